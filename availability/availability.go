@@ -0,0 +1,190 @@
+// Package availability is the central degradation policy. Handlers ask it what
+// may be served instead of checking dependencies themselves; the ladder is:
+//
+//	normal           everything is served
+//	quotas_disabled  usage storage is failing: spend caps are not enforced, requests keep flowing
+//	cached_token     the solver is failing: chat is served with the last AntiBot token while it is younger than MaxTokenAge
+//	chat_unavailable no usable token: chat returns 503, /v1/models and admin endpoints keep working
+//
+// A stage implies the restrictions of the stages below it that currently apply;
+// quotas are disabled whenever storage is failing, whatever the token state.
+package availability
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"cursor2api/config"
+	"cursor2api/logger"
+	"cursor2api/metrics"
+	"cursor2api/types"
+)
+
+// Stage is a rung of the degradation ladder, ordered by severity
+type Stage int
+
+const (
+	StageNormal Stage = iota
+	StageQuotasDisabled
+	StageCachedToken
+	StageChatUnavailable
+)
+
+var stageNames = [...]string{"normal", "quotas_disabled", "cached_token", "chat_unavailable"}
+
+func (s Stage) String() string {
+	if s < 0 || int(s) >= len(stageNames) {
+		return "unknown"
+	}
+	return stageNames[s]
+}
+
+// ErrChatUnavailable is returned by ChatAllowed when no usable AntiBot token exists
+var ErrChatUnavailable = errors.New("chat is temporarily unavailable: no valid upstream token")
+
+// TokenSource reports the state of the AntiBot token (implemented by models.AntiBotManager)
+type TokenSource interface {
+	TokenState() (age time.Duration, ok bool, refreshErr error)
+}
+
+// StorageSource reports the last error of persistent storage (nil when healthy)
+type StorageSource func() error
+
+var (
+	stageGauge = metrics.NewGauge(
+		"cursor2api_availability_stage",
+		"Current degradation stage: 0 normal, 1 quotas_disabled, 2 cached_token, 3 chat_unavailable.")
+
+	transitions = metrics.NewCounter(
+		"cursor2api_availability_transitions_total",
+		"Degradation stage changes, by the stage entered.",
+		"stage")
+)
+
+// Manager evaluates the degradation ladder from its sources on every call
+type Manager struct {
+	maxTokenAge time.Duration
+	tokens      TokenSource   // nil in mock mode: chat never depends on a token
+	storage     StorageSource // nil when nothing is persisted
+
+	mu    sync.Mutex
+	stage Stage
+	since time.Time
+}
+
+// New creates the availability manager; tokens and storage may be nil
+func New(cfg config.AvailabilityConfig, tokens TokenSource, storage StorageSource) *Manager {
+	stageGauge.Set(float64(StageNormal))
+	return &Manager{
+		maxTokenAge: cfg.MaxTokenAge,
+		tokens:      tokens,
+		storage:     storage,
+		since:       time.Now(),
+	}
+}
+
+// ServeStaleToken implements models.StaleTokenPolicy: while the solver is failing,
+// tokens younger than MaxTokenAge keep being served
+func (m *Manager) ServeStaleToken(age time.Duration) bool {
+	return m.maxTokenAge > 0 && age <= m.maxTokenAge
+}
+
+// ChatAllowed returns ErrChatUnavailable when chat completions cannot be served
+func (m *Manager) ChatAllowed() error {
+	if m.evaluate().stage == StageChatUnavailable {
+		return ErrChatUnavailable
+	}
+	return nil
+}
+
+// QuotasEnforced reports whether spend caps are enforced; they are lifted while
+// storage is failing so a broken disk never blocks traffic
+func (m *Manager) QuotasEnforced() bool {
+	return m.evaluate().storageErr == nil
+}
+
+// Status returns the current stage and the reasons behind it, served at /health
+func (m *Manager) Status() *types.AvailabilityStatus {
+	e := m.evaluate()
+	status := &types.AvailabilityStatus{
+		Stage:          e.stage.String(),
+		Since:          e.since,
+		ChatAvailable:  e.stage != StageChatUnavailable,
+		QuotasEnforced: e.storageErr == nil,
+	}
+	if e.hasToken {
+		status.TokenAge = e.tokenAge.Round(time.Second).String()
+	}
+	if e.solverErr != nil {
+		status.SolverError = e.solverErr.Error()
+	}
+	if e.storageErr != nil {
+		status.StorageError = e.storageErr.Error()
+	}
+	return status
+}
+
+// evaluation is the outcome of one pass over the sources
+type evaluation struct {
+	stage      Stage
+	since      time.Time
+	hasToken   bool
+	tokenAge   time.Duration
+	solverErr  error
+	storageErr error
+}
+
+// evaluate derives the stage from the sources and records transitions
+func (m *Manager) evaluate() evaluation {
+	var e evaluation
+	if m.storage != nil {
+		e.storageErr = m.storage()
+	}
+	if m.tokens != nil {
+		e.tokenAge, e.hasToken, e.solverErr = m.tokens.TokenState()
+	}
+
+	switch {
+	case m.tokens != nil && !e.hasToken:
+		e.stage = StageChatUnavailable
+	case e.solverErr != nil && m.ServeStaleToken(e.tokenAge):
+		e.stage = StageCachedToken
+	case e.solverErr != nil:
+		e.stage = StageChatUnavailable
+	case e.storageErr != nil:
+		e.stage = StageQuotasDisabled
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e.stage != m.stage {
+		m.transition(e)
+	}
+	e.since = m.since
+	return e
+}
+
+// transition logs and records a stage change (caller holds m.mu)
+func (m *Manager) transition(e evaluation) {
+	reason := "recovered"
+	switch e.stage {
+	case StageQuotasDisabled:
+		reason = "storage failing, spend caps lifted: " + e.storageErr.Error()
+	case StageCachedToken:
+		reason = "solver failing, serving cached token: " + e.solverErr.Error()
+	case StageChatUnavailable:
+		reason = "no usable token, chat returns 503"
+		if e.solverErr != nil {
+			reason += ": " + e.solverErr.Error()
+		}
+	}
+	if e.stage > m.stage {
+		logger.Warn("Availability degraded | from=%s to=%s reason=%s", m.stage, e.stage, reason)
+	} else {
+		logger.Info("Availability improved | from=%s to=%s reason=%s", m.stage, e.stage, reason)
+	}
+	m.stage, m.since = e.stage, time.Now()
+	stageGauge.Set(float64(e.stage))
+	transitions.Inc(e.stage.String())
+}
@@ -0,0 +1,67 @@
+package availability
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"cursor2api/config"
+)
+
+type fakeTokens struct {
+	age time.Duration
+	ok  bool
+	err error
+}
+
+func (f *fakeTokens) TokenState() (time.Duration, bool, error) { return f.age, f.ok, f.err }
+
+func TestLadder(t *testing.T) {
+	tokens := &fakeTokens{age: 10 * time.Second, ok: true}
+	var storageErr error
+	m := New(config.AvailabilityConfig{MaxTokenAge: time.Minute}, tokens, func() error { return storageErr })
+
+	steps := []struct {
+		name    string
+		apply   func()
+		stage   string
+		chat    bool
+		enforce bool
+	}{
+		{"healthy", func() {}, "normal", true, true},
+		{"storage down", func() { storageErr = errors.New("disk full") }, "quotas_disabled", true, false},
+		{"solver down", func() { tokens.err = errors.New("solver timeout") }, "cached_token", true, false},
+		{"token too old", func() { tokens.age = 2 * time.Minute }, "chat_unavailable", false, false},
+		{"storage back", func() { storageErr = nil }, "chat_unavailable", false, true},
+		{"solver back", func() { tokens.age, tokens.err = 0, nil }, "normal", true, true},
+		{"no token yet", func() { tokens.ok = false }, "chat_unavailable", false, true},
+	}
+	for _, step := range steps {
+		step.apply()
+		status := m.Status()
+		if status.Stage != step.stage || status.ChatAvailable != step.chat || status.QuotasEnforced != step.enforce {
+			t.Errorf("%s: status = %+v", step.name, status)
+		}
+		if err := m.ChatAllowed(); (err == nil) != step.chat {
+			t.Errorf("%s: ChatAllowed() = %v", step.name, err)
+		}
+		if m.QuotasEnforced() != step.enforce {
+			t.Errorf("%s: QuotasEnforced() = %v", step.name, !step.enforce)
+		}
+	}
+}
+
+func TestServeStaleToken(t *testing.T) {
+	m := New(config.AvailabilityConfig{MaxTokenAge: time.Minute}, nil, nil)
+	if !m.ServeStaleToken(30*time.Second) || m.ServeStaleToken(2*time.Minute) {
+		t.Error("tokens must be served up to MaxTokenAge")
+	}
+	if New(config.AvailabilityConfig{}, nil, nil).ServeStaleToken(time.Second) {
+		t.Error("MaxTokenAge 0 must never serve stale tokens")
+	}
+
+	// Without a token source (mock mode) chat never degrades
+	if err := m.ChatAllowed(); err != nil || m.Status().Stage != "normal" {
+		t.Errorf("mock mode: ChatAllowed() = %v, status = %+v", err, m.Status())
+	}
+}
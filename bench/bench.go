@@ -0,0 +1,285 @@
+// Package bench implements the `cursor2api bench` load test subcommand.
+// It drives synthetic chat completion requests against a running instance
+// (real upstream or MOCK_MODE) and reports throughput, TTFT and latency percentiles.
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Options holds the bench command line options
+type Options struct {
+	Target      string
+	APIKey      string
+	Model       string
+	Prompt      string
+	Concurrency int
+	Duration    time.Duration
+	MaxRequests int
+	Stream      bool
+	Timeout     time.Duration
+}
+
+// result is the outcome of a single request
+type result struct {
+	status  int
+	err     error
+	latency time.Duration
+	ttft    time.Duration // zero for non-stream requests
+}
+
+// Run parses args, executes the load test and prints a report; returns the process exit code
+func Run(args []string) int {
+	opts, err := parseFlags(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 2
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	fmt.Printf("🏁 Benchmarking %s\n", opts.Target)
+	fmt.Printf("   ├─ Model: %s (stream: %v)\n", opts.Model, opts.Stream)
+	fmt.Printf("   ├─ Concurrency: %d\n", opts.Concurrency)
+	if opts.MaxRequests > 0 {
+		fmt.Printf("   └─ Duration: %s (max %d requests)\n", opts.Duration, opts.MaxRequests)
+	} else {
+		fmt.Printf("   └─ Duration: %s\n", opts.Duration)
+	}
+
+	results, elapsed := execute(ctx, opts)
+	printReport(os.Stdout, results, elapsed)
+
+	for _, r := range results {
+		if r.err == nil && r.status == http.StatusOK {
+			return 0
+		}
+	}
+	return 1
+}
+
+// parseFlags parses and validates the bench flags
+func parseFlags(args []string) (Options, error) {
+	var opts Options
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.StringVar(&opts.Target, "target", "http://localhost:5680", "Base URL of the cursor2api instance")
+	fs.StringVar(&opts.APIKey, "key", os.Getenv("BENCH_API_KEY"), "API key sent as Bearer token (default $BENCH_API_KEY)")
+	fs.StringVar(&opts.Model, "model", "anthropic/claude-4.5-sonnet", "Model to request")
+	fs.StringVar(&opts.Prompt, "prompt", "Reply with a single short sentence.", "User prompt sent with each request")
+	fs.IntVar(&opts.Concurrency, "concurrency", 10, "Number of concurrent workers")
+	fs.DurationVar(&opts.Duration, "duration", 30*time.Second, "Test duration")
+	fs.IntVar(&opts.MaxRequests, "requests", 0, "Stop after this many requests (0 = unlimited)")
+	fs.BoolVar(&opts.Stream, "stream", true, "Use streaming requests (required for TTFT)")
+	fs.DurationVar(&opts.Timeout, "timeout", 2*time.Minute, "Per-request timeout")
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+
+	opts.Target = strings.TrimRight(opts.Target, "/")
+	if opts.Concurrency < 1 {
+		return opts, fmt.Errorf("concurrency must be >= 1")
+	}
+	if opts.Duration <= 0 && opts.MaxRequests <= 0 {
+		return opts, fmt.Errorf("either duration or requests must be positive")
+	}
+	return opts, nil
+}
+
+// execute runs the workers until the duration elapses or the request budget is exhausted
+func execute(ctx context.Context, opts Options) ([]result, time.Duration) {
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+
+	client := &http.Client{Timeout: opts.Timeout}
+	body := buildBody(opts)
+
+	var (
+		mu      sync.Mutex
+		results []result
+		issued  int
+		wg      sync.WaitGroup
+	)
+
+	// next reserves a request slot, returning false when the budget is exhausted
+	next := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if opts.MaxRequests > 0 && issued >= opts.MaxRequests {
+			return false
+		}
+		issued++
+		return true
+	}
+
+	start := time.Now()
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil && next() {
+				r := doRequest(ctx, client, opts, body)
+				// Requests interrupted by the end of the test are not counted
+				if ctx.Err() != nil && r.err != nil {
+					return
+				}
+				mu.Lock()
+				results = append(results, r)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return results, time.Since(start)
+}
+
+// buildBody creates the JSON request body shared by all requests
+func buildBody(opts Options) []byte {
+	payload := map[string]interface{}{
+		"model":  opts.Model,
+		"stream": opts.Stream,
+		"messages": []map[string]string{
+			{"role": "user", "content": opts.Prompt},
+		},
+	}
+	data, _ := json.Marshal(payload)
+	return data
+}
+
+// doRequest sends one chat completion request and measures latency and TTFT
+func doRequest(ctx context.Context, client *http.Client, opts Options, body []byte) result {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, opts.Target+"/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return result{err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if opts.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.APIKey)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return result{err: err, latency: time.Since(start)}
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	r := result{status: resp.StatusCode}
+	if resp.StatusCode != http.StatusOK || !opts.Stream {
+		_, err = io.Copy(io.Discard, resp.Body)
+		r.err = err
+		r.latency = time.Since(start)
+		return r
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if r.ttft == 0 && data != "[DONE]" {
+			r.ttft = time.Since(start)
+		}
+		if strings.Contains(data, `"error"`) && !strings.Contains(data, `"choices"`) {
+			r.err = fmt.Errorf("stream error: %s", data)
+		}
+	}
+	if err := scanner.Err(); err != nil && r.err == nil {
+		r.err = err
+	}
+	r.latency = time.Since(start)
+	return r
+}
+
+// printReport writes the aggregated results
+func printReport(w io.Writer, results []result, elapsed time.Duration) {
+	var (
+		success   int
+		latencies []time.Duration
+		ttfts     []time.Duration
+		statuses  = make(map[string]int)
+	)
+	for _, r := range results {
+		switch {
+		case r.err != nil && r.status == 0:
+			statuses["transport_error"]++
+		case r.err != nil:
+			statuses[fmt.Sprintf("%d (stream error)", r.status)]++
+		default:
+			statuses[fmt.Sprintf("%d", r.status)]++
+		}
+		if r.err == nil && r.status == http.StatusOK {
+			success++
+			latencies = append(latencies, r.latency)
+			if r.ttft > 0 {
+				ttfts = append(ttfts, r.ttft)
+			}
+		}
+	}
+
+	fmt.Fprintln(w, "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Fprintf(w, "📊 Results (%s elapsed)\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "   ├─ Requests: %d (success: %d, failed: %d)\n", len(results), success, len(results)-success)
+	if elapsed > 0 {
+		fmt.Fprintf(w, "   ├─ Throughput: %.2f req/s (successful: %.2f req/s)\n",
+			float64(len(results))/elapsed.Seconds(), float64(success)/elapsed.Seconds())
+	}
+
+	codes := make([]string, 0, len(statuses))
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "   ├─ Status %s: %d\n", code, statuses[code])
+	}
+
+	printPercentiles(w, "├─", "Latency", latencies)
+	printPercentiles(w, "└─", "TTFT", ttfts)
+}
+
+// printPercentiles prints p50/p90/p99/max for a set of durations
+func printPercentiles(w io.Writer, branch, name string, values []time.Duration) {
+	if len(values) == 0 {
+		fmt.Fprintf(w, "   %s %s: n/a\n", branch, name)
+		return
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	fmt.Fprintf(w, "   %s %s: p50=%s p90=%s p99=%s max=%s\n", branch, name,
+		percentile(values, 0.50), percentile(values, 0.90), percentile(values, 0.99),
+		values[len(values)-1].Round(time.Millisecond))
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	idx := int(float64(len(sorted))*p+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx].Round(time.Millisecond)
+}
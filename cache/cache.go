@@ -0,0 +1,126 @@
+// Package cache is the key/value store shared by caching features. Each use case
+// (e.g. "dedupe") opens its own namespace on the backend selected for it by
+// CACHE_BACKENDS: memory (per instance), redis (shared by replicas) or disk
+// (survives restarts). Every backend expires entries after their TTL and is
+// instrumented with the same metrics, labelled by use case and backend.
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cursor2api/config"
+	"cursor2api/metrics"
+	"cursor2api/redis"
+)
+
+// Backend names accepted in CACHE_BACKENDS
+const (
+	Memory = "memory"
+	Redis  = "redis"
+	Disk   = "disk"
+)
+
+// Cache stores byte values by key. A ttl of zero or less keeps the entry until
+// it is deleted or evicted. Get reports a miss, not an error, for expired entries.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	Close() error
+}
+
+var (
+	lookups = metrics.NewCounter(
+		"cursor2api_cache_lookups_total",
+		"Cache lookups by use case, backend and result (hit, miss or error).",
+		"cache", "backend", "result")
+
+	writes = metrics.NewCounter(
+		"cursor2api_cache_writes_total",
+		"Cache writes and deletes by use case, backend and result (ok or error).",
+		"cache", "backend", "result")
+
+	latency = metrics.NewHistogram(
+		"cursor2api_cache_operation_duration_seconds",
+		"Latency of cache operations by use case, backend and operation.",
+		[]float64{.0001, .0005, .001, .005, .01, .05, .1, .5},
+		"cache", "backend", "op")
+)
+
+// Open opens the cache of a use case on the backend configured for it (memory
+// by default); redis entries are prefixed and disk entries kept in a directory
+// named after the use case
+func Open(use string, cfg config.CacheConfig) (Cache, error) {
+	backend := cfg.Backends[use]
+	if backend == "" {
+		backend = Memory
+	}
+
+	var c Cache
+	switch backend {
+	case Memory:
+		c = NewMemory(cfg.MaxEntries)
+	case Redis:
+		client, err := redis.New(cfg.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		c = NewRedis(client, "cursor2api:cache:"+use+":")
+	case Disk:
+		d, err := NewDisk(cfg.Dir + "/" + use)
+		if err != nil {
+			return nil, err
+		}
+		c = d
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q for %s", backend, use)
+	}
+	return &instrumented{Cache: c, use: use, backend: backend}, nil
+}
+
+// instrumented records metrics around the operations of a backend
+type instrumented struct {
+	Cache
+	use     string
+	backend string
+}
+
+func (c *instrumented) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	start := time.Now()
+	value, ok, err := c.Cache.Get(ctx, key)
+	latency.Observe(time.Since(start).Seconds(), c.use, c.backend, "get")
+	switch {
+	case err != nil:
+		lookups.Inc(c.use, c.backend, "error")
+	case ok:
+		lookups.Inc(c.use, c.backend, "hit")
+	default:
+		lookups.Inc(c.use, c.backend, "miss")
+	}
+	return value, ok, err
+}
+
+func (c *instrumented) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	start := time.Now()
+	err := c.Cache.Set(ctx, key, value, ttl)
+	latency.Observe(time.Since(start).Seconds(), c.use, c.backend, "set")
+	writes.Inc(c.use, c.backend, result(err))
+	return err
+}
+
+func (c *instrumented) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := c.Cache.Delete(ctx, key)
+	latency.Observe(time.Since(start).Seconds(), c.use, c.backend, "delete")
+	writes.Inc(c.use, c.backend, result(err))
+	return err
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
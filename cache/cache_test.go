@@ -0,0 +1,232 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cursor2api/clock"
+	"cursor2api/config"
+	"cursor2api/redis"
+)
+
+// testBackend checks the semantics every backend shares
+func testBackend(t *testing.T, c Cache) {
+	t.Helper()
+	ctx := context.Background()
+	if _, ok, err := c.Get(ctx, "missing"); ok || err != nil {
+		t.Fatalf("Get(missing) = %v, %v", ok, err)
+	}
+	if err := c.Set(ctx, "k", []byte("v1"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(ctx, "k", []byte("v2"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, err := c.Get(ctx, "k"); !ok || err != nil || string(value) != "v2" {
+		t.Errorf("Get(k) = %q, %v, %v", value, ok, err)
+	}
+	if err := c.Delete(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := c.Get(ctx, "k"); ok {
+		t.Error("deleted entry is still served")
+	}
+	if err := c.Delete(ctx, "k"); err != nil {
+		t.Errorf("deleting a missing entry: %v", err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	c := NewMemory(2)
+	defer c.Close()
+	fake := clock.NewFake(time.Now())
+	c.clock = fake
+	testBackend(t, c)
+
+	ctx := context.Background()
+	_ = c.Set(ctx, "expired", []byte("x"), time.Second)
+	fake.Advance(time.Second - 1)
+	if _, ok, _ := c.Get(ctx, "expired"); !ok {
+		t.Error("entry expired before its TTL")
+	}
+	fake.Advance(1)
+	if _, ok, _ := c.Get(ctx, "expired"); ok {
+		t.Error("expired entry is still served")
+	}
+
+	// When full, the entry closest to expiry makes room
+	_ = c.Set(ctx, "forever", []byte("x"), 0)
+	_ = c.Set(ctx, "soon", []byte("x"), time.Minute)
+	_ = c.Set(ctx, "later", []byte("x"), time.Hour)
+	if _, ok, _ := c.Get(ctx, "soon"); ok {
+		t.Error("the entry closest to expiry should have been evicted")
+	}
+	for _, key := range []string{"forever", "later"} {
+		if _, ok, _ := c.Get(ctx, key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+}
+
+func TestDisk(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fake := clock.NewFake(time.Now())
+	c.clock = fake
+	testBackend(t, c)
+
+	ctx := context.Background()
+	_ = c.Set(ctx, "kept", []byte("x"), time.Hour)
+	_ = c.Set(ctx, "expired", []byte("x"), time.Second)
+	fake.Advance(time.Second)
+	if _, ok, _ := c.Get(ctx, "expired"); ok {
+		t.Error("expired entry is still served")
+	}
+
+	// Entries survive a restart; the sweeper removes expired files
+	_ = c.Set(ctx, "expired", []byte("x"), time.Second)
+	fake.Advance(time.Second)
+	reopened, err := NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if value, ok, _ := reopened.Get(ctx, "kept"); !ok || string(value) != "x" {
+		t.Errorf("Get(kept) after reopening = %q, %v", value, ok)
+	}
+	reopened.sweep(fake.Now())
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 1 {
+		t.Errorf("files after sweep = %v, want only the kept entry", files)
+	}
+}
+
+func TestRedis(t *testing.T) {
+	addr, commands := fakeRedis(t)
+	client, err := redis.New("redis://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewRedis(client, "cursor2api:cache:test:")
+	defer c.Close()
+	testBackend(t, c)
+
+	if got := strings.Join(*commands, "\n"); !strings.Contains(got, "SET cursor2api:cache:test:k v1 PX 60000") {
+		t.Errorf("commands = %s", got)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	cfg := config.CacheConfig{
+		Backends: map[string]string{"files": Disk, "bogus": "memcached"},
+		Dir:      t.TempDir(),
+	}
+	c, err := Open("files", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Set(context.Background(), "k", []byte("v"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Dir, "files")); err != nil {
+		t.Errorf("disk cache directory: %v", err)
+	}
+
+	memory, err := Open("unlisted", cfg)
+	if err != nil || memory.(*instrumented).backend != Memory {
+		t.Errorf("unlisted use case = %v, %v; want memory", memory, err)
+	}
+	if _, err := Open("bogus", cfg); err == nil {
+		t.Error("unknown backend should be rejected")
+	}
+}
+
+// fakeRedis serves GET, SET (with PX) and DEL from memory and records the commands
+func fakeRedis(t *testing.T) (string, *[]string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	var mu sync.Mutex
+	var commands []string
+	data := map[string]string{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rd := bufio.NewReader(conn)
+				for {
+					args, err := readCommand(rd)
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					commands = append(commands, strings.Join(args, " "))
+					var out string
+					switch args[0] {
+					case "SET":
+						data[args[1]] = args[2]
+						out = "+OK\r\n"
+					case "GET":
+						if v, ok := data[args[1]]; ok {
+							out = "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+						} else {
+							out = "$-1\r\n"
+						}
+					case "DEL":
+						delete(data, args[1])
+						out = ":1\r\n"
+					default:
+						out = "-ERR unknown command\r\n"
+					}
+					mu.Unlock()
+					if _, err := conn.Write([]byte(out)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), &commands
+}
+
+// readCommand reads one RESP array of bulk strings
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		if _, err := rd.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"cursor2api/clock"
+	"cursor2api/logger"
+)
+
+// DiskCache keeps one file per entry in a directory, so entries survive restarts.
+// A file holds the expiry (Unix nanoseconds, big endian, 0 = none) followed by
+// the value; file names are hashes of the keys.
+type DiskCache struct {
+	dir   string
+	clock clock.Clock // Time of entry expiry
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+// headerSize is the length of the expiry prefix of a cache file
+const headerSize = 8
+
+// NewDisk creates a cache in dir, creating the directory if needed
+func NewDisk(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	c := &DiskCache{dir: dir, clock: clock.System, stopChan: make(chan struct{})}
+	go c.sweepLoop()
+	return c, nil
+}
+
+func (c *DiskCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	path := c.path(key)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data) < headerSize {
+		_ = os.Remove(path)
+		return nil, false, fmt.Errorf("corrupt cache file %s", filepath.Base(path))
+	}
+	if expired(data, c.clock.Now()) {
+		_ = os.Remove(path)
+		return nil, false, nil
+	}
+	return data[headerSize:], true, nil
+}
+
+func (c *DiskCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	data := make([]byte, headerSize+len(value))
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(data, uint64(c.clock.Now().Add(ttl).UnixNano()))
+	}
+	copy(data[headerSize:], value)
+
+	// Write to a temporary file first so readers never see a partial entry
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (c *DiskCache) Delete(_ context.Context, key string) error {
+	if err := os.Remove(c.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Close stops the sweeper; the files are kept
+func (c *DiskCache) Close() error {
+	c.stopOnce.Do(func() { close(c.stopChan) })
+	return nil
+}
+
+// path returns the file of a key
+func (c *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// expired reports whether the expiry header of a cache file is in the past
+func expired(data []byte, now time.Time) bool {
+	expires := int64(binary.BigEndian.Uint64(data))
+	return expires != 0 && now.UnixNano() >= expires
+}
+
+func (c *DiskCache) sweepLoop() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.sweep(c.clock.Now())
+		case <-c.stopChan:
+			return
+		}
+	}
+}
+
+// sweep removes expired entries, reading only their headers, and abandoned temporary files
+func (c *DiskCache) sweep(now time.Time) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		logger.Warn("Failed to sweep disk cache | dir=%s error=%v", c.dir, err)
+		return
+	}
+	header := make([]byte, headerSize)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(c.dir, entry.Name())
+		if strings.HasPrefix(entry.Name(), ".tmp-") {
+			// Left behind by a crash during Set
+			if info, err := entry.Info(); err == nil && now.Sub(info.ModTime()) > time.Hour {
+				_ = os.Remove(path)
+			}
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		_, err = f.ReadAt(header, 0)
+		f.Close()
+		if err == nil && expired(header, now) {
+			_ = os.Remove(path)
+		}
+	}
+}
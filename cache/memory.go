@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"cursor2api/clock"
+)
+
+// sweepInterval is how often expired entries are dropped from memory and disk
+const sweepInterval = time.Minute
+
+// MemoryCache keeps entries in a map of this instance
+type MemoryCache struct {
+	maxEntries int
+	clock      clock.Clock // Time of entry expiry
+
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time // zero = no expiry
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// NewMemory creates an in-memory cache holding at most maxEntries entries
+// (0 = unlimited); when full, the entry closest to expiry is evicted
+func NewMemory(maxEntries int) *MemoryCache {
+	c := &MemoryCache{
+		maxEntries: maxEntries,
+		clock:      clock.System,
+		entries:    make(map[string]memoryEntry),
+		stopChan:   make(chan struct{}),
+	}
+	go c.sweepLoop()
+	return c
+}
+
+func (c *MemoryCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if entry.expired(c.clock.Now()) {
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+	return entry.value, true, nil
+}
+
+func (c *MemoryCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	entry := memoryEntry{value: value}
+	if ttl > 0 {
+		entry.expires = c.clock.Now().Add(ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		c.evictLocked()
+	}
+	c.entries[key] = entry
+	return nil
+}
+
+func (c *MemoryCache) Delete(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+	return nil
+}
+
+// Close stops the sweeper
+func (c *MemoryCache) Close() error {
+	c.stopOnce.Do(func() { close(c.stopChan) })
+	return nil
+}
+
+// evictLocked drops expired entries, or else the entry closest to expiry
+// (entries without expiry last) to make room (caller holds c.mu)
+func (c *MemoryCache) evictLocked() {
+	c.sweepLocked(c.clock.Now())
+	if len(c.entries) < c.maxEntries {
+		return
+	}
+	var victim string
+	var victimExpires time.Time
+	found := false
+	for key, entry := range c.entries {
+		if !found || (!entry.expires.IsZero() && (victimExpires.IsZero() || entry.expires.Before(victimExpires))) {
+			victim, victimExpires, found = key, entry.expires, true
+		}
+	}
+	delete(c.entries, victim)
+}
+
+func (c *MemoryCache) sweepLoop() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.sweep()
+		case <-c.stopChan:
+			return
+		}
+	}
+}
+
+// sweep drops expired entries
+func (c *MemoryCache) sweep() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweepLocked(c.clock.Now())
+}
+
+func (c *MemoryCache) sweepLocked(now time.Time) {
+	for key, entry := range c.entries {
+		if entry.expired(now) {
+			delete(c.entries, key)
+		}
+	}
+}
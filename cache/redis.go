@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"cursor2api/redis"
+)
+
+// RedisCache keeps entries in Redis, shared by every replica using the same prefix
+type RedisCache struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedis creates a cache storing keys under prefix
+func NewRedis(client *redis.Client, prefix string) *RedisCache {
+	return &RedisCache{client: client, prefix: prefix}
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := c.client.Do(ctx, "GET", c.prefix+key)
+	if err != nil {
+		return nil, false, err
+	}
+	switch value := reply.(type) {
+	case nil:
+		return nil, false, nil
+	case []byte:
+		return value, true, nil
+	default:
+		return nil, false, fmt.Errorf("unexpected GET reply %T", reply)
+	}
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", c.prefix + key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	}
+	_, err := c.client.Do(ctx, args...)
+	return err
+}
+
+func (c *RedisCache) Delete(ctx context.Context, key string) error {
+	_, err := c.client.Do(ctx, "DEL", c.prefix+key)
+	return err
+}
+
+// Close closes the Redis connection
+func (c *RedisCache) Close() error {
+	return c.client.Close()
+}
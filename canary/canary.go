@@ -0,0 +1,113 @@
+// Package canary routes a percentage of requests to a variant configuration
+// (system prompt, upstream profile) and records per-variant metrics so the
+// variant can be compared with the current configuration before promoting it.
+package canary
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand/v2"
+	"time"
+
+	"cursor2api/config"
+	"cursor2api/logger"
+	"cursor2api/metrics"
+)
+
+// Control is the variant name of requests served by the regular configuration
+const Control = "control"
+
+var (
+	variantRequests = metrics.NewCounter(
+		"cursor2api_variant_requests_total",
+		"Chat completions by configuration variant and result (ok or error).",
+		"variant", "result")
+
+	variantDuration = metrics.NewHistogram(
+		"cursor2api_variant_request_duration_seconds",
+		"End-to-end chat completion latency by configuration variant.",
+		[]float64{0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120}, "variant")
+
+	variantCompletionTokens = metrics.NewCounter(
+		"cursor2api_variant_completion_tokens_total",
+		"Completion tokens produced by configuration variant.",
+		"variant")
+)
+
+// Rollout assigns requests to the control or canary variant
+type Rollout struct {
+	name    string
+	percent float64
+}
+
+// New creates a rollout; returns nil when no traffic is sent to the canary
+func New(cfg config.CanaryConfig) *Rollout {
+	if cfg.Percent <= 0 {
+		return nil
+	}
+	r := &Rollout{name: cfg.Name, percent: min(cfg.Percent, 100)}
+	if r.name == "" || r.name == Control {
+		r.name = "canary"
+	}
+	logger.Info("Canary rollout enabled | variant=%s percent=%.1f system_prompt=%v chat_url=%s extra_headers=%d",
+		r.name, r.percent, cfg.SystemPrompt != "", cfg.ChatURL, len(cfg.ExtraHeaders))
+	return r
+}
+
+// Assign picks the variant for a request. Requests with the same key (e.g. a
+// conversation ID) always get the same variant; an empty key is assigned randomly.
+func (r *Rollout) Assign(key string) string {
+	if r == nil {
+		return Control
+	}
+
+	var bucket float64
+	if key == "" {
+		bucket = rand.Float64() * 100
+	} else {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(key))
+		bucket = float64(h.Sum32()%10000) / 100
+	}
+	if bucket < r.percent {
+		return r.name
+	}
+	return Control
+}
+
+// variantContextKey is the private context key for the assigned variant
+type variantContextKey struct{}
+
+// WithVariant returns a context carrying the assigned variant
+func WithVariant(ctx context.Context, variant string) context.Context {
+	return context.WithValue(ctx, variantContextKey{}, variant)
+}
+
+// VariantFromContext returns the assigned variant, or Control when none was assigned
+func VariantFromContext(ctx context.Context) string {
+	if variant, ok := ctx.Value(variantContextKey{}).(string); ok {
+		return variant
+	}
+	return Control
+}
+
+// IsCanary reports whether the request was assigned to the canary variant
+func IsCanary(ctx context.Context) bool {
+	return VariantFromContext(ctx) != Control
+}
+
+// Observe records the result of a completed request for its variant
+func Observe(ctx context.Context, start time.Time, completionTokens int, err error) {
+	variant := VariantFromContext(ctx)
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	variantRequests.Inc(variant, result)
+	if !start.IsZero() {
+		variantDuration.Observe(time.Since(start).Seconds(), variant)
+	}
+	if completionTokens > 0 {
+		variantCompletionTokens.Add(float64(completionTokens), variant)
+	}
+}
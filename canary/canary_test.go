@@ -0,0 +1,51 @@
+package canary
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"cursor2api/config"
+)
+
+func TestRollout_AssignIsSticky(t *testing.T) {
+	r := New(config.CanaryConfig{Name: "prompt-v2", Percent: 50})
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("conv-%d", i)
+		first := r.Assign(key)
+		for j := 0; j < 5; j++ {
+			if got := r.Assign(key); got != first {
+				t.Fatalf("%s moved from %s to %s", key, first, got)
+			}
+		}
+	}
+}
+
+func TestRollout_AssignPercent(t *testing.T) {
+	r := New(config.CanaryConfig{Name: "prompt-v2", Percent: 20})
+
+	canary := 0
+	const n = 10000
+	for i := 0; i < n; i++ {
+		if r.Assign(fmt.Sprintf("conv-%d", i)) == "prompt-v2" {
+			canary++
+		}
+	}
+	if share := float64(canary) / n * 100; share < 17 || share > 23 {
+		t.Fatalf("canary share = %.1f%%, want about 20%%", share)
+	}
+}
+
+func TestRollout_Disabled(t *testing.T) {
+	r := New(config.CanaryConfig{Name: "prompt-v2"})
+	if r != nil {
+		t.Fatal("rollout with 0 percent should be nil")
+	}
+	if got := r.Assign("conv-1"); got != Control {
+		t.Fatalf("nil rollout assigned %s", got)
+	}
+	if IsCanary(context.Background()) {
+		t.Fatal("context without a variant must be control")
+	}
+}
@@ -0,0 +1,306 @@
+// Package catalog holds the model list served at /v1/models and the alias map
+// applied to chat requests. The initial catalog comes from MODEL_CATALOG (inline
+// JSON) or the built-in list; both can be replaced at runtime through
+// PUT /admin/models and are persisted to a JSON file so new upstream models can
+// be served without a redeploy. Chat requests for models outside the catalog are
+// rejected.
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"cursor2api/logger"
+)
+
+// ErrInvalid is returned by Replace when the new catalog fails validation
+var ErrInvalid = errors.New("invalid model catalog")
+
+// DefaultOwner is the owned_by of models that do not name one
+const DefaultOwner = "cursor"
+
+// Model is one model of the catalog
+type Model struct {
+	ID      string `json:"id"`
+	OwnedBy string `json:"owned_by,omitempty"` // Defaults to DefaultOwner
+
+	// Capabilities published at /v1/models
+	ContextWindow int  `json:"context_window,omitempty"` // Context length in tokens (0 = unknown)
+	SupportsTools bool `json:"supports_tools,omitempty"`
+	Vision        bool `json:"vision,omitempty"` // Accepts image inputs
+
+	// Deprecated models are still served, with a Warning header on every response
+	Deprecated bool   `json:"deprecated,omitempty"`
+	ReplacedBy string `json:"replaced_by,omitempty"` // Model clients should migrate to
+	Redirect   bool   `json:"redirect,omitempty"`    // Serve ReplacedBy instead of the deprecated model
+}
+
+// Deprecation describes how requests for a deprecated model are served
+type Deprecation struct {
+	ReplacedBy string // Empty when no replacement is named
+	Redirect   bool   // Requests are served by ReplacedBy
+}
+
+// Snapshot is the served model list and the alias map: alias -> model ID
+type Snapshot struct {
+	Models    []Model           `json:"models"`
+	Aliases   map[string]string `json:"aliases,omitempty"`
+	UpdatedAt *time.Time        `json:"updated_at,omitempty"` // Last replacement through the admin API (nil = built-in list)
+}
+
+// DefaultModels is the catalog used until one is set through the admin API
+var DefaultModels = []Model{
+	{ID: "anthropic/claude-4.5-sonnet", ContextWindow: 200_000, SupportsTools: true, Vision: true},
+	{ID: "anthropic/claude-4-sonnet", ContextWindow: 200_000, SupportsTools: true, Vision: true},
+	{ID: "anthropic/claude-opus-4.1", ContextWindow: 200_000, SupportsTools: true, Vision: true},
+	{ID: "openai/gpt-5", ContextWindow: 400_000, SupportsTools: true, Vision: true},
+	{ID: "google/gemini-2.5-pro", ContextWindow: 1_048_576, SupportsTools: true, Vision: true},
+	{ID: "xai/grok-4", ContextWindow: 256_000, SupportsTools: true, Vision: true},
+}
+
+// Catalog holds the current snapshot, persisted to a JSON file
+type Catalog struct {
+	mu   sync.RWMutex
+	snap Snapshot
+	path string
+}
+
+// New creates the catalog persisted to path (empty = in memory only). The catalog
+// file, written by PUT /admin/models, takes precedence over inline, a JSON catalog
+// of the same shape from MODEL_CATALOG; DefaultModels is served when neither is
+// set. aliases (MODEL_ALIASES) are added to the inline or built-in catalog,
+// replacing its aliases of the same name. A source that cannot be read or is
+// invalid is ignored with a warning.
+func New(path, inline string, aliases map[string]string) *Catalog {
+	c := &Catalog{snap: Snapshot{Models: slices.Clone(DefaultModels)}, path: path}
+	if inline != "" {
+		var snap Snapshot
+		err := json.Unmarshal([]byte(inline), &snap)
+		if err == nil {
+			snap, err = normalize(snap)
+		}
+		if err != nil {
+			logger.Warn("Invalid MODEL_CATALOG, serving the built-in models | error=%v", err)
+		} else {
+			c.snap = snap
+		}
+	}
+	c.addAliases(aliases)
+	if path == "" {
+		if inline != "" || len(aliases) > 0 {
+			logger.Info("Model catalog initialized | models=%d aliases=%d", len(c.snap.Models), len(c.snap.Aliases))
+		}
+		return c
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		logger.Warn("Failed to read model catalog file, ignoring it | file=%s error=%v", path, err)
+	default:
+		var snap Snapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			logger.Warn("Failed to parse model catalog file, ignoring it | file=%s error=%v", path, err)
+			break
+		}
+		if snap, err = normalize(snap); err != nil {
+			logger.Warn("Invalid model catalog file, ignoring it | file=%s error=%v", path, err)
+			break
+		}
+		c.snap = snap
+	}
+	logger.Info("Model catalog initialized | file=%s models=%d aliases=%d", path, len(c.snap.Models), len(c.snap.Aliases))
+	return c
+}
+
+// addAliases adds configured aliases to the initial catalog, skipping those
+// that would make it invalid
+func (c *Catalog) addAliases(aliases map[string]string) {
+	for _, alias := range slices.Sorted(maps.Keys(aliases)) {
+		snap := Snapshot{Models: c.snap.Models, Aliases: maps.Clone(c.snap.Aliases)}
+		if snap.Aliases == nil {
+			snap.Aliases = make(map[string]string, len(aliases))
+		}
+		snap.Aliases[alias] = aliases[alias]
+		normalized, err := normalize(snap)
+		if err != nil {
+			logger.Warn("Invalid model alias, ignoring | alias=%s target=%s error=%v", alias, aliases[alias], err)
+			continue
+		}
+		c.snap = normalized
+	}
+}
+
+// Snapshot returns a copy of the current catalog
+func (c *Catalog) Snapshot() Snapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return Snapshot{Models: slices.Clone(c.snap.Models), Aliases: maps.Clone(c.snap.Aliases), UpdatedAt: c.snap.UpdatedAt}
+}
+
+// Models returns the served models
+func (c *Catalog) Models() []Model {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return slices.Clone(c.snap.Models)
+}
+
+// Has reports whether model is served by the catalog (aliases are not models)
+func (c *Catalog) Has(model string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return slices.ContainsFunc(c.snap.Models, func(m Model) bool { return m.ID == model })
+}
+
+// IDs returns the IDs of the served models
+func (c *Catalog) IDs() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ids := make([]string, len(c.snap.Models))
+	for i, m := range c.snap.Models {
+		ids[i] = m.ID
+	}
+	return ids
+}
+
+// Resolve returns the model an alias points to; other names are returned unchanged
+// with ok false
+func (c *Catalog) Resolve(model string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	target, ok := c.snap.Aliases[model]
+	if !ok {
+		return model, false
+	}
+	return target, true
+}
+
+// Deprecation reports whether a model is deprecated and what replaces it
+func (c *Catalog) Deprecation(model string) (Deprecation, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, m := range c.snap.Models {
+		if m.ID == model {
+			return Deprecation{ReplacedBy: m.ReplacedBy, Redirect: m.Redirect}, m.Deprecated
+		}
+	}
+	return Deprecation{}, false
+}
+
+// Replace validates and persists a new catalog, then serves it. Nothing changes
+// when the catalog is invalid or cannot be written.
+func (c *Catalog) Replace(snap Snapshot) (Snapshot, error) {
+	snap, err := normalize(snap)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	now := time.Now().UTC()
+	snap.UpdatedAt = &now
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.path != "" {
+		if err := write(c.path, snap); err != nil {
+			return Snapshot{}, fmt.Errorf("failed to persist the model catalog: %w", err)
+		}
+	}
+	c.snap = snap
+	return Snapshot{Models: slices.Clone(snap.Models), Aliases: maps.Clone(snap.Aliases), UpdatedAt: snap.UpdatedAt}, nil
+}
+
+// normalize trims IDs, fills owned_by and checks that model IDs are unique and
+// non-empty, that every alias points to a served model without shadowing one and
+// that deprecated models are replaced by a served model that is not deprecated
+func normalize(snap Snapshot) (Snapshot, error) {
+	if len(snap.Models) == 0 {
+		return Snapshot{}, errors.New("models must not be empty")
+	}
+	models := make([]Model, 0, len(snap.Models))
+	ids := make(map[string]bool, len(snap.Models))
+	for _, m := range snap.Models {
+		m.ID = strings.TrimSpace(m.ID)
+		if m.ID == "" {
+			return Snapshot{}, errors.New("model id must not be empty")
+		}
+		if ids[m.ID] {
+			return Snapshot{}, fmt.Errorf("duplicate model %q", m.ID)
+		}
+		ids[m.ID] = true
+		if m.OwnedBy == "" {
+			m.OwnedBy = DefaultOwner
+		}
+		m.ReplacedBy = strings.TrimSpace(m.ReplacedBy)
+		if m.ContextWindow < 0 {
+			return Snapshot{}, fmt.Errorf("model %q has a negative context window", m.ID)
+		}
+		models = append(models, m)
+	}
+	deprecated := make(map[string]bool)
+	for _, m := range models {
+		if m.Deprecated {
+			deprecated[m.ID] = true
+		}
+	}
+	for _, m := range models {
+		switch {
+		case !m.Deprecated && (m.ReplacedBy != "" || m.Redirect):
+			return Snapshot{}, fmt.Errorf("model %q names a replacement but is not deprecated", m.ID)
+		case m.Redirect && m.ReplacedBy == "":
+			return Snapshot{}, fmt.Errorf("model %q redirects but names no replacement", m.ID)
+		case m.ReplacedBy == "":
+		case !ids[m.ReplacedBy]:
+			return Snapshot{}, fmt.Errorf("model %q is replaced by %q, which is not in the catalog", m.ID, m.ReplacedBy)
+		case deprecated[m.ReplacedBy]:
+			return Snapshot{}, fmt.Errorf("model %q is replaced by %q, which is deprecated too", m.ID, m.ReplacedBy)
+		}
+	}
+
+	var aliases map[string]string
+	for alias, target := range snap.Aliases {
+		alias, target = strings.TrimSpace(alias), strings.TrimSpace(target)
+		switch {
+		case alias == "":
+			return Snapshot{}, errors.New("alias must not be empty")
+		case ids[alias]:
+			return Snapshot{}, fmt.Errorf("alias %q shadows a model of the catalog", alias)
+		case !ids[target]:
+			return Snapshot{}, fmt.Errorf("alias %q points to %q, which is not in the catalog", alias, target)
+		}
+		if aliases == nil {
+			aliases = make(map[string]string, len(snap.Aliases))
+		}
+		aliases[alias] = target
+	}
+	return Snapshot{Models: models, Aliases: aliases, UpdatedAt: snap.UpdatedAt}, nil
+}
+
+// write writes the catalog to a temporary file and renames it into place
+func write(path string, snap Snapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,144 @@
+package catalog
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCatalog_ReplaceAndPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models.json")
+	c := New(path, "", nil)
+	if len(c.Models()) != len(DefaultModels) {
+		t.Fatalf("models = %d, want the built-in list", len(c.Models()))
+	}
+
+	updated, err := c.Replace(Snapshot{
+		Models:  []Model{{ID: " openai/gpt-5 "}, {ID: "openai/gpt-5-mini", OwnedBy: "openai"}},
+		Aliases: map[string]string{"gpt-4o": "openai/gpt-5"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.UpdatedAt == nil || updated.Models[0].ID != "openai/gpt-5" || updated.Models[0].OwnedBy != DefaultOwner {
+		t.Errorf("updated = %+v", updated)
+	}
+	if target, ok := c.Resolve("gpt-4o"); !ok || target != "openai/gpt-5" {
+		t.Errorf("Resolve(gpt-4o) = %q, %v", target, ok)
+	}
+	if target, ok := c.Resolve("openai/gpt-5-mini"); ok || target != "openai/gpt-5-mini" {
+		t.Errorf("Resolve of a model = %q, %v; want it unchanged", target, ok)
+	}
+
+	reloaded := New(path, "", nil)
+	if snap := reloaded.Snapshot(); len(snap.Models) != 2 || snap.Aliases["gpt-4o"] != "openai/gpt-5" || snap.UpdatedAt == nil {
+		t.Errorf("reloaded = %+v", snap)
+	}
+}
+
+func TestCatalog_Deprecation(t *testing.T) {
+	c := New("", "", nil)
+	if _, err := c.Replace(Snapshot{Models: []Model{
+		{ID: "old", Deprecated: true, ReplacedBy: " new ", Redirect: true},
+		{ID: "legacy", Deprecated: true},
+		{ID: "new"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if dep, ok := c.Deprecation("old"); !ok || dep.ReplacedBy != "new" || !dep.Redirect {
+		t.Errorf("Deprecation(old) = %+v, %v", dep, ok)
+	}
+	if dep, ok := c.Deprecation("legacy"); !ok || dep.ReplacedBy != "" || dep.Redirect {
+		t.Errorf("Deprecation(legacy) = %+v, %v", dep, ok)
+	}
+	for _, model := range []string{"new", "unknown"} {
+		if _, ok := c.Deprecation(model); ok {
+			t.Errorf("Deprecation(%s) reported a deprecated model", model)
+		}
+	}
+}
+
+func TestCatalog_ReplaceRejectsInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models.json")
+	c := New(path, "", nil)
+
+	tests := []struct {
+		name string
+		snap Snapshot
+	}{
+		{"no models", Snapshot{}},
+		{"empty id", Snapshot{Models: []Model{{ID: " "}}}},
+		{"duplicate", Snapshot{Models: []Model{{ID: "a"}, {ID: "a"}}}},
+		{"dangling alias", Snapshot{Models: []Model{{ID: "a"}}, Aliases: map[string]string{"b": "c"}}},
+		{"alias shadows a model", Snapshot{Models: []Model{{ID: "a"}, {ID: "b"}}, Aliases: map[string]string{"b": "a"}}},
+		{"replacement not deprecated", Snapshot{Models: []Model{{ID: "a", ReplacedBy: "b"}, {ID: "b"}}}},
+		{"redirect without replacement", Snapshot{Models: []Model{{ID: "a", Deprecated: true, Redirect: true}}}},
+		{"dangling replacement", Snapshot{Models: []Model{{ID: "a", Deprecated: true, ReplacedBy: "b"}}}},
+		{"deprecated replacement", Snapshot{Models: []Model{{ID: "a", Deprecated: true, ReplacedBy: "b"}, {ID: "b", Deprecated: true}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := c.Replace(tt.snap); !errors.Is(err, ErrInvalid) {
+				t.Errorf("err = %v, want ErrInvalid", err)
+			}
+		})
+	}
+	if len(c.Models()) != len(DefaultModels) {
+		t.Error("a rejected catalog must not change the served models")
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Error("a rejected catalog must not be persisted")
+	}
+}
+
+func TestNew_InvalidFileFallsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models.json")
+	if err := os.WriteFile(path, []byte(`{"models": [], "aliases": {"x": "y"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if c := New(path, "", nil); len(c.Models()) != len(DefaultModels) {
+		t.Errorf("models = %+v, want the built-in list", c.Models())
+	}
+}
+
+func TestCatalog_Inline(t *testing.T) {
+	c := New("", `{"models": [{"id": "openai/gpt-5", "context_window": 400000, "supports_tools": true}], "aliases": {"gpt": "openai/gpt-5"}}`, nil)
+	if models := c.Models(); len(models) != 1 || models[0].ContextWindow != 400_000 || !models[0].SupportsTools || models[0].OwnedBy != DefaultOwner {
+		t.Fatalf("models = %+v", models)
+	}
+	if !c.Has("openai/gpt-5") || c.Has("gpt") || c.Has("xai/grok-4") {
+		t.Error("Has must only report catalog models")
+	}
+
+	// An invalid inline catalog falls back to the built-in models
+	if c := New("", `{"models": [{"id": "a", "context_window": -1}]}`, nil); len(c.IDs()) != len(DefaultModels) {
+		t.Errorf("ids = %v, want the built-in list", c.IDs())
+	}
+
+	// The persisted catalog takes precedence
+	path := filepath.Join(t.TempDir(), "models.json")
+	if _, err := New(path, "", nil).Replace(Snapshot{Models: []Model{{ID: "xai/grok-4"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if ids := New(path, `{"models": [{"id": "openai/gpt-5"}]}`, nil).IDs(); len(ids) != 1 || ids[0] != "xai/grok-4" {
+		t.Errorf("ids = %v, want the persisted catalog", ids)
+	}
+}
+
+func TestCatalog_ConfiguredAliases(t *testing.T) {
+	c := New("", "", map[string]string{
+		"gpt-4o":            "openai/gpt-5",
+		"claude-3-5-sonnet": "anthropic/claude-4.5-sonnet",
+		"openai/gpt-5":      "xai/grok-4", // Shadows a model
+		"broken":            "unknown/model",
+	})
+	snap := c.Snapshot()
+	if len(snap.Aliases) != 2 || snap.Aliases["gpt-4o"] != "openai/gpt-5" || snap.Aliases["claude-3-5-sonnet"] != "anthropic/claude-4.5-sonnet" {
+		t.Errorf("aliases = %v, want only the valid ones", snap.Aliases)
+	}
+	if target, ok := c.Resolve("claude-3-5-sonnet"); !ok || target != "anthropic/claude-4.5-sonnet" {
+		t.Errorf("Resolve = %q, %v", target, ok)
+	}
+}
@@ -0,0 +1,257 @@
+// Package client is a typed Go client for a cursor2api deployment: chat
+// completions, streamed completions as an iterator, the model list and the health
+// check. Failed requests are retried when the deployment reports a transient
+// condition (rate limits, restarts, upstream outages), honouring Retry-After, and
+// interrupted streams reconnect through their resume token when the deployment
+// has STREAM_RESUME_WINDOW set.
+//
+//	c := client.New("http://cursor2api:3001", client.WithAPIKey(os.Getenv("CURSOR2API_KEY")))
+//	for chunk, err := range c.ChatStream(ctx, req) {
+//		...
+//	}
+package client
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cursor2api/types"
+)
+
+// userAgent identifies the client to the deployment (cursor2api_client_requests_total)
+const userAgent = "cursor2api-go/1.0"
+
+// Defaults of the retry policy
+const (
+	defaultRetries   = 2
+	defaultRetryWait = 500 * time.Millisecond
+	maxRetryWait     = 30 * time.Second
+)
+
+// Client calls the API of one cursor2api deployment; it is safe for concurrent use
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+	retries    int
+	retryWait  time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithAPIKey sends key as the Bearer token of every request
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithHTTPClient replaces the default HTTP client. Its Timeout also bounds
+// streams, so prefer request contexts for deadlines.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithRetries sets how often a failed request is retried (0 disables retries) and
+// the first backoff, doubled on every further attempt; a Retry-After of the
+// deployment takes precedence
+func WithRetries(retries int, wait time.Duration) Option {
+	return func(c *Client) {
+		c.retries = max(retries, 0)
+		c.retryWait = wait
+	}
+}
+
+// New creates a client for the deployment at baseURL (e.g. http://localhost:3001)
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{},
+		retries:    defaultRetries,
+		retryWait:  defaultRetryWait,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// APIError is an error response of the deployment, or an error event ending a stream
+type APIError struct {
+	StatusCode int           // 0 for an error event in a stream
+	RetryAfter time.Duration // From the Retry-After header, 0 when absent
+	types.ErrorDetail
+}
+
+func (e *APIError) Error() string {
+	code := cmp.Or(e.Code, e.Type)
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("cursor2api: stream error %s: %s", code, e.Message)
+	}
+	return fmt.Sprintf("cursor2api: HTTP %d %s: %s", e.StatusCode, code, e.Message)
+}
+
+// Temporary reports whether repeating the request may succeed: rate limits, a
+// restarting deployment and upstream outages are temporary, an exhausted spend
+// limit or a response violating the requested format are not
+func (e *APIError) Temporary() bool {
+	switch e.Code {
+	case "spend_limit_exceeded", "json_mode_violation", "json_schema_violation":
+		return false
+	}
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Chat sends a chat completion request and returns the whole completion;
+// req.Stream is ignored
+func (c *Client) Chat(ctx context.Context, req types.ChatCompletionRequest) (*types.ChatCompletionResponse, error) {
+	req.Stream, req.StreamOptions = false, nil
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("cursor2api: encode request: %w", err)
+	}
+	var resp types.ChatCompletionResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/v1/chat/completions", body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Models returns the models served by the deployment, aliases included
+func (c *Client) Models(ctx context.Context) ([]types.Model, error) {
+	var list types.ModelList
+	if err := c.doJSON(ctx, http.MethodGet, "/v1/models", nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Data, nil
+}
+
+// Health returns the health report of the deployment (GET /health)
+func (c *Client) Health(ctx context.Context) (*types.HealthResponse, error) {
+	var health types.HealthResponse
+	if err := c.doJSON(ctx, http.MethodGet, "/health", nil, &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// doJSON sends a request with retries and decodes the JSON response into out
+func (c *Client) doJSON(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	resp, err := c.do(ctx, method, path, body, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("cursor2api: decode %s response: %w", path, err)
+	}
+	return nil
+}
+
+// do sends a request, retrying temporary failures, and returns the first 2xx
+// response; the caller closes its body
+func (c *Client) do(ctx context.Context, method, path string, body []byte, header http.Header) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, path, body, header)
+		if err == nil {
+			return resp, nil
+		}
+		if attempt >= c.retries || !retryable(ctx, err) {
+			return nil, err
+		}
+
+		wait := c.retryWait << attempt
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			wait = apiErr.RetryAfter
+		}
+		timer := time.NewTimer(min(wait, maxRetryWait))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// send makes one attempt; responses other than 2xx are returned as *APIError
+func (c *Client) send(ctx context.Context, method, path string, body []byte, header http.Header) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("cursor2api: build request: %w", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cursor2api: %s %s: %w", method, path, err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	return nil, responseError(resp)
+}
+
+// responseError reads the OpenAI-style error body of a failed response
+func responseError(resp *http.Response) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	var body types.ErrorResponse
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err := json.Unmarshal(data, &body); err == nil && body.Error.Message != "" {
+		apiErr.ErrorDetail = body.Error
+	} else {
+		apiErr.Message = cmp.Or(strings.TrimSpace(string(data)), http.StatusText(resp.StatusCode))
+	}
+	return apiErr
+}
+
+// retryable reports whether a failed attempt is worth repeating: temporary API
+// errors and transport errors, unless ctx ended
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	return true
+}
@@ -0,0 +1,177 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cursor2api/types"
+)
+
+var chatRequest = types.ChatCompletionRequest{
+	Model:    "claude-sonnet-4",
+	Messages: []types.ChatMessage{{Role: "user", Content: "hi"}},
+}
+
+func TestClient_RetriesTemporaryErrors(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if r.Header.Get("Authorization") != "Bearer sk-test" || r.UserAgent() != userAgent {
+			t.Errorf("Authorization %q, User-Agent %q", r.Header.Get("Authorization"), r.UserAgent())
+		}
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"error":{"message":"The server is shutting down, please retry.","type":"api_error","code":"server_shutting_down"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"hello"}}]}`)
+	}))
+	defer server.Close()
+
+	c := New(server.URL+"/", WithAPIKey("sk-test"))
+	start := time.Now()
+	resp, err := c.Chat(context.Background(), chatRequest)
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if attempts != 2 || resp.Choices[0].Message.Content != "hello" {
+		t.Errorf("attempts = %d, response = %+v", attempts, resp)
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Errorf("retried after %v, want the Retry-After of 1s", waited)
+	}
+}
+
+func TestClient_DoesNotRetryPermanentErrors(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error":{"message":"Spend limit reached","type":"insufficient_quota","code":"spend_limit_exceeded"}}`)
+	}))
+	defer server.Close()
+
+	_, err := New(server.URL).Chat(context.Background(), chatRequest)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests || apiErr.Code != "spend_limit_exceeded" {
+		t.Fatalf("err = %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestChatStream_Collect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, event := range []string{
+			`{"id":"chatcmpl-1","model":"claude-sonnet-4","choices":[{"index":0,"delta":{"role":"assistant","content":"Let me "}}]}`,
+			`{"id":"chatcmpl-1","model":"claude-sonnet-4","choices":[{"index":0,"delta":{"content":"check."}}]}`,
+			`{"id":"chatcmpl-1","model":"claude-sonnet-4","choices":[{"index":0,"delta":{"tool_calls":[{"id":"call_1","type":"function","function":{"name":"read_file","arguments":"{\"path\":"}}]}}]}`,
+			`{"id":"chatcmpl-1","model":"claude-sonnet-4","choices":[{"index":0,"delta":{"tool_calls":[{"function":{"arguments":"\"a.go\"}"}}]}}]}`,
+			`{"id":"chatcmpl-1","model":"claude-sonnet-4","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+			`{"id":"chatcmpl-1","model":"claude-sonnet-4","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`,
+			`[DONE]`,
+		} {
+			fmt.Fprintf(w, ": keep-alive\n\ndata: %s\n\n", event)
+		}
+	}))
+	defer server.Close()
+
+	resp, err := Collect(New(server.URL).ChatStream(context.Background(), chatRequest))
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	msg := resp.Choices[0].Message
+	if msg.Content != "Let me check." || resp.Choices[0].FinishReason != "tool_calls" || resp.Usage.TotalTokens != 15 {
+		t.Errorf("response = %+v, message = %+v", resp, msg)
+	}
+	if len(msg.ToolCalls) != 1 || msg.ToolCalls[0].ID != "call_1" || msg.ToolCalls[0].Function.Name != "read_file" ||
+		msg.ToolCalls[0].Function.Arguments != `{"path":"a.go"}` {
+		t.Errorf("tool calls = %+v", msg.ToolCalls)
+	}
+}
+
+func TestCollect_InterleavedChoices(t *testing.T) {
+	chunks := []*types.ChatCompletionStreamResponse{
+		{Choices: []types.ChatCompletionChoice{{Index: 0, Delta: &types.ChatMessage{Content: "Hel"}}}},
+		{Choices: []types.ChatCompletionChoice{{Index: 1, Delta: &types.ChatMessage{Content: "Bon"}}}},
+		{Choices: []types.ChatCompletionChoice{{Index: 0, Delta: &types.ChatMessage{Content: "lo"}}}},
+		{Choices: []types.ChatCompletionChoice{{Index: 2, Delta: &types.ChatMessage{Content: "Hal"}}}},
+		{Choices: []types.ChatCompletionChoice{{Index: 1, Delta: &types.ChatMessage{Content: "jour"}}}},
+		{Choices: []types.ChatCompletionChoice{{Index: 2, Delta: &types.ChatMessage{Content: "lo"}}}},
+	}
+	resp, err := Collect(func(yield func(*types.ChatCompletionStreamResponse, error) bool) {
+		for _, chunk := range chunks {
+			if !yield(chunk, nil) {
+				return
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	for i, want := range []string{"Hello", "Bonjour", "Hallo"} {
+		if got := resp.Choices[i].Message.Content; got != want {
+			t.Errorf("choice %d = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestChatStream_ResumesInterruptedStream(t *testing.T) {
+	var resumedAfter string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := r.Header.Get(resumeHeader); token != "" {
+			resumedAfter = r.Header.Get("Last-Event-ID")
+			fmt.Fprint(w, "id: 2\ndata: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\" world\"}}]}\n\ndata: [DONE]\n\n")
+			return
+		}
+		w.Header().Set(resumeHeader, "tok")
+		w.Header().Set("Content-Length", "1000") // The connection ends short of it
+		fmt.Fprint(w, "id: 1\ndata: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hello\"}}]}\n\n")
+	}))
+	defer server.Close()
+
+	var text strings.Builder
+	for chunk, err := range New(server.URL).ChatStream(context.Background(), chatRequest) {
+		if err != nil {
+			t.Fatalf("stream: %v", err)
+		}
+		text.WriteString(chunk.Choices[0].Delta.Content)
+	}
+	if text.String() != "hello world" || resumedAfter != "1" {
+		t.Errorf("text = %q, resumed after %q", text.String(), resumedAfter)
+	}
+}
+
+func TestChatStream_ErrorEvent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"partial\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"error\"}],\"error\":{\"message\":\"upstream closed\",\"type\":\"upstream_error\",\"code\":\"upstream_aborted\"}}\n\n")
+	}))
+	defer server.Close()
+
+	// The final chunk is yielded for its finish_reason, then the error
+	var finishReasons []string
+	var streamErr error
+	for chunk, err := range New(server.URL).ChatStream(context.Background(), chatRequest) {
+		if err != nil {
+			streamErr = err
+			break
+		}
+		finishReasons = append(finishReasons, chunk.Choices[0].FinishReason)
+	}
+	var apiErr *APIError
+	if !errors.As(streamErr, &apiErr) || apiErr.StatusCode != 0 || apiErr.Code != "upstream_aborted" {
+		t.Errorf("err = %v", streamErr)
+	}
+	if len(finishReasons) != 2 || finishReasons[1] != "error" {
+		t.Errorf("finish reasons = %q", finishReasons)
+	}
+}
@@ -0,0 +1,161 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"strings"
+
+	"cursor2api/types"
+)
+
+// resumeHeader carries the resume token of a stream (middleware.ResumeHeader)
+const resumeHeader = "X-Resume-Token"
+
+// maxEventBytes bounds one SSE line of a stream
+const maxEventBytes = 1 << 20
+
+// ChatStream sends a streaming chat completion and yields its chunks. Opening the
+// stream is retried like any request. When the connection breaks mid-stream and
+// the deployment issued a resume token, the stream continues after the last event
+// received, at most as often as requests are retried. Iteration ends after the
+// last chunk or with the first error; an error event of the stream is an
+// *APIError with StatusCode 0. Breaking out of the loop closes the stream.
+func (c *Client) ChatStream(ctx context.Context, req types.ChatCompletionRequest) iter.Seq2[*types.ChatCompletionStreamResponse, error] {
+	req.Stream = true
+	body, err := json.Marshal(req)
+	return func(yield func(*types.ChatCompletionStreamResponse, error) bool) {
+		if err != nil {
+			yield(nil, fmt.Errorf("cursor2api: encode request: %w", err))
+			return
+		}
+		resp, err := c.do(ctx, http.MethodPost, "/v1/chat/completions", body, nil)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		token := resp.Header.Get(resumeHeader)
+
+		var lastID string
+		for resumes := 0; ; resumes++ {
+			finished, err := readStream(resp.Body, &lastID, yield)
+			_ = resp.Body.Close()
+			if finished {
+				return
+			}
+			if token == "" || resumes >= c.retries || ctx.Err() != nil {
+				yield(nil, fmt.Errorf("cursor2api: stream interrupted: %w", err))
+				return
+			}
+
+			header := http.Header{resumeHeader: {token}}
+			if lastID != "" {
+				header.Set("Last-Event-ID", lastID)
+			}
+			if resp, err = c.do(ctx, http.MethodPost, "/v1/chat/completions", body, header); err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+	}
+}
+
+// readStream yields the chunks of an SSE body, recording the ID of each event in
+// lastID. finished is true once the stream ended ([DONE] or an error event) or
+// the consumer stopped; otherwise the connection broke with err.
+func readStream(body io.Reader, lastID *string, yield func(*types.ChatCompletionStreamResponse, error) bool) (finished bool, err error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxEventBytes)
+	var eventID string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if id, ok := strings.CutPrefix(line, "id: "); ok {
+			eventID = id
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			// Blank lines end events; comments carry progress and keep-alives
+			continue
+		}
+		if eventID != "" {
+			*lastID, eventID = eventID, ""
+		}
+		if data == "[DONE]" {
+			return true, nil
+		}
+
+		var chunk types.ChatCompletionStreamResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			yield(nil, fmt.Errorf("cursor2api: decode stream event: %w", err))
+			return true, nil
+		}
+		if chunk.Error != nil {
+			// A stream aborted by the upstream ends with a chunk carrying the error
+			if len(chunk.Choices) > 0 && !yield(&chunk, nil) {
+				return true, nil
+			}
+			yield(nil, &APIError{ErrorDetail: *chunk.Error})
+			return true, nil
+		}
+		if !yield(&chunk, nil) {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	return false, io.ErrUnexpectedEOF
+}
+
+// Collect reads a stream to the end and assembles its chunks into the response a
+// non-streaming request returns: the text of each choice, its tool calls with
+// their arguments joined, the finish reason and, when the stream reports it
+// (stream_options.include_usage), the token usage
+func Collect(stream iter.Seq2[*types.ChatCompletionStreamResponse, error]) (*types.ChatCompletionResponse, error) {
+	resp := &types.ChatCompletionResponse{Object: "chat.completion"}
+	var content []*strings.Builder
+	for chunk, err := range stream {
+		if err != nil {
+			return nil, err
+		}
+		resp.ID, resp.Created, resp.Model = chunk.ID, chunk.Created, chunk.Model
+		if chunk.Usage != nil {
+			resp.Usage = *chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			for len(resp.Choices) <= choice.Index {
+				resp.Choices = append(resp.Choices, types.ChatCompletionChoice{
+					Index:   len(resp.Choices),
+					Message: &types.ChatMessage{Role: "assistant"},
+				})
+				content = append(content, &strings.Builder{})
+			}
+			merged := &resp.Choices[choice.Index]
+			if choice.FinishReason != "" {
+				merged.FinishReason = choice.FinishReason
+			}
+			if choice.Delta == nil {
+				continue
+			}
+			content[choice.Index].WriteString(choice.Delta.Content)
+			for _, call := range choice.Delta.ToolCalls {
+				calls := merged.Message.ToolCalls
+				if call.ID != "" || len(calls) <= call.StreamIndex() {
+					// The first chunk of a tool call carries its ID and name
+					merged.Message.ToolCalls = append(calls, call)
+					continue
+				}
+				calls[call.StreamIndex()].Function.Arguments += call.Function.Arguments
+			}
+		}
+	}
+	for i := range resp.Choices {
+		resp.Choices[i].Message.Content = content[i].String()
+	}
+	return resp, nil
+}
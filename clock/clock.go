@@ -0,0 +1,86 @@
+// Package clock abstracts the current time and random jitter for time-based
+// subsystems (rate limiter cleanup, cache TTLs, AntiBot parameter expiry, quota
+// windows), so their behavior can be tested with a Fake clock instead of sleeping.
+package clock
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and draws random jitter
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	// Jitter returns a random duration in [0, max), 0 when max is not positive
+	Jitter(max time.Duration) time.Duration
+}
+
+// System is the wall clock with random jitter, the default of every subsystem
+var System Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                  { return time.Now() }
+func (systemClock) Since(t time.Time) time.Duration { return time.Since(t) }
+
+func (systemClock) Jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(max)))
+}
+
+// Fake is a clock that only moves when told to. Its jitter is a fixed fraction
+// of the maximum (0 unless set with SetJitter). It is safe for concurrent use.
+type Fake struct {
+	mu     sync.Mutex
+	now    time.Time
+	jitter float64
+}
+
+// NewFake returns a fake clock reading now
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+func (f *Fake) Jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return min(time.Duration(float64(max)*f.jitter), max-1)
+}
+
+// Advance moves the clock forward by d
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	f.now = f.now.Add(d)
+	f.mu.Unlock()
+}
+
+// Set moves the clock to t
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	f.now = t
+	f.mu.Unlock()
+}
+
+// SetJitter makes Jitter return fraction (0-1) of its maximum
+func (f *Fake) SetJitter(fraction float64) {
+	f.mu.Lock()
+	f.jitter = min(max(fraction, 0), 1)
+	f.mu.Unlock()
+}
@@ -0,0 +1,44 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2025, 1, 31, 23, 59, 0, 0, time.UTC)
+	c := NewFake(start)
+	if !c.Now().Equal(start) {
+		t.Fatalf("Now = %v, want %v", c.Now(), start)
+	}
+	c.Advance(2 * time.Minute)
+	if got := c.Since(start); got != 2*time.Minute {
+		t.Errorf("Since = %v, want 2m", got)
+	}
+	if c.Now().Month() != time.February {
+		t.Errorf("Now = %v, want February", c.Now())
+	}
+
+	if got := c.Jitter(time.Second); got != 0 {
+		t.Errorf("Jitter = %v, want 0 by default", got)
+	}
+	c.SetJitter(0.5)
+	if got := c.Jitter(time.Second); got != 500*time.Millisecond {
+		t.Errorf("Jitter = %v, want 500ms", got)
+	}
+	c.SetJitter(1)
+	if got := c.Jitter(time.Second); got >= time.Second {
+		t.Errorf("Jitter = %v, want below the maximum", got)
+	}
+}
+
+func TestSystemJitter(t *testing.T) {
+	if got := System.Jitter(0); got != 0 {
+		t.Errorf("Jitter(0) = %v, want 0", got)
+	}
+	for range 100 {
+		if got := System.Jitter(time.Millisecond); got < 0 || got >= time.Millisecond {
+			t.Fatalf("Jitter = %v, want [0, 1ms)", got)
+		}
+	}
+}
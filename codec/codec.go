@@ -0,0 +1,135 @@
+// Package codec compresses the files this service stores (transcripts, usage
+// state) with gzip or zstd. Readers detect the codec from the data itself, so
+// files written before the codec was changed stay readable.
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codecs
+const (
+	None = "none"
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// Level trades speed for size
+type Level int
+
+const (
+	// Fast is used for files written while requests are served
+	Fast Level = iota
+	// Best is used when compacting finished files in the background
+	Best
+)
+
+// Magic numbers at the start of compressed data
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Valid reports whether name is a known codec
+func Valid(name string) bool {
+	return name == None || name == Gzip || name == Zstd
+}
+
+// Ext returns the file extension of a codec: "", ".gz" or ".zst"
+func Ext(name string) string {
+	switch name {
+	case Gzip:
+		return ".gz"
+	case Zstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// NewWriter returns a writer compressing into w; Close flushes the compressed
+// stream but does not close w
+func NewWriter(w io.Writer, name string, level Level) (io.WriteCloser, error) {
+	switch name {
+	case Gzip:
+		gzipLevel := gzip.BestSpeed
+		if level == Best {
+			gzipLevel = gzip.BestCompression
+		}
+		return gzip.NewWriterLevel(w, gzipLevel)
+	case Zstd:
+		zstdLevel := zstd.SpeedFastest
+		if level == Best {
+			zstdLevel = zstd.SpeedBetterCompression
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstdLevel))
+	case None, "":
+		return nopCloser{w}, nil
+	}
+	return nil, fmt.Errorf("unknown codec %q", name)
+}
+
+// NewReader returns a reader decompressing r, detecting gzip and zstd from the
+// first bytes; other data is returned unchanged
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(head, zstdMagic):
+		dec, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case bytes.HasPrefix(head, gzipMagic):
+		return gzip.NewReader(br)
+	}
+	return io.NopCloser(br), nil
+}
+
+// Encode compresses data
+func Encode(data []byte, name string, level Level) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, name, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decompresses data written by any codec
+func Decode(data []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// ReadFile reads and decompresses a file written by any codec
+func ReadFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(data)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,36 @@
+package codec
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	data := []byte(strings.Repeat(`{"type":"delta","content":"hello"}`+"\n", 200))
+	for _, name := range []string{None, Gzip, Zstd} {
+		for _, level := range []Level{Fast, Best} {
+			encoded, err := Encode(data, name, level)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if name != None && len(encoded) >= len(data) {
+				t.Errorf("%s: %d bytes not compressed (%d)", name, len(encoded), len(data))
+			}
+			decoded, err := Decode(encoded)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if !bytes.Equal(decoded, data) {
+				t.Errorf("%s: round trip mismatch", name)
+			}
+		}
+	}
+
+	if _, err := NewWriter(&bytes.Buffer{}, "lz4", Fast); err == nil {
+		t.Error("unknown codec must fail")
+	}
+	if decoded, err := Decode(nil); err != nil || len(decoded) != 0 {
+		t.Errorf("empty data = %q, %v", decoded, err)
+	}
+}
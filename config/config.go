@@ -1,8 +1,10 @@
 package config
 
 import (
+	"cmp"
 	"log"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -15,11 +17,45 @@ type Config struct {
 	Cursor    CursorConfig
 	Auth      AuthConfig
 	RateLimit RateLimitConfig
+	Upstream  UpstreamConfig
+	Chaos     ChaosConfig
+	Mock      MockConfig
+	Usage     UsageConfig
+
+	Observability ObservabilityConfig
+	Pool          PoolConfig
+	Stream        StreamConfig
+	Shadow        ShadowConfig
+	Canary        CanaryConfig
+	Guardrail     GuardrailConfig
+	Tenant        TenantConfig
+	Heartbeat     HeartbeatConfig
+	Guidance      GuidanceConfig
+	Verify        VerifyConfig
+	ErrorDocs     ErrorDocsConfig
+	KeepWarm      KeepWarmConfig
+	Storage       StorageConfig
+	Availability  AvailabilityConfig
+	Cache         CacheConfig
+
+	settings map[string]Setting // Environment variables read by Load, for GET /admin/config/effective
 }
 
 // ServerConfig holds server-related configuration
 type ServerConfig struct {
-	Port string
+	Port                 string
+	MetricsEnabled       bool          // Serve Prometheus metrics at /metrics (unauthenticated, like /health)
+	ReadTimeout          time.Duration // Maximum time to read a request including its body
+	WriteTimeout         time.Duration // Maximum time to write a non-streaming response
+	IdleTimeout          time.Duration // Keep-alive connections are closed after this idle time
+	StreamWriteTimeout   time.Duration // Maximum duration of an SSE response, replacing WriteTimeout (0 = unlimited)
+	ShutdownTimeout      time.Duration // Time in-flight requests get to finish after SIGTERM
+	StreamDrainGrace     time.Duration // Streams still running this long before the shutdown deadline are ended with a server_shutting_down error event
+	ComponentStopTimeout time.Duration // Upper bound for stopping each other subsystem (exporters, pools, ...) on shutdown
+	LowMemoryMode        bool          // Smaller buffers, pools and caches for small VPS/ARM hosts (see applyLowMemoryProfile)
+	DedupeWindow         time.Duration // Identical chat requests of a key within this window share one generation (0 = disabled)
+	DedupeMaxBytes       int           // Larger responses are not kept for duplicates arriving after they finished
+	SigningSecret        string        `secret:"value"` // Signs every response with HMAC-SHA256 for downstream verification (empty = disabled)
 }
 
 // LoggerConfig holds logger-related configuration
@@ -36,55 +72,586 @@ type CursorConfig struct {
 	RefreshInterval        time.Duration
 	IdleTimeout            time.Duration
 	EnableFunctionCalling  bool
+	ChatURL                string            // Upstream chat endpoint
+	Referer                string            // referer header sent with chat requests
+	JSReferer              string            // referer header sent when downloading the AntiBot script
+	XMethod                string            // x-method header
+	XPath                  string            // x-path header
+	ExtraHeaders           map[string]string `secret:"value"` // Additional headers added to every chat request
+	PassthroughHeaders     []string          // Upstream response headers surfaced to clients as X-Upstream-<name>
+	ErrorHistorySize       int               // Number of recent AntiBot refresh errors kept for stats/health
+	StartupRequireToken    bool              // Exit when the first refresh fails; otherwise retry in the background
+	StartupChecks          bool              // Check PROCESS_URL, JS_URL and the upstream handshake at boot and print a diagnostic table
+	StartupCheckTimeout    time.Duration     // Timeout of each startup check
+	StartupMaxBackoff      time.Duration     // Upper bound of the background startup retry backoff
+	SolverCacheTTL         time.Duration     // Reuse the solver result while the script is unchanged (0 = always solve)
+	SharedTokenRedisURL    string            `secret:"url"` // Replicas elect one refresher through a Redis lock and share its token (empty = every instance solves)
+	SharedTokenKeyPrefix   string            // Prefix of the lock, token and pub/sub channel keys
+	SharedTokenLockTTL     time.Duration     // Leader lock lifetime; another replica takes over this long after the leader disappears
+	PromptTemplatesFile    string            // JSON object of prompt_id -> template; clients select one with prompt_id
+	KeyUpstreamHeadersFile string            // JSON object of api_key -> tagging headers added to that key's upstream requests
+	PresetsFile            string            // JSON file of generation parameter presets assigned per model or API key
+	AutoModelEnabled       bool              // Offer the pseudo-model "auto", routed to a real model by prompt heuristics
+	AutoModelRulesFile     string            // JSON routing rules for "auto" (empty = built-in rules)
+	ModelAliases           map[string]string // Alias -> model ID added to the initial catalog (e.g. gpt-4o=openai/gpt-5)
+	ModelCatalog           string            // Inline JSON catalog (models with metadata, aliases) served until MODEL_CATALOG_FILE holds one
+	ModelCatalogFile       string            // Persists the model list and aliases set through PUT /admin/models (empty = in memory only)
+	ModelsCacheMaxAge      time.Duration     // Cache-Control max-age of /v1/models; clients revalidate with its ETag (0 = always revalidate)
+	ResponseModel          string            // Model echoed in responses: resolved (the model that served the request) or requested (the name the client sent)
+	MessageHooks           []string          // Registered converter hooks run on request messages, in order
+	OutputHooks            []string          // Registered converter hooks run on generated text, in order
+	ToolPromptLanguage     string            // Language of the injected tool instructions: auto, zh or en
+	ToolsMaxJSONBytes      int               // Limit on the tool definitions JSON injected into the system prompt (0 = unlimited)
+	ToolsOversizeStrategy  []string          // Steps applied in order to oversized tool definitions: drop_examples, truncate_descriptions, reject
+	ToolsDescriptionMax    int               // Characters kept of each description by truncate_descriptions
 }
 
 // AuthConfig holds authentication-related configuration
 type AuthConfig struct {
-	Enabled bool
-	APIKeys []string
+	Enabled      bool
+	APIKeys      []string          `secret:"value"`
+	AdminToken   string            `secret:"value"` // Bearer token with the admin role for /admin/ endpoints
+	AdminTokens  map[string]string `secret:"keys"`  // Additional admin tokens mapped to a role: viewer, operator or admin
+	SandboxKeys  []string          `secret:"value"` // Keys accepted in addition to API_KEYS whose requests never use real capacity or quotas
+	SandboxModel string            // Model serving sandbox requests on the real upstream (empty = mock responses)
+
+	FeatureGrants map[string]string `secret:"keys"` // api_key -> "*" or "|"-separated experimental features it may enable with X-C2A-Features
 }
 
 // RateLimitConfig holds rate limiting configuration
 type RateLimitConfig struct {
-	Enabled         bool
-	RequestsPerSec  float64
-	Burst           int
-	Strategy        string
-	CleanupInterval time.Duration
+	Enabled            bool
+	RequestsPerSec     float64
+	Burst              int
+	Strategy           string
+	CleanupInterval    time.Duration
+	UserRequestsPerSec float64 // Per end-user limit beneath the key/IP via the `user` request field (0 = disabled)
+	UserBurst          int
+	PolicyFile         string        // JSON rate limit policy: time-of-day profiles and adaptive tightening (empty = static limits)
+	PolicyInterval     time.Duration // How often the policy is re-evaluated
+	BanFile            string        // Persists IP and API key bans across restarts (empty = in memory only)
+	TrustedProxies     []string      // Reverse proxies (IPs or CIDRs) whose X-Forwarded-For names the client for IP bans
+}
+
+// UpstreamConfig holds upstream HTTP client and connection pool configuration
+type UpstreamConfig struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+	TLSHandshakeTimeout time.Duration
+	TLSSessionCacheSize int
+	DisableKeepAlives   bool
+	DNSServers          []string
+	StaticHosts         map[string]string
+	DoHURL              string
+	TLSInsecure         bool
+	TLSCAFiles          []string
+	TLSPinnedSHA256     []string
+}
+
+// ChaosConfig holds fault injection settings for resilience testing (never enable in production)
+type ChaosConfig struct {
+	Enabled       bool
+	Latency       time.Duration
+	LatencyJitter time.Duration
+	ErrorRate     float64 // Probability of an injected upstream 5xx
+	TruncateRate  float64 // Probability that a response stream is cut off mid-way
+	MalformedRate float64 // Per-line probability of injecting a malformed SSE event
+}
+
+// MockConfig holds the synthetic upstream settings used for load tests and client integration
+type MockConfig struct {
+	Enabled    bool
+	ChunkCount int
+	ChunkDelay time.Duration
+	ReplayFile string // Serve this captured upstream stream instead of synthetic chunks
+}
+
+// UsageConfig holds usage accounting configuration
+type UsageConfig struct {
+	DimensionKeys         []string          // Request metadata keys recorded as usage dimensions
+	Pricing               map[string]string // model=input:output USD per million tokens, overrides the built-in table
+	MonthlySpendLimit     float64           // Default per-key monthly cap in USD (0 = unlimited)
+	SpendLimits           map[string]string `secret:"keys"` // api_key=usd per-key monthly cap overrides
+	DailyBudget           float64           // Global daily USD budget alerted at 50/80/100% (0 = none)
+	DailyModelBudgets     map[string]string // model=usd per-model daily budgets
+	BudgetAlertWebhookURL string            `secret:"url"` // Receives budget alerts; alerts are only logged when empty
+	SizeAnomalyFactor     float64           // Alert when a request is this many times the moving average of its key and model (0 = disabled)
+	SizeAnomalyMinSamples int               // Requests of a key and model seen before its baseline is trusted
+	SizeAnomalyCooldown   time.Duration     // Minimum time between alerts of one key, model and metric
+	SizeAlertWebhookURL   string            `secret:"url"` // Receives size anomaly alerts; alerts are only logged when empty
+	StateFile             string            // Persists monthly spend across restarts
+	ExportInterval        time.Duration
+	BillingWebhookURL     string `secret:"url"`
+	BillingWebhookSecret  string `secret:"value"` // Signs webhook payloads with HMAC-SHA256
+	ExportCSVPath         string
+}
+
+// ObservabilityConfig holds trace header names and the optional Langfuse-compatible exporter settings
+type ObservabilityConfig struct {
+	TraceHeader       string // Header carrying the trace ID, echoed on responses
+	SessionHeader     string // Header carrying the session ID
+	UserHeader        string // Header carrying the end-user ID
+	LangfuseHost      string // Exporter is disabled when empty
+	LangfusePublicKey string `secret:"value"`
+	LangfuseSecretKey string `secret:"value"`
+	CaptureContent    bool   // Include prompts and completions in exported generations
+	CaptureMaxBytes   int    // Upper bound of completion content kept in memory for export (0 = unlimited)
+	BatchSize         int
+	FlushInterval     time.Duration
+	TranscriptDir     string // Stores streamed completions as JSONL files; disabled when empty
+
+	AttemptsHeader string // X-Attempts upstream attempt trace on chat responses: errors, always or off
+
+	RecentCompletions  int // Completion summaries kept in memory for GET /admin/recent (0 = disabled)
+	RecentPreviewChars int // Characters of completion text kept as a preview in those summaries (0 = no content)
+
+	UpstreamCaptureDir         string   // Failing upstream SSE streams are written here while capture mode is enabled through the admin API
+	UpstreamCaptureMaxBytes    int      // Bytes of each upstream stream kept for capture
+	UpstreamCaptureKeepContent bool     // Keep text deltas and tool inputs instead of masking them
+	UpstreamCaptureRedact      []string // Regular expressions replaced with [REDACTED] in captured streams
+
+	SLOTarget  float64         // Success-rate objective the SLIs are checked against at GET /admin/slo
+	SLIWindows []time.Duration // Rolling windows of the per-endpoint and per-model SLIs (empty = disabled)
+
+	OTLPEndpoint     string            `secret:"url"`   // OTLP/HTTP collector; spans are posted to <endpoint>/v1/traces (empty = tracing disabled)
+	OTLPHeaders      map[string]string `secret:"value"` // Headers sent with each export, e.g. collector credentials
+	ServiceName      string            // service.name resource attribute of the spans
+	TraceSampleRatio float64           // Share of new traces recorded; requests with a traceparent follow its sampled flag
+}
+
+// PoolConfig holds the upstream account pool and sticky conversation routing settings
+type PoolConfig struct {
+	AccountsFile     string        // JSON array of {"name", "headers"}; the pool is disabled when empty
+	StickyTTL        time.Duration // How long a conversation stays pinned to an account after its last request
+	FailureThreshold int           // Consecutive failures before an account is taken out of rotation
+	Cooldown         time.Duration // How long an unhealthy account stays out of rotation
+}
+
+// Languages of the tool-calling instructions injected into the system prompt
+const (
+	ToolPromptAuto    = "auto" // match the dominant language of the user messages
+	ToolPromptChinese = "zh"
+	ToolPromptEnglish = "en"
+)
+
+// Values of RESPONSE_MODEL: the "model" field of chat responses
+const (
+	ResponseModelResolved  = "resolved"  // the model that served the request, after aliases, redirects and routing
+	ResponseModelRequested = "requested" // the model name the client sent, e.g. an alias
+)
+
+// Steps of TOOLS_OVERSIZE_STRATEGY, applied in order until the tool definitions fit
+const (
+	ToolsDropExamples         = "drop_examples"         // remove example/examples keywords from the parameter schemas
+	ToolsTruncateDescriptions = "truncate_descriptions" // shorten tool and parameter descriptions
+	ToolsReject               = "reject"                // answer 400 tools_too_large
+)
+
+// Slow consumer policies applied when the stream channel to the client handler is full
+const (
+	SlowConsumerBlock = "block" // wait up to SendTimeout, then abort the stream
+	SlowConsumerDrop  = "drop"  // abort the stream as soon as the buffer is full
+)
+
+// Handling of upstream completions without any text or tool call, after the retry
+const (
+	EmptyCompletionPassthrough = "passthrough" // return the empty assistant message
+	EmptyCompletionPlaceholder = "placeholder" // return EMPTY_COMPLETION_PLACEHOLDER as the content
+	EmptyCompletionError       = "error"       // fail with an empty_completion error
+)
+
+// When the X-Attempts header is added to chat responses
+const (
+	AttemptsHeaderErrors = "errors" // failed requests only
+	AttemptsHeaderAlways = "always" // every response; streams list the attempts finished before the first byte
+	AttemptsHeaderOff    = "off"
+)
+
+// StreamConfig holds the buffering between the upstream reader and the client writer
+type StreamConfig struct {
+	ChannelBuffer      int               // Chunks buffered between the upstream reader and the client writer
+	ScannerBuffer      int               // Maximum size of a single upstream SSE line in bytes
+	SlowConsumerPolicy string            // block or drop
+	SendTimeout        time.Duration     // Deadline for a blocked send under the block policy (0 = wait indefinitely)
+	JSONModeRetries    int               // Non-streaming retries with stricter instructions when json_object/json_schema output is not a matching JSON object (0 = fail immediately)
+	ExtraHeaders       map[string]string `secret:"value"` // Headers added to every SSE response, e.g. those a specific CDN needs to pass the stream through
+	ProgressInterval   time.Duration     // Interval of x-progress comments for streams that opt in
+	EmptyRetry         bool              // Retry once when the upstream completes without any text or tool call
+	EmptyAction        string            // passthrough, placeholder or error when the completion is still empty
+	EmptyPlaceholder   string            // Content returned for empty completions under the placeholder action
+	MaxFrameBytes      int               // Tool call arguments are split across chunks so no SSE frame exceeds this size (0 = unlimited, min 1024)
+	ToolArgumentChunk  int               // Size in bytes of the streamed function.arguments fragments (0 = all arguments in one chunk after the name)
+	MaxTokensPerSecond int               // Output pacing: text of a stream reaches the client at no more than this rate (0 = unpaced)
+	MaxPerKey          int               // Concurrent streaming completions per API key, more are rejected with 429 (0 = unlimited)
+	ResumeWindow       time.Duration     // Disconnected clients may reconnect to a stream with its resume token within this window (0 = disabled)
+	ResumeBufferBytes  int               // Most recent events of each stream kept for reconnects
+
+	UnknownEventThreshold float64 // Share of unknown upstream event types that switches the parser or warns of a schema change
+	SchemaDetectEvents    int     // Early events of each upstream stream inspected by the schema detector
+}
+
+// ShadowConfig holds request mirroring to a secondary OpenAI-compatible backend
+type ShadowConfig struct {
+	URL            string            `secret:"url"` // Chat completions endpoint of the secondary backend; shadowing is disabled when empty
+	SampleRate     float64           // Fraction of requests mirrored (0-1)
+	Model          string            // Model name sent to the secondary backend (empty = keep the client's model)
+	Headers        map[string]string `secret:"value"` // Headers sent to the secondary backend, e.g. its Authorization
+	Timeout        time.Duration     // Per shadow request timeout
+	MaxConcurrency int               // Shadow requests in flight; extra samples are dropped
+	RedactFields   []string          // Request fields removed before mirroring: user, metadata, conversation_id
+	RedactPatterns []string          // Regular expressions replaced with [REDACTED] in message content
+}
+
+// CanaryConfig holds a variant configuration applied to a percentage of requests;
+// empty fields inherit the regular configuration
+type CanaryConfig struct {
+	Name         string            // Variant name used in metrics and the X-Config-Variant header
+	Percent      float64           // Share of requests served by the variant (0-100); disabled when 0
+	SystemPrompt string            // Sent as a leading system message on variant requests
+	ChatURL      string            `secret:"url"`   // Variant upstream chat endpoint
+	ExtraHeaders map[string]string `secret:"value"` // Variant headers added to upstream requests (merged over CURSOR_EXTRA_HEADERS)
+}
+
+// GuardrailConfig holds the default post-processing applied to generated text
+type GuardrailConfig struct {
+	MaxOutputChars   int      // Generated text is cut off after this many characters with finish_reason "length" (0 = unlimited)
+	BannedSubstrings []string // Replaced with Redaction, case-insensitive
+	Redaction        string
+	RequiredPrefix   string // Prepended when the output does not already start with it
+	RequiredSuffix   string // Appended when the output does not already end with it
+	KeysFile         string // JSON object of api_key -> policy; a listed key uses its own policy instead of the defaults
+}
+
+// TenantConfig holds multi-tenant namespaces
+type TenantConfig struct {
+	File   string // JSON object of tenant name -> settings; multi-tenancy is disabled when empty
+	Header string // Selects the tenant for API keys not bound to one
+}
+
+// HeartbeatConfig holds the push reporter that POSTs health and usage snapshots as
+// CloudEvents, for environments that cannot scrape /health or /metrics
+type HeartbeatConfig struct {
+	URL          string        `secret:"url"`   // Endpoint receiving the CloudEvents batch (empty = disabled)
+	Secret       string        `secret:"value"` // Signs the payload with HMAC-SHA256 in X-Signature-SHA256
+	Interval     time.Duration // Time between heartbeats
+	RetryBackoff time.Duration // First retry delay after a failed delivery, doubled up to Interval
+	Source       string        // CloudEvents source attribute (default: cursor2api/<hostname>)
+}
+
+// VerifyConfig holds the self-verification pass of non-streaming completions with
+// format constraints (JSON mode, required fields)
+type VerifyConfig struct {
+	Enabled bool   // Verify every eligible completion; requests opt in or out with self_verify
+	Model   string // Model of the repair call (empty = the request's model)
+}
+
+// KeepWarmConfig holds the synthetic requests that keep the AntiBot token, TLS
+// sessions and upstream path warm during quiet periods
+type KeepWarmConfig struct {
+	Interval time.Duration // Time between keep-warm requests; skipped after real traffic (0 = disabled)
+	Hours    string        // Daily window in local time, HH[:MM]-HH[:MM] (empty = all day)
+	Model    string        // Model of the synthetic request (empty = first model of the catalog)
+}
+
+// StorageConfig holds the compression of stored transcripts and usage state
+type StorageConfig struct {
+	Compression     string        // none, gzip or zstd; files are read whatever codec wrote them
+	CompactInterval time.Duration // Time between recompressions of finished transcript days (0 = disabled)
+}
+
+// CacheConfig holds the backends of the caches shared by caching features
+type CacheConfig struct {
+	Backends   map[string]string // Use case (e.g. dedupe) -> memory, redis or disk (unlisted = memory)
+	RedisURL   string            `secret:"url"` // Server of the redis backend, e.g. redis://:secret@host:6379/0
+	Dir        string            // Root of the disk backend; each use case gets a subdirectory
+	MaxEntries int               // Entries kept by each memory cache; the closest to expiry are evicted (0 = unlimited)
+}
+
+// AvailabilityConfig holds the degradation policy applied when dependencies fail
+type AvailabilityConfig struct {
+	MaxTokenAge time.Duration // While the solver is failing, chat keeps using the last AntiBot token up to this age (0 = never; 503 instead)
+}
+
+// ErrorDocsConfig holds the hints and documentation links added to error payloads
+type ErrorDocsConfig struct {
+	Hints   bool   // Add an actionable hint to error payloads
+	DocsURL string // Page documenting the error codes, linked as doc_url <url>#<code> (empty = no link)
+}
+
+// GuidanceConfig holds the fetcher that keeps per-model guidance (default system
+// prompt, description) in sync with a document published upstream
+type GuidanceConfig struct {
+	URL      string        // JSON guidance document (empty = disabled)
+	Interval time.Duration // Time between fetches
 }
 
 // Load reads configuration from environment variables
 func Load() *Config {
+	takeSettings() // Forget the settings of a previous Load
 	cfg := &Config{
 		Server: ServerConfig{
-			Port: getEnv("PORT", "5680"),
+			Port:                 getEnv("PORT", "5680"),
+			MetricsEnabled:       getBoolEnv("METRICS_ENABLED", true),
+			ReadTimeout:          getDurationEnv("SERVER_READ_TIMEOUT", 15*time.Second),
+			WriteTimeout:         getDurationEnv("SERVER_WRITE_TIMEOUT", 120*time.Second),
+			IdleTimeout:          getDurationEnv("SERVER_IDLE_TIMEOUT", 60*time.Second),
+			StreamWriteTimeout:   getDurationEnv("SERVER_STREAM_WRITE_TIMEOUT", 0),
+			ShutdownTimeout:      getDurationEnv("SERVER_SHUTDOWN_TIMEOUT", 30*time.Second),
+			StreamDrainGrace:     getDurationEnv("SERVER_STREAM_DRAIN_GRACE", 2*time.Second),
+			ComponentStopTimeout: getDurationEnv("COMPONENT_STOP_TIMEOUT", 10*time.Second),
+			LowMemoryMode:        getBoolEnv("LOW_MEMORY_MODE", false),
+			DedupeWindow:         getDurationEnv("DEDUPE_WINDOW", 0),
+			DedupeMaxBytes:       getIntEnv("DEDUPE_MAX_BYTES", 1024*1024),
+			SigningSecret:        getEnv("RESPONSE_SIGNING_SECRET", ""),
 		},
 		Logger: LoggerConfig{
 			Level:   getEnv("LOG_LEVEL", "info"),
 			Verbose: getBoolEnv("LOG_VERBOSE", false),
 		},
 		Cursor: CursorConfig{
-			JSURL:           getEnv("JS_URL", "https://cursor.com/149e9513-01fa-4fb0-aad4-566afd725d1b/2d206a39-8ed7-437e-a3be-862e0f06eea3/a-4-a/c.js?i=0&v=3&h=cursor.com"),
-			ProcessURL:      getEnv("PROCESS_URL", "http://localhost:3000/api/process"),
-			SystemPrompt:    getEnv("SYSTEM_PROMPT", "You are a helpful assistant."),
-			RefreshInterval: getDurationEnv("REFRESH_INTERVAL", 5*time.Minute),
-			IdleTimeout:     getDurationEnv("IDLE_TIMEOUT", 10*time.Minute),
+			JSURL:                  getEnv("JS_URL", "https://cursor.com/149e9513-01fa-4fb0-aad4-566afd725d1b/2d206a39-8ed7-437e-a3be-862e0f06eea3/a-4-a/c.js?i=0&v=3&h=cursor.com"),
+			ProcessURL:             getEnv("PROCESS_URL", "http://localhost:3000/api/process"),
+			SystemPrompt:           getEnv("SYSTEM_PROMPT", "You are a helpful assistant."),
+			RefreshInterval:        getDurationEnv("REFRESH_INTERVAL", 5*time.Minute),
+			IdleTimeout:            getDurationEnv("IDLE_TIMEOUT", 10*time.Minute),
+			ChatURL:                getEnv("CURSOR_CHAT_URL", "https://cursor.com/api/chat"),
+			Referer:                getEnv("CURSOR_REFERER", "https://cursor.com/cn/learn/context"),
+			JSReferer:              getEnv("CURSOR_JS_REFERER", "https://cursor.com/cn/learn"),
+			XMethod:                getEnv("CURSOR_X_METHOD", "POST"),
+			XPath:                  getEnv("CURSOR_X_PATH", "/api/chat"),
+			ExtraHeaders:           getMapEnv("CURSOR_EXTRA_HEADERS", map[string]string{}),
+			PassthroughHeaders:     getSliceEnv("UPSTREAM_PASSTHROUGH_HEADERS", nil),
+			ErrorHistorySize:       getIntEnv("ERROR_HISTORY_SIZE", 50),
+			StartupRequireToken:    getBoolEnv("STARTUP_REQUIRE_TOKEN", true),
+			StartupChecks:          getBoolEnv("STARTUP_CHECKS", true),
+			StartupCheckTimeout:    getDurationEnv("STARTUP_CHECK_TIMEOUT", 10*time.Second),
+			StartupMaxBackoff:      getDurationEnv("STARTUP_MAX_BACKOFF", time.Minute),
+			SolverCacheTTL:         getDurationEnv("SOLVER_CACHE_TTL", 0),
+			SharedTokenRedisURL:    getEnv("ANTIBOT_SHARED_REDIS_URL", ""),
+			SharedTokenKeyPrefix:   getEnv("ANTIBOT_SHARED_KEY_PREFIX", "cursor2api:antibot"),
+			SharedTokenLockTTL:     getDurationEnv("ANTIBOT_SHARED_LOCK_TTL", 0),
+			PromptTemplatesFile:    getEnv("PROMPT_TEMPLATES_FILE", ""),
+			KeyUpstreamHeadersFile: getEnv("KEY_UPSTREAM_HEADERS_FILE", ""),
+			PresetsFile:            getEnv("PRESETS_FILE", ""),
+			AutoModelEnabled:       getBoolEnv("AUTO_MODEL_ENABLED", false),
+			AutoModelRulesFile:     getEnv("AUTO_MODEL_RULES_FILE", ""),
+			ModelAliases:           getMapEnv("MODEL_ALIASES", nil),
+			ModelCatalog:           getEnv("MODEL_CATALOG", ""),
+			ModelCatalogFile:       getEnv("MODEL_CATALOG_FILE", ""),
+			ModelsCacheMaxAge:      getDurationEnv("MODELS_CACHE_MAX_AGE", time.Minute),
+			ResponseModel:          getEnv("RESPONSE_MODEL", ResponseModelResolved),
+			MessageHooks:           getSliceEnv("CONVERTER_MESSAGE_HOOKS", nil),
+			OutputHooks:            getSliceEnv("CONVERTER_OUTPUT_HOOKS", nil),
+			ToolPromptLanguage:     getEnv("TOOL_PROMPT_LANGUAGE", ToolPromptAuto),
+			ToolsMaxJSONBytes:      getIntEnv("TOOLS_MAX_JSON_BYTES", 0),
+			ToolsOversizeStrategy:  getSliceEnv("TOOLS_OVERSIZE_STRATEGY", []string{ToolsDropExamples, ToolsTruncateDescriptions, ToolsReject}),
+			ToolsDescriptionMax:    getIntEnv("TOOLS_DESCRIPTION_MAX_CHARS", 200),
 		},
 		Auth: AuthConfig{
-			Enabled: getBoolEnv("AUTH_ENABLED", true),
-			APIKeys: getSliceEnv("API_KEYS", []string{}),
+			Enabled:      getBoolEnv("AUTH_ENABLED", true),
+			APIKeys:      getSliceEnv("API_KEYS", []string{}),
+			AdminToken:   getEnv("ADMIN_TOKEN", ""),
+			AdminTokens:  getMapEnv("ADMIN_TOKENS", map[string]string{}),
+			SandboxKeys:  getSliceEnv("SANDBOX_API_KEYS", []string{}),
+			SandboxModel: getEnv("SANDBOX_MODEL", ""),
+
+			FeatureGrants: getMapEnv("FEATURE_FLAG_GRANTS", map[string]string{}),
 		},
 		RateLimit: RateLimitConfig{
-			Enabled:         getBoolEnv("RATE_LIMIT_ENABLED", true),
-			RequestsPerSec:  getFloatEnv("RATE_LIMIT_REQUESTS_PER_SEC", 1000.0),
-			Burst:           getIntEnv("RATE_LIMIT_BURST", 2000),
-			Strategy:        getEnv("RATE_LIMIT_STRATEGY", "ip"),
-			CleanupInterval: getDurationEnv("RATE_LIMIT_CLEANUP_INTERVAL", 10*time.Minute),
+			Enabled:            getBoolEnv("RATE_LIMIT_ENABLED", true),
+			RequestsPerSec:     getFloatEnv("RATE_LIMIT_REQUESTS_PER_SEC", 1000.0),
+			Burst:              getIntEnv("RATE_LIMIT_BURST", 2000),
+			Strategy:           getEnv("RATE_LIMIT_STRATEGY", "ip"),
+			CleanupInterval:    getDurationEnv("RATE_LIMIT_CLEANUP_INTERVAL", 10*time.Minute),
+			UserRequestsPerSec: getFloatEnv("RATE_LIMIT_USER_REQUESTS_PER_SEC", 0),
+			UserBurst:          getIntEnv("RATE_LIMIT_USER_BURST", 20),
+			PolicyFile:         getEnv("RATE_LIMIT_POLICY_FILE", ""),
+			PolicyInterval:     getDurationEnv("RATE_LIMIT_POLICY_INTERVAL", 15*time.Second),
+			BanFile:            getEnv("BAN_FILE", ""),
+			TrustedProxies:     getSliceEnv("TRUSTED_PROXIES", nil),
+		},
+		Upstream: UpstreamConfig{
+			MaxIdleConns:        getIntEnv("UPSTREAM_MAX_IDLE_CONNS", 100),
+			MaxIdleConnsPerHost: getIntEnv("UPSTREAM_MAX_IDLE_CONNS_PER_HOST", 20),
+			MaxConnsPerHost:     getIntEnv("UPSTREAM_MAX_CONNS_PER_HOST", 0),
+			IdleConnTimeout:     getDurationEnv("UPSTREAM_IDLE_CONN_TIMEOUT", 90*time.Second),
+			TLSHandshakeTimeout: getDurationEnv("UPSTREAM_TLS_HANDSHAKE_TIMEOUT", 10*time.Second),
+			TLSSessionCacheSize: getIntEnv("UPSTREAM_TLS_SESSION_CACHE_SIZE", 64),
+			DisableKeepAlives:   getBoolEnv("UPSTREAM_DISABLE_KEEPALIVES", false),
+			DNSServers:          getSliceEnv("UPSTREAM_DNS_SERVERS", []string{}),
+			StaticHosts:         getMapEnv("UPSTREAM_STATIC_HOSTS", map[string]string{}),
+			DoHURL:              getEnv("UPSTREAM_DOH_URL", ""),
+			TLSInsecure:         getBoolEnv("UPSTREAM_TLS_INSECURE", false),
+			TLSCAFiles:          getSliceEnv("UPSTREAM_TLS_CA_FILES", []string{}),
+			TLSPinnedSHA256:     getSliceEnv("UPSTREAM_TLS_PINNED_SHA256", []string{}),
+		},
+		Chaos: ChaosConfig{
+			Enabled:       getBoolEnv("CHAOS_ENABLED", false),
+			Latency:       getDurationEnv("CHAOS_LATENCY", 0),
+			LatencyJitter: getDurationEnv("CHAOS_LATENCY_JITTER", 0),
+			ErrorRate:     getFloatEnv("CHAOS_ERROR_RATE", 0),
+			TruncateRate:  getFloatEnv("CHAOS_TRUNCATE_RATE", 0),
+			MalformedRate: getFloatEnv("CHAOS_MALFORMED_RATE", 0),
+		},
+		Mock: MockConfig{
+			Enabled:    getBoolEnv("MOCK_MODE", false),
+			ChunkCount: getIntEnv("MOCK_CHUNK_COUNT", 20),
+			ChunkDelay: getDurationEnv("MOCK_CHUNK_DELAY", 20*time.Millisecond),
+			ReplayFile: getEnv("MOCK_REPLAY_FILE", ""),
+		},
+		Usage: UsageConfig{
+			DimensionKeys:         getSliceEnv("USAGE_DIMENSION_KEYS", []string{"team", "feature"}),
+			Pricing:               getMapEnv("MODEL_PRICING", map[string]string{}),
+			MonthlySpendLimit:     getFloatEnv("SPEND_LIMIT_MONTHLY_USD", 0),
+			SpendLimits:           getMapEnv("SPEND_LIMITS", map[string]string{}),
+			DailyBudget:           getFloatEnv("DAILY_BUDGET_USD", 0),
+			DailyModelBudgets:     getMapEnv("DAILY_MODEL_BUDGETS", map[string]string{}),
+			BudgetAlertWebhookURL: getEnv("BUDGET_ALERT_WEBHOOK_URL", ""),
+			SizeAnomalyFactor:     getFloatEnv("SIZE_ANOMALY_FACTOR", 10),
+			SizeAnomalyMinSamples: getIntEnv("SIZE_ANOMALY_MIN_SAMPLES", 20),
+			SizeAnomalyCooldown:   getDurationEnv("SIZE_ANOMALY_COOLDOWN", time.Hour),
+			SizeAlertWebhookURL:   getEnv("SIZE_ALERT_WEBHOOK_URL", ""),
+			StateFile:             getEnv("USAGE_STATE_FILE", ""),
+			ExportInterval:        getDurationEnv("USAGE_EXPORT_INTERVAL", time.Hour),
+			BillingWebhookURL:     getEnv("BILLING_WEBHOOK_URL", ""),
+			BillingWebhookSecret:  getEnv("BILLING_WEBHOOK_SECRET", ""),
+			ExportCSVPath:         getEnv("USAGE_EXPORT_CSV_PATH", ""),
+		},
+		Pool: PoolConfig{
+			AccountsFile:     getEnv("UPSTREAM_ACCOUNTS_FILE", ""),
+			StickyTTL:        getDurationEnv("STICKY_SESSION_TTL", time.Hour),
+			FailureThreshold: getIntEnv("ACCOUNT_FAILURE_THRESHOLD", 3),
+			Cooldown:         getDurationEnv("ACCOUNT_COOLDOWN", time.Minute),
+		},
+		Stream: StreamConfig{
+			ChannelBuffer:      getIntEnv("STREAM_CHANNEL_BUFFER", 10),
+			ScannerBuffer:      getIntEnv("STREAM_SCANNER_BUFFER", 1024*1024),
+			SlowConsumerPolicy: getEnv("STREAM_SLOW_CONSUMER_POLICY", SlowConsumerBlock),
+			SendTimeout:        getDurationEnv("STREAM_SEND_TIMEOUT", 30*time.Second),
+			JSONModeRetries:    getIntEnv("STREAM_JSON_MODE_RETRIES", 1),
+			ExtraHeaders:       getMapEnv("STREAM_EXTRA_HEADERS", map[string]string{}),
+			ProgressInterval:   getDurationEnv("STREAM_PROGRESS_INTERVAL", 2*time.Second),
+			EmptyRetry:         getBoolEnv("EMPTY_COMPLETION_RETRY", true),
+			EmptyAction:        getEnv("EMPTY_COMPLETION_ACTION", EmptyCompletionPassthrough),
+			EmptyPlaceholder:   getEnv("EMPTY_COMPLETION_PLACEHOLDER", "(The model returned an empty response. Please try again.)"),
+			MaxFrameBytes:      getIntEnv("STREAM_MAX_FRAME_BYTES", 0),
+			ToolArgumentChunk:  getIntEnv("STREAM_TOOL_ARGUMENT_CHUNK_BYTES", 128),
+			MaxTokensPerSecond: getIntEnv("STREAM_MAX_TOKENS_PER_SECOND", 0),
+			MaxPerKey:          getIntEnv("STREAM_MAX_PER_KEY", 0),
+			ResumeWindow:       getDurationEnv("STREAM_RESUME_WINDOW", 0),
+			ResumeBufferBytes:  getIntEnv("STREAM_RESUME_BUFFER_BYTES", 1024*1024),
+
+			UnknownEventThreshold: getFloatEnv("STREAM_UNKNOWN_EVENT_THRESHOLD", 0.3),
+			SchemaDetectEvents:    getIntEnv("STREAM_SCHEMA_DETECT_EVENTS", 20),
+		},
+		Shadow: ShadowConfig{
+			URL:            getEnv("SHADOW_URL", ""),
+			SampleRate:     getFloatEnv("SHADOW_SAMPLE_RATE", 0),
+			Model:          getEnv("SHADOW_MODEL", ""),
+			Headers:        getMapEnv("SHADOW_HEADERS", map[string]string{}),
+			Timeout:        getDurationEnv("SHADOW_TIMEOUT", time.Minute),
+			MaxConcurrency: getIntEnv("SHADOW_MAX_CONCURRENCY", 8),
+			RedactFields:   getSliceEnv("SHADOW_REDACT_FIELDS", []string{"user", "metadata", "conversation_id"}),
+			RedactPatterns: getSliceEnv("SHADOW_REDACT_PATTERNS", []string{}),
+		},
+		Canary: CanaryConfig{
+			Name:         getEnv("CANARY_NAME", "canary"),
+			Percent:      getFloatEnv("CANARY_PERCENT", 0),
+			SystemPrompt: getEnv("CANARY_SYSTEM_PROMPT", ""),
+			ChatURL:      getEnv("CANARY_CHAT_URL", ""),
+			ExtraHeaders: getMapEnv("CANARY_EXTRA_HEADERS", map[string]string{}),
+		},
+		Guardrail: GuardrailConfig{
+			MaxOutputChars:   getIntEnv("GUARDRAIL_MAX_OUTPUT_CHARS", 0),
+			BannedSubstrings: getSliceEnv("GUARDRAIL_BANNED_SUBSTRINGS", []string{}),
+			Redaction:        getEnv("GUARDRAIL_REDACTION", "[redacted]"),
+			RequiredPrefix:   getEnv("GUARDRAIL_REQUIRED_PREFIX", ""),
+			RequiredSuffix:   getEnv("GUARDRAIL_REQUIRED_SUFFIX", ""),
+			KeysFile:         getEnv("GUARDRAIL_KEYS_FILE", ""),
+		},
+		Tenant: TenantConfig{
+			File:   getEnv("TENANTS_FILE", ""),
+			Header: getEnv("TENANT_HEADER", "X-Tenant-Id"),
+		},
+		Heartbeat: HeartbeatConfig{
+			URL:          getEnv("HEARTBEAT_URL", ""),
+			Secret:       getEnv("HEARTBEAT_SECRET", ""),
+			Interval:     getDurationEnv("HEARTBEAT_INTERVAL", time.Minute),
+			RetryBackoff: getDurationEnv("HEARTBEAT_RETRY_BACKOFF", 5*time.Second),
+			Source:       getEnv("HEARTBEAT_SOURCE", ""),
 		},
+		Guidance: GuidanceConfig{
+			URL:      getEnv("MODEL_GUIDANCE_URL", ""),
+			Interval: getDurationEnv("MODEL_GUIDANCE_INTERVAL", 6*time.Hour),
+		},
+		Verify: VerifyConfig{
+			Enabled: getBoolEnv("SELF_VERIFY_ENABLED", false),
+			Model:   getEnv("SELF_VERIFY_MODEL", ""),
+		},
+		KeepWarm: KeepWarmConfig{
+			Interval: getDurationEnv("KEEP_WARM_INTERVAL", 0),
+			Hours:    getEnv("KEEP_WARM_HOURS", ""),
+			Model:    getEnv("KEEP_WARM_MODEL", ""),
+		},
+		Storage: StorageConfig{
+			Compression:     getEnv("STORAGE_COMPRESSION", "none"),
+			CompactInterval: getDurationEnv("TRANSCRIPT_COMPACT_INTERVAL", 0),
+		},
+		Cache: CacheConfig{
+			Backends:   getMapEnv("CACHE_BACKENDS", map[string]string{}),
+			RedisURL:   getEnv("CACHE_REDIS_URL", ""),
+			Dir:        getEnv("CACHE_DIR", "data/cache"),
+			MaxEntries: getIntEnv("CACHE_MAX_ENTRIES", 10000),
+		},
+		Availability: AvailabilityConfig{
+			MaxTokenAge: getDurationEnv("AVAILABILITY_MAX_TOKEN_AGE", 5*time.Minute),
+		},
+		ErrorDocs: ErrorDocsConfig{
+			Hints:   getBoolEnv("ERROR_HINTS_ENABLED", false),
+			DocsURL: getEnv("ERROR_DOCS_URL", ""),
+		},
+		Observability: ObservabilityConfig{
+			TraceHeader:       getEnv("TRACE_HEADER", "X-Trace-Id"),
+			SessionHeader:     getEnv("SESSION_HEADER", "X-Session-Id"),
+			UserHeader:        getEnv("USER_HEADER", "X-User-Id"),
+			LangfuseHost:      getEnv("LANGFUSE_HOST", ""),
+			LangfusePublicKey: getEnv("LANGFUSE_PUBLIC_KEY", ""),
+			LangfuseSecretKey: getEnv("LANGFUSE_SECRET_KEY", ""),
+			CaptureContent:    getBoolEnv("LANGFUSE_CAPTURE_CONTENT", false),
+			CaptureMaxBytes:   getIntEnv("LANGFUSE_CAPTURE_MAX_BYTES", 256*1024),
+			BatchSize:         getIntEnv("LANGFUSE_BATCH_SIZE", 50),
+			FlushInterval:     getDurationEnv("LANGFUSE_FLUSH_INTERVAL", 5*time.Second),
+			TranscriptDir:     getEnv("TRANSCRIPT_DIR", ""),
+
+			AttemptsHeader: getEnv("ATTEMPTS_HEADER", AttemptsHeaderErrors),
+
+			RecentCompletions:  getIntEnv("RECENT_COMPLETIONS", 50),
+			RecentPreviewChars: getIntEnv("RECENT_COMPLETIONS_PREVIEW_CHARS", 0),
+
+			UpstreamCaptureDir:         getEnv("UPSTREAM_CAPTURE_DIR", "./captures"),
+			UpstreamCaptureMaxBytes:    getIntEnv("UPSTREAM_CAPTURE_MAX_BYTES", 1024*1024),
+			UpstreamCaptureKeepContent: getBoolEnv("UPSTREAM_CAPTURE_KEEP_CONTENT", false),
+			UpstreamCaptureRedact:      getSliceEnv("UPSTREAM_CAPTURE_REDACT_PATTERNS", nil),
+
+			SLOTarget: getFloatEnv("SLO_TARGET", 0.99),
+
+			OTLPEndpoint:     getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+			OTLPHeaders:      getMapEnv("OTEL_EXPORTER_OTLP_HEADERS", nil),
+			ServiceName:      getEnv("OTEL_SERVICE_NAME", "cursor2api"),
+			TraceSampleRatio: getFloatEnv("OTEL_TRACES_SAMPLER_ARG", 1),
+		},
+	}
+
+	if cfg.Server.LowMemoryMode {
+		applyLowMemoryProfile(cfg)
 	}
 
 	// Validate required configuration
-	if cfg.Cursor.ProcessURL == "" || cfg.Cursor.ProcessURL == "http://localhost:3000/api/process" {
+	if cfg.Mock.Enabled {
+		log.Println("🧪 MOCK_MODE is enabled, upstream requests will be answered with synthetic data")
+	} else if cfg.Cursor.ProcessURL == "" || cfg.Cursor.ProcessURL == "http://localhost:3000/api/process" {
 		log.Println("⚠️  Warning: PROCESS_URL not configured or using default value")
 		log.Println("   Please set PROCESS_URL in .env file to your actual AntiBot service endpoint")
 	}
@@ -94,29 +661,355 @@ func Load() *Config {
 		log.Println("   Please set API_KEYS in .env file or disable authentication")
 	}
 
+	if cfg.Chaos.Enabled {
+		log.Println("⚠️  Warning: CHAOS_ENABLED is true, upstream faults will be injected")
+		log.Println("   This mode is intended for resilience testing only")
+	}
+
+	if p := cfg.Stream.SlowConsumerPolicy; p != SlowConsumerBlock && p != SlowConsumerDrop {
+		log.Printf("⚠️  Warning: Invalid STREAM_SLOW_CONSUMER_POLICY: %s, using default: %s", p, SlowConsumerBlock)
+		cfg.Stream.SlowConsumerPolicy = SlowConsumerBlock
+	}
+	switch cfg.Cursor.ResponseModel {
+	case ResponseModelResolved, ResponseModelRequested:
+	default:
+		log.Printf("⚠️  Warning: Invalid RESPONSE_MODEL: %s, using default: %s", cfg.Cursor.ResponseModel, ResponseModelResolved)
+		cfg.Cursor.ResponseModel = ResponseModelResolved
+	}
+	switch cfg.Cursor.ToolPromptLanguage {
+	case ToolPromptAuto, ToolPromptChinese, ToolPromptEnglish:
+	default:
+		log.Printf("⚠️  Warning: Invalid TOOL_PROMPT_LANGUAGE: %s, using default: %s", cfg.Cursor.ToolPromptLanguage, ToolPromptAuto)
+		cfg.Cursor.ToolPromptLanguage = ToolPromptAuto
+	}
+	if cfg.Cursor.ToolsMaxJSONBytes < 0 {
+		cfg.Cursor.ToolsMaxJSONBytes = 0
+	}
+	if cfg.Cursor.ToolsDescriptionMax < 1 {
+		cfg.Cursor.ToolsDescriptionMax = 200
+	}
+	steps := cfg.Cursor.ToolsOversizeStrategy[:0]
+	for _, step := range cfg.Cursor.ToolsOversizeStrategy {
+		switch step {
+		case ToolsDropExamples, ToolsTruncateDescriptions, ToolsReject:
+			steps = append(steps, step)
+		default:
+			log.Printf("⚠️  Warning: Unknown TOOLS_OVERSIZE_STRATEGY step %q ignored", step)
+		}
+	}
+	cfg.Cursor.ToolsOversizeStrategy = steps
+	switch cfg.Observability.AttemptsHeader {
+	case AttemptsHeaderErrors, AttemptsHeaderAlways, AttemptsHeaderOff:
+	default:
+		log.Printf("⚠️  Warning: Invalid ATTEMPTS_HEADER: %s, using default: %s", cfg.Observability.AttemptsHeader, AttemptsHeaderErrors)
+		cfg.Observability.AttemptsHeader = AttemptsHeaderErrors
+	}
+	switch cfg.Stream.EmptyAction {
+	case EmptyCompletionPassthrough, EmptyCompletionPlaceholder, EmptyCompletionError:
+	default:
+		log.Printf("⚠️  Warning: Invalid EMPTY_COMPLETION_ACTION: %s, using default: %s", cfg.Stream.EmptyAction, EmptyCompletionPassthrough)
+		cfg.Stream.EmptyAction = EmptyCompletionPassthrough
+	}
+	if cfg.Stream.ChannelBuffer < 0 {
+		cfg.Stream.ChannelBuffer = 0
+	}
+	if cfg.Observability.UpstreamCaptureMaxBytes <= 0 {
+		cfg.Observability.UpstreamCaptureMaxBytes = 1024 * 1024
+	}
+	if t := cfg.Observability.SLOTarget; t <= 0 || t >= 1 {
+		log.Printf("⚠️  Warning: Invalid SLO_TARGET: %g, must be between 0 and 1, using default: 0.99", t)
+		cfg.Observability.SLOTarget = 0.99
+	}
+	if r := cfg.Observability.TraceSampleRatio; r < 0 || r > 1 {
+		log.Printf("⚠️  Warning: Invalid OTEL_TRACES_SAMPLER_ARG: %g, must be between 0 and 1, using default: 1", r)
+		cfg.Observability.TraceSampleRatio = 1
+	}
+	for _, item := range getSliceEnv("SLI_WINDOWS", []string{"5m", "1h", "24h"}) {
+		if item == "off" {
+			break
+		}
+		window, err := time.ParseDuration(item)
+		if err != nil || window < time.Minute {
+			log.Printf("⚠️  Warning: Invalid SLI_WINDOWS entry %q ignored, windows are durations of at least 1m", item)
+			continue
+		}
+		cfg.Observability.SLIWindows = append(cfg.Observability.SLIWindows, window)
+	}
+	if cfg.Stream.JSONModeRetries < 0 {
+		cfg.Stream.JSONModeRetries = 0
+	}
+	if cfg.Stream.ProgressInterval <= 0 {
+		cfg.Stream.ProgressInterval = 2 * time.Second
+	}
+	if cfg.Stream.MaxFrameBytes < 0 {
+		cfg.Stream.MaxFrameBytes = 0
+	} else if cfg.Stream.MaxFrameBytes > 0 && cfg.Stream.MaxFrameBytes < 1024 {
+		cfg.Stream.MaxFrameBytes = 1024
+	}
+	if cfg.Stream.ToolArgumentChunk < 0 {
+		cfg.Stream.ToolArgumentChunk = 0
+	}
+	if cfg.Stream.MaxTokensPerSecond < 0 {
+		cfg.Stream.MaxTokensPerSecond = 0
+	} else if cfg.Stream.MaxTokensPerSecond > 0 && cfg.Stream.SlowConsumerPolicy == SlowConsumerDrop {
+		log.Println("⚠️  Warning: STREAM_MAX_TOKENS_PER_SECOND with STREAM_SLOW_CONSUMER_POLICY=drop aborts streams that outpace the limit")
+	}
+	if cfg.Stream.UnknownEventThreshold <= 0 || cfg.Stream.UnknownEventThreshold > 1 {
+		cfg.Stream.UnknownEventThreshold = 0.3
+	}
+	if cfg.Stream.SchemaDetectEvents < 0 {
+		cfg.Stream.SchemaDetectEvents = 0
+	}
+	if cfg.RateLimit.PolicyInterval <= 0 {
+		cfg.RateLimit.PolicyInterval = 15 * time.Second
+	}
+	if cfg.Cursor.SharedTokenLockTTL <= 0 {
+		// Outlive a few refresh intervals so a healthy leader always renews in time
+		cfg.Cursor.SharedTokenLockTTL = max(3*cfg.Cursor.RefreshInterval, 30*time.Second)
+	}
+	if cfg.Cursor.StartupCheckTimeout <= 0 {
+		cfg.Cursor.StartupCheckTimeout = 10 * time.Second
+	}
+	if cfg.Server.ShutdownTimeout <= 0 {
+		cfg.Server.ShutdownTimeout = 30 * time.Second
+	}
+	if cfg.Server.ComponentStopTimeout <= 0 {
+		cfg.Server.ComponentStopTimeout = 10 * time.Second
+	}
+	// Streams are drained within the shutdown timeout
+	cfg.Server.StreamDrainGrace = min(max(cfg.Server.StreamDrainGrace, 0), cfg.Server.ShutdownTimeout)
+	if cfg.Cursor.ModelsCacheMaxAge < 0 {
+		cfg.Cursor.ModelsCacheMaxAge = 0
+	}
+	if cfg.Stream.MaxPerKey < 0 {
+		cfg.Stream.MaxPerKey = 0
+	}
+	if cfg.Stream.ResumeWindow < 0 {
+		cfg.Stream.ResumeWindow = 0
+	}
+	if cfg.Stream.ResumeBufferBytes <= 0 {
+		cfg.Stream.ResumeBufferBytes = 1024 * 1024
+	}
+	switch cfg.Storage.Compression {
+	case "none", "gzip", "zstd":
+	default:
+		log.Printf("⚠️  Warning: Invalid STORAGE_COMPRESSION: %s, using default: none", cfg.Storage.Compression)
+		cfg.Storage.Compression = "none"
+	}
+	if cfg.Storage.CompactInterval < 0 {
+		cfg.Storage.CompactInterval = 0
+	}
+	if cfg.Usage.SizeAnomalyFactor < 0 {
+		cfg.Usage.SizeAnomalyFactor = 0
+	}
+	cfg.Usage.SizeAnomalyMinSamples = max(cfg.Usage.SizeAnomalyMinSamples, 1)
+	cfg.Usage.SizeAnomalyCooldown = max(cfg.Usage.SizeAnomalyCooldown, 0)
+	for use, backend := range cfg.Cache.Backends {
+		switch {
+		case backend != "memory" && backend != "redis" && backend != "disk":
+			log.Printf("⚠️  Warning: Invalid cache backend for %s: %s, using default: memory", use, backend)
+			cfg.Cache.Backends[use] = "memory"
+		case backend == "redis" && cfg.Cache.RedisURL == "":
+			log.Printf("⚠️  Warning: Cache %s uses redis but CACHE_REDIS_URL is not set, using memory", use)
+			cfg.Cache.Backends[use] = "memory"
+		case backend == "disk" && cfg.Cache.Dir == "":
+			log.Printf("⚠️  Warning: Cache %s uses disk but CACHE_DIR is not set, using memory", use)
+			cfg.Cache.Backends[use] = "memory"
+		}
+	}
+	if cfg.Cache.MaxEntries < 0 {
+		cfg.Cache.MaxEntries = 0
+	}
+	if cfg.Availability.MaxTokenAge < 0 {
+		cfg.Availability.MaxTokenAge = 0
+	}
+	if cfg.Heartbeat.Interval <= 0 {
+		cfg.Heartbeat.Interval = time.Minute
+	}
+	if cfg.Guidance.Interval < time.Minute {
+		cfg.Guidance.Interval = time.Minute
+	}
+	if cfg.Heartbeat.RetryBackoff <= 0 {
+		cfg.Heartbeat.RetryBackoff = 5 * time.Second
+	}
+	if cfg.Heartbeat.Source == "" {
+		hostname, _ := os.Hostname()
+		cfg.Heartbeat.Source = "cursor2api/" + hostname
+	}
+
 	// Log loaded configuration with detailed information
 	log.Println("✅ Configuration loaded successfully:")
-	log.Printf("   ├─ Server Port: %s", cfg.Server.Port)
+	log.Printf("   ├─ Server Port: %s (metrics: %v)", cfg.Server.Port, cfg.Server.MetricsEnabled)
+	log.Printf("   ├─ Server Timeouts: read=%s write=%s idle=%s stream_write=%s shutdown=%s component_stop=%s",
+		cfg.Server.ReadTimeout, cfg.Server.WriteTimeout, cfg.Server.IdleTimeout, cfg.Server.StreamWriteTimeout,
+		cfg.Server.ShutdownTimeout, cfg.Server.ComponentStopTimeout)
+	if cfg.Server.LowMemoryMode {
+		log.Printf("   ├─ Low Memory Mode: enabled")
+	}
+	if cfg.Server.DedupeWindow > 0 {
+		log.Printf("   ├─ Request Dedupe: window=%s max_bytes=%d", cfg.Server.DedupeWindow, cfg.Server.DedupeMaxBytes)
+	}
+	if cfg.Server.SigningSecret != "" {
+		log.Printf("   ├─ Response Signing: enabled")
+	}
 	log.Printf("   ├─ Log Level: %s (verbose: %v)", cfg.Logger.Level, cfg.Logger.Verbose)
 	log.Printf("   ├─ Auth Enabled: %v", cfg.Auth.Enabled)
 	if cfg.Auth.Enabled {
 		log.Printf("   ├─ API Keys Count: %d", len(cfg.Auth.APIKeys))
+		if len(cfg.Auth.SandboxKeys) > 0 {
+			log.Printf("   ├─ Sandbox API Keys: %d (served by: %s)", len(cfg.Auth.SandboxKeys), cmp.Or(cfg.Auth.SandboxModel, "mock upstream"))
+		}
+	}
+	log.Printf("   ├─ Admin Endpoints: %v (role tokens: %d)", cfg.Auth.AdminToken != "" || len(cfg.Auth.AdminTokens) > 0, len(cfg.Auth.AdminTokens))
+	if len(cfg.Auth.FeatureGrants) > 0 {
+		log.Printf("   ├─ Feature Flag Grants: %d keys", len(cfg.Auth.FeatureGrants))
+	}
+	if cfg.Tenant.File != "" {
+		log.Printf("   ├─ Tenants: %s (header: %s)", cfg.Tenant.File, cfg.Tenant.Header)
 	}
 	log.Printf("   ├─ Rate Limit Enabled: %v", cfg.RateLimit.Enabled)
 	if cfg.RateLimit.Enabled {
-		log.Printf("   ├─ Rate Limit: %.0f req/sec (burst: %d, strategy: %s)", 
+		log.Printf("   ├─ Rate Limit: %.0f req/sec (burst: %d, strategy: %s)",
 			cfg.RateLimit.RequestsPerSec, cfg.RateLimit.Burst, cfg.RateLimit.Strategy)
+		if cfg.RateLimit.UserRequestsPerSec > 0 {
+			log.Printf("   ├─ Per-User Rate Limit: %g req/sec (burst: %d)", cfg.RateLimit.UserRequestsPerSec, cfg.RateLimit.UserBurst)
+		}
+		if cfg.RateLimit.PolicyFile != "" {
+			log.Printf("   ├─ Rate Limit Policy: %s (every %s)", cfg.RateLimit.PolicyFile, cfg.RateLimit.PolicyInterval)
+		}
+	}
+	log.Printf("   ├─ Upstream Pool: max_idle=%d per_host=%d idle_timeout=%s tls_session_cache=%d keepalive=%v",
+		cfg.Upstream.MaxIdleConns, cfg.Upstream.MaxIdleConnsPerHost, cfg.Upstream.IdleConnTimeout,
+		cfg.Upstream.TLSSessionCacheSize, !cfg.Upstream.DisableKeepAlives)
+	if len(cfg.Upstream.StaticHosts) > 0 || cfg.Upstream.DoHURL != "" || len(cfg.Upstream.DNSServers) > 0 {
+		log.Printf("   ├─ Upstream DNS: static_hosts=%d doh=%s dns_servers=%v",
+			len(cfg.Upstream.StaticHosts), cfg.Upstream.DoHURL, cfg.Upstream.DNSServers)
+	}
+	log.Printf("   ├─ Upstream TLS: insecure=%v ca_files=%d pins=%d",
+		cfg.Upstream.TLSInsecure, len(cfg.Upstream.TLSCAFiles), len(cfg.Upstream.TLSPinnedSHA256))
+	if cfg.Usage.MonthlySpendLimit > 0 || len(cfg.Usage.SpendLimits) > 0 {
+		log.Printf("   ├─ Spend Limits: default $%.2f/month (per-key overrides: %d)", cfg.Usage.MonthlySpendLimit, len(cfg.Usage.SpendLimits))
+	}
+	if cfg.Usage.SizeAnomalyFactor > 0 {
+		log.Printf("   ├─ Size Anomaly Alerts: %gx baseline (after %d requests, webhook: %v)", cfg.Usage.SizeAnomalyFactor, cfg.Usage.SizeAnomalyMinSamples, cfg.Usage.SizeAlertWebhookURL != "")
+	}
+	if cfg.Usage.DailyBudget > 0 || len(cfg.Usage.DailyModelBudgets) > 0 {
+		log.Printf("   ├─ Daily Budgets: global $%g (per-model: %d, webhook: %v)", cfg.Usage.DailyBudget, len(cfg.Usage.DailyModelBudgets), cfg.Usage.BudgetAlertWebhookURL != "")
+	}
+	if cfg.Usage.BillingWebhookURL != "" || cfg.Usage.ExportCSVPath != "" {
+		log.Printf("   ├─ Usage Export: every %s (webhook: %v, csv: %s)", cfg.Usage.ExportInterval, cfg.Usage.BillingWebhookURL != "", cfg.Usage.ExportCSVPath)
+	}
+	if cfg.Pool.AccountsFile != "" {
+		log.Printf("   ├─ Upstream Accounts: %s (sticky ttl: %s)", cfg.Pool.AccountsFile, cfg.Pool.StickyTTL)
+	}
+	log.Printf("   ├─ Stream Buffers: channel=%d scanner=%d slow_consumer=%s send_timeout=%s json_retries=%d",
+		cfg.Stream.ChannelBuffer, cfg.Stream.ScannerBuffer, cfg.Stream.SlowConsumerPolicy, cfg.Stream.SendTimeout, cfg.Stream.JSONModeRetries)
+	log.Printf("   ├─ Empty Completions: retry=%v action=%s", cfg.Stream.EmptyRetry, cfg.Stream.EmptyAction)
+	if cfg.Stream.MaxFrameBytes > 0 {
+		log.Printf("   ├─ Max SSE Frame: %d bytes", cfg.Stream.MaxFrameBytes)
+	}
+	if cfg.Stream.ToolArgumentChunk > 0 {
+		log.Printf("   ├─ Tool Argument Chunks: %d bytes", cfg.Stream.ToolArgumentChunk)
+	}
+	if cfg.Stream.MaxTokensPerSecond > 0 {
+		log.Printf("   ├─ Stream Pacing: %d tokens/s", cfg.Stream.MaxTokensPerSecond)
+	}
+	if cfg.Stream.MaxPerKey > 0 {
+		log.Printf("   ├─ Max Streams Per Key: %d", cfg.Stream.MaxPerKey)
+	}
+	if cfg.Stream.ResumeWindow > 0 {
+		log.Printf("   ├─ Stream Resume: window=%s buffer=%d bytes", cfg.Stream.ResumeWindow, cfg.Stream.ResumeBufferBytes)
+	}
+	if cfg.Cursor.ResponseModel != ResponseModelResolved {
+		log.Printf("   ├─ Response Model: %s", cfg.Cursor.ResponseModel)
+	}
+	if cfg.Cursor.ToolsMaxJSONBytes > 0 {
+		log.Printf("   ├─ Tool Definitions Limit: %d bytes (strategy: %s)", cfg.Cursor.ToolsMaxJSONBytes, strings.Join(cfg.Cursor.ToolsOversizeStrategy, ","))
+	}
+	if cfg.Shadow.URL != "" {
+		log.Printf("   ├─ Shadow Traffic: %s (sample rate: %.2f, redact fields: %v, patterns: %d)",
+			cfg.Shadow.URL, cfg.Shadow.SampleRate, cfg.Shadow.RedactFields, len(cfg.Shadow.RedactPatterns))
+	}
+	if cfg.Canary.Percent > 0 {
+		log.Printf("   ├─ Canary: %s on %.1f%% of requests (system prompt: %v, chat url: %s, extra headers: %d)",
+			cfg.Canary.Name, cfg.Canary.Percent, cfg.Canary.SystemPrompt != "", cfg.Canary.ChatURL, len(cfg.Canary.ExtraHeaders))
+	}
+	if g := cfg.Guardrail; g.MaxOutputChars > 0 || len(g.BannedSubstrings) > 0 || g.RequiredPrefix != "" || g.RequiredSuffix != "" || g.KeysFile != "" {
+		log.Printf("   ├─ Output Guardrails: max_chars=%d banned=%d prefix=%v suffix=%v keys_file=%s",
+			g.MaxOutputChars, len(g.BannedSubstrings), g.RequiredPrefix != "", g.RequiredSuffix != "", g.KeysFile)
+	}
+	if cfg.Observability.AttemptsHeader != AttemptsHeaderErrors {
+		log.Printf("   ├─ X-Attempts Header: %s", cfg.Observability.AttemptsHeader)
+	}
+	if len(cfg.Observability.SLIWindows) > 0 {
+		log.Printf("   ├─ SLIs: windows=%v target=%g", cfg.Observability.SLIWindows, cfg.Observability.SLOTarget)
+	}
+	if cfg.Observability.OTLPEndpoint != "" {
+		log.Printf("   ├─ OpenTelemetry Tracing: %s (service: %s, sample ratio: %g)",
+			cfg.Observability.OTLPEndpoint, cfg.Observability.ServiceName, cfg.Observability.TraceSampleRatio)
+	}
+	if cfg.Observability.LangfuseHost != "" {
+		log.Printf("   ├─ Langfuse Export: %s (capture content: %v)", cfg.Observability.LangfuseHost, cfg.Observability.CaptureContent)
+	}
+	if cfg.Guidance.URL != "" {
+		log.Printf("   ├─ Model Guidance: %s (every %s)", cfg.Guidance.URL, cfg.Guidance.Interval)
+	}
+	if cfg.Verify.Enabled {
+		log.Printf("   ├─ Self-Verification: enabled (repair model: %s)", cmp.Or(cfg.Verify.Model, "request model"))
+	}
+	if cfg.KeepWarm.Interval > 0 {
+		log.Printf("   ├─ Keep-Warm: every %s (hours: %s)", cfg.KeepWarm.Interval, cmp.Or(cfg.KeepWarm.Hours, "all day"))
+	}
+	if cfg.Storage.Compression != "none" || cfg.Storage.CompactInterval > 0 {
+		log.Printf("   ├─ Storage Compression: %s (transcript compaction interval: %s)", cfg.Storage.Compression, cfg.Storage.CompactInterval)
+	}
+	if len(cfg.Cache.Backends) > 0 {
+		backends := make([]string, 0, len(cfg.Cache.Backends))
+		for use, backend := range cfg.Cache.Backends {
+			backends = append(backends, use+"="+backend)
+		}
+		slices.Sort(backends)
+		log.Printf("   ├─ Cache Backends: %s", strings.Join(backends, ", "))
+	}
+	if cfg.Availability.MaxTokenAge == 0 {
+		log.Printf("   ├─ Stale Token Fallback: disabled (chat returns 503 while the solver is down)")
+	} else if cfg.Availability.MaxTokenAge != 5*time.Minute {
+		log.Printf("   ├─ Stale Token Fallback: up to %s", cfg.Availability.MaxTokenAge)
+	}
+	if cfg.ErrorDocs.Hints {
+		log.Printf("   ├─ Error Hints: enabled (docs: %s)", cmp.Or(cfg.ErrorDocs.DocsURL, "none"))
+	}
+	if cfg.Heartbeat.URL != "" {
+		log.Printf("   ├─ Heartbeat Push: every %s (source: %s, signed: %v)", cfg.Heartbeat.Interval, cfg.Heartbeat.Source, cfg.Heartbeat.Secret != "")
+	}
+	if cfg.Cursor.PromptTemplatesFile != "" {
+		log.Printf("   ├─ Prompt Templates: %s", cfg.Cursor.PromptTemplatesFile)
+	}
+	if cfg.Cursor.PresetsFile != "" {
+		log.Printf("   ├─ Parameter Presets: %s", cfg.Cursor.PresetsFile)
+	}
+	if cfg.Cursor.AutoModelEnabled {
+		log.Printf("   ├─ Auto Model: enabled (rules file: %q, empty = built-in)", cfg.Cursor.AutoModelRulesFile)
 	}
 	log.Printf("   ├─ Process URL: %s", cfg.Cursor.ProcessURL)
 	log.Printf("   ├─ JS URL: %s", cfg.Cursor.JSURL)
+	log.Printf("   ├─ Chat URL: %s (x-path: %s, extra headers: %d)", cfg.Cursor.ChatURL, cfg.Cursor.XPath, len(cfg.Cursor.ExtraHeaders))
+	if len(cfg.Cursor.PassthroughHeaders) > 0 {
+		log.Printf("   ├─ Upstream Passthrough Headers: %v", cfg.Cursor.PassthroughHeaders)
+	}
 	log.Printf("   ├─ Refresh Interval: %s", cfg.Cursor.RefreshInterval)
+	if cfg.Cursor.SharedTokenRedisURL != "" {
+		log.Printf("   ├─ Shared AntiBot Token: enabled (prefix: %s, lock ttl: %s)", cfg.Cursor.SharedTokenKeyPrefix, cfg.Cursor.SharedTokenLockTTL)
+	}
 	log.Printf("   └─ Idle Timeout: %s", cfg.Cursor.IdleTimeout)
 
+	cfg.settings = takeSettings()
 	return cfg
 }
 
 // getEnv retrieves a string environment variable or returns a default value
-func getEnv(key, defaultValue string) string {
+func getEnv(key, defaultValue string) (result string) {
+	defer func() { recordSetting(key, result, defaultValue) }()
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
@@ -124,7 +1017,8 @@ func getEnv(key, defaultValue string) string {
 }
 
 // getBoolEnv retrieves a boolean environment variable or returns a default value
-func getBoolEnv(key string, defaultValue bool) bool {
+func getBoolEnv(key string, defaultValue bool) (result bool) {
+	defer func() { recordSetting(key, result, defaultValue) }()
 	if value := os.Getenv(key); value != "" {
 		boolValue, err := strconv.ParseBool(value)
 		if err != nil {
@@ -136,8 +1030,42 @@ func getBoolEnv(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+// applyLowMemoryProfile lowers the defaults of memory-heavy settings for LOW_MEMORY_MODE.
+// Values set explicitly in the environment are kept, except for the transcript
+// store and the recent completions buffer, which hold whole completions and are
+// always disabled in this mode.
+func applyLowMemoryProfile(cfg *Config) {
+	lower := func(key string, target *int, value int) {
+		if _, ok := os.LookupEnv(key); !ok {
+			*target = value
+		}
+	}
+	lower("STREAM_SCANNER_BUFFER", &cfg.Stream.ScannerBuffer, 256*1024)
+	lower("STREAM_CHANNEL_BUFFER", &cfg.Stream.ChannelBuffer, 2)
+	lower("STREAM_RESUME_BUFFER_BYTES", &cfg.Stream.ResumeBufferBytes, 256*1024)
+	lower("UPSTREAM_MAX_IDLE_CONNS", &cfg.Upstream.MaxIdleConns, 16)
+	lower("UPSTREAM_MAX_IDLE_CONNS_PER_HOST", &cfg.Upstream.MaxIdleConnsPerHost, 4)
+	lower("UPSTREAM_TLS_SESSION_CACHE_SIZE", &cfg.Upstream.TLSSessionCacheSize, 8)
+	lower("ERROR_HISTORY_SIZE", &cfg.Cursor.ErrorHistorySize, 10)
+	lower("SHADOW_MAX_CONCURRENCY", &cfg.Shadow.MaxConcurrency, 2)
+	lower("LANGFUSE_CAPTURE_MAX_BYTES", &cfg.Observability.CaptureMaxBytes, 32*1024)
+	lower("LANGFUSE_BATCH_SIZE", &cfg.Observability.BatchSize, 10)
+	lower("UPSTREAM_CAPTURE_MAX_BYTES", &cfg.Observability.UpstreamCaptureMaxBytes, 128*1024)
+	lower("CACHE_MAX_ENTRIES", &cfg.Cache.MaxEntries, 1000)
+
+	if cfg.Observability.TranscriptDir != "" {
+		log.Println("⚠️  LOW_MEMORY_MODE: transcript store disabled, TRANSCRIPT_DIR is ignored")
+		cfg.Observability.TranscriptDir = ""
+	}
+	if _, ok := os.LookupEnv("RECENT_COMPLETIONS"); ok && cfg.Observability.RecentCompletions > 0 {
+		log.Println("⚠️  LOW_MEMORY_MODE: recent completions buffer disabled, RECENT_COMPLETIONS is ignored")
+	}
+	cfg.Observability.RecentCompletions = 0
+}
+
 // getIntEnv retrieves an integer environment variable or returns a default value
-func getIntEnv(key string, defaultValue int) int {
+func getIntEnv(key string, defaultValue int) (result int) {
+	defer func() { recordSetting(key, result, defaultValue) }()
 	if value := os.Getenv(key); value != "" {
 		intValue, err := strconv.Atoi(value)
 		if err != nil {
@@ -150,7 +1078,8 @@ func getIntEnv(key string, defaultValue int) int {
 }
 
 // getFloatEnv retrieves a float environment variable or returns a default value
-func getFloatEnv(key string, defaultValue float64) float64 {
+func getFloatEnv(key string, defaultValue float64) (result float64) {
+	defer func() { recordSetting(key, result, defaultValue) }()
 	if value := os.Getenv(key); value != "" {
 		floatValue, err := strconv.ParseFloat(value, 64)
 		if err != nil {
@@ -163,7 +1092,8 @@ func getFloatEnv(key string, defaultValue float64) float64 {
 }
 
 // getDurationEnv retrieves a duration environment variable or returns a default value
-func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+func getDurationEnv(key string, defaultValue time.Duration) (result time.Duration) {
+	defer func() { recordSetting(key, result, defaultValue) }()
 	if value := os.Getenv(key); value != "" {
 		duration, err := time.ParseDuration(value)
 		if err != nil {
@@ -176,7 +1106,8 @@ func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 }
 
 // getSliceEnv retrieves a comma-separated string environment variable as a slice
-func getSliceEnv(key string, defaultValue []string) []string {
+func getSliceEnv(key string, defaultValue []string) (result []string) {
+	defer func() { recordSetting(key, result, defaultValue) }()
 	if value := os.Getenv(key); value != "" {
 		items := strings.Split(value, ",")
 		result := make([]string, 0, len(items))
@@ -190,5 +1121,24 @@ func getSliceEnv(key string, defaultValue []string) []string {
 	return defaultValue
 }
 
+// getMapEnv retrieves a comma-separated list of key=value pairs as a map
+func getMapEnv(key string, defaultValue map[string]string) (result map[string]string) {
+	defer func() { recordSetting(key, result, defaultValue) }()
+	if value := os.Getenv(key); value != "" {
+		result := make(map[string]string)
+		for _, item := range strings.Split(value, ",") {
+			k, v, ok := strings.Cut(item, "=")
+			k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+			if !ok || k == "" || v == "" {
+				log.Printf("⚠️  Warning: Invalid key=value pair for %s: %s, skipping", key, item)
+				continue
+			}
+			result[k] = v
+		}
+		return result
+	}
+	return defaultValue
+}
+
 // GlobalConfig is the global configuration instance
-var GlobalConfig *Config
\ No newline at end of file
+var GlobalConfig *Config
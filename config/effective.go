@@ -0,0 +1,224 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sources of a setting reported by GET /admin/config/effective
+const (
+	SourceDefault = "default" // The variable is not set
+	SourceEnv     = "env"     // Set in the process environment
+	SourceDotenv  = ".env"    // Loaded from the .env file
+)
+
+// Masking of secret settings, also used as the value of the secret struct tag
+const (
+	maskValue = "value" // The whole value (each element of lists and maps)
+	maskKeys  = "keys"  // The keys of a map, e.g. API keys mapped to limits
+	maskURL   = "url"   // Credentials, path and query of a URL
+)
+
+// masked replaces secrets in the effective configuration
+const masked = "****"
+
+// secretSettings are the variables holding credentials, by masking
+var secretSettings = map[string]string{
+	"API_KEYS":                 maskValue,
+	"SANDBOX_API_KEYS":         maskValue,
+	"ADMIN_TOKEN":              maskValue,
+	"ADMIN_TOKENS":             maskKeys,
+	"FEATURE_FLAG_GRANTS":      maskKeys,
+	"SPEND_LIMITS":             maskKeys,
+	"RESPONSE_SIGNING_SECRET":  maskValue,
+	"BILLING_WEBHOOK_SECRET":   maskValue,
+	"HEARTBEAT_SECRET":         maskValue,
+	"LANGFUSE_PUBLIC_KEY":      maskValue,
+	"LANGFUSE_SECRET_KEY":      maskValue,
+	"CURSOR_EXTRA_HEADERS":     maskValue,
+	"STREAM_EXTRA_HEADERS":     maskValue,
+	"CANARY_EXTRA_HEADERS":     maskValue,
+	"SHADOW_HEADERS":           maskValue,
+	"ANTIBOT_SHARED_REDIS_URL": maskURL,
+	"CACHE_REDIS_URL":          maskURL,
+	"BILLING_WEBHOOK_URL":      maskURL,
+	"BUDGET_ALERT_WEBHOOK_URL": maskURL,
+	"SIZE_ALERT_WEBHOOK_URL":   maskURL,
+	"HEARTBEAT_URL":            maskURL,
+	"SHADOW_URL":               maskURL,
+	"CANARY_CHAT_URL":          maskURL,
+
+	// Collectors often take credentials in the endpoint or the export headers
+	"OTEL_EXPORTER_OTLP_ENDPOINT": maskURL,
+	"OTEL_EXPORTER_OTLP_HEADERS":  maskValue,
+}
+
+// Setting is one environment variable as resolved by Load. Value is the parsed
+// value (the default when the variable is unset or invalid); validation may still
+// adjust it, as the resolved configuration shows.
+type Setting struct {
+	Key     string      `json:"key"`
+	Value   interface{} `json:"value"`
+	Default interface{} `json:"default"`
+	Raw     string      `json:"raw,omitempty"` // The variable as set, when it is set
+	Source  string      `json:"source"`
+}
+
+// Overridden reports whether the variable is set, whether or not it parsed
+func (s Setting) Overridden() bool {
+	return s.Source != SourceDefault
+}
+
+var (
+	// processEnv holds the variables set before the .env file was loaded (nil = unknown)
+	processEnv map[string]bool
+
+	settingsMu sync.Mutex
+	settings   map[string]Setting // Recorded by the get*Env helpers during Load
+)
+
+// CaptureProcessEnv remembers the variables of the process environment; call it
+// before loading the .env file so settings from that file are reported as such
+func CaptureProcessEnv() {
+	processEnv = make(map[string]bool)
+	for _, entry := range os.Environ() {
+		key, _, _ := strings.Cut(entry, "=")
+		processEnv[key] = true
+	}
+}
+
+// recordSetting records the value a get*Env helper resolved for key
+func recordSetting(key string, value, defaultValue interface{}) {
+	setting := Setting{Key: key, Value: settingValue(value), Default: settingValue(defaultValue), Source: SourceDefault}
+	if raw := os.Getenv(key); raw != "" {
+		setting.Raw, setting.Source = raw, SourceEnv
+		if processEnv != nil && !processEnv[key] {
+			setting.Source = SourceDotenv
+		}
+	}
+	if mode, ok := secretSettings[key]; ok {
+		setting.Value = maskSetting(setting.Value, mode)
+		setting.Default = maskSetting(setting.Default, mode)
+		if setting.Raw != "" {
+			setting.Raw = masked
+		}
+	}
+
+	settingsMu.Lock()
+	if settings == nil {
+		settings = make(map[string]Setting)
+	}
+	settings[key] = setting
+	settingsMu.Unlock()
+}
+
+// settingValue renders durations the way they are configured
+func settingValue(v interface{}) interface{} {
+	if d, ok := v.(time.Duration); ok {
+		return d.String()
+	}
+	return v
+}
+
+// maskSetting masks a recorded value
+func maskSetting(v interface{}, mode string) interface{} {
+	switch v := v.(type) {
+	case string:
+		return maskString(v, mode)
+	case []string:
+		out := make([]string, len(v))
+		for i, item := range v {
+			out[i] = maskString(item, mode)
+		}
+		return out
+	case map[string]string:
+		return maskMap(v, mode)
+	}
+	return v
+}
+
+// maskString masks a secret string; empty strings stay empty
+func maskString(s, mode string) string {
+	if s == "" {
+		return ""
+	}
+	if mode == maskURL {
+		u, err := url.Parse(s)
+		if err != nil || u.Host == "" {
+			return masked
+		}
+		// Credentials, webhook tokens in the path and query parameters are all hidden
+		base := u.Scheme + "://" + u.Host
+		if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			base += "/" + masked
+		}
+		return base
+	}
+	return masked
+}
+
+// maskMap masks the values, or with maskKeys the keys, of a map
+func maskMap(m map[string]string, mode string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		if mode == maskKeys {
+			// Keep enough of the key to tell entries apart
+			k = k[:min(4, len(k))] + masked
+		} else {
+			v = maskString(v, mode)
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// Settings returns the environment variables read by Load, sorted by name
+func (c *Config) Settings() []Setting {
+	list := make([]Setting, 0, len(c.settings))
+	for _, setting := range c.settings {
+		list = append(list, setting)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list
+}
+
+// takeSettings returns the settings recorded since the previous call
+func takeSettings() map[string]Setting {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	recorded := settings
+	settings = nil
+	return recorded
+}
+
+// Masked returns the resolved configuration as a JSON-ready tree with the fields
+// tagged secret masked
+func (c *Config) Masked() map[string]interface{} {
+	return maskStruct(reflect.ValueOf(*c))
+}
+
+// maskStruct converts a configuration struct, masking fields tagged secret
+func maskStruct(v reflect.Value) map[string]interface{} {
+	out := make(map[string]interface{}, v.NumField())
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		value := v.Field(i)
+		switch mode := field.Tag.Get("secret"); {
+		case mode != "":
+			out[field.Name] = maskSetting(value.Interface(), mode)
+		case value.Kind() == reflect.Struct && value.Type() != reflect.TypeOf(time.Time{}):
+			out[field.Name] = maskStruct(value)
+		default:
+			out[field.Name] = settingValue(value.Interface())
+		}
+	}
+	return out
+}
@@ -0,0 +1,77 @@
+// Package errdocs attaches an actionable hint and a documentation link to error
+// payloads, so integrators can fix a rejected request without opening a support
+// ticket. Both are omitted until Configure enables them (ERROR_HINTS_ENABLED).
+package errdocs
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+type settings struct {
+	docsURL string
+}
+
+// current is nil while hints are disabled
+var current atomic.Pointer[settings]
+
+// hints are the generic hints by error code; call sites pass a more specific hint
+// when they know the request (e.g. the models a key may use)
+var hints = map[string]string{
+	"missing_api_key":          "Send your API key in the Authorization header: 'Authorization: Bearer <API_KEY>'.",
+	"invalid_format":           "Use the Bearer scheme: 'Authorization: Bearer <API_KEY>'.",
+	"invalid_api_key":          "Check the key for typos or truncation; ask the operator for a new key if it was rotated.",
+	"rate_limit_exceeded":      "Wait for the number of seconds in the Retry-After header, then spread requests out or lower the concurrency.",
+	"too_many_streams":         "Wait for one of your open streams to finish, or end one with POST /v1/chat/completions/{id}/cancel.",
+	"resume_token_invalid":     "Reconnect within STREAM_RESUME_WINDOW with the same API key, or send the request again without X-Resume-Token.",
+	"resume_gap":               "Send the request again without X-Resume-Token; Last-Event-ID points before the oldest buffered event.",
+	"spend_limit_exceeded":     "Ask the operator to raise the monthly spend limit of your key, or wait for the next month.",
+	"model_not_found":          "List the models available to your key with GET /v1/models.",
+	"banned":                   "Contact the operator of this service to lift the suspension.",
+	"unknown_tenant":           "Check the tenant header, or omit it to use the tenant your key is bound to.",
+	"tenant_mismatch":          "Omit the tenant header: your key is bound to another tenant.",
+	"unknown_feature":          "Remove the unknown names from X-C2A-Features; the error message lists the known ones.",
+	"feature_not_allowed":      "Ask the operator to grant the experimental feature to your key, or remove it from X-C2A-Features.",
+	"prompt_not_found":         "Check prompt_id; prompt templates are defined by the operator of this service.",
+	"invalid_prompt_variables": "Pass every {{name}} the template uses in variables.",
+	"completion_not_found":     "The completion already finished, or it was started with a different API key.",
+	"request_cancelled":        "The generation was cancelled on request; send the request again to regenerate it.",
+	"upstream_aborted":         "The upstream ended the generation early; retrying usually succeeds.",
+	"empty_completion":         "The model returned nothing; retry the request or rephrase the prompt.",
+	"json_mode_violation":      "Describe the expected JSON object in the prompt, or set \"self_verify\": true on non-streaming requests.",
+	"json_schema_violation":    "Describe the fields of the schema in the prompt, relax the schema, or set \"self_verify\": true on non-streaming requests.",
+	"invalid_response_format":  "Use response_format type text, json_object or json_schema; json_schema needs a name and a schema object.",
+	"tools_too_large":          "Send fewer tools, or shorten their descriptions and parameter schemas; the message shows the size and the limit.",
+	"server_shutting_down":     "Retry the request; it will be served by another instance or after the restart.",
+	"token_unavailable":        "Retry after the number of seconds in the Retry-After header; GET /health shows the availability stage.",
+	"unknown_url":              "Check the path: the OpenAI-compatible endpoints are under /v1, e.g. POST /v1/chat/completions.",
+	"not_found":                "Check the path: the OpenAI-compatible endpoints are under /v1, e.g. POST /v1/chat/completions.",
+}
+
+// Configure enables hints; docsURL (optional) is the page documenting the error
+// codes, linked as docsURL#<code>
+func Configure(enabled bool, docsURL string) {
+	if !enabled {
+		current.Store(nil)
+		return
+	}
+	current.Store(&settings{docsURL: strings.TrimSuffix(docsURL, "#")})
+}
+
+// Annotate returns the hint and the documentation link of an error code. hint
+// replaces the generic hint of the code when set. Both are empty while hints are
+// disabled; the link is empty for errors without a code or without a docs URL.
+func Annotate(code, hint string) (string, string) {
+	s := current.Load()
+	if s == nil {
+		return "", ""
+	}
+	if hint == "" {
+		hint = hints[code]
+	}
+	var docURL string
+	if s.docsURL != "" && code != "" {
+		docURL = s.docsURL + "#" + code
+	}
+	return hint, docURL
+}
@@ -0,0 +1,33 @@
+package errdocs
+
+import "testing"
+
+func TestAnnotate(t *testing.T) {
+	t.Cleanup(func() { Configure(false, "") })
+
+	if hint, docURL := Annotate("invalid_api_key", ""); hint != "" || docURL != "" {
+		t.Errorf("disabled: hint=%q doc_url=%q, want both empty", hint, docURL)
+	}
+
+	Configure(true, "")
+	if hint, docURL := Annotate("invalid_api_key", ""); hint != hints["invalid_api_key"] || docURL != "" {
+		t.Errorf("without docs URL: hint=%q doc_url=%q", hint, docURL)
+	}
+
+	Configure(true, "https://example.com/errors.md#")
+	tests := []struct {
+		code, hint           string
+		wantHint, wantDocURL string
+	}{
+		{"model_not_found", "", hints["model_not_found"], "https://example.com/errors.md#model_not_found"},
+		{"model_not_found", "Allowed: [a b]", "Allowed: [a b]", "https://example.com/errors.md#model_not_found"},
+		{"some_new_code", "", "", "https://example.com/errors.md#some_new_code"},
+		{"", "", "", ""},
+	}
+	for _, tt := range tests {
+		hint, docURL := Annotate(tt.code, tt.hint)
+		if hint != tt.wantHint || docURL != tt.wantDocURL {
+			t.Errorf("Annotate(%q, %q) = %q, %q; want %q, %q", tt.code, tt.hint, hint, docURL, tt.wantHint, tt.wantDocURL)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Package features implements request-scoped feature flags. Callers opt into
+// experimental behaviors per request with the X-C2A-Features header, so features
+// can ship dark and be enabled client by client. Only API keys granted a feature
+// (FEATURE_FLAG_GRANTS) may turn it on.
+package features
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"cursor2api/logger"
+	"cursor2api/metrics"
+)
+
+// Header lists the features a request opts into, comma-separated
+const Header = "X-C2A-Features"
+
+// Experimental features that can be enabled per request
+const (
+	ReasoningContent = "reasoning_content" // Stream the upstream's reasoning as delta.reasoning_content
+)
+
+// known lists every feature the header accepts
+var known = []string{ReasoningContent}
+
+var featureRequests = metrics.NewCounter(
+	"cursor2api_feature_flag_requests_total",
+	"Requests that enabled an experimental feature through the X-C2A-Features header.",
+	"feature")
+
+// Set is the set of features enabled for a request; a nil set enables nothing
+type Set map[string]bool
+
+// Parse parses the header value; unknown feature names are an error
+func Parse(header string) (Set, error) {
+	var set Set
+	for _, name := range strings.Split(header, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(known, name) {
+			return nil, fmt.Errorf("unknown feature %q in %s (known: %s)", name, Header, strings.Join(known, ", "))
+		}
+		if set == nil {
+			set = make(Set)
+		}
+		set[name] = true
+	}
+	return set, nil
+}
+
+// String returns the features in sorted, comma-separated form
+func (s Set) String() string {
+	return strings.Join(slices.Sorted(maps.Keys(s)), ",")
+}
+
+// Grants holds which API keys may enable which features
+type Grants struct {
+	byKey map[string]Set
+}
+
+// NewGrants builds the grants from api_key=features pairs, where features is "*" for
+// every feature or a "|"-separated list. The key "*" applies to every request,
+// including requests without an API key when auth is disabled.
+func NewGrants(pairs map[string]string) *Grants {
+	g := &Grants{byKey: make(map[string]Set, len(pairs))}
+	for key, value := range pairs {
+		set := make(Set)
+		for _, name := range strings.Split(value, "|") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			switch {
+			case name == "*":
+				for _, feature := range known {
+					set[feature] = true
+				}
+			case slices.Contains(known, name):
+				set[name] = true
+			default:
+				logger.Warn("Unknown feature in grant, skipping | feature=%s", name)
+			}
+		}
+		g.byKey[key] = set
+	}
+	return g
+}
+
+// Check returns the first requested feature the API key may not enable, or ""
+func (g *Grants) Check(apiKey string, requested Set) string {
+	for _, name := range slices.Sorted(maps.Keys(requested)) {
+		if !g.byKey[apiKey][name] && !g.byKey["*"][name] {
+			return name
+		}
+	}
+	return ""
+}
+
+// Len returns the number of keys with grants
+func (g *Grants) Len() int {
+	return len(g.byKey)
+}
+
+// contextKey is the private context key for the request's feature set
+type contextKey struct{}
+
+// WithSet returns a context carrying the enabled features and counts them
+func WithSet(ctx context.Context, set Set) context.Context {
+	if len(set) == 0 {
+		return ctx
+	}
+	for name := range set {
+		featureRequests.Inc(name)
+	}
+	return context.WithValue(ctx, contextKey{}, set)
+}
+
+// Enabled reports whether the request enabled the feature
+func Enabled(ctx context.Context, name string) bool {
+	set, _ := ctx.Value(contextKey{}).(Set)
+	return set[name]
+}
@@ -0,0 +1,67 @@
+package features
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	set, err := Parse(" reasoning_content, Reasoning_Content ,,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.String() != "reasoning_content" {
+		t.Errorf("set = %s", set)
+	}
+	if set, err := Parse(""); err != nil || set != nil {
+		t.Errorf("empty header = %v, %v", set, err)
+	}
+	if _, err := Parse("reasoning_content,time_travel"); err == nil {
+		t.Error("unknown feature should fail")
+	}
+}
+
+func TestGrants_Check(t *testing.T) {
+	g := NewGrants(map[string]string{
+		"sk-beta":   "*",
+		"sk-reason": "reasoning_content|bogus",
+	})
+	tests := []struct {
+		key    string
+		header string
+		denied string
+	}{
+		{"sk-beta", "reasoning_content", ""},
+		{"sk-reason", "reasoning_content", ""},
+		{"sk-other", "", ""},
+		{"sk-other", "reasoning_content", "reasoning_content"},
+		{"", "reasoning_content", "reasoning_content"},
+	}
+	for _, tt := range tests {
+		set, err := Parse(tt.header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if denied := g.Check(tt.key, set); denied != tt.denied {
+			t.Errorf("Check(%s, %s) = %q, want %q", tt.key, tt.header, denied, tt.denied)
+		}
+	}
+
+	// The key "*" grants to every request, including those without an API key
+	everyone := NewGrants(map[string]string{"*": "reasoning_content"})
+	for _, key := range []string{"sk-other", ""} {
+		if denied := everyone.Check(key, Set{ReasoningContent: true}); denied != "" {
+			t.Errorf("Check(%q) = %q with a grant to everyone", key, denied)
+		}
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	ctx := context.Background()
+	if Enabled(ctx, ReasoningContent) {
+		t.Error("no features without a set")
+	}
+	if !Enabled(WithSet(ctx, Set{ReasoningContent: true}), ReasoningContent) || Enabled(WithSet(ctx, Set{}), ReasoningContent) {
+		t.Error("Enabled does not follow the set")
+	}
+}
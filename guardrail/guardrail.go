@@ -0,0 +1,290 @@
+// Package guardrail post-processes generated text before it reaches the client:
+// banned substrings are redacted, the output is cut off after a maximum number of
+// characters and a required prefix/suffix is enforced. Filters work on streamed
+// chunks, so a banned substring split across two chunks is still caught.
+package guardrail
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"cursor2api/config"
+	"cursor2api/logger"
+	"cursor2api/metrics"
+)
+
+var guardrailActions = metrics.NewCounter(
+	"cursor2api_guardrail_actions_total",
+	"Completions changed by an output guardrail, by action (redacted, truncated, prefixed, suffixed).",
+	"action")
+
+// Policy is the set of guardrails applied to one completion
+type Policy struct {
+	MaxOutputChars   int      `json:"max_output_chars"`
+	BannedSubstrings []string `json:"banned_substrings"`
+	Redaction        string   `json:"redaction"`
+	RequiredPrefix   string   `json:"required_prefix"`
+	RequiredSuffix   string   `json:"required_suffix"`
+
+	banned *regexp.Regexp
+	hold   int // bytes held back so a banned substring split across chunks is still found
+}
+
+// compile prepares the banned substring matcher; returns false when the policy changes nothing
+func (p *Policy) compile(defaultRedaction string) bool {
+	if p.Redaction == "" {
+		p.Redaction = defaultRedaction
+	}
+
+	var patterns []string
+	for _, s := range p.BannedSubstrings {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		patterns = append(patterns, regexp.QuoteMeta(s))
+		p.hold = max(p.hold, len(s)-1)
+	}
+	if len(patterns) > 0 {
+		p.banned = regexp.MustCompile("(?i)" + strings.Join(patterns, "|"))
+	}
+	return p.banned != nil || p.MaxOutputChars > 0 || p.RequiredPrefix != "" || p.RequiredSuffix != ""
+}
+
+// Set holds the default policy and the per-key overrides
+type Set struct {
+	defaults *Policy
+	keys     map[string]*Policy
+}
+
+// New creates the guardrail set; returns nil when no guardrail is configured
+func New(cfg config.GuardrailConfig) *Set {
+	s := &Set{keys: make(map[string]*Policy)}
+
+	defaults := &Policy{
+		MaxOutputChars:   cfg.MaxOutputChars,
+		BannedSubstrings: cfg.BannedSubstrings,
+		Redaction:        cfg.Redaction,
+		RequiredPrefix:   cfg.RequiredPrefix,
+		RequiredSuffix:   cfg.RequiredSuffix,
+	}
+	if defaults.compile(cfg.Redaction) {
+		s.defaults = defaults
+	}
+
+	if cfg.KeysFile != "" {
+		keys, err := loadKeys(cfg.KeysFile)
+		if err != nil {
+			logger.Warn("Failed to load per-key guardrails, using the defaults for every key | file=%s error=%v", cfg.KeysFile, err)
+		}
+		for key, p := range keys {
+			if !p.compile(cfg.Redaction) {
+				p = nil // listed with an empty policy: no guardrails for this key
+			}
+			s.keys[key] = p
+		}
+	}
+
+	if s.defaults == nil && len(s.keys) == 0 {
+		return nil
+	}
+	logger.Info("Output guardrails enabled | defaults=%v per_key=%d", s.defaults != nil, len(s.keys))
+	return s
+}
+
+// loadKeys reads the api_key -> policy file
+func loadKeys(path string) (map[string]*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys map[string]*Policy
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse guardrail keys file: %w", err)
+	}
+	return keys, nil
+}
+
+// For returns the policy of an API key, or nil when no guardrail applies
+func (s *Set) For(apiKey string) *Policy {
+	if s == nil {
+		return nil
+	}
+	if p, ok := s.keys[apiKey]; ok && apiKey != "" {
+		return p
+	}
+	return s.defaults
+}
+
+// Filter applies a policy to one completion, chunk by chunk
+type Filter struct {
+	p *Policy
+
+	pending    string // text held back for banned substring matching
+	emitted    int    // characters of generated text let through
+	truncated  bool
+	redactions int
+
+	head       string // output held back until the required prefix is decided
+	prefixDone bool
+	tail       string // end of the output, checked against the required suffix
+}
+
+// NewFilter starts filtering a completion; returns nil for a nil policy, which passes text through
+func (p *Policy) NewFilter() *Filter {
+	if p == nil {
+		return nil
+	}
+	return &Filter{p: p}
+}
+
+// Apply filters a complete (non-stream) text; reports whether it was truncated
+func (p *Policy) Apply(text string) (string, bool) {
+	f := p.NewFilter()
+	out := f.Write(text)
+	out += f.Finish()
+	return out, f.Truncated()
+}
+
+// Write filters a chunk and returns the text that may be sent now; the result
+// can be empty while text is held back. Input after truncation is discarded.
+func (f *Filter) Write(chunk string) string {
+	if f == nil {
+		return chunk
+	}
+	if f.truncated {
+		return ""
+	}
+	f.pending += chunk
+	return f.emit(f.redact(false))
+}
+
+// Finish releases the held back text and the enforced suffix; call once at the end of the output
+func (f *Filter) Finish() string {
+	if f == nil {
+		return ""
+	}
+	var out string
+	if !f.truncated {
+		out = f.limit(f.redact(true))
+	}
+	out = f.prefix(out, true)
+	if suffix := f.p.RequiredSuffix; suffix != "" && !strings.HasSuffix(f.tail+out, suffix) {
+		out += suffix
+		guardrailActions.Inc("suffixed")
+	}
+
+	if f.redactions > 0 {
+		guardrailActions.Inc("redacted")
+	}
+	if f.truncated {
+		guardrailActions.Inc("truncated")
+	}
+	return out
+}
+
+// Truncated reports whether the output hit the maximum length; the caller should end
+// the completion with finish_reason "length"
+func (f *Filter) Truncated() bool {
+	return f != nil && f.truncated
+}
+
+// emit runs released text through the length limit and prefix stages
+func (f *Filter) emit(s string) string {
+	out := f.prefix(f.limit(s), false)
+	if suffix := f.p.RequiredSuffix; suffix != "" && out != "" {
+		f.tail += out
+		if len(f.tail) > len(suffix) {
+			f.tail = f.tail[len(f.tail)-len(suffix):]
+		}
+	}
+	return out
+}
+
+// redact replaces banned substrings in the pending text and releases it, keeping
+// back the tail that could be the start of a banned substring unless final is set
+func (f *Filter) redact(final bool) string {
+	if f.p.banned == nil {
+		out := f.pending
+		f.pending = ""
+		return out
+	}
+
+	locs := f.p.banned.FindAllStringIndex(f.pending, -1)
+	cut := len(f.pending)
+	if !final {
+		cut = max(0, len(f.pending)-f.p.hold)
+		for cut > 0 && cut < len(f.pending) && !utf8.RuneStart(f.pending[cut]) {
+			cut--
+		}
+		// Never split a match
+		for _, loc := range locs {
+			if loc[0] < cut && loc[1] > cut {
+				cut = loc[1]
+			}
+		}
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, loc := range locs {
+		if loc[1] > cut {
+			break
+		}
+		b.WriteString(f.pending[last:loc[0]])
+		b.WriteString(f.p.Redaction)
+		last = loc[1]
+		f.redactions++
+	}
+	b.WriteString(f.pending[last:cut])
+	f.pending = f.pending[cut:]
+	return b.String()
+}
+
+// limit cuts the text off at the maximum output length
+func (f *Filter) limit(s string) string {
+	if f.p.MaxOutputChars <= 0 || s == "" {
+		return s
+	}
+	remaining := f.p.MaxOutputChars - f.emitted
+	n := utf8.RuneCountInString(s)
+	if n <= remaining {
+		f.emitted += n
+		return s
+	}
+
+	f.truncated = true
+	f.pending = ""
+	f.emitted = f.p.MaxOutputChars
+	i := 0
+	for range remaining {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	return s[:i]
+}
+
+// prefix holds the start of the output until it is known whether it carries the
+// required prefix, then prepends the prefix if it does not
+func (f *Filter) prefix(s string, final bool) string {
+	required := f.p.RequiredPrefix
+	if required == "" || f.prefixDone {
+		return s
+	}
+	f.head += s
+	if !final && len(f.head) < len(required) && strings.HasPrefix(required, f.head) {
+		return ""
+	}
+
+	f.prefixDone = true
+	out := f.head
+	f.head = ""
+	if !strings.HasPrefix(out, required) {
+		out = required + out
+		guardrailActions.Inc("prefixed")
+	}
+	return out
+}
@@ -0,0 +1,112 @@
+package guardrail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cursor2api/config"
+)
+
+// stream feeds the chunks through a filter and returns the full output
+func stream(p *Policy, chunks ...string) (string, bool) {
+	f := p.NewFilter()
+	var b strings.Builder
+	for _, c := range chunks {
+		b.WriteString(f.Write(c))
+	}
+	b.WriteString(f.Finish())
+	return b.String(), f.Truncated()
+}
+
+func newPolicy(t *testing.T, cfg config.GuardrailConfig) *Policy {
+	t.Helper()
+	if cfg.Redaction == "" {
+		cfg.Redaction = "[redacted]"
+	}
+	s := New(cfg)
+	if s == nil {
+		t.Fatal("guardrails should be enabled")
+	}
+	return s.For("")
+}
+
+func TestFilter_RedactsAcrossChunks(t *testing.T) {
+	p := newPolicy(t, config.GuardrailConfig{BannedSubstrings: []string{"secret-token", "bad"}})
+
+	got, _ := stream(p, "the SECRET-to", "ken is ba", "d, really")
+	if want := "the [redacted] is [redacted], really"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestFilter_MaxOutputChars(t *testing.T) {
+	p := newPolicy(t, config.GuardrailConfig{MaxOutputChars: 5})
+
+	f := p.NewFilter()
+	if got := f.Write("héllo"); got != "héllo" || f.Truncated() {
+		t.Fatalf("first write = %q, truncated = %v", got, f.Truncated())
+	}
+	if got := f.Write(" world"); got != "" || !f.Truncated() {
+		t.Fatalf("second write = %q, truncated = %v", got, f.Truncated())
+	}
+	if got := f.Write("more"); got != "" {
+		t.Fatalf("write after truncation = %q", got)
+	}
+
+	got, truncated := p.Apply("ab")
+	if got != "ab" || truncated {
+		t.Fatalf("short text = %q, truncated = %v", got, truncated)
+	}
+}
+
+func TestFilter_PrefixAndSuffix(t *testing.T) {
+	p := newPolicy(t, config.GuardrailConfig{RequiredPrefix: "Answer: ", RequiredSuffix: "\n--"})
+
+	cases := []struct {
+		chunks []string
+		want   string
+	}{
+		{[]string{"Ans", "wer: 42\n--"}, "Answer: 42\n--"},
+		{[]string{"An", "swer", " is 42"}, "Answer: Answer is 42\n--"},
+		{[]string{"42"}, "Answer: 42\n--"},
+		{nil, "Answer: \n--"},
+	}
+	for _, c := range cases {
+		if got, _ := stream(p, c.chunks...); got != c.want {
+			t.Errorf("%q: got %q, want %q", c.chunks, got, c.want)
+		}
+	}
+}
+
+func TestSet_PerKeyPolicies(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "guardrails.json")
+	data := `{"sk-strict": {"max_output_chars": 3}, "sk-free": {}}`
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(config.GuardrailConfig{RequiredSuffix: "!", Redaction: "[redacted]", KeysFile: file})
+
+	if got, _ := s.For("sk-other").Apply("hi"); got != "hi!" {
+		t.Fatalf("default policy: got %q", got)
+	}
+	if got, truncated := s.For("sk-strict").Apply("hello"); got != "hel" || !truncated {
+		t.Fatalf("strict key: got %q, truncated = %v", got, truncated)
+	}
+	if p := s.For("sk-free"); p != nil {
+		t.Fatal("a key listed with an empty policy must not be filtered")
+	}
+}
+
+func TestNew_Disabled(t *testing.T) {
+	s := New(config.GuardrailConfig{Redaction: "[redacted]"})
+	if s != nil {
+		t.Fatal("no guardrails configured, set should be nil")
+	}
+	f := s.For("sk-any").NewFilter()
+	if got := f.Write("text"); got != "text" || f.Finish() != "" || f.Truncated() {
+		t.Fatal("nil filter must pass text through")
+	}
+}
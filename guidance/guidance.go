@@ -0,0 +1,250 @@
+// Package guidance keeps per-model guidance published upstream (a default system
+// prompt, a description and the context window of each model) in sync on a
+// schedule, so behavior follows upstream expectations without config edits.
+// The last good document stays in effect when a fetch fails.
+package guidance
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"cursor2api/config"
+	"cursor2api/logger"
+	"cursor2api/metrics"
+	"cursor2api/types"
+)
+
+// maxDocumentSize bounds the guidance document read from upstream
+const maxDocumentSize = 1 << 20
+
+// retryDelay is the delay before retrying a failed fetch, capped at the interval
+const retryDelay = time.Minute
+
+var fetches = metrics.NewCounter(
+	"cursor2api_model_guidance_fetches_total",
+	"Model guidance fetches by result (updated, not_modified or error).",
+	"result")
+
+// Model is the guidance published for one model
+type Model struct {
+	SystemPrompt  string `json:"system_prompt,omitempty"` // Used when the request has no system message
+	Description   string `json:"description,omitempty"`
+	ContextWindow int    `json:"context_window,omitempty"`
+}
+
+// Document is the guidance document: model ID -> guidance, where "*" applies to
+// models without an entry of their own
+type Document struct {
+	Models map[string]Model `json:"models"`
+}
+
+// Fetcher fetches the guidance document until Stop
+type Fetcher struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+
+	mu     sync.RWMutex
+	doc    Document
+	etag   string
+	status types.ModelGuidanceStatus
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// New creates the fetcher; returns nil when no URL is configured.
+// Call Start to begin fetching.
+func New(cfg config.GuidanceConfig) *Fetcher {
+	if cfg.URL == "" {
+		return nil
+	}
+	return &Fetcher{
+		url:      cfg.URL,
+		interval: cfg.Interval,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start fetches the document immediately and then once every interval
+func (f *Fetcher) Start() {
+	if f == nil {
+		return
+	}
+	f.wg.Add(1)
+	go f.loop()
+	logger.Info("Model guidance fetcher started | url=%s interval=%v", f.url, f.interval)
+}
+
+// Stop stops fetching; a nil fetcher is a no-op
+func (f *Fetcher) Stop() {
+	if f == nil {
+		return
+	}
+	f.stopOnce.Do(func() {
+		close(f.stopChan)
+		f.wg.Wait()
+	})
+}
+
+// loop fetches whenever the timer fires, rescheduling after each attempt
+func (f *Fetcher) loop() {
+	defer f.wg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			timer.Reset(f.refresh(time.Now()))
+		case <-f.stopChan:
+			return
+		}
+	}
+}
+
+// refresh fetches the document once, records the outcome and returns the delay
+// until the next attempt
+func (f *Fetcher) refresh(now time.Time) time.Duration {
+	f.mu.RLock()
+	etag := f.etag
+	f.mu.RUnlock()
+
+	doc, newETag, modified, err := f.fetch(etag)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status.LastAttempt = &now
+	if err != nil {
+		fetches.Inc("error")
+		f.status.Failed++
+		f.status.LastError = err.Error()
+		logger.Warn("Model guidance fetch failed, keeping previous guidance | models=%d error=%v", len(f.doc.Models), err)
+		return min(retryDelay, f.interval)
+	}
+
+	f.status.Fetched++
+	f.status.LastError = ""
+	if !modified {
+		fetches.Inc("not_modified")
+		return f.interval
+	}
+	fetches.Inc("updated")
+	f.doc = doc
+	f.etag = newETag
+	f.status.Models = len(doc.Models)
+	f.status.LastUpdate = &now
+	logger.Info("Model guidance updated | models=%d", len(doc.Models))
+	return f.interval
+}
+
+// fetch downloads the document, sending If-None-Match when a previous ETag is known;
+// modified is false when the server answers 304
+func (f *Fetcher) fetch(etag string) (doc Document, newETag string, modified bool, err error) {
+	req, err := http.NewRequest(http.MethodGet, f.url, nil)
+	if err != nil {
+		return Document{}, "", false, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return Document{}, "", false, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return Document{}, etag, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Document{}, "", false, fmt.Errorf("endpoint returned HTTP %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDocumentSize+1))
+	if err != nil {
+		return Document{}, "", false, fmt.Errorf("failed to read document: %w", err)
+	}
+	if len(body) > maxDocumentSize {
+		return Document{}, "", false, fmt.Errorf("document exceeds %d bytes", maxDocumentSize)
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return Document{}, "", false, fmt.Errorf("failed to parse document: %w", err)
+	}
+	if len(doc.Models) == 0 {
+		return Document{}, "", false, fmt.Errorf("document has no models")
+	}
+	return doc, resp.Header.Get("ETag"), true, nil
+}
+
+// Lookup returns the guidance for model, falling back to the "*" entry
+func (f *Fetcher) Lookup(model string) (Model, bool) {
+	if f == nil {
+		return Model{}, false
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if m, ok := f.doc.Models[model]; ok {
+		return m, true
+	}
+	m, ok := f.doc.Models["*"]
+	return m, ok
+}
+
+// Apply adds the model's guidance system prompt when the request has no system
+// message. Returns whether a prompt was added.
+func (f *Fetcher) Apply(req *types.ChatCompletionRequest) bool {
+	m, ok := f.Lookup(req.Model)
+	if !ok || m.SystemPrompt == "" {
+		return false
+	}
+	for _, msg := range req.Messages {
+		if msg.Role == "system" {
+			return false
+		}
+	}
+	messages := make([]types.ChatMessage, 0, len(req.Messages)+1)
+	messages = append(messages, types.ChatMessage{Role: "system", Content: m.SystemPrompt})
+	req.Messages = append(messages, req.Messages...)
+	return true
+}
+
+// Annotate fills the description and, unless the catalog set one, the context window
+// of the listed models that have an entry of their own; the "*" entry only provides
+// a system prompt
+func (f *Fetcher) Annotate(models []types.Model) {
+	if f == nil {
+		return
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for i := range models {
+		m, ok := f.doc.Models[models[i].ID]
+		if !ok {
+			continue
+		}
+		models[i].Description = m.Description
+		models[i].ContextWindow = cmp.Or(models[i].ContextWindow, m.ContextWindow)
+	}
+}
+
+// Status returns the fetch status, or nil when the fetcher is disabled
+func (f *Fetcher) Status() *types.ModelGuidanceStatus {
+	if f == nil {
+		return nil
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	status := f.status
+	return &status
+}
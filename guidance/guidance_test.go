@@ -0,0 +1,120 @@
+package guidance
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cursor2api/config"
+	"cursor2api/types"
+)
+
+const document = `{"models": {
+	"openai/gpt-5": {"system_prompt": "Be concise.", "description": "Reasoning model", "context_window": 400000},
+	"*": {"system_prompt": "Follow the user's instructions."}
+}}`
+
+func TestFetcher_Refresh(t *testing.T) {
+	var (
+		requests    int
+		ifNoneMatch string
+		fail        bool
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		ifNoneMatch = r.Header.Get("If-None-Match")
+		switch {
+		case fail:
+			w.WriteHeader(http.StatusBadGateway)
+		case ifNoneMatch == `"v1"`:
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.Header().Set("ETag", `"v1"`)
+			_, _ = w.Write([]byte(document))
+		}
+	}))
+	defer server.Close()
+
+	f := New(config.GuidanceConfig{URL: server.URL, Interval: time.Hour})
+	now := time.Now()
+
+	if delay := f.refresh(now); delay != time.Hour {
+		t.Errorf("delay after update = %v, want 1h", delay)
+	}
+	if status := f.Status(); status.Models != 2 || status.Fetched != 1 || status.LastUpdate == nil {
+		t.Errorf("status after update = %+v", status)
+	}
+
+	f.refresh(now)
+	if ifNoneMatch != `"v1"` {
+		t.Errorf("If-None-Match = %q, want the previous ETag", ifNoneMatch)
+	}
+	if status := f.Status(); status.Models != 2 || status.Fetched != 2 {
+		t.Errorf("status after not modified = %+v", status)
+	}
+
+	fail = true
+	if delay := f.refresh(now); delay != retryDelay {
+		t.Errorf("delay after failure = %v, want %v", delay, retryDelay)
+	}
+	if status := f.Status(); status.Failed != 1 || status.LastError == "" {
+		t.Errorf("status after failure = %+v", status)
+	}
+	if m, ok := f.Lookup("openai/gpt-5"); !ok || m.SystemPrompt != "Be concise." {
+		t.Errorf("guidance lost after a failed fetch: %+v, %v", m, ok)
+	}
+	if requests != 3 {
+		t.Errorf("requests = %d, want 3", requests)
+	}
+}
+
+func TestFetcher_RejectsEmptyDocument(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"models": {}}`))
+	}))
+	defer server.Close()
+
+	f := New(config.GuidanceConfig{URL: server.URL, Interval: time.Hour})
+	f.refresh(time.Now())
+	if status := f.Status(); status.Failed != 1 || status.Models != 0 {
+		t.Errorf("status = %+v, want a failed fetch", status)
+	}
+}
+
+func TestFetcher_ApplyAndAnnotate(t *testing.T) {
+	f := &Fetcher{}
+	f.doc.Models = map[string]Model{
+		"openai/gpt-5": {SystemPrompt: "Be concise.", Description: "Reasoning model", ContextWindow: 400000},
+		"*":            {SystemPrompt: "Default."},
+	}
+
+	req := types.ChatCompletionRequest{Model: "openai/gpt-5", Messages: []types.ChatMessage{{Role: "user", Content: "hi"}}}
+	if !f.Apply(&req) || len(req.Messages) != 2 || req.Messages[0].Content != "Be concise." {
+		t.Errorf("messages = %+v, want the model's system prompt first", req.Messages)
+	}
+
+	req = types.ChatCompletionRequest{Model: "xai/grok-4", Messages: []types.ChatMessage{{Role: "user", Content: "hi"}}}
+	if !f.Apply(&req) || req.Messages[0].Content != "Default." {
+		t.Errorf("messages = %+v, want the fallback system prompt", req.Messages)
+	}
+
+	req = types.ChatCompletionRequest{Model: "openai/gpt-5", Messages: []types.ChatMessage{{Role: "system", Content: "mine"}}}
+	if f.Apply(&req) || len(req.Messages) != 1 {
+		t.Errorf("client system prompt was overridden: %+v", req.Messages)
+	}
+
+	models := []types.Model{{ID: "openai/gpt-5"}, {ID: "xai/grok-4"}}
+	f.Annotate(models)
+	if models[0].Description != "Reasoning model" || models[0].ContextWindow != 400000 {
+		t.Errorf("annotated model = %+v", models[0])
+	}
+	if models[1].Description != "" {
+		t.Errorf("fallback entry annotated a model: %+v", models[1])
+	}
+
+	var disabled *Fetcher
+	if disabled.Apply(&req) || disabled.Status() != nil {
+		t.Error("nil fetcher must be a no-op")
+	}
+}
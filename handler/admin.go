@@ -0,0 +1,313 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"cursor2api/catalog"
+	"cursor2api/config"
+	"cursor2api/middleware"
+	"cursor2api/tenant"
+	"cursor2api/transcript"
+	"cursor2api/usage"
+	"cursor2api/utils"
+)
+
+// maxDebugConvertBody 限制 debug/convert 请求体大小
+const maxDebugConvertBody = 4 << 20
+
+// maxModelCatalogBody 限制 PUT /admin/models 请求体大小
+const maxModelCatalogBody = 1 << 20
+
+// refreshStateResponse 刷新控制接口的响应
+type refreshStateResponse struct {
+	Paused      bool       `json:"paused"`
+	Changed     bool       `json:"changed"`
+	PausedAt    *time.Time `json:"paused_at,omitempty"`
+	PauseReason string     `json:"pause_reason,omitempty"`
+}
+
+// HandleRefreshStatus handles GET /admin/refresh
+func (h *APIHandler) HandleRefreshStatus(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, http.StatusOK, h.refreshState(false))
+}
+
+// HandleRefreshPause handles POST /admin/refresh/pause
+// Optional body: {"reason": "upstream maintenance"}
+func (h *APIHandler) HandleRefreshPause(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			h.writeError(w, http.StatusBadRequest, "Invalid JSON", "invalid_request_error")
+			return
+		}
+	}
+	if body.Reason == "" {
+		body.Reason = "admin request"
+	}
+
+	changed := h.manager.PauseRefresh(body.Reason)
+	log.Printf("🛠️  Admin: 暂停参数刷新 (changed: %v)", changed)
+	h.writeJSON(w, http.StatusOK, h.refreshState(changed))
+}
+
+// HandleRefreshResume handles POST /admin/refresh/resume
+func (h *APIHandler) HandleRefreshResume(w http.ResponseWriter, r *http.Request) {
+	changed := h.manager.ResumeRefresh()
+	log.Printf("🛠️  Admin: 恢复参数刷新 (changed: %v)", changed)
+	h.writeJSON(w, http.StatusOK, h.refreshState(changed))
+}
+
+// refreshState 构建当前刷新状态
+func (h *APIHandler) refreshState(changed bool) refreshStateResponse {
+	stats := h.manager.GetStats()
+	state := refreshStateResponse{
+		Paused:  stats["refreshPaused"].(bool),
+		Changed: changed,
+	}
+	if pausedAt, ok := stats["pausedAt"].(time.Time); ok {
+		state.PausedAt = &pausedAt
+		state.PauseReason, _ = stats["pauseReason"].(string)
+	}
+	return state
+}
+
+// HandleAccounts handles GET /admin/accounts
+// Returns the upstream account pool state (health and pinned conversations)
+func (h *APIHandler) HandleAccounts(w http.ResponseWriter, r *http.Request) {
+	accounts := h.cursorService.Accounts()
+	h.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"enabled":  accounts != nil,
+		"accounts": accounts,
+	})
+}
+
+// HandlePrompts handles GET /admin/prompts
+// Lists the server-side prompt templates and the variables each one expects
+func (h *APIHandler) HandlePrompts(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"enabled": h.prompts != nil,
+		"prompts": h.prompts.List(),
+	})
+}
+
+// HandleTenants handles GET /admin/tenants
+// Lists the tenants in the admin token's scope; keys and tokens are only counted
+func (h *APIHandler) HandleTenants(w http.ResponseWriter, r *http.Request) {
+	scope := middleware.AdminTenantFromContext(r.Context())
+	list := make([]tenant.Info, 0)
+	for _, t := range h.tenants.List() {
+		if scope == "" || t.Name == scope {
+			list = append(list, t.Info())
+		}
+	}
+	h.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"enabled": h.tenants != nil,
+		"tenants": list,
+	})
+}
+
+// HandleUsage handles GET /admin/usage
+// Returns the usage aggregates and monthly spend in the admin token's tenant scope,
+// plus today's spend against the daily budgets for unscoped admins
+func (h *APIHandler) HandleUsage(w http.ResponseWriter, r *http.Request) {
+	scope := middleware.AdminTenantFromContext(r.Context())
+	summaries := h.usage.Snapshot()
+	spend := h.usage.SpendSnapshot()
+	if scope != "" {
+		summaries = slices.DeleteFunc(summaries, func(s usage.Summary) bool { return s.Tenant != scope })
+		spend = slices.DeleteFunc(spend, func(s usage.Spend) bool { return s.Tenant != scope })
+	}
+	resp := map[string]interface{}{
+		"tenant": scope,
+		"usage":  summaries,
+		"spend":  spend,
+	}
+	// Daily budgets span all tenants, so only unscoped admins see them
+	if budgets := h.usage.BudgetSnapshot(); scope == "" && budgets != nil {
+		resp["budgets"] = budgets
+	}
+	h.writeJSON(w, http.StatusOK, resp)
+}
+
+// HandleDebugConvert handles POST /admin/debug/convert
+// Body is either a conversion case in the golden file format
+// ({"function_calling": true, "request": {...}, "expected": {...}}) or a plain chat
+// completion request. Returns the Cursor request body the converter produces and,
+// when "expected" is given, whether it matches byte for byte.
+func (h *APIHandler) HandleDebugConvert(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxDebugConvertBody))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Failed to read request body", "invalid_request_error")
+		return
+	}
+
+	var c utils.ConversionCase
+	if err := json.Unmarshal(data, &c); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON", "invalid_request_error")
+		return
+	}
+	if len(c.Request.Messages) == 0 {
+		// Not a conversion case, treat the body as the chat completion request itself
+		c = utils.ConversionCase{}
+		if err := json.Unmarshal(data, &c.Request); err != nil || len(c.Request.Messages) == 0 {
+			h.writeError(w, http.StatusBadRequest, "request.messages (or messages) must be a non-empty array", "invalid_request_error")
+			return
+		}
+	}
+
+	result, err := utils.RunConversionCase(c)
+	if err != nil {
+		h.writeError(w, http.StatusUnprocessableEntity, err.Error(), "invalid_request_error")
+		return
+	}
+	log.Printf("🛠️  Admin: debug/convert (messages: %d, tools: %d, match: %v)", len(c.Request.Messages), len(c.Request.Tools), result.Match != nil && *result.Match)
+	h.writeJSON(w, http.StatusOK, result)
+}
+
+// HandleCaptureStatus handles GET /admin/capture
+// Returns whether upstream stream capture is on and the capture files written so far
+func (h *APIHandler) HandleCaptureStatus(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, http.StatusOK, h.cursorService.Capture().Status())
+}
+
+// HandleCaptureStart handles POST /admin/capture
+// Optional body: {"duration": "10m", "max_files": 10}. While capture is on, the raw
+// upstream stream of failing requests is written (redacted) to UPSTREAM_CAPTURE_DIR
+// for `cursor2api replay`.
+func (h *APIHandler) HandleCaptureStart(w http.ResponseWriter, r *http.Request) {
+	body := struct {
+		Duration string `json:"duration"`
+		MaxFiles int    `json:"max_files"`
+	}{Duration: "10m", MaxFiles: 10}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			h.writeError(w, http.StatusBadRequest, "Invalid JSON", "invalid_request_error")
+			return
+		}
+	}
+	duration, err := time.ParseDuration(body.Duration)
+	if err != nil || duration <= 0 || duration > 24*time.Hour {
+		h.writeError(w, http.StatusBadRequest, "duration must be a Go duration between 1s and 24h", "invalid_request_error")
+		return
+	}
+	if body.MaxFiles <= 0 {
+		h.writeError(w, http.StatusBadRequest, "max_files must be positive", "invalid_request_error")
+		return
+	}
+
+	status, err := h.cursorService.Capture().Enable(duration, body.MaxFiles)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, err.Error(), "api_error")
+		return
+	}
+	log.Printf("🛠️  Admin: 开启上游流捕获 (duration: %v, max files: %d)", duration, body.MaxFiles)
+	h.writeJSON(w, http.StatusOK, status)
+}
+
+// HandleCaptureStop handles DELETE /admin/capture
+func (h *APIHandler) HandleCaptureStop(w http.ResponseWriter, r *http.Request) {
+	log.Printf("🛠️  Admin: 关闭上游流捕获")
+	h.writeJSON(w, http.StatusOK, h.cursorService.Capture().Disable())
+}
+
+// HandleTranscript handles GET /admin/transcripts/{id}
+// Returns the stored transcript of a streamed completion as JSON Lines,
+// decompressed whatever STORAGE_COMPRESSION it was written with
+func (h *APIHandler) HandleTranscript(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	data, err := h.transcripts.Read(id)
+	if errors.Is(err, transcript.ErrNotFound) {
+		h.writeErrorCode(w, http.StatusNotFound, "No transcript with ID "+id, "invalid_request_error", "transcript_not_found")
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Admin: 读取 transcript 失败: %v", err)
+		h.writeError(w, http.StatusInternalServerError, err.Error(), "api_error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// HandleModelCatalog handles GET /admin/models
+// Returns the served model list and aliases
+func (h *APIHandler) HandleModelCatalog(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, http.StatusOK, h.catalog.Snapshot())
+}
+
+// HandleModelCatalogReplace handles PUT /admin/models
+// Body: {"models": [{"id": "openai/gpt-5", "owned_by": "cursor"}], "aliases": {"gpt-4o": "openai/gpt-5"}};
+// replaces the whole catalog and persists it to MODEL_CATALOG_FILE
+func (h *APIHandler) HandleModelCatalogReplace(w http.ResponseWriter, r *http.Request) {
+	var snap catalog.Snapshot
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxModelCatalogBody)).Decode(&snap); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON", "invalid_request_error")
+		return
+	}
+
+	updated, err := h.catalog.Replace(snap)
+	if errors.Is(err, catalog.ErrInvalid) {
+		h.writeError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Admin: 模型目录保存失败: %v", err)
+		h.writeError(w, http.StatusInternalServerError, err.Error(), "api_error")
+		return
+	}
+	log.Printf("🛠️  Admin: 替换模型目录 (models: %d, aliases: %d, role: %s)", len(updated.Models), len(updated.Aliases), middleware.AdminRoleFromContext(r.Context()))
+	h.writeJSON(w, http.StatusOK, updated)
+}
+
+// HandleEffectiveConfig handles GET /admin/config/effective
+// Returns the resolved configuration (defaults, environment, .env file and validation
+// fallbacks applied) with secrets masked, the source of every environment variable
+// and the settings changed at runtime through the admin API or the rate limit policy.
+// ?diff=true lists only the variables that are set.
+func (h *APIHandler) HandleEffectiveConfig(w http.ResponseWriter, r *http.Request) {
+	settings := h.config.Settings()
+	if r.URL.Query().Get("diff") == "true" {
+		settings = slices.DeleteFunc(settings, func(s config.Setting) bool { return !s.Overridden() })
+	}
+
+	runtime := map[string]interface{}{}
+	refresh := h.refreshState(false)
+	runtime["antibot_refresh"] = map[string]interface{}{
+		"paused":       refresh.Paused,
+		"paused_at":    refresh.PausedAt,
+		"pause_reason": refresh.PauseReason,
+	}
+	snap := h.catalog.Snapshot()
+	runtime["model_catalog"] = map[string]interface{}{
+		"replaced":   snap.UpdatedAt != nil, // Replaced through PUT /admin/models
+		"updated_at": snap.UpdatedAt,
+		"models":     len(snap.Models),
+		"aliases":    len(snap.Aliases),
+	}
+	capture := h.cursorService.Capture().Status()
+	runtime["upstream_capture"] = map[string]interface{}{
+		"enabled": capture.Enabled,
+		"until":   capture.Until,
+	}
+	if h.rateLimiter != nil {
+		runtime["rate_limit"] = h.rateLimiter.Effective()
+	}
+	if h.userLimiter != nil {
+		runtime["user_rate_limit"] = h.userLimiter.Effective()
+	}
+
+	h.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"settings": settings,
+		"config":   h.config.Masked(),
+		"runtime":  runtime,
+	})
+}
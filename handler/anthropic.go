@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"cursor2api/types"
+	"cursor2api/utils"
+)
+
+// HandleMessages 处理 POST /v1/messages (Anthropic Messages API)
+// 请求被转换为 OpenAI 格式后交给 HandleChatCompletions,因此鉴权、额度、租户与用量统计
+// 与 /v1/chat/completions 完全一致; 响应 (包括错误) 再转换回 Messages API 格式
+func (h *APIHandler) HandleMessages(w http.ResponseWriter, r *http.Request) {
+	var req types.AnthropicRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("❌ 无效的 Messages API 请求: %v", err)
+		writeAnthropicError(w, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	chatReq, err := utils.AnthropicToOpenAI(req)
+	if err != nil {
+		log.Printf("❌ Messages API 请求转换失败: %v", err)
+		writeAnthropicError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	body, err := json.Marshal(chatReq)
+	if err != nil {
+		writeAnthropicError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Printf("🔀 Messages API 请求转换为 chat completion (messages: %d → %d, tools: %d)", len(req.Messages), len(chatReq.Messages), len(chatReq.Tools))
+	r = r.Clone(r.Context())
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	aw := &translatingWriter{
+		ResponseWriter: w,
+		format:         &anthropicFormat{stream: utils.NewAnthropicStream(h.converter.EstimateMessagesTokens(chatReq.Messages))},
+	}
+	h.HandleChatCompletions(aw, r)
+	aw.finish()
+}
+
+// writeAnthropicError 写入 Messages API 格式的错误响应
+func writeAnthropicError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	response := types.AnthropicErrorResponse{
+		Type:  "error",
+		Error: types.AnthropicError{Type: utils.AnthropicErrorType(status), Message: message},
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("❌ 写入 Messages API 错误响应失败: %v", err)
+	}
+}
+
+// anthropicFormat 把 chat completion 响应转换为 Messages API 格式
+type anthropicFormat struct {
+	stream *utils.AnthropicStream
+}
+
+func (f *anthropicFormat) streamContentType() string {
+	return "text/event-stream"
+}
+
+// encodeChunk 以 "event: <type>" 格式编码转换出的事件
+func (f *anthropicFormat) encodeChunk(chunk *types.ChatCompletionStreamResponse) ([]byte, error) {
+	var events []types.AnthropicEvent
+	if chunk == nil {
+		events = f.stream.Finish()
+	} else {
+		events = f.stream.Chunk(*chunk)
+	}
+	var frames bytes.Buffer
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&frames, "event: %s\ndata: %s\n\n", event.Type, data)
+	}
+	return frames.Bytes(), nil
+}
+
+func (f *anthropicFormat) endStream() []byte {
+	return nil
+}
+
+// keepAlive 返回 Messages API 的 ping 事件
+func (f *anthropicFormat) keepAlive() []byte {
+	return []byte("event: ping\ndata: {\"type\":\"ping\"}\n\n")
+}
+
+func (f *anthropicFormat) encodeResponse(resp types.ChatCompletionResponse) interface{} {
+	return utils.OpenAIToAnthropic(resp)
+}
+
+func (f *anthropicFormat) writeError(w http.ResponseWriter, status int, message string) {
+	writeAnthropicError(w, status, message)
+}
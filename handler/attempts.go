@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"net/http"
+
+	"cursor2api/config"
+	"cursor2api/service"
+)
+
+// attemptsHeader 上游尝试记录: 重试次数、使用过的模型、每次尝试的结果以及熔断与降级状态
+const attemptsHeader = "X-Attempts"
+
+// attemptsWriter 在写出响应头时附加 X-Attempts。ATTEMPTS_HEADER=errors 时仅附加于失败的请求
+// (状态码 >= 400,或在第一个字节之前失败的流式请求); 流式响应头一旦发出便无法再修改
+type attemptsWriter struct {
+	http.ResponseWriter
+	h           *APIHandler
+	trace       *service.AttemptTrace
+	failed      bool
+	wroteHeader bool
+}
+
+// withAttemptsHeader 返回附加 X-Attempts 的 ResponseWriter,ATTEMPTS_HEADER=off 时原样返回
+func (h *APIHandler) withAttemptsHeader(w http.ResponseWriter, trace *service.AttemptTrace) http.ResponseWriter {
+	if h.config.Observability.AttemptsHeader == config.AttemptsHeaderOff {
+		return w
+	}
+	return &attemptsWriter{ResponseWriter: w, h: h, trace: trace}
+}
+
+func (w *attemptsWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if w.failed || status >= http.StatusBadRequest || w.h.config.Observability.AttemptsHeader == config.AttemptsHeaderAlways {
+			w.Header().Set(attemptsHeader, w.value())
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *attemptsWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *attemptsWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *attemptsWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// value 组合尝试记录、账号池熔断状态 (健康账号数/总数) 与降级阶段
+func (w *attemptsWriter) value() string {
+	return w.trace.String() + "; circuit=" + w.h.cursorService.CircuitState() + "; stage=" + w.h.availability.Status().Stage
+}
+
+// markAttemptsFailed 标记请求失败,使 ATTEMPTS_HEADER=errors 时状态码为 200 的流式错误也附加 X-Attempts
+func markAttemptsFailed(w http.ResponseWriter) {
+	for {
+		if aw, ok := w.(*attemptsWriter); ok {
+			aw.failed = true
+			return
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = u.Unwrap()
+	}
+}
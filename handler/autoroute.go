@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"log"
+
+	"cursor2api/metrics"
+	"cursor2api/types"
+	"cursor2api/utils"
+)
+
+var autoModelRoutes = metrics.NewCounter(
+	"cursor2api_auto_model_routes_total",
+	"Requests for the auto model by selected model and matching rule.",
+	"model", "rule")
+
+// routeAutoModel 将伪模型 auto 解析为真实模型; 未启用自动路由或请求其他模型时不做修改
+func (h *APIHandler) routeAutoModel(req *types.ChatCompletionRequest) {
+	if h.autoRouter == nil || req.Model != utils.AutoModel {
+		return
+	}
+	model, rule := h.autoRouter.Route(req, h.converter.EstimateMessagesTokens(req.Messages))
+	autoModelRoutes.Inc(model, rule)
+	log.Printf("🧭 自动模型路由: %s → %s (rule: %s)", utils.AutoModel, model, rule)
+	req.Model = model
+}
@@ -1,11 +1,26 @@
 package handler
 
 import (
+	"cmp"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"strings"
 
+	"cursor2api/canary"
+	"cursor2api/config"
+	"cursor2api/features"
+	"cursor2api/middleware"
+	"cursor2api/observability"
+	"cursor2api/service"
+	"cursor2api/tenant"
+	"cursor2api/tracing"
 	"cursor2api/types"
+	"cursor2api/usage"
+	"cursor2api/utils"
 )
 
 // HandleChatCompletions 处理 /v1/chat/completions 请求
@@ -22,14 +37,171 @@ func (h *APIHandler) HandleChatCompletions(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Expand a server-side prompt template before validating the messages
+	promptID := req.PromptID
+	if err := h.prompts.Expand(&req); err != nil {
+		log.Printf("❌ 提示词模板展开失败: %v", err)
+		code := "invalid_prompt_variables"
+		if errors.Is(err, utils.ErrPromptNotFound) {
+			code = "prompt_not_found"
+		}
+		h.writeErrorCode(w, http.StatusBadRequest, err.Error(), "invalid_request_error", code)
+		return
+	}
+
 	if len(req.Messages) == 0 {
 		log.Printf("❌ messages 字段为空")
 		h.writeError(w, http.StatusBadRequest, "messages field is required and must be a non-empty array", "invalid_request_error")
 		return
 	}
 
+	if req.StreamOptions != nil && !req.Stream {
+		log.Printf("❌ stream_options 仅允许在流式请求中使用")
+		h.writeError(w, http.StatusBadRequest, "The 'stream_options' parameter is only allowed when 'stream' is enabled.", "invalid_request_error")
+		return
+	}
+
+	if err := validateMetadata(req.Metadata); err != nil {
+		log.Printf("❌ metadata 字段无效: %v", err)
+		h.writeError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return
+	}
+
+	if err := validateResponseFormat(req.ResponseFormat); err != nil {
+		log.Printf("❌ response_format 字段无效: %v", err)
+		h.writeErrorCode(w, http.StatusBadRequest, err.Error(), "invalid_request_error", "invalid_response_format")
+		return
+	}
+
+	// Oversized tool definitions are shrunk or rejected per TOOLS_OVERSIZE_STRATEGY
+	if !h.fitTools(w, &req) {
+		return
+	}
+
+	// Experimental features requested through X-C2A-Features, limited to granted keys
+	enabledFeatures, ok := h.requestFeatures(w, r)
+	if !ok {
+		return
+	}
+	r = r.WithContext(features.WithSet(r.Context(), enabledFeatures))
+
+	// Sandbox keys never consume real upstream capacity or quotas
+	sandbox := h.isSandbox(r)
+
+	// Chat needs a usable AntiBot token (sandbox keys served by the mock upstream do not);
+	// /v1/models and admin endpoints keep working meanwhile
+	if err := h.availability.ChatAllowed(); err != nil && (!sandbox || h.config.Auth.SandboxModel != "") {
+		log.Printf("🪫 服务降级,拒绝 chat 请求: %v", err)
+		w.Header().Set("Retry-After", "30")
+		h.writeErrorCode(w, http.StatusServiceUnavailable,
+			"The upstream token is unavailable while the solver recovers, please retry shortly.", "api_error", "token_unavailable")
+		return
+	}
+
+	// Spend caps are lifted while the usage state cannot be persisted
+	if spent, limit, ok := h.usage.CheckSpend(middleware.APIKeyFromContext(r.Context())); !ok && !sandbox && h.availability.QuotasEnforced() {
+		log.Printf("🚫 月度消费额度已用尽: $%.4f / $%.2f", spent, limit)
+		h.writeErrorCode(w, http.StatusTooManyRequests,
+			fmt.Sprintf("You exceeded your monthly spend limit of $%.2f ($%.4f used). Limits reset at the start of the next month.", limit, spent),
+			"insufficient_quota", "spend_limit_exceeded")
+		return
+	}
+
+	// Limit end users of a downstream app individually beneath the key's quota
+	if !h.userLimiter.AllowUser(w, r, req.User) {
+		log.Printf("🚫 终端用户请求过于频繁: %s", req.User)
+		return
+	}
+
+	// Apply the tenant's default model, model allowlist and system prompt
+	t := tenant.FromContext(r.Context())
 	if req.Model == "" {
-		req.Model = "anthropic/claude-opus-4.1"
+		req.Model = cmp.Or(t.DefaultModel(), "anthropic/claude-opus-4.1")
+	}
+	requested := req.Model
+	var alias string
+	if target, ok := h.catalog.Resolve(req.Model); ok {
+		alias, req.Model = req.Model, target
+	}
+	// Only models of the catalog (and "auto" when routing is enabled) are served
+	if !h.catalog.Has(req.Model) && (h.autoRouter == nil || req.Model != utils.AutoModel) {
+		available := slices.DeleteFunc(h.catalog.IDs(), func(id string) bool { return !t.AllowsModel(id) })
+		log.Printf("❌ 未知模型: %s", req.Model)
+		h.writeErrorCode(w, http.StatusBadRequest,
+			fmt.Sprintf("The model `%s` does not exist. Available models: %s.", req.Model, strings.Join(available, ", ")),
+			"invalid_request_error", "model_not_found")
+		return
+	}
+	deprecated := h.applyDeprecation(w, &req)
+	h.routeAutoModel(&req)
+	if !t.AllowsModel(req.Model) {
+		log.Printf("🚫 租户 %s 不允许使用模型 %s", t.Name, req.Model)
+		h.writeErrorHint(w, http.StatusNotFound,
+			fmt.Sprintf("The model `%s` does not exist or you do not have access to it.", req.Model),
+			"invalid_request_error", "model_not_found",
+			fmt.Sprintf("The model is not in the allowlist of tenant %s; allowed: [%s].", t.Name, strings.Join(t.Models, ", ")))
+		return
+	}
+	var sandboxMode string
+	if sandbox {
+		// Serve sandbox keys from the mock upstream, or the cheap SANDBOX_MODEL
+		r, sandboxMode = h.applySandbox(w, r, &req)
+	}
+	middleware.SetSLIModel(r.Context(), req.Model)
+	span := tracing.SpanFromContext(r.Context())
+	span.SetAttribute("gen_ai.request.model", req.Model)
+	span.SetAttribute("cursor2api.stream", req.Stream)
+	// RESPONSE_MODEL=requested: responses echo the name the client sent instead of the resolved model
+	if h.config.Cursor.ResponseModel == config.ResponseModelRequested {
+		req.RequestedModel = requested
+	}
+	preset := h.presets.Apply(&req, middleware.APIKeyFromContext(r.Context()))
+	guided := h.guidance.Apply(&req)
+	req.Messages = t.WithSystemPrompt(req.Messages)
+	tags := h.upstreamTags(r, t)
+	r = r.WithContext(service.WithUpstreamTags(r.Context(), tags))
+	ctx, upstreamHeaders := service.WithResponseHeaders(r.Context(), h.config.Cursor.PassthroughHeaders)
+	r = r.WithContext(ctx)
+	// Record the upstream attempts for the X-Attempts header
+	ctx, attempts := service.WithAttemptTrace(r.Context())
+	r = r.WithContext(ctx)
+	w = h.withAttemptsHeader(w, attempts)
+
+	// Attach observability identifiers and echo them so clients can correlate traces
+	trace := observability.FromRequest(r, h.config.Observability, req.User)
+	r = r.WithContext(observability.WithTrace(r.Context(), trace))
+	w.Header().Set(h.config.Observability.TraceHeader, trace.TraceID)
+	span.SetAttribute("cursor2api.trace_id", trace.TraceID)
+	if trace.SessionID != "" {
+		w.Header().Set(h.config.Observability.SessionHeader, trace.SessionID)
+	}
+
+	// Assign the configuration variant; a conversation (or session) keeps the same variant
+	variant := h.canary.Assign(cmp.Or(req.ConversationID, trace.SessionID))
+	r = r.WithContext(canary.WithVariant(r.Context(), variant))
+	if h.canary != nil {
+		w.Header().Set("X-Config-Variant", variant)
+	}
+
+	// Track the generation so POST /v1/chat/completions/{id}/cancel can stop it server-side
+	completionID := newCompletionID()
+	ctx, entry, err := h.inflight.track(r.Context(), completionID, middleware.APIKeyFromContext(r.Context()), req, h.config.Stream.MaxPerKey)
+	if err != nil {
+		log.Printf("🚫 并发流数已达上限: %s (max %d)", middleware.MaskAPIKey(middleware.APIKeyFromContext(r.Context())), h.config.Stream.MaxPerKey)
+		h.writeErrorHint(w, http.StatusTooManyRequests, err.Error(), "rate_limit_error", "too_many_streams",
+			fmt.Sprintf("Your key may have %d concurrent streams; wait for one to finish, or end one with POST /v1/chat/completions/{id}/cancel.", h.config.Stream.MaxPerKey))
+		return
+	}
+	defer h.inflight.release(entry)
+	r = r.WithContext(ctx)
+	w.Header().Set("X-Completion-Id", completionID)
+	if req.Stream {
+		// Count the bytes sent for /admin/streams
+		w = &countingWriter{ResponseWriter: w, entry: entry}
+	}
+	if !sandbox {
+		// Real traffic keeps the upstream path warm; skip the next keep-warm request
+		h.keepWarm.Touch()
 	}
 
 	// Log request metadata only (no sensitive message content)
@@ -39,10 +211,61 @@ func (h *APIHandler) HandleChatCompletions(w http.ResponseWriter, r *http.Reques
 	log.Printf("  └─ Stream: %v", req.Stream)
 	log.Printf("  └─ Tools Count: %d", len(req.Tools))
 	log.Printf("  └─ ConversationID: %s", req.ConversationID)
+	log.Printf("  └─ Client: %s", middleware.ClientFromContext(r.Context()))
+	if alias != "" {
+		log.Printf("  └─ Alias: %s → %s", alias, req.Model)
+	}
+	if deprecated != "" {
+		log.Printf("  └─ Deprecated: %s", deprecated)
+	}
+	if promptID != "" {
+		log.Printf("  └─ Prompt: %s", promptID)
+	}
+	if preset != "" {
+		log.Printf("  └─ Preset: %s", preset)
+	}
+	if guided {
+		log.Printf("  └─ Model guidance system prompt applied")
+	}
+	log.Printf("  └─ TraceID: %s", trace.TraceID)
+	log.Printf("  └─ CompletionID: %s", completionID)
+	if t != nil {
+		log.Printf("  └─ Tenant: %s", t.Name)
+	}
+	if len(tags) > 0 {
+		log.Printf("  └─ Upstream Tags: %d", len(tags))
+	}
+	if h.canary != nil {
+		log.Printf("  └─ Variant: %s", variant)
+	}
+	if sandbox {
+		log.Printf("  └─ 🧪 Sandbox: %s", sandboxMode)
+	}
+	if len(enabledFeatures) > 0 {
+		log.Printf("  └─ Features: %s", enabledFeatures)
+	}
+	if dims := h.usage.Dimensions(req.Metadata); dims != nil {
+		log.Printf("  └─ Metadata: %s", usage.FormatDimensions(dims))
+	}
+
+	// Mirror a sample of requests to the shadow backend; never affects this response
+	if !sandbox {
+		h.shadow.Mirror(req, h.config.Observability.TraceHeader, trace.TraceID)
+	}
 
 	if req.Stream {
-		h.handleStreamingResponse(w, r, req)
+		h.handleStreamingResponse(w, r, req, completionID, upstreamHeaders)
 	} else {
-		h.handleNonStreamingResponse(w, r, req)
+		h.handleNonStreamingResponse(w, r, req, completionID, upstreamHeaders)
+	}
+}
+
+// refundOnUnavailable 上游在产生任何输出之前因服务端原因失败时,退还请求消耗的限流令牌
+func (h *APIHandler) refundOnUnavailable(r *http.Request, err error) {
+	if !errors.Is(err, service.ErrUpstreamUnavailable) {
+		return
+	}
+	if n := middleware.RefundRateLimit(r.Context()); n > 0 {
+		log.Printf("↩️  上游不可用,已退还 %d 个限流令牌", n)
 	}
 }
@@ -1,21 +1,26 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
+	"cursor2api/errdocs"
+	"cursor2api/middleware"
+	"cursor2api/service"
+	"cursor2api/tee"
+	"cursor2api/tracing"
 	"cursor2api/types"
+	"cursor2api/utils"
 )
 
 // handleStreamingResponse 处理流式响应
-func (h *APIHandler) handleStreamingResponse(w http.ResponseWriter, r *http.Request, req types.ChatCompletionRequest) {
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "*")
+func (h *APIHandler) handleStreamingResponse(w http.ResponseWriter, r *http.Request, req types.ChatCompletionRequest, streamID string, upstreamHeaders *service.ResponseHeaders) {
+	h.setSSEHeaders(w)
 
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -23,62 +28,231 @@ func (h *APIHandler) handleStreamingResponse(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	streamID := fmt.Sprintf("chatcmpl-%d", time.Now().UnixMilli())
+	// 流式响应不受全局 WriteTimeout 限制,改用 SERVER_STREAM_WRITE_TIMEOUT (0 = 不限制)
+	var deadline time.Time
+	if timeout := h.config.Server.StreamWriteTimeout; timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := http.NewResponseController(w).SetWriteDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("⚠️  无法设置流式响应写超时: %v", err)
+	}
+
 	created := time.Now().Unix()
-	fullContent := ""
-	isFirstChunk := true
-	
+
+	// Fan text chunks out to the client, the token counter and the optional
+	// capture/transcript sinks instead of accumulating the full content here
+	counter := &utils.TokenCounter{}
+	capture := h.newCapture()
+	sinks := []tee.Sink{
+		// STREAM_MAX_TOKENS_PER_SECOND: 平滑上游的突发输出,并限制单个流的带宽
+		tee.NewPaced(r.Context(), &sseClientSink{h: h, w: w, flusher: flusher, id: streamID, created: created, model: req.ResponseModel()}, h.config.Stream.MaxTokensPerSecond),
+		counter,
+		h.openTranscript(r, req, streamID),
+	}
+	if capture != nil {
+		sinks = append(sinks, capture)
+	}
+	progress := h.newStreamProgress(w, r, req, flusher, counter)
+	if progress != nil {
+		sinks = append(sinks, progress)
+	}
+	stream := tee.New(sinks...)
+	outcome := tee.Outcome{FinishReason: types.FinishReasonCancelled}
+	defer func() {
+		stream.Close(outcome)
+	}()
+
+	// Output guardrails of the calling key, nil when none apply
+	guard := h.guardrails.For(middleware.APIKeyFromContext(r.Context())).NewFilter()
+	// max_tokens: 达到上限后不再转发内容,以 "length" 结束流 (nil = 不限制)
+	limiter := utils.NewTokenLimiter(req.MaxTokens)
+
 	// Initialize tool call index counter for streaming responses (matching Python reference)
 	toolCallIdx := 0
+	var toolCalls types.CursorToolCalls
 
 	ctx := r.Context()
-	dataChan, errorChan := h.cursorService.StreamChat(ctx, req.Messages, req.Model, req.ConversationID, req.Tools)
+	streamCtx, stopStream := context.WithCancel(ctx)
+	defer stopStream()
+
+	// response_format=json_object/json_schema: 逐块校验输出,偏离时停止上游流并重试
+	messages := req.Messages
+	var jsonMode *jsonModeStream
+	if req.JSONMode() {
+		jsonMode = &jsonModeStream{h: h, req: req, schema: requestSchema(req), stop: stopStream}
+		messages = utils.WithJSONModeInstruction(req.Messages, req.JSONSchema(), false)
+	}
+	// stop_on_tool_call=false: 工具调用后不结束流,文本与工具调用交错发送
+	if req.InterleavedToolCalls() {
+		streamCtx = service.WithInterleavedToolCalls(streamCtx)
+	}
+	// parallel_tool_calls=false: 第一个工具调用后结束响应
+	if req.SingleToolCall() {
+		streamCtx = service.WithSingleToolCall(streamCtx)
+	}
+	dataChan, errorChan := h.cursorService.StreamChat(streamCtx, messages, req.Model, req.ConversationID, req.Tools)
+
+	// 上游响应头在收到第一个数据或错误时加入响应头部;此前已刷新的头部(如进度事件)无法再修改
+	passUpstreamHeaders := sync.OnceFunc(func() { upstreamHeaders.CopyTo(w.Header()) })
 
 	for {
 		select {
+		case <-progress.tick():
+			progress.write()
+
 		case <-ctx.Done():
-			log.Printf("⚠️  客户端已断开连接,终止流式响应")
+			if cancelledByRequest(ctx) {
+				// 通过取消接口终止: 连接仍然可用,正常结束流
+				h.writeCancelledStream(w, flusher, r, req, streamID, created, capture, counter)
+				return
+			}
+			if cancelledByDrain(ctx) {
+				// 服务关闭中: 以 server_shutting_down 错误事件结束,客户端可以重试
+				h.writeStreamError(w, flusher, r, req, capture, errServerDraining)
+				return
+			}
+			log.Printf("⚠️  客户端已断开连接,终止流式响应 (finish reason: %s)", types.FinishReasonCancelled)
 			return
 
 		case data, ok := <-dataChan:
+			passUpstreamHeaders()
 			if !ok {
-				// 流结束，发送最终chunk
-				promptTokens := h.converter.EstimateMessagesTokens(req.Messages)
-				completionTokens := h.converter.EstimateTokens(fullContent)
+				// 服务端在发送错误后关闭通道 (errorChan 先于 dataChan 关闭,不会阻塞)
+				if err := <-errorChan; err != nil {
+					h.writeStreamError(w, flusher, r, req, capture, err)
+					outcome = tee.Outcome{FinishReason: "error", Err: err}
+					return
+				}
+				log.Printf("⚠️  [Stream] 数据通道关闭但未收到终止信号,客户端可能已断开")
+				return
+			}
+
+			// Handle normal text chunk
+			if chunk, ok := data.(string); ok {
+				var jsonFinish *types.StreamFinish
+				if jsonMode != nil {
+					chunk, jsonFinish = jsonMode.filter(ctx, chunk)
+				}
+				if chunk = guard.Write(limiter.Write(chunk)); chunk != "" {
+					stream.WriteChunk(chunk)
+				}
+				switch {
+				case guard.Truncated() || limiter.Reached():
+					// 输出达到长度上限: 立即结束流并取消上游请求
+					stopStream()
+					data = types.StreamFinish{Reason: types.FinishReasonLength}
+				case jsonFinish != nil:
+					data = *jsonFinish
+				default:
+					continue
+				}
+			}
+
+			// 上游流结束,按终止原因发送最终 chunk
+			if finish, ok := data.(types.StreamFinish); ok {
+				if jsonMode != nil {
+					var object string
+					object, finish = jsonMode.finish(ctx, finish)
+					if object = guard.Write(limiter.Write(object)); object != "" {
+						stream.WriteChunk(object)
+					}
+				}
+				if tail := guard.Finish(); tail != "" {
+					stream.WriteChunk(tail)
+				}
+				if (guard.Truncated() || limiter.Reached()) && finish.Reason == types.FinishReasonStop {
+					finish.Reason = types.FinishReasonLength
+				}
+				outcome = tee.Outcome{FinishReason: finish.Reason, Err: finish.Err}
+				usage := completionUsage(finish.Usage, h.converter.EstimateMessagesTokens(req.Messages), counter.Tokens())
 
 				finalChunk := types.ChatCompletionStreamResponse{
 					ID:      streamID,
 					Object:  "chat.completion.chunk",
 					Created: created,
-					Model:   req.Model,
+					Model:   req.ResponseModel(),
 					Choices: []types.ChatCompletionChoice{
 						{
 							Index:        0,
 							Delta:        &types.ChatMessage{}, // 空 delta
-							FinishReason: "stop",
+							FinishReason: finish.Reason,
 						},
 					},
-					Usage: &types.ChatCompletionUsage{
-						PromptTokens:     promptTokens,
-						CompletionTokens: completionTokens,
-						TotalTokens:      promptTokens + completionTokens,
-					},
+					Usage: &usage,
+				}
+				switch finish.Reason {
+				case types.FinishReasonUpstreamAbort:
+					// 上游中途断开: 不能伪装成 "stop",附带错误信息让客户端知道内容不完整
+					finalChunk.Choices[0].FinishReason = "error"
+					finalChunk.Error = &types.ErrorDetail{
+						Message: finish.Err.Error(),
+						Type:    "upstream_error",
+						Code:    "upstream_aborted",
+					}
+				case types.FinishReasonInvalidJSON:
+					// JSON 模式: 重试后仍不是 JSON 对象,部分对象已发送后出错,或对象不符合 schema
+					finalChunk.Choices[0].FinishReason = "error"
+					finalChunk.Error = &types.ErrorDetail{
+						Message: finish.Err.Error(),
+						Type:    "invalid_response_error",
+						Code:    jsonModeErrorCode(finish.Err),
+					}
+				}
+				if finalChunk.Error != nil {
+					finalChunk.Error.Hint, finalChunk.Error.DocURL = errdocs.Annotate(finalChunk.Error.Code, "")
 				}
 
-				h.writeSSE(w, finalChunk)
+				if req.IncludeUsage() {
+					// stream_options.include_usage: usage 单独在最后一个 chunk 中发送
+					finalChunk.Usage = nil
+					h.writeSSE(w, finalChunk)
+					h.writeUsageChunk(w, req, streamID, created, usage)
+				} else {
+					h.writeSSE(w, finalChunk)
+				}
 				if _, err := fmt.Fprintf(w, "data: [DONE]\n\n"); err != nil {
 					log.Printf("❌ Failed to write [DONE]: %v", err)
 				}
 				flusher.Flush()
+				output := capturedOutput(capture)
+				if finish.Reason == types.FinishReasonToolCalls && !req.InterleavedToolCalls() {
+					output = toolCalls
+				}
+				h.recordUsage(r, req, output, finish.Reason, usage.PromptTokens, usage.CompletionTokens, finish.Err)
 
 				// Log metadata only (no sensitive response content)
-				log.Printf("✅ [Stream] OpenAI response completed")
-				log.Printf("  └─ Content length: %d characters", len(fullContent))
-				log.Printf("  └─ Prompt Tokens: %d", promptTokens)
-				log.Printf("  └─ Completion Tokens: %d", completionTokens)
+				if finish.Reason == types.FinishReasonInvalidJSON {
+					log.Printf("❌ [Stream] JSON mode output is not a valid JSON object")
+					log.Printf("  └─ Error: %v", finish.Err)
+				} else if finish.Err != nil {
+					log.Printf("❌ [Stream] Upstream aborted before completion")
+					log.Printf("  └─ Error: %v", finish.Err)
+				} else {
+					log.Printf("✅ [Stream] OpenAI response completed")
+				}
+				log.Printf("  └─ Finish reason: %s", finish.Reason)
+				log.Printf("  └─ Content length: %d bytes", counter.Bytes())
+				log.Printf("  └─ Prompt Tokens: %d", usage.PromptTokens)
+				log.Printf("  └─ Completion Tokens: %d", usage.CompletionTokens)
 				return
 			}
 
+			// 上游推理内容 (X-C2A-Features: reasoning_content) 以 delta.reasoning_content 发送
+			if reasoning, ok := data.(types.CursorReasoning); ok {
+				h.writeSSE(w, types.ChatCompletionStreamResponse{
+					ID:      streamID,
+					Object:  "chat.completion.chunk",
+					Created: created,
+					Model:   req.ResponseModel(),
+					Choices: []types.ChatCompletionChoice{{
+						Index: 0,
+						Delta: &types.ChatMessage{ReasoningContent: string(reasoning)},
+					}},
+				})
+				flusher.Flush()
+				continue
+			}
+
 			// Handle tool call response - match Python reference implementation format
 			if toolCall, ok := data.(types.CursorToolCall); ok {
 				// Convert tool input to JSON string
@@ -91,131 +265,164 @@ func (h *APIHandler) handleStreamingResponse(w http.ResponseWriter, r *http.Requ
 				// Send tool call chunk - Critical: Match Python's streaming format
 				// - Include Index field for tool call tracking
 				// - Do NOT include Role in Delta (only in first text chunk)
-				toolCallChunk := types.ChatCompletionStreamResponse{
+				// - Name first, then argument fragments (STREAM_TOOL_ARGUMENT_CHUNK_BYTES, STREAM_MAX_FRAME_BYTES)
+				base := types.ChatCompletionStreamResponse{
 					ID:      streamID,
 					Object:  "chat.completion.chunk",
 					Created: created,
-					Model:   req.Model,
-					Choices: []types.ChatCompletionChoice{
-						{
-							Index: 0,
-							Delta: &types.ChatMessage{
-								// Critical: Do NOT include Role field here (only in first text delta)
-								ToolCalls: []types.ToolCall{
-									{
-										Index: toolCallIdx, // Critical: Include index for streaming tool calls
-										ID:    toolCall.ToolID,
-										Type:  "function",
-										Function: types.ToolCallFunction{
-											Name:      toolCall.ToolName,
-											Arguments: inputJSON,
-										},
-									},
-								},
-							},
-							FinishReason: "",
-						},
+					Model:   req.ResponseModel(),
+				}
+				index := toolCallIdx
+				toolCallChunks := h.toolCallChunks(base, types.ToolCall{
+					Index: &index, // Critical: Include index for streaming tool calls
+					ID:    toolCall.ToolID,
+					Type:  "function",
+					Function: types.ToolCallFunction{
+						Name:      toolCall.ToolName,
+						Arguments: inputJSON,
 					},
+				})
+				for _, chunk := range toolCallChunks {
+					h.writeSSE(w, chunk)
 				}
-
-				h.writeSSE(w, toolCallChunk)
 				flusher.Flush()
 				
-				// Increment tool call index after each tool call (matching Python behavior)
+				// Increment tool call index after each tool call (matching Python behavior);
+				// the stream ends with the upstream turn, finish_reason tool_calls
 				toolCallIdx++
-
-				// Send finish chunk with tool_calls reason
-				finishChunk := types.ChatCompletionStreamResponse{
-					ID:      streamID,
-					Object:  "chat.completion.chunk",
-					Created: created,
-					Model:   req.Model,
-					Choices: []types.ChatCompletionChoice{
-						{
-							Index:        0,
-							Delta:        &types.ChatMessage{},
-							FinishReason: "tool_calls",
-						},
-					},
-				}
-
-				h.writeSSE(w, finishChunk)
-				if _, err := fmt.Fprintf(w, "data: [DONE]\n\n"); err != nil {
-					log.Printf("❌ Failed to write [DONE]: %v", err)
-				}
-				flusher.Flush()
-
-				log.Printf("✅ [Stream] Tool call response completed")
-				return
-			}
-
-			// Handle normal text chunk
-			if chunk, ok := data.(string); ok {
-				fullContent += chunk
-
-				// 构建 delta
-				var delta *types.ChatMessage
-				if isFirstChunk {
-					// 第一个 chunk 包含 role
-					delta = &types.ChatMessage{
-						Role:    "assistant",
-						Content: chunk,
-					}
-					isFirstChunk = false
-				} else {
-					// 后续 chunk 只有 content
-					delta = &types.ChatMessage{
-						Content: chunk,
-					}
-				}
-
-				streamChunk := types.ChatCompletionStreamResponse{
-					ID:      streamID,
-					Object:  "chat.completion.chunk",
-					Created: created,
-					Model:   req.Model,
-					Choices: []types.ChatCompletionChoice{
-						{
-							Index:        0,
-							Delta:        delta,
-							FinishReason: "",
-						},
-					},
-				}
-
-				h.writeSSE(w, streamChunk)
-				flusher.Flush()
+				toolCalls = append(toolCalls, toolCall)
+				log.Printf("🔧 [Stream] Tool call sent (index: %d)", toolCallIdx-1)
+				continue
 			}
 
 		case err := <-errorChan:
+			passUpstreamHeaders()
 			if err != nil {
-				log.Printf("❌ 流式请求错误: %v", err)
-				errorChunk := types.ErrorResponse{
-					Error: types.ErrorDetail{
-						Message: err.Error(),
-						Type:    "api_error",
-					},
-				}
-				h.writeSSE(w, errorChunk)
-				flusher.Flush()
+				h.writeStreamError(w, flusher, r, req, capture, err)
+				outcome = tee.Outcome{FinishReason: "error", Err: err}
 				return
 			}
 		}
 	}
 }
 
+// writeStreamError 在上游返回任何数据之前失败时写出错误事件
+func (h *APIHandler) writeStreamError(w http.ResponseWriter, flusher http.Flusher, r *http.Request, req types.ChatCompletionRequest, capture *tee.Capture, err error) {
+	log.Printf("❌ 流式请求错误: %v", err)
+	markAttemptsFailed(w)
+	middleware.MarkSLIFailed(r.Context())
+	tracing.SpanFromContext(r.Context()).Fail(err)
+	h.refundOnUnavailable(r, err)
+	h.exportGeneration(r, req, capturedOutput(capture), "error", h.converter.EstimateMessagesTokens(req.Messages), 0, err)
+	errorChunk := types.ErrorResponse{
+		Error: types.ErrorDetail{
+			Message: err.Error(),
+			Type:    "api_error",
+		},
+	}
+	if errors.Is(err, service.ErrEmptyCompletion) {
+		errorChunk.Error.Type = "upstream_error"
+		errorChunk.Error.Code = "empty_completion"
+	}
+	if errors.Is(err, errServerDraining) {
+		errorChunk.Error.Code = "server_shutting_down"
+	}
+	errorChunk.Error.Hint, errorChunk.Error.DocURL = errdocs.Annotate(errorChunk.Error.Code, "")
+	h.writeSSE(w, errorChunk)
+	flusher.Flush()
+}
+
+// writeCancelledStream 流被取消接口终止时发送 finish_reason "cancelled" 的最终 chunk 和 [DONE]
+func (h *APIHandler) writeCancelledStream(w http.ResponseWriter, flusher http.Flusher, r *http.Request, req types.ChatCompletionRequest, streamID string, created int64, capture *tee.Capture, counter *utils.TokenCounter) {
+	usage := completionUsage(nil, h.converter.EstimateMessagesTokens(req.Messages), counter.Tokens())
+	finalChunk := types.ChatCompletionStreamResponse{
+		ID:      streamID,
+		Object:  "chat.completion.chunk",
+		Created: created,
+		Model:   req.ResponseModel(),
+		Choices: []types.ChatCompletionChoice{
+			{
+				Index:        0,
+				Delta:        &types.ChatMessage{},
+				FinishReason: types.FinishReasonCancelled,
+			},
+		},
+	}
+	if req.IncludeUsage() {
+		h.writeSSE(w, finalChunk)
+		h.writeUsageChunk(w, req, streamID, created, usage)
+	} else {
+		finalChunk.Usage = &usage
+		h.writeSSE(w, finalChunk)
+	}
+	if _, err := fmt.Fprintf(w, "data: [DONE]\n\n"); err != nil {
+		log.Printf("❌ Failed to write [DONE]: %v", err)
+	}
+	flusher.Flush()
+	h.recordUsage(r, req, capturedOutput(capture), types.FinishReasonCancelled, usage.PromptTokens, usage.CompletionTokens, errCompletionCancelled)
+
+	log.Printf("🛑 [Stream] Generation cancelled through the cancel endpoint")
+	log.Printf("  └─ Content length: %d bytes", counter.Bytes())
+	log.Printf("  └─ Completion Tokens: %d", usage.CompletionTokens)
+}
+
+// writeUsageChunk 发送 stream_options.include_usage 要求的最终 chunk: choices 为空,只含本次请求的 usage
+func (h *APIHandler) writeUsageChunk(w http.ResponseWriter, req types.ChatCompletionRequest, streamID string, created int64, usage types.ChatCompletionUsage) {
+	h.writeSSE(w, types.ChatCompletionStreamResponse{
+		ID:      streamID,
+		Object:  "chat.completion.chunk",
+		Created: created,
+		Model:   req.ResponseModel(),
+		Choices: []types.ChatCompletionChoice{},
+		Usage:   &usage,
+	})
+}
+
 // handleNonStreamingResponse 处理非流式响应 - Supports both text and tool calls
-func (h *APIHandler) handleNonStreamingResponse(w http.ResponseWriter, r *http.Request, req types.ChatCompletionRequest) {
+func (h *APIHandler) handleNonStreamingResponse(w http.ResponseWriter, r *http.Request, req types.ChatCompletionRequest, completionID string, upstreamHeaders *service.ResponseHeaders) {
 	ctx := r.Context()
 
-	// Chat now returns interface{} - can be string (text) or CursorToolCall (tool call)
-	result, err := h.cursorService.Chat(ctx, req.Messages, req.Model, req.ConversationID, req.Tools)
+	// response_format=json_object/json_schema: instruct the model to answer with a matching JSON object
+	messages := req.Messages
+	if req.JSONMode() {
+		messages = utils.WithJSONModeInstruction(req.Messages, req.JSONSchema(), false)
+	}
+
+	// Chat now returns interface{} - can be CursorTextResult (text) or CursorToolCallResult (tool calls)
+	// max_tokens: the upstream read stops at the limit and the completion finishes with "length"
+	chatCtx := service.WithMaxTokens(ctx, req.MaxTokens)
+	if req.SingleToolCall() {
+		chatCtx = service.WithSingleToolCall(chatCtx)
+	}
+	result, err := h.cursorService.Chat(chatCtx, messages, req.Model, req.ConversationID, req.Tools)
+	upstreamHeaders.CopyTo(w.Header())
 	if err != nil {
+		if cancelledByRequest(ctx) {
+			log.Printf("🛑 [Non-Stream] Generation cancelled through the cancel endpoint")
+			h.writeErrorCode(w, statusClientClosedRequest, "The generation was cancelled.", "invalid_request_error", "request_cancelled")
+			return
+		}
+		if cancelledByDrain(ctx) {
+			log.Printf("🛑 [Non-Stream] Generation ended by the server shutdown")
+			h.writeErrorCode(w, http.StatusServiceUnavailable, "The server is shutting down, please retry.", "api_error", "server_shutting_down")
+			return
+		}
 		if ctx.Err() != nil {
 			log.Printf("⚠️  客户端已断开连接: %v", ctx.Err())
 			return
 		}
 		log.Printf("❌ API 调用失败: %v", err)
+		h.refundOnUnavailable(r, err)
+		h.exportGeneration(r, req, nil, "error", h.converter.EstimateMessagesTokens(req.Messages), 0, err)
+		if errors.Is(err, service.ErrUpstreamAborted) {
+			// 上游中途断开,不返回不完整的内容
+			h.writeErrorCode(w, http.StatusBadGateway, err.Error(), "upstream_error", "upstream_aborted")
+			return
+		}
+		if errors.Is(err, service.ErrEmptyCompletion) {
+			h.writeErrorCode(w, http.StatusBadGateway, err.Error(), "upstream_error", "empty_completion")
+			return
+		}
 		h.writeError(w, http.StatusInternalServerError, err.Error(), "api_error")
 		return
 	}
@@ -223,63 +430,91 @@ func (h *APIHandler) handleNonStreamingResponse(w http.ResponseWriter, r *http.R
 	promptTokens := h.converter.EstimateMessagesTokens(req.Messages)
 	
 	// Check if result is a tool call (matching Python's type checking logic)
-	if toolCall, ok := result.(types.CursorToolCall); ok {
+	if toolResult, ok := result.(types.CursorToolCallResult); ok {
+		toolCalls := toolResult.Calls
+		calls := make([]types.ToolCall, len(toolCalls))
+		for i, toolCall := range toolCalls {
+			calls[i] = types.ToolCall{
+				ID:   toolCall.ToolID,
+				Type: "function",
+				Function: types.ToolCallFunction{
+					Name:      toolCall.ToolName,
+					Arguments: toolCall.ToolInput,
+				},
+			}
+		}
+
+		// Upstream usage when reported; the estimate counts no completion tokens for tool calls
+		usage := completionUsage(toolResult.Usage, promptTokens, 0)
+
 		// Handle tool call response - match OpenAI non-streaming format
 		response := types.ChatCompletionResponse{
-			ID:      fmt.Sprintf("chatcmpl-%d", time.Now().UnixMilli()),
+			ID:      completionID,
 			Object:  "chat.completion",
 			Created: time.Now().Unix(),
-			Model:   req.Model,
+			Model:   req.ResponseModel(),
 			Choices: []types.ChatCompletionChoice{
 				{
 					Index: 0,
 					Message: &types.ChatMessage{
 						Role: "assistant",
-						ToolCalls: []types.ToolCall{
-							{
-								ID:   toolCall.ToolID,
-								Type: "function",
-								Function: types.ToolCallFunction{
-									Name:      toolCall.ToolName,
-									Arguments: toolCall.ToolInput,
-								},
-							},
-						},
+						ToolCalls: calls,
 					},
 					FinishReason: "tool_calls",
 				},
 			},
-			Usage: types.ChatCompletionUsage{
-				PromptTokens:     promptTokens,
-				CompletionTokens: 0, // Tool calls don't consume completion tokens
-				TotalTokens:      promptTokens,
-			},
+			Usage:    usage,
+			Metadata: req.Metadata,
 		}
 		
 		log.Printf("✅ [Non-Stream] Tool call response completed")
-		log.Printf("  └─ Tool ID: %s", toolCall.ToolID)
-		log.Printf("  └─ Tool Name: %s", toolCall.ToolName)
-		log.Printf("  └─ Prompt Tokens: %d", promptTokens)
+		for _, toolCall := range toolCalls {
+			log.Printf("  └─ Tool ID: %s, Name: %s", toolCall.ToolID, toolCall.ToolName)
+		}
+		log.Printf("  └─ Prompt Tokens: %d, Completion Tokens: %d", usage.PromptTokens, usage.CompletionTokens)
 		
+		h.recordUsage(r, req, toolCalls, types.FinishReasonToolCalls, usage.PromptTokens, usage.CompletionTokens, nil)
 		h.writeJSON(w, http.StatusOK, response)
 		return
 	}
 	
 	// Handle normal text response
-	content, ok := result.(string)
+	text, ok := result.(types.CursorTextResult)
 	if !ok {
 		log.Printf("❌ Unexpected result type: %T", result)
 		h.writeError(w, http.StatusInternalServerError, "Internal error: unexpected response type", "api_error")
 		return
 	}
 	
-	completionTokens := h.converter.EstimateTokens(content)
+	// Optionally have the model repair output violating response_format before guardrails run
+	content, verified := h.selfVerify(ctx, req, text.Content)
+	if verified != "" {
+		w.Header().Set(selfVerifyHeader, verified)
+	}
+	content, err = h.enforceJSONMode(ctx, req, content)
+	if err != nil {
+		log.Printf("❌ [Non-Stream] JSON mode output does not satisfy response_format")
+		log.Printf("  └─ Error: %v", err)
+		h.exportGeneration(r, req, nil, types.FinishReasonInvalidJSON, promptTokens, 0, err)
+		h.writeErrorCode(w, http.StatusBadGateway, err.Error(), "invalid_response_error", jsonModeErrorCode(err))
+		return
+	}
+	content, truncated := h.guardrails.For(middleware.APIKeyFromContext(ctx)).Apply(content)
+	if truncated && text.FinishReason == types.FinishReasonStop {
+		text.FinishReason = types.FinishReasonLength
+	}
+	// Retried or repaired answers are generated without the limit, cut them off here
+	content, truncated = utils.LimitTokens(content, req.MaxTokens)
+	if truncated && text.FinishReason == types.FinishReasonStop {
+		text.FinishReason = types.FinishReasonLength
+	}
+	usage := completionUsage(text.Usage, promptTokens, h.converter.EstimateTokens(content))
 
 	response := types.ChatCompletionResponse{
-		ID:      fmt.Sprintf("chatcmpl-%d", time.Now().UnixMilli()),
+		ID:      completionID,
 		Object:  "chat.completion",
 		Created: time.Now().Unix(),
-		Model:   req.Model,
+		Model:   req.ResponseModel(),
 		Choices: []types.ChatCompletionChoice{
 			{
 				Index: 0,
@@ -287,21 +522,20 @@ func (h *APIHandler) handleNonStreamingResponse(w http.ResponseWriter, r *http.R
 					Role:    "assistant",
 					Content: content,
 				},
-				FinishReason: "stop",
+				FinishReason: text.FinishReason,
 			},
 		},
-		Usage: types.ChatCompletionUsage{
-			PromptTokens:     promptTokens,
-			CompletionTokens: completionTokens,
-			TotalTokens:      promptTokens + completionTokens,
-		},
+		Usage:    usage,
+		Metadata: req.Metadata,
 	}
 
 	// Log metadata only (no sensitive response content)
 	log.Printf("✅ [Non-Stream] Text response completed")
 	log.Printf("  └─ Content length: %d characters", len(content))
-	log.Printf("  └─ Prompt Tokens: %d", promptTokens)
-	log.Printf("  └─ Completion Tokens: %d", completionTokens)
+	log.Printf("  └─ Finish reason: %s", text.FinishReason)
+	log.Printf("  └─ Prompt Tokens: %d", usage.PromptTokens)
+	log.Printf("  └─ Completion Tokens: %d", usage.CompletionTokens)
 
+	h.recordUsage(r, req, content, text.FinishReason, usage.PromptTokens, usage.CompletionTokens, nil)
 	h.writeJSON(w, http.StatusOK, response)
 }
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"cursor2api/metrics"
+	"cursor2api/types"
+)
+
+var deprecatedModelRequests = metrics.NewCounter(
+	"cursor2api_deprecated_model_requests_total",
+	"Requests for deprecated models by requested model and action (served or redirected).",
+	"model", "action")
+
+// applyDeprecation 处理已弃用模型的请求: 照常提供服务或改由替代模型提供,并附加 Warning 头。
+// 返回请求的原始模型名,未弃用时返回空字符串
+func (h *APIHandler) applyDeprecation(w http.ResponseWriter, req *types.ChatCompletionRequest) string {
+	dep, ok := h.catalog.Deprecation(req.Model)
+	if !ok {
+		return ""
+	}
+	model := req.Model
+
+	message := fmt.Sprintf("The model `%s` is deprecated", model)
+	action := "served"
+	switch {
+	case dep.Redirect:
+		message += fmt.Sprintf(" and this request was served by `%s`", dep.ReplacedBy)
+		action = "redirected"
+		req.Model = dep.ReplacedBy
+	case dep.ReplacedBy != "":
+		message += fmt.Sprintf("; migrate to `%s`", dep.ReplacedBy)
+	}
+	// RFC 7234 warn-code 299: miscellaneous persistent warning
+	w.Header().Add("Warning", "299 cursor2api "+strconv.Quote(message))
+	deprecatedModelRequests.Inc(model, action)
+	log.Printf("⚠️  请求了已弃用的模型: %s (action: %s, replaced by: %s)", model, action, cmp.Or(dep.ReplacedBy, "none"))
+	return model
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// writeCacheableJSON 写入可被客户端与 CDN 缓存的 JSON 响应: 附带内容摘要 ETag 与 Cache-Control,
+// If-None-Match 命中时返回 304。响应因 API Key (租户的模型白名单) 而不同,因此按 Authorization 区分缓存
+func (h *APIHandler) writeCacheableJSON(w http.ResponseWriter, r *http.Request, data interface{}, maxAge time.Duration) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		fmt.Printf("❌ JSON 编码失败: %v\n", err)
+		h.writeError(w, http.StatusInternalServerError, "Failed to encode response", "api_error")
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	header := w.Header()
+	header.Set("ETag", etag)
+	header.Add("Vary", "Authorization")
+	scope := "public"
+	if h.tenants != nil {
+		scope = "private"
+	}
+	if maxAge > 0 {
+		header.Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, int(maxAge.Seconds())))
+	} else {
+		header.Set("Cache-Control", scope+", no-cache")
+	}
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	header.Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(body, '\n'))
+}
+
+// etagMatches 按 If-None-Match 的弱比较规则判断 ETag 是否命中 (RFC 9110 13.1.2)
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"cursor2api/features"
+	"cursor2api/middleware"
+)
+
+// requestFeatures 解析 X-C2A-Features 请求头中的实验特性,只有被授权的 API Key 可以开启;
+// 失败时写入错误响应并返回 false。开启的特性会回显在同名响应头中
+func (h *APIHandler) requestFeatures(w http.ResponseWriter, r *http.Request) (features.Set, bool) {
+	set, err := features.Parse(r.Header.Get(features.Header))
+	if err != nil {
+		log.Printf("❌ 实验特性请求头无效: %v", err)
+		h.writeErrorCode(w, http.StatusBadRequest, err.Error(), "invalid_request_error", "unknown_feature")
+		return nil, false
+	}
+	if denied := h.featureGrants.Check(middleware.APIKeyFromContext(r.Context()), set); denied != "" {
+		log.Printf("🚫 API Key 未被授权开启实验特性: %s", denied)
+		h.writeErrorCode(w, http.StatusForbidden,
+			fmt.Sprintf("Your API key is not allowed to enable the experimental feature `%s`.", denied),
+			"permission_error", "feature_not_allowed")
+		return nil, false
+	}
+	if len(set) > 0 {
+		w.Header().Set(features.Header, set.String())
+	}
+	return set, true
+}
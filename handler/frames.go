@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"encoding/json"
+
+	"cursor2api/types"
+	"cursor2api/utils"
+)
+
+// sseFrameOverhead is the "data: " prefix and the blank line closing an SSE frame
+const sseFrameOverhead = len("data: \n\n")
+
+// toolCallChunks returns the stream chunks of a tool call the way OpenAI streams
+// them: the first chunk carries the ID, type and name with empty arguments, the
+// following chunks of the same index carry argument fragments of at most
+// STREAM_TOOL_ARGUMENT_CHUNK_BYTES, so clients can parse the arguments progressively.
+// With STREAM_MAX_FRAME_BYTES set, fragments are also kept below the frame limit.
+func (h *APIHandler) toolCallChunks(base types.ChatCompletionStreamResponse, call types.ToolCall) []types.ChatCompletionStreamResponse {
+	chunk := func(call types.ToolCall) types.ChatCompletionStreamResponse {
+		c := base
+		c.Choices = []types.ChatCompletionChoice{{
+			Index: 0,
+			Delta: &types.ChatMessage{ToolCalls: []types.ToolCall{call}},
+		}}
+		return c
+	}
+
+	// Every chunk carries the index, so clients can attribute the fragments of call 0
+	index := call.StreamIndex()
+	arguments := call.Function.Arguments
+	first := call
+	first.Index = &index
+	first.Function.Arguments = ""
+	chunks := []types.ChatCompletionStreamResponse{chunk(first)}
+
+	// Budget the argument fragments so their frames stay within STREAM_MAX_FRAME_BYTES
+	rest := types.ToolCall{Index: &index}
+	budget := h.config.Stream.ToolArgumentChunk
+	if limit := h.config.Stream.MaxFrameBytes; limit > 0 {
+		frameBudget := limit - frameSize(chunk(rest))
+		if budget <= 0 || frameBudget < budget {
+			budget = frameBudget
+		}
+	}
+	for _, piece := range utils.SplitJSONString(arguments, budget) {
+		rest.Function.Arguments = piece
+		chunks = append(chunks, chunk(rest))
+	}
+	return chunks
+}
+
+// frameSize returns the size of the SSE frame carrying chunk
+func frameSize(chunk types.ChatCompletionStreamResponse) int {
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		return 0
+	}
+	return len(data) + sseFrameOverhead
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"cursor2api/config"
+	"cursor2api/types"
+)
+
+// wireToolCall returns the tool call of chunk as a client decodes it
+func wireToolCall(t *testing.T, chunk types.ChatCompletionStreamResponse) types.ToolCall {
+	t.Helper()
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded types.ChatCompletionStreamResponse
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded.Choices[0].Delta.ToolCalls[0]
+}
+
+func TestToolCallChunks(t *testing.T) {
+	base := types.ChatCompletionStreamResponse{ID: "chatcmpl-1", Object: "chat.completion.chunk", Model: "claude-sonnet-4"}
+
+	for _, stream := range []config.StreamConfig{
+		{ToolArgumentChunk: 128},
+		{ToolArgumentChunk: 0},
+		{ToolArgumentChunk: 4096, MaxFrameBytes: 1024},
+		{ToolArgumentChunk: 0, MaxFrameBytes: 1024},
+	} {
+		for index := range 2 {
+			call := types.ToolCall{
+				Index:    &index,
+				ID:       "call_1",
+				Type:     "function",
+				Function: types.ToolCallFunction{Name: "write_file", Arguments: `{"path":"a.go","content":"` + strings.Repeat(`package main\n\"x\" `, 200) + `"}`},
+			}
+			checkToolCallChunks(t, stream, base, call)
+		}
+	}
+}
+
+func checkToolCallChunks(t *testing.T, stream config.StreamConfig, base types.ChatCompletionStreamResponse, call types.ToolCall) {
+	t.Helper()
+	h := &APIHandler{config: &config.Config{Stream: stream}}
+	chunks := h.toolCallChunks(base, call)
+	index := call.StreamIndex()
+
+	// The index is on the wire in every chunk, also for the first tool call
+	first := wireToolCall(t, chunks[0])
+	if first.ID != call.ID || first.Type != "function" || first.Function.Name != call.Function.Name ||
+		first.Function.Arguments != "" || first.Index == nil || *first.Index != index {
+		t.Errorf("%+v: first chunk of call %d = %+v, want the index, ID and name without arguments", stream, index, first)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("%+v: %d chunks, want the name and at least one argument fragment", stream, len(chunks))
+	}
+
+	var arguments strings.Builder
+	for _, chunk := range chunks[1:] {
+		fragment := wireToolCall(t, chunk)
+		if fragment.ID != "" || fragment.Function.Name != "" || fragment.Index == nil || *fragment.Index != index {
+			t.Errorf("%+v: fragment of call %d = %+v, want only the index and arguments", stream, index, fragment)
+		}
+		if chunk.ID != base.ID || chunk.Model != base.Model {
+			t.Errorf("%+v: fragment chunk = %+v, want the base ID and model", stream, chunk)
+		}
+		if stream.MaxFrameBytes > 0 && frameSize(chunk) > stream.MaxFrameBytes {
+			t.Errorf("%+v: frame of %d bytes", stream, frameSize(chunk))
+		}
+		arguments.WriteString(fragment.Function.Arguments)
+	}
+	if arguments.String() != call.Function.Arguments {
+		t.Errorf("%+v: arguments of call %d rejoin to %q", stream, index, arguments.String())
+	}
+	if stream.ToolArgumentChunk == 0 && stream.MaxFrameBytes == 0 && len(chunks) != 2 {
+		t.Errorf("%+v: %d chunks, want the arguments in one fragment", stream, len(chunks))
+	}
+}
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"cursor2api/types"
+	"cursor2api/utils"
+)
+
+// HandleGenerateContent 处理 POST /v1beta/models/{model}:generateContent (Gemini API)
+func (h *APIHandler) HandleGenerateContent(w http.ResponseWriter, r *http.Request) {
+	h.handleGemini(w, r, false)
+}
+
+// HandleStreamGenerateContent 处理 POST /v1beta/models/{model}:streamGenerateContent
+// ?alt=sse 时以 SSE 发送事件,否则与 Gemini API 一致地流式发送一个 JSON 数组
+func (h *APIHandler) HandleStreamGenerateContent(w http.ResponseWriter, r *http.Request) {
+	h.handleGemini(w, r, true)
+}
+
+// handleGemini 将 Gemini 请求转换为 OpenAI 格式后交给 HandleChatCompletions,因此鉴权、
+// 额度、租户与用量统计与 /v1/chat/completions 完全一致; 响应 (包括错误) 再转换回 Gemini 格式
+func (h *APIHandler) handleGemini(w http.ResponseWriter, r *http.Request, stream bool) {
+	// 带 "/" 的模型名 (如 openai/gpt-5) 由 /v1beta/models/{provider}/{model}:... 路由匹配
+	model := r.PathValue("model")
+	if provider := r.PathValue("provider"); provider != "" {
+		model = provider + "/" + model
+	}
+
+	var req types.GeminiRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("❌ 无效的 Gemini API 请求: %v", err)
+		writeGeminiError(w, http.StatusBadRequest, "Invalid JSON payload: "+err.Error())
+		return
+	}
+	chatReq, err := utils.GeminiToOpenAI(model, req, stream)
+	if err != nil {
+		log.Printf("❌ Gemini API 请求转换失败: %v", err)
+		writeGeminiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	body, err := json.Marshal(chatReq)
+	if err != nil {
+		writeGeminiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Printf("🔀 Gemini API 请求转换为 chat completion (model: %s, contents: %d → messages: %d, tools: %d)",
+		model, len(req.Contents), len(chatReq.Messages), len(chatReq.Tools))
+	r = r.Clone(r.Context())
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	gw := &translatingWriter{
+		ResponseWriter: w,
+		format: &geminiFormat{
+			stream: utils.NewGeminiStream(h.converter.EstimateMessagesTokens(chatReq.Messages)),
+			sse:    r.URL.Query().Get("alt") == "sse",
+		},
+	}
+	h.HandleChatCompletions(gw, r)
+	gw.finish()
+}
+
+// writeGeminiError 写入 Gemini API 格式的错误响应
+func writeGeminiError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	response := types.GeminiErrorResponse{
+		Error: types.GeminiError{Code: status, Message: message, Status: utils.GeminiErrorStatus(status)},
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("❌ 写入 Gemini API 错误响应失败: %v", err)
+	}
+}
+
+// geminiFormat 把 chat completion 响应转换为 Gemini 格式
+type geminiFormat struct {
+	stream *utils.GeminiStream
+	sse    bool // alt=sse: "data: <event>" 事件; 否则为流式 JSON 数组
+	events int  // 已编码的事件数,JSON 数组模式下决定分隔符
+}
+
+func (f *geminiFormat) streamContentType() string {
+	if f.sse {
+		return "text/event-stream"
+	}
+	return "application/json"
+}
+
+// encodeChunk 编码转换出的事件: SSE 模式为 "data: <event>",否则为 JSON 数组元素
+func (f *geminiFormat) encodeChunk(chunk *types.ChatCompletionStreamResponse) ([]byte, error) {
+	var events []types.GeminiResponse
+	if chunk == nil {
+		events = f.stream.Finish()
+	} else {
+		events = f.stream.Chunk(*chunk)
+	}
+	var frames []byte
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case f.sse:
+			frames = append(append(append(frames, "data: "...), data...), "\r\n\r\n"...)
+		case f.events == 0:
+			frames = append(append(frames, '['), data...)
+		default:
+			frames = append(append(frames, ",\r\n"...), data...)
+		}
+		f.events++
+	}
+	return frames, nil
+}
+
+// endStream 结束流式 JSON 数组; 客户端断开等情况下流未正常结束,仍补全数组
+func (f *geminiFormat) endStream() []byte {
+	switch {
+	case f.sse:
+		return nil
+	case f.events == 0:
+		return []byte("[]")
+	default:
+		return []byte("]")
+	}
+}
+
+// keepAlive SSE 模式返回注释; JSON 数组模式返回元素之间允许出现的空白
+func (f *geminiFormat) keepAlive() []byte {
+	if f.sse {
+		return []byte(": ping\r\n\r\n")
+	}
+	return []byte("\n")
+}
+
+func (f *geminiFormat) encodeResponse(resp types.ChatCompletionResponse) interface{} {
+	return utils.OpenAIToGemini(resp)
+}
+
+func (f *geminiFormat) writeError(w http.ResponseWriter, status int, message string) {
+	writeGeminiError(w, status, message)
+}
@@ -1,9 +1,32 @@
 package handler
 
 import (
+	"cmp"
+	"context"
+	"log"
+	"maps"
+	"net/http"
+	"time"
+
+	"cursor2api/availability"
+	"cursor2api/canary"
+	"cursor2api/catalog"
 	"cursor2api/config"
+	"cursor2api/features"
+	"cursor2api/guardrail"
+	"cursor2api/guidance"
+	"cursor2api/heartbeat"
+	"cursor2api/keepwarm"
+	"cursor2api/middleware"
 	"cursor2api/models"
+	"cursor2api/observability"
 	"cursor2api/service"
+	"cursor2api/shadow"
+	"cursor2api/tenant"
+	"cursor2api/transcript"
+	"cursor2api/types"
+	"cursor2api/upstream"
+	"cursor2api/usage"
 	"cursor2api/utils"
 )
 
@@ -13,14 +36,159 @@ type APIHandler struct {
 	manager       *models.AntiBotManager
 	converter     *utils.MessageConverter
 	config        *config.Config
+	usage         *usage.Recorder
+	exporter      *observability.Exporter
+	transcripts   *transcript.Store
+	shadow        *shadow.Mirror
+	canary        *canary.Rollout
+	guardrails    *guardrail.Set
+	prompts       *utils.PromptLibrary
+	presets       *utils.PresetSet
+	autoRouter    *utils.AutoRouter // 伪模型 auto 的路由规则,未启用时为 nil
+	tenants       *tenant.Registry
+	userLimiter   *middleware.RateLimiter      // 按 `user` 字段的终端用户限流,未配置时为 nil
+	rateLimiter   *middleware.RateLimiter      // 全局限流器,仅用于在有效配置中展示策略调整后的限额
+	inflight      *completionRegistry          // 进行中的生成请求,供取消接口使用
+	keyTags       map[string]map[string]string // api_key -> upstream tagging headers
+	heartbeat     *heartbeat.Reporter          // 健康与用量快照推送,未配置时为 nil
+	sandboxKeys   map[string]bool              // 沙箱 API Key,请求不访问真实上游也不计入额度
+	recent        *recentCompletions           // 最近完成请求的摘要,供 /admin/recent 使用
+	featureGrants *features.Grants             // 可通过 X-C2A-Features 开启实验特性的 API Key
+	guidance      *guidance.Fetcher            // 上游模型指南 (默认系统提示词与模型说明),未配置时为 nil
+	catalog       *catalog.Catalog             // /v1/models 的模型列表与别名,可通过 PUT /admin/models 替换
+	keepWarm      *keepwarm.Scheduler          // 空闲时段的保温请求,未配置时为 nil
+	availability  *availability.Manager        // 降级策略: 依赖故障时决定 chat、缓存参数与额度如何处理
+	startedAt     time.Time                    // 启动时间,内置模型列表的 created,使 /v1/models 的 ETag 保持稳定
 }
 
-// NewAPIHandler 创建 API 处理器
-func NewAPIHandler(cursorService *service.CursorService, manager *models.AntiBotManager, cfg *config.Config) *APIHandler {
-	return &APIHandler{
+// NewAPIHandler 创建 API 处理器; tenants 为 nil 时不启用多租户
+func NewAPIHandler(cursorService *service.CursorService, manager *models.AntiBotManager, cfg *config.Config, tenants *tenant.Registry) *APIHandler {
+	prompts, err := utils.LoadPromptLibrary(cfg.Cursor.PromptTemplatesFile)
+	if err != nil {
+		log.Printf("⚠️  Warning: 加载提示词模板失败,prompt_id 请求将被拒绝: %v", err)
+	} else if prompts != nil {
+		log.Printf("📚 已加载 %d 个提示词模板", prompts.Len())
+	}
+
+	presets, err := utils.LoadPresets(cfg.Cursor.PresetsFile)
+	if err != nil {
+		log.Printf("⚠️  Warning: 加载参数预设失败,已忽略: %v", err)
+	} else if presets != nil {
+		log.Printf("🎛️  已加载 %d 个参数预设", presets.Len())
+	}
+
+	var autoRouter *utils.AutoRouter
+	if cfg.Cursor.AutoModelEnabled {
+		autoRouter, err = utils.LoadAutoRouter(cfg.Cursor.AutoModelRulesFile)
+		if err != nil {
+			log.Printf("⚠️  Warning: 加载自动模型路由规则失败,auto 模型不可用: %v", err)
+		} else {
+			log.Printf("🧭 自动模型路由已启用 - %d 条规则, 默认模型: %s", len(autoRouter.Rules), autoRouter.Default)
+		}
+	}
+
+	keyTags, err := upstream.LoadKeyTagHeaders(cfg.Cursor.KeyUpstreamHeadersFile)
+	if err != nil {
+		log.Printf("⚠️  Warning: 加载 API Key 上游标记头部失败,已忽略: %v", err)
+	}
+
+	var userLimiter *middleware.RateLimiter
+	if cfg.RateLimit.Enabled && cfg.RateLimit.UserRequestsPerSec > 0 {
+		userLimiter = middleware.NewRateLimiter(cfg.RateLimit.UserRequestsPerSec, cfg.RateLimit.UserBurst,
+			cfg.RateLimit.Strategy, true, cfg.RateLimit.CleanupInterval)
+	}
+
+	h := &APIHandler{
 		cursorService: cursorService,
 		manager:       manager,
 		converter:     utils.NewMessageConverter(cfg.Cursor.SystemPrompt),
 		config:        cfg,
+		usage:         usage.NewRecorder(cfg.Usage, cfg.Storage),
+		exporter:      observability.NewExporter(cfg.Observability),
+		transcripts:   transcript.NewStore(cfg.Observability.TranscriptDir, cfg.Storage),
+		shadow:        shadow.New(cfg.Shadow),
+		canary:        canary.New(cfg.Canary),
+		guardrails:    guardrail.New(cfg.Guardrail),
+		prompts:       prompts,
+		presets:       presets,
+		autoRouter:    autoRouter,
+		tenants:       tenants,
+		userLimiter:   userLimiter,
+		inflight:      newCompletionRegistry(),
+		keyTags:       keyTags,
+		sandboxKeys:   make(map[string]bool, len(cfg.Auth.SandboxKeys)),
+		featureGrants: features.NewGrants(cfg.Auth.FeatureGrants),
+		recent:        newRecentCompletions(cfg.Observability.RecentCompletions, cfg.Observability.RecentPreviewChars),
+		guidance:      guidance.New(cfg.Guidance),
+		catalog:       catalog.New(cfg.Cursor.ModelCatalogFile, cfg.Cursor.ModelCatalog, cfg.Cursor.ModelAliases),
+		startedAt:     time.Now(),
+	}
+	for _, key := range cfg.Auth.SandboxKeys {
+		h.sandboxKeys[key] = true
+	}
+
+	// Mock 模式下不需要 AntiBot 参数,chat 不会因参数降级
+	var tokens availability.TokenSource
+	if !cfg.Mock.Enabled {
+		tokens = manager
 	}
+	h.availability = availability.New(cfg.Availability, tokens, h.usage.StorageError)
+	manager.UseStaleTokenPolicy(h.availability)
+
+	h.heartbeat = heartbeat.New(cfg.Heartbeat,
+		func() interface{} { return h.healthSnapshot() },
+		func() interface{} {
+			return usage.Report{GeneratedAt: time.Now().UTC(), Usage: h.usage.Snapshot(), Spend: h.usage.SpendSnapshot()}
+		})
+	h.heartbeat.Start()
+	h.transcripts.Start()
+	h.guidance.Start()
+	h.keepWarm = keepwarm.New(cfg.KeepWarm, h.keepWarmChat)
+	h.keepWarm.Start()
+	return h
+}
+
+// keepWarmChat 发送保温请求: 一条极短的非流式请求,只为保持 AntiBot 参数、TLS 会话与上游链路可用
+func (h *APIHandler) keepWarmChat(ctx context.Context) error {
+	model := cmp.Or(h.config.KeepWarm.Model, h.catalog.Models()[0].ID)
+	messages := []types.ChatMessage{{Role: "user", Content: "Reply with OK."}}
+	_, err := h.cursorService.Chat(ctx, messages, model, "", nil)
+	return err
+}
+
+// upstreamTags 合并租户与 API Key 的上游标记头部,Key 的配置优先
+func (h *APIHandler) upstreamTags(r *http.Request, t *tenant.Tenant) map[string]string {
+	keyTags := h.keyTags[middleware.APIKeyFromContext(r.Context())]
+	if t == nil || len(t.UpstreamHeaders) == 0 {
+		return keyTags
+	}
+	tags := maps.Clone(t.UpstreamHeaders)
+	maps.Copy(tags, keyTags)
+	return tags
+}
+
+// Usage 返回用量记录器
+func (h *APIHandler) Usage() *usage.Recorder {
+	return h.usage
+}
+
+// UserRateLimiter 返回终端用户限流器,未配置时为 nil
+func (h *APIHandler) UserRateLimiter() *middleware.RateLimiter {
+	return h.userLimiter
+}
+
+// UseRateLimiter 设置全局限流器,GET /admin/config/effective 据此展示当前生效的限额
+func (h *APIHandler) UseRateLimiter(rl *middleware.RateLimiter) {
+	h.rateLimiter = rl
+}
+
+// Close 停止后台导出器、心跳推送、保温请求与模型指南拉取并刷新未发送的记录,等待进行中的影子请求
+func (h *APIHandler) Close() {
+	h.heartbeat.Stop()
+	h.keepWarm.Stop()
+	h.transcripts.Stop()
+	h.guidance.Stop()
+	h.userLimiter.Stop()
+	h.exporter.Stop()
+	h.shadow.Stop()
 }
@@ -0,0 +1,277 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cursor2api/middleware"
+	"cursor2api/tenant"
+	"cursor2api/types"
+)
+
+// statusClientClosedRequest 非流式请求被取消时的状态码 (nginx 约定的 499)
+const statusClientClosedRequest = 499
+
+// errCompletionCancelled 通过取消接口终止的生成请求的取消原因
+var errCompletionCancelled = errors.New("generation cancelled through the cancel endpoint")
+
+// errServerDraining 关闭期间仍未结束的生成请求的取消原因
+var errServerDraining = errors.New("server is shutting down")
+
+// errTooManyStreams track 在 API Key 的并发流数达到 STREAM_MAX_PER_KEY 时返回
+var errTooManyStreams = errors.New("too many concurrent streams for this API key")
+
+// inflightCompletion 一个进行中的生成请求
+type inflightCompletion struct {
+	id      string
+	apiKey  string // 只有同一个 API Key 可以取消
+	tenant  string
+	model   string
+	stream  bool
+	started time.Time
+	cancel  context.CancelCauseFunc
+	bytes   atomic.Int64 // 已写给客户端的流式响应字节数
+}
+
+// activeStream /admin/streams 中的一个流式连接
+type activeStream struct {
+	ID        string    `json:"id"`
+	Key       string    `json:"key"` // Masked API key
+	Tenant    string    `json:"tenant,omitempty"`
+	Model     string    `json:"model"`
+	StartedAt time.Time `json:"started_at"`
+	Elapsed   string    `json:"elapsed"`
+	BytesSent int64     `json:"bytes_sent"`
+}
+
+// completionRegistry 跟踪进行中的生成请求,使其可以在服务端被取消
+// (用于无法可靠中断 SSE 连接的客户端)
+type completionRegistry struct {
+	mu      sync.Mutex
+	entries map[string]*inflightCompletion
+}
+
+// newCompletionRegistry 创建进行中请求的登记表
+func newCompletionRegistry() *completionRegistry {
+	return &completionRegistry{entries: make(map[string]*inflightCompletion)}
+}
+
+// newCompletionID 生成 OpenAI 风格的 completion ID
+func newCompletionID() string {
+	return "chatcmpl-" + rand.Text()[:24]
+}
+
+// track 登记一个生成请求,返回可被取消的 context 和登记项; 请求结束时调用 release。
+// maxStreams > 0 时同一 API Key 的并发流式请求超过该数量会返回 errTooManyStreams
+// (未鉴权的请求没有 Key,不受限制)
+func (cr *completionRegistry) track(ctx context.Context, id, apiKey string, req types.ChatCompletionRequest, maxStreams int) (context.Context, *inflightCompletion, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if req.Stream && maxStreams > 0 && apiKey != "" && cr.streamsOf(apiKey) >= maxStreams {
+		return ctx, nil, errTooManyStreams
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	entry := &inflightCompletion{
+		id:      id,
+		apiKey:  apiKey,
+		model:   req.Model,
+		stream:  req.Stream,
+		started: time.Now(),
+		cancel:  cancel,
+	}
+	if t := tenant.FromContext(ctx); t != nil {
+		entry.tenant = t.Name
+	}
+	cr.entries[id] = entry
+	return ctx, entry, nil
+}
+
+// release 注销请求结束的登记项
+func (cr *completionRegistry) release(entry *inflightCompletion) {
+	cr.mu.Lock()
+	delete(cr.entries, entry.id)
+	cr.mu.Unlock()
+	entry.cancel(nil)
+}
+
+// streamsOf 返回 apiKey 进行中的流式请求数,调用方需持有 mu
+func (cr *completionRegistry) streamsOf(apiKey string) int {
+	n := 0
+	for _, entry := range cr.entries {
+		if entry.stream && entry.apiKey == apiKey {
+			n++
+		}
+	}
+	return n
+}
+
+// streams 返回进行中的流式请求,最早开始的在前; scope 非空时只返回该租户的请求
+func (cr *completionRegistry) streams(scope string) []activeStream {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	now := time.Now()
+	streams := make([]activeStream, 0, len(cr.entries))
+	for _, entry := range cr.entries {
+		if !entry.stream || (scope != "" && entry.tenant != scope) {
+			continue
+		}
+		streams = append(streams, activeStream{
+			ID:        entry.id,
+			Key:       middleware.MaskAPIKey(entry.apiKey),
+			Tenant:    entry.tenant,
+			Model:     entry.model,
+			StartedAt: entry.started.UTC(),
+			Elapsed:   now.Sub(entry.started).Round(time.Millisecond).String(),
+			BytesSent: entry.bytes.Load(),
+		})
+	}
+	slices.SortFunc(streams, func(a, b activeStream) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+	return streams
+}
+
+// cancelAll 以 cause 取消所有进行中的请求,返回取消的数量
+func (cr *completionRegistry) cancelAll(cause error) int {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	for _, entry := range cr.entries {
+		entry.cancel(cause)
+	}
+	return len(cr.entries)
+}
+
+// cancel 取消 apiKey 发起的进行中请求; 请求不存在或属于其他 Key 时返回 false
+func (cr *completionRegistry) cancel(id, apiKey string) (*inflightCompletion, bool) {
+	cr.mu.Lock()
+	entry, ok := cr.entries[id]
+	cr.mu.Unlock()
+	if !ok || subtle.ConstantTimeCompare([]byte(entry.apiKey), []byte(apiKey)) != 1 {
+		return nil, false
+	}
+	entry.cancel(errCompletionCancelled)
+	return entry, true
+}
+
+// lookup 返回进行中的请求
+func (cr *completionRegistry) lookup(id string) (*inflightCompletion, bool) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	entry, ok := cr.entries[id]
+	return entry, ok
+}
+
+// cancelledByRequest 报告 ctx 是否因取消接口而结束 (而非客户端断开)
+func cancelledByRequest(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errCompletionCancelled)
+}
+
+// cancelledByDrain 报告 ctx 是否因服务关闭而结束
+func cancelledByDrain(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errServerDraining)
+}
+
+// countingWriter 统计写给客户端的流式响应字节数
+type countingWriter struct {
+	http.ResponseWriter
+	entry *inflightCompletion
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.entry.bytes.Add(int64(n))
+	return n, err
+}
+
+func (w *countingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// DrainStreams 在关闭期间结束仍在进行的生成请求: 在 ctx 截止前
+// SERVER_STREAM_DRAIN_GRACE 以 errServerDraining 取消它们,使流式响应在连接被强制
+// 关闭前以 server_shutting_down 错误事件正常结束。返回的函数停止计时
+func (h *APIHandler) DrainStreams(ctx context.Context) (stop func() bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return func() bool { return false }
+	}
+	delay := max(time.Until(deadline)-h.config.Server.StreamDrainGrace, 0)
+	timer := time.AfterFunc(delay, func() {
+		if n := h.inflight.cancelAll(errServerDraining); n > 0 {
+			log.Printf("🛑 服务关闭中,终止 %d 个进行中的生成请求", n)
+		}
+	})
+	return timer.Stop
+}
+
+// HandleCancelCompletion 处理 POST /v1/chat/completions/{id}/cancel
+// 取消同一 API Key 发起的进行中的生成请求: 流式响应以 finish_reason "cancelled" 结束,
+// 非流式请求返回 request_cancelled 错误
+func (h *APIHandler) HandleCancelCompletion(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	entry, ok := h.inflight.cancel(id, middleware.APIKeyFromContext(r.Context()))
+	if !ok {
+		h.writeErrorCode(w, http.StatusNotFound, "No in-flight completion with ID "+id, "invalid_request_error", "completion_not_found")
+		return
+	}
+
+	log.Printf("🛑 已取消进行中的生成请求: %s", id)
+	log.Printf("  └─ Model: %s", entry.model)
+	log.Printf("  └─ Stream: %v", entry.stream)
+	log.Printf("  └─ Elapsed: %v", time.Since(entry.started).Round(time.Millisecond))
+	h.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":        id,
+		"object":    "chat.completion.cancellation",
+		"cancelled": true,
+	})
+}
+
+// HandleStreams 处理 GET /admin/streams
+// 返回进行中的流式连接 (ID、脱敏的 Key、模型、开始时间、已发送字节数),最早开始的在前。
+// 租户范围的管理令牌只能看到本租户的连接
+func (h *APIHandler) HandleStreams(w http.ResponseWriter, r *http.Request) {
+	scope := middleware.AdminTenantFromContext(r.Context())
+	streams := h.inflight.streams(scope)
+	h.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"tenant":      scope,
+		"count":       len(streams),
+		"max_per_key": h.config.Stream.MaxPerKey,
+		"streams":     streams,
+	})
+}
+
+// HandleStreamCancel 处理 DELETE /admin/streams/{id}
+// 终止任意 Key 的进行中的生成请求,客户端收到的结果与取消接口相同
+func (h *APIHandler) HandleStreamCancel(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	entry, ok := h.inflight.lookup(id)
+	if !ok || !entry.stream {
+		h.writeErrorCode(w, http.StatusNotFound, "No active stream with ID "+id, "invalid_request_error", "stream_not_found")
+		return
+	}
+	entry.cancel(errCompletionCancelled)
+
+	log.Printf("🛑 管理员终止了流式连接: %s", id)
+	log.Printf("  └─ Key: %s", middleware.MaskAPIKey(entry.apiKey))
+	log.Printf("  └─ Model: %s", entry.model)
+	log.Printf("  └─ Bytes Sent: %d", entry.bytes.Load())
+	h.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":        id,
+		"cancelled": true,
+	})
+}
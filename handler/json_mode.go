@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"cursor2api/metrics"
+	"cursor2api/types"
+	"cursor2api/utils"
+)
+
+var (
+	jsonModeStreams = metrics.NewCounter(
+		"cursor2api_json_mode_streams_total",
+		"Streamed json_object and json_schema completions by result (valid, retried, failed).",
+		"result")
+	jsonModeCompletions = metrics.NewCounter(
+		"cursor2api_json_mode_completions_total",
+		"Non-streaming json_object and json_schema completions by result (valid, retried, failed).",
+		"result")
+)
+
+// validateResponseFormat 校验 response_format: 类型必须已知,json_schema 必须带可编译的 schema
+func validateResponseFormat(format *types.ResponseFormat) error {
+	if format == nil {
+		return nil
+	}
+	switch format.Type {
+	case "text", "json_object":
+		return nil
+	case "json_schema":
+	default:
+		return fmt.Errorf("Invalid value for 'response_format.type': %q. Supported values are: 'text', 'json_object' and 'json_schema'.", format.Type)
+	}
+	if format.JSONSchema == nil || format.JSONSchema.Name == "" {
+		return errors.New("Missing required parameter: 'response_format.json_schema.name'.")
+	}
+	if len(format.JSONSchema.Schema) == 0 {
+		return fmt.Errorf("Missing required parameter: 'response_format.json_schema.schema' of %q.", format.JSONSchema.Name)
+	}
+	if _, err := utils.CompileJSONSchema(format.JSONSchema.Schema); err != nil {
+		return fmt.Errorf("Invalid schema for response_format %q: %v", format.JSONSchema.Name, err)
+	}
+	return nil
+}
+
+// requestSchema 编译 json_schema 请求的 schema; 其他请求返回 nil (schema 已在请求校验时检查)
+func requestSchema(req types.ChatCompletionRequest) *utils.JSONSchema {
+	raw := req.JSONSchema()
+	if raw == nil {
+		return nil
+	}
+	schema, err := utils.CompileJSONSchema(raw)
+	if err != nil {
+		return nil
+	}
+	return schema
+}
+
+// jsonModeErrorCode 返回 JSON 模式失败的错误码: 输出是 JSON 对象但不符合 schema 时为 json_schema_violation
+func jsonModeErrorCode(err error) string {
+	if errors.Is(err, utils.ErrSchemaMismatch) {
+		return "json_schema_violation"
+	}
+	return "json_mode_violation"
+}
+
+// enforceJSONMode 校验非流式 json_object / json_schema 请求的输出,返回提取出的 JSON 对象。
+// 不满足约束时以更严格的指令重试 (STREAM_JSON_MODE_RETRIES),仍不满足时返回错误
+func (h *APIHandler) enforceJSONMode(ctx context.Context, req types.ChatCompletionRequest, content string) (string, error) {
+	if !req.JSONMode() {
+		return content, nil
+	}
+	schema := requestSchema(req)
+	object, problems := utils.CheckJSONObject(content, req.RequiredFields(), schema)
+	if len(problems) == 0 {
+		jsonModeCompletions.Inc("valid")
+		return object, nil
+	}
+
+	retries := h.config.Stream.JSONModeRetries
+	messages := utils.WithJSONModeInstruction(req.Messages, req.JSONSchema(), true)
+	for attempt := 1; attempt <= retries; attempt++ {
+		log.Printf("🔁 [Non-Stream] JSON 模式输出不满足约束,使用更严格的指令重试 (%d/%d)", attempt, retries)
+		log.Printf("  └─ Problems: %s", strings.Join(problems, " "))
+		result, err := h.cursorService.Chat(ctx, messages, req.Model, req.ConversationID, nil)
+		if err != nil {
+			jsonModeCompletions.Inc("failed")
+			return "", fmt.Errorf("json mode retry failed: %w", err)
+		}
+		text, ok := result.(types.CursorTextResult)
+		if !ok {
+			continue
+		}
+		if object, problems = utils.CheckJSONObject(text.Content, req.RequiredFields(), schema); len(problems) == 0 {
+			jsonModeCompletions.Inc("retried")
+			return object, nil
+		}
+	}
+
+	jsonModeCompletions.Inc("failed")
+	if schema != nil && object != "" {
+		return "", fmt.Errorf("%w: %s", utils.ErrSchemaMismatch, strings.Join(problems, " "))
+	}
+	return "", fmt.Errorf("%w: %s", utils.ErrNotJSON, strings.Join(problems, " "))
+}
+
+// jsonModeStream 逐块校验流式 json_object / json_schema 请求的输出。模型在发送任何 JSON 之前
+// 偏离为普通文本时,停止上游流并以更严格的指令改用非流式请求重试;已有部分对象
+// 发送给客户端后无法撤回,只能以 invalid_json 结束 (对象闭合后才能对照 schema 校验)
+type jsonModeStream struct {
+	h         *APIHandler
+	req       types.ChatCompletionRequest
+	schema    *utils.JSONSchema // json_schema 请求的 schema,其他请求为 nil
+	validator utils.JSONStreamValidator
+	object    strings.Builder    // 已发送的对象部分,闭合后对照 schema 校验
+	stop      context.CancelFunc // 取消上游流
+	done      bool               // 结果已确定,后续结束信号原样通过
+}
+
+// filter 校验一个文本块,返回应发送给客户端的部分; 返回的 finish 非空时流应立即结束
+func (j *jsonModeStream) filter(ctx context.Context, chunk string) (string, *types.StreamFinish) {
+	if j.done {
+		return "", nil
+	}
+	out, err := j.validator.Write(chunk)
+	j.object.WriteString(out)
+	switch {
+	case err == nil && j.validator.Complete():
+		// 对象已闭合,丢弃之后的说明文字或代码块结尾
+		j.stop()
+		if _, problems := utils.CheckJSONObject(j.object.String(), nil, j.schema); len(problems) > 0 {
+			_, finish := j.fail(fmt.Errorf("%w: %s", utils.ErrSchemaMismatch, strings.Join(problems, " ")))
+			return out, finish
+		}
+		j.done = true
+		jsonModeStreams.Inc("valid")
+		return out, &types.StreamFinish{Reason: types.FinishReasonStop}
+	case err == nil:
+		return out, nil
+	case j.validator.Started():
+		j.stop()
+		return j.fail(err)
+	default:
+		j.stop()
+		return j.retry(ctx)
+	}
+}
+
+// finish 处理上游流的结束信号: 正常结束但对象未闭合时重试或报错
+func (j *jsonModeStream) finish(ctx context.Context, finish types.StreamFinish) (string, types.StreamFinish) {
+	if j.done || finish.Reason != types.FinishReasonStop {
+		return "", finish
+	}
+	var (
+		out    string
+		result *types.StreamFinish
+	)
+	if j.validator.Started() {
+		out, result = j.fail(fmt.Errorf("%w: the object was not closed", utils.ErrNotJSON))
+	} else {
+		out, result = j.retry(ctx)
+	}
+	return out, *result
+}
+
+// retry 以非流式请求重新生成,返回提取出的 JSON 对象
+func (j *jsonModeStream) retry(ctx context.Context) (string, *types.StreamFinish) {
+	j.done = true
+	retries := j.h.config.Stream.JSONModeRetries
+	messages := utils.WithJSONModeInstruction(j.req.Messages, j.req.JSONSchema(), true)
+	for attempt := 1; attempt <= retries; attempt++ {
+		log.Printf("🔁 [Stream] JSON 模式输出偏离为普通文本,使用非流式请求重试 (%d/%d)", attempt, retries)
+		result, err := j.h.cursorService.Chat(ctx, messages, j.req.Model, j.req.ConversationID, nil)
+		if err != nil {
+			return j.fail(fmt.Errorf("json mode retry failed: %w", err))
+		}
+		if text, ok := result.(types.CursorTextResult); ok {
+			if object, problems := utils.CheckJSONObject(text.Content, nil, j.schema); len(problems) == 0 {
+				jsonModeStreams.Inc("retried")
+				return object, &types.StreamFinish{Reason: types.FinishReasonStop}
+			}
+		}
+	}
+	return j.fail(utils.ErrNotJSON)
+}
+
+// fail 以 invalid_json 结束流
+func (j *jsonModeStream) fail(err error) (string, *types.StreamFinish) {
+	j.done = true
+	jsonModeStreams.Inc("failed")
+	return "", &types.StreamFinish{Reason: types.FinishReasonInvalidJSON, Err: err}
+}
@@ -1,56 +1,76 @@
 package handler
 
 import (
+	"cmp"
+	"fmt"
+	"log"
+	"maps"
 	"net/http"
+	"slices"
 	"time"
 
+	"cursor2api/tenant"
 	"cursor2api/types"
+	"cursor2api/utils"
 )
 
 // HandleModels handles /v1/models request
 // Returns the list of available Cursor AI models
 func (h *APIHandler) HandleModels(w http.ResponseWriter, r *http.Request) {
-	// Return fixed list of Cursor AI models
-	// These are the standard models supported by Cursor
-	created := time.Now().Unix()
-
-	models := []types.Model{
-	{
-		ID:      "anthropic/claude-4.5-sonnet",
-		Object:  "model",
-		Created: created,
-		OwnedBy: "cursor",
-	},
-	{
-		ID:      "anthropic/claude-4-sonnet",
-		Object:  "model",
-		Created: created,
-		OwnedBy: "cursor",
-	},
-	{
-		ID:      "anthropic/claude-opus-4.1",
-		Object:  "model",
-		Created: created,
-		OwnedBy: "cursor",
-	},
-	{
-		ID:      "openai/gpt-5",
-		Object:  "model",
-		Created: created,
-		OwnedBy: "cursor",
-	},
-	{
-		ID:      "google/gemini-2.5-pro",
-		Object:  "model",
-		Created: created,
-		OwnedBy: "cursor",
-	},
-	{
-		ID:      "xai/grok-4",
-		Object:  "model",
-		Created: created,
-		OwnedBy: "cursor",
-	},
+	// Models of the catalog, replaceable at runtime through PUT /admin/models.
+	// created only changes with the catalog so the ETag stays stable between polls
+	snap := h.catalog.Snapshot()
+	created := h.startedAt.Unix()
+	if snap.UpdatedAt != nil {
+		created = snap.UpdatedAt.Unix()
+	}
+
+	entries := snap.Models
+	models := make([]types.Model, 0, len(entries)+len(snap.Aliases)+1)
+	for _, m := range entries {
+		models = append(models, types.Model{
+			ID:      m.ID,
+			Object:  "model",
+			Created: created,
+			OwnedBy: m.OwnedBy,
+
+			ContextWindow: m.ContextWindow,
+			SupportsTools: m.SupportsTools,
+			Vision:        m.Vision,
+
+			Deprecated: m.Deprecated,
+			ReplacedBy: m.ReplacedBy,
+		})
+	}
+
+	// Aliases are listed with the metadata of their target so clients that check
+	// the list before sending a request accept them
+	for _, alias := range slices.Sorted(maps.Keys(snap.Aliases)) {
+		i := slices.IndexFunc(models, func(m types.Model) bool { return m.ID == snap.Aliases[alias] })
+		if i < 0 {
+			continue
+		}
+		entry := models[i]
+		entry.ID, entry.AliasFor = alias, snap.Aliases[alias]
+		models = append(models, entry)
+	}
+
+	// The auto pseudo-model is routed to one of the models above
+	if h.autoRouter != nil {
+		models = append(models, types.Model{
+			ID:      utils.AutoModel,
+			Object:  "model",
+			Created: created,
+			OwnedBy: "cursor2api",
+		})
+	}
+
+	// Descriptions published upstream, and context windows the catalog does not set
+	h.guidance.Annotate(models)
+
+	// Tenants only see the models on their allowlist, and the aliases of those models
+	if t := tenant.FromContext(r.Context()); t != nil {
+		models = slices.DeleteFunc(models, func(m types.Model) bool { return !t.AllowsModel(cmp.Or(m.AliasFor, m.ID)) })
 	}
 
 	response := types.ModelList{
@@ -58,27 +78,69 @@ func (h *APIHandler) HandleModels(w http.ResponseWriter, r *http.Request) {
 		Data:   models,
 	}
 
-	h.writeJSON(w, http.StatusOK, response)
+	h.writeCacheableJSON(w, r, response, h.config.Cursor.ModelsCacheMaxAge)
 }
 
 // HandleHealth handles /health request
 // Returns service health status and statistics
 func (h *APIHandler) HandleHealth(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, http.StatusOK, h.healthSnapshot())
+}
+
+// healthSnapshot collects the health status served at /health and pushed by the heartbeat
+func (h *APIHandler) healthSnapshot() types.HealthResponse {
 	stats := h.manager.GetStats()
 
 	response := types.HealthResponse{
 		Status:          "ok",
 		Timestamp:       time.Now(),
 		ManagerHealthy:  h.manager.IsHealthy(),
+		Ready:           h.isReady(),
 		TotalRequests:   stats["totalRequests"].(int64),
 		SuccessRequests: stats["successRequests"].(int64),
 		FailedRequests:  stats["failedRequests"].(int64),
 		CacheHits:       stats["cacheHits"].(int64),
+		RefreshPaused:   stats["refreshPaused"].(bool),
 	}
 
 	if paramAge, ok := stats["parameterAge"].(time.Duration); ok {
 		response.ParameterAge = paramAge.String()
 	}
 
-	h.writeJSON(w, http.StatusOK, response)
-}
\ No newline at end of file
+	if recentErrors, ok := stats["recentErrors"].([]types.ManagerError); ok {
+		response.LastError = &recentErrors[0]
+		response.RecentErrors = recentErrors
+		response.ErrorCounts, _ = stats["errorCounts"].(map[string]int64)
+	}
+
+	response.Heartbeat = h.heartbeat.Status()
+	response.ModelGuidance = h.guidance.Status()
+	response.Availability = h.availability.Status()
+	return response
+}
+
+// HandleReady handles /ready request
+// Returns 503 until the AntiBot manager has obtained its first parameter
+func (h *APIHandler) HandleReady(w http.ResponseWriter, r *http.Request) {
+	if !h.isReady() {
+		h.writeJSON(w, http.StatusServiceUnavailable, types.ReadyResponse{
+			Ready:  false,
+			Reason: "AntiBot parameter not initialized yet",
+		})
+		return
+	}
+	h.writeJSON(w, http.StatusOK, types.ReadyResponse{Ready: true})
+}
+
+// isReady reports whether requests can be served (always true in mock mode)
+func (h *APIHandler) isReady() bool {
+	return h.config.Mock.Enabled || h.manager.IsReady()
+}
+
+// HandleNotFound handles requests to unknown paths
+// Mirrors OpenAI's "Invalid URL" error so SDKs surface a readable message
+func (h *APIHandler) HandleNotFound(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("Invalid URL (%s %s)", r.Method, r.URL.Path)
+	log.Printf("⚠️  未知路径: %s %s", r.Method, r.URL.Path)
+	h.writeErrorCode(w, http.StatusNotFound, message, "invalid_request_error", "unknown_url")
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cursor2api/tee"
+	"cursor2api/types"
+	"cursor2api/utils"
+)
+
+// progressHeader 请求头开启流式进度扩展,与请求体的 stream_progress 等效
+const progressHeader = "X-Stream-Progress"
+
+// streamProgress 周期性地以 SSE 注释 (": x-progress {...}") 发送流的进度,
+// 长时间运行的 agent 界面无需解析 delta 即可显示进度;标准 SSE 客户端会忽略注释行
+type streamProgress struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	counter *utils.TokenCounter
+	started time.Time
+	ticker  *time.Ticker
+	chunks  int
+}
+
+// progressEvent x-progress 注释的内容
+type progressEvent struct {
+	Chunks          int   `json:"chunks"`
+	ElapsedMs       int64 `json:"elapsed_ms"`
+	EstimatedTokens int   `json:"estimated_tokens"`
+}
+
+// newStreamProgress 请求开启了进度扩展时返回进度 sink,否则返回 nil
+func (h *APIHandler) newStreamProgress(w http.ResponseWriter, r *http.Request, req types.ChatCompletionRequest, flusher http.Flusher, counter *utils.TokenCounter) *streamProgress {
+	requested, _ := strconv.ParseBool(r.Header.Get(progressHeader))
+	if !requested && !req.StreamProgress {
+		return nil
+	}
+	return &streamProgress{
+		w:       w,
+		flusher: flusher,
+		counter: counter,
+		started: time.Now(),
+		ticker:  time.NewTicker(h.config.Stream.ProgressInterval),
+	}
+}
+
+// tick 返回进度定时器的通道,未开启时返回 nil (select 中永不就绪)
+func (p *streamProgress) tick() <-chan time.Time {
+	if p == nil {
+		return nil
+	}
+	return p.ticker.C
+}
+
+// WriteChunk 统计发送给客户端的 chunk 数
+func (p *streamProgress) WriteChunk(string) error {
+	p.chunks++
+	return nil
+}
+
+// Close 停止进度定时器
+func (p *streamProgress) Close(tee.Outcome) error {
+	p.ticker.Stop()
+	return nil
+}
+
+// write 发送一条 x-progress 注释
+func (p *streamProgress) write() {
+	data, _ := json.Marshal(progressEvent{
+		Chunks:          p.chunks,
+		ElapsedMs:       time.Since(p.started).Milliseconds(),
+		EstimatedTokens: p.counter.Tokens(),
+	})
+	if _, err := fmt.Fprintf(p.w, ": x-progress %s\n\n", data); err != nil {
+		log.Printf("❌ 写入流式进度失败: %v", err)
+		return
+	}
+	p.flusher.Flush()
+}
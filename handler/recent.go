@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cursor2api/middleware"
+	"cursor2api/observability"
+	"cursor2api/tenant"
+	"cursor2api/types"
+)
+
+// recentCompletion 一次完成请求的摘要,用于 /admin/recent 快速排查
+type recentCompletion struct {
+	Time             time.Time `json:"time"`
+	TraceID          string    `json:"trace_id,omitempty"`
+	Model            string    `json:"model"`
+	Tenant           string    `json:"tenant,omitempty"`
+	APIKey           string    `json:"api_key,omitempty"` // 已脱敏
+	Client           string    `json:"client,omitempty"`  // 客户端 SDK 及版本
+	Stream           bool      `json:"stream"`
+	Sandbox          bool      `json:"sandbox,omitempty"`
+	LatencyMs        int64     `json:"latency_ms"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	FinishReason     string    `json:"finish_reason"`
+	Error            string    `json:"error,omitempty"`
+	ToolName         string    `json:"tool_name,omitempty"` // 多个工具调用时以逗号分隔
+	Preview          string    `json:"preview,omitempty"`   // 仅在 RECENT_COMPLETIONS_PREVIEW_CHARS > 0 时保存
+}
+
+// recentCompletions 保存最近 N 次完成请求摘要的环形缓冲区,无需完整的转录存储
+type recentCompletions struct {
+	mu           sync.Mutex
+	entries      []recentCompletion
+	next         int
+	count        int
+	previewChars int
+}
+
+// newRecentCompletions 创建环形缓冲区,size 为 0 时返回 nil (不记录)
+func newRecentCompletions(size, previewChars int) *recentCompletions {
+	if size <= 0 {
+		return nil
+	}
+	return &recentCompletions{entries: make([]recentCompletion, size), previewChars: previewChars}
+}
+
+// previews 报告是否需要保存内容预览 (流式请求因此需要捕获输出)
+func (rc *recentCompletions) previews() bool {
+	return rc != nil && rc.previewChars > 0
+}
+
+// add 将一条摘要写入环形缓冲区,覆盖最旧的记录
+func (rc *recentCompletions) add(entry recentCompletion) {
+	if rc == nil {
+		return
+	}
+	rc.mu.Lock()
+	rc.entries[rc.next] = entry
+	rc.next = (rc.next + 1) % len(rc.entries)
+	rc.count = min(rc.count+1, len(rc.entries))
+	rc.mu.Unlock()
+}
+
+// snapshot 返回最近的记录,最新的在前; scope 非空时只返回该租户的记录
+func (rc *recentCompletions) snapshot(scope string, limit int) []recentCompletion {
+	out := []recentCompletion{}
+	if rc == nil {
+		return out
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for i := 1; i <= rc.count && len(out) < limit; i++ {
+		entry := rc.entries[(rc.next-i+len(rc.entries))%len(rc.entries)]
+		if scope == "" || entry.Tenant == scope {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+// rememberCompletion 记录一次完成请求的摘要; output 为文本或工具调用
+func (h *APIHandler) rememberCompletion(r *http.Request, req types.ChatCompletionRequest, output interface{}, finishReason string, promptTokens, completionTokens int, genErr error) {
+	if h.recent == nil {
+		return
+	}
+	now := time.Now()
+	entry := recentCompletion{
+		Time:             now,
+		Model:            req.Model,
+		Tenant:           tenant.NameFromContext(r.Context()),
+		Client:           middleware.ClientFromContext(r.Context()).String(),
+		Stream:           req.Stream,
+		Sandbox:          h.isSandbox(r),
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		FinishReason:     finishReason,
+	}
+	if trace, ok := observability.TraceFromContext(r.Context()); ok {
+		entry.TraceID = trace.TraceID
+		entry.LatencyMs = now.Sub(trace.StartTime).Milliseconds()
+	}
+	if apiKey := middleware.APIKeyFromContext(r.Context()); apiKey != "" {
+		entry.APIKey = middleware.MaskAPIKey(apiKey)
+	}
+	if genErr != nil {
+		entry.Error = genErr.Error()
+	}
+	switch out := output.(type) {
+	case types.CursorToolCalls:
+		names := make([]string, len(out))
+		for i, call := range out {
+			names[i] = call.ToolName
+		}
+		entry.ToolName = strings.Join(names, ",")
+	case string:
+		if h.recent.previews() {
+			entry.Preview = truncateRunes(out, h.recent.previewChars)
+		}
+	}
+	h.recent.add(entry)
+}
+
+// truncateRunes 截断到 n 个字符,被截断时追加省略号
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "…"
+}
+
+// HandleRecent handles GET /admin/recent
+// Returns the most recent completion summaries, newest first; ?limit=N caps the count.
+// Tenant-scoped admin tokens only see their own tenant's completions.
+func (h *APIHandler) HandleRecent(w http.ResponseWriter, r *http.Request) {
+	limit := h.config.Observability.RecentCompletions
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			h.writeError(w, http.StatusBadRequest, "limit must be a positive integer", "invalid_request_error")
+			return
+		}
+		limit = min(n, limit)
+	}
+	scope := middleware.AdminTenantFromContext(r.Context())
+	completions := h.recent.snapshot(scope, limit)
+	h.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"enabled":     h.recent != nil,
+		"tenant":      scope,
+		"count":       len(completions),
+		"completions": completions,
+	})
+}
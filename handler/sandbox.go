@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"net/http"
+
+	"cursor2api/metrics"
+	"cursor2api/middleware"
+	"cursor2api/service"
+	"cursor2api/types"
+)
+
+// sandboxHeader 响应头,标记由沙箱处理、未消耗真实容量的请求
+const sandboxHeader = "X-Sandbox"
+
+var sandboxRequests = metrics.NewCounter(
+	"cursor2api_sandbox_requests_total",
+	"Chat completions from sandbox API keys by how they were served (mock or model).",
+	"mode")
+
+// isSandbox 报告请求是否来自沙箱 API Key (SANDBOX_API_KEYS)
+func (h *APIHandler) isSandbox(r *http.Request) bool {
+	return h.sandboxKeys[middleware.APIKeyFromContext(r.Context())]
+}
+
+// applySandbox 让沙箱请求改由模拟上游处理,配置了 SANDBOX_MODEL 时改用该廉价模型;
+// 返回更新后的请求与用于日志的处理方式
+func (h *APIHandler) applySandbox(w http.ResponseWriter, r *http.Request, req *types.ChatCompletionRequest) (*http.Request, string) {
+	w.Header().Set(sandboxHeader, "true")
+	if model := h.config.Auth.SandboxModel; model != "" {
+		req.Model = model
+		sandboxRequests.Inc("model")
+		return r, "model " + model
+	}
+	sandboxRequests.Inc("mock")
+	return r.WithContext(service.WithSandbox(r.Context())), "mock upstream"
+}
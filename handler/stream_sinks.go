@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"cursor2api/middleware"
+	"cursor2api/observability"
+	"cursor2api/tee"
+	"cursor2api/transcript"
+	"cursor2api/types"
+)
+
+// sseClientSink 将文本 chunk 以 OpenAI 流式格式写给客户端
+type sseClientSink struct {
+	h         *APIHandler
+	w         http.ResponseWriter
+	flusher   http.Flusher
+	id        string
+	created   int64
+	model     string
+	sentFirst bool
+}
+
+// WriteChunk 写入一个 content delta,第一个 chunk 带 role
+func (s *sseClientSink) WriteChunk(chunk string) error {
+	delta := &types.ChatMessage{Content: chunk}
+	if !s.sentFirst {
+		delta.Role = "assistant"
+		s.sentFirst = true
+	}
+
+	s.h.writeSSE(s.w, types.ChatCompletionStreamResponse{
+		ID:      s.id,
+		Object:  "chat.completion.chunk",
+		Created: s.created,
+		Model:   s.model,
+		Choices: []types.ChatCompletionChoice{
+			{
+				Index:        0,
+				Delta:        delta,
+				FinishReason: "",
+			},
+		},
+	})
+	s.flusher.Flush()
+	return nil
+}
+
+// Close 最终 chunk 需要用量信息,由 handler 直接写出
+func (s *sseClientSink) Close(tee.Outcome) error { return nil }
+
+// newCapture 仅在导出器或最近完成记录的预览需要内容时返回捕获 sink
+func (h *APIHandler) newCapture() *tee.Capture {
+	if !h.exporter.CaptureContent() && !h.recent.previews() {
+		return nil
+	}
+	return &tee.Capture{MaxBytes: h.config.Observability.CaptureMaxBytes}
+}
+
+// capturedOutput 返回捕获的内容,未捕获时返回 nil;超出上限时追加截断标记
+func capturedOutput(capture *tee.Capture) interface{} {
+	if capture == nil {
+		return nil
+	}
+	if capture.Truncated() {
+		return capture.String() + "\n[truncated]"
+	}
+	return capture.String()
+}
+
+// openTranscript 为流式请求打开转录 sink,未启用或失败时返回 nil
+func (h *APIHandler) openTranscript(r *http.Request, req types.ChatCompletionRequest, streamID string) tee.Sink {
+	meta := transcript.Meta{
+		ID:       streamID,
+		Model:    req.Model,
+		Metadata: req.Metadata,
+	}
+	if apiKey := middleware.APIKeyFromContext(r.Context()); apiKey != "" {
+		meta.MaskedKey = middleware.MaskAPIKey(apiKey)
+	}
+	if trace, ok := observability.TraceFromContext(r.Context()); ok {
+		meta.TraceID = trace.TraceID
+	}
+
+	sink, err := h.transcripts.Open(meta)
+	if err != nil {
+		log.Printf("⚠️  打开转录文件失败: %v", err)
+		return nil
+	}
+	return sink
+}
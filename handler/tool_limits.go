@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"cursor2api/metrics"
+	"cursor2api/types"
+	"cursor2api/utils"
+)
+
+var oversizedToolRequests = metrics.NewCounter(
+	"cursor2api_oversized_tool_requests_total",
+	"Requests whose tool definitions exceeded TOOLS_MAX_JSON_BYTES, by action (shrunk, over_limit, rejected).",
+	"action")
+
+// fitTools 将超过 TOOLS_MAX_JSON_BYTES 的工具定义按 TOOLS_OVERSIZE_STRATEGY 缩减,
+// 并记录本次请求采取的动作。拒绝时写出 400 tools_too_large 并返回 false
+func (h *APIHandler) fitTools(w http.ResponseWriter, req *types.ChatCompletionRequest) bool {
+	cfg := h.config.Cursor
+	if len(req.Tools) == 0 || cfg.ToolsMaxJSONBytes <= 0 {
+		return true
+	}
+	tools, fit, err := utils.FitTools(req.Tools, cfg.ToolsMaxJSONBytes, cfg.ToolsOversizeStrategy, cfg.ToolsDescriptionMax)
+	if !fit.Oversized {
+		return true
+	}
+	steps := cmp.Or(strings.Join(fit.Applied, ","), "-")
+	if errors.Is(err, utils.ErrToolsTooLarge) {
+		log.Printf("🧰 工具定义过大,拒绝请求: %d bytes > %d bytes (已尝试: %s)", fit.Bytes, cfg.ToolsMaxJSONBytes, steps)
+		oversizedToolRequests.Inc("rejected")
+		h.writeErrorCode(w, http.StatusBadRequest, err.Error(), "invalid_request_error", "tools_too_large")
+		return false
+	}
+	req.Tools = tools
+	if fit.Fits(cfg.ToolsMaxJSONBytes) {
+		log.Printf("🧰 工具定义已缩减: %d → %d bytes (%s)", fit.OriginalBytes, fit.Bytes, steps)
+		oversizedToolRequests.Inc("shrunk")
+		return true
+	}
+	log.Printf("⚠️  工具定义缩减后仍超过限制,照常发送: %d → %d bytes > %d bytes (%s)", fit.OriginalBytes, fit.Bytes, cfg.ToolsMaxJSONBytes, steps)
+	oversizedToolRequests.Inc("over_limit")
+	return true
+}
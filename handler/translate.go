@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"cursor2api/types"
+)
+
+// responseFormat 是 translatingWriter 转换的目标 API 格式
+type responseFormat interface {
+	// streamContentType 返回转换后流式响应的 Content-Type
+	streamContentType() string
+	// encodeChunk 把一个流式 chunk 编码为目标格式的数据, chunk 为 nil 表示 [DONE]
+	encodeChunk(chunk *types.ChatCompletionStreamResponse) ([]byte, error)
+	// endStream 返回流式响应结束时需要补写的数据
+	endStream() []byte
+	// keepAlive 返回代替 SSE 注释 (进度与心跳) 写出的数据,使等待首个 token 期间连接不空闲
+	keepAlive() []byte
+	// encodeResponse 转换非流式响应
+	encodeResponse(resp types.ChatCompletionResponse) interface{}
+	// writeError 写入目标格式的错误响应
+	writeError(w http.ResponseWriter, status int, message string)
+}
+
+// translatingWriter 将 chat completion 响应转换为 format 的格式: SSE 流逐个事件
+// 转换,其他响应 (非流式结果与错误) 缓存到结束时整体转换
+type translatingWriter struct {
+	http.ResponseWriter
+	format responseFormat
+
+	status    int
+	streaming bool
+	buf       bytes.Buffer // 未读完的 SSE 事件,或完整的非流式响应
+}
+
+func (w *translatingWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	w.streaming = code == http.StatusOK && w.isEventStream()
+	if w.streaming {
+		w.Header().Set("Content-Type", w.format.streamContentType())
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *translatingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.buf.Write(b)
+	if w.streaming {
+		return len(b), w.translateEvents()
+	}
+	return len(b), nil
+}
+
+// isEventStream 报告 chat completion 响应是否为 SSE 流
+func (w *translatingWriter) isEventStream() bool {
+	return strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+}
+
+// Flush 转发流式响应的 flush; 流式响应在第一次 flush 时开始
+func (w *translatingWriter) Flush() {
+	if w.status == 0 && w.isEventStream() {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.streaming {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *translatingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// translateEvents 转换缓冲区中完整的 SSE 事件,注释 (进度与心跳) 转换为目标格式的心跳
+func (w *translatingWriter) translateEvents() error {
+	for {
+		event, _, ok := bytes.Cut(w.buf.Bytes(), []byte("\n\n"))
+		if !ok {
+			return nil
+		}
+		data, isData := bytes.CutPrefix(event, []byte("data: "))
+		var frame []byte
+		var err error
+		switch {
+		case !isData:
+			if bytes.HasPrefix(event, []byte(":")) {
+				frame = w.format.keepAlive()
+			}
+		case string(data) == "[DONE]":
+			frame, err = w.format.encodeChunk(nil)
+		default:
+			var chunk types.ChatCompletionStreamResponse
+			if jsonErr := json.Unmarshal(data, &chunk); jsonErr != nil {
+				log.Printf("⚠️  无法转换流式 chunk: %v", jsonErr)
+				break
+			}
+			frame, err = w.format.encodeChunk(&chunk)
+		}
+		w.buf.Next(len(event) + 2)
+		if err != nil {
+			return err
+		}
+		if len(frame) > 0 {
+			if _, err := w.ResponseWriter.Write(frame); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// finish 转换缓存的非流式响应; 流式响应已逐个事件写出,只补写结尾
+func (w *translatingWriter) finish() {
+	if w.streaming {
+		if end := w.format.endStream(); len(end) > 0 {
+			_, _ = w.ResponseWriter.Write(end)
+		}
+		return
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.Header().Del("Content-Length")
+
+	if w.status >= http.StatusBadRequest {
+		var errResp types.ErrorResponse
+		message := strings.TrimSpace(w.buf.String())
+		if err := json.Unmarshal(w.buf.Bytes(), &errResp); err == nil && errResp.Error.Message != "" {
+			message = errResp.Error.Message
+		}
+		w.format.writeError(w.ResponseWriter, w.status, message)
+		return
+	}
+
+	var resp types.ChatCompletionResponse
+	if err := json.Unmarshal(w.buf.Bytes(), &resp); err != nil {
+		log.Printf("❌ 无法转换非流式响应: %v", err)
+		w.format.writeError(w.ResponseWriter, http.StatusBadGateway, "Failed to translate the completion")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.ResponseWriter.WriteHeader(w.status)
+	if err := json.NewEncoder(w.ResponseWriter).Encode(w.format.encodeResponse(resp)); err != nil {
+		log.Printf("❌ 写入转换后的响应失败: %v", err)
+	}
+}
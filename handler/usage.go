@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"cursor2api/canary"
+	"cursor2api/middleware"
+	"cursor2api/observability"
+	"cursor2api/tenant"
+	"cursor2api/types"
+	"cursor2api/usage"
+)
+
+// OpenAI metadata limits
+const (
+	maxMetadataPairs       = 16
+	maxMetadataKeyLength   = 64
+	maxMetadataValueLength = 512
+)
+
+// validateMetadata 校验 metadata 是否符合 OpenAI 的限制
+func validateMetadata(metadata map[string]string) error {
+	if len(metadata) > maxMetadataPairs {
+		return fmt.Errorf("metadata may contain at most %d key-value pairs", maxMetadataPairs)
+	}
+	for k, v := range metadata {
+		if len(k) > maxMetadataKeyLength {
+			return fmt.Errorf("metadata key %q exceeds %d characters", k[:maxMetadataKeyLength], maxMetadataKeyLength)
+		}
+		if len(v) > maxMetadataValueLength {
+			return fmt.Errorf("metadata value for %q exceeds %d characters", k, maxMetadataValueLength)
+		}
+	}
+	return nil
+}
+
+// completionUsage 返回响应的 token 用量: 上游在 messageMetadata 中报告了用量时直接使用
+// (含缓存命中的 prompt token),未报告时回退到本地估算
+func completionUsage(upstream *types.Usage, promptTokens, completionTokens int) types.ChatCompletionUsage {
+	usage := types.ChatCompletionUsage{PromptTokens: promptTokens, CompletionTokens: completionTokens}
+	if upstream != nil && upstream.InputTokens+upstream.OutputTokens > 0 {
+		usage.PromptTokens, usage.CompletionTokens = upstream.InputTokens, upstream.OutputTokens
+		if upstream.CachedInputTokens > 0 {
+			usage.PromptTokensDetails = &types.PromptTokensDetails{CachedTokens: upstream.CachedInputTokens}
+		}
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
+
+// recordUsage 记录一次完成请求的用量，并导出可观测性记录; 沙箱请求只导出不计入用量
+// genErr 非空表示上游中途终止,已送达的 token 仍计入用量
+func (h *APIHandler) recordUsage(r *http.Request, req types.ChatCompletionRequest, output interface{}, finishReason string, promptTokens, completionTokens int, genErr error) {
+	if h.isSandbox(r) {
+		// Sandbox requests do not count against spend limits, budgets or usage reports
+		h.exportGeneration(r, req, output, finishReason, promptTokens, completionTokens, genErr)
+		return
+	}
+
+	apiKey := middleware.APIKeyFromContext(r.Context())
+	h.usage.Record(usage.Record{
+		APIKey:           apiKey,
+		MaskedKey:        middleware.MaskAPIKey(apiKey),
+		Tenant:           tenant.NameFromContext(r.Context()),
+		Client:           middleware.ClientFromContext(r.Context()).Name,
+		Model:            req.Model,
+		Stream:           req.Stream,
+		RequestBytes:     int(max(r.ContentLength, 0)),
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		Dimensions:       h.usage.Dimensions(req.Metadata),
+	})
+
+	h.exportGeneration(r, req, output, finishReason, promptTokens, completionTokens, genErr)
+}
+
+// exportGeneration 记录配置变体指标与最近完成记录,并将一次生成导出到 Langfuse 兼容的采集端点
+func (h *APIHandler) exportGeneration(r *http.Request, req types.ChatCompletionRequest, output interface{}, finishReason string, promptTokens, completionTokens int, genErr error) {
+	h.rememberCompletion(r, req, output, finishReason, promptTokens, completionTokens, genErr)
+
+	trace, ok := observability.TraceFromContext(r.Context())
+	if !ok {
+		return
+	}
+	canary.Observe(r.Context(), trace.StartTime, completionTokens, genErr)
+
+	if h.exporter == nil {
+		return
+	}
+
+	generation := observability.Generation{
+		Trace:            trace,
+		Name:             "chat.completions",
+		Model:            req.Model,
+		EndTime:          time.Now(),
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		Metadata:         req.Metadata,
+	}
+	if h.exporter.CaptureContent() {
+		generation.Input = req.Messages
+		generation.Output = output
+	}
+	if genErr != nil {
+		generation.Error = genErr.Error()
+	}
+	h.exporter.Export(generation)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 
+	"cursor2api/errdocs"
 	"cursor2api/types"
 )
 
@@ -18,6 +19,21 @@ func (h *APIHandler) writeJSON(w http.ResponseWriter, status int, data interface
 	}
 }
 
+// setSSEHeaders 设置流式响应头部,并关闭 nginx / CDN 的响应缓冲和内容改写
+func (h *APIHandler) setSSEHeaders(w http.ResponseWriter) {
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache, no-store, no-transform")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	header.Add("Vary", "Authorization")
+	header.Set("Access-Control-Allow-Origin", "*")
+	header.Set("Access-Control-Allow-Headers", "*")
+	for name, value := range h.config.Stream.ExtraHeaders {
+		header.Set(name, value)
+	}
+}
+
 // writeSSE 写入 SSE 数据
 func (h *APIHandler) writeSSE(w http.ResponseWriter, data interface{}) {
 	jsonData, err := json.Marshal(data)
@@ -32,11 +48,24 @@ func (h *APIHandler) writeSSE(w http.ResponseWriter, data interface{}) {
 
 // writeError 写入错误响应
 func (h *APIHandler) writeError(w http.ResponseWriter, status int, message, errorType string) {
+	h.writeErrorCode(w, status, message, errorType, "")
+}
+
+// writeErrorCode 写入带错误码的错误响应
+func (h *APIHandler) writeErrorCode(w http.ResponseWriter, status int, message, errorType, code string) {
+	h.writeErrorHint(w, status, message, errorType, code, "")
+}
+
+// writeErrorHint 写入带错误码的错误响应,hint 替换该错误码的通用修复建议
+// (仅在 ERROR_HINTS_ENABLED 时返回给客户端)
+func (h *APIHandler) writeErrorHint(w http.ResponseWriter, status int, message, errorType, code, hint string) {
 	response := types.ErrorResponse{
 		Error: types.ErrorDetail{
 			Message: message,
 			Type:    errorType,
+			Code:    code,
 		},
 	}
+	response.Error.Hint, response.Error.DocURL = errdocs.Annotate(code, hint)
 	h.writeJSON(w, status, response)
 }
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"cursor2api/metrics"
+	"cursor2api/types"
+	"cursor2api/utils"
+)
+
+// selfVerifyHeader 告知客户端自检结果 (passed、repaired 或 failed)
+const selfVerifyHeader = "X-Self-Verify"
+
+// selfVerifyInstruction 修复请求的系统指令
+const selfVerifyInstruction = "You check another assistant's answer against format constraints and repair it. " +
+	"Keep the content of the answer and only change what the constraints require. " +
+	"Reply with the corrected answer only: no explanations, no Markdown code fences."
+
+var selfVerifications = metrics.NewCounter(
+	"cursor2api_self_verifications_total",
+	"Self-verified non-streaming completions by result (passed, repaired, failed).",
+	"result")
+
+// selfVerifyEnabled 判断请求是否需要自检: 仅针对有格式约束的 json_object / json_schema 请求,
+// self_verify 字段优先于 SELF_VERIFY_ENABLED
+func (h *APIHandler) selfVerifyEnabled(req types.ChatCompletionRequest) bool {
+	if !req.JSONMode() {
+		return false
+	}
+	if req.SelfVerify != nil {
+		return *req.SelfVerify
+	}
+	return h.config.Verify.Enabled
+}
+
+// selfVerify 检查非流式响应是否满足格式约束 (JSON 对象、必需字段),不满足时发起
+// 一次额外的非流式请求让模型修复。返回最终内容与自检结果; 未启用自检时结果为空。
+// 修复失败时返回原始内容,由客户端按原有方式处理
+func (h *APIHandler) selfVerify(ctx context.Context, req types.ChatCompletionRequest, content string) (string, string) {
+	if !h.selfVerifyEnabled(req) {
+		return content, ""
+	}
+	required, schema := req.RequiredFields(), requestSchema(req)
+	object, problems := utils.CheckJSONObject(content, required, schema)
+	if len(problems) == 0 {
+		selfVerifications.Inc("passed")
+		return object, "passed"
+	}
+
+	model := cmp.Or(h.config.Verify.Model, req.Model)
+	log.Printf("🔍 [Non-Stream] 输出不满足格式约束,请求模型修复")
+	log.Printf("  └─ Problems: %s", strings.Join(problems, " "))
+	log.Printf("  └─ Repair Model: %s", model)
+
+	result, err := h.cursorService.Chat(ctx, selfVerifyMessages(req, content, problems), model, "", nil)
+	if err != nil {
+		log.Printf("⚠️  自检修复请求失败,返回原始输出: %v", err)
+		selfVerifications.Inc("failed")
+		return content, "failed"
+	}
+	if text, ok := result.(types.CursorTextResult); ok {
+		repaired, remaining := utils.CheckJSONObject(text.Content, required, schema)
+		if len(remaining) == 0 {
+			log.Printf("✅ [Non-Stream] 自检修复成功")
+			selfVerifications.Inc("repaired")
+			return repaired, "repaired"
+		}
+		problems = remaining
+	}
+	log.Printf("⚠️  自检修复后的输出仍不满足约束,返回原始输出")
+	log.Printf("  └─ Problems: %s", strings.Join(problems, " "))
+	selfVerifications.Inc("failed")
+	return content, "failed"
+}
+
+// selfVerifyMessages 构造修复请求: 约束、发现的问题、原始请求的最后一条用户消息
+// (补全缺失字段时需要) 以及待修复的回答
+func selfVerifyMessages(req types.ChatCompletionRequest, content string, problems []string) []types.ChatMessage {
+	var prompt strings.Builder
+	prompt.WriteString("Constraints:\n- The answer must be a single valid JSON object with no text before or after it.\n")
+	if required := req.RequiredFields(); len(required) > 0 {
+		fmt.Fprintf(&prompt, "- The object must contain the top-level fields: %s.\n", strings.Join(required, ", "))
+	}
+	if schema := req.JSONSchema(); schema != nil {
+		fmt.Fprintf(&prompt, "- The object must match this JSON Schema:\n%s\n", schema)
+	}
+	prompt.WriteString("\nProblems found:\n")
+	for _, p := range problems {
+		fmt.Fprintf(&prompt, "- %s\n", p)
+	}
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == "user" {
+			fmt.Fprintf(&prompt, "\nOriginal request:\n%s\n", req.Messages[i].Content)
+			break
+		}
+	}
+	fmt.Fprintf(&prompt, "\nAnswer to repair:\n%s", content)
+
+	return []types.ChatMessage{
+		{Role: "system", Content: selfVerifyInstruction},
+		{Role: "user", Content: prompt.String()},
+	}
+}
@@ -0,0 +1,221 @@
+// Package heartbeat periodically pushes health and usage snapshots to an HTTP
+// endpoint as a CloudEvents batch, for environments where nothing scrapes
+// /health or /metrics. Failed deliveries are retried with exponential backoff.
+package heartbeat
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"cursor2api/config"
+	"cursor2api/logger"
+	"cursor2api/metrics"
+	"cursor2api/types"
+)
+
+// CloudEvents types of the pushed snapshots
+const (
+	EventTypeHealth = "com.cursor2api.health.snapshot"
+	EventTypeUsage  = "com.cursor2api.usage.snapshot"
+)
+
+// batchContentType is the CloudEvents JSON batch format
+const batchContentType = "application/cloudevents-batch+json"
+
+var deliveries = metrics.NewCounter(
+	"cursor2api_heartbeat_deliveries_total",
+	"Heartbeat pushes by result (ok or error).",
+	"result")
+
+// Event is a CloudEvents 1.0 event in structured JSON mode
+type Event struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            interface{} `json:"data"`
+}
+
+// Snapshot returns the current data of one event type
+type Snapshot func() interface{}
+
+// Reporter pushes heartbeats until Stop
+type Reporter struct {
+	url          string
+	secret       string
+	source       string
+	interval     time.Duration
+	retryBackoff time.Duration
+	health       Snapshot
+	usage        Snapshot
+	client       *http.Client
+
+	mu     sync.Mutex
+	status types.HeartbeatStatus
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// New creates the reporter; returns nil when no endpoint is configured.
+// Call Start to begin pushing.
+func New(cfg config.HeartbeatConfig, health, usage Snapshot) *Reporter {
+	if cfg.URL == "" {
+		return nil
+	}
+	return &Reporter{
+		url:          cfg.URL,
+		secret:       cfg.Secret,
+		source:       cfg.Source,
+		interval:     cfg.Interval,
+		retryBackoff: cfg.RetryBackoff,
+		health:       health,
+		usage:        usage,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		stopChan:     make(chan struct{}),
+	}
+}
+
+// Start sends the first heartbeat immediately and then one every interval
+func (r *Reporter) Start() {
+	if r == nil {
+		return
+	}
+	r.wg.Add(1)
+	go r.loop()
+	logger.Info("Heartbeat push started | interval=%v retry_backoff=%v source=%s signed=%v",
+		r.interval, r.retryBackoff, r.source, r.secret != "")
+}
+
+// Stop stops pushing; a nil reporter is a no-op
+func (r *Reporter) Stop() {
+	if r == nil {
+		return
+	}
+	r.stopOnce.Do(func() {
+		close(r.stopChan)
+		r.wg.Wait()
+	})
+}
+
+// Status returns the delivery status, or nil when the reporter is disabled
+func (r *Reporter) Status() *types.HeartbeatStatus {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := r.status
+	return &status
+}
+
+// loop pushes whenever the timer fires, rescheduling after each attempt
+func (r *Reporter) loop() {
+	defer r.wg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			timer.Reset(r.push(time.Now()))
+		case <-r.stopChan:
+			return
+		}
+	}
+}
+
+// push delivers one batch of fresh snapshots, records the outcome and returns the
+// delay until the next attempt: the interval after a success, otherwise the backoff
+func (r *Reporter) push(now time.Time) time.Duration {
+	err := r.deliver(now)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status.LastAttempt = &now
+	delay := r.interval
+	if err != nil {
+		deliveries.Inc("error")
+		r.status.Failed++
+		r.status.ConsecutiveFailures++
+		r.status.LastError = err.Error()
+		delay = r.backoff(r.status.ConsecutiveFailures)
+		logger.Warn("Heartbeat delivery failed | consecutive_failures=%d retry_in=%v error=%v",
+			r.status.ConsecutiveFailures, delay, err)
+	} else {
+		deliveries.Inc("ok")
+		r.status.Delivered++
+		r.status.ConsecutiveFailures = 0
+		r.status.LastError = ""
+		r.status.LastDelivery = &now
+		logger.Debug("Heartbeat delivered | delivered=%d", r.status.Delivered)
+	}
+	r.status.NextAttempt = now.Add(delay)
+	return delay
+}
+
+// backoff returns the retry delay after the given number of consecutive failures,
+// doubling from retryBackoff and never exceeding the regular interval
+func (r *Reporter) backoff(failures int) time.Duration {
+	delay := r.retryBackoff
+	for i := 1; i < failures && delay < r.interval; i++ {
+		delay *= 2
+	}
+	return min(delay, r.interval)
+}
+
+// deliver posts the health and usage events as one CloudEvents batch
+func (r *Reporter) deliver(now time.Time) error {
+	events := []Event{r.event(EventTypeHealth, now, r.health()), r.event(EventTypeUsage, now, r.usage())}
+	payload, err := json.Marshal(events)
+	if err != nil {
+		return fmt.Errorf("failed to marshal events: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, r.url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", batchContentType)
+	if r.secret != "" {
+		mac := hmac.New(sha256.New, []byte(r.secret))
+		mac.Write(payload)
+		req.Header.Set("X-Signature-SHA256", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// event wraps data in a CloudEvents envelope with a unique ID
+func (r *Reporter) event(eventType string, now time.Time, data interface{}) Event {
+	return Event{
+		SpecVersion:     "1.0",
+		ID:              rand.Text(),
+		Source:          r.source,
+		Type:            eventType,
+		Time:            now.UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+	}
+}
@@ -0,0 +1,94 @@
+package heartbeat
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cursor2api/config"
+)
+
+func TestReporter_Push(t *testing.T) {
+	var (
+		events    []Event
+		signature string
+		fail      bool
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != batchContentType {
+			t.Errorf("Content-Type = %q", r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write(body)
+		signature = hex.EncodeToString(mac.Sum(nil))
+		if r.Header.Get("X-Signature-SHA256") != signature {
+			t.Error("payload signature mismatch")
+		}
+		if err := json.Unmarshal(body, &events); err != nil {
+			t.Fatal(err)
+		}
+		if fail {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	r := New(config.HeartbeatConfig{
+		URL:          server.URL,
+		Secret:       "s3cret",
+		Interval:     time.Minute,
+		RetryBackoff: 10 * time.Second,
+		Source:       "cursor2api/test",
+	}, func() interface{} { return map[string]string{"status": "ok"} }, func() interface{} { return []int{1, 2} })
+
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	if delay := r.push(now); delay != time.Minute {
+		t.Errorf("delay after success = %v, want 1m", delay)
+	}
+	if len(events) != 2 || events[0].Type != EventTypeHealth || events[1].Type != EventTypeUsage {
+		t.Fatalf("events = %+v", events)
+	}
+	if e := events[0]; e.SpecVersion != "1.0" || e.Source != "cursor2api/test" || e.ID == "" || e.ID == events[1].ID || !e.Time.Equal(now) {
+		t.Errorf("envelope = %+v", e)
+	}
+	status := r.Status()
+	if status.Delivered != 1 || status.LastDelivery == nil || !status.NextAttempt.Equal(now.Add(time.Minute)) {
+		t.Errorf("status after success = %+v", status)
+	}
+
+	fail = true
+	for _, want := range []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute} {
+		if delay := r.push(now); delay != want {
+			t.Errorf("backoff = %v, want %v", delay, want)
+		}
+	}
+	status = r.Status()
+	if status.Failed != 4 || status.ConsecutiveFailures != 4 || status.LastError == "" || !status.LastDelivery.Equal(now) {
+		t.Errorf("status after failures = %+v", status)
+	}
+
+	fail = false
+	r.push(now)
+	if status = r.Status(); status.ConsecutiveFailures != 0 || status.LastError != "" || status.Delivered != 2 {
+		t.Errorf("status after recovery = %+v", status)
+	}
+}
+
+func TestReporter_Disabled(t *testing.T) {
+	r := New(config.HeartbeatConfig{}, nil, nil)
+	if r != nil {
+		t.Fatal("reporter without URL should be nil")
+	}
+	r.Start()
+	r.Stop()
+	if r.Status() != nil {
+		t.Error("nil reporter status should be nil")
+	}
+}
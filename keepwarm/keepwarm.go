@@ -0,0 +1,207 @@
+// Package keepwarm sends a tiny synthetic chat request on a schedule during
+// configured hours, keeping the AntiBot token, the TLS sessions and the upstream
+// path warm so the first real request after a quiet period is not slow. A tick
+// is skipped when real traffic reached the upstream within the interval.
+package keepwarm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cursor2api/config"
+	"cursor2api/logger"
+	"cursor2api/metrics"
+)
+
+// requestTimeout bounds one synthetic request
+const requestTimeout = 30 * time.Second
+
+var requests = metrics.NewCounter(
+	"cursor2api_keep_warm_requests_total",
+	"Keep-warm ticks by result (ok, error, skipped_active or outside_hours).",
+	"result")
+
+// ChatFunc sends one synthetic chat request
+type ChatFunc func(ctx context.Context) error
+
+// Hours is a daily window in local time; the end is exclusive and may be earlier
+// than the start to span midnight. The zero value covers the whole day.
+type Hours struct {
+	start, end int // Minutes since midnight
+}
+
+// ParseHours parses "HH[:MM]-HH[:MM]", e.g. "08-20" or "22:30-06:00"; an empty
+// string covers the whole day
+func ParseHours(s string) (Hours, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Hours{}, nil
+	}
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return Hours{}, fmt.Errorf("invalid hours %q: want HH[:MM]-HH[:MM]", s)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return Hours{}, fmt.Errorf("invalid hours %q: %w", s, err)
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return Hours{}, fmt.Errorf("invalid hours %q: %w", s, err)
+	}
+	return Hours{start: start, end: end}, nil
+}
+
+// parseClock parses "HH" or "HH:MM" into minutes since midnight; "24" is the end of the day
+func parseClock(s string) (int, error) {
+	h, m, hasMinutes := strings.Cut(strings.TrimSpace(s), ":")
+	hour, err := strconv.Atoi(h)
+	if err != nil || hour < 0 || hour > 24 {
+		return 0, fmt.Errorf("invalid hour %q", s)
+	}
+	minute := 0
+	if hasMinutes {
+		if minute, err = strconv.Atoi(m); err != nil || minute < 0 || minute > 59 || (hour == 24 && minute > 0) {
+			return 0, fmt.Errorf("invalid minute %q", s)
+		}
+	}
+	return hour*60 + minute, nil
+}
+
+// Contains reports whether t falls within the window
+func (h Hours) Contains(t time.Time) bool {
+	if h.start == h.end {
+		return true
+	}
+	minute := t.Hour()*60 + t.Minute()
+	if h.start < h.end {
+		return minute >= h.start && minute < h.end
+	}
+	return minute >= h.start || minute < h.end
+}
+
+// String formats the window as HH:MM-HH:MM
+func (h Hours) String() string {
+	if h.start == h.end {
+		return "all day"
+	}
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", h.start/60, h.start%60, h.end/60, h.end%60)
+}
+
+// Scheduler sends keep-warm requests until Stop
+type Scheduler struct {
+	interval time.Duration
+	hours    Hours
+	chat     ChatFunc
+
+	lastActivity atomic.Int64 // Unix nanoseconds of the last real request (see Touch)
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// New creates the scheduler; returns nil when no interval is configured or the
+// hours are invalid. Call Start to begin sending.
+func New(cfg config.KeepWarmConfig, chat ChatFunc) *Scheduler {
+	if cfg.Interval <= 0 {
+		return nil
+	}
+	hours, err := ParseHours(cfg.Hours)
+	if err != nil {
+		logger.Warn("Keep-warm disabled | error=%v", err)
+		return nil
+	}
+	return &Scheduler{
+		interval: cfg.Interval,
+		hours:    hours,
+		chat:     chat,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start sends a keep-warm request every interval
+func (s *Scheduler) Start() {
+	if s == nil {
+		return
+	}
+	s.wg.Add(1)
+	go s.loop()
+	logger.Info("Keep-warm started | interval=%v hours=%s", s.interval, s.hours)
+}
+
+// Stop stops sending; a nil scheduler is a no-op
+func (s *Scheduler) Stop() {
+	if s == nil {
+		return
+	}
+	s.stopOnce.Do(func() {
+		close(s.stopChan)
+		s.wg.Wait()
+	})
+}
+
+// Touch records real upstream traffic, which makes the next tick unnecessary
+func (s *Scheduler) Touch() {
+	if s == nil {
+		return
+	}
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (s *Scheduler) loop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.tick(now)
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// tick sends one synthetic request unless outside the hours or traffic was recent
+func (s *Scheduler) tick(now time.Time) {
+	if !s.hours.Contains(now) {
+		requests.Inc("outside_hours")
+		return
+	}
+	if last := s.lastActivity.Load(); last != 0 && now.Sub(time.Unix(0, last)) < s.interval {
+		requests.Inc("skipped_active")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	go func() {
+		// Stop interrupts a request in flight
+		select {
+		case <-s.stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	start := time.Now()
+	if err := s.chat(ctx); err != nil {
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		requests.Inc("error")
+		logger.Warn("Keep-warm request failed | error=%v", err)
+		return
+	}
+	requests.Inc("ok")
+	logger.Debug("Keep-warm request sent | duration=%v", time.Since(start).Round(time.Millisecond))
+}
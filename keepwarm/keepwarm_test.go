@@ -0,0 +1,88 @@
+package keepwarm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cursor2api/config"
+)
+
+func TestParseHours(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2025, 3, 1, hour, minute, 0, 0, time.Local)
+	}
+	tests := []struct {
+		hours   string
+		in, out []time.Time
+	}{
+		{"", []time.Time{at(0, 0), at(23, 59)}, nil},
+		{"08-20", []time.Time{at(8, 0), at(19, 59)}, []time.Time{at(7, 59), at(20, 0)}},
+		{"22:30-06:00", []time.Time{at(22, 30), at(0, 0), at(5, 59)}, []time.Time{at(22, 29), at(6, 0), at(12, 0)}},
+		{"00-24", []time.Time{at(0, 0), at(23, 59)}, nil},
+	}
+	for _, tt := range tests {
+		h, err := ParseHours(tt.hours)
+		if err != nil {
+			t.Fatalf("ParseHours(%q): %v", tt.hours, err)
+		}
+		for _, in := range tt.in {
+			if !h.Contains(in) {
+				t.Errorf("%q should contain %s", tt.hours, in.Format("15:04"))
+			}
+		}
+		for _, out := range tt.out {
+			if h.Contains(out) {
+				t.Errorf("%q should not contain %s", tt.hours, out.Format("15:04"))
+			}
+		}
+	}
+
+	for _, invalid := range []string{"8", "25-08", "08:60-09", "a-b", "24:30-08"} {
+		if _, err := ParseHours(invalid); err == nil {
+			t.Errorf("ParseHours(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestScheduler_Tick(t *testing.T) {
+	calls := 0
+	chat := func(context.Context) error {
+		calls++
+		return nil
+	}
+
+	noon := time.Date(2025, 3, 1, 12, 0, 0, 0, time.Local)
+	s := New(config.KeepWarmConfig{Interval: time.Minute, Hours: "08-20"}, chat)
+	s.tick(noon.Add(-10 * time.Hour))
+	if calls != 0 {
+		t.Fatal("no request outside the hours")
+	}
+	s.tick(noon)
+	if calls != 1 {
+		t.Fatalf("calls = %d, want 1", calls)
+	}
+
+	s = New(config.KeepWarmConfig{Interval: time.Minute}, chat)
+	s.Touch()
+	s.tick(time.Now())
+	if calls != 1 {
+		t.Error("a tick right after real traffic must be skipped")
+	}
+	s.tick(time.Now().Add(2 * time.Minute))
+	if calls != 2 {
+		t.Errorf("calls = %d, want 2 once the traffic is older than the interval", calls)
+	}
+}
+
+func TestNew_Disabled(t *testing.T) {
+	if s := New(config.KeepWarmConfig{}, nil); s != nil {
+		t.Error("no interval must disable keep-warm")
+	}
+	if s := New(config.KeepWarmConfig{Interval: time.Minute, Hours: "nope"}, nil); s != nil {
+		t.Error("invalid hours must disable keep-warm")
+	}
+	var s *Scheduler
+	s.Touch()
+	s.Stop()
+}
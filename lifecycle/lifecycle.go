@@ -0,0 +1,119 @@
+// Package lifecycle starts and stops the server's subsystems in a defined order.
+// Components register Start/Stop hooks; Start runs them in registration order and
+// Stop runs the stop hooks in reverse, each bounded by its own timeout, logging
+// the result of every component.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cursor2api/logger"
+)
+
+// ErrStopTimeout is reported for a component whose stop hook did not return in time
+var ErrStopTimeout = errors.New("stop timed out")
+
+// Hook is one component's lifecycle; either function may be nil
+type Hook struct {
+	Name    string
+	Start   func(ctx context.Context) error
+	Stop    func(ctx context.Context) error
+	Timeout time.Duration // Upper bound for Stop (0 = the manager's default)
+}
+
+// Result is the outcome of stopping one component
+type Result struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+}
+
+// Manager runs the registered hooks
+type Manager struct {
+	hooks          []Hook
+	started        int // Number of hooks whose Start succeeded
+	defaultTimeout time.Duration
+}
+
+// New creates a manager whose stop hooks default to defaultTimeout
+func New(defaultTimeout time.Duration) *Manager {
+	return &Manager{defaultTimeout: defaultTimeout}
+}
+
+// Register appends a hook; components are started in registration order
+func (m *Manager) Register(hook Hook) {
+	m.hooks = append(m.hooks, hook)
+}
+
+// OnStop registers a component that only needs to be stopped, e.g. one started by its constructor
+func (m *Manager) OnStop(name string, stop func()) {
+	m.Register(Hook{Name: name, Stop: func(context.Context) error {
+		stop()
+		return nil
+	}})
+}
+
+// Start runs the start hooks in order. When one fails, the components started
+// before it are stopped again and the error is returned.
+func (m *Manager) Start(ctx context.Context) error {
+	for _, hook := range m.hooks[m.started:] {
+		if hook.Start != nil {
+			if err := hook.Start(ctx); err != nil {
+				m.Stop(ctx)
+				return fmt.Errorf("start %s: %w", hook.Name, err)
+			}
+		}
+		m.started++
+	}
+	return nil
+}
+
+// Stop runs the stop hooks of started components in reverse order. Each hook gets
+// its own timeout, bounded by ctx; a hook that does not return in time is left
+// running and reported with ErrStopTimeout so the remaining components still stop.
+func (m *Manager) Stop(ctx context.Context) []Result {
+	results := make([]Result, 0, m.started)
+	for i := m.started - 1; i >= 0; i-- {
+		hook := m.hooks[i]
+		if hook.Stop == nil {
+			continue
+		}
+		result := m.stop(ctx, hook)
+		if result.Err != nil {
+			logger.Error("Component stop failed | component=%s duration=%v error=%v", result.Name, result.Duration, result.Err)
+		} else {
+			logger.Info("Component stopped | component=%s duration=%v", result.Name, result.Duration)
+		}
+		results = append(results, result)
+	}
+	m.started = 0
+	return results
+}
+
+// stop runs one stop hook with its timeout
+func (m *Manager) stop(ctx context.Context, hook Hook) Result {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = m.defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- hook.Stop(ctx)
+	}()
+
+	result := Result{Name: hook.Name}
+	select {
+	case result.Err = <-done:
+	case <-ctx.Done():
+		result.Err = fmt.Errorf("%w after %v", ErrStopTimeout, timeout)
+	}
+	result.Duration = time.Since(start).Round(time.Millisecond)
+	return result
+}
@@ -0,0 +1,72 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestManager_Order(t *testing.T) {
+	var events []string
+	m := New(time.Second)
+	for _, name := range []string{"store", "pool", "server"} {
+		m.Register(Hook{
+			Name:  name,
+			Start: func(context.Context) error { events = append(events, "start "+name); return nil },
+			Stop:  func(context.Context) error { events = append(events, "stop "+name); return nil },
+		})
+	}
+	m.OnStop("exporter", func() { events = append(events, "stop exporter") })
+
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	results := m.Stop(context.Background())
+
+	want := []string{"start store", "start pool", "start server", "stop exporter", "stop server", "stop pool", "stop store"}
+	if !slices.Equal(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+	if len(results) != 4 || results[0].Name != "exporter" || results[3].Name != "store" {
+		t.Errorf("results = %+v", results)
+	}
+}
+
+func TestManager_StartFailureStopsStarted(t *testing.T) {
+	var stopped []string
+	m := New(time.Second)
+	m.Register(Hook{Name: "a", Stop: func(context.Context) error { stopped = append(stopped, "a"); return nil }})
+	m.Register(Hook{Name: "b", Start: func(context.Context) error { return errors.New("port in use") },
+		Stop: func(context.Context) error { stopped = append(stopped, "b"); return nil }})
+
+	if err := m.Start(context.Background()); err == nil || err.Error() != "start b: port in use" {
+		t.Fatalf("err = %v", err)
+	}
+	if !slices.Equal(stopped, []string{"a"}) {
+		t.Errorf("stopped = %v, want only the started component", stopped)
+	}
+	if results := m.Stop(context.Background()); len(results) != 0 {
+		t.Errorf("second Stop ran hooks again: %+v", results)
+	}
+}
+
+func TestManager_StopTimeout(t *testing.T) {
+	m := New(20 * time.Millisecond)
+	release := make(chan struct{})
+	defer close(release)
+	m.Register(Hook{Name: "stuck", Stop: func(context.Context) error { <-release; return nil }})
+	m.Register(Hook{Name: "failing", Stop: func(context.Context) error { return errors.New("flush failed") }})
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	results := m.Stop(context.Background())
+	if len(results) != 2 || results[0].Err == nil || results[0].Err.Error() != "flush failed" {
+		t.Fatalf("results = %+v", results)
+	}
+	if !errors.Is(results[1].Err, ErrStopTimeout) {
+		t.Errorf("stuck component err = %v, want ErrStopTimeout", results[1].Err)
+	}
+}
@@ -4,23 +4,53 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
-	"time"
 
+	"cursor2api/bench"
+	"cursor2api/cache"
 	"cursor2api/config"
+	"cursor2api/errdocs"
 	"cursor2api/handler"
+	"cursor2api/lifecycle"
 	"cursor2api/logger"
+	"cursor2api/metrics"
 	"cursor2api/middleware"
 	"cursor2api/models"
+	"cursor2api/preflight"
+	"cursor2api/redis"
+	"cursor2api/replay"
+	"cursor2api/router"
+	"cursor2api/selftest"
 	"cursor2api/service"
+	"cursor2api/tenant"
+	"cursor2api/tracing"
+	"cursor2api/upstream"
+	"cursor2api/usage"
+	"cursor2api/utils"
 	"github.com/joho/godotenv"
 )
 
 func main() {
-	// Load .env file at the very beginning
+	// Developer subcommands run standalone and never start the server
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "bench":
+			os.Exit(bench.Run(os.Args[2:]))
+		case "selftest":
+			os.Exit(selftest.Run(os.Args[2:]))
+		case "replay":
+			os.Exit(replay.Run(os.Args[2:]))
+		}
+	}
+
+	// Load .env file at the very beginning (after noting which variables came from
+	// the process environment, for GET /admin/config/effective)
+	config.CaptureProcessEnv()
 	if err := godotenv.Load(); err != nil {
 		log.Printf("⚠️  Warning: .env file not found or cannot be loaded: %v", err)
 		log.Println("ℹ️  Will use system environment variables or default values")
@@ -36,6 +66,7 @@ func main() {
 
 	// Initialize logger
 	logger.Init(cfg.Logger.Level, cfg.Logger.Verbose)
+	errdocs.Configure(cfg.ErrorDocs.Hints, cfg.ErrorDocs.DocsURL)
 	
 	// Log startup information with emoji for better readability
 	logger.Info("🚀 Starting cursor2api server")
@@ -49,31 +80,113 @@ func main() {
 	}
 	logger.Info("   └─ Process URL: %s", cfg.Cursor.ProcessURL)
 
+	// A CA bundle that cannot be loaded must not silently fall back to the system roots
+	if err := upstream.CheckTLS(cfg.Upstream); err != nil {
+		logger.Error("❌ Invalid upstream TLS settings | error=%v", err)
+		os.Exit(1)
+	}
+
+	// All upstream traffic (AntiBot script, solver, startup checks) shares one client
+	upstreamClient := upstream.NewClient(cfg.Upstream)
+
+	// Verify external dependencies up front; --check-only exits with the result
+	checkOnly := slices.Contains(os.Args[1:], "--check-only")
+	if checkOnly || cfg.Cursor.StartupChecks {
+		results := preflight.Run(context.Background(), upstreamClient, cfg, cfg.Cursor.StartupCheckTimeout)
+		if checkOnly {
+			preflight.Report(os.Stdout, results)
+			if preflight.Failed(results) {
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+		preflight.Report(log.Writer(), results)
+		if preflight.Failed(results) {
+			logger.Warn("⚠️  Startup checks failed, requests will fail until the dependencies above are fixed")
+		}
+	}
+
+	// Subsystems register start/stop hooks; they start in registration order and
+	// stop in reverse on shutdown, each bounded by its own timeout
+	components := lifecycle.New(cfg.Server.ComponentStopTimeout)
+
+	// OpenTelemetry spans exported to OTEL_EXPORTER_OTLP_ENDPOINT; registered first so
+	// it stops last, after the spans of requests finishing during shutdown ended
+	tracer := tracing.New(cfg.Observability)
+	components.OnStop("tracer", tracer.Stop)
+
 	// Initialize AntiBot Manager
-	antiBotManager := models.NewAntiBotManager(
-		cfg.Cursor.JSURL,
-		cfg.Cursor.ProcessURL,
-		cfg.Cursor.RefreshInterval,
-		cfg.Cursor.IdleTimeout,
-	)
+	antiBotManager := models.NewAntiBotManager(cfg.Cursor, upstreamClient)
 
-	// Start AntiBot Manager
-	logger.Info("🔧 Initializing AntiBot Manager...")
-	if err := antiBotManager.Start(); err != nil {
-		logger.Error("❌ Failed to start AntiBot manager | error=%v", err)
-		os.Exit(1)
+	// Start AntiBot Manager (not needed when the upstream is mocked)
+	if cfg.Mock.Enabled {
+		logger.Info("🧪 Mock mode enabled, skipping AntiBot Manager startup")
+	} else {
+		if cfg.Cursor.SharedTokenRedisURL != "" {
+			client, err := redis.New(cfg.Cursor.SharedTokenRedisURL)
+			if err != nil {
+				logger.Error("❌ Invalid ANTIBOT_SHARED_REDIS_URL | error=%v", err)
+				os.Exit(1)
+			}
+			antiBotManager.UseSharedToken(client, cfg.Cursor.SharedTokenKeyPrefix, cfg.Cursor.SharedTokenLockTTL)
+		}
+		components.Register(lifecycle.Hook{
+			Name: "antibot_manager",
+			Start: func(context.Context) error {
+				logger.Info("🔧 Initializing AntiBot Manager...")
+				if !cfg.Cursor.StartupRequireToken {
+					// Serve immediately; /ready reports 503 until the first refresh succeeds
+					antiBotManager.StartAsync()
+					logger.Info("⏳ AntiBot Manager initializing in background (STARTUP_REQUIRE_TOKEN=false)")
+					return nil
+				}
+				if err := antiBotManager.Start(); err != nil {
+					return err
+				}
+				logger.Info("✅ AntiBot Manager started successfully")
+				return nil
+			},
+			Stop: func(context.Context) error {
+				antiBotManager.Stop()
+				return nil
+			},
+		})
 	}
-	defer antiBotManager.Stop()
-	logger.Info("✅ AntiBot Manager started successfully")
 
 	// Initialize Cursor Service
-	cursorService := service.NewCursorService(antiBotManager, cfg.Cursor.SystemPrompt)
+	cursorService := service.NewCursorService(antiBotManager, cfg)
+
+	// Enable converter hooks (an unknown hook name is a configuration error, not something to skip)
+	converterHooks, err := utils.NewConverterHooks(cfg.Cursor.MessageHooks, cfg.Cursor.OutputHooks)
+	if err != nil {
+		logger.Error("❌ Failed to enable converter hooks | error=%v", err)
+		os.Exit(1)
+	}
+	if converterHooks != nil {
+		cursorService.UseConverterHooks(converterHooks)
+		logger.Info("Converter hooks enabled | %s", converterHooks)
+	}
+
+	// Load tenants (a broken tenants file must not silently drop tenant limits)
+	tenants, err := tenant.New(cfg.Tenant)
+	if err != nil {
+		logger.Error("❌ Failed to load tenants | file=%s error=%v", cfg.Tenant.File, err)
+		os.Exit(1)
+	}
 
 	// Initialize API Handler
-	apiHandler := handler.NewAPIHandler(cursorService, antiBotManager, cfg)
+	apiHandler := handler.NewAPIHandler(cursorService, antiBotManager, cfg, tenants)
+	components.OnStop("api_handler", apiHandler.Close)
+
+	// Start usage export (billing webhook / CSV / spend state persistence)
+	usageExporter := usage.NewExporter(cfg.Usage, apiHandler.Usage())
+	components.OnStop("usage_exporter", usageExporter.Stop)
 
 	// Initialize API key authentication middleware
-	authMiddleware := middleware.NewAPIKeyAuth(cfg.Auth.APIKeys, cfg.Auth.Enabled)
+	authMiddleware := middleware.NewAPIKeyAuth(slices.Concat(cfg.Auth.APIKeys, cfg.Auth.SandboxKeys, tenants.APIKeys()), cfg.Auth.Enabled)
+
+	// Initialize admin authentication (admin endpoints use role-scoped admin tokens instead of API keys)
+	adminAuth := middleware.NewAdminAuth(cfg.Auth, tenants)
 
 	// Initialize rate limiter middleware
 	rateLimiter := middleware.NewRateLimiter(
@@ -83,44 +196,158 @@ func main() {
 		cfg.RateLimit.Enabled,
 		cfg.RateLimit.CleanupInterval,
 	)
+	components.OnStop("rate_limiter", rateLimiter.Stop)
+	apiHandler.UseRateLimiter(rateLimiter)
+
+	// Apply scheduled and adaptive rate limit profiles (a broken policy file must not silently fall back to static limits)
+	limitPolicy, err := middleware.LoadLimitPolicy(cfg.RateLimit.PolicyFile)
+	if err != nil {
+		logger.Error("❌ Failed to load rate limit policy | file=%s error=%v", cfg.RateLimit.PolicyFile, err)
+		os.Exit(1)
+	}
+	rateLimiter.UsePolicy(limitPolicy, cursorService.UpstreamErrorRate, cfg.RateLimit.PolicyInterval)
+
+	// Initialize ban list (enforced before rate limiting, managed through the admin API)
+	banList := middleware.NewBanList(cfg.RateLimit.BanFile, cfg.RateLimit.TrustedProxies)
+
+	// Success-rate SLIs per endpoint and model over SLI_WINDOWS, checked against SLO_TARGET
+	sli := middleware.NewSLITracker(cfg.Observability.SLOTarget, cfg.Observability.SLIWindows)
+	components.OnStop("sli_tracker", sli.Stop)
 
 	// Setup HTTP router
-	mux := http.NewServeMux()
+	mux := router.New()
+	mux.NotFound = http.HandlerFunc(apiHandler.HandleNotFound)
 
-	// Health check endpoint (no authentication required)
-	mux.HandleFunc("/health", apiHandler.HandleHealth)
+	// Health, readiness and metrics endpoints (no authentication required)
+	mux.HandleFunc(http.MethodGet, "/health", apiHandler.HandleHealth)
+	mux.HandleFunc(http.MethodGet, "/ready", apiHandler.HandleReady)
+	if cfg.Server.MetricsEnabled {
+		// Go runtime (GC pauses, goroutines, heap) and build info next to the service metrics
+		metrics.RegisterRuntime()
+		mux.Handle(http.MethodGet, "/metrics", metrics.Handler())
+	}
 
 	// OpenAI-compatible endpoints (authentication required)
-	mux.HandleFunc("/v1/models", apiHandler.HandleModels)
-	mux.HandleFunc("/v1/chat/completions", apiHandler.HandleChatCompletions)
+	mux.HandleFunc(http.MethodGet, "/v1/models", apiHandler.HandleModels)
+	mux.HandleFunc(http.MethodPost, "/v1/chat/completions", apiHandler.HandleChatCompletions)
+	mux.HandleFunc(http.MethodPost, "/v1/chat/completions/{id}/cancel", apiHandler.HandleCancelCompletion)
+	mux.HandleFunc(http.MethodPost, "/v1/messages", apiHandler.HandleMessages)
+	mux.HandleFunc(http.MethodPost, "/v1beta/models/{model}:generateContent", apiHandler.HandleGenerateContent)
+	mux.HandleFunc(http.MethodPost, "/v1beta/models/{model}:streamGenerateContent", apiHandler.HandleStreamGenerateContent)
+	// Model IDs with a provider prefix (openai/gpt-5) span two path segments
+	mux.HandleFunc(http.MethodPost, "/v1beta/models/{provider}/{model}:generateContent", apiHandler.HandleGenerateContent)
+	mux.HandleFunc(http.MethodPost, "/v1beta/models/{provider}/{model}:streamGenerateContent", apiHandler.HandleStreamGenerateContent)
+
+	// Admin endpoints (admin token with at least the listed role required)
+	mux.Handle(http.MethodGet, "/admin/refresh", adminAuth.Require(middleware.RoleViewer, http.HandlerFunc(apiHandler.HandleRefreshStatus)))
+	mux.Handle(http.MethodPost, "/admin/refresh/pause", adminAuth.Require(middleware.RoleOperator, http.HandlerFunc(apiHandler.HandleRefreshPause)))
+	mux.Handle(http.MethodPost, "/admin/refresh/resume", adminAuth.Require(middleware.RoleOperator, http.HandlerFunc(apiHandler.HandleRefreshResume)))
+	mux.Handle(http.MethodGet, "/admin/accounts", adminAuth.Require(middleware.RoleViewer, http.HandlerFunc(apiHandler.HandleAccounts)))
+	mux.Handle(http.MethodGet, "/admin/models", adminAuth.Require(middleware.RoleViewer, http.HandlerFunc(apiHandler.HandleModelCatalog)))
+	mux.Handle(http.MethodPut, "/admin/models", adminAuth.Require(middleware.RoleAdmin, http.HandlerFunc(apiHandler.HandleModelCatalogReplace)))
+	mux.Handle(http.MethodGet, "/admin/config/effective", adminAuth.Require(middleware.RoleAdmin, http.HandlerFunc(apiHandler.HandleEffectiveConfig)))
+	mux.Handle(http.MethodGet, "/admin/prompts", adminAuth.Require(middleware.RoleViewer, http.HandlerFunc(apiHandler.HandlePrompts)))
+	mux.Handle(http.MethodGet, "/admin/tenants", adminAuth.RequireTenant(middleware.RoleViewer, http.HandlerFunc(apiHandler.HandleTenants)))
+	mux.Handle(http.MethodGet, "/admin/usage", adminAuth.RequireTenant(middleware.RoleViewer, http.HandlerFunc(apiHandler.HandleUsage)))
+	mux.Handle(http.MethodGet, "/admin/recent", adminAuth.RequireTenant(middleware.RoleViewer, http.HandlerFunc(apiHandler.HandleRecent)))
+	mux.Handle(http.MethodGet, "/admin/streams", adminAuth.RequireTenant(middleware.RoleViewer, http.HandlerFunc(apiHandler.HandleStreams)))
+	mux.Handle(http.MethodDelete, "/admin/streams/{id}", adminAuth.Require(middleware.RoleOperator, http.HandlerFunc(apiHandler.HandleStreamCancel)))
+	mux.Handle(http.MethodGet, "/admin/transcripts/{id}", adminAuth.Require(middleware.RoleAdmin, http.HandlerFunc(apiHandler.HandleTranscript)))
+	mux.Handle(http.MethodPost, "/admin/debug/convert", adminAuth.Require(middleware.RoleOperator, http.HandlerFunc(apiHandler.HandleDebugConvert)))
+	mux.Handle(http.MethodGet, "/admin/capture", adminAuth.Require(middleware.RoleViewer, http.HandlerFunc(apiHandler.HandleCaptureStatus)))
+	mux.Handle(http.MethodPost, "/admin/capture", adminAuth.Require(middleware.RoleAdmin, http.HandlerFunc(apiHandler.HandleCaptureStart)))
+	mux.Handle(http.MethodDelete, "/admin/capture", adminAuth.Require(middleware.RoleAdmin, http.HandlerFunc(apiHandler.HandleCaptureStop)))
+	// Registered before /admin/ratelimit/{id} so "users" is not taken for a limiter ID
+	if userLimiter := apiHandler.UserRateLimiter(); userLimiter != nil {
+		mux.Handle(http.MethodGet, "/admin/ratelimit/users", adminAuth.Require(middleware.RoleViewer, http.HandlerFunc(userLimiter.HandleAdminList)))
+		mux.Handle(http.MethodDelete, "/admin/ratelimit/users/{id}", adminAuth.Require(middleware.RoleOperator, http.HandlerFunc(userLimiter.HandleAdminReset)))
+	}
+	mux.Handle(http.MethodGet, "/admin/ratelimit", adminAuth.Require(middleware.RoleViewer, http.HandlerFunc(rateLimiter.HandleAdminList)))
+	mux.Handle(http.MethodDelete, "/admin/ratelimit/{id}", adminAuth.Require(middleware.RoleOperator, http.HandlerFunc(rateLimiter.HandleAdminReset)))
+	mux.Handle(http.MethodGet, "/admin/bans", adminAuth.Require(middleware.RoleViewer, http.HandlerFunc(banList.HandleAdminList)))
+	mux.Handle(http.MethodPost, "/admin/bans", adminAuth.Require(middleware.RoleOperator, http.HandlerFunc(banList.HandleAdminBan)))
+	mux.Handle(http.MethodDelete, "/admin/bans/{id}", adminAuth.Require(middleware.RoleOperator, http.HandlerFunc(banList.HandleAdminUnban)))
+	if sli != nil {
+		mux.Handle(http.MethodGet, "/admin/slo", adminAuth.Require(middleware.RoleViewer, http.HandlerFunc(sli.HandleAdmin)))
+	}
+
+	// Identical chat requests fired within DEDUPE_WINDOW share one upstream generation;
+	// finished responses are kept in the "dedupe" cache (CACHE_BACKENDS)
+	var dedupe *middleware.Dedupe
+	if cfg.Server.DedupeWindow > 0 {
+		dedupeCache, err := cache.Open("dedupe", cfg.Cache)
+		if err != nil {
+			logger.Error("❌ Failed to open dedupe cache | error=%v", err)
+			os.Exit(1)
+		}
+		dedupe = middleware.NewDedupe(cfg.Server.DedupeWindow, cfg.Server.DedupeMaxBytes, dedupeCache)
+		components.Register(lifecycle.Hook{
+			Name: "dedupe_cache",
+			Stop: func(context.Context) error { return dedupe.Close() },
+		})
+	}
 
-	// Apply middleware chain: CORS -> RateLimit -> Auth -> Router
-	handlerChain := middleware.CORS(rateLimiter.Middleware(authMiddleware.Middleware(mux)))
+	// Streams stay resumable for STREAM_RESUME_WINDOW after their client disconnects
+	resume := middleware.NewStreamResume(cfg.Stream.ResumeWindow, cfg.Stream.ResumeBufferBytes)
+
+	// Responses are signed for downstream services when RESPONSE_SIGNING_SECRET is set
+	signer := middleware.NewResponseSigner(cfg.Server.SigningSecret)
+
+	// Apply middleware chain: CORS -> XAPIKey -> Signing -> Preflight -> ClientSDK -> SLIs -> Bans -> RateLimit -> Auth -> Tenants -> Resume -> Dedupe -> Router
+	handlerChain := middleware.Tracing(tracer, middleware.CORS(middleware.XAPIKey(signer.Middleware(mux.Preflight(middleware.ClientSDK(sli.Middleware(banList.Middleware(rateLimiter.Middleware(authMiddleware.Middleware(middleware.Tenants(tenants, resume.Middleware(dedupe.Middleware(mux)))))))))))))
 
 	// Create HTTP server
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%s", cfg.Server.Port),
 		Handler:      handlerChain,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 120 * time.Second,
-		IdleTimeout:  60 * time.Second,
-	}
-
-	// Start server in a goroutine for graceful shutdown
-	go func() {
-		logger.Info("🌐 Server listening on %s", server.Addr)
-		logger.Info("📡 API Endpoints:")
-		logger.Info("   ├─ GET  /health")
-		logger.Info("   ├─ GET  /v1/models")
-		logger.Info("   └─ POST /v1/chat/completions")
-		logger.Info("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-		logger.Info("✨ Server is ready to accept requests!")
-		
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Error("❌ Server failed | error=%v", err)
-			os.Exit(1)
-		}
-	}()
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout, // SSE responses replace it with SERVER_STREAM_WRITE_TIMEOUT
+		IdleTimeout:  cfg.Server.IdleTimeout,
+	}
+
+	// Listen synchronously so a busy port fails startup, then serve in the background;
+	// registered last so it is the first component to stop
+	components.Register(lifecycle.Hook{
+		Name: "http_server",
+		Start: func(context.Context) error {
+			listener, err := net.Listen("tcp", server.Addr)
+			if err != nil {
+				return err
+			}
+			logger.Info("🌐 Server listening on %s", server.Addr)
+			logger.Info("📡 API Endpoints:")
+			routes := mux.Routes()
+			for i, route := range routes {
+				branch := "├─"
+				if i == len(routes)-1 {
+					branch = "└─"
+				}
+				logger.Info("   %s %s", branch, route)
+			}
+			logger.Info("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+			logger.Info("✨ Server is ready to accept requests!")
+
+			go func() {
+				if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+					logger.Error("❌ Server failed | error=%v", err)
+					os.Exit(1)
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			// End streams that would outlive the shutdown deadline with a clean error event
+			stop := apiHandler.DrainStreams(ctx)
+			defer stop()
+			return server.Shutdown(ctx)
+		},
+		Timeout: cfg.Server.ShutdownTimeout,
+	})
+
+	if err := components.Start(context.Background()); err != nil {
+		logger.Error("❌ Failed to start | error=%v", err)
+		os.Exit(1)
+	}
 
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
@@ -129,14 +356,19 @@ func main() {
 
 	logger.Info("🛑 Shutdown signal received, gracefully shutting down...")
 
-	// Create a deadline for shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Create a deadline for shutdown; every component gets its own share of it
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout+cfg.Server.ComponentStopTimeout)
 	defer cancel()
 
-	// Attempt graceful shutdown
-	if err := server.Shutdown(ctx); err != nil {
-		logger.Error("⚠️  Server forced to shutdown: %v", err)
+	failed := 0
+	for _, result := range components.Stop(ctx) {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		logger.Warn("⚠️  Server exited, %d components failed to stop cleanly", failed)
+		return
 	}
-
 	logger.Info("👋 Server exited gracefully")
 }
\ No newline at end of file
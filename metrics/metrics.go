@@ -0,0 +1,365 @@
+// Package metrics is a minimal Prometheus-compatible metrics registry.
+// Metrics are registered on Default and exposed in the text exposition format by Handler.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default latency buckets in seconds
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// collector is implemented by every metric type
+type collector interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry holds registered metrics
+type Registry struct {
+	mu         sync.RWMutex
+	collectors map[string]collector
+}
+
+// Default is the process-wide registry
+var Default = NewRegistry()
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+// register adds a collector, panicking on duplicate names like Prometheus' MustRegister
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.collectors[c.name()]; exists {
+		panic("metrics: duplicate metric name " + c.name())
+	}
+	r.collectors[c.name()] = c
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format, sorted by name
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]collector, 0, len(names))
+	for _, name := range names {
+		list = append(list, r.collectors[name])
+	}
+	r.mu.RUnlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, c := range list {
+		c.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler returns an HTTP handler serving the Default registry
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = Default.WriteTo(w)
+	})
+}
+
+// metricDesc holds the name, help text and label names shared by all metric types
+type metricDesc struct {
+	metricName string
+	help       string
+	labelNames []string
+}
+
+func (d *metricDesc) name() string { return d.metricName }
+
+// writeHeader writes the HELP and TYPE lines
+func (d *metricDesc) writeHeader(w *bufio.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.metricName, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.metricName, typ)
+}
+
+// labelKey joins label values into a map key, validating the count
+func (d *metricDesc) labelKey(values []string) string {
+	if len(values) != len(d.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.metricName, len(d.labelNames), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// formatLabels renders {k="v",...} for the given values plus optional extra pairs
+func (d *metricDesc) formatLabels(values []string, extra ...string) string {
+	if len(d.labelNames) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range d.labelNames {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extra[i])
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(extra[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// series is one labelled value of a counter or gauge
+type series struct {
+	labels []string
+	value  float64
+}
+
+// Counter is a monotonically increasing value, optionally partitioned by labels
+type Counter struct {
+	metricDesc
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// NewCounter creates and registers a counter on Default
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{
+		metricDesc: metricDesc{metricName: name, help: help, labelNames: labelNames},
+		series:     make(map[string]*series),
+	}
+	Default.register(c)
+	return c
+}
+
+// Inc adds one to the series identified by labelValues
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v (which must be non-negative) to the series identified by labelValues
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	key := c.labelKey(labelValues)
+	c.mu.Lock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), labelValues...)}
+		c.series[key] = s
+	}
+	s.value += v
+	c.mu.Unlock()
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.writeHeader(w, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, c.formatLabels(s.labels), formatFloat(s.value))
+	}
+}
+
+// Gauge is a value that can go up and down, optionally partitioned by labels
+type Gauge struct {
+	metricDesc
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// NewGauge creates and registers a gauge on Default
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	g := &Gauge{
+		metricDesc: metricDesc{metricName: name, help: help, labelNames: labelNames},
+		series:     make(map[string]*series),
+	}
+	Default.register(g)
+	return g
+}
+
+// Set sets the series identified by labelValues to v
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.update(labelValues, func(s *series) { s.value = v })
+}
+
+// Add adds v (possibly negative) to the series identified by labelValues
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.update(labelValues, func(s *series) { s.value += v })
+}
+
+// Inc adds one to the series identified by labelValues
+func (g *Gauge) Inc(labelValues ...string) { g.Add(1, labelValues...) }
+
+// Dec subtracts one from the series identified by labelValues
+func (g *Gauge) Dec(labelValues ...string) { g.Add(-1, labelValues...) }
+
+func (g *Gauge) update(labelValues []string, fn func(s *series)) {
+	key := g.labelKey(labelValues)
+	g.mu.Lock()
+	s, ok := g.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), labelValues...)}
+		g.series[key] = s
+	}
+	fn(s)
+	g.mu.Unlock()
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.writeHeader(w, "gauge")
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, key := range sortedKeys(g.series) {
+		s := g.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", g.metricName, g.formatLabels(s.labels), formatFloat(s.value))
+	}
+}
+
+// GaugeFunc is an unlabelled gauge whose value is read at scrape time
+type GaugeFunc struct {
+	metricDesc
+	fn func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge backed by fn on Default
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{metricDesc: metricDesc{metricName: name, help: help}, fn: fn}
+	Default.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.writeHeader(w, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(g.fn()))
+}
+
+// histogramSeries is one labelled histogram
+type histogramSeries struct {
+	labels []string
+	counts []uint64 // Per-bucket (non-cumulative) counts; the last entry is +Inf
+	sum    float64
+	count  uint64
+}
+
+// Histogram samples observations into cumulative buckets, optionally partitioned by labels
+type Histogram struct {
+	metricDesc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+// NewHistogram creates and registers a histogram on Default; buckets must be sorted ascending
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	h := &Histogram{
+		metricDesc: metricDesc{metricName: name, help: help, labelNames: labelNames},
+		buckets:    buckets,
+		series:     make(map[string]*histogramSeries),
+	}
+	Default.register(h)
+	return h
+}
+
+// Observe records v in the series identified by labelValues
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.labelKey(labelValues)
+	idx := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			labels: append([]string(nil), labelValues...),
+			counts: make([]uint64, len(h.buckets)+1),
+		}
+		h.series[key] = s
+	}
+	s.counts[idx]++
+	s.sum += v
+	s.count++
+	h.mu.Unlock()
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.writeHeader(w, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.formatLabels(s.labels, "le", formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.formatLabels(s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, h.formatLabels(s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, h.formatLabels(s.labels), s.count)
+	}
+}
+
+// sortedKeys returns map keys in a stable order for deterministic output
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatFloat renders a sample value the way Prometheus expects
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// countingWriter tracks bytes written for WriteTo
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := Default.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	return buf.String()
+}
+
+func TestCounterAndGauge(t *testing.T) {
+	c := NewCounter("test_requests_total", "Test requests.", "code")
+	c.Inc("200")
+	c.Add(2, "200")
+	c.Inc("500")
+
+	g := NewGauge("test_in_flight", "Test in-flight requests.")
+	g.Inc()
+	g.Inc()
+	g.Dec()
+
+	out := scrape(t)
+	for _, want := range []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{code="200"} 3`,
+		`test_requests_total{code="500"} 1`,
+		"# TYPE test_in_flight gauge",
+		"test_in_flight 1",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q\n%s", want, out)
+		}
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	h := NewHistogram("test_latency_seconds", "Test latency.", []float64{0.1, 1}, "route")
+	h.Observe(0.05, "chat")
+	h.Observe(0.1, "chat") // Upper bounds are inclusive
+	h.Observe(0.5, "chat")
+	h.Observe(3, "chat")
+
+	out := scrape(t)
+	for _, want := range []string{
+		"# TYPE test_latency_seconds histogram",
+		`test_latency_seconds_bucket{route="chat",le="0.1"} 2`,
+		`test_latency_seconds_bucket{route="chat",le="1"} 3`,
+		`test_latency_seconds_bucket{route="chat",le="+Inf"} 4`,
+		`test_latency_seconds_sum{route="chat"} 3.65`,
+		`test_latency_seconds_count{route="chat"} 4`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q\n%s", want, out)
+		}
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	c := NewCounter("test_escaped_total", "Help with \\ backslash.", "value")
+	c.Inc("a\"b\nc")
+
+	out := scrape(t)
+	if !strings.Contains(out, `test_escaped_total{value="a\"b\nc"} 1`) {
+		t.Errorf("label not escaped\n%s", out)
+	}
+	if !strings.Contains(out, `# HELP test_escaped_total Help with \\ backslash.`) {
+		t.Errorf("help not escaped\n%s", out)
+	}
+}
+
+func TestDuplicateRegistrationPanics(t *testing.T) {
+	NewCounter("test_duplicate_total", "First.")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on duplicate registration")
+		}
+	}()
+	NewCounter("test_duplicate_total", "Second.")
+}
+
+func TestRegisterRuntime(t *testing.T) {
+	RegisterRuntime()
+	RegisterRuntime() // Registering twice must not panic on duplicate names
+
+	out := scrape(t)
+	for _, want := range []string{
+		"# TYPE go_gc_duration_seconds summary",
+		`go_gc_duration_seconds{quantile="0.5"} `,
+		"go_gc_duration_seconds_count ",
+		"go_goroutines ",
+		"go_memstats_heap_alloc_bytes ",
+		"# TYPE go_memstats_alloc_bytes_total counter",
+		`go_info{version="go`,
+		"cursor2api_build_info{version=",
+		"process_start_time_seconds ",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+}
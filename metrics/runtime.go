@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// processStart is reported as process_start_time_seconds
+var processStart = time.Now()
+
+// gcQuantiles are the quantiles of go_gc_duration_seconds, as in the Prometheus Go collector
+var gcQuantiles = []float64{0, 0.25, 0.5, 0.75, 1}
+
+var registerRuntime sync.Once
+
+// RegisterRuntime adds the standard Go collector metrics (goroutines, threads, GC
+// pauses, heap and allocator statistics) and build information to Default, using the
+// metric names of the Prometheus Go client so existing dashboards work unchanged.
+// Calling it more than once has no further effect.
+func RegisterRuntime() {
+	registerRuntime.Do(func() {
+		Default.register(&runtimeCollector{build: readBuildInfo()})
+	})
+}
+
+// buildInfo holds the labels of cursor2api_build_info
+type buildInfo struct {
+	version   string
+	revision  string
+	vcsTime   string
+	modified  string
+	goVersion string
+}
+
+// readBuildInfo reads the module version and VCS stamp embedded by go build
+func readBuildInfo() buildInfo {
+	info := buildInfo{version: "unknown", revision: "unknown", modified: "unknown", goVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.version = cmp.Or(bi.Main.Version, info.version)
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.revision = setting.Value
+		case "vcs.time":
+			info.vcsTime = setting.Value
+		case "vcs.modified":
+			info.modified = setting.Value
+		}
+	}
+	return info
+}
+
+// runtimeCollector reads the runtime statistics at scrape time
+type runtimeCollector struct {
+	build buildInfo
+}
+
+func (c *runtimeCollector) name() string { return "go_runtime" }
+
+func (c *runtimeCollector) write(w *bufio.Writer) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	gc := debug.GCStats{PauseQuantiles: make([]time.Duration, len(gcQuantiles))}
+	debug.ReadGCStats(&gc)
+	threads, _ := runtime.ThreadCreateProfile(nil)
+
+	value := func(name, typ, help string, v float64) {
+		writeFamily(w, name, typ, help)
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(v))
+	}
+
+	writeFamily(w, "cursor2api_build_info", "gauge", "Build information of the running binary; the value is always 1.")
+	fmt.Fprintf(w, "cursor2api_build_info{version=\"%s\",revision=\"%s\",vcs_time=\"%s\",modified=\"%s\",goversion=\"%s\"} 1\n",
+		escapeLabel(c.build.version), escapeLabel(c.build.revision), escapeLabel(c.build.vcsTime), escapeLabel(c.build.modified), escapeLabel(c.build.goVersion))
+
+	writeFamily(w, "go_gc_duration_seconds", "summary", "A summary of the pause duration of garbage collection cycles.")
+	for i, q := range gcQuantiles {
+		fmt.Fprintf(w, "go_gc_duration_seconds{quantile=\"%s\"} %s\n", formatFloat(q), formatFloat(gc.PauseQuantiles[i].Seconds()))
+	}
+	fmt.Fprintf(w, "go_gc_duration_seconds_sum %s\n", formatFloat(gc.PauseTotal.Seconds()))
+	fmt.Fprintf(w, "go_gc_duration_seconds_count %d\n", gc.NumGC)
+
+	value("go_goroutines", "gauge", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	writeFamily(w, "go_info", "gauge", "Information about the Go environment.")
+	fmt.Fprintf(w, "go_info{version=\"%s\"} 1\n", escapeLabel(runtime.Version()))
+
+	value("go_memstats_alloc_bytes", "gauge", "Number of bytes allocated and still in use.", float64(ms.Alloc))
+	value("go_memstats_alloc_bytes_total", "counter", "Total number of bytes allocated, even if freed.", float64(ms.TotalAlloc))
+	value("go_memstats_frees_total", "counter", "Total number of frees.", float64(ms.Frees))
+	value("go_memstats_gc_sys_bytes", "gauge", "Number of bytes used for garbage collection system metadata.", float64(ms.GCSys))
+	value("go_memstats_heap_alloc_bytes", "gauge", "Number of heap bytes allocated and still in use.", float64(ms.HeapAlloc))
+	value("go_memstats_heap_idle_bytes", "gauge", "Number of heap bytes waiting to be used.", float64(ms.HeapIdle))
+	value("go_memstats_heap_inuse_bytes", "gauge", "Number of heap bytes that are in use.", float64(ms.HeapInuse))
+	value("go_memstats_heap_objects", "gauge", "Number of allocated objects.", float64(ms.HeapObjects))
+	value("go_memstats_heap_released_bytes", "gauge", "Number of heap bytes released to OS.", float64(ms.HeapReleased))
+	value("go_memstats_heap_sys_bytes", "gauge", "Number of heap bytes obtained from system.", float64(ms.HeapSys))
+	value("go_memstats_last_gc_time_seconds", "gauge", "Number of seconds since 1970 of last garbage collection.", float64(ms.LastGC)/1e9)
+	value("go_memstats_mallocs_total", "counter", "Total number of mallocs.", float64(ms.Mallocs))
+	value("go_memstats_next_gc_bytes", "gauge", "Number of heap bytes when next garbage collection will take place.", float64(ms.NextGC))
+	value("go_memstats_stack_inuse_bytes", "gauge", "Number of bytes in use by the stack allocator.", float64(ms.StackInuse))
+	value("go_memstats_sys_bytes", "gauge", "Number of bytes obtained from system.", float64(ms.Sys))
+	value("go_threads", "gauge", "Number of OS threads created.", float64(threads))
+
+	value("process_start_time_seconds", "gauge", "Start time of the process since unix epoch in seconds.", float64(processStart.UnixNano())/1e9)
+}
+
+// writeFamily writes the HELP and TYPE lines of a metric family without a metricDesc
+func writeFamily(w *bufio.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
@@ -0,0 +1,198 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"cursor2api/config"
+	"cursor2api/errdocs"
+	"cursor2api/logger"
+	"cursor2api/tenant"
+	"cursor2api/types"
+)
+
+// AdminPathPrefix is the path prefix of admin endpoints, which use AdminAuth instead of API keys
+const AdminPathPrefix = "/admin/"
+
+// AdminRole is the permission level granted by an admin token; higher roles include lower ones
+type AdminRole int
+
+const (
+	// RoleViewer can read stats and health details
+	RoleViewer AdminRole = iota + 1
+	// RoleOperator can additionally run operational actions such as pausing refreshes
+	RoleOperator
+	// RoleAdmin can additionally manage keys and configuration
+	RoleAdmin
+)
+
+// String returns the role name used in configuration and logs
+func (r AdminRole) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// ParseAdminRole parses a role name from configuration
+func ParseAdminRole(name string) (AdminRole, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "viewer":
+		return RoleViewer, true
+	case "operator":
+		return RoleOperator, true
+	case "admin":
+		return RoleAdmin, true
+	default:
+		return 0, false
+	}
+}
+
+// adminToken is a configured admin bearer token and the role it grants
+type adminToken struct {
+	token  []byte
+	role   AdminRole
+	tenant string // "" for instance-wide tokens
+}
+
+// AdminAuth protects admin endpoints with dedicated bearer tokens, each mapped to a role
+type AdminAuth struct {
+	tokens []adminToken
+}
+
+// NewAdminAuth creates admin authentication from ADMIN_TOKEN (admin role), ADMIN_TOKENS
+// (token=role pairs) and the tenants' admin tokens; with no tokens every admin request is rejected
+func NewAdminAuth(cfg config.AuthConfig, tenants *tenant.Registry) *AdminAuth {
+	a := &AdminAuth{}
+	if cfg.AdminToken != "" {
+		a.tokens = append(a.tokens, adminToken{token: []byte(cfg.AdminToken), role: RoleAdmin})
+	}
+	for token, name := range cfg.AdminTokens {
+		role, ok := ParseAdminRole(name)
+		if !ok {
+			logger.Warn("Ignoring admin token with unknown role | role=%s", name)
+			continue
+		}
+		a.tokens = append(a.tokens, adminToken{token: []byte(token), role: role})
+	}
+	for _, t := range tenants.List() {
+		for token, name := range t.AdminTokens {
+			role, ok := ParseAdminRole(name)
+			if !ok {
+				logger.Warn("Ignoring tenant admin token with unknown role | tenant=%s role=%s", t.Name, name)
+				continue
+			}
+			a.tokens = append(a.tokens, adminToken{token: []byte(token), role: role, tenant: t.Name})
+		}
+	}
+
+	logger.Info("Admin authentication initialized | enabled=%v tokens=%d", a.Enabled(), len(a.tokens))
+	return a
+}
+
+// Enabled reports whether any admin token is configured
+func (a *AdminAuth) Enabled() bool {
+	return len(a.tokens) > 0
+}
+
+// lookup returns the token that matches; every configured token is compared
+// so the response time does not reveal which one matched
+func (a *AdminAuth) lookup(token string) adminToken {
+	var match adminToken
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), t.token) == 1 && t.role > match.role {
+			match = t
+		}
+	}
+	return match
+}
+
+// Require returns a handler that requires "Authorization: Bearer <token>" with at least
+// the given role; tenant admin tokens are rejected because the endpoint is instance-wide
+func (a *AdminAuth) Require(role AdminRole, next http.Handler) http.Handler {
+	return a.authorize(role, false, next)
+}
+
+// RequireTenant is like Require for endpoints scoped to a tenant. Tenant admin tokens
+// only see their own tenant; instance-wide tokens select one with ?tenant= or see all.
+// The scope is available through AdminTenantFromContext.
+func (a *AdminAuth) RequireTenant(role AdminRole, next http.Handler) http.Handler {
+	return a.authorize(role, true, next)
+}
+
+// authorize checks the admin token, its role and its tenant scope
+func (a *AdminAuth) authorize(role AdminRole, tenantScoped bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.Enabled() {
+			respondAdminError(w, r, http.StatusForbidden, "admin_disabled", "Admin endpoints are disabled, set ADMIN_TOKEN or ADMIN_TOKENS to enable them")
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		var granted adminToken
+		if ok {
+			granted = a.lookup(token)
+		}
+		if granted.role == 0 {
+			logger.Warn("Invalid admin token attempt | client_ip=%s path=%s method=%s", getClientIP(r), r.URL.Path, r.Method)
+			respondAdminError(w, r, http.StatusUnauthorized, "invalid_admin_token", "Invalid admin token provided")
+			return
+		}
+		if granted.role < role {
+			logger.Warn("Admin role denied | client_ip=%s path=%s method=%s role=%s required=%s", getClientIP(r), r.URL.Path, r.Method, granted.role, role)
+			respondAdminError(w, r, http.StatusForbidden, "insufficient_admin_role", "This endpoint requires the "+role.String()+" role")
+			return
+		}
+
+		scope := r.URL.Query().Get("tenant")
+		if granted.tenant != "" {
+			if !tenantScoped || (scope != "" && scope != granted.tenant) {
+				logger.Warn("Tenant admin token out of scope | client_ip=%s path=%s method=%s tenant=%s", getClientIP(r), r.URL.Path, r.Method, granted.tenant)
+				respondAdminError(w, r, http.StatusForbidden, "tenant_scope_denied", "This admin token is limited to tenant "+granted.tenant)
+				return
+			}
+			scope = granted.tenant
+		}
+
+		logger.Info("Admin request | client_ip=%s path=%s method=%s role=%s tenant=%s", getClientIP(r), r.URL.Path, r.Method, granted.role, granted.tenant)
+		ctx := withAdminRole(r.Context(), granted.role)
+		if tenantScoped {
+			ctx = withAdminTenant(ctx, scope)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// isAdminPath reports whether the request targets an admin endpoint
+func isAdminPath(path string) bool {
+	return strings.HasPrefix(path, AdminPathPrefix)
+}
+
+// respondAdminError sends an OpenAI-compatible error response for admin requests
+func respondAdminError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	w.WriteHeader(status)
+
+	errResp := types.OpenAIErrorResponse{
+		Error: types.OpenAIError{
+			Message: message,
+			Type:    "invalid_request_error",
+			Code:    code,
+		},
+	}
+
+	errResp.Error.Hint, errResp.Error.DocURL = errdocs.Annotate(errResp.Error.Code, "")
+	if err := types.WriteJSON(w, errResp); err != nil {
+		logger.Error("Failed to write error response | error=%v client_ip=%s", err, getClientIP(r))
+	}
+}
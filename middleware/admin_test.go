@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cursor2api/config"
+	"cursor2api/tenant"
+)
+
+func TestAdminAuth_Roles(t *testing.T) {
+	auth := NewAdminAuth(config.AuthConfig{
+		AdminToken: "root-token",
+		AdminTokens: map[string]string{
+			"view-token": "viewer",
+			"ops-token":  "operator",
+			"bad-token":  "superuser",
+		},
+	}, nil)
+
+	var gotRole AdminRole
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRole = AdminRoleFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := auth.Require(RoleOperator, next)
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+		role   AdminRole
+	}{
+		{name: "missing token", token: "", status: http.StatusUnauthorized},
+		{name: "unknown token", token: "nope", status: http.StatusUnauthorized},
+		{name: "unknown role is ignored", token: "bad-token", status: http.StatusUnauthorized},
+		{name: "viewer below required role", token: "view-token", status: http.StatusForbidden},
+		{name: "operator", token: "ops-token", status: http.StatusOK, role: RoleOperator},
+		{name: "legacy admin token", token: "root-token", status: http.StatusOK, role: RoleAdmin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotRole = 0
+			req := httptest.NewRequest(http.MethodPost, "/admin/refresh/pause", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if gotRole != tt.role {
+				t.Fatalf("role in context = %s, want %s", gotRole, tt.role)
+			}
+		})
+	}
+}
+
+func TestAdminAuth_Disabled(t *testing.T) {
+	auth := NewAdminAuth(config.AuthConfig{}, nil)
+	handler := auth.Require(RoleViewer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler must not run when admin endpoints are disabled")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/refresh", nil)
+	req.Header.Set("Authorization", "Bearer anything")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestAdminAuth_TenantScope(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tenants.json")
+	data := `{"acme": {"admin_tokens": {"acme-token": "viewer"}}, "beta": {}}`
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tenants, err := tenant.New(config.TenantConfig{File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := NewAdminAuth(config.AuthConfig{AdminToken: "root-token"}, tenants)
+
+	var gotScope string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotScope = AdminTenantFromContext(r.Context())
+	})
+
+	tests := []struct {
+		name   string
+		scoped bool
+		token  string
+		target string
+		status int
+		scope  string
+	}{
+		{name: "tenant token on scoped endpoint", scoped: true, token: "acme-token", target: "/admin/usage", status: http.StatusOK, scope: "acme"},
+		{name: "tenant token asks for another tenant", scoped: true, token: "acme-token", target: "/admin/usage?tenant=beta", status: http.StatusForbidden},
+		{name: "tenant token on instance-wide endpoint", token: "acme-token", target: "/admin/refresh", status: http.StatusForbidden},
+		{name: "global token sees all tenants", scoped: true, token: "root-token", target: "/admin/usage", status: http.StatusOK},
+		{name: "global token selects a tenant", scoped: true, token: "root-token", target: "/admin/usage?tenant=beta", status: http.StatusOK, scope: "beta"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotScope = ""
+			handler := auth.Require(RoleViewer, next)
+			if tt.scoped {
+				handler = auth.RequireTenant(RoleViewer, next)
+			}
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if gotScope != tt.scope {
+				t.Fatalf("scope = %q, want %q", gotScope, tt.scope)
+			}
+		})
+	}
+}
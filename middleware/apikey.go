@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// XAPIKeyHeader carries the API key of Anthropic SDK clients
+	XAPIKeyHeader = "X-Api-Key"
+	// GoogAPIKeyHeader carries the API key of Gemini SDK clients
+	GoogAPIKeyHeader = "X-Goog-Api-Key"
+)
+
+// XAPIKey accepts the API key in "x-api-key: <API_KEY>", as sent by Anthropic SDK
+// clients of /v1/messages, and in "x-goog-api-key: <API_KEY>" or "?key=<API_KEY>"
+// (Gemini endpoints under /v1beta only), as sent by Gemini SDK clients, by
+// rewriting it to "Authorization: Bearer <API_KEY>" before the ban list, rate
+// limiter and APIKeyAuth read it. An Authorization header takes precedence;
+// admin endpoints keep their own token.
+func XAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" || isAdminPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := r.Header.Get(XAPIKeyHeader)
+		if key == "" {
+			key = r.Header.Get(GoogAPIKeyHeader)
+		}
+		var query url.Values
+		fromQuery := key == "" && strings.HasPrefix(r.URL.Path, "/v1beta/")
+		if fromQuery {
+			query = r.URL.Query()
+			key = query.Get("key")
+		}
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer "+key)
+		r.Header.Del(XAPIKeyHeader)
+		r.Header.Del(GoogAPIKeyHeader)
+		if fromQuery {
+			// Keep the key out of access logs and anything else that records the URL
+			query.Del("key")
+			r.URL.RawQuery = query.Encode()
+			r.RequestURI = r.URL.RequestURI()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestXAPIKey(t *testing.T) {
+	var got *http.Request
+	handler := XAPIKey(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = r }))
+
+	tests := []struct {
+		name      string
+		target    string
+		header    string
+		value     string
+		wantAuth  string
+		wantQuery string
+	}{
+		{"anthropic header", "/v1/messages", XAPIKeyHeader, "sk-a", "Bearer sk-a", ""},
+		{"gemini header", "/v1beta/models/m:generateContent", GoogAPIKeyHeader, "sk-g", "Bearer sk-g", ""},
+		{"gemini query", "/v1beta/models/m:streamGenerateContent?alt=sse&key=sk-q", "", "", "Bearer sk-q", "alt=sse"},
+		{"query outside v1beta", "/v1/chat/completions?key=sk-q", "", "", "", "key=sk-q"},
+		{"authorization wins", "/v1/messages", "Authorization", "Bearer sk-b", "Bearer sk-b", ""},
+		{"admin path", "/admin/streams", XAPIKeyHeader, "sk-a", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if auth := got.Header.Get("Authorization"); auth != tt.wantAuth {
+				t.Errorf("Authorization = %q, want %q", auth, tt.wantAuth)
+			}
+			if got.URL.RawQuery != tt.wantQuery {
+				t.Errorf("query = %q, want %q", got.URL.RawQuery, tt.wantQuery)
+			}
+		})
+	}
+}
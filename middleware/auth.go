@@ -6,6 +6,7 @@ import (
 	"strings"
 	"sync"
 
+	"cursor2api/errdocs"
 	"cursor2api/logger"
 	"cursor2api/types"
 )
@@ -44,8 +45,14 @@ func (a *APIKeyAuth) Middleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Whitelist: /health endpoint doesn't require authentication
-		if r.URL.Path == "/health" {
+		// Whitelist: /health, /ready and /metrics endpoints don't require authentication
+		if r.URL.Path == "/health" || r.URL.Path == "/ready" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Admin endpoints are protected by AdminAuth with their own token
+		if isAdminPath(r.URL.Path) {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -79,7 +86,7 @@ func (a *APIKeyAuth) Middleware(next http.Handler) http.Handler {
 		logger.Info("API key authentication successful | masked_key=%s client_ip=%s path=%s method=%s",
 			maskAPIKey(apiKey), getClientIP(r), r.URL.Path, r.Method)
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(withAPIKey(r.Context(), apiKey)))
 	})
 }
 
@@ -127,6 +134,7 @@ func (a *APIKeyAuth) respondUnauthorized(w http.ResponseWriter, r *http.Request,
 		},
 	}
 
+	errResp.Error.Hint, errResp.Error.DocURL = errdocs.Annotate(errResp.Error.Code, "")
 	if err := types.WriteJSON(w, errResp); err != nil {
 		logger.Error("Failed to write error response | error=%v client_ip=%s", err, getClientIP(r))
 	}
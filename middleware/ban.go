@@ -0,0 +1,403 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cursor2api/errdocs"
+	"cursor2api/logger"
+	"cursor2api/metrics"
+	"cursor2api/types"
+)
+
+// Ban types
+const (
+	BanTypeIP  = "ip"
+	BanTypeKey = "key"
+)
+
+var bannedRequests = metrics.NewCounter(
+	"cursor2api_banned_requests_total",
+	"Requests rejected by the ban list, by ban type.",
+	"type")
+
+// Ban is one banned IP address or API key. Keys are never stored: the ID is derived
+// from the banned value and requests are matched by recomputing it.
+type Ban struct {
+	ID        string     `json:"id"`
+	Type      string     `json:"type"`  // ip or key
+	Value     string     `json:"value"` // IP address, or the masked API key
+	Reason    string     `json:"reason,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // nil = permanent
+}
+
+// active reports whether the ban is still in force at now
+func (b *Ban) active(now time.Time) bool {
+	return b.ExpiresAt == nil || now.Before(*b.ExpiresAt)
+}
+
+// banID derives the ID of a ban from its type and full value
+func banID(kind, value string) string {
+	sum := sha256.Sum256([]byte(kind + ":" + value))
+	return hex.EncodeToString(sum[:8])
+}
+
+// BanList rejects requests from banned IP addresses and API keys. Bans are enforced
+// before rate limiting and persisted to a JSON file so they survive restarts.
+type BanList struct {
+	mu      sync.RWMutex
+	bans    map[string]*Ban
+	path    string
+	proxies []*net.IPNet // Peers whose X-Forwarded-For is trusted
+}
+
+// NewBanList creates a ban list persisted to path (empty = in memory only). IP bans
+// match the peer address of a request, or the client named in X-Forwarded-For when
+// the peer is one of trustedProxies (IPs or CIDRs).
+func NewBanList(path string, trustedProxies []string) *BanList {
+	b := &BanList{bans: make(map[string]*Ban), path: path, proxies: parseProxies(trustedProxies)}
+	if path == "" {
+		return b
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		logger.Warn("Failed to read ban file, starting with an empty ban list | file=%s error=%v", path, err)
+	default:
+		var bans []*Ban
+		if err := json.Unmarshal(data, &bans); err != nil {
+			logger.Warn("Failed to parse ban file, starting with an empty ban list | file=%s error=%v", path, err)
+			break
+		}
+		now := time.Now()
+		for _, ban := range bans {
+			if ban.ID != "" && ban.active(now) {
+				b.bans[ban.ID] = ban
+			}
+		}
+	}
+	logger.Info("Ban list initialized | file=%s bans=%d", path, len(b.bans))
+	return b
+}
+
+// Ban bans an IP address or API key for duration (0 = permanent); banning an
+// already banned value replaces the previous ban
+func (b *BanList) Ban(kind, value, reason string, duration time.Duration) (Ban, error) {
+	value = strings.TrimSpace(value)
+	shown := value
+	switch kind {
+	case BanTypeIP:
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return Ban{}, fmt.Errorf("invalid IP address %q", value)
+		}
+		// Requests are matched by their canonical address
+		value = ip.String()
+		shown = value
+	case BanTypeKey:
+		if value == "" {
+			return Ban{}, errors.New("value must be the API key to ban")
+		}
+		shown = maskAPIKey(value)
+	default:
+		return Ban{}, fmt.Errorf("type must be %q or %q", BanTypeIP, BanTypeKey)
+	}
+	if duration < 0 {
+		return Ban{}, errors.New("duration must not be negative")
+	}
+
+	now := time.Now()
+	ban := &Ban{
+		ID:        banID(kind, value),
+		Type:      kind,
+		Value:     shown,
+		Reason:    reason,
+		CreatedAt: now,
+	}
+	if duration > 0 {
+		expires := now.Add(duration)
+		ban.ExpiresAt = &expires
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bans[ban.ID] = ban
+	b.pruneLocked(now)
+	b.saveLocked()
+	return *ban, nil
+}
+
+// Unban lifts a ban by ID
+func (b *BanList) Unban(id string) (Ban, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ban, ok := b.bans[id]
+	if !ok || !ban.active(time.Now()) {
+		return Ban{}, false
+	}
+	delete(b.bans, id)
+	b.saveLocked()
+	return *ban, true
+}
+
+// List returns the bans in force, newest first
+func (b *BanList) List() []Ban {
+	now := time.Now()
+	b.mu.RLock()
+	list := make([]Ban, 0, len(b.bans))
+	for _, ban := range b.bans {
+		if ban.active(now) {
+			list = append(list, *ban)
+		}
+	}
+	b.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list
+}
+
+// match returns the ban in force for a request's IP or API key
+func (b *BanList) match(ip, apiKey string) (*Ban, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.bans) == 0 {
+		return nil, false
+	}
+	now := time.Now()
+	if ban, ok := b.bans[banID(BanTypeIP, ip)]; ok && ban.active(now) {
+		return ban, true
+	}
+	if apiKey != "" {
+		if ban, ok := b.bans[banID(BanTypeKey, apiKey)]; ok && ban.active(now) {
+			return ban, true
+		}
+	}
+	return nil, false
+}
+
+// parseProxies parses the trusted proxies; invalid entries are logged and skipped
+func parseProxies(proxies []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil {
+				if v4 := ip.To4(); v4 != nil {
+					ip = v4
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+				continue
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			logger.Warn("Invalid trusted proxy ignored | proxy=%s", proxy)
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+// trusted reports whether ip is a trusted proxy
+func (b *BanList) trusted(ip net.IP) bool {
+	for _, proxy := range b.proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the canonical address of the client of a request: the peer
+// address, or when the peer is a trusted proxy the last X-Forwarded-For entry not
+// added by a trusted proxy. Headers of untrusted peers are ignored.
+func (b *BanList) clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if b.trusted(ip) {
+		hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+			ip = hop
+			if !b.trusted(hop) {
+				break
+			}
+		}
+	}
+	return ip.String()
+}
+
+// pruneLocked drops expired bans; the caller holds the write lock
+func (b *BanList) pruneLocked(now time.Time) {
+	for id, ban := range b.bans {
+		if !ban.active(now) {
+			delete(b.bans, id)
+		}
+	}
+}
+
+// saveLocked persists the bans; the caller holds the lock. A failed write is
+// logged and the change stays in effect in memory.
+func (b *BanList) saveLocked() {
+	if b.path == "" {
+		return
+	}
+	if err := b.writeLocked(); err != nil {
+		logger.Error("Failed to persist ban list | file=%s error=%v", b.path, err)
+	}
+}
+
+// writeLocked writes the bans to a temporary file and renames it into place
+func (b *BanList) writeLocked() error {
+	list := make([]*Ban, 0, len(b.bans))
+	for _, ban := range b.bans {
+		list = append(list, ban)
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(b.path), filepath.Base(b.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), b.path)
+}
+
+// Middleware rejects requests from banned IPs and keys. It runs before rate limiting;
+// health, readiness, metrics and admin endpoints are never blocked.
+func (b *BanList) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/ready" || r.URL.Path == "/metrics" || isAdminPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		apiKey, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		clientIP := b.clientAddr(r)
+		ban, banned := b.match(clientIP, apiKey)
+		if !banned {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		bannedRequests.Inc(ban.Type)
+		logger.Warn("Banned request rejected | ban_id=%s type=%s client_ip=%s path=%s", ban.ID, ban.Type, clientIP, r.URL.Path)
+
+		message := "Access has been suspended"
+		if ban.ExpiresAt != nil {
+			message += " until " + ban.ExpiresAt.UTC().Format(time.RFC3339)
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(*ban.ExpiresAt).Seconds())+1))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		errResp := types.OpenAIErrorResponse{
+			Error: types.OpenAIError{
+				Message: message + ".",
+				Type:    "permission_error",
+				Code:    "banned",
+			},
+		}
+		errResp.Error.Hint, errResp.Error.DocURL = errdocs.Annotate(errResp.Error.Code, "")
+		if err := types.WriteJSON(w, errResp); err != nil {
+			logger.Error("Failed to write ban response | error=%v client_ip=%s", err, clientIP)
+		}
+	})
+}
+
+// HandleAdminList handles GET /admin/bans
+func (b *BanList) HandleAdminList(w http.ResponseWriter, r *http.Request) {
+	bans := b.List()
+	writeAdminJSON(w, r, map[string]interface{}{
+		"count": len(bans),
+		"bans":  bans,
+	})
+}
+
+// HandleAdminBan handles POST /admin/bans
+// Body: {"type": "ip"|"key", "value": "203.0.113.7", "duration": "24h", "reason": "abuse"};
+// an empty duration bans permanently
+func (b *BanList) HandleAdminBan(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Type     string `json:"type"`
+		Value    string `json:"value"`
+		Duration string `json:"duration"`
+		Reason   string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondAdminError(w, r, http.StatusBadRequest, "invalid_json", "Invalid JSON")
+		return
+	}
+	var duration time.Duration
+	if body.Duration != "" {
+		d, err := time.ParseDuration(body.Duration)
+		if err != nil {
+			respondAdminError(w, r, http.StatusBadRequest, "invalid_duration", "duration must be a Go duration such as 30m or 24h")
+			return
+		}
+		duration = d
+	}
+
+	ban, err := b.Ban(body.Type, body.Value, body.Reason, duration)
+	if err != nil {
+		respondAdminError(w, r, http.StatusBadRequest, "invalid_ban", err.Error())
+		return
+	}
+	logger.Info("Ban added by admin | ban_id=%s type=%s value=%s duration=%s role=%s",
+		ban.ID, ban.Type, ban.Value, banDuration(duration), AdminRoleFromContext(r.Context()))
+	writeAdminJSON(w, r, ban)
+}
+
+// HandleAdminUnban handles DELETE /admin/bans/{id}
+func (b *BanList) HandleAdminUnban(w http.ResponseWriter, r *http.Request) {
+	ban, ok := b.Unban(r.PathValue("id"))
+	if !ok {
+		respondAdminError(w, r, http.StatusNotFound, "not_found", "No ban with this ID is in force")
+		return
+	}
+	logger.Info("Ban lifted by admin | ban_id=%s type=%s value=%s role=%s", ban.ID, ban.Type, ban.Value, AdminRoleFromContext(r.Context()))
+	writeAdminJSON(w, r, map[string]interface{}{
+		"unbanned": true,
+		"ban":      ban,
+	})
+}
+
+// banDuration renders a ban duration for logs
+func banDuration(d time.Duration) string {
+	if d == 0 {
+		return "permanent"
+	}
+	return d.String()
+}
@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBanList_Middleware(t *testing.T) {
+	bans := NewBanList("", nil)
+	if _, err := bans.Ban(BanTypeIP, "203.0.113.7", "abuse", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bans.Ban(BanTypeKey, "sk-abusive-key-123", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	handler := bans.Middleware(createTestHandler())
+
+	tests := []struct {
+		name   string
+		path   string
+		ip     string
+		key    string
+		status int
+	}{
+		{"banned IP", "/v1/models", "203.0.113.7", "", http.StatusForbidden},
+		{"banned key", "/v1/models", "198.51.100.1", "sk-abusive-key-123", http.StatusForbidden},
+		{"other client", "/v1/models", "198.51.100.1", "sk-good-key", http.StatusOK},
+		{"health is never blocked", "/health", "203.0.113.7", "", http.StatusOK},
+		{"admin is never blocked", "/admin/bans", "203.0.113.7", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = tt.ip + ":5000"
+			if tt.key != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.key)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestBanList_ClientAddress(t *testing.T) {
+	bans := NewBanList("", []string{"10.0.0.0/8", "fd00::1"})
+	for _, ip := range []string{"203.0.113.7", "2001:DB8:0:0::1"} {
+		if _, err := bans.Ban(BanTypeIP, ip, "", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := bans.Middleware(createTestHandler())
+
+	tests := []struct {
+		name   string
+		peer   string
+		xff    string
+		status int
+	}{
+		{"spoofed header of a banned client", "203.0.113.7", "198.51.100.1", http.StatusForbidden},
+		{"spoofed header naming a banned client", "198.51.100.1", "203.0.113.7", http.StatusOK},
+		{"banned client behind a trusted proxy", "10.0.0.2", "203.0.113.7", http.StatusForbidden},
+		{"banned client behind two trusted proxies", "10.0.0.2", "203.0.113.7, 10.0.0.3", http.StatusForbidden},
+		{"forged hop before the real client", "10.0.0.2", "203.0.113.7, 198.51.100.1", http.StatusOK},
+		{"direct IPv6 client", "2001:db8::1", "", http.StatusForbidden},
+		{"IPv6 client behind an IPv6 proxy", "fd00::1", "2001:db8::1", http.StatusForbidden},
+		{"other IPv6 client", "2001:db8::2", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+			req.RemoteAddr = net.JoinHostPort(tt.peer, "5000")
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+
+	if list := bans.List(); list[0].Value != "2001:db8::1" && list[1].Value != "2001:db8::1" {
+		t.Errorf("IPv6 ban not stored in canonical form: %+v", list)
+	}
+}
+
+func TestBanList_ValidationAndExpiry(t *testing.T) {
+	bans := NewBanList("", nil)
+	if _, err := bans.Ban(BanTypeIP, "not-an-ip", "", 0); err == nil {
+		t.Error("invalid IP should be rejected")
+	}
+	if _, err := bans.Ban("user", "x", "", 0); err == nil {
+		t.Error("unknown ban type should be rejected")
+	}
+
+	ban, err := bans.Ban(BanTypeIP, "192.0.2.1", "", time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, banned := bans.match("192.0.2.1", ""); banned {
+		t.Error("expired ban must not be enforced")
+	}
+	if _, ok := bans.Unban(ban.ID); ok {
+		t.Error("expired ban should not be reported as lifted")
+	}
+}
+
+func TestBanList_Persistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bans.json")
+	bans := NewBanList(file, nil)
+	keyBan, err := bans.Ban(BanTypeKey, "sk-persisted-key-abc", "leaked", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyBan.Value == "sk-persisted-key-abc" {
+		t.Error("API key must be stored masked")
+	}
+	ipBan, err := bans.Ban(BanTypeIP, "192.0.2.9", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := NewBanList(file, nil)
+	if len(reloaded.List()) != 2 {
+		t.Fatalf("reloaded %d bans, want 2", len(reloaded.List()))
+	}
+	if _, banned := reloaded.match("192.0.2.200", "sk-persisted-key-abc"); !banned {
+		t.Error("key ban must survive a restart")
+	}
+
+	if _, ok := reloaded.Unban(ipBan.ID); !ok {
+		t.Fatal("Unban() found no ban")
+	}
+	if len(NewBanList(file, nil).List()) != 1 {
+		t.Error("unban must be persisted")
+	}
+}
@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cursor2api/metrics"
+)
+
+// Client SDKs recognized by DetectClient; anything else is ClientOther
+const (
+	ClientOpenAIPython = "openai-python"
+	ClientOpenAINode   = "openai-node"
+	ClientLangChain    = "langchain"
+	ClientLiteLLM      = "litellm"
+	ClientCurl         = "curl"
+	ClientGo           = "cursor2api-go" // The client package of this repository
+	ClientOther        = "other"
+	ClientUnknown      = "unknown" // No User-Agent at all
+)
+
+var clientRequests = metrics.NewCounter(
+	"cursor2api_client_requests_total",
+	"API requests by detected client SDK and response status class (2xx, 4xx, 5xx).",
+	"client", "status")
+
+// ClientInfo is the client SDK detected from a request
+type ClientInfo struct {
+	Name    string // One of the Client* constants
+	Version string // SDK version when the User-Agent carries one
+}
+
+// String renders the client as "name version" for logs
+func (c ClientInfo) String() string {
+	if c.Version == "" {
+		return c.Name
+	}
+	return c.Name + " " + c.Version
+}
+
+// clientPatterns map User-Agent substrings (lowercase) to clients, first match wins.
+// Frameworks come first because they often embed an OpenAI SDK User-Agent.
+var clientPatterns = []struct {
+	substring string
+	name      string
+}{
+	{"litellm", ClientLiteLLM},
+	{"langchain", ClientLangChain},
+	{"openai/python", ClientOpenAIPython},
+	{"openai/js", ClientOpenAINode},
+	{"curl/", ClientCurl},
+	{"cursor2api-go/", ClientGo},
+}
+
+// DetectClient identifies the client SDK from the User-Agent and, for the OpenAI
+// SDKs, the X-Stainless-* headers they send even when the User-Agent is overridden
+func DetectClient(r *http.Request) ClientInfo {
+	ua := r.Header.Get("User-Agent")
+	lower := strings.ToLower(ua)
+	for _, p := range clientPatterns {
+		if i := strings.Index(lower, p.substring); i >= 0 {
+			return ClientInfo{Name: p.name, Version: uaVersion(ua[i:])}
+		}
+	}
+
+	switch strings.ToLower(r.Header.Get("X-Stainless-Lang")) {
+	case "python":
+		return ClientInfo{Name: ClientOpenAIPython, Version: r.Header.Get("X-Stainless-Package-Version")}
+	case "js":
+		return ClientInfo{Name: ClientOpenAINode, Version: r.Header.Get("X-Stainless-Package-Version")}
+	}
+
+	if ua == "" {
+		return ClientInfo{Name: ClientUnknown}
+	}
+	return ClientInfo{Name: ClientOther}
+}
+
+// uaVersion extracts the version following a product token: "curl/8.4.0" and
+// "OpenAI/Python 1.51.0" both yield the version; products without one yield ""
+func uaVersion(product string) string {
+	_, rest, ok := strings.Cut(product, "/")
+	if !ok {
+		return ""
+	}
+	// The OpenAI SDKs name the language after the slash and the version after a space
+	if name, version, ok := strings.Cut(rest, " "); ok && (name == "" || name[0] < '0' || name[0] > '9') {
+		rest = version
+	}
+	version, _, _ := strings.Cut(rest, " ")
+	if version == "" || version[0] < '0' || version[0] > '9' {
+		return ""
+	}
+	return version
+}
+
+// withClient returns a context carrying the detected client
+func withClient(ctx context.Context, client ClientInfo) context.Context {
+	return context.WithValue(ctx, clientContextKey, client)
+}
+
+// ClientFromContext returns the client detected by ClientSDK, or ClientUnknown outside it
+func ClientFromContext(ctx context.Context) ClientInfo {
+	client, ok := ctx.Value(clientContextKey).(ClientInfo)
+	if !ok {
+		return ClientInfo{Name: ClientUnknown}
+	}
+	return client
+}
+
+// ClientSDK detects the client SDK of API requests, makes it available through
+// ClientFromContext and counts requests by client and response status class, so
+// a change that breaks one integration shows up as errors of that client only.
+// Health, metrics and admin endpoints are not counted.
+func ClientSDK(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/ready" || r.URL.Path == "/metrics" || isAdminPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := DetectClient(r)
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(withClient(r.Context(), client)))
+		clientRequests.Inc(client.Name, statusClass(sw.status))
+	})
+}
+
+// statusClass renders a status code as "2xx", "4xx", ...; no explicit status means 200
+func statusClass(status int) string {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// statusWriter records the response status while passing flushes and
+// ResponseController calls through to the underlying writer
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDetectClient(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    ClientInfo
+	}{
+		{"openai python", map[string]string{"User-Agent": "OpenAI/Python 1.51.0"}, ClientInfo{ClientOpenAIPython, "1.51.0"}},
+		{"openai node", map[string]string{"User-Agent": "OpenAI/JS 4.67.3"}, ClientInfo{ClientOpenAINode, "4.67.3"}},
+		{"litellm over the openai sdk", map[string]string{"User-Agent": "litellm/1.48.2 OpenAI/Python 1.51.0"}, ClientInfo{ClientLiteLLM, "1.48.2"}},
+		{"langchain", map[string]string{"User-Agent": "langchain-openai/0.2.1"}, ClientInfo{ClientLangChain, "0.2.1"}},
+		{"curl", map[string]string{"User-Agent": "curl/8.4.0"}, ClientInfo{ClientCurl, "8.4.0"}},
+		{"go client", map[string]string{"User-Agent": "cursor2api-go/1.0"}, ClientInfo{ClientGo, "1.0"}},
+		{"stainless headers with a custom user agent", map[string]string{
+			"User-Agent":                  "my-app",
+			"X-Stainless-Lang":            "python",
+			"X-Stainless-Package-Version": "1.40.0",
+		}, ClientInfo{ClientOpenAIPython, "1.40.0"}},
+		{"other", map[string]string{"User-Agent": "Mozilla/5.0"}, ClientInfo{Name: ClientOther}},
+		{"no user agent", nil, ClientInfo{Name: ClientUnknown}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+			req.Header.Del("User-Agent")
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if got := DetectClient(req); got != tt.want {
+				t.Fatalf("DetectClient() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientSDK_KeepsFlusherAndContext(t *testing.T) {
+	var got ClientInfo
+	handler := ClientSDK(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = ClientFromContext(r.Context())
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("wrapped writer should still implement http.Flusher")
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	req.Header.Set("User-Agent", "curl/8.4.0")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got.Name != ClientCurl || rec.Code != http.StatusBadRequest {
+		t.Fatalf("client = %+v, status = %d", got, rec.Code)
+	}
+	if class := statusClass(0); class != "2xx" {
+		t.Fatalf("statusClass(0) = %s, want 2xx", class)
+	}
+}
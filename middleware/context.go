@@ -0,0 +1,54 @@
+package middleware
+
+import "context"
+
+// contextKey is the private type for request context values set by middleware
+type contextKey int
+
+const (
+	apiKeyContextKey contextKey = iota
+	adminRoleContextKey
+	adminTenantContextKey
+	rateLimitChargesContextKey
+	clientContextKey
+	sliContextKey
+)
+
+// withAPIKey returns a context carrying the authenticated API key
+func withAPIKey(ctx context.Context, apiKey string) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey, apiKey)
+}
+
+// APIKeyFromContext returns the API key authenticated for this request, or "" when auth is disabled
+func APIKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(apiKeyContextKey).(string)
+	return key
+}
+
+// withAdminRole returns a context carrying the authenticated admin role
+func withAdminRole(ctx context.Context, role AdminRole) context.Context {
+	return context.WithValue(ctx, adminRoleContextKey, role)
+}
+
+// AdminRoleFromContext returns the role of the authenticated admin token, or 0 outside admin endpoints
+func AdminRoleFromContext(ctx context.Context) AdminRole {
+	role, _ := ctx.Value(adminRoleContextKey).(AdminRole)
+	return role
+}
+
+// withAdminTenant returns a context carrying the tenant an admin request is scoped to
+func withAdminTenant(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, adminTenantContextKey, name)
+}
+
+// AdminTenantFromContext returns the tenant a tenant-scoped admin request is limited to,
+// or "" when it covers all tenants
+func AdminTenantFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(adminTenantContextKey).(string)
+	return name
+}
+
+// MaskAPIKey masks an API key for logs and admin output (first 8 characters only)
+func MaskAPIKey(key string) string {
+	return maskAPIKey(key)
+}
@@ -3,16 +3,12 @@ package middleware
 import "net/http"
 
 // CORS 中间件
+// OPTIONS 预检请求由 router.Preflight 根据路由表应答,这里只负责设置 CORS 头
 func CORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Api-Key, Anthropic-Version, X-Goog-Api-Key")
 
 		next.ServeHTTP(w, r)
 	})
@@ -0,0 +1,367 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"cursor2api/cache"
+	"cursor2api/logger"
+	"cursor2api/metrics"
+)
+
+// DedupeHeader marks responses served from an identical earlier request
+const DedupeHeader = "X-Deduplicated"
+
+var dedupeHits = metrics.NewCounter(
+	"cursor2api_dedupe_hits_total",
+	"Duplicate requests served from an identical earlier request, by whether it was still in flight or had just finished.",
+	"state")
+
+// Dedupe detects identical generation requests (same API key, path and body) fired
+// within a short window, as buggy client retry logic often does, and serves the
+// response of the first one instead of starting another upstream generation.
+// A duplicate of an in-flight request follows its response as it is written,
+// streams included; a successful response is stored in the dedupe cache for the
+// window after it finished, so with a shared backend duplicates reaching another
+// replica are served too. When the client of the first request goes away before
+// its response finished, duplicates that have not received anything yet run the
+// request themselves. A nil *Dedupe disables de-duplication.
+type Dedupe struct {
+	window   time.Duration
+	maxBytes int
+	store    cache.Cache // finished responses
+
+	mu      sync.Mutex
+	entries map[string]*dedupeEntry
+}
+
+// dedupeEntry is the response of the first request of a key, shared with duplicates
+type dedupeEntry struct {
+	mu        sync.Mutex
+	status    int
+	header    http.Header
+	body      []byte
+	done      bool
+	abandoned bool          // the leader's client went away, the response is incomplete
+	detached  bool          // removed from the index, no new duplicates attach
+	followers int           // duplicates following the response
+	changed   chan struct{} // closed and replaced whenever the response progresses
+}
+
+// storedResponse is a finished response kept in the dedupe cache
+type storedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// NewDedupe creates the de-duplicator; returns nil when window is not positive.
+// Responses larger than maxBytes are not kept for requests arriving after them.
+// Finished responses are kept in store, or in memory when store is nil.
+func NewDedupe(window time.Duration, maxBytes int, store cache.Cache) *Dedupe {
+	if window <= 0 {
+		return nil
+	}
+	if store == nil {
+		store = cache.NewMemory(0)
+	}
+	logger.Info("Request de-duplication enabled | window=%v max_bytes=%d", window, maxBytes)
+	return &Dedupe{window: window, maxBytes: maxBytes, store: store, entries: make(map[string]*dedupeEntry)}
+}
+
+// Middleware de-duplicates POST /v1/chat/completions; it must run after APIKeyAuth
+// so requests of different keys never share a response
+func (d *Dedupe) Middleware(next http.Handler) http.Handler {
+	if d == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/chat/completions" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			respondTenantError(w, r, http.StatusBadRequest, "invalid_request_error", "invalid_body", "Failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		key := dedupeKey(APIKeyFromContext(r.Context()), r.URL.Path, body)
+		if d.serveStored(w, r, key) {
+			return
+		}
+		entry, leader := d.attach(key)
+		for !leader {
+			if !d.follow(w, r, entry) {
+				return
+			}
+			// The leader was abandoned before anything was written: run the
+			// request as the new leader, or follow a duplicate that got there first
+			entry, leader = d.attach(key)
+		}
+
+		dw := &dedupeWriter{ResponseWriter: w, entry: entry, d: d, key: key}
+		next.ServeHTTP(dw, r)
+		// A client leaving after the whole response was written does not abandon it
+		d.finish(key, entry, r.Context().Err() != nil && !dw.complete)
+	})
+}
+
+// dedupeKey hashes the API key, path and body of a request
+func dedupeKey(apiKey, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(apiKey))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// attach returns the entry of an identical request, or registers a new entry for
+// which the caller is the leader. An entry detached but not yet removed from the
+// index is a miss: it may no longer hold the beginning of its response.
+func (d *Dedupe) attach(key string) (*dedupeEntry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if entry, ok := d.entries[key]; ok {
+		entry.mu.Lock()
+		detached := entry.detached
+		if !detached {
+			entry.followers++
+		}
+		entry.mu.Unlock()
+		if !detached {
+			return entry, false
+		}
+	}
+	entry := &dedupeEntry{changed: make(chan struct{})}
+	d.entries[key] = entry
+	return entry, true
+}
+
+// detach removes the entry from the index so later requests run normally
+func (d *Dedupe) detach(key string, entry *dedupeEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.entries[key] == entry {
+		delete(d.entries, key)
+	}
+}
+
+// finish marks the leader's response complete and stores a successful response
+// for the window. The entry leaves the index once stored, later duplicates are
+// served from the store. A response cut short by its client is not kept, and
+// its followers are woken to run the request themselves.
+func (d *Dedupe) finish(key string, entry *dedupeEntry, aborted bool) {
+	if aborted {
+		d.detach(key, entry)
+		entry.mu.Lock()
+		entry.abandoned = true
+		entry.notifyLocked()
+		entry.mu.Unlock()
+		return
+	}
+
+	entry.mu.Lock()
+	entry.done = true
+	if entry.status == 0 {
+		entry.status = http.StatusOK
+	}
+	keep := !entry.detached && entry.status < http.StatusMultipleChoices
+	stored := storedResponse{Status: entry.status, Header: entry.header, Body: entry.body}
+	entry.notifyLocked()
+	entry.mu.Unlock()
+
+	if keep {
+		if err := d.storeResponse(key, stored); err != nil {
+			logger.Warn("Failed to store response for duplicates | error=%v", err)
+		}
+	}
+	d.detach(key, entry)
+}
+
+// storeResponse keeps a finished response in the store for the window
+func (d *Dedupe) storeResponse(key string, resp storedResponse) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return d.store.Set(context.Background(), key, data, d.window)
+}
+
+// serveStored writes a stored response of an identical finished request;
+// returns false when there is none (store errors count as misses)
+func (d *Dedupe) serveStored(w http.ResponseWriter, r *http.Request, key string) bool {
+	data, ok, err := d.store.Get(r.Context(), key)
+	if err != nil {
+		logger.Warn("Failed to read stored response for duplicates | error=%v", err)
+	}
+	if !ok {
+		return false
+	}
+	var resp storedResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		logger.Warn("Failed to decode stored response for duplicates | error=%v", err)
+		return false
+	}
+
+	dedupeHits.Inc("finished")
+	logger.Info("Duplicate request served from an identical request | client_ip=%s done=true", getClientIP(r))
+	maps.Copy(w.Header(), resp.Header)
+	w.Header().Set(DedupeHeader, "true")
+	w.WriteHeader(resp.Status)
+	_, _ = w.Write(resp.Body)
+	return true
+}
+
+// Close releases the store of finished responses
+func (d *Dedupe) Close() error {
+	if d == nil {
+		return nil
+	}
+	return d.store.Close()
+}
+
+// follow writes the leader's response to a duplicate request, waiting for the
+// parts not written yet. Returns true when the leader was abandoned before
+// anything was written, so the duplicate should run the request itself; a
+// response already partly written ends where the leader's did.
+func (d *Dedupe) follow(w http.ResponseWriter, r *http.Request, entry *dedupeEntry) bool {
+	defer func() {
+		entry.mu.Lock()
+		entry.followers--
+		entry.mu.Unlock()
+	}()
+
+	flusher, _ := w.(http.Flusher)
+	offset := 0
+	headerWritten := false
+	for {
+		entry.mu.Lock()
+		if entry.abandoned {
+			entry.mu.Unlock()
+			if !headerWritten {
+				logger.Info("Identical request abandoned by its client, running the duplicate | client_ip=%s", getClientIP(r))
+			}
+			return !headerWritten
+		}
+		status, done, changed := entry.status, entry.done, entry.changed
+		if !headerWritten && status != 0 {
+			if done {
+				dedupeHits.Inc("finished")
+			} else {
+				dedupeHits.Inc("inflight")
+			}
+			maps.Copy(w.Header(), entry.header)
+			w.Header().Set(DedupeHeader, "true")
+		}
+		chunk := entry.body[offset:]
+		entry.mu.Unlock()
+
+		if !headerWritten && status != 0 {
+			logger.Info("Duplicate request served from an identical request | client_ip=%s done=%v", getClientIP(r), done)
+			w.WriteHeader(status)
+			headerWritten = true
+		}
+		if len(chunk) > 0 {
+			if _, err := w.Write(chunk); err != nil {
+				return false
+			}
+			offset += len(chunk)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if done {
+			return false
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return false
+		}
+	}
+}
+
+// notifyLocked wakes the followers waiting for progress (caller holds entry.mu)
+func (e *dedupeEntry) notifyLocked() {
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+// dedupeStreamEnd is the last event of a complete chat completion stream
+var dedupeStreamEnd = []byte("data: [DONE]\n\n")
+
+// dedupeWriter records the leader's response for its duplicates while writing it
+type dedupeWriter struct {
+	http.ResponseWriter
+	entry    *dedupeEntry
+	d        *Dedupe
+	key      string
+	complete bool // the last write ended a successful response: [DONE] of a stream, or any other body
+}
+
+func (w *dedupeWriter) WriteHeader(code int) {
+	w.entry.mu.Lock()
+	if w.entry.status == 0 {
+		w.entry.status = code
+		w.entry.header = w.Header().Clone()
+		w.entry.notifyLocked()
+	}
+	w.entry.mu.Unlock()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *dedupeWriter) Write(b []byte) (int, error) {
+	w.entry.mu.Lock()
+	if w.entry.status == 0 {
+		w.entry.status = http.StatusOK
+		w.entry.header = w.Header().Clone()
+	}
+	if !w.entry.detached || w.entry.followers > 0 {
+		w.entry.body = append(w.entry.body, b...)
+	}
+	detach := !w.entry.detached && w.d.maxBytes > 0 && len(w.entry.body) > w.d.maxBytes
+	if detach {
+		// Too large to keep: duplicates already following still get the whole response
+		w.entry.detached = true
+	}
+	if w.entry.detached && w.entry.followers == 0 {
+		// Nobody follows a detached response, stop recording it
+		w.entry.body = nil
+	}
+	success := w.entry.status < http.StatusMultipleChoices
+	w.entry.notifyLocked()
+	w.entry.mu.Unlock()
+
+	if detach {
+		w.d.detach(w.key, w.entry)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	eventStream := strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+	w.complete = success && (!eventStream || bytes.HasSuffix(b, dedupeStreamEnd))
+	return n, err
+}
+
+func (w *dedupeWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *dedupeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,318 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cursor2api/cache"
+)
+
+// writeSignal records a response and signals its first write
+type writeSignal struct {
+	*httptest.ResponseRecorder
+	once    sync.Once
+	written chan struct{}
+}
+
+func (w *writeSignal) Write(b []byte) (int, error) {
+	n, err := w.ResponseRecorder.Write(b)
+	w.once.Do(func() { close(w.written) })
+	return n, err
+}
+
+func chatRequest(body string) *http.Request {
+	return httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+}
+
+func TestDedupe_FollowsInFlightRequest(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	handler := NewDedupe(time.Minute, 0, nil).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write([]byte("data: " + string(body) + "\n\n"))
+	}))
+
+	leader := &writeSignal{ResponseRecorder: httptest.NewRecorder(), written: make(chan struct{})}
+	leaderDone := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(leader, chatRequest("same"))
+		close(leaderDone)
+	}()
+	<-leader.written
+
+	follower := &writeSignal{ResponseRecorder: httptest.NewRecorder(), written: make(chan struct{})}
+	followerDone := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(follower, chatRequest("same"))
+		close(followerDone)
+	}()
+	<-follower.written
+	close(release)
+	<-leaderDone
+	<-followerDone
+
+	want := "data: first\n\ndata: same\n\n"
+	if calls.Load() != 1 {
+		t.Errorf("handler calls = %d, want 1", calls.Load())
+	}
+	if got := follower.Body.String(); got != want {
+		t.Errorf("duplicate body = %q, want %q", got, want)
+	}
+	if follower.Header().Get(DedupeHeader) != "true" || follower.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("duplicate headers = %v", follower.Header())
+	}
+	if leader.Header().Get(DedupeHeader) != "" {
+		t.Error("original response must not be marked as deduplicated")
+	}
+}
+
+func TestDedupe_LeaderCancelledMidStream(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{})
+	handler := NewDedupe(time.Minute, 0, nil).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		if calls.Add(1) == 1 {
+			// The first client gives up while waiting for the first token
+			close(started)
+			<-r.Context().Done()
+			return
+		}
+		_, _ = w.Write([]byte("data: answer\n\ndata: [DONE]\n\n"))
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leaderDone := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), chatRequest("same").WithContext(ctx))
+		close(leaderDone)
+	}()
+	<-started
+
+	follower := httptest.NewRecorder()
+	followerDone := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(follower, chatRequest("same"))
+		close(followerDone)
+	}()
+	// Give the duplicate time to attach to the in-flight request
+	time.Sleep(50 * time.Millisecond)
+	if calls.Load() != 1 {
+		t.Fatalf("handler calls = %d before the cancellation, want 1", calls.Load())
+	}
+	cancel()
+	<-leaderDone
+	<-followerDone
+
+	if calls.Load() != 2 {
+		t.Errorf("handler calls = %d, want 2", calls.Load())
+	}
+	if got := follower.Body.String(); got != "data: answer\n\ndata: [DONE]\n\n" {
+		t.Errorf("duplicate body = %q, want the complete response", got)
+	}
+	if follower.Header().Get(DedupeHeader) != "" {
+		t.Error("a duplicate that ran the request itself must not be marked as deduplicated")
+	}
+}
+
+func TestDedupe_LeaderCancelledAfterResponse(t *testing.T) {
+	const response = "data: answer\n\ndata: [DONE]\n\n"
+	var calls atomic.Int32
+	started, release, written := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	handler := NewDedupe(time.Minute, 0, nil).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		if calls.Add(1) > 1 {
+			_, _ = w.Write([]byte(response))
+			return
+		}
+		_, _ = w.Write([]byte("data: answer\n\n"))
+		close(started)
+		<-release
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+		close(written)
+		// The client leaves after the whole response, before the handler returns
+		<-r.Context().Done()
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leaderDone := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), chatRequest("same").WithContext(ctx))
+		close(leaderDone)
+	}()
+	<-started
+
+	follower := httptest.NewRecorder()
+	followerDone := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(follower, chatRequest("same"))
+		close(followerDone)
+	}()
+	// Give the duplicate time to attach to the in-flight request
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	<-written
+	cancel()
+	<-leaderDone
+	<-followerDone
+
+	if got := follower.Body.String(); got != response {
+		t.Errorf("duplicate body = %q, want the complete response", got)
+	}
+	later := httptest.NewRecorder()
+	handler.ServeHTTP(later, chatRequest("same"))
+	if later.Body.String() != response || later.Header().Get(DedupeHeader) != "true" {
+		t.Errorf("later duplicate = %q, want the stored response", later.Body.String())
+	}
+	if calls.Load() != 1 {
+		t.Errorf("handler calls = %d, want 1", calls.Load())
+	}
+}
+
+func TestDedupe_FinishedResponses(t *testing.T) {
+	var calls atomic.Int32
+	handler := NewDedupe(time.Minute, 16, nil).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		switch string(body) {
+		case "fail":
+			w.WriteHeader(http.StatusBadGateway)
+		case "large":
+			_, _ = w.Write([]byte(strings.Repeat("x", 32)))
+		default:
+			_, _ = w.Write(body)
+		}
+	}))
+
+	tests := []struct {
+		name  string
+		body  string
+		calls int32 // handler calls for two identical requests
+	}{
+		{"success is served again", "ok", 1},
+		{"errors are not kept", "fail", 2},
+		{"oversized responses are not kept", "large", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls.Store(0)
+			first, second := httptest.NewRecorder(), httptest.NewRecorder()
+			handler.ServeHTTP(first, chatRequest(tt.body))
+			handler.ServeHTTP(second, chatRequest(tt.body))
+			if calls.Load() != tt.calls {
+				t.Errorf("handler calls = %d, want %d", calls.Load(), tt.calls)
+			}
+			if second.Code != first.Code || second.Body.String() != first.Body.String() {
+				t.Errorf("second response = %d %q, want %d %q", second.Code, second.Body, first.Code, first.Body)
+			}
+		})
+	}
+}
+
+func TestDedupe_OversizedResponseNotRecorded(t *testing.T) {
+	var recorded int
+	handler := NewDedupe(time.Minute, 16, nil).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for range 100 {
+			_, _ = w.Write([]byte("data: x\n"))
+		}
+		entry := w.(*dedupeWriter).entry
+		entry.mu.Lock()
+		recorded = len(entry.body)
+		entry.mu.Unlock()
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, chatRequest("large"))
+	if rec.Body.Len() != 800 {
+		t.Errorf("response = %d bytes, want 800", rec.Body.Len())
+	}
+	if recorded != 0 {
+		t.Errorf("%d bytes of a response over the limit without duplicates were recorded", recorded)
+	}
+}
+
+func TestDedupe_DetachedEntryIsAMiss(t *testing.T) {
+	d := NewDedupe(time.Minute, 16, nil)
+	entry, leader := d.attach("key")
+	if !leader {
+		t.Fatal("the first request must lead")
+	}
+	// The leader's response went over the limit, its entry is not removed from the index yet
+	entry.mu.Lock()
+	entry.detached = true
+	entry.mu.Unlock()
+
+	next, leader := d.attach("key")
+	if !leader || next == entry {
+		t.Fatal("a duplicate attached to a detached response")
+	}
+	entry.mu.Lock()
+	followers := entry.followers
+	entry.mu.Unlock()
+	if followers != 0 {
+		t.Errorf("detached entry has %d followers", followers)
+	}
+
+	// The late removal of the detached entry leaves the new one in place
+	d.detach("key", entry)
+	if third, leader := d.attach("key"); leader || third != next {
+		t.Error("the new leader's entry was removed from the index")
+	}
+}
+
+func TestDedupe_SharedStore(t *testing.T) {
+	// Two replicas sharing the store of finished responses
+	store := cache.NewMemory(0)
+	var calls atomic.Int32
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"1"}`))
+	})
+	first := NewDedupe(time.Minute, 0, store).Middleware(next)
+	second := NewDedupe(time.Minute, 0, store).Middleware(next)
+
+	original, duplicate := httptest.NewRecorder(), httptest.NewRecorder()
+	first.ServeHTTP(original, chatRequest("same"))
+	second.ServeHTTP(duplicate, chatRequest("same"))
+	if calls.Load() != 1 {
+		t.Errorf("handler calls = %d, want 1", calls.Load())
+	}
+	if duplicate.Body.String() != `{"id":"1"}` || duplicate.Header().Get(DedupeHeader) != "true" || duplicate.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("duplicate = %d %v %q", duplicate.Code, duplicate.Header(), duplicate.Body)
+	}
+}
+
+func TestDedupe_KeyedByAPIKeyAndBody(t *testing.T) {
+	var calls atomic.Int32
+	handler := NewDedupe(time.Minute, 0, nil).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), chatRequest("a"))
+	handler.ServeHTTP(httptest.NewRecorder(), chatRequest("b"))
+	other := chatRequest("a")
+	handler.ServeHTTP(httptest.NewRecorder(), other.WithContext(withAPIKey(other.Context(), "sk-other")))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+	if calls.Load() != 5 {
+		t.Errorf("handler calls = %d, want 5", calls.Load())
+	}
+
+	var disabled *Dedupe
+	if NewDedupe(0, 0, nil) != nil || disabled.Middleware(http.NotFoundHandler()) == nil {
+		t.Error("a zero window must disable de-duplication")
+	}
+}
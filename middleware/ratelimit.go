@@ -2,97 +2,207 @@ package middleware
 
 import (
 	"fmt"
+	"hash/fnv"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"cursor2api/clock"
+	"cursor2api/errdocs"
 	"cursor2api/logger"
 	"cursor2api/types"
 	"golang.org/x/time/rate"
 )
 
+// userIdentifierSep joins the key (or IP) identifier and the end-user ID of per-user limiters
+const userIdentifierSep = "|user="
+
+// limiterShardCount is the number of independent shards the limiter map is split into.
+// Must be a power of two so the shard index can be computed with a mask.
+const limiterShardCount = 64
+
 // limiterEntry wraps a rate limiter with its last access time
 type limiterEntry struct {
 	limiter    *rate.Limiter
-	lastAccess time.Time
+	lastAccess atomic.Int64 // Unix nanoseconds, updated without holding the shard lock
+}
+
+// limiterShard is one partition of the limiter map with its own lock
+type limiterShard struct {
+	mu       sync.RWMutex
+	limiters map[string]*limiterEntry
 }
 
 // RateLimiter manages rate limiting for requests
 type RateLimiter struct {
-	limiters        map[string]*limiterEntry
-	mu              sync.RWMutex
+	shards          [limiterShardCount]*limiterShard
 	requestsPerSec  rate.Limit
 	burst           int
 	strategy        string
 	enabled         bool
 	cleanupInterval time.Duration
-	lastCleanup     time.Time
+	clock           clock.Clock // Time of the token buckets and limiter eviction
+
+	// Effective limit after schedule profiles and adaptive tightening (see UsePolicy);
+	// equals requestsPerSec/burst while no policy is active
+	limitMu    sync.RWMutex
+	limit      rate.Limit
+	limitBurst int
+	profile    string
+	scale      float64
+	policy     *LimitPolicy
+	errorRate  func() (float64, int)
+
+	stopOnce sync.Once
+	stopChan chan struct{}
 }
 
 // NewRateLimiter creates a new rate limiter instance
+// A background goroutine evicts inactive limiters every cleanupInterval; call Stop to release it.
 func NewRateLimiter(requestsPerSec float64, burst int, strategy string, enabled bool, cleanupInterval time.Duration) *RateLimiter {
 	rl := &RateLimiter{
-		limiters:        make(map[string]*limiterEntry),
 		requestsPerSec:  rate.Limit(requestsPerSec),
 		burst:           burst,
 		strategy:        strategy,
 		enabled:         enabled,
 		cleanupInterval: cleanupInterval,
-		lastCleanup:     time.Now(),
+		clock:           clock.System,
+		limit:           rate.Limit(requestsPerSec),
+		limitBurst:      burst,
+		profile:         "default",
+		scale:           1,
+		stopChan:        make(chan struct{}),
 	}
-	
-	logger.Info("Rate limiter initialized | requests_per_sec=%.2f burst=%d strategy=%s enabled=%v cleanup_interval=%v",
-		requestsPerSec, burst, strategy, enabled, cleanupInterval)
-	
+	for i := range rl.shards {
+		rl.shards[i] = &limiterShard{limiters: make(map[string]*limiterEntry)}
+	}
+
+	if enabled && cleanupInterval > 0 {
+		go rl.cleanupLoop()
+	}
+
+	logger.Info("Rate limiter initialized | requests_per_sec=%.2f burst=%d strategy=%s enabled=%v cleanup_interval=%v shards=%d",
+		requestsPerSec, burst, strategy, enabled, cleanupInterval, limiterShardCount)
+
 	return rl
 }
 
+// Stop terminates the background cleanup goroutine; a nil limiter is a no-op
+func (rl *RateLimiter) Stop() {
+	if rl == nil {
+		return
+	}
+	rl.stopOnce.Do(func() {
+		close(rl.stopChan)
+	})
+}
+
+// shardFor returns the shard responsible for the given identifier
+func (rl *RateLimiter) shardFor(identifier string) *limiterShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(identifier))
+	return rl.shards[h.Sum32()&(limiterShardCount-1)]
+}
+
 // GetLimiter retrieves or creates a rate limiter for the given identifier
 func (rl *RateLimiter) GetLimiter(identifier string) *rate.Limiter {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+	shard := rl.shardFor(identifier)
+	now := rl.clock.Now().UnixNano()
 
-	// Perform periodic cleanup to prevent memory leaks
-	if time.Since(rl.lastCleanup) > rl.cleanupInterval {
-		rl.cleanup()
-		rl.lastCleanup = time.Now()
+	// Fast path: existing limiter only needs the read lock
+	shard.mu.RLock()
+	entry, exists := shard.limiters[identifier]
+	shard.mu.RUnlock()
+	if exists {
+		entry.lastAccess.Store(now)
+		return entry.limiter
 	}
 
-	entry, exists := rl.limiters[identifier]
-	if !exists {
-		entry = &limiterEntry{
-			limiter:    rate.NewLimiter(rl.requestsPerSec, rl.burst),
-			lastAccess: time.Now(),
-		}
-		rl.limiters[identifier] = entry
-	} else {
-		// Update last access time
-		entry.lastAccess = time.Now()
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	// Re-check after acquiring the write lock, another request may have created it
+	if entry, exists = shard.limiters[identifier]; !exists {
+		limit, burst := rl.currentLimit()
+		entry = &limiterEntry{limiter: rate.NewLimiter(limit, burst)}
+		shard.limiters[identifier] = entry
 	}
+	entry.lastAccess.Store(now)
 
 	return entry.limiter
 }
 
+// currentLimit returns the effective per-identifier limit and burst
+func (rl *RateLimiter) currentLimit() (rate.Limit, int) {
+	rl.limitMu.RLock()
+	defer rl.limitMu.RUnlock()
+	return rl.limit, rl.limitBurst
+}
+
+// lookup returns the stored entry for identifier without creating or touching it
+func (rl *RateLimiter) lookup(identifier string) (*limiterEntry, bool) {
+	shard := rl.shardFor(identifier)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	entry, exists := shard.limiters[identifier]
+	return entry, exists
+}
+
+// size returns the total number of tracked identifiers across all shards
+func (rl *RateLimiter) size() int {
+	total := 0
+	for _, shard := range rl.shards {
+		shard.mu.RLock()
+		total += len(shard.limiters)
+		shard.mu.RUnlock()
+	}
+	return total
+}
+
+// cleanupLoop periodically evicts inactive limiters until Stop is called
+func (rl *RateLimiter) cleanupLoop() {
+	ticker := time.NewTicker(rl.cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rl.stopChan:
+			return
+		case <-ticker.C:
+			if removed := rl.cleanup(); removed > 0 {
+				logger.Debug("Rate limiter cleanup completed | removed=%d remaining=%d", removed, rl.size())
+			}
+		}
+	}
+}
+
 // cleanup removes inactive limiters to prevent memory leaks
-// This method assumes the caller holds the write lock
-// Limiters that haven't been accessed for longer than cleanupInterval are removed
-func (rl *RateLimiter) cleanup() {
-	now := time.Now()
-	ttl := rl.cleanupInterval
-	
-	// Remove entries that haven't been accessed within the TTL window
-	for key, entry := range rl.limiters {
-		if now.Sub(entry.lastAccess) > ttl {
-			delete(rl.limiters, key)
+// Limiters that haven't been accessed for longer than cleanupInterval are removed.
+// Shards are locked one at a time so request handling is never blocked on the whole map.
+func (rl *RateLimiter) cleanup() int {
+	cutoff := rl.clock.Now().Add(-rl.cleanupInterval).UnixNano()
+	removed := 0
+
+	for _, shard := range rl.shards {
+		shard.mu.Lock()
+		for key, entry := range shard.limiters {
+			if entry.lastAccess.Load() < cutoff {
+				delete(shard.limiters, key)
+				removed++
+			}
 		}
+		shard.mu.Unlock()
 	}
+
+	return removed
 }
 
 // Allow checks if a request should be allowed for the given identifier
 func (rl *RateLimiter) Allow(identifier string) bool {
 	limiter := rl.GetLimiter(identifier)
-	return limiter.Allow()
+	return limiter.AllowN(rl.clock.Now(), 1)
 }
 
 // extractIdentifier extracts the rate limit identifier from the request
@@ -143,6 +253,9 @@ func (rl *RateLimiter) extractIdentifier(r *http.Request) string {
 // Middleware returns the rate limiting middleware handler
 func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Collect consumed tokens (also the per-user limiter's) for RefundRateLimit
+		r = r.WithContext(withRateLimitCharges(r.Context()))
+
 		// Skip rate limiting if disabled
 		if !rl.enabled {
 			next.ServeHTTP(w, r)
@@ -159,22 +272,51 @@ func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
 		identifier := rl.extractIdentifier(r)
 
 		// Check rate limit
-		if !rl.Allow(identifier) {
-			rl.respondRateLimitExceeded(w, r, identifier)
+		limiter := rl.GetLimiter(identifier)
+		if !limiter.AllowN(rl.clock.Now(), 1) {
+			rl.respondRateLimitExceeded(w, r, identifier, limiter)
 			return
 		}
+		chargeRateLimit(r.Context(), limiter, "key", rl.clock)
 
 		// Request allowed, proceed to next handler
 		next.ServeHTTP(w, r)
 	})
 }
 
+// AllowUser applies the per-user limit to a request carrying the OpenAI `user` field.
+// End users are limited beneath the API key (or client IP, depending on the strategy),
+// so one end user of a downstream app cannot exhaust the whole key's quota. It writes
+// the 429 response and returns false when the limit is exceeded; a nil limiter or an
+// empty user always allows.
+func (rl *RateLimiter) AllowUser(w http.ResponseWriter, r *http.Request, user string) bool {
+	if rl == nil || !rl.enabled || user == "" {
+		return true
+	}
+	identifier := rl.extractIdentifier(r) + userIdentifierSep + user
+	limiter := rl.GetLimiter(identifier)
+	if !limiter.AllowN(rl.clock.Now(), 1) {
+		rl.respondRateLimitExceeded(w, r, identifier, limiter)
+		return false
+	}
+	chargeRateLimit(r.Context(), limiter, "user", rl.clock)
+	return true
+}
+
 // respondRateLimitExceeded sends OpenAI-compatible 429 error response
-func (rl *RateLimiter) respondRateLimitExceeded(w http.ResponseWriter, r *http.Request, identifier string) {
+func (rl *RateLimiter) respondRateLimitExceeded(w http.ResponseWriter, r *http.Request, identifier string, limiter *rate.Limiter) {
+	now := rl.clock.Now()
+	// Whole tokens left in the bucket, 0 for the request that was just rejected
+	remaining := int(limiter.TokensAt(now))
+	if remaining < 0 {
+		remaining = 0
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Retry-After", "60") // Suggest retry after 60 seconds
-	w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%.0f", rl.requestsPerSec))
-	w.Header().Set("X-RateLimit-Reset", fmt.Sprintf("%d", time.Now().Add(time.Minute).Unix()))
+	w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%.0f", limiter.Limit()))
+	w.Header().Set("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
+	w.Header().Set("X-RateLimit-Reset", fmt.Sprintf("%d", now.Add(time.Minute).Unix()))
 	w.WriteHeader(http.StatusTooManyRequests)
 
 	errResp := types.OpenAIErrorResponse{
@@ -185,6 +327,8 @@ func (rl *RateLimiter) respondRateLimitExceeded(w http.ResponseWriter, r *http.R
 		},
 	}
 
+	errResp.Error.Hint, errResp.Error.DocURL = errdocs.Annotate(errResp.Error.Code, "")
+
 	logger.Warn("Rate limit exceeded | identifier=%s client_ip=%s path=%s method=%s strategy=%s",
 		maskIdentifier(identifier), getClientIP(r), r.URL.Path, r.Method, rl.strategy)
 
@@ -194,7 +338,11 @@ func (rl *RateLimiter) respondRateLimitExceeded(w http.ResponseWriter, r *http.R
 }
 
 // maskIdentifier masks the identifier for logging (shows only first 8 characters)
+// Per-user identifiers keep the end-user ID readable: "sk-abcde...|user=alice"
 func maskIdentifier(identifier string) string {
+	if parent, user, ok := strings.Cut(identifier, userIdentifierSep); ok {
+		return maskIdentifier(parent) + userIdentifierSep + user
+	}
 	if len(identifier) == 0 {
 		return ""
 	}
@@ -202,4 +350,4 @@ func maskIdentifier(identifier string) string {
 		return identifier
 	}
 	return identifier[:8] + "..."
-}
\ No newline at end of file
+}
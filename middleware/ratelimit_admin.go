@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"time"
+
+	"cursor2api/logger"
+	"cursor2api/types"
+)
+
+// LimiterInfo is the admin view of one rate limited identifier
+type LimiterInfo struct {
+	ID         string    `json:"id"`         // Stable handle for DELETE /admin/ratelimit/{id}
+	Identifier string    `json:"identifier"` // Masked IP or API key
+	Remaining  float64   `json:"remaining"`  // Tokens currently available
+	Burst      int       `json:"burst"`
+	LastAccess time.Time `json:"last_access"`
+}
+
+// limiterID derives the handle of an identifier without revealing it
+func limiterID(identifier string) string {
+	sum := sha256.Sum256([]byte(identifier))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Snapshot lists the tracked identifiers, most recently active first
+func (rl *RateLimiter) Snapshot() []LimiterInfo {
+	list := make([]LimiterInfo, 0)
+	now := rl.clock.Now()
+	for _, shard := range rl.shards {
+		shard.mu.RLock()
+		for identifier, entry := range shard.limiters {
+			list = append(list, LimiterInfo{
+				ID:         limiterID(identifier),
+				Identifier: maskIdentifier(identifier),
+				Remaining:  max(entry.limiter.TokensAt(now), 0),
+				Burst:      entry.limiter.Burst(),
+				LastAccess: time.Unix(0, entry.lastAccess.Load()),
+			})
+		}
+		shard.mu.RUnlock()
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastAccess.After(list[j].LastAccess) })
+	return list
+}
+
+// Reset forgets an identifier, given as its ID or in full, so its next request starts
+// with a full bucket. Returns the masked identifier and whether it was tracked.
+func (rl *RateLimiter) Reset(idOrIdentifier string) (string, bool) {
+	if _, ok := rl.lookup(idOrIdentifier); ok {
+		shard := rl.shardFor(idOrIdentifier)
+		shard.mu.Lock()
+		delete(shard.limiters, idOrIdentifier)
+		shard.mu.Unlock()
+		return maskIdentifier(idOrIdentifier), true
+	}
+	for _, shard := range rl.shards {
+		shard.mu.Lock()
+		for identifier := range shard.limiters {
+			if limiterID(identifier) == idOrIdentifier {
+				delete(shard.limiters, identifier)
+				shard.mu.Unlock()
+				return maskIdentifier(identifier), true
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return "", false
+}
+
+// HandleAdminList handles GET /admin/ratelimit
+func (rl *RateLimiter) HandleAdminList(w http.ResponseWriter, r *http.Request) {
+	limiters := rl.Snapshot()
+	writeAdminJSON(w, r, map[string]interface{}{
+		"enabled":          rl.enabled,
+		"strategy":         rl.strategy,
+		"requests_per_sec": float64(rl.requestsPerSec),
+		"burst":            rl.burst,
+		"effective":        rl.Effective(),
+		"count":            len(limiters),
+		"limiters":         limiters,
+	})
+}
+
+// Effective returns the limits in force after the scheduled profile and adaptive
+// scaling of the rate limit policy
+func (rl *RateLimiter) Effective() map[string]interface{} {
+	rl.limitMu.RLock()
+	defer rl.limitMu.RUnlock()
+	return map[string]interface{}{
+		"profile":          rl.profile,
+		"adaptive_scale":   rl.scale,
+		"requests_per_sec": float64(rl.limit),
+		"burst":            rl.limitBurst,
+	}
+}
+
+// HandleAdminReset handles DELETE /admin/ratelimit/{id}
+// {id} is the ID from the listing or the full identifier (e.g. a client IP)
+func (rl *RateLimiter) HandleAdminReset(w http.ResponseWriter, r *http.Request) {
+	masked, ok := rl.Reset(r.PathValue("id"))
+	if !ok {
+		respondAdminError(w, r, http.StatusNotFound, "not_found", "No rate limiter is tracked for this identifier")
+		return
+	}
+	logger.Info("Rate limiter reset by admin | identifier=%s role=%s", masked, AdminRoleFromContext(r.Context()))
+	writeAdminJSON(w, r, map[string]interface{}{
+		"reset":      true,
+		"identifier": masked,
+	})
+}
+
+// writeAdminJSON writes a 200 JSON response for admin endpoints served by middleware
+func writeAdminJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := types.WriteJSON(w, v); err != nil {
+		logger.Error("Failed to write admin response | error=%v client_ip=%s", err, getClientIP(r))
+	}
+}
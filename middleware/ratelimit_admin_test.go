@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_SnapshotAndReset(t *testing.T) {
+	rl := NewRateLimiter(1, 2, "api_key", true, 0)
+	defer rl.Stop()
+
+	rl.Allow("sk-wedged-customer-key")
+	rl.Allow("sk-wedged-customer-key")
+	if rl.Allow("sk-wedged-customer-key") {
+		t.Fatal("third request should exceed the burst")
+	}
+	rl.Allow("10.0.0.1")
+
+	list := rl.Snapshot()
+	if len(list) != 2 {
+		t.Fatalf("Snapshot() returned %d entries, want 2", len(list))
+	}
+	if list[0].Identifier != "10.0.0.1" {
+		t.Errorf("most recent identifier = %q, want 10.0.0.1 first", list[0].Identifier)
+	}
+	wedged := list[1]
+	if wedged.Identifier != "sk-wedge..." || wedged.Remaining >= 1 || wedged.Burst != 2 {
+		t.Errorf("unexpected entry: %+v", wedged)
+	}
+
+	// Reset by ID: the identifier starts over with a full bucket
+	if masked, ok := rl.Reset(wedged.ID); !ok || masked != "sk-wedge..." {
+		t.Fatalf("Reset(id) = %q, %v", masked, ok)
+	}
+	if !rl.Allow("sk-wedged-customer-key") {
+		t.Error("request after reset should be allowed")
+	}
+
+	// Reset by the full identifier
+	if _, ok := rl.Reset("10.0.0.1"); !ok {
+		t.Error("Reset by identifier failed")
+	}
+	if _, ok := rl.Reset("unknown"); ok {
+		t.Error("Reset of an untracked identifier should report false")
+	}
+}
+
+func TestRateLimiter_AdminHandlers(t *testing.T) {
+	rl := NewRateLimiter(1, 1, "ip", true, time.Hour)
+	defer rl.Stop()
+	rl.Allow("192.168.1.50")
+
+	rec := httptest.NewRecorder()
+	rl.HandleAdminList(rec, httptest.NewRequest(http.MethodGet, "/admin/ratelimit", nil))
+	var body struct {
+		Count    int           `json:"count"`
+		Limiters []LimiterInfo `json:"limiters"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Count != 1 {
+		t.Fatalf("list response: %v, count %d", err, body.Count)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/admin/ratelimit/"+body.Limiters[0].ID, nil)
+	req.SetPathValue("id", body.Limiters[0].ID)
+	rec = httptest.NewRecorder()
+	rl.HandleAdminReset(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reset status = %d, want 200", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	rl.HandleAdminReset(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("second reset status = %d, want 404", rec.Code)
+	}
+}
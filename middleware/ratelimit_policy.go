@@ -0,0 +1,237 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"cursor2api/logger"
+	"cursor2api/metrics"
+	"golang.org/x/time/rate"
+)
+
+var effectiveRateLimit = metrics.NewGauge(
+	"cursor2api_rate_limit_effective_rps",
+	"Requests per second currently allowed per identifier after schedule profiles and adaptive tightening.")
+
+// weekdays maps the day names accepted in limit profiles
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// LimitProfile replaces the default rate limit on the given weekdays between Start and End
+type LimitProfile struct {
+	Name           string   `json:"name"`
+	Days           []string `json:"days,omitempty"` // mon..sun; empty = every day
+	Start          string   `json:"start"`          // HH:MM, inclusive
+	End            string   `json:"end"`            // HH:MM, exclusive; before Start wraps past midnight
+	RequestsPerSec float64  `json:"requests_per_sec"`
+	Burst          int      `json:"burst"`
+
+	days       map[time.Weekday]bool
+	start, end int // Minutes since midnight
+}
+
+// AdaptiveLimit tightens the active limit while the upstream error rate is high and
+// relaxes it again, one step per evaluation, once the error rate has halved
+type AdaptiveLimit struct {
+	ErrorRateThreshold float64 `json:"error_rate_threshold"` // Upstream error rate (0-1) over the last minute that tightens limits
+	MinRequests        int     `json:"min_requests"`         // Upstream requests in the last minute needed to judge the rate
+	Factor             float64 `json:"factor"`               // Multiplier applied per evaluation while errors stay high (default 0.5)
+	MinFactor          float64 `json:"min_factor"`           // Lowest combined multiplier (default 0.1)
+}
+
+// LimitPolicy is the declarative rate limit configuration: time-of-day profiles and
+// an optional adaptive mode driven by the upstream error rate
+type LimitPolicy struct {
+	Timezone string         `json:"timezone,omitempty"` // IANA name for profile times (default UTC)
+	Profiles []LimitProfile `json:"profiles,omitempty"` // First matching profile wins
+	Adaptive *AdaptiveLimit `json:"adaptive,omitempty"`
+
+	location *time.Location
+}
+
+// LoadLimitPolicy reads and validates the policy file; returns nil when path is empty
+func LoadLimitPolicy(path string) (*LimitPolicy, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policy LimitPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse rate limit policy: %w", err)
+	}
+
+	policy.location = time.UTC
+	if policy.Timezone != "" {
+		if policy.location, err = time.LoadLocation(policy.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", policy.Timezone, err)
+		}
+	}
+	for i := range policy.Profiles {
+		if err := policy.Profiles[i].parse(); err != nil {
+			return nil, fmt.Errorf("profile %q: %w", policy.Profiles[i].Name, err)
+		}
+	}
+	if a := policy.Adaptive; a != nil {
+		if a.ErrorRateThreshold <= 0 || a.ErrorRateThreshold > 1 {
+			return nil, errors.New("adaptive error_rate_threshold must be in (0, 1]")
+		}
+		if a.Factor == 0 {
+			a.Factor = 0.5
+		}
+		if a.MinFactor == 0 {
+			a.MinFactor = 0.1
+		}
+		if a.Factor <= 0 || a.Factor >= 1 || a.MinFactor <= 0 || a.MinFactor > 1 {
+			return nil, errors.New("adaptive factor must be in (0, 1) and min_factor in (0, 1]")
+		}
+	}
+	return &policy, nil
+}
+
+// parse validates the profile and precomputes its days and times
+func (p *LimitProfile) parse() error {
+	if p.RequestsPerSec <= 0 || p.Burst <= 0 {
+		return errors.New("requests_per_sec and burst must be positive")
+	}
+	var err error
+	if p.start, err = parseClock(p.Start); err != nil {
+		return err
+	}
+	if p.end, err = parseClock(p.End); err != nil {
+		return err
+	}
+	if len(p.Days) > 0 {
+		p.days = make(map[time.Weekday]bool, len(p.Days))
+		for _, day := range p.Days {
+			weekday, ok := weekdays[strings.ToLower(day)]
+			if !ok {
+				return fmt.Errorf("unknown day %q", day)
+			}
+			p.days[weekday] = true
+		}
+	}
+	return nil
+}
+
+// active reports whether the profile applies at t (in the policy's timezone). A range
+// that wraps midnight belongs to the day it starts on.
+func (p *LimitProfile) active(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	switch {
+	case p.start < p.end:
+		if minute < p.start || minute >= p.end {
+			return false
+		}
+	case minute >= p.start:
+	case minute < p.end:
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+	return p.days == nil || p.days[day]
+}
+
+// parseClock parses HH:MM into minutes since midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// profileAt returns the first profile active at t, or nil
+func (p *LimitPolicy) profileAt(t time.Time) *LimitProfile {
+	t = t.In(p.location)
+	for i := range p.Profiles {
+		if p.Profiles[i].active(t) {
+			return &p.Profiles[i]
+		}
+	}
+	return nil
+}
+
+// UsePolicy applies the policy's schedule profiles and adaptive mode, re-evaluated every
+// interval until Stop. errorRate reports the upstream error rate and request count over
+// the last minute; a nil policy or a disabled limiter keeps the static limits.
+func (rl *RateLimiter) UsePolicy(policy *LimitPolicy, errorRate func() (float64, int), interval time.Duration) {
+	if policy == nil || !rl.enabled {
+		return
+	}
+	rl.policy = policy
+	rl.errorRate = errorRate
+	rl.evaluatePolicy(rl.clock.Now())
+	logger.Info("Rate limit policy enabled | profiles=%d adaptive=%v timezone=%s interval=%v",
+		len(policy.Profiles), policy.Adaptive != nil, policy.location, interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-rl.stopChan:
+				return
+			case <-ticker.C:
+				rl.evaluatePolicy(rl.clock.Now())
+			}
+		}
+	}()
+}
+
+// evaluatePolicy selects the profile for now, updates the adaptive multiplier and
+// applies the resulting limit to every tracked identifier when it changed
+func (rl *RateLimiter) evaluatePolicy(now time.Time) {
+	limit, burst, name := rl.requestsPerSec, rl.burst, "default"
+	if profile := rl.policy.profileAt(now); profile != nil {
+		limit, burst, name = rate.Limit(profile.RequestsPerSec), profile.Burst, profile.Name
+	}
+
+	rl.limitMu.Lock()
+	scale := rl.scale
+	if a := rl.policy.Adaptive; a != nil && rl.errorRate != nil {
+		errRate, requests := rl.errorRate()
+		switch {
+		case requests >= a.MinRequests && errRate >= a.ErrorRateThreshold:
+			scale = max(scale*a.Factor, a.MinFactor)
+		case errRate < a.ErrorRateThreshold/2:
+			scale = min(scale/a.Factor, 1)
+		}
+		if scale < rl.scale {
+			logger.Warn("Upstream error rate high, tightening rate limits | error_rate=%.2f requests=%d scale=%.2f", errRate, requests, scale)
+		} else if scale > rl.scale {
+			logger.Info("Upstream error rate recovered, relaxing rate limits | error_rate=%.2f requests=%d scale=%.2f", errRate, requests, scale)
+		}
+	}
+	limit = rate.Limit(float64(limit) * scale)
+	burst = max(int(float64(burst)*scale), 1)
+
+	changed := limit != rl.limit || burst != rl.limitBurst
+	if name != rl.profile {
+		logger.Info("Rate limit profile switched | profile=%s previous=%s requests_per_sec=%.2f burst=%d", name, rl.profile, float64(limit), burst)
+	}
+	rl.limit, rl.limitBurst, rl.profile, rl.scale = limit, burst, name, scale
+	rl.limitMu.Unlock()
+
+	effectiveRateLimit.Set(float64(limit))
+	if !changed {
+		return
+	}
+	for _, shard := range rl.shards {
+		shard.mu.RLock()
+		for _, entry := range shard.limiters {
+			entry.limiter.SetLimitAt(now, limit)
+			entry.limiter.SetBurstAt(now, burst)
+		}
+		shard.mu.RUnlock()
+	}
+}
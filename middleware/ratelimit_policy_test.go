@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePolicy(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadLimitPolicy(t *testing.T) {
+	if policy, err := LoadLimitPolicy(""); policy != nil || err != nil {
+		t.Fatalf("empty path = %v, %v; want nil, nil", policy, err)
+	}
+
+	invalid := []string{
+		`{"profiles":[{"name":"x","start":"9:00","end":"25:00","requests_per_sec":1,"burst":1}]}`,
+		`{"profiles":[{"name":"x","days":["someday"],"start":"09:00","end":"10:00","requests_per_sec":1,"burst":1}]}`,
+		`{"profiles":[{"name":"x","start":"09:00","end":"10:00","requests_per_sec":0,"burst":1}]}`,
+		`{"timezone":"Nowhere/City"}`,
+		`{"adaptive":{"error_rate_threshold":0}}`,
+		`{"adaptive":{"error_rate_threshold":0.5,"factor":1.5}}`,
+	}
+	for _, content := range invalid {
+		if _, err := LoadLimitPolicy(writePolicy(t, content)); err == nil {
+			t.Errorf("LoadLimitPolicy(%s) should fail", content)
+		}
+	}
+
+	policy, err := LoadLimitPolicy(writePolicy(t, `{"adaptive":{"error_rate_threshold":0.3}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Adaptive.Factor != 0.5 || policy.Adaptive.MinFactor != 0.1 {
+		t.Errorf("adaptive defaults = %+v", policy.Adaptive)
+	}
+}
+
+func TestLimitPolicy_ProfileAt(t *testing.T) {
+	policy, err := LoadLimitPolicy(writePolicy(t, `{
+		"profiles": [
+			{"name":"business","days":["mon","tue","wed","thu","fri"],"start":"09:00","end":"18:00","requests_per_sec":100,"burst":200},
+			{"name":"friday_night","days":["fri"],"start":"22:00","end":"06:00","requests_per_sec":5,"burst":10}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		at   time.Time
+		want string
+	}{
+		{time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC), "business"}, // Monday, start is inclusive
+		{time.Date(2025, 3, 3, 18, 0, 0, 0, time.UTC), ""},        // end is exclusive
+		{time.Date(2025, 3, 8, 12, 0, 0, 0, time.UTC), ""},        // Saturday
+		{time.Date(2025, 3, 7, 23, 0, 0, 0, time.UTC), "friday_night"},
+		{time.Date(2025, 3, 8, 5, 59, 0, 0, time.UTC), "friday_night"}, // Saturday morning belongs to Friday's range
+		{time.Date(2025, 3, 7, 5, 0, 0, 0, time.UTC), ""},              // Friday morning belongs to Thursday
+	}
+	for _, tt := range tests {
+		got := ""
+		if profile := policy.profileAt(tt.at); profile != nil {
+			got = profile.Name
+		}
+		if got != tt.want {
+			t.Errorf("profileAt(%s) = %q, want %q", tt.at.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestRateLimiter_EvaluatePolicy(t *testing.T) {
+	rl := NewRateLimiter(100, 50, "ip", true, 0)
+	defer rl.Stop()
+	policy, err := LoadLimitPolicy(writePolicy(t, `{
+		"profiles": [{"name":"night","start":"00:00","end":"06:00","requests_per_sec":10,"burst":20}],
+		"adaptive": {"error_rate_threshold":0.5,"min_requests":10,"factor":0.5,"min_factor":0.25}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errRate, requests := 0.0, 0
+	rl.policy = policy
+	rl.errorRate = func() (float64, int) { return errRate, requests }
+
+	day := time.Date(2025, 3, 3, 12, 0, 0, 0, time.UTC)
+	night := time.Date(2025, 3, 3, 1, 0, 0, 0, time.UTC)
+	existing := rl.GetLimiter("192.168.1.1")
+
+	rl.evaluatePolicy(night)
+	if limit, burst := rl.currentLimit(); limit != 10 || burst != 20 || rl.profile != "night" {
+		t.Fatalf("night limit = %v/%d profile %s, want 10/20 night", limit, burst, rl.profile)
+	}
+	if existing.Limit() != 10 || existing.Burst() != 20 {
+		t.Errorf("existing limiter not updated: %v/%d", existing.Limit(), existing.Burst())
+	}
+
+	// High error rate without enough requests is ignored
+	errRate, requests = 0.9, 5
+	rl.evaluatePolicy(day)
+	if limit, _ := rl.currentLimit(); limit != 100 {
+		t.Errorf("limit with too few requests = %v, want 100", limit)
+	}
+
+	// Tighten step by step down to min_factor
+	requests = 50
+	for _, want := range []float64{50, 25, 25} {
+		rl.evaluatePolicy(day)
+		if limit, _ := rl.currentLimit(); float64(limit) != want {
+			t.Errorf("tightened limit = %v, want %v", limit, want)
+		}
+	}
+	if rl.GetLimiter("10.0.0.1").Limit() != 25 {
+		t.Error("new limiters must use the effective limit")
+	}
+
+	// Between half the threshold and the threshold the scale holds, below it relaxes
+	errRate = 0.3
+	rl.evaluatePolicy(day)
+	if limit, _ := rl.currentLimit(); limit != 25 {
+		t.Errorf("limit in hysteresis band = %v, want 25", limit)
+	}
+	errRate = 0.1
+	for _, want := range []float64{50, 100, 100} {
+		rl.evaluatePolicy(day)
+		if limit, burst := rl.currentLimit(); float64(limit) != want {
+			t.Errorf("relaxed limit = %v (burst %d), want %v", limit, burst, want)
+		}
+	}
+	if rl.requestsPerSec != 100 || rl.burst != 50 {
+		t.Error("configured base limits must not change")
+	}
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"cursor2api/clock"
+	"cursor2api/metrics"
+	"golang.org/x/time/rate"
+)
+
+var rateLimitRefunds = metrics.NewCounter(
+	"cursor2api_rate_limit_refunds_total",
+	"Rate limit tokens returned because the upstream failed before producing any output, by limiter (key or user).",
+	"limiter")
+
+// rateLimitCharge is one token taken from a limiter by a request
+type rateLimitCharge struct {
+	limiter *rate.Limiter
+	kind    string // "key" for the main limiter, "user" for the per-user limiter
+	clock   clock.Clock
+}
+
+// rateLimitCharges collects the tokens a request consumed so they can be refunded once.
+// Middleware attaches it to the request context; AllowUser adds to it.
+type rateLimitCharges struct {
+	mu       sync.Mutex
+	charges  []rateLimitCharge
+	refunded bool
+}
+
+// withRateLimitCharges returns a context carrying an empty charge collector
+func withRateLimitCharges(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rateLimitChargesContextKey, &rateLimitCharges{})
+}
+
+// chargeRateLimit records a consumed token on the request's collector, if any
+func chargeRateLimit(ctx context.Context, limiter *rate.Limiter, kind string, c clock.Clock) {
+	charges, _ := ctx.Value(rateLimitChargesContextKey).(*rateLimitCharges)
+	if charges == nil {
+		return
+	}
+	charges.mu.Lock()
+	charges.charges = append(charges.charges, rateLimitCharge{limiter: limiter, kind: kind, clock: c})
+	charges.mu.Unlock()
+}
+
+// RefundRateLimit returns the rate limit tokens consumed by the request, so clients are
+// not penalized for server-side failures such as an unreachable or erroring upstream.
+// Only the first call per request refunds; it returns the number of tokens returned.
+// Failed requests never record usage, so spend quotas need no refund.
+func RefundRateLimit(ctx context.Context) int {
+	charges, _ := ctx.Value(rateLimitChargesContextKey).(*rateLimitCharges)
+	if charges == nil {
+		return 0
+	}
+	charges.mu.Lock()
+	defer charges.mu.Unlock()
+	if charges.refunded {
+		return 0
+	}
+	charges.refunded = true
+
+	refunded := 0
+	for _, charge := range charges.charges {
+		if refundToken(charge.limiter, charge.clock.Now()) {
+			rateLimitRefunds.Inc(charge.kind)
+			refunded++
+		}
+	}
+	return refunded
+}
+
+// refundToken puts one token back into limiter unless its bucket is already full.
+// rate.Limiter has no refund API and Reservation.Cancel only works before the
+// reservation's time to act, so this takes a negative number of tokens instead;
+// the limiter caps the bucket at its burst again on the next request.
+func refundToken(limiter *rate.Limiter, now time.Time) bool {
+	if limiter.TokensAt(now) >= float64(limiter.Burst()) {
+		return false
+	}
+	return limiter.AllowN(now, -1)
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRefundRateLimit_ReturnsConsumedToken(t *testing.T) {
+	rl := NewRateLimiter(0.001, 1, "ip", true, time.Hour)
+	defer rl.Stop()
+
+	refund := false
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if refund {
+			if n := RefundRateLimit(r.Context()); n != 1 {
+				t.Errorf("RefundRateLimit = %d, want 1", n)
+			}
+			if n := RefundRateLimit(r.Context()); n != 0 {
+				t.Errorf("second RefundRateLimit = %d, want 0", n)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// A failed upstream gives the only token back, so the next request still passes
+	refund = true
+	if code := serve(); code != http.StatusOK {
+		t.Fatalf("first request = %d", code)
+	}
+	refund = false
+	if code := serve(); code != http.StatusOK {
+		t.Fatalf("request after refund = %d, want 200", code)
+	}
+	if code := serve(); code != http.StatusTooManyRequests {
+		t.Fatalf("request without refund = %d, want 429", code)
+	}
+}
+
+func TestRefundToken_CappedAtBurst(t *testing.T) {
+	rl := NewRateLimiter(1, 2, "ip", true, time.Hour)
+	defer rl.Stop()
+	limiter := rl.GetLimiter("10.0.0.1")
+	now := time.Now()
+
+	if refundToken(limiter, now) {
+		t.Fatal("a full bucket should not accept a refund")
+	}
+	limiter.AllowN(now, 1)
+	if !refundToken(limiter, now) {
+		t.Fatal("refund after consuming a token should succeed")
+	}
+	// Two requests drain the refilled bucket; a third is still limited
+	if !limiter.AllowN(now, 1) || !limiter.AllowN(now, 1) {
+		t.Fatal("the refunded bucket should allow its burst")
+	}
+	if limiter.AllowN(now, 1) {
+		t.Fatal("a refund must not let more than the burst through")
+	}
+}
+
+func TestRefundRateLimit_WithoutMiddleware(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	if n := RefundRateLimit(req.Context()); n != 0 {
+		t.Fatalf("RefundRateLimit = %d, want 0 outside the middleware", n)
+	}
+}
@@ -1,11 +1,15 @@
 package middleware
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"cursor2api/clock"
 )
 
 // Test helper: create a simple next handler
@@ -334,10 +338,11 @@ func TestRateLimiter_ConcurrentSafety(t *testing.T) {
 	wg.Wait()
 }
 
-// Test 10: Cleanup mechanism removes inactive limiters
+// Test 10: Background cleanup removes inactive limiters
 func TestRateLimiter_CleanupMechanism(t *testing.T) {
 	// Create rate limiter with short cleanup interval
 	rl := NewRateLimiter(10.0, 20, "ip", true, 100*time.Millisecond)
+	defer rl.Stop()
 
 	// Create a limiter for an IP
 	limiter1 := rl.GetLimiter("192.168.1.1")
@@ -346,29 +351,66 @@ func TestRateLimiter_CleanupMechanism(t *testing.T) {
 	}
 
 	// Verify limiter exists
-	rl.mu.RLock()
-	if _, exists := rl.limiters["192.168.1.1"]; !exists {
+	if _, exists := rl.lookup("192.168.1.1"); !exists {
 		t.Error("Limiter was not stored")
 	}
-	rl.mu.RUnlock()
 
 	// Consume all tokens to make it eligible for cleanup
 	for i := 0; i < 20; i++ {
 		limiter1.Allow()
 	}
 
-	// Wait for cleanup interval + buffer
-	time.Sleep(150 * time.Millisecond)
-
-	// Trigger cleanup by getting a new limiter
-	_ = rl.GetLimiter("192.168.1.2")
+	// Wait for the background loop to run at least once past the TTL,
+	// without issuing any further requests
+	time.Sleep(350 * time.Millisecond)
 
 	// Verify old limiter was cleaned up
-	rl.mu.RLock()
-	if _, exists := rl.limiters["192.168.1.1"]; exists {
+	if _, exists := rl.lookup("192.168.1.1"); exists {
 		t.Error("Inactive limiter was not cleaned up")
 	}
-	rl.mu.RUnlock()
+}
+
+// Test 10b: Cleanup keeps recently accessed limiters
+func TestRateLimiter_CleanupKeepsActive(t *testing.T) {
+	rl := NewRateLimiter(10.0, 20, "ip", true, time.Hour)
+	defer rl.Stop()
+
+	rl.GetLimiter("192.168.1.1")
+	rl.GetLimiter("192.168.1.2")
+
+	if removed := rl.cleanup(); removed != 0 {
+		t.Errorf("cleanup() removed %d active limiters, want 0", removed)
+	}
+	if got := rl.size(); got != 2 {
+		t.Errorf("size() = %d, want 2", got)
+	}
+}
+
+// Test 10c: Cleanup and refill follow the limiter's clock
+func TestRateLimiter_FakeClock(t *testing.T) {
+	rl := NewRateLimiter(1.0, 1, "ip", true, time.Hour)
+	defer rl.Stop()
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	rl.clock = fake
+
+	if !rl.Allow("192.168.1.1") || rl.Allow("192.168.1.1") {
+		t.Fatal("burst of 1 should allow exactly one request")
+	}
+	fake.Advance(time.Second)
+	if !rl.Allow("192.168.1.1") {
+		t.Error("bucket should refill after one second")
+	}
+
+	rl.GetLimiter("192.168.1.2")
+	fake.Advance(30 * time.Minute)
+	rl.GetLimiter("192.168.1.2")
+	fake.Advance(31 * time.Minute)
+	if removed := rl.cleanup(); removed != 1 {
+		t.Errorf("cleanup() removed %d limiters, want only the one idle past the interval", removed)
+	}
+	if _, exists := rl.lookup("192.168.1.2"); !exists {
+		t.Error("limiter accessed within the interval was removed")
+	}
 }
 
 // Test 11: maskIdentifier function
@@ -403,6 +445,11 @@ func TestMaskIdentifier(t *testing.T) {
 			input: "",
 			want:  "",
 		},
+		{
+			name:  "Per-user identifier",
+			input: "sk_test_1234567890abcdefghijklmnop|user=alice",
+			want:  "sk_test_...|user=alice",
+		},
 	}
 
 	for _, tt := range tests {
@@ -427,9 +474,7 @@ func TestRateLimiter_GetLimiter_CreatesNew(t *testing.T) {
 	}
 
 	// Verify limiter is stored
-	rl.mu.RLock()
-	entry, exists := rl.limiters[identifier]
-	rl.mu.RUnlock()
+	entry, exists := rl.lookup(identifier)
 
 	if !exists {
 		t.Error("Limiter was not stored in map")
@@ -564,6 +609,43 @@ func TestRateLimiter_ExtractIdentifier_APIKey(t *testing.T) {
 	}
 }
 
+// Test 17: AllowUser limits end users beneath the API key
+func TestRateLimiter_AllowUser(t *testing.T) {
+	rl := NewRateLimiter(0.001, 1, "api_key", true, time.Hour)
+	newRequest := func(key string) *http.Request {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		return req
+	}
+
+	if !rl.AllowUser(httptest.NewRecorder(), newRequest("sk-app"), "alice") {
+		t.Fatal("first request of alice should be allowed")
+	}
+	w := httptest.NewRecorder()
+	if rl.AllowUser(w, newRequest("sk-app"), "alice") {
+		t.Fatal("second request of alice should be limited")
+	}
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", w.Code)
+	}
+
+	// Other users of the same key and the same user ID under another key are independent
+	if !rl.AllowUser(httptest.NewRecorder(), newRequest("sk-app"), "bob") {
+		t.Error("bob should not be limited by alice")
+	}
+	if !rl.AllowUser(httptest.NewRecorder(), newRequest("sk-other"), "alice") {
+		t.Error("alice under another key should not be limited")
+	}
+	if !rl.AllowUser(httptest.NewRecorder(), newRequest("sk-app"), "") {
+		t.Error("requests without a user should not be limited")
+	}
+
+	var disabled *RateLimiter
+	if !disabled.AllowUser(httptest.NewRecorder(), newRequest("sk-app"), "alice") {
+		t.Error("nil limiter should allow")
+	}
+}
+
 // Benchmark: Rate limiter performance
 func BenchmarkRateLimiter_Middleware(b *testing.B) {
 	rl := NewRateLimiter(1000.0, 2000, "ip", true, time.Hour)
@@ -587,4 +669,61 @@ func BenchmarkRateLimiter_GetLimiter(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		_ = rl.GetLimiter("192.168.1.1")
 	}
-}
\ No newline at end of file
+}
+
+// benchIdentifiers builds n distinct identifiers for high-cardinality benchmarks
+func benchIdentifiers(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("10.%d.%d.%d", (i>>16)&0xff, (i>>8)&0xff, i&0xff)
+	}
+	return ids
+}
+
+// Benchmark: GetLimiter with 10k distinct identifiers from parallel goroutines
+func BenchmarkRateLimiter_GetLimiter_10kIdentifiers(b *testing.B) {
+	rl := NewRateLimiter(1000.0, 2000, "ip", true, time.Hour)
+	defer rl.Stop()
+	ids := benchIdentifiers(10000)
+	for _, id := range ids {
+		rl.GetLimiter(id)
+	}
+
+	var counter atomic.Uint64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := counter.Add(1)
+			_ = rl.GetLimiter(ids[i%uint64(len(ids))])
+		}
+	})
+}
+
+// Benchmark: Allow with 10k distinct identifiers while cleanup runs concurrently
+func BenchmarkRateLimiter_Allow_10kIdentifiersWithCleanup(b *testing.B) {
+	rl := NewRateLimiter(1000.0, 2000, "ip", true, time.Hour)
+	defer rl.Stop()
+	ids := benchIdentifiers(10000)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				rl.cleanup()
+			}
+		}
+	}()
+	defer close(done)
+
+	var counter atomic.Uint64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := counter.Add(1)
+			_ = rl.Allow(ids[i%uint64(len(ids))])
+		}
+	})
+}
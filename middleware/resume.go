@@ -0,0 +1,395 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cursor2api/logger"
+	"cursor2api/metrics"
+)
+
+// ResumeHeader carries the resume token of a stream: it is set on resumable
+// responses and sent back by a client reconnecting to one
+const ResumeHeader = "X-Resume-Token"
+
+// errResumeExpired ends a generation whose client did not reconnect within the window
+var errResumeExpired = errors.New("client did not reconnect within the stream resume window")
+
+var streamResumes = metrics.NewCounter(
+	"cursor2api_stream_resumes_total",
+	"Reconnects to resumable streams, by result (resumed, unknown, gap).",
+	"result")
+
+// StreamResume lets clients on flaky links reconnect to a streaming completion.
+// Every SSE response of POST /v1/chat/completions gets an opaque resume token (the
+// X-Resume-Token header and a ": resume-token" comment before the first event) and
+// numbered events ("id:" lines) kept in a bounded buffer. When the client goes away
+// the generation keeps running for the window; a request presenting the token with
+// the same API key (and optionally Last-Event-ID) replays the buffered events after
+// that ID and follows the rest of the stream. A nil *StreamResume disables resuming.
+type StreamResume struct {
+	window   time.Duration
+	maxBytes int
+
+	mu      sync.Mutex
+	streams map[string]*resumableStream
+}
+
+// resumeEvent is one SSE event of a resumable stream, including its id line
+type resumeEvent struct {
+	id   int64
+	data []byte
+}
+
+// resumableStream is the buffered response of a streaming completion
+type resumableStream struct {
+	token  string
+	apiKey string
+	cancel context.CancelCauseFunc // ends the generation
+
+	mu      sync.Mutex
+	header  http.Header
+	events  []resumeEvent // most recent events, at most maxBytes in total
+	size    int
+	nextID  int64
+	done    bool
+	reader  int           // connection receiving the events: 0 the original request, n the nth reconnect, -1 none
+	readers int           // reconnects so far
+	expiry  *time.Timer   // removes the stream once nobody reconnected within the window
+	changed chan struct{} // closed and replaced whenever the stream progresses
+}
+
+// NewStreamResume creates the resume registry; returns nil when window is not
+// positive. Each stream keeps its most recent events up to maxBytes.
+func NewStreamResume(window time.Duration, maxBytes int) *StreamResume {
+	if window <= 0 {
+		return nil
+	}
+	logger.Info("Stream resume enabled | window=%v buffer_bytes=%d", window, maxBytes)
+	return &StreamResume{window: window, maxBytes: maxBytes, streams: make(map[string]*resumableStream)}
+}
+
+// Middleware makes the streams of POST /v1/chat/completions resumable and serves
+// reconnects; it must run after APIKeyAuth so a token only resumes for its own key
+func (sr *StreamResume) Middleware(next http.Handler) http.Handler {
+	if sr == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/chat/completions" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if token := r.Header.Get(ResumeHeader); token != "" {
+			sr.resume(w, r, token)
+			return
+		}
+
+		// The generation outlives its connection once the stream started; it is
+		// cancelled like before when the client leaves earlier
+		ctx, cancel := context.WithCancelCause(context.WithoutCancel(r.Context()))
+		defer cancel(nil)
+		rw := &resumeWriter{ResponseWriter: w, sr: sr, apiKey: APIKeyFromContext(r.Context()), cancel: cancel}
+		stop := context.AfterFunc(r.Context(), func() { rw.clientGone(context.Cause(r.Context())) })
+		defer stop()
+
+		next.ServeHTTP(rw, r.WithContext(ctx))
+		rw.finish()
+	})
+}
+
+// register adds a new stream under a fresh token
+func (sr *StreamResume) register(apiKey string, cancel context.CancelCauseFunc) *resumableStream {
+	s := &resumableStream{token: rand.Text(), apiKey: apiKey, cancel: cancel, changed: make(chan struct{})}
+	sr.mu.Lock()
+	sr.streams[s.token] = s
+	sr.mu.Unlock()
+	return s
+}
+
+// lookup returns the stream of token if it belongs to apiKey
+func (sr *StreamResume) lookup(token, apiKey string) (*resumableStream, bool) {
+	sr.mu.Lock()
+	s, ok := sr.streams[token]
+	sr.mu.Unlock()
+	if !ok || subtle.ConstantTimeCompare([]byte(s.apiKey), []byte(apiKey)) != 1 {
+		return nil, false
+	}
+	return s, true
+}
+
+// remove drops the stream from the registry
+func (sr *StreamResume) remove(s *resumableStream) {
+	sr.mu.Lock()
+	if sr.streams[s.token] == s {
+		delete(sr.streams, s.token)
+	}
+	sr.mu.Unlock()
+}
+
+// detach records that connection reader no longer receives the stream; the stream
+// is dropped, and its generation cancelled, unless a client reconnects within the window
+func (sr *StreamResume) detach(s *resumableStream, reader int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reader != reader {
+		return
+	}
+	s.reader = -1
+	s.expiry = time.AfterFunc(sr.window, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.reader != -1 {
+			return
+		}
+		sr.remove(s)
+		if !s.done {
+			logger.Info("Stream not resumed within the window, ending its generation | window=%v", sr.window)
+			s.cancel(errResumeExpired)
+		}
+	})
+}
+
+// resume serves a reconnect presenting a resume token
+func (sr *StreamResume) resume(w http.ResponseWriter, r *http.Request, token string) {
+	s, ok := sr.lookup(token, APIKeyFromContext(r.Context()))
+	if !ok {
+		streamResumes.Inc("unknown")
+		respondTenantError(w, r, http.StatusNotFound, "invalid_request_error", "resume_token_invalid",
+			"The resume token is unknown, has expired or belongs to another API key.")
+		return
+	}
+	after := int64(-1)
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		id, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil || id < 0 {
+			respondTenantError(w, r, http.StatusBadRequest, "invalid_request_error", "invalid_request",
+				fmt.Sprintf("Last-Event-ID must be an event ID of the stream, got %q", v))
+			return
+		}
+		after = id
+	}
+
+	reader, header, ok := s.attach(after)
+	if !ok {
+		streamResumes.Inc("gap")
+		respondTenantError(w, r, http.StatusGone, "invalid_request_error", "resume_gap",
+			"The events after Last-Event-ID are no longer buffered; send the request again without a resume token.")
+		return
+	}
+	streamResumes.Inc("resumed")
+	logger.Info("Stream resumed | client_ip=%s last_event_id=%d", getClientIP(r), after)
+
+	maps.Copy(w.Header(), header)
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	for {
+		s.mu.Lock()
+		if s.reader != reader {
+			// A newer reconnect took the stream over
+			s.mu.Unlock()
+			return
+		}
+		var pending [][]byte
+		for _, event := range s.events {
+			if event.id > after {
+				pending = append(pending, event.data)
+				after = event.id
+			}
+		}
+		done, changed := s.done, s.changed
+		s.mu.Unlock()
+
+		for _, data := range pending {
+			if _, err := w.Write(data); err != nil {
+				sr.detach(s, reader)
+				return
+			}
+		}
+		if len(pending) > 0 && flusher != nil {
+			flusher.Flush()
+		}
+		if done {
+			sr.detach(s, reader)
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			sr.detach(s, reader)
+			return
+		}
+	}
+}
+
+// attach makes a reconnect the reader of the stream, taking it over from the
+// previous connection; returns false when events after the given ID were dropped
+func (s *resumableStream) attach(after int64) (int, http.Header, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if oldest := s.nextID - int64(len(s.events)); after+1 < oldest {
+		return 0, nil, false
+	}
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	s.readers++
+	s.reader = s.readers
+	s.notifyLocked()
+	return s.reader, s.header.Clone(), true
+}
+
+// notifyLocked wakes the reconnects waiting for progress (caller holds s.mu)
+func (s *resumableStream) notifyLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// resumeWriter numbers and buffers the events of a streaming response while
+// writing them to the original client as long as it is connected
+type resumeWriter struct {
+	http.ResponseWriter
+	sr     *StreamResume
+	apiKey string
+	cancel context.CancelCauseFunc
+
+	mu        sync.Mutex
+	started   bool
+	stream    *resumableStream // nil until an SSE response starts
+	pending   []byte           // incomplete event
+	announced bool             // the resume-token comment was written
+	gone      bool             // the original client left before the stream started
+}
+
+// start registers the stream on the first write of an SSE response
+func (w *resumeWriter) start() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.started {
+		return
+	}
+	w.started = true
+	if w.gone || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		return
+	}
+	w.stream = w.sr.register(w.apiKey, w.cancel)
+	w.Header().Set(ResumeHeader, w.stream.token)
+	w.stream.header = w.Header().Clone()
+}
+
+func (w *resumeWriter) WriteHeader(code int) {
+	w.start()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *resumeWriter) Write(b []byte) (int, error) {
+	w.start()
+	s := w.stream
+	if s == nil {
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.mu.Lock()
+	w.pending = append(w.pending, b...)
+	var out []byte
+	if !w.announced {
+		out = fmt.Appendf(out, ": resume-token %s\n\n", s.token)
+		w.announced = true
+	}
+	s.mu.Lock()
+	for {
+		end := bytes.Index(w.pending, []byte("\n\n"))
+		if end < 0 {
+			break
+		}
+		event := w.pending[:end+2]
+		w.pending = w.pending[end+2:]
+		if event[0] == ':' {
+			// Comments (progress, keep-alive) are only for the live connection
+			out = append(out, event...)
+			continue
+		}
+		data := append(fmt.Appendf(nil, "id: %d\n", s.nextID), event...)
+		s.events = append(s.events, resumeEvent{id: s.nextID, data: data})
+		s.size += len(data)
+		s.nextID++
+		for w.sr.maxBytes > 0 && s.size > w.sr.maxBytes && len(s.events) > 1 {
+			s.size -= len(s.events[0].data)
+			s.events = s.events[1:]
+		}
+		out = append(out, data...)
+	}
+	live := s.reader == 0
+	s.notifyLocked()
+	s.mu.Unlock()
+	w.mu.Unlock()
+
+	if live && len(out) > 0 {
+		if _, err := w.ResponseWriter.Write(out); err != nil {
+			// Keep generating for a reconnect instead of failing the handler
+			w.sr.detach(s, 0)
+		}
+	}
+	return len(b), nil
+}
+
+func (w *resumeWriter) Flush() {
+	if s := w.stream; s != nil {
+		s.mu.Lock()
+		live := s.reader == 0
+		s.mu.Unlock()
+		if !live {
+			return
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *resumeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// clientGone handles the original client disconnecting: a started stream keeps
+// generating for a reconnect, anything else is cancelled as before
+func (w *resumeWriter) clientGone(cause error) {
+	w.mu.Lock()
+	s := w.stream
+	if s == nil {
+		w.gone = true
+	}
+	w.mu.Unlock()
+	if s == nil {
+		w.cancel(cause)
+		return
+	}
+	w.sr.detach(s, 0)
+}
+
+// finish marks the stream complete. It stays for reconnects until the window
+// passes even when the original client seems connected: writes to a connection
+// that just broke may still succeed.
+func (w *resumeWriter) finish() {
+	s := w.stream
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.done = true
+	s.notifyLocked()
+	s.mu.Unlock()
+	w.sr.detach(s, 0)
+}
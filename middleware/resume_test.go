@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// streamingHandler writes one event, waits for release, then writes the rest of the
+// stream; the cause its context ended with (nil while running) is sent on causes
+func streamingHandler(release <-chan struct{}, causes chan<- error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+			_, _ = w.Write([]byte(": x-progress {}\n\n"))
+			_, _ = w.Write([]byte("data: second\n\ndata: [DONE]\n\n"))
+		case <-r.Context().Done():
+		}
+		causes <- context.Cause(r.Context())
+	})
+}
+
+func keyedRequest(ctx context.Context, apiKey string) *http.Request {
+	return chatRequest("{}").WithContext(withAPIKey(ctx, apiKey))
+}
+
+func TestStreamResume_ReconnectAfterDisconnect(t *testing.T) {
+	release := make(chan struct{})
+	causes := make(chan error, 1)
+	handler := NewStreamResume(time.Minute, 0).Middleware(streamingHandler(release, causes))
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	original := &writeSignal{ResponseRecorder: httptest.NewRecorder(), written: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(original, keyedRequest(ctx, "sk-a"))
+		close(done)
+	}()
+	<-original.written
+	token := original.Header().Get(ResumeHeader)
+	if token == "" {
+		t.Fatal("stream has no resume token")
+	}
+
+	// The generation outlives the connection
+	disconnect()
+	close(release)
+	<-done
+	if err := <-causes; err != nil {
+		t.Fatalf("generation context ended with the connection: %v", err)
+	}
+	body := original.Body.String()
+	if !strings.Contains(body, ": resume-token "+token) || !strings.Contains(body, "id: 0\ndata: first") {
+		t.Errorf("original body = %q", body)
+	}
+
+	// Another key cannot resume the stream
+	other := keyedRequest(context.Background(), "sk-b")
+	other.Header.Set(ResumeHeader, token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, other)
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "resume_token_invalid") {
+		t.Fatalf("other key: status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	reconnect := keyedRequest(context.Background(), "sk-a")
+	reconnect.Header.Set(ResumeHeader, token)
+	reconnect.Header.Set("Last-Event-ID", "0")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, reconnect)
+	if want := "id: 1\ndata: second\n\nid: 2\ndata: [DONE]\n\n"; rec.Body.String() != want {
+		t.Errorf("resumed body = %q, want %q", rec.Body.String(), want)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestStreamResume_GenerationEndsAfterWindow(t *testing.T) {
+	causes := make(chan error, 1)
+	handler := NewStreamResume(20*time.Millisecond, 0).Middleware(streamingHandler(nil, causes))
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	original := &writeSignal{ResponseRecorder: httptest.NewRecorder(), written: make(chan struct{})}
+	go handler.ServeHTTP(original, keyedRequest(ctx, "sk-a"))
+	<-original.written
+	disconnect()
+
+	select {
+	case cause := <-causes:
+		if cause != errResumeExpired {
+			t.Errorf("cause = %v, want errResumeExpired", cause)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("generation was not cancelled after the resume window")
+	}
+
+	reconnect := keyedRequest(context.Background(), "sk-a")
+	reconnect.Header.Set(ResumeHeader, original.Header().Get(ResumeHeader))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, reconnect)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404 for an expired token", rec.Code)
+	}
+}
+
+func TestStreamResume_GapInBuffer(t *testing.T) {
+	release := make(chan struct{})
+	causes := make(chan error, 1)
+	// The buffer only holds the last event
+	handler := NewStreamResume(time.Minute, 1).Middleware(streamingHandler(release, causes))
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	original := &writeSignal{ResponseRecorder: httptest.NewRecorder(), written: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(original, keyedRequest(ctx, "sk-a"))
+		close(done)
+	}()
+	<-original.written
+	disconnect()
+	close(release)
+	<-done
+
+	reconnect := keyedRequest(context.Background(), "sk-a")
+	reconnect.Header.Set(ResumeHeader, original.Header().Get(ResumeHeader))
+	reconnect.Header.Set("Last-Event-ID", "0")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, reconnect)
+	if rec.Code != http.StatusGone || !strings.Contains(rec.Body.String(), "resume_gap") {
+		t.Errorf("status = %d, body = %s, want 410 resume_gap", rec.Code, rec.Body)
+	}
+}
+
+func TestStreamResume_NonStreamingUnchanged(t *testing.T) {
+	handler := NewStreamResume(time.Minute, 0).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, keyedRequest(context.Background(), "sk-a"))
+	if rec.Header().Get(ResumeHeader) != "" || rec.Body.String() != `{"ok":true}` {
+		t.Errorf("header = %q, body = %q", rec.Header().Get(ResumeHeader), rec.Body)
+	}
+}
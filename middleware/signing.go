@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cursor2api/logger"
+)
+
+// Response signature headers. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with RESPONSE_SIGNING_SECRET.
+const (
+	SignatureHeader          = "X-Signature-SHA256"
+	SignatureTimestampHeader = "X-Signature-Timestamp" // Unix seconds
+)
+
+// ResponseSigner signs every response so downstream services receiving them through
+// intermediaries can verify they come from this instance. Regular responses are
+// buffered and carry the signature as a header; streamed responses (SSE, or any
+// response its handler flushes) are hashed as they are written and carry it as an
+// HTTP trailer. A nil *ResponseSigner disables signing.
+type ResponseSigner struct {
+	secret []byte
+}
+
+// NewResponseSigner creates the signer; returns nil when secret is empty
+func NewResponseSigner(secret string) *ResponseSigner {
+	if secret == "" {
+		return nil
+	}
+	logger.Info("Response signing enabled | header=%s", SignatureHeader)
+	return &ResponseSigner{secret: []byte(secret)}
+}
+
+// Middleware signs the responses of next
+func (s *ResponseSigner) Middleware(next http.Handler) http.Handler {
+	if s == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, s.secret)
+		mac.Write([]byte(timestamp + "."))
+
+		sw := &signingWriter{ResponseWriter: w, mac: mac, timestamp: timestamp}
+		next.ServeHTTP(sw, r)
+		sw.finish()
+	})
+}
+
+// signingWriter hashes the response body and adds the signature headers. The
+// headers are only set when the response header is written, so handlers copying
+// the header of another response never carry over a foreign timestamp.
+type signingWriter struct {
+	http.ResponseWriter
+	mac       hash.Hash
+	timestamp string
+
+	status    int
+	streaming bool
+	body      bytes.Buffer // regular responses, written with the signature on finish
+}
+
+func (w *signingWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	if w.isStream() {
+		w.startStream()
+	}
+}
+
+// startStream writes the header and what was buffered so far; the rest of the
+// response passes through and the signature follows as a trailer
+func (w *signingWriter) startStream() {
+	w.streaming = true
+	w.Header().Set(SignatureTimestampHeader, w.timestamp)
+	w.Header().Add("Trailer", SignatureHeader)
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.body.Len() == 0 {
+		return
+	}
+	if _, err := w.ResponseWriter.Write(w.body.Bytes()); err != nil {
+		logger.Warn("Failed to write signed response | error=%v", err)
+	}
+	w.body.Reset()
+}
+
+func (w *signingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.mac.Write(b)
+	if !w.streaming {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// isStream reports whether the response is an SSE stream
+func (w *signingWriter) isStream() bool {
+	return strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+}
+
+// Flush passes through for streams. A response flushed by its handler is a stream
+// whatever its Content-Type (e.g. the JSON array of Gemini's streamGenerateContent),
+// so the first flush starts streaming it; unflushed responses are sent whole on finish.
+func (w *signingWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.streaming {
+		w.startStream()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *signingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish sets the signature: as a trailer for streams, otherwise as a header
+// before writing the buffered response
+func (w *signingWriter) finish() {
+	signature := hex.EncodeToString(w.mac.Sum(nil))
+	if w.streaming {
+		w.Header().Set(SignatureHeader, signature)
+		return
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.Header().Set(SignatureTimestampHeader, w.timestamp)
+	w.Header().Set(SignatureHeader, signature)
+	w.ResponseWriter.WriteHeader(w.status)
+	if _, err := w.ResponseWriter.Write(w.body.Bytes()); err != nil {
+		logger.Warn("Failed to write signed response | error=%v", err)
+	}
+}
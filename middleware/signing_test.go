@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// expectedSignature computes the signature a downstream service verifies
+func expectedSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestResponseSigner_RegularResponse(t *testing.T) {
+	handler := NewResponseSigner("s3cret").Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"a":`))
+		_, _ = w.Write([]byte(`1}`))
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+
+	timestamp := rec.Header().Get(SignatureTimestampHeader)
+	if rec.Code != http.StatusCreated || rec.Body.String() != `{"a":1}` || timestamp == "" {
+		t.Fatalf("response = %d %q, timestamp %q", rec.Code, rec.Body, timestamp)
+	}
+	if got, want := rec.Header().Get(SignatureHeader), expectedSignature("s3cret", timestamp, rec.Body.Bytes()); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+}
+
+func TestResponseSigner_StreamTrailer(t *testing.T) {
+	handler := NewResponseSigner("s3cret").Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte("data: {}\n\n"))
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timestamp := resp.Header.Get(SignatureTimestampHeader)
+	if resp.Header.Get(SignatureHeader) != "" || timestamp == "" {
+		t.Fatalf("stream headers = %v, want the timestamp only", resp.Header)
+	}
+	if got, want := resp.Trailer.Get(SignatureHeader), expectedSignature("s3cret", timestamp, body); got != want {
+		t.Errorf("trailer signature = %q, want %q", got, want)
+	}
+}
+
+func TestResponseSigner_FlushedJSONStream(t *testing.T) {
+	// A JSON array streamed with flushes, as streamGenerateContent without alt=sse
+	released := make(chan struct{})
+	handler := NewResponseSigner("s3cret").Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`[{"a":1}`))
+		w.(http.Flusher).Flush()
+		<-released
+		_, _ = w.Write([]byte(`,{"a":2}]`))
+	}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	first := make([]byte, len(`[{"a":1}`))
+	if _, err := io.ReadFull(resp.Body, first); err != nil {
+		t.Fatalf("first element not sent before the response finished: %v", err)
+	}
+	close(released)
+	rest, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := append(first, rest...)
+	timestamp := resp.Header.Get(SignatureTimestampHeader)
+	if string(body) != `[{"a":1},{"a":2}]` || timestamp == "" {
+		t.Fatalf("response = %q, timestamp %q", body, timestamp)
+	}
+	if got, want := resp.Trailer.Get(SignatureHeader), expectedSignature("s3cret", timestamp, body); got != want {
+		t.Errorf("trailer signature = %q, want %q", got, want)
+	}
+}
+
+func TestResponseSigner_Disabled(t *testing.T) {
+	if NewResponseSigner("") != nil {
+		t.Fatal("an empty secret must disable signing")
+	}
+	var signer *ResponseSigner
+	rec := httptest.NewRecorder()
+	signer.Middleware(createTestHandler()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Header().Get(SignatureHeader) != "" {
+		t.Error("disabled signer added a signature")
+	}
+}
@@ -0,0 +1,343 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cursor2api/clock"
+	"cursor2api/logger"
+	"cursor2api/metrics"
+)
+
+// sliRefreshInterval is how often the SLI gauges are recomputed from the buckets
+const sliRefreshInterval = 15 * time.Second
+
+// sliNoModel is the model label of requests that name no model (e.g. GET /v1/models)
+const sliNoModel = "none"
+
+var (
+	sliRequests = metrics.NewCounter(
+		"cursor2api_sli_requests_total",
+		"API requests counted by the success-rate SLIs, by endpoint, model and result (good, bad). Client 4xx errors and requests abandoned by the client are not counted.",
+		"endpoint", "model", "result")
+	endpointSuccessRatio = metrics.NewGauge(
+		"cursor2api_endpoint_sli_success_ratio",
+		"Share of good requests per endpoint over the rolling window (1 without traffic).",
+		"endpoint", "window")
+	endpointBurnRate = metrics.NewGauge(
+		"cursor2api_endpoint_slo_burn_rate",
+		"Error budget burn rate per endpoint over the rolling window: error rate divided by 1 - SLO_TARGET (above 1 exhausts the budget early).",
+		"endpoint", "window")
+	modelSuccessRatio = metrics.NewGauge(
+		"cursor2api_model_sli_success_ratio",
+		"Share of good requests per model over the rolling window (1 without traffic).",
+		"model", "window")
+	modelBurnRate = metrics.NewGauge(
+		"cursor2api_model_slo_burn_rate",
+		"Error budget burn rate per model over the rolling window: error rate divided by 1 - SLO_TARGET.",
+		"model", "window")
+)
+
+// SLIWindow is the success-rate SLI of one endpoint or model over one rolling window
+type SLIWindow struct {
+	Window          string  `json:"window"`
+	Requests        int64   `json:"requests"`
+	Errors          int64   `json:"errors"`
+	SuccessRate     float64 `json:"success_rate"`           // 1 without traffic
+	BurnRate        float64 `json:"burn_rate"`              // Error rate relative to the error budget 1 - target
+	BudgetRemaining float64 `json:"error_budget_remaining"` // 1 - burn rate; negative once the budget is spent
+	Compliant       bool    `json:"compliant"`              // Success rate at or above the target
+}
+
+// SLIStatus lists the SLIs of one endpoint or model, one entry per window
+type SLIStatus struct {
+	Name    string      `json:"name"`
+	Windows []SLIWindow `json:"windows"`
+}
+
+// sliBucket counts the requests of one minute
+type sliBucket struct {
+	minute    int64
+	good, bad int64
+}
+
+// sliSeries is the ring of minute buckets of one endpoint and model
+type sliSeries struct {
+	endpoint, model string
+	buckets         []sliBucket
+}
+
+// SLITracker computes success-rate SLIs of the API endpoints, per endpoint and per
+// model, over rolling windows and checks them against SLO_TARGET. Requests
+// answered with 5xx, and streams that end with an error event after a 200, are
+// bad; client 4xx errors are not counted. A nil *SLITracker disables the SLIs.
+type SLITracker struct {
+	target  float64
+	windows []time.Duration
+	clock   clock.Clock
+
+	mu     sync.Mutex
+	series map[string]*sliSeries // endpoint|model -> buckets
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+// sliRequest is the outcome of one request, filled in by the handler
+type sliRequest struct {
+	mu     sync.Mutex
+	model  string
+	failed bool
+}
+
+// NewSLITracker creates the tracker and starts refreshing its gauges; returns nil
+// when no window is configured
+func NewSLITracker(target float64, windows []time.Duration) *SLITracker {
+	if len(windows) == 0 {
+		return nil
+	}
+	windows = append([]time.Duration(nil), windows...)
+	sort.Slice(windows, func(i, j int) bool { return windows[i] < windows[j] })
+	t := &SLITracker{
+		target:   target,
+		windows:  windows,
+		clock:    clock.System,
+		series:   make(map[string]*sliSeries),
+		stopChan: make(chan struct{}),
+	}
+	go t.refreshLoop()
+	logger.Info("SLIs enabled | target=%g windows=%v", target, windows)
+	return t
+}
+
+// Stop terminates the gauge refresh goroutine; a nil tracker is a no-op
+func (t *SLITracker) Stop() {
+	if t == nil {
+		return
+	}
+	t.stopOnce.Do(func() {
+		close(t.stopChan)
+	})
+}
+
+// Middleware counts the requests of the API endpoints; health, metrics, admin
+// and unknown paths are not counted
+func (t *SLITracker) Middleware(next http.Handler) http.Handler {
+	if t == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint := sliEndpoint(r.URL.Path)
+		if endpoint == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		req := &sliRequest{}
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), sliContextKey, req)))
+
+		req.mu.Lock()
+		model, failed := req.model, req.failed
+		req.mu.Unlock()
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		switch {
+		case status >= http.StatusInternalServerError || failed:
+			t.record(endpoint, model, false)
+		case status >= http.StatusBadRequest, r.Context().Err() != nil:
+			// Client errors and abandoned requests say nothing about the service
+		default:
+			t.record(endpoint, model, true)
+		}
+	})
+}
+
+// SetSLIModel attributes the request to the model that serves it
+func SetSLIModel(ctx context.Context, model string) {
+	if req, _ := ctx.Value(sliContextKey).(*sliRequest); req != nil {
+		req.mu.Lock()
+		req.model = model
+		req.mu.Unlock()
+	}
+}
+
+// MarkSLIFailed counts the request as bad although its status is not 5xx, for
+// streams that end with an error event after the 200 was sent
+func MarkSLIFailed(ctx context.Context) {
+	if req, _ := ctx.Value(sliContextKey).(*sliRequest); req != nil {
+		req.mu.Lock()
+		req.failed = true
+		req.mu.Unlock()
+	}
+}
+
+// sliEndpoint returns the route of an API path, "" for paths without SLIs
+func sliEndpoint(path string) string {
+	switch {
+	case path == "/v1/chat/completions", path == "/v1/messages", path == "/v1/models":
+		return path
+	case strings.HasPrefix(path, "/v1/chat/completions/") && strings.HasSuffix(path, "/cancel"):
+		return "/v1/chat/completions/{id}/cancel"
+	case strings.HasPrefix(path, "/v1beta/models/") && strings.HasSuffix(path, ":streamGenerateContent"):
+		return "/v1beta/models/{model}:streamGenerateContent"
+	case strings.HasPrefix(path, "/v1beta/models/") && strings.HasSuffix(path, ":generateContent"):
+		return "/v1beta/models/{model}:generateContent"
+	}
+	return ""
+}
+
+// record adds one request to the current minute of its series
+func (t *SLITracker) record(endpoint, model string, good bool) {
+	if model == "" {
+		model = sliNoModel
+	}
+	result := "bad"
+	if good {
+		result = "good"
+	}
+	sliRequests.Inc(endpoint, model, result)
+
+	minute := t.clock.Now().Unix() / 60
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := endpoint + "|" + model
+	s, ok := t.series[key]
+	if !ok {
+		s = &sliSeries{endpoint: endpoint, model: model, buckets: make([]sliBucket, t.windows[len(t.windows)-1]/time.Minute)}
+		t.series[key] = s
+	}
+	b := &s.buckets[minute%int64(len(s.buckets))]
+	if b.minute != minute {
+		*b = sliBucket{minute: minute}
+	}
+	if good {
+		b.good++
+	} else {
+		b.bad++
+	}
+}
+
+// sliCounts are the good and bad requests of one endpoint or model per window
+type sliCounts map[string][]sliBucket
+
+// Snapshot returns the SLIs per endpoint and per model, sorted by name
+func (t *SLITracker) Snapshot() (endpoints, models []SLIStatus) {
+	now := t.clock.Now().Unix() / 60
+	byEndpoint, byModel := sliCounts{}, sliCounts{}
+
+	t.mu.Lock()
+	for _, s := range t.series {
+		totals := make([]sliBucket, len(t.windows))
+		for _, b := range s.buckets {
+			for i, window := range t.windows {
+				if b.minute > now-int64(window/time.Minute) && b.minute <= now {
+					totals[i].good += b.good
+					totals[i].bad += b.bad
+				}
+			}
+		}
+		byEndpoint.add(s.endpoint, totals)
+		if s.model != sliNoModel {
+			byModel.add(s.model, totals)
+		}
+	}
+	t.mu.Unlock()
+
+	return t.statuses(byEndpoint), t.statuses(byModel)
+}
+
+// add sums the per-window totals of a series into name
+func (c sliCounts) add(name string, totals []sliBucket) {
+	sum, ok := c[name]
+	if !ok {
+		sum = make([]sliBucket, len(totals))
+		c[name] = sum
+	}
+	for i, b := range totals {
+		sum[i].good += b.good
+		sum[i].bad += b.bad
+	}
+}
+
+// statuses turns window totals into SLIs checked against the target
+func (t *SLITracker) statuses(counts sliCounts) []SLIStatus {
+	list := make([]SLIStatus, 0, len(counts))
+	for name, totals := range counts {
+		status := SLIStatus{Name: name, Windows: make([]SLIWindow, len(t.windows))}
+		for i, b := range totals {
+			w := SLIWindow{Window: t.windows[i].String(), Requests: b.good + b.bad, Errors: b.bad, SuccessRate: 1}
+			if w.Requests > 0 {
+				w.SuccessRate = float64(b.good) / float64(w.Requests)
+			}
+			w.BurnRate = (1 - w.SuccessRate) / (1 - t.target)
+			w.BudgetRemaining = 1 - w.BurnRate
+			w.Compliant = w.SuccessRate >= t.target
+			status.Windows[i] = w
+		}
+		list = append(list, status)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// refreshLoop recomputes the SLI gauges until Stop is called
+func (t *SLITracker) refreshLoop() {
+	ticker := time.NewTicker(sliRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stopChan:
+			return
+		case <-ticker.C:
+			t.refreshGauges()
+		}
+	}
+}
+
+// refreshGauges publishes the current SLIs as gauges
+func (t *SLITracker) refreshGauges() {
+	endpoints, models := t.Snapshot()
+	for _, status := range endpoints {
+		for _, w := range status.Windows {
+			endpointSuccessRatio.Set(w.SuccessRate, status.Name, w.Window)
+			endpointBurnRate.Set(w.BurnRate, status.Name, w.Window)
+		}
+	}
+	for _, status := range models {
+		for _, w := range status.Windows {
+			modelSuccessRatio.Set(w.SuccessRate, status.Name, w.Window)
+			modelBurnRate.Set(w.BurnRate, status.Name, w.Window)
+		}
+	}
+}
+
+// HandleAdmin handles GET /admin/slo
+// Reports the SLIs per endpoint and per model and whether they meet SLO_TARGET
+func (t *SLITracker) HandleAdmin(w http.ResponseWriter, r *http.Request) {
+	endpoints, models := t.Snapshot()
+	compliant := true
+	for _, status := range append(endpoints, models...) {
+		for _, window := range status.Windows {
+			compliant = compliant && window.Compliant
+		}
+	}
+	windows := make([]string, len(t.windows))
+	for i, window := range t.windows {
+		windows[i] = window.String()
+	}
+	writeAdminJSON(w, r, map[string]interface{}{
+		"target":    t.target,
+		"windows":   windows,
+		"compliant": compliant,
+		"endpoints": endpoints,
+		"models":    models,
+	})
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cursor2api/clock"
+)
+
+func TestSLITracker_SuccessRates(t *testing.T) {
+	tracker := NewSLITracker(0.9, []time.Duration{time.Hour, 5 * time.Minute})
+	defer tracker.Stop()
+	fake := clock.NewFake(time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC))
+	tracker.clock = fake
+
+	serve := func(status int, streamFailed bool) {
+		handler := tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			SetSLIModel(r.Context(), "openai/gpt-5")
+			if streamFailed {
+				MarkSLIFailed(r.Context())
+			}
+			w.WriteHeader(status)
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), chatRequest("{}"))
+	}
+
+	for range 8 {
+		serve(http.StatusOK, false)
+	}
+	serve(http.StatusBadGateway, false)
+	serve(http.StatusOK, true) // A stream ending with an error event
+	serve(http.StatusTooManyRequests, false)
+	serve(http.StatusBadRequest, false)
+
+	// Requests without SLIs are not counted
+	admin := tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	admin.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/admin/slo", nil))
+
+	endpoints, byModel := tracker.Snapshot()
+	if len(endpoints) != 1 || endpoints[0].Name != "/v1/chat/completions" || len(byModel) != 1 || byModel[0].Name != "openai/gpt-5" {
+		t.Fatalf("endpoints = %+v, models = %+v", endpoints, byModel)
+	}
+	short := endpoints[0].Windows[0]
+	if short.Window != "5m0s" || short.Requests != 10 || short.Errors != 2 || short.SuccessRate != 0.8 || short.Compliant {
+		t.Errorf("5m window = %+v", short)
+	}
+	if burn := short.BurnRate; burn < 1.99 || burn > 2.01 {
+		t.Errorf("burn rate = %v, want 2", burn)
+	}
+
+	// The errors leave the short window first
+	fake.Advance(10 * time.Minute)
+	serve(http.StatusOK, false)
+	endpoints, _ = tracker.Snapshot()
+	if short, long := endpoints[0].Windows[0], endpoints[0].Windows[1]; short.Requests != 1 || !short.Compliant || long.Requests != 11 || long.Errors != 2 {
+		t.Errorf("windows after 10m = %+v", endpoints[0].Windows)
+	}
+}
+
+func TestSLIEndpoint(t *testing.T) {
+	for path, want := range map[string]string{
+		"/v1/chat/completions":                              "/v1/chat/completions",
+		"/v1/chat/completions/chatcmpl-1/cancel":            "/v1/chat/completions/{id}/cancel",
+		"/v1beta/models/openai/gpt-5:streamGenerateContent": "/v1beta/models/{model}:streamGenerateContent",
+		"/v1beta/models/gemini-2.5-pro:generateContent":     "/v1beta/models/{model}:generateContent",
+		"/health":    "",
+		"/admin/slo": "",
+	} {
+		if got := sliEndpoint(path); got != want {
+			t.Errorf("sliEndpoint(%s) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestSLITracker_Disabled(t *testing.T) {
+	tracker := NewSLITracker(0.99, nil)
+	if tracker != nil {
+		t.Fatal("tracker without windows should be nil")
+	}
+	tracker.Stop()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	if handler := tracker.Middleware(next); handler == nil {
+		t.Error("nil tracker must pass requests through")
+	}
+}
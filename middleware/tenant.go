@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"cursor2api/errdocs"
+	"cursor2api/logger"
+	"cursor2api/metrics"
+	"cursor2api/tenant"
+	"cursor2api/types"
+)
+
+var tenantRequests = metrics.NewCounter(
+	"cursor2api_tenant_requests_total",
+	"API requests by tenant and result (ok, rate_limited, rejected).",
+	"tenant", "result")
+
+// Tenants assigns API requests to a tenant and enforces the tenant rate limit.
+// It must run after APIKeyAuth so the authenticated key is known; a nil registry
+// disables multi-tenancy.
+func Tenants(registry *tenant.Registry, next http.Handler) http.Handler {
+	if registry == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/ready" || r.URL.Path == "/metrics" || isAdminPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		apiKey := APIKeyFromContext(r.Context())
+		t, err := registry.Resolve(apiKey, r.Header.Get(registry.Header()))
+		switch {
+		case errors.Is(err, tenant.ErrTenantMismatch):
+			logger.Warn("Tenant mismatch | masked_key=%s requested=%s client_ip=%s", maskAPIKey(apiKey), r.Header.Get(registry.Header()), getClientIP(r))
+			tenantRequests.Inc("none", "rejected")
+			respondTenantError(w, r, http.StatusForbidden, "permission_error", "tenant_mismatch", err.Error())
+			return
+		case err != nil:
+			tenantRequests.Inc("none", "rejected")
+			respondTenantError(w, r, http.StatusBadRequest, "invalid_request_error", "unknown_tenant", err.Error())
+			return
+		case t == nil:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !t.Allow() {
+			logger.Warn("Tenant rate limit exceeded | tenant=%s client_ip=%s path=%s", t.Name, getClientIP(r), r.URL.Path)
+			tenantRequests.Inc(t.Name, "rate_limited")
+			w.Header().Set("Retry-After", "1")
+			respondTenantError(w, r, http.StatusTooManyRequests, "rate_limit_error", "rate_limit_exceeded",
+				"Rate limit of tenant "+t.Name+" exceeded. Please retry shortly.")
+			return
+		}
+
+		tenantRequests.Inc(t.Name, "ok")
+		next.ServeHTTP(w, r.WithContext(tenant.WithTenant(r.Context(), t)))
+	})
+}
+
+// respondTenantError sends an OpenAI-compatible error response for tenant checks
+func respondTenantError(w http.ResponseWriter, r *http.Request, status int, errType, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	errResp := types.OpenAIErrorResponse{
+		Error: types.OpenAIError{
+			Message: message,
+			Type:    errType,
+			Code:    code,
+		},
+	}
+
+	errResp.Error.Hint, errResp.Error.DocURL = errdocs.Annotate(errResp.Error.Code, "")
+	if err := types.WriteJSON(w, errResp); err != nil {
+		logger.Error("Failed to write error response | error=%v client_ip=%s", err, getClientIP(r))
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"cursor2api/tracing"
+)
+
+// Tracing starts the server span of every request, continuing the W3C traceparent
+// of the caller. The router renames the span after the matched route; handlers and
+// services add child spans through the request context. A nil tracer disables
+// tracing.
+func Tracing(tracer *tracing.Tracer, next http.Handler) http.Handler {
+	if tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracer.StartRequest(r.Context(), r.Method, r.Header.Get("traceparent"))
+		if span == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer span.End()
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("client.address", getClientIP(r))
+		span.SetAttribute("user_agent.original", r.UserAgent())
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttribute("http.response.status_code", status)
+		if status >= http.StatusInternalServerError {
+			span.Fail(fmt.Errorf("HTTP %d", status))
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"cursor2api/types"
+)
+
+// 错误分类
+const (
+	ErrorCategoryDownload       = "js_download"     // 下载 JS 失败
+	ErrorCategoryProcess        = "process"         // 处理服务调用失败
+	ErrorCategoryNotInitialized = "not_initialized" // 参数尚未初始化
+	ErrorCategoryUnknown        = "unknown"
+)
+
+// 错误来源
+const (
+	errorSourceStartup  = "startup"   // 启动时初始化
+	errorSourceLoop     = "loop"      // 定时刷新循环
+	errorSourceOnDemand = "on_demand" // 请求触发的强制刷新
+)
+
+// defaultErrorHistorySize 默认保留的错误条数
+const defaultErrorHistorySize = 50
+
+// categorizedError 带分类的刷新错误
+type categorizedError struct {
+	category string
+	err      error
+}
+
+func (e *categorizedError) Error() string { return e.err.Error() }
+func (e *categorizedError) Unwrap() error { return e.err }
+
+// errorCategory 返回错误链中的分类
+func errorCategory(err error) string {
+	var ce *categorizedError
+	if errors.As(err, &ce) {
+		return ce.category
+	}
+	return ErrorCategoryUnknown
+}
+
+// errorHistory 固定容量的错误环形缓冲区,自带锁,可在任意锁状态下调用
+type errorHistory struct {
+	mu      sync.Mutex
+	entries []types.ManagerError
+	next    int
+	full    bool
+	counts  map[string]int64
+}
+
+// newErrorHistory 创建容量为 size 的错误历史
+func newErrorHistory(size int) *errorHistory {
+	if size <= 0 {
+		size = defaultErrorHistorySize
+	}
+	return &errorHistory{
+		entries: make([]types.ManagerError, size),
+		counts:  make(map[string]int64),
+	}
+}
+
+// add 记录一条错误,覆盖最旧的条目
+func (h *errorHistory) add(source string, err error) {
+	entry := types.ManagerError{
+		Time:     time.Now(),
+		Category: errorCategory(err),
+		Source:   source,
+		Message:  err.Error(),
+	}
+
+	h.mu.Lock()
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+	h.counts[entry.Category]++
+	h.mu.Unlock()
+}
+
+// recent 返回错误历史副本,最新的在前
+func (h *errorHistory) recent() []types.ManagerError {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := h.next
+	if h.full {
+		n = len(h.entries)
+	}
+	list := make([]types.ManagerError, 0, n)
+	for i := 1; i <= n; i++ {
+		list = append(list, h.entries[(h.next-i+len(h.entries))%len(h.entries)])
+	}
+	return list
+}
+
+// countsByCategory 返回各分类的累计错误次数
+func (h *errorHistory) countsByCategory() map[string]int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts := make(map[string]int64, len(h.counts))
+	for k, v := range h.counts {
+		counts[k] = v
+	}
+	return counts
+}
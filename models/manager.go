@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"github.com/imroc/req/v3"
+
+	"cursor2api/clock"
 )
 
 // AntiBotManager Vercel BotID 参数动态管理器
@@ -14,11 +16,16 @@ type AntiBotManager struct {
 	mu         sync.RWMutex
 	client     *req.Client
 	jsURL      string
+	jsReferer  string
 	processURL string
 
 	// 缓存数据
 	currentXIsHuman string
 	jsCode          string
+	jsHash          string    // 脚本内容 SHA-256
+	jsETag          string    // 用于条件请求的 ETag
+	jsLastModified  string    // 用于条件请求的 Last-Modified
+	lastSolveTime   time.Time // 最后一次调用求解服务的时间
 	lastUpdateTime  time.Time
 	lastAccessTime  time.Time // 最后一次访问时间
 
@@ -26,25 +33,50 @@ type AntiBotManager struct {
 	refreshInterval time.Duration
 	maxRetries      int
 	idleTimeout     time.Duration // 空闲超时时间(超过此时间停止刷新)
+	solverCacheTTL  time.Duration // 脚本未变化时复用求解结果的时长(0 表示每次都调用求解服务)
+	clock           clock.Clock   // 参数过期、空闲与求解缓存判断使用的时钟(测试中可替换)
 
 	// 控制通道
 	ctx    context.Context
 	cancel context.CancelFunc
 
 	// 刷新控制
+	starting      bool          // 首次刷新在后台重试中(此期间请求不触发强制刷新)
+	maxBackoff    time.Duration // 后台启动重试的最大退避时间
 	refreshActive bool          // 刷新循环是否活跃
 	wakeupChan    chan struct{} // 唤醒信号
 
+	// 暂停控制(例如上游维护期间避免消耗求解调用)
+	paused      bool
+	pausedAt    time.Time
+	pauseReason string
+
+	// 多副本共享参数(未启用时为 nil)
+	shared *sharedTokens
+
+	// 降级策略: 求解服务不可用时是否继续使用缓存的参数(未设置时从不使用)
+	stalePolicy    StaleTokenPolicy
+	lastRefreshErr error // 最近一次刷新的错误,成功后清空
+
 	// 统计信息
 	stats ManagerStats
 }
 
+// StaleTokenPolicy 决定求解服务不可用时是否继续使用指定年龄的缓存参数
+type StaleTokenPolicy interface {
+	ServeStaleToken(age time.Duration) bool
+}
+
 // ManagerStats 管理器统计信息
-// All int64 fields use atomic operations for thread-safety
+// All int64 fields use atomic operations for thread-safety;
+// Errors has its own lock and is safe to use regardless of AntiBotManager.mu
 type ManagerStats struct {
 	TotalRequests   atomic.Int64
 	SuccessRequests atomic.Int64
 	FailedRequests  atomic.Int64
 	CacheHits       atomic.Int64
-	LastError       error // Protected by AntiBotManager.mu
+	JSNotModified   atomic.Int64 // 脚本未变化(304 或内容哈希相同)的次数
+	SolverCalls     atomic.Int64
+	SolverSkips     atomic.Int64 // 复用求解结果而跳过求解服务的次数
+	Errors          *errorHistory
 }
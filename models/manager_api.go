@@ -7,24 +7,32 @@ import (
 	"time"
 
 	"github.com/imroc/req/v3"
-	utls "github.com/refraction-networking/utls"
+
+	"cursor2api/clock"
+	"cursor2api/config"
 )
 
 // NewAntiBotManager 创建新的 Vercel BotID 管理器
-func NewAntiBotManager(jsURL, processURL string, refreshInterval, idleTimeout time.Duration) *AntiBotManager {
+// client 由调用方构建,以便与 CursorService 共享连接池配置
+func NewAntiBotManager(cfg config.CursorConfig, client *req.Client) *AntiBotManager {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &AntiBotManager{
-		client:          req.C().ImpersonateChrome().SetTLSFingerprint(utls.HelloChrome_131),
-		jsURL:           jsURL,
-		processURL:      processURL,
-		refreshInterval: refreshInterval,
+		client:          client,
+		jsURL:           cfg.JSURL,
+		jsReferer:       cfg.JSReferer,
+		processURL:      cfg.ProcessURL,
+		refreshInterval: cfg.RefreshInterval,
 		maxRetries:      3,
-		idleTimeout:     idleTimeout,
+		idleTimeout:     cfg.IdleTimeout,
+		maxBackoff:      cfg.StartupMaxBackoff,
+		solverCacheTTL:  cfg.SolverCacheTTL,
+		clock:           clock.System,
 		ctx:             ctx,
 		cancel:          cancel,
 		refreshActive:   false,
 		wakeupChan:      make(chan struct{}, 1), // 带缓冲的通道避免阻塞
+		stats:           ManagerStats{Errors: newErrorHistory(cfg.ErrorHistorySize)},
 	}
 }
 
@@ -33,9 +41,11 @@ func (m *AntiBotManager) Start() error {
 	log.Println("🚀 启动 Vercel BotID 管理器")
 
 	// 初始化访问时间
-	m.lastAccessTime = time.Now()
+	m.lastAccessTime = m.clock.Now()
+	m.startSharedToken()
 
 	if err := m.refreshParameters(); err != nil {
+		m.stats.Errors.add(errorSourceStartup, err)
 		return fmt.Errorf("初始化参数失败: %w", err)
 	}
 
@@ -45,20 +55,72 @@ func (m *AntiBotManager) Start() error {
 	return nil
 }
 
+// StartAsync 启动管理器但不等待首次刷新成功
+// 首次刷新失败时以指数退避在后台重试,成功后进入自动刷新循环;期间 IsReady 返回 false
+func (m *AntiBotManager) StartAsync() {
+	log.Println("🚀 启动 Vercel BotID 管理器 (后台初始化)")
+
+	m.mu.Lock()
+	m.lastAccessTime = m.clock.Now()
+	m.starting = true
+	m.mu.Unlock()
+	m.startSharedToken()
+
+	go func() {
+		backoff := time.Second
+		for attempt := 1; ; attempt++ {
+			err := m.refreshParameters()
+			if err == nil {
+				break
+			}
+			m.stats.Errors.add(errorSourceStartup, err)
+			log.Printf("❌ 初始化参数失败 (第 %d 次), %v 后重试: %v", attempt, backoff, err)
+
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if m.maxBackoff > 0 && backoff > m.maxBackoff {
+				backoff = m.maxBackoff
+			}
+		}
+
+		m.mu.Lock()
+		m.starting = false
+		m.mu.Unlock()
+
+		log.Printf("✅ 参数管理器初始化成功，刷新间隔: %v, 空闲超时: %v", m.refreshInterval, m.idleTimeout)
+		m.autoRefreshLoop()
+	}()
+}
+
 // Stop 停止管理器
 func (m *AntiBotManager) Stop() {
 	log.Println("🛑 停止 Vercel BotID 管理器")
 	m.cancel()
+	if m.shared != nil {
+		m.shared.release()
+	}
 }
 
 // GetXIsHuman 获取当前有效的 x-is-human 参数
-func (m *AntiBotManager) GetXIsHuman() (string, error) {
+func (m *AntiBotManager) GetXIsHuman() (_ string, err error) {
 	m.stats.TotalRequests.Add(1)
 
+	start := time.Now()
+	defer func() {
+		getDuration.Observe(time.Since(start).Seconds(), resultLabel(err))
+	}()
+
 	m.mu.RLock()
+	if wait := time.Since(start); wait > lockWaitThreshold {
+		refreshWait.Observe(wait.Seconds(), "lock")
+	}
 
 	// 更新最后访问时间
-	m.lastAccessTime = time.Now()
+	m.lastAccessTime = m.clock.Now()
 
 	// 唤醒休眠的刷新循环
 	if !m.refreshActive {
@@ -77,18 +139,27 @@ func (m *AntiBotManager) GetXIsHuman() (string, error) {
 		m.mu.RLock()
 	}
 
-	// 检查参数是否过期
-	if time.Since(m.lastUpdateTime) > 28*time.Second {
+	// 检查参数是否过期(暂停、后台初始化期间或已降级为使用缓存参数时不强制刷新)
+	if !m.paused && !m.starting && m.clock.Since(m.lastUpdateTime) > 28*time.Second && !m.servingStaleUnsafe() {
 		m.mu.RUnlock()
+		waitStart := time.Now()
 		m.mu.Lock()
-		if time.Since(m.lastUpdateTime) > 28*time.Second {
+		if !m.paused && !m.starting && m.clock.Since(m.lastUpdateTime) > 28*time.Second && !m.servingStaleUnsafe() {
 			log.Println("⚠️ 参数即将过期，强制刷新")
-			if err := m.refreshParametersUnsafe(); err != nil {
-				m.stats.FailedRequests.Add(1)
-				m.stats.LastError = err
-				m.mu.Unlock()
-				return "", fmt.Errorf("强制刷新参数失败: %w", err)
+			err := m.refreshParametersUnsafe()
+			refreshWait.Observe(time.Since(waitStart).Seconds(), "forced")
+			if err != nil {
+				m.stats.Errors.add(errorSourceOnDemand, err)
+				if !m.servingStaleUnsafe() {
+					m.stats.FailedRequests.Add(1)
+					m.mu.Unlock()
+					return "", fmt.Errorf("强制刷新参数失败: %w", err)
+				}
+				log.Printf("🪫 求解服务不可用,降级使用缓存参数 (参数年龄: %v)", m.clock.Since(m.lastUpdateTime).Round(time.Second))
 			}
+		} else if wait := time.Since(waitStart); wait > lockWaitThreshold {
+			// 另一个请求已完成刷新,本请求只是等待了锁
+			refreshWait.Observe(wait.Seconds(), "lock")
 		}
 		m.mu.Unlock()
 		m.mu.RLock()
@@ -97,10 +168,13 @@ func (m *AntiBotManager) GetXIsHuman() (string, error) {
 	if m.currentXIsHuman == "" {
 		m.mu.RUnlock()
 		m.stats.FailedRequests.Add(1)
-		return "", fmt.Errorf("参数未初始化")
+		err := &categorizedError{category: ErrorCategoryNotInitialized, err: fmt.Errorf("参数未初始化")}
+		m.stats.Errors.add(errorSourceOnDemand, err)
+		return "", err
 	}
 
 	result := m.currentXIsHuman
+	tokenAge.Observe(m.clock.Since(m.lastUpdateTime).Seconds())
 	m.mu.RUnlock()
 	
 	m.stats.SuccessRequests.Add(1)
@@ -108,44 +182,135 @@ func (m *AntiBotManager) GetXIsHuman() (string, error) {
 	return result, nil
 }
 
+// servingStaleUnsafe 报告最近一次刷新失败后是否按降级策略继续使用缓存参数(调用方需持有锁)
+func (m *AntiBotManager) servingStaleUnsafe() bool {
+	if m.stalePolicy == nil || m.lastRefreshErr == nil || m.currentXIsHuman == "" {
+		return false
+	}
+	return m.stalePolicy.ServeStaleToken(m.clock.Since(m.lastUpdateTime))
+}
+
+// UseStaleTokenPolicy 设置求解服务不可用时的降级策略(nil 表示从不使用缓存参数)
+func (m *AntiBotManager) UseStaleTokenPolicy(policy StaleTokenPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stalePolicy = policy
+}
+
+// TokenState 返回参数年龄、是否已有参数以及最近一次刷新的错误(成功时为 nil)
+func (m *AntiBotManager) TokenState() (age time.Duration, ok bool, refreshErr error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.currentXIsHuman != "" {
+		age, ok = m.clock.Since(m.lastUpdateTime), true
+	}
+	return age, ok, m.lastRefreshErr
+}
+
+// PauseRefresh 暂停参数刷新(定时刷新与按需强制刷新),返回是否发生了状态变化
+func (m *AntiBotManager) PauseRefresh(reason string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.paused {
+		return false
+	}
+	m.paused = true
+	m.pausedAt = m.clock.Now()
+	m.pauseReason = reason
+	log.Printf("⏸️  参数刷新已暂停 (原因: %s)", reason)
+	return true
+}
+
+// ResumeRefresh 恢复参数刷新,返回是否发生了状态变化
+func (m *AntiBotManager) ResumeRefresh() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.paused {
+		return false
+	}
+	log.Printf("▶️  参数刷新已恢复 (暂停时长: %v)", m.clock.Since(m.pausedAt).Round(time.Second))
+	m.paused = false
+	m.pausedAt = time.Time{}
+	m.pauseReason = ""
+	return true
+}
+
+// IsRefreshPaused 返回参数刷新是否处于暂停状态
+func (m *AntiBotManager) IsRefreshPaused() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.paused
+}
+
+// IsReady 检查管理器是否已获取到参数(可以处理请求)
+func (m *AntiBotManager) IsReady() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.currentXIsHuman != ""
+}
+
 // IsHealthy 检查管理器是否健康
 func (m *AntiBotManager) IsHealthy() bool {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.currentXIsHuman != "" && time.Since(m.lastUpdateTime) < 30*time.Second
+	return m.currentXIsHuman != "" && m.clock.Since(m.lastUpdateTime) < 30*time.Second
 }
 
 // GetStats 获取统计信息
 func (m *AntiBotManager) GetStats() map[string]interface{} {
 	m.mu.RLock()
 	lastUpdateTime := m.lastUpdateTime
+	lastSolveTime := m.lastSolveTime
 	lastAccessTime := m.lastAccessTime
 	refreshInterval := m.refreshInterval
 	idleTimeout := m.idleTimeout
 	refreshActive := m.refreshActive
 	hasValidParameter := m.currentXIsHuman != ""
-	lastError := m.stats.LastError
+	starting := m.starting
+	paused := m.paused
+	pausedAt := m.pausedAt
+	pauseReason := m.pauseReason
 	m.mu.RUnlock()
 
-	idleTime := time.Since(lastAccessTime)
+	idleTime := m.clock.Since(lastAccessTime)
 
 	stats := map[string]interface{}{
 		"totalRequests":     m.stats.TotalRequests.Load(),
 		"successRequests":   m.stats.SuccessRequests.Load(),
 		"failedRequests":    m.stats.FailedRequests.Load(),
 		"cacheHits":         m.stats.CacheHits.Load(),
+		"jsNotModified":     m.stats.JSNotModified.Load(),
+		"solverCalls":       m.stats.SolverCalls.Load(),
+		"solverSkips":       m.stats.SolverSkips.Load(),
+		"lastSolveTime":     lastSolveTime,
 		"lastUpdateTime":    lastUpdateTime,
 		"lastAccessTime":    lastAccessTime,
-		"parameterAge":      time.Since(lastUpdateTime),
+		"parameterAge":      m.clock.Since(lastUpdateTime),
 		"idleTime":          idleTime,
 		"refreshInterval":   refreshInterval,
 		"idleTimeout":       idleTimeout,
 		"refreshActive":     refreshActive,
 		"hasValidParameter": hasValidParameter,
+		"refreshPaused":     paused,
+		"starting":          starting,
+	}
+
+	if paused {
+		stats["pausedAt"] = pausedAt
+		stats["pauseReason"] = pauseReason
+	}
+
+	if shared := m.sharedTokenStats(); shared != nil {
+		stats["sharedToken"] = shared
 	}
 
-	if lastError != nil {
-		stats["lastError"] = lastError.Error()
+	if recentErrors := m.stats.Errors.recent(); len(recentErrors) > 0 {
+		stats["lastError"] = recentErrors[0].Message
+		stats["lastErrorTime"] = recentErrors[0].Time
+		stats["recentErrors"] = recentErrors
+		stats["errorCounts"] = m.stats.Errors.countsByCategory()
 	}
 
 	return stats
@@ -1,9 +1,12 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"time"
 
 	"cursor2api/types"
@@ -30,12 +33,16 @@ func (m *AntiBotManager) autoRefreshLoop() {
 			m.mu.Lock()
 
 			// 检查是否超过空闲时间
-			idleTime := time.Since(m.lastAccessTime)
+			idleTime := m.clock.Since(m.lastAccessTime)
 			if idleTime > m.idleTimeout {
 				m.refreshActive = false
 				m.mu.Unlock()
 
 				log.Printf("😴 超过 %v 无请求,进入休眠模式", m.idleTimeout)
+				if m.shared != nil {
+					// 休眠期间不再刷新,让仍有流量的实例接管
+					m.shared.release()
+				}
 
 				// 进入休眠,等待唤醒信号
 				select {
@@ -51,11 +58,18 @@ func (m *AntiBotManager) autoRefreshLoop() {
 				continue
 			}
 
+			// 暂停期间跳过刷新
+			if m.paused {
+				m.mu.Unlock()
+				log.Printf("⏸️  参数刷新已暂停,跳过本次定时刷新")
+				continue
+			}
+
 			// 正常刷新流程
 			log.Printf("🔄 开始定时刷新参数 (上次访问: %v 前)", idleTime.Round(time.Second))
 			if err := m.refreshParametersUnsafe(); err != nil {
 				log.Printf("❌ 定时刷新失败: %v", err)
-				m.stats.LastError = err
+				m.stats.Errors.add(errorSourceLoop, err)
 			} else {
 				log.Println("✅ 定时刷新成功")
 			}
@@ -72,7 +86,17 @@ func (m *AntiBotManager) refreshParameters() error {
 }
 
 // refreshParametersUnsafe 刷新参数（无锁版本）
-func (m *AntiBotManager) refreshParametersUnsafe() error {
+func (m *AntiBotManager) refreshParametersUnsafe() (err error) {
+	start := time.Now()
+	defer func() {
+		refreshDuration.Observe(time.Since(start).Seconds(), resultLabel(err))
+		m.lastRefreshErr = err
+	}()
+
+	if m.shared != nil && m.adoptSharedTokenUnsafe() {
+		return nil
+	}
+
 	var lastErr error
 
 	for attempt := 1; attempt <= m.maxRetries; attempt++ {
@@ -80,9 +104,9 @@ func (m *AntiBotManager) refreshParametersUnsafe() error {
 			log.Printf("🔁 第 %d 次重试刷新参数", attempt)
 		}
 
-		jsCode, err := m.downloadJS()
+		script, err := m.downloadJS()
 		if err != nil {
-			lastErr = fmt.Errorf("下载JS失败: %w", err)
+			lastErr = &categorizedError{category: ErrorCategoryDownload, err: fmt.Errorf("下载JS失败: %w", err)}
 			if attempt < m.maxRetries {
 				time.Sleep(time.Duration(attempt) * time.Second)
 				continue
@@ -90,9 +114,25 @@ func (m *AntiBotManager) refreshParametersUnsafe() error {
 			break
 		}
 
-		xIsHuman, err := m.getXIsHuman(jsCode)
+		// 脚本未变化且求解结果仍在复用窗口内,跳过求解服务调用
+		unchanged := script.hash == m.jsHash && m.currentXIsHuman != ""
+		if unchanged {
+			m.stats.JSNotModified.Add(1)
+		} else if m.jsHash != "" {
+			log.Printf("📜 检测到脚本变化 (%s -> %s)", m.jsHash[:12], script.hash[:12])
+		}
+		if unchanged && m.solverCacheTTL > 0 && m.clock.Since(m.lastSolveTime) < m.solverCacheTTL {
+			m.stats.SolverSkips.Add(1)
+			m.lastUpdateTime = m.clock.Now()
+			log.Printf("♻️  脚本未变化,复用求解结果 (求解于 %v 前)", m.clock.Since(m.lastSolveTime).Round(time.Second))
+			m.publishSharedTokenUnsafe()
+			return nil
+		}
+
+		m.stats.SolverCalls.Add(1)
+		xIsHuman, err := m.getXIsHuman(script.code)
 		if err != nil {
-			lastErr = fmt.Errorf("获取参数失败: %w", err)
+			lastErr = &categorizedError{category: ErrorCategoryProcess, err: fmt.Errorf("获取参数失败: %w", err)}
 			if attempt < m.maxRetries {
 				time.Sleep(time.Duration(attempt) * time.Second)
 				continue
@@ -100,11 +140,17 @@ func (m *AntiBotManager) refreshParametersUnsafe() error {
 			break
 		}
 
-		m.jsCode = jsCode
+		// 仅在求解成功后记录脚本与缓存校验信息,避免 304 复用未成功求解的脚本
+		m.jsCode = script.code
+		m.jsHash = script.hash
+		m.jsETag = script.etag
+		m.jsLastModified = script.lastModified
 		m.currentXIsHuman = xIsHuman
-		m.lastUpdateTime = time.Now()
+		m.lastUpdateTime = m.clock.Now()
+		m.lastSolveTime = m.lastUpdateTime
 
 		log.Printf("✨ 参数刷新成功 (长度: %d)", len(xIsHuman))
+		m.publishSharedTokenUnsafe()
 		return nil
 	}
 
@@ -112,23 +158,52 @@ func (m *AntiBotManager) refreshParametersUnsafe() error {
 	return fmt.Errorf("重试 %d 次后仍然失败: %w", m.maxRetries, lastErr)
 }
 
-// downloadJS 下载 JavaScript 文件
-func (m *AntiBotManager) downloadJS() (string, error) {
-	resp, err := m.client.R().SetHeader("referer", "https://cursor.com/cn/learn").Get(m.jsURL)
+// jsScript 下载得到的脚本及其缓存校验信息
+type jsScript struct {
+	code         string
+	hash         string // 内容 SHA-256
+	etag         string
+	lastModified string
+}
+
+// downloadJS 下载 JavaScript 文件(调用方需持有锁)
+// 已有缓存时使用 ETag/Last-Modified 条件请求,304 时直接返回缓存脚本
+func (m *AntiBotManager) downloadJS() (jsScript, error) {
+	r := m.client.R().SetHeader("referer", m.jsReferer)
+	if m.jsCode != "" {
+		if m.jsETag != "" {
+			r.SetHeader("If-None-Match", m.jsETag)
+		}
+		if m.jsLastModified != "" {
+			r.SetHeader("If-Modified-Since", m.jsLastModified)
+		}
+	}
+
+	resp, err := r.Get(m.jsURL)
 	if err != nil {
-		return "", fmt.Errorf("请求失败: %w", err)
+		return jsScript{}, fmt.Errorf("请求失败: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotModified && m.jsCode != "" {
+		return jsScript{code: m.jsCode, hash: m.jsHash, etag: m.jsETag, lastModified: m.jsLastModified}, nil
 	}
 
 	if !resp.IsSuccessState() {
-		return "", fmt.Errorf("HTTP状态码错误: %d", resp.StatusCode)
+		return jsScript{}, fmt.Errorf("HTTP状态码错误: %d", resp.StatusCode)
 	}
 
 	bodyContent := resp.String()
 	if len(bodyContent) < 1000 {
-		return "", fmt.Errorf("JS文件内容异常，大小: %d", len(bodyContent))
+		return jsScript{}, fmt.Errorf("JS文件内容异常，大小: %d", len(bodyContent))
 	}
 
-	return bodyContent, nil
+	sum := sha256.Sum256([]byte(bodyContent))
+	return jsScript{
+		code:         bodyContent,
+		hash:         hex.EncodeToString(sum[:]),
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}, nil
 }
 
 // getXIsHuman 从本地接口获取动态参数
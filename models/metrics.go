@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"cursor2api/metrics"
+)
+
+// lockWaitThreshold 超过该时长的锁等待才记为"等待刷新"
+const lockWaitThreshold = time.Millisecond
+
+var (
+	getDuration = metrics.NewHistogram(
+		"cursor2api_antibot_get_duration_seconds",
+		"Latency of GetXIsHuman calls, including any wait on a refresh.",
+		metrics.DefBuckets, "result")
+
+	refreshWait = metrics.NewHistogram(
+		"cursor2api_antibot_refresh_wait_seconds",
+		"Time requests spent waiting on a refresh: blocked behind an in-progress refresh (lock) or triggering one (forced).",
+		[]float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}, "reason")
+
+	tokenAge = metrics.NewHistogram(
+		"cursor2api_antibot_token_age_seconds",
+		"Age of the x-is-human parameter when it was handed to a request.",
+		[]float64{1, 2, 5, 10, 15, 20, 25, 28, 30, 45, 60, 120, 300})
+
+	refreshDuration = metrics.NewHistogram(
+		"cursor2api_antibot_refresh_duration_seconds",
+		"Duration of AntiBot parameter refreshes including retries.",
+		[]float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}, "result")
+)
+
+// resultLabel 将错误转换为 result 标签值
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
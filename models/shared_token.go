@@ -0,0 +1,366 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"cursor2api/metrics"
+	"cursor2api/redis"
+)
+
+const (
+	// sharedTokenMaxAge 超过该时长的共享参数不再采用,与 GetXIsHuman 的强制刷新阈值一致
+	// 年龄由发布者的 UpdatedAt 与本地时钟相减得到,假设副本间时钟已同步 (NTP);
+	// 时钟偏差会原样计入年龄,本地时钟落后时参数显得更新,超前时更早回退到本地求解
+	sharedTokenMaxAge = 28 * time.Second
+	// sharedTokenRefreshAge leader 的参数达到该年龄时提前刷新并发布,
+	// 使 follower 在 sharedTokenMaxAge 之前就收到新参数,不会同时回退到本地求解
+	sharedTokenRefreshAge = 20 * time.Second
+	// sharedTokenSyncInterval 后台协调 (续期锁、读取共享参数、leader 提前刷新) 的周期
+	sharedTokenSyncInterval = 5 * time.Second
+	// sharedTokenTimeout 单次 Redis 协调操作的超时
+	sharedTokenTimeout = 2 * time.Second
+)
+
+// renewScript 仅在锁仍属于本实例时续期
+const renewScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) else return 0 end`
+
+// releaseScript 仅在锁仍属于本实例时释放
+const releaseScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) else return 0 end`
+
+var sharedTokenEvents = metrics.NewCounter(
+	"cursor2api_antibot_shared_token_total",
+	"Shared-token coordination events: published (leader solved and shared), adopted (follower installed a newer shared token) or fallback (solved locally because Redis or the shared token was unavailable).",
+	"event")
+
+// sharedToken 通过 Redis 在副本之间共享的 x-is-human 参数
+type sharedToken struct {
+	Token     string    `json:"token"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Instance  string    `json:"instance"`
+}
+
+// sharedTokens 多副本共享参数的协调器
+// 持有 Redis 锁的实例 (leader) 负责下载脚本、调用求解服务并发布参数,其他实例订阅并直接采用,
+// 求解服务的负载因此不随副本数增长。Redis 访问都在后台进行,刷新时只读取缓存的角色与参数
+type sharedTokens struct {
+	client   *redis.Client
+	lockKey  string
+	tokenKey string
+	channel  string
+	instance string
+	lockTTL  time.Duration
+
+	leader      atomic.Bool
+	unavailable atomic.Bool                 // 最近一次 Redis 协调失败
+	newest      atomic.Pointer[sharedToken] // 收到的最新共享参数
+	pending     atomic.Pointer[sharedToken] // 等待发布的参数
+	publishNow  chan struct{}
+}
+
+// UseSharedToken 启用多副本共享参数模式,需在 Start/StartAsync 之前调用
+// lockTTL 决定 leader 失联或休眠后其他实例接管前的最长等待
+func (m *AntiBotManager) UseSharedToken(client *redis.Client, keyPrefix string, lockTTL time.Duration) {
+	m.shared = &sharedTokens{
+		client:   client,
+		lockKey:  keyPrefix + ":lock",
+		tokenKey: keyPrefix + ":token",
+		channel:  keyPrefix + ":updates",
+		instance: instanceID(),
+		lockTTL:  lockTTL,
+
+		publishNow: make(chan struct{}, 1),
+	}
+	log.Printf("🔗 共享参数模式已启用 (redis: %s, 实例: %s, 锁 TTL: %v)", client.Addr(), m.shared.instance, lockTTL)
+}
+
+// instanceID 生成本进程在锁与发布消息中使用的唯一标识
+func instanceID() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return host + "-" + hex.EncodeToString(b)
+}
+
+// acquire 获取或续期 leader 锁,返回本实例是否为 leader
+func (s *sharedTokens) acquire(ctx context.Context) (bool, error) {
+	ttl := strconv.FormatInt(s.lockTTL.Milliseconds(), 10)
+	reply, err := s.client.Do(ctx, "SET", s.lockKey, s.instance, "NX", "PX", ttl)
+	if err != nil {
+		return false, err
+	}
+	if reply == nil {
+		if reply, err = s.client.Do(ctx, "EVAL", renewScript, "1", s.lockKey, s.instance, ttl); err != nil {
+			return false, err
+		}
+	}
+
+	leader := reply == "OK" || reply == int64(1)
+	if s.leader.Swap(leader) != leader {
+		if leader {
+			log.Printf("👑 本实例成为共享参数 leader")
+		} else {
+			log.Printf("👥 本实例成为共享参数 follower")
+		}
+	}
+	return leader, nil
+}
+
+// release 释放本实例持有的 leader 锁,让其他实例立即接管
+func (s *sharedTokens) release() {
+	if !s.leader.Swap(false) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sharedTokenTimeout)
+	defer cancel()
+	if _, err := s.client.Do(ctx, "EVAL", releaseScript, "1", s.lockKey, s.instance); err != nil {
+		log.Printf("⚠️  释放共享参数锁失败: %v", err)
+		return
+	}
+	log.Printf("🔓 已释放共享参数 leader 锁")
+}
+
+// publish 保存并广播新参数,仅 leader 调用
+func (s *sharedTokens) publish(ctx context.Context, token sharedToken) error {
+	payload, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	ttl := strconv.FormatInt(s.lockTTL.Milliseconds(), 10)
+	if _, err := s.client.Do(ctx, "SET", s.tokenKey, string(payload), "PX", ttl); err != nil {
+		return err
+	}
+	_, err = s.client.Do(ctx, "PUBLISH", s.channel, string(payload))
+	return err
+}
+
+// latest 读取最近发布的参数,不存在时返回 nil
+func (s *sharedTokens) latest(ctx context.Context) (*sharedToken, error) {
+	reply, err := s.client.Do(ctx, "GET", s.tokenKey)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	payload, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected reply %T", reply)
+	}
+	var token sharedToken
+	if err := json.Unmarshal(payload, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// subscribe 持续接收其他实例发布的参数,连接断开后以退避重连,直到 ctx 取消
+func (s *sharedTokens) subscribe(ctx context.Context, handle func(sharedToken)) {
+	backoff := time.Second
+	for {
+		err := s.client.Subscribe(ctx, s.channel, func(payload []byte) {
+			backoff = time.Second
+			var token sharedToken
+			if err := json.Unmarshal(payload, &token); err != nil {
+				log.Printf("⚠️  共享参数消息解析失败: %v", err)
+				return
+			}
+			if token.Instance != s.instance {
+				handle(token)
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("⚠️  共享参数订阅中断, %v 后重连: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+// remember 记录收到的共享参数,只保留最新的一个
+func (s *sharedTokens) remember(token sharedToken) {
+	for {
+		current := s.newest.Load()
+		if current != nil && !token.UpdatedAt.After(current.UpdatedAt) {
+			return
+		}
+		if s.newest.CompareAndSwap(current, &token) {
+			return
+		}
+	}
+}
+
+// publishLoop 在后台发布 leader 的新参数,积压时只发布最新的一个
+func (s *sharedTokens) publishLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.publishNow:
+		}
+		token := s.pending.Swap(nil)
+		if token == nil {
+			continue
+		}
+		publishCtx, cancel := context.WithTimeout(ctx, sharedTokenTimeout)
+		err := s.publish(publishCtx, *token)
+		cancel()
+		if err != nil {
+			log.Printf("⚠️  发布共享参数失败: %v", err)
+			continue
+		}
+		sharedTokenEvents.Inc("published")
+	}
+}
+
+// startSharedToken 启动共享参数的订阅、发布与后台协调,未启用共享模式时为空操作
+// 首次协调同步完成,启动时的首次刷新因此已知本实例的角色与最近的共享参数
+func (m *AntiBotManager) startSharedToken() {
+	if m.shared == nil {
+		return
+	}
+	m.syncSharedToken()
+	go m.shared.subscribe(m.ctx, m.onSharedToken)
+	go m.shared.publishLoop(m.ctx)
+	go m.sharedTokenLoop()
+}
+
+// sharedTokenLoop 周期性续期或争取 leader 锁; leader 在参数达到 sharedTokenRefreshAge 时
+// 提前刷新并发布。休眠、暂停与后台初始化期间不争取锁,让仍有流量的实例接管
+func (m *AntiBotManager) sharedTokenLoop() {
+	ticker := time.NewTicker(sharedTokenSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		m.mu.RLock()
+		active := m.refreshActive && !m.paused && !m.starting
+		age := m.clock.Since(m.lastUpdateTime)
+		m.mu.RUnlock()
+		if !active || !m.syncSharedToken() || age < sharedTokenRefreshAge {
+			continue
+		}
+
+		log.Printf("🔄 leader 提前刷新共享参数 (参数年龄: %v)", age.Round(time.Second))
+		if err := m.refreshParameters(); err != nil {
+			log.Printf("❌ 提前刷新共享参数失败: %v", err)
+			m.stats.Errors.add(errorSourceLoop, err)
+		}
+	}
+}
+
+// syncSharedToken 续期或争取 leader 锁,follower 同时读取最近的共享参数以补上可能丢失的订阅消息
+// 结果缓存在 sharedTokens 中,刷新时无需在持有 m.mu 期间访问 Redis。返回本实例是否为 leader
+func (m *AntiBotManager) syncSharedToken() bool {
+	s := m.shared
+	ctx, cancel := context.WithTimeout(m.ctx, sharedTokenTimeout)
+	defer cancel()
+
+	leader, err := s.acquire(ctx)
+	if err == nil && !leader {
+		var token *sharedToken
+		if token, err = s.latest(ctx); token != nil {
+			s.remember(*token)
+		}
+	}
+	if err != nil {
+		if !s.unavailable.Swap(true) {
+			log.Printf("⚠️  共享参数协调失败,恢复前按需本地求解: %v", err)
+		}
+		return false
+	}
+	if s.unavailable.Swap(false) {
+		log.Printf("🔗 共享参数协调已恢复")
+	}
+	return leader
+}
+
+// onSharedToken 采用其他实例发布的、比当前更新的参数
+func (m *AntiBotManager) onSharedToken(token sharedToken) {
+	m.shared.remember(token)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if token.Token == "" || !token.UpdatedAt.After(m.lastUpdateTime) {
+		return
+	}
+	m.installSharedTokenUnsafe(token)
+}
+
+// adoptSharedTokenUnsafe 共享模式下决定本次刷新是否可以直接采用共享参数(调用方需持有锁)
+// 只使用后台协调缓存的角色与共享参数,持有锁期间不访问 Redis。
+// leader 返回 false 并自行求解;follower 采用仍在有效期内的共享参数并返回 true。
+// Redis 不可用或共享参数已过期(例如 leader 休眠后锁尚未过期)时返回 false,本实例临时独立求解
+func (m *AntiBotManager) adoptSharedTokenUnsafe() bool {
+	if m.shared.leader.Load() {
+		return false
+	}
+
+	token := m.shared.newest.Load()
+	switch {
+	case m.shared.unavailable.Load():
+		log.Printf("⚠️  Redis 不可用,本地求解")
+	case token == nil || m.clock.Since(token.UpdatedAt) > sharedTokenMaxAge:
+		log.Printf("⚠️  暂无有效的共享参数,本地求解")
+	default:
+		// 订阅消息可能已先一步安装了同一参数,此时不再计数
+		if token.UpdatedAt.After(m.lastUpdateTime) {
+			m.installSharedTokenUnsafe(*token)
+			log.Printf("🔗 采用共享参数 (发布者: %s, %v 前)", token.Instance, m.clock.Since(token.UpdatedAt).Round(time.Second))
+		}
+		return true
+	}
+	sharedTokenEvents.Inc("fallback")
+	return false
+}
+
+// installSharedTokenUnsafe 安装比当前更新的共享参数并计数(调用方需持有锁)
+func (m *AntiBotManager) installSharedTokenUnsafe(token sharedToken) {
+	m.currentXIsHuman = token.Token
+	m.lastUpdateTime = token.UpdatedAt
+	sharedTokenEvents.Inc("adopted")
+}
+
+// publishSharedTokenUnsafe leader 刷新成功后交给后台发布参数(调用方需持有锁)
+func (m *AntiBotManager) publishSharedTokenUnsafe() {
+	if m.shared == nil || !m.shared.leader.Load() {
+		return
+	}
+	m.shared.pending.Store(&sharedToken{Token: m.currentXIsHuman, UpdatedAt: m.lastUpdateTime, Instance: m.shared.instance})
+	select {
+	case m.shared.publishNow <- struct{}{}:
+	default:
+		// 已有发布在等待,它会读取最新的参数
+	}
+}
+
+// sharedTokenStats 返回共享模式的状态,未启用时返回 nil
+func (m *AntiBotManager) sharedTokenStats() map[string]interface{} {
+	if m.shared == nil {
+		return nil
+	}
+	role := "follower"
+	if m.shared.leader.Load() {
+		role = "leader"
+	}
+	return map[string]interface{}{
+		"role":     role,
+		"instance": m.shared.instance,
+		"redis":    m.shared.client.Addr(),
+	}
+}
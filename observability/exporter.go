@@ -0,0 +1,221 @@
+package observability
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"cursor2api/config"
+	"cursor2api/logger"
+)
+
+// Generation is one completed model call exported for prompt analytics
+type Generation struct {
+	Trace            Trace
+	Name             string
+	Model            string
+	EndTime          time.Time
+	Input            interface{} // Only populated when content capture is enabled
+	Output           interface{} // Only populated when content capture is enabled
+	PromptTokens     int
+	CompletionTokens int
+	Metadata         map[string]string
+	Error            string
+}
+
+// ingestionEvent is a single Langfuse ingestion batch entry
+type ingestionEvent struct {
+	ID        string      `json:"id"`
+	Timestamp string      `json:"timestamp"`
+	Type      string      `json:"type"`
+	Body      interface{} `json:"body"`
+}
+
+// Exporter batches generations and posts them to a Langfuse-compatible ingestion endpoint
+type Exporter struct {
+	cfg      config.ObservabilityConfig
+	endpoint string
+	client   *http.Client
+	queue    chan Generation
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewExporter creates and starts an exporter; returns nil when no host is configured
+func NewExporter(cfg config.ObservabilityConfig) *Exporter {
+	if cfg.LangfuseHost == "" {
+		return nil
+	}
+
+	e := &Exporter{
+		cfg:      cfg,
+		endpoint: strings.TrimRight(cfg.LangfuseHost, "/") + "/api/public/ingestion",
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan Generation, cfg.BatchSize*10),
+		done:     make(chan struct{}),
+	}
+	e.wg.Add(1)
+	go e.loop()
+
+	logger.Info("Observability exporter started | endpoint=%s batch_size=%d flush_interval=%v capture_content=%v",
+		e.endpoint, cfg.BatchSize, cfg.FlushInterval, cfg.CaptureContent)
+	return e
+}
+
+// CaptureContent reports whether prompts and outputs should be included in exports
+func (e *Exporter) CaptureContent() bool {
+	return e != nil && e.cfg.CaptureContent
+}
+
+// Export queues a generation without blocking; records are dropped when the queue is full
+func (e *Exporter) Export(g Generation) {
+	if e == nil {
+		return
+	}
+	select {
+	case e.queue <- g:
+	default:
+		logger.Warn("Observability export queue full, dropping generation | trace_id=%s", g.Trace.TraceID)
+	}
+}
+
+// Stop flushes pending generations and stops the background loop
+func (e *Exporter) Stop() {
+	if e == nil {
+		return
+	}
+	close(e.done)
+	e.wg.Wait()
+}
+
+// loop batches queued generations and flushes them periodically
+func (e *Exporter) loop() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Generation, 0, e.cfg.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			logger.Warn("Failed to export generations | count=%d error=%v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case g := <-e.queue:
+			batch = append(batch, g)
+			if len(batch) >= e.cfg.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.done:
+			for {
+				select {
+				case g := <-e.queue:
+					batch = append(batch, g)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// send posts one ingestion batch
+func (e *Exporter) send(batch []Generation) error {
+	events := make([]ingestionEvent, 0, len(batch)*2)
+	for _, g := range batch {
+		events = append(events, e.toEvents(g)...)
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{"batch": events})
+	if err != nil {
+		return fmt.Errorf("failed to marshal batch: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(e.cfg.LangfusePublicKey, e.cfg.LangfuseSecretKey)
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	_ = resp.Body.Close()
+
+	// Langfuse answers 207 Multi-Status for partially accepted batches
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusMultiStatus {
+		return fmt.Errorf("ingestion returned HTTP %d", resp.StatusCode)
+	}
+	logger.Debug("Exported generations | count=%d status=%d", len(batch), resp.StatusCode)
+	return nil
+}
+
+// toEvents converts a generation to trace-create and generation-create ingestion events
+func (e *Exporter) toEvents(g Generation) []ingestionEvent {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	level := "DEFAULT"
+	if g.Error != "" {
+		level = "ERROR"
+	}
+
+	trace := map[string]interface{}{
+		"id":        g.Trace.TraceID,
+		"name":      g.Name,
+		"timestamp": g.Trace.StartTime.UTC().Format(time.RFC3339Nano),
+	}
+	if g.Trace.SessionID != "" {
+		trace["sessionId"] = g.Trace.SessionID
+	}
+	if g.Trace.UserID != "" {
+		trace["userId"] = g.Trace.UserID
+	}
+	if len(g.Metadata) > 0 {
+		trace["metadata"] = g.Metadata
+	}
+
+	generation := map[string]interface{}{
+		"id":        NewID(),
+		"traceId":   g.Trace.TraceID,
+		"name":      g.Name,
+		"model":     g.Model,
+		"startTime": g.Trace.StartTime.UTC().Format(time.RFC3339Nano),
+		"endTime":   g.EndTime.UTC().Format(time.RFC3339Nano),
+		"level":     level,
+		"usage": map[string]interface{}{
+			"input":  g.PromptTokens,
+			"output": g.CompletionTokens,
+			"unit":   "TOKENS",
+		},
+	}
+	if g.Error != "" {
+		generation["statusMessage"] = g.Error
+	}
+	if len(g.Metadata) > 0 {
+		generation["metadata"] = g.Metadata
+	}
+	if e.cfg.CaptureContent {
+		generation["input"] = g.Input
+		generation["output"] = g.Output
+	}
+
+	return []ingestionEvent{
+		{ID: NewID(), Timestamp: now, Type: "trace-create", Body: trace},
+		{ID: NewID(), Timestamp: now, Type: "generation-create", Body: generation},
+	}
+}
@@ -0,0 +1,74 @@
+// Package observability extracts trace/session/user identifiers from incoming requests
+// and optionally exports generation records to a Langfuse-compatible ingestion API.
+package observability
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"cursor2api/config"
+)
+
+// Trace holds the observability identifiers of one request
+type Trace struct {
+	TraceID   string
+	SessionID string
+	UserID    string
+	StartTime time.Time
+}
+
+// traceContextKey is the private context key for Trace values
+type traceContextKey struct{}
+
+// FromRequest builds a Trace from the configured headers, generating a trace ID when absent
+// Helicone-style headers (Helicone-Session-Id, Helicone-User-Id) are accepted as fallbacks.
+func FromRequest(r *http.Request, cfg config.ObservabilityConfig, user string) Trace {
+	trace := Trace{
+		TraceID:   firstHeader(r, cfg.TraceHeader, "Langfuse-Trace-Id"),
+		SessionID: firstHeader(r, cfg.SessionHeader, "Helicone-Session-Id"),
+		UserID:    firstHeader(r, cfg.UserHeader, "Helicone-User-Id"),
+		StartTime: time.Now(),
+	}
+	if trace.TraceID == "" {
+		trace.TraceID = NewID()
+	}
+	if trace.UserID == "" {
+		trace.UserID = user
+	}
+	return trace
+}
+
+// WithTrace returns a context carrying trace
+func WithTrace(ctx context.Context, trace Trace) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, trace)
+}
+
+// TraceFromContext returns the request trace, if any
+func TraceFromContext(ctx context.Context) (Trace, bool) {
+	trace, ok := ctx.Value(traceContextKey{}).(Trace)
+	return trace, ok
+}
+
+// NewID returns a random 128-bit hex identifier
+func NewID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// firstHeader returns the first non-empty header value among names
+func firstHeader(r *http.Request, names ...string) string {
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if value := strings.TrimSpace(r.Header.Get(name)); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,194 @@
+// Package preflight verifies the external dependencies of the service at startup:
+// the x-is-human solver behind PROCESS_URL, the script behind JS_URL and a TLS
+// handshake with the upstream chat endpoint. Problems are reported as a table
+// with an actionable hint instead of surfacing on the first user request.
+package preflight
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/imroc/req/v3"
+
+	"cursor2api/config"
+)
+
+// Status is the outcome of a single check
+type Status string
+
+const (
+	StatusPass Status = "PASS"
+	StatusWarn Status = "WARN" // reachable, but a later request may still fail
+	StatusFail Status = "FAIL"
+	StatusSkip Status = "SKIP"
+)
+
+// minScriptSize matches the size below which the AntiBot manager rejects a downloaded script
+const minScriptSize = 1000
+
+// Result is the outcome of one dependency check
+type Result struct {
+	Name     string        `json:"name"`
+	Target   string        `json:"target"`
+	Status   Status        `json:"status"`
+	Detail   string        `json:"detail,omitempty"`
+	Hint     string        `json:"hint,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// check is a named dependency check
+type check struct {
+	name   string
+	target func(cfg *config.Config) string
+	run    func(ctx context.Context, client *req.Client, cfg *config.Config) (Status, string, string)
+}
+
+// checks lists the checks in execution order
+var checks = []check{
+	{"process_url", func(cfg *config.Config) string { return cfg.Cursor.ProcessURL }, checkProcessURL},
+	{"js_url", func(cfg *config.Config) string { return cfg.Cursor.JSURL }, checkJSURL},
+	{"upstream", func(cfg *config.Config) string { return cfg.Cursor.ChatURL }, checkUpstream},
+}
+
+// Run performs every check with the upstream client, each bounded by timeout
+func Run(ctx context.Context, client *req.Client, cfg *config.Config, timeout time.Duration) []Result {
+	results := make([]Result, 0, len(checks))
+	for _, c := range checks {
+		result := Result{Name: c.name, Target: redactURL(c.target(cfg))}
+		if cfg.Mock.Enabled {
+			result.Status, result.Detail = StatusSkip, "MOCK_MODE serves synthetic completions"
+			results = append(results, result)
+			continue
+		}
+		checkCtx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		result.Status, result.Detail, result.Hint = c.run(checkCtx, client, cfg)
+		result.Duration = time.Since(start)
+		cancel()
+		results = append(results, result)
+	}
+	return results
+}
+
+// Failed reports whether any check failed
+func Failed(results []Result) bool {
+	for _, r := range results {
+		if r.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// Report writes the diagnostic table
+func Report(w io.Writer, results []Result) {
+	icons := map[Status]string{StatusPass: "✅", StatusWarn: "⚠️ ", StatusFail: "❌", StatusSkip: "⏭️ "}
+	fmt.Fprintln(w, "🩺 Startup dependency checks")
+	fmt.Fprintln(w, "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s %-12s %s", icons[r.Status], r.Name, r.Status)
+		if r.Status != StatusSkip {
+			fmt.Fprintf(w, " (%s)", r.Duration.Round(time.Millisecond))
+		}
+		fmt.Fprintf(w, "  %s\n", r.Target)
+		if r.Detail != "" {
+			fmt.Fprintf(w, "   └─ %s\n", r.Detail)
+		}
+		if r.Hint != "" {
+			fmt.Fprintf(w, "   └─ fix: %s\n", r.Hint)
+		}
+	}
+	fmt.Fprintln(w, "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+}
+
+// checkProcessURL verifies the solver accepts connections; any HTTP response
+// counts, since the solver only serves POST requests carrying a script
+func checkProcessURL(ctx context.Context, client *req.Client, cfg *config.Config) (Status, string, string) {
+	resp, err := client.R().SetContext(ctx).Get(cfg.Cursor.ProcessURL)
+	if err != nil {
+		return StatusFail, err.Error(), networkHint(err,
+			"Start the x-is-human-api solver (see README) or point PROCESS_URL at it")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return StatusWarn, fmt.Sprintf("HTTP %d", resp.StatusCode), "The solver is up but failing; check its logs"
+	}
+	return StatusPass, fmt.Sprintf("reachable (HTTP %d)", resp.StatusCode), ""
+}
+
+// checkJSURL downloads the AntiBot script the way the manager does
+func checkJSURL(ctx context.Context, client *req.Client, cfg *config.Config) (Status, string, string) {
+	resp, err := client.R().SetContext(ctx).SetHeader("referer", cfg.Cursor.JSReferer).Get(cfg.Cursor.JSURL)
+	if err != nil {
+		return StatusFail, err.Error(), networkHint(err,
+			"Check outbound access to "+host(cfg.Cursor.JSURL)+" (DNS, firewall, proxy)")
+	}
+	body := resp.String()
+	if !resp.IsSuccessState() {
+		return StatusFail, fmt.Sprintf("HTTP %d", resp.StatusCode),
+			"JS_URL has probably rotated; copy the current script URL from https://cursor.com/cn/learn"
+	}
+	if len(body) < minScriptSize {
+		return StatusFail, fmt.Sprintf("script is only %d bytes", len(body)),
+			"JS_URL does not point at the AntiBot script; copy the current script URL from https://cursor.com/cn/learn"
+	}
+	return StatusPass, fmt.Sprintf("downloaded %d bytes", len(body)), ""
+}
+
+// checkUpstream performs a TLS handshake with the chat endpoint and sends a HEAD
+// request; no completion is requested
+func checkUpstream(ctx context.Context, client *req.Client, cfg *config.Config) (Status, string, string) {
+	resp, err := client.R().SetContext(ctx).Head(cfg.Cursor.ChatURL)
+	if err != nil {
+		hint := "Check outbound access to " + host(cfg.Cursor.ChatURL) + " (DNS, firewall, proxy)"
+		if msg := err.Error(); strings.Contains(msg, "tls:") || strings.Contains(msg, "x509:") || strings.Contains(msg, "pinned") {
+			hint = "TLS handshake failed; check UPSTREAM_TLS_CA_FILES, UPSTREAM_TLS_PINNED_SHA256 and any TLS-intercepting proxy"
+		}
+		return StatusFail, err.Error(), networkHint(err, hint)
+	}
+	defer resp.Body.Close()
+	detail := fmt.Sprintf("handshake ok (HTTP %d", resp.StatusCode)
+	if resp.TLS != nil {
+		detail += ", " + tls.VersionName(resp.TLS.Version)
+	}
+	detail += ")"
+	if resp.StatusCode == 403 || resp.StatusCode == 429 {
+		return StatusWarn, detail, "The upstream rejects this host; requests may be blocked until the IP is allowed again"
+	}
+	return StatusPass, detail, ""
+}
+
+// networkHint prefers a timeout-specific hint over the generic one
+func networkHint(err error, hint string) string {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return "Timed out: " + strings.ToLower(hint[:1]) + hint[1:] + "; raise STARTUP_CHECK_TIMEOUT for slow networks"
+	}
+	return hint
+}
+
+// host returns the host of a URL for hints
+func host(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return rawURL
+}
+
+// redactURL drops credentials and the query string from a URL shown in the report
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.User = nil
+	u.RawQuery = ""
+	return u.String()
+}
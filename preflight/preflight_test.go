@@ -0,0 +1,81 @@
+package preflight
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imroc/req/v3"
+
+	"cursor2api/config"
+)
+
+func TestRun(t *testing.T) {
+	script := strings.Repeat("x", minScriptSize)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/process":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case "/c.js":
+			_, _ = w.Write([]byte(script))
+		case "/short.js":
+			_, _ = w.Write([]byte("<html>"))
+		case "/chat":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{Cursor: config.CursorConfig{
+		ProcessURL: srv.URL + "/process",
+		JSURL:      srv.URL + "/c.js?token=secret",
+		ChatURL:    srv.URL + "/chat",
+	}}
+	results := Run(context.Background(), req.C(), cfg, time.Second)
+	statuses := []Status{StatusPass, StatusPass, StatusWarn}
+	for i, r := range results {
+		if r.Status != statuses[i] {
+			t.Errorf("%s = %s (%s), want %s", r.Name, r.Status, r.Detail, statuses[i])
+		}
+	}
+	if Failed(results) {
+		t.Error("no check failed")
+	}
+	if strings.Contains(results[1].Target, "secret") {
+		t.Errorf("target must not leak the query string: %s", results[1].Target)
+	}
+
+	cfg.Cursor.JSURL = srv.URL + "/short.js"
+	cfg.Cursor.ProcessURL = "http://127.0.0.1:1/process"
+	results = Run(context.Background(), req.C(), cfg, time.Second)
+	if results[0].Status != StatusFail || !strings.Contains(results[0].Hint, "PROCESS_URL") {
+		t.Errorf("process_url = %+v", results[0])
+	}
+	if results[1].Status != StatusFail || !strings.Contains(results[1].Detail, "6 bytes") {
+		t.Errorf("js_url = %+v", results[1])
+	}
+	if !Failed(results) {
+		t.Error("failures must be reported")
+	}
+
+	var buf bytes.Buffer
+	Report(&buf, results)
+	if !strings.Contains(buf.String(), "❌ process_url") || !strings.Contains(buf.String(), "└─ fix: Start the x-is-human-api solver") {
+		t.Errorf("report =\n%s", buf.String())
+	}
+}
+
+func TestRun_MockMode(t *testing.T) {
+	cfg := &config.Config{Mock: config.MockConfig{Enabled: true}}
+	for _, r := range Run(context.Background(), req.C(), cfg, time.Second) {
+		if r.Status != StatusSkip {
+			t.Errorf("%s = %s, want SKIP in mock mode", r.Name, r.Status)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Package redis is a minimal RESP2 client covering the handful of commands the
+// proxy needs to coordinate replicas and share caches (SET/GET/DEL/EVAL/PUBLISH
+// and SUBSCRIBE). Commands are serialized over one lazily dialed connection that
+// is re-dialed after any I/O error; subscriptions use a dedicated connection.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTimeout bounds a command when the context carries no deadline
+const defaultTimeout = 5 * time.Second
+
+// Error is an error reply sent by the server
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// Client sends commands to a single Redis server
+type Client struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      bool
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// New parses a redis:// or rediss:// URL such as redis://:secret@host:6379/2
+func New(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("invalid redis URL scheme %q", u.Scheme)
+	}
+	c := &Client{addr: u.Host, tls: u.Scheme == "rediss"}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+	}
+	return c, nil
+}
+
+// Addr returns the server address
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+// Do sends one command and returns its reply: string for simple strings,
+// int64 for integers, []byte for bulk strings, []any for arrays and nil for
+// nil replies. Error replies are returned as Error.
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		conn, rd, err := c.dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.conn, c.rd = conn, rd
+	}
+	setDeadline(ctx, c.conn)
+
+	reply, err := roundTrip(c.conn, c.rd, args)
+	var replyErr Error
+	if err != nil && !errors.As(err, &replyErr) {
+		// The stream position is unknown after an I/O error; start over next time
+		_ = c.conn.Close()
+		c.conn, c.rd = nil, nil
+	}
+	return reply, err
+}
+
+// Subscribe delivers messages published to channel until ctx is cancelled or
+// the connection fails; callers reconnect by calling it again
+func (c *Client) Subscribe(ctx context.Context, channel string, handle func(payload []byte)) error {
+	conn, rd, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	setDeadline(ctx, conn)
+	if _, err := roundTrip(conn, rd, []string{"SUBSCRIBE", channel}); err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	for {
+		reply, err := readReply(rd)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		msg, ok := reply.([]any)
+		if !ok || len(msg) != 3 {
+			continue
+		}
+		if kind, _ := msg[0].([]byte); string(kind) != "message" {
+			continue
+		}
+		if payload, ok := msg[2].([]byte); ok {
+			handle(payload)
+		}
+	}
+}
+
+// Close closes the command connection
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn, c.rd = nil, nil
+	return err
+}
+
+// dial opens an authenticated connection with the configured database selected
+func (c *Client) dial(ctx context.Context) (net.Conn, *bufio.Reader, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+
+	var dialer interface {
+		DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+	} = &net.Dialer{}
+	if c.tls {
+		dialer = &tls.Dialer{}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("redis dial %s: %w", c.addr, err)
+	}
+	rd := bufio.NewReader(conn)
+	setDeadline(ctx, conn)
+
+	var setup [][]string
+	switch {
+	case c.username != "" && c.password != "":
+		setup = append(setup, []string{"AUTH", c.username, c.password})
+	case c.password != "":
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	for _, cmd := range setup {
+		if _, err := roundTrip(conn, rd, cmd); err != nil {
+			_ = conn.Close()
+			return nil, nil, fmt.Errorf("redis %s: %w", cmd[0], err)
+		}
+	}
+	return conn, rd, nil
+}
+
+// setDeadline applies the context deadline, or the default timeout, to conn
+func setDeadline(ctx context.Context, conn net.Conn) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultTimeout)
+	}
+	_ = conn.SetDeadline(deadline)
+}
+
+// roundTrip writes a command and reads its reply
+func roundTrip(w io.Writer, rd *bufio.Reader, args []string) (any, error) {
+	if _, err := w.Write(encodeCommand(args)); err != nil {
+		return nil, err
+	}
+	return readReply(rd)
+}
+
+// encodeCommand encodes args as a RESP array of bulk strings
+func encodeCommand(args []string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return []byte(b.String())
+}
+
+// readReply reads one RESP2 reply
+func readReply(rd *bufio.Reader) (any, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(rd); err != nil {
+				var replyErr Error
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+				items[i] = replyErr
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
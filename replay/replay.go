@@ -0,0 +1,248 @@
+// Package replay implements the `cursor2api replay` subcommand. It feeds an upstream
+// SSE stream captured through POST /admin/capture (or any raw Cursor stream dump)
+// through the regular service and handler pipeline, with the capture standing in for
+// the upstream, and prints the OpenAI response a client would have received. With
+// -abort-after it disconnects the simulated client mid-stream and reports how fast
+// the abort propagated.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cursor2api/config"
+	"cursor2api/handler"
+	"cursor2api/models"
+	"cursor2api/service"
+	"cursor2api/types"
+	"cursor2api/upstream"
+)
+
+// Options holds the replay command line options
+type Options struct {
+	File       string
+	Stream     bool
+	Model      string
+	Tools      []string
+	AbortAfter int // Disconnect the client after this many SSE events (0 = never)
+	Timeout    time.Duration
+	Verbose    bool
+}
+
+// Run parses args, replays the capture and prints the response; returns the process exit code
+func Run(args []string) int {
+	opts, err := parseFlags(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 2
+	}
+	if !opts.Verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	cfg := config.Load()
+	cfg.Mock = config.MockConfig{Enabled: true, ReplayFile: opts.File}
+	// Replayed requests must not reach external sinks or persistent state
+	cfg.Shadow.URL = ""
+	cfg.Observability.LangfuseHost = ""
+	cfg.Observability.TranscriptDir = ""
+	cfg.Usage.StateFile = ""
+	cfg.Usage.BillingWebhookURL = ""
+	cfg.Usage.ExportCSVPath = ""
+	config.GlobalConfig = cfg
+
+	manager := models.NewAntiBotManager(cfg.Cursor, upstream.NewClient(cfg.Upstream))
+	apiHandler := handler.NewAPIHandler(service.NewCursorService(manager, cfg), manager, cfg, nil)
+	defer apiHandler.Close()
+
+	fmt.Fprintf(os.Stderr, "🔁 Replaying %s (stream: %v, model: %s, tools: %d)\n", opts.File, opts.Stream, opts.Model, len(opts.Tools))
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(requestBody(opts))).WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	rec := &recorder{header: make(http.Header), abortAfter: opts.AbortAfter, cancel: cancel}
+
+	apiHandler.HandleChatCompletions(rec, req)
+	returned := time.Now()
+
+	os.Stdout.Write(rec.body.Bytes())
+	if rec.body.Len() > 0 && !bytes.HasSuffix(rec.body.Bytes(), []byte("\n")) {
+		fmt.Println()
+	}
+
+	if opts.AbortAfter > 0 {
+		if rec.abortedAt.IsZero() {
+			fmt.Fprintf(os.Stderr, "⚠️  The stream ended before %d events, the client was not disconnected\n", opts.AbortAfter)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "✂️  Client disconnected after %d events, handler returned %v later\n", opts.AbortAfter, returned.Sub(rec.abortedAt).Round(time.Microsecond))
+		return 0
+	}
+	if rec.status() >= http.StatusBadRequest || bytes.Contains(rec.body.Bytes(), []byte(`"error":`)) {
+		fmt.Fprintf(os.Stderr, "❌ Replay produced an error response (HTTP %d)\n", rec.status())
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "✅ Replay completed (HTTP %d)\n", rec.status())
+	return 0
+}
+
+// parseFlags parses the replay flags; settings not given default to the capture header
+func parseFlags(args []string) (Options, error) {
+	var (
+		opts  Options
+		mode  string
+		tools string
+	)
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.StringVar(&mode, "mode", "", "stream or non_stream (default: mode recorded in the capture, else stream)")
+	fs.StringVar(&opts.Model, "model", "", "Model of the replayed request (default: model recorded in the capture)")
+	fs.StringVar(&tools, "tools", "", "Comma separated tool names offered to the model (default: tools recorded in the capture)")
+	fs.IntVar(&opts.AbortAfter, "abort-after", 0, "Disconnect the client after this many SSE events to test abort propagation")
+	fs.DurationVar(&opts.Timeout, "timeout", time.Minute, "Timeout of the replayed request")
+	fs.BoolVar(&opts.Verbose, "v", false, "Show the proxy logs on stderr")
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+	if fs.NArg() != 1 {
+		return opts, fmt.Errorf("usage: cursor2api replay [flags] <capture.sse>")
+	}
+	opts.File = fs.Arg(0)
+
+	header, err := readHeader(opts.File)
+	if err != nil {
+		return opts, err
+	}
+	mode = firstNonEmpty(mode, header["mode"], "stream")
+	if mode != "stream" && mode != "non_stream" {
+		return opts, fmt.Errorf("invalid -mode %q: want stream or non_stream", mode)
+	}
+	opts.Stream = mode == "stream"
+	opts.Model = firstNonEmpty(opts.Model, header["model"], "anthropic/claude-4.5-sonnet")
+	for _, name := range strings.Split(firstNonEmpty(tools, header["tools"]), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			opts.Tools = append(opts.Tools, name)
+		}
+	}
+	if opts.AbortAfter > 0 && !opts.Stream {
+		return opts, fmt.Errorf("-abort-after requires stream mode")
+	}
+	return opts, nil
+}
+
+// readHeader parses the ": key=value" comment lines at the top of a capture file
+func readHeader(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		comment, ok := strings.CutPrefix(scanner.Text(), ":")
+		if !ok {
+			break
+		}
+		for _, field := range strings.Fields(comment) {
+			if key, value, ok := strings.Cut(field, "="); ok {
+				header[key] = value
+			}
+		}
+	}
+	return header, scanner.Err()
+}
+
+// requestBody builds the chat completion request replayed against the capture
+func requestBody(opts Options) []byte {
+	req := types.ChatCompletionRequest{
+		Model:    opts.Model,
+		Stream:   opts.Stream,
+		Messages: []types.ChatMessage{{Role: "user", Content: "replay"}},
+	}
+	for _, name := range opts.Tools {
+		req.Tools = append(req.Tools, types.Tool{
+			Type: "function",
+			Function: types.FunctionDef{
+				Name:       name,
+				Parameters: map[string]interface{}{"type": "object"},
+			},
+		})
+	}
+	data, _ := json.Marshal(req)
+	return data
+}
+
+// firstNonEmpty returns the first non-empty value
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// recorder is the simulated client: it buffers the response and, with abortAfter set,
+// cancels the request context once that many SSE events have been written
+type recorder struct {
+	mu         sync.Mutex
+	header     http.Header
+	code       int
+	body       bytes.Buffer
+	events     int
+	abortAfter int
+	abortedAt  time.Time
+	cancel     context.CancelFunc
+}
+
+func (r *recorder) Header() http.Header { return r.header }
+
+func (r *recorder) WriteHeader(code int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.code == 0 {
+		r.code = code
+	}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	if !r.abortedAt.IsZero() {
+		return 0, context.Canceled
+	}
+	r.body.Write(p)
+	r.events += bytes.Count(p, []byte("data: "))
+	if r.abortAfter > 0 && r.events >= r.abortAfter {
+		r.abortedAt = time.Now()
+		r.cancel()
+	}
+	return len(p), nil
+}
+
+// Flush implements http.Flusher; the response is buffered until the handler returns
+func (r *recorder) Flush() {}
+
+// status returns the response status code
+func (r *recorder) status() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.code
+}
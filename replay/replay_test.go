@@ -0,0 +1,41 @@
+package replay
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "capture.sse")
+	capture := ": cursor2api upstream capture\n" +
+		": time=2025-01-01T12:00:00Z mode=non_stream model=openai/gpt-5 outcome=error malformed=false truncated=false\n" +
+		": tools=read_file,write_file\n" +
+		"data: {\"type\":\"text-delta\",\"delta\":\"xx\"}\n"
+	if err := os.WriteFile(file, []byte(capture), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseFlags([]string{file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Stream || opts.Model != "openai/gpt-5" || len(opts.Tools) != 2 || opts.Tools[1] != "write_file" {
+		t.Errorf("header defaults not applied: %+v", opts)
+	}
+
+	opts, err = parseFlags([]string{"-mode", "stream", "-model", "m1", "-tools", "", "-abort-after", "3", file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.Stream || opts.Model != "m1" || opts.AbortAfter != 3 {
+		t.Errorf("flags should override the header: %+v", opts)
+	}
+
+	if _, err := parseFlags([]string{"-abort-after", "3", file}); err == nil {
+		t.Error("-abort-after with a non-stream capture should be rejected")
+	}
+	if _, err := parseFlags(nil); err == nil {
+		t.Error("missing capture file should be rejected")
+	}
+}
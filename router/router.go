@@ -0,0 +1,224 @@
+// Package router provides a minimal method-aware HTTP router.
+// Routes declare their allowed methods explicitly; HEAD is served by GET handlers,
+// OPTIONS preflights are answered from the route table, and unmatched requests
+// receive OpenAI-compatible 404/405 JSON errors.
+package router
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"cursor2api/errdocs"
+	"cursor2api/logger"
+	"cursor2api/tracing"
+	"cursor2api/types"
+)
+
+// route is a registered path pattern with its per-method handlers
+type route struct {
+	pattern  string
+	segments []string
+	handlers map[string]http.Handler
+}
+
+// Router dispatches requests by path and method
+type Router struct {
+	routes []*route
+
+	// NotFound handles requests whose path matches no route
+	NotFound http.Handler
+}
+
+// New creates an empty router
+func New() *Router {
+	return &Router{
+		NotFound: http.HandlerFunc(defaultNotFound),
+	}
+}
+
+// Handle registers handler for method and path pattern
+// Patterns are exact paths whose segments may contain a "{name}" wildcard, optionally
+// followed by a literal suffix (e.g. "/v1/items/{id}" or "/models/{model}:generate").
+// Matched wildcard values are available through r.PathValue(name).
+func (rt *Router) Handle(method, pattern string, handler http.Handler) {
+	for _, existing := range rt.routes {
+		if existing.pattern == pattern {
+			existing.handlers[method] = handler
+			return
+		}
+	}
+	rt.routes = append(rt.routes, &route{
+		pattern:  pattern,
+		segments: splitPath(pattern),
+		handlers: map[string]http.Handler{method: handler},
+	})
+}
+
+// HandleFunc registers a handler function for method and path pattern
+func (rt *Router) HandleFunc(method, pattern string, handler http.HandlerFunc) {
+	rt.Handle(method, pattern, handler)
+}
+
+// ServeHTTP implements http.Handler
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rte, values := rt.match(r.URL.Path)
+	if rte == nil {
+		rt.NotFound.ServeHTTP(w, r)
+		return
+	}
+
+	for name, value := range values {
+		r.SetPathValue(name, value)
+	}
+	if span := tracing.SpanFromContext(r.Context()); span != nil {
+		span.SetName(r.Method + " " + rte.pattern)
+		span.SetAttribute("http.route", rte.pattern)
+	}
+
+	method := r.Method
+	if method == http.MethodHead {
+		if _, ok := rte.handlers[http.MethodHead]; !ok {
+			method = http.MethodGet
+		}
+	}
+
+	handler, ok := rte.handlers[method]
+	switch {
+	case ok:
+		handler.ServeHTTP(w, r)
+	case r.Method == http.MethodOptions:
+		writeOptions(w, rte)
+	default:
+		w.Header().Set("Allow", strings.Join(rte.allowed(), ", "))
+		writeError(w, http.StatusMethodNotAllowed, "Method "+r.Method+" not allowed for "+r.URL.Path, "method_not_allowed")
+	}
+}
+
+// Preflight returns middleware answering OPTIONS requests from the route table
+// It must wrap auth/rate limiting so preflights never require credentials.
+func (rt *Router) Preflight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rte, _ := rt.match(r.URL.Path)
+		if rte == nil {
+			rt.NotFound.ServeHTTP(w, r)
+			return
+		}
+		if handler, ok := rte.handlers[http.MethodOptions]; ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		writeOptions(w, rte)
+	})
+}
+
+// Routes returns the registered "METHOD pattern" pairs, sorted for display
+func (rt *Router) Routes() []string {
+	list := make([]string, 0, len(rt.routes))
+	for _, rte := range rt.routes {
+		for method := range rte.handlers {
+			list = append(list, method+" "+rte.pattern)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// match finds the route for path and returns its wildcard values
+func (rt *Router) match(path string) (*route, map[string]string) {
+	segments := splitPath(path)
+	for _, rte := range rt.routes {
+		if values, ok := matchSegments(rte.segments, segments); ok {
+			return rte, values
+		}
+	}
+	return nil, nil
+}
+
+// allowed lists the methods accepted by the route, including implicit HEAD/OPTIONS
+func (rte *route) allowed() []string {
+	methods := make([]string, 0, len(rte.handlers)+2)
+	for method := range rte.handlers {
+		methods = append(methods, method)
+	}
+	if _, ok := rte.handlers[http.MethodGet]; ok {
+		if _, ok := rte.handlers[http.MethodHead]; !ok {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	if _, ok := rte.handlers[http.MethodOptions]; !ok {
+		methods = append(methods, http.MethodOptions)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// splitPath splits a URL path into its non-empty segments
+func splitPath(path string) []string {
+	return strings.FieldsFunc(path, func(r rune) bool { return r == '/' })
+}
+
+// matchSegments matches path segments against pattern segments
+func matchSegments(pattern, path []string) (map[string]string, bool) {
+	if len(pattern) != len(path) {
+		return nil, false
+	}
+	var values map[string]string
+	for i, seg := range pattern {
+		if !strings.HasPrefix(seg, "{") {
+			if seg != path[i] {
+				return nil, false
+			}
+			continue
+		}
+		end := strings.Index(seg, "}")
+		if end == -1 {
+			return nil, false
+		}
+		name, suffix := seg[1:end], seg[end+1:]
+		value, ok := strings.CutSuffix(path[i], suffix)
+		if !ok || value == "" {
+			return nil, false
+		}
+		if values == nil {
+			values = make(map[string]string)
+		}
+		values[name] = value
+	}
+	return values, true
+}
+
+// writeOptions answers a preflight with the route's allowed methods
+func writeOptions(w http.ResponseWriter, rte *route) {
+	allowed := strings.Join(rte.allowed(), ", ")
+	w.Header().Set("Allow", allowed)
+	w.Header().Set("Access-Control-Allow-Methods", allowed)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// defaultNotFound writes a generic OpenAI-format 404
+func defaultNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, "Not found", "not_found")
+}
+
+// writeError writes an OpenAI-compatible error response
+func writeError(w http.ResponseWriter, status int, message, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	errResp := types.ErrorResponse{
+		Error: types.ErrorDetail{
+			Message: message,
+			Type:    "invalid_request_error",
+			Code:    code,
+		},
+	}
+	errResp.Error.Hint, errResp.Error.DocURL = errdocs.Annotate(errResp.Error.Code, "")
+	if err := types.WriteJSON(w, errResp); err != nil {
+		logger.Error("Failed to write router error response | error=%v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestRouter() *Router {
+	rt := New()
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.PathValue("id")))
+	}
+	rt.HandleFunc(http.MethodGet, "/v1/models", ok)
+	rt.HandleFunc(http.MethodPost, "/v1/chat/completions", ok)
+	rt.HandleFunc(http.MethodPost, "/v1/items/{id}/cancel", ok)
+	rt.HandleFunc(http.MethodPost, "/v1beta/models/{id}:generate", ok)
+	return rt
+}
+
+func TestRouter_Dispatch(t *testing.T) {
+	rt := newTestRouter()
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantBody   string
+		wantAllow  string
+	}{
+		{name: "GET route", method: http.MethodGet, path: "/v1/models", wantStatus: http.StatusOK},
+		{name: "HEAD served by GET", method: http.MethodHead, path: "/v1/models", wantStatus: http.StatusOK},
+		{name: "POST to GET route", method: http.MethodPost, path: "/v1/models", wantStatus: http.StatusMethodNotAllowed, wantAllow: "GET, HEAD, OPTIONS"},
+		{name: "GET to POST route", method: http.MethodGet, path: "/v1/chat/completions", wantStatus: http.StatusMethodNotAllowed, wantAllow: "OPTIONS, POST"},
+		{name: "OPTIONS", method: http.MethodOptions, path: "/v1/chat/completions", wantStatus: http.StatusNoContent, wantAllow: "OPTIONS, POST"},
+		{name: "Wildcard segment", method: http.MethodPost, path: "/v1/items/abc/cancel", wantStatus: http.StatusOK, wantBody: "abc"},
+		{name: "Wildcard with suffix", method: http.MethodPost, path: "/v1beta/models/gem:generate", wantStatus: http.StatusOK, wantBody: "gem"},
+		{name: "Wrong suffix", method: http.MethodPost, path: "/v1beta/models/gem:other", wantStatus: http.StatusNotFound},
+		{name: "Unknown path", method: http.MethodGet, path: "/v2/unknown", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+			rt.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+			if tt.wantAllow != "" && w.Header().Get("Allow") != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", w.Header().Get("Allow"), tt.wantAllow)
+			}
+			if w.Code >= 400 {
+				var resp map[string]map[string]interface{}
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("error body is not JSON: %v", err)
+				}
+				if resp["error"]["type"] != "invalid_request_error" {
+					t.Errorf("error type = %v, want invalid_request_error", resp["error"]["type"])
+				}
+			}
+		})
+	}
+}
+
+func TestRouter_PreflightBypassesInnerChain(t *testing.T) {
+	rt := newTestRouter()
+	reject := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	handler := rt.Preflight(reject)
+
+	req := httptest.NewRequest(http.MethodOptions, "/v1/chat/completions", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("preflight status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("non-preflight status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,343 @@
+package selftest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Wire formats as seen by an OpenAI-compatible client
+
+type modelList struct {
+	Object string `json:"object"`
+	Data   []struct {
+		ID     string `json:"id"`
+		Object string `json:"object"`
+	} `json:"data"`
+}
+
+type toolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type message struct {
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	ToolCalls []toolCall `json:"tool_calls"`
+}
+
+type completion struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Choices []struct {
+		Message      *message `json:"message"`
+		Delta        *message `json:"delta"`
+		FinishReason string   `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *apiError `json:"error"`
+}
+
+type apiError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+}
+
+// weatherTool is the tool offered in the tool calling check
+var weatherTool = map[string]interface{}{
+	"type": "function",
+	"function": map[string]interface{}{
+		"name":        "get_weather",
+		"description": "Get the current weather for a city",
+		"parameters": map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"city": map[string]string{"type": "string"}},
+			"required":   []string{"city"},
+		},
+	},
+}
+
+// checkModels verifies GET /v1/models returns a non-empty list containing the test model
+func checkModels(ctx context.Context, t *tester) (Status, string) {
+	resp, err := t.do(ctx, http.MethodGet, "/v1/models", nil, t.opts.APIKey)
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return StatusFail, readError(resp)
+	}
+
+	var list modelList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return StatusFail, fmt.Sprintf("invalid JSON: %v", err)
+	}
+	if list.Object != "list" {
+		return StatusFail, fmt.Sprintf(`object = %q, want "list"`, list.Object)
+	}
+	if len(list.Data) == 0 {
+		return StatusFail, "no models listed"
+	}
+	for _, m := range list.Data {
+		if m.ID == t.opts.Model {
+			return StatusPass, fmt.Sprintf("%d models", len(list.Data))
+		}
+	}
+	return StatusWarn, fmt.Sprintf("%d models, but %s is not listed", len(list.Data), t.opts.Model)
+}
+
+// checkNonStream verifies a plain non-stream completion
+func checkNonStream(ctx context.Context, t *tester) (Status, string) {
+	resp, err := t.do(ctx, http.MethodPost, "/v1/chat/completions", t.chatBody(false, "Reply with the single word: pong", nil), t.opts.APIKey)
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return StatusFail, readError(resp)
+	}
+
+	var c completion
+	if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+		return StatusFail, fmt.Sprintf("invalid JSON: %v", err)
+	}
+	switch {
+	case c.Object != "chat.completion":
+		return StatusFail, fmt.Sprintf(`object = %q, want "chat.completion"`, c.Object)
+	case len(c.Choices) == 0 || c.Choices[0].Message == nil:
+		return StatusFail, "response has no choices[0].message"
+	case c.Choices[0].Message.Role != "assistant":
+		return StatusFail, fmt.Sprintf(`message.role = %q, want "assistant"`, c.Choices[0].Message.Role)
+	case c.Choices[0].Message.Content == "":
+		return StatusFail, "message.content is empty"
+	case c.Choices[0].FinishReason == "":
+		return StatusFail, "finish_reason is missing"
+	case c.Usage == nil:
+		return StatusWarn, "usage is missing"
+	}
+	return StatusPass, fmt.Sprintf("finish_reason=%s, %d completion tokens", c.Choices[0].FinishReason, c.Usage.CompletionTokens)
+}
+
+// checkStream verifies SSE framing, the role chunk, the final finish_reason and [DONE]
+func checkStream(ctx context.Context, t *tester) (Status, string) {
+	resp, err := t.do(ctx, http.MethodPost, "/v1/chat/completions", t.chatBody(true, "Count from 1 to 5, separated by spaces.", nil), t.opts.APIKey)
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return StatusFail, readError(resp)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		return StatusFail, fmt.Sprintf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	var (
+		chunks       int
+		content      strings.Builder
+		sawRole      bool
+		finishReason string
+		ids          = make(map[string]bool)
+		problem      string
+	)
+	sawDone, err := sseEvents(resp.Body, func(data string) bool {
+		var c completion
+		if err := json.Unmarshal([]byte(data), &c); err != nil {
+			problem = fmt.Sprintf("invalid chunk JSON: %v", err)
+			return false
+		}
+		if c.Error != nil {
+			problem = fmt.Sprintf("error event: %s", c.Error.Message)
+			return false
+		}
+		if c.Object != "chat.completion.chunk" {
+			problem = fmt.Sprintf(`chunk object = %q, want "chat.completion.chunk"`, c.Object)
+			return false
+		}
+		chunks++
+		ids[c.ID] = true
+		for _, choice := range c.Choices {
+			if choice.Delta != nil {
+				sawRole = sawRole || choice.Delta.Role == "assistant"
+				content.WriteString(choice.Delta.Content)
+			}
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
+		}
+		return true
+	})
+
+	switch {
+	case problem != "":
+		return StatusFail, problem
+	case err != nil:
+		return StatusFail, fmt.Sprintf("stream read failed after %d chunks: %v", chunks, err)
+	case !sawDone:
+		return StatusFail, fmt.Sprintf("stream ended after %d chunks without data: [DONE]", chunks)
+	case content.Len() == 0:
+		return StatusFail, "no content deltas received"
+	case finishReason == "":
+		return StatusFail, "no chunk carried a finish_reason"
+	case !sawRole:
+		return StatusWarn, `no delta carried role "assistant"`
+	case len(ids) > 1:
+		return StatusWarn, fmt.Sprintf("chunks used %d different ids", len(ids))
+	}
+	return StatusPass, fmt.Sprintf("%d chunks, finish_reason=%s", chunks, finishReason)
+}
+
+// checkToolCalling verifies a tool call comes back in the OpenAI tool_calls format
+func checkToolCalling(ctx context.Context, t *tester) (Status, string) {
+	body := t.chatBody(false, "What is the weather in Paris right now? Use the get_weather tool.", map[string]interface{}{
+		"tools":       []interface{}{weatherTool},
+		"tool_choice": "auto",
+	})
+	resp, err := t.do(ctx, http.MethodPost, "/v1/chat/completions", body, t.opts.APIKey)
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return StatusFail, readError(resp)
+	}
+
+	var c completion
+	if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+		return StatusFail, fmt.Sprintf("invalid JSON: %v", err)
+	}
+	if len(c.Choices) == 0 || c.Choices[0].Message == nil {
+		return StatusFail, "response has no choices[0].message"
+	}
+	choice := c.Choices[0]
+	if len(choice.Message.ToolCalls) == 0 {
+		return StatusWarn, fmt.Sprintf("model answered without calling the tool (finish_reason=%s); is ENABLE_FUNCTION_CALLING on?", choice.FinishReason)
+	}
+
+	call := choice.Message.ToolCalls[0]
+	var args map[string]interface{}
+	switch {
+	case choice.FinishReason != "tool_calls":
+		return StatusFail, fmt.Sprintf(`finish_reason = %q, want "tool_calls"`, choice.FinishReason)
+	case call.ID == "":
+		return StatusFail, "tool call has no id"
+	case call.Type != "function":
+		return StatusFail, fmt.Sprintf(`tool call type = %q, want "function"`, call.Type)
+	case call.Function.Name != "get_weather":
+		return StatusFail, fmt.Sprintf("tool call name = %q, want get_weather", call.Function.Name)
+	case json.Unmarshal([]byte(call.Function.Arguments), &args) != nil:
+		return StatusFail, fmt.Sprintf("arguments are not a JSON object: %s", call.Function.Arguments)
+	}
+	return StatusPass, fmt.Sprintf("get_weather(%s)", call.Function.Arguments)
+}
+
+// checkStreamAbort disconnects after the first content chunk and verifies the instance stays healthy
+func checkStreamAbort(ctx context.Context, t *tester) (Status, string) {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	body := t.chatBody(true, "Count from 1 to 300, one number per line.", nil)
+	start := time.Now()
+	resp, err := t.do(streamCtx, http.MethodPost, "/v1/chat/completions", body, t.opts.APIKey)
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return StatusFail, readError(resp)
+	}
+
+	gotContent := false
+	sawDone, _ := sseEvents(resp.Body, func(data string) bool {
+		var c completion
+		if json.Unmarshal([]byte(data), &c) == nil && len(c.Choices) > 0 && c.Choices[0].Delta != nil && c.Choices[0].Delta.Content != "" {
+			gotContent = true
+			return false
+		}
+		return true
+	})
+	ttft := time.Since(start)
+	cancel()
+	_ = resp.Body.Close()
+
+	// The instance must keep serving after a client walks away mid-stream
+	healthCtx, healthCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer healthCancel()
+	health, err := t.do(healthCtx, http.MethodGet, "/health", nil, "")
+	if err != nil {
+		return StatusFail, fmt.Sprintf("instance unreachable after abort: %v", err)
+	}
+	defer health.Body.Close()
+	if health.StatusCode != http.StatusOK {
+		return StatusFail, fmt.Sprintf("/health returned %d after abort", health.StatusCode)
+	}
+
+	if !gotContent {
+		if sawDone {
+			return StatusWarn, "stream finished before any content arrived, nothing to abort"
+		}
+		return StatusWarn, "no content chunk arrived before the stream ended"
+	}
+	return StatusPass, fmt.Sprintf("disconnected after first chunk (%s), instance still healthy", ttft.Round(time.Millisecond))
+}
+
+// checkErrorFormat verifies errors use the OpenAI {"error": {"message", "type"}} shape
+func checkErrorFormat(ctx context.Context, t *tester) (Status, string) {
+	cases := []struct {
+		name   string
+		body   []byte
+		key    string
+		status []int
+	}{
+		{name: "malformed JSON", body: []byte("{"), key: t.opts.APIKey, status: []int{http.StatusBadRequest}},
+		{name: "empty messages", body: []byte(`{"model":"` + t.opts.Model + `","messages":[]}`), key: t.opts.APIKey, status: []int{http.StatusBadRequest}},
+		// 400 means authentication is disabled and the request reached validation
+		{name: "invalid API key", body: []byte(`{"messages":[]}`), key: "sk-selftest-invalid", status: []int{http.StatusUnauthorized, http.StatusBadRequest}},
+	}
+
+	var notes []string
+	for _, tc := range cases {
+		resp, err := t.do(ctx, http.MethodPost, "/v1/chat/completions", tc.body, tc.key)
+		if err != nil {
+			return StatusFail, fmt.Sprintf("%s: %v", tc.name, err)
+		}
+		var payload struct {
+			Error *apiError `json:"error"`
+		}
+		decodeErr := json.NewDecoder(resp.Body).Decode(&payload)
+		resp.Body.Close()
+
+		expected := false
+		for _, s := range tc.status {
+			expected = expected || resp.StatusCode == s
+		}
+		switch {
+		case !expected:
+			return StatusFail, fmt.Sprintf("%s: HTTP %d, want %v", tc.name, resp.StatusCode, tc.status)
+		case decodeErr != nil || payload.Error == nil:
+			return StatusFail, fmt.Sprintf(`%s: body is not {"error": {...}}`, tc.name)
+		case payload.Error.Message == "" || payload.Error.Type == "":
+			return StatusFail, fmt.Sprintf("%s: error.message and error.type must be set", tc.name)
+		}
+		if tc.key == "sk-selftest-invalid" && resp.StatusCode == http.StatusBadRequest {
+			notes = append(notes, "authentication is disabled")
+		}
+	}
+
+	if len(notes) > 0 {
+		return StatusWarn, strings.Join(notes, "; ")
+	}
+	return StatusPass, fmt.Sprintf("%d error responses well-formed", len(cases))
+}
@@ -0,0 +1,231 @@
+// Package selftest implements the `cursor2api selftest` subcommand.
+// It exercises a running instance the way OpenAI-compatible clients do (model
+// listing, non-stream and stream chat, tool calling, mid-stream abort and error
+// formats) and prints a compatibility report, e.g. before pointing production
+// clients at an upgraded deployment.
+package selftest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Status is the outcome of a single check
+type Status string
+
+const (
+	StatusPass Status = "PASS"
+	StatusWarn Status = "WARN" // works, but a client might notice a difference
+	StatusFail Status = "FAIL"
+	StatusSkip Status = "SKIP"
+)
+
+// Options holds the selftest command line options
+type Options struct {
+	BaseURL string
+	APIKey  string
+	Model   string
+	Timeout time.Duration
+	Skip    map[string]bool
+	JSON    bool
+}
+
+// CheckResult is the outcome of one compatibility check
+type CheckResult struct {
+	Name     string        `json:"name"`
+	Status   Status        `json:"status"`
+	Detail   string        `json:"detail,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// check is a named compatibility check
+type check struct {
+	name string
+	run  func(ctx context.Context, t *tester) (Status, string)
+}
+
+// checks lists the checks in execution order
+var checks = []check{
+	{"models", checkModels},
+	{"chat_non_stream", checkNonStream},
+	{"chat_stream", checkStream},
+	{"tool_calling", checkToolCalling},
+	{"stream_abort", checkStreamAbort},
+	{"error_format", checkErrorFormat},
+}
+
+// Run parses args, runs the checks and prints the report; returns the process exit code
+func Run(args []string) int {
+	opts, err := parseFlags(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 2
+	}
+
+	t := &tester{opts: opts, client: &http.Client{}}
+	if !opts.JSON {
+		fmt.Printf("🩺 Self-testing %s (model: %s)\n", opts.BaseURL, opts.Model)
+	}
+
+	results := make([]CheckResult, 0, len(checks))
+	for _, c := range checks {
+		if opts.Skip[c.name] {
+			results = append(results, CheckResult{Name: c.name, Status: StatusSkip, Detail: "skipped by --skip"})
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+		start := time.Now()
+		status, detail := c.run(ctx, t)
+		cancel()
+		results = append(results, CheckResult{Name: c.name, Status: status, Detail: detail, Duration: time.Since(start)})
+	}
+
+	if opts.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(results)
+	} else {
+		printReport(os.Stdout, results)
+	}
+
+	for _, r := range results {
+		if r.Status == StatusFail {
+			return 1
+		}
+	}
+	return 0
+}
+
+// parseFlags parses and validates the selftest flags
+func parseFlags(args []string) (Options, error) {
+	var (
+		opts Options
+		skip string
+	)
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	fs.StringVar(&opts.BaseURL, "base-url", "http://localhost:5680", "Base URL of the cursor2api instance")
+	fs.StringVar(&opts.APIKey, "key", os.Getenv("SELFTEST_API_KEY"), "API key sent as Bearer token (default $SELFTEST_API_KEY)")
+	fs.StringVar(&opts.Model, "model", "anthropic/claude-4.5-sonnet", "Model used for chat checks")
+	fs.DurationVar(&opts.Timeout, "timeout", 2*time.Minute, "Timeout of each check")
+	fs.StringVar(&skip, "skip", "", "Comma separated checks to skip: "+checkNames())
+	fs.BoolVar(&opts.JSON, "json", false, "Print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+
+	opts.BaseURL = strings.TrimRight(opts.BaseURL, "/")
+	opts.Skip = make(map[string]bool)
+	for _, name := range strings.Split(skip, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			opts.Skip[name] = true
+		}
+	}
+	return opts, nil
+}
+
+// checkNames lists the available check names
+func checkNames() string {
+	names := make([]string, 0, len(checks))
+	for _, c := range checks {
+		names = append(names, c.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// printReport writes the human readable report
+func printReport(w io.Writer, results []CheckResult) {
+	icons := map[Status]string{StatusPass: "✅", StatusWarn: "⚠️ ", StatusFail: "❌", StatusSkip: "⏭️ "}
+	counts := make(map[Status]int)
+
+	fmt.Fprintln(w, "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	for _, r := range results {
+		counts[r.Status]++
+		fmt.Fprintf(w, "%s %-16s %s", icons[r.Status], r.Name, r.Status)
+		if r.Status != StatusSkip {
+			fmt.Fprintf(w, " (%s)", r.Duration.Round(time.Millisecond))
+		}
+		fmt.Fprintln(w)
+		if r.Detail != "" {
+			fmt.Fprintf(w, "   └─ %s\n", r.Detail)
+		}
+	}
+	fmt.Fprintln(w, "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Fprintf(w, "📊 %d passed, %d warnings, %d failed, %d skipped\n",
+		counts[StatusPass], counts[StatusWarn], counts[StatusFail], counts[StatusSkip])
+}
+
+// tester holds the shared HTTP state of a selftest run
+type tester struct {
+	opts   Options
+	client *http.Client
+}
+
+// do sends a request to the instance; body may be nil
+func (t *tester) do(ctx context.Context, method, path string, body []byte, apiKey string) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, t.opts.BaseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	return t.client.Do(req)
+}
+
+// chatBody builds a chat completion request body
+func (t *tester) chatBody(stream bool, prompt string, extra map[string]interface{}) []byte {
+	payload := map[string]interface{}{
+		"model":  t.opts.Model,
+		"stream": stream,
+		"messages": []map[string]string{
+			{"role": "user", "content": prompt},
+		},
+	}
+	for k, v := range extra {
+		payload[k] = v
+	}
+	data, _ := json.Marshal(payload)
+	return data
+}
+
+// readError reads a non-2xx response and formats it for the report
+func readError(resp *http.Response) string {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Sprintf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+}
+
+// sseEvents reads "data:" payloads from an SSE body until [DONE] or EOF;
+// fn returns false to stop reading early
+func sseEvents(body io.Reader, fn func(data string) bool) (sawDone bool, err error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			return true, nil
+		}
+		if !fn(data) {
+			return false, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,40 @@
+package selftest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSSEEvents(t *testing.T) {
+	body := ": keep-alive\n\ndata: {\"a\":1}\n\ndata: {\"a\":2}\n\ndata: [DONE]\n\n"
+
+	var got []string
+	sawDone, err := sseEvents(strings.NewReader(body), func(data string) bool {
+		got = append(got, data)
+		return true
+	})
+	if err != nil || !sawDone {
+		t.Fatalf("sawDone = %v, err = %v", sawDone, err)
+	}
+	if len(got) != 2 || got[1] != `{"a":2}` {
+		t.Fatalf("events = %q", got)
+	}
+
+	sawDone, _ = sseEvents(strings.NewReader(body), func(string) bool { return false })
+	if sawDone {
+		t.Fatal("stopping early must not report [DONE]")
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	opts, err := parseFlags([]string{"--base-url", "http://127.0.0.1:5680/", "--skip", "tool_calling, stream_abort"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.BaseURL != "http://127.0.0.1:5680" {
+		t.Fatalf("BaseURL = %q", opts.BaseURL)
+	}
+	if !opts.Skip["tool_calling"] || !opts.Skip["stream_abort"] || len(opts.Skip) != 2 {
+		t.Fatalf("Skip = %v", opts.Skip)
+	}
+}
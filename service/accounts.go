@@ -0,0 +1,250 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cursor2api/config"
+	"cursor2api/metrics"
+	"cursor2api/types"
+)
+
+// stickySweepEvery 每隔多少次选择清理一次过期的会话绑定
+const stickySweepEvery = 1024
+
+var (
+	accountRequests = metrics.NewCounter(
+		"cursor2api_upstream_account_requests_total",
+		"Upstream requests per pool account by result.",
+		"account", "result")
+
+	accountRebalances = metrics.NewCounter(
+		"cursor2api_upstream_account_rebalances_total",
+		"Conversations moved off an unhealthy upstream account.",
+		"from", "to")
+)
+
+// upstreamAccount 上游账号:一组独立的身份头部(如 cookie),与全局 x-is-human 参数配合使用
+type upstreamAccount struct {
+	Name    string            `json:"name"`
+	Headers map[string]string `json:"headers"`
+
+	consecutiveFailures atomic.Int32
+	unhealthyUntil      atomic.Int64 // UnixNano,0 表示健康
+	inFlight            atomic.Int64
+}
+
+// healthy 检查账号是否可用
+func (a *upstreamAccount) healthy(now time.Time) bool {
+	return now.UnixNano() >= a.unhealthyUntil.Load()
+}
+
+// stickyBinding 会话与账号的绑定
+type stickyBinding struct {
+	account  *upstreamAccount
+	lastUsed time.Time
+}
+
+// accountPool 上游账号池,按 conversation_id 粘性路由
+type accountPool struct {
+	accounts         []*upstreamAccount
+	stickyTTL        time.Duration
+	failureThreshold int32
+	cooldown         time.Duration
+
+	mu     sync.Mutex
+	sticky map[string]*stickyBinding
+	picks  uint64
+	next   uint64 // 无会话 ID 或负载相同时的轮询游标
+}
+
+// newAccountPool 从账号文件创建账号池,未配置时返回 nil(使用默认身份)
+func newAccountPool(cfg config.PoolConfig) *accountPool {
+	if cfg.AccountsFile == "" {
+		return nil
+	}
+
+	accounts, err := loadAccounts(cfg.AccountsFile)
+	if err != nil {
+		log.Printf("⚠️  Warning: 加载上游账号池失败,使用默认身份: %v", err)
+		return nil
+	}
+
+	threshold := int32(cfg.FailureThreshold)
+	if threshold <= 0 {
+		threshold = 1
+	}
+
+	log.Printf("👥 上游账号池已启用")
+	log.Printf("  └─ Accounts: %d", len(accounts))
+	log.Printf("  └─ Sticky TTL: %v, Failure Threshold: %d, Cooldown: %v", cfg.StickyTTL, threshold, cfg.Cooldown)
+
+	return &accountPool{
+		accounts:         accounts,
+		stickyTTL:        cfg.StickyTTL,
+		failureThreshold: threshold,
+		cooldown:         cfg.Cooldown,
+		sticky:           make(map[string]*stickyBinding),
+	}
+}
+
+// loadAccounts 读取 JSON 账号文件: [{"name": "...", "headers": {"cookie": "..."}}]
+func loadAccounts(path string) ([]*upstreamAccount, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts []*upstreamAccount
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return nil, fmt.Errorf("解析账号文件失败: %w", err)
+	}
+	if len(accounts) == 0 {
+		return nil, fmt.Errorf("账号文件为空: %s", path)
+	}
+
+	seen := make(map[string]bool, len(accounts))
+	for i, account := range accounts {
+		if account.Name == "" {
+			account.Name = fmt.Sprintf("account-%d", i+1)
+		}
+		if seen[account.Name] {
+			return nil, fmt.Errorf("账号名称重复: %s", account.Name)
+		}
+		seen[account.Name] = true
+	}
+	return accounts, nil
+}
+
+// pick 为请求选择账号:同一会话固定到同一账号,账号不健康时重新分配
+func (p *accountPool) pick(conversationID string) *upstreamAccount {
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.picks++
+	if p.picks%stickySweepEvery == 0 {
+		p.sweepLocked(now)
+	}
+
+	if conversationID == "" {
+		return p.selectLocked(now)
+	}
+
+	if binding, ok := p.sticky[conversationID]; ok && now.Sub(binding.lastUsed) < p.stickyTTL {
+		if binding.account.healthy(now) {
+			binding.lastUsed = now
+			return binding.account
+		}
+
+		replacement := p.selectLocked(now)
+		if replacement != binding.account {
+			log.Printf("🔀 账号 %s 不健康,会话 %s 迁移至 %s", binding.account.Name, conversationID, replacement.Name)
+			accountRebalances.Inc(binding.account.Name, replacement.Name)
+		}
+		binding.account = replacement
+		binding.lastUsed = now
+		return replacement
+	}
+
+	account := p.selectLocked(now)
+	p.sticky[conversationID] = &stickyBinding{account: account, lastUsed: now}
+	return account
+}
+
+// selectLocked 选择进行中请求最少的健康账号;全部不健康时选择最早恢复的账号
+func (p *accountPool) selectLocked(now time.Time) *upstreamAccount {
+	p.next++
+	var best *upstreamAccount
+	for i := range p.accounts {
+		account := p.accounts[(int(p.next)+i)%len(p.accounts)]
+		if !account.healthy(now) {
+			continue
+		}
+		if best == nil || account.inFlight.Load() < best.inFlight.Load() {
+			best = account
+		}
+	}
+	if best != nil {
+		return best
+	}
+
+	best = p.accounts[0]
+	for _, account := range p.accounts[1:] {
+		if account.unhealthyUntil.Load() < best.unhealthyUntil.Load() {
+			best = account
+		}
+	}
+	return best
+}
+
+// sweepLocked 清理过期的会话绑定
+func (p *accountPool) sweepLocked(now time.Time) {
+	for id, binding := range p.sticky {
+		if now.Sub(binding.lastUsed) >= p.stickyTTL {
+			delete(p.sticky, id)
+		}
+	}
+}
+
+// begin 标记账号开始处理一个请求
+func (p *accountPool) begin(account *upstreamAccount) {
+	account.inFlight.Add(1)
+}
+
+// finish 记录请求结果;连续失败达到阈值后账号进入冷却期
+func (p *accountPool) finish(account *upstreamAccount, failed bool) {
+	account.inFlight.Add(-1)
+
+	if !failed {
+		account.consecutiveFailures.Store(0)
+		account.unhealthyUntil.Store(0)
+		accountRequests.Inc(account.Name, "ok")
+		return
+	}
+
+	accountRequests.Inc(account.Name, "error")
+	if failures := account.consecutiveFailures.Add(1); failures >= p.failureThreshold {
+		account.unhealthyUntil.Store(time.Now().Add(p.cooldown).UnixNano())
+		log.Printf("🚫 上游账号 %s 连续失败 %d 次,冷却 %v", account.Name, failures, p.cooldown)
+	}
+}
+
+// snapshot 返回账号池状态
+func (p *accountPool) snapshot() []types.UpstreamAccountStatus {
+	now := time.Now()
+
+	p.mu.Lock()
+	conversations := make(map[*upstreamAccount]int, len(p.accounts))
+	for _, binding := range p.sticky {
+		if now.Sub(binding.lastUsed) < p.stickyTTL {
+			conversations[binding.account]++
+		}
+	}
+	p.mu.Unlock()
+
+	list := make([]types.UpstreamAccountStatus, 0, len(p.accounts))
+	for _, account := range p.accounts {
+		status := types.UpstreamAccountStatus{
+			Name:                account.Name,
+			Healthy:             account.healthy(now),
+			ConsecutiveFailures: int(account.consecutiveFailures.Load()),
+			InFlight:            account.inFlight.Load(),
+			Conversations:       conversations[account],
+		}
+		if !status.Healthy {
+			until := time.Unix(0, account.unhealthyUntil.Load())
+			status.UnhealthyUntil = &until
+		}
+		list = append(list, status)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestPool(names ...string) *accountPool {
+	accounts := make([]*upstreamAccount, 0, len(names))
+	for _, name := range names {
+		accounts = append(accounts, &upstreamAccount{Name: name})
+	}
+	return &accountPool{
+		accounts:         accounts,
+		stickyTTL:        time.Hour,
+		failureThreshold: 2,
+		cooldown:         time.Minute,
+		sticky:           make(map[string]*stickyBinding),
+	}
+}
+
+func TestAccountPool_StickyConversation(t *testing.T) {
+	pool := newTestPool("a", "b", "c")
+
+	first := pool.pick("conv-1")
+	for i := 0; i < 10; i++ {
+		// Other traffic must not move the conversation
+		pool.begin(pool.pick(""))
+		if got := pool.pick("conv-1"); got != first {
+			t.Fatalf("conversation moved from %s to %s", first.Name, got.Name)
+		}
+	}
+}
+
+func TestAccountPool_RebalancesUnhealthyAccount(t *testing.T) {
+	pool := newTestPool("a", "b")
+
+	original := pool.pick("conv-1")
+	for i := 0; i < 2; i++ {
+		pool.begin(original)
+		pool.finish(original, true)
+	}
+	if original.healthy(time.Now()) {
+		t.Fatal("account should be unhealthy after reaching the failure threshold")
+	}
+
+	moved := pool.pick("conv-1")
+	if moved == original {
+		t.Fatal("conversation should move off the unhealthy account")
+	}
+	if again := pool.pick("conv-1"); again != moved {
+		t.Fatalf("conversation should stay on its new account, got %s", again.Name)
+	}
+}
+
+func TestAccountPool_SuccessResetsFailures(t *testing.T) {
+	pool := newTestPool("a")
+	account := pool.accounts[0]
+
+	pool.begin(account)
+	pool.finish(account, true)
+	pool.begin(account)
+	pool.finish(account, false)
+	pool.begin(account)
+	pool.finish(account, true)
+
+	if !account.healthy(time.Now()) {
+		t.Fatal("non-consecutive failures should not bench the account")
+	}
+}
+
+func TestAccountPool_AllUnhealthyPicksEarliestRecovery(t *testing.T) {
+	pool := newTestPool("a", "b")
+	now := time.Now()
+	pool.accounts[0].unhealthyUntil.Store(now.Add(2 * time.Minute).UnixNano())
+	pool.accounts[1].unhealthyUntil.Store(now.Add(time.Minute).UnixNano())
+
+	if got := pool.pick(""); got.Name != "b" {
+		t.Fatalf("expected account recovering first, got %s", got.Name)
+	}
+}
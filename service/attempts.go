@@ -0,0 +1,151 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cursor2api/types"
+)
+
+// attemptTraceContextKey is the private context key for the attempt trace
+type attemptTraceContextKey struct{}
+
+// Attempt is one upstream request made for a client request
+type Attempt struct {
+	Model    string
+	Account  string // Pool account used (empty without a pool)
+	Status   int    // Upstream HTTP status (0 = no response)
+	Outcome  string // Finish reason of the attempt, or "error"
+	Duration time.Duration
+}
+
+// AttemptTrace collects the upstream attempts of a request: empty completion
+// retries, JSON mode retries and self-verification repairs each add one, so
+// clients can tell upstream flakiness from proxy issues. A nil *AttemptTrace
+// records nothing.
+type AttemptTrace struct {
+	mu       sync.Mutex
+	attempts []Attempt
+	current  Attempt // attempt in progress, filled in by openUpstream
+	started  time.Time
+}
+
+// WithAttemptTrace returns a context whose upstream attempts are recorded into the
+// returned trace
+func WithAttemptTrace(ctx context.Context) (context.Context, *AttemptTrace) {
+	trace := &AttemptTrace{}
+	return context.WithValue(ctx, attemptTraceContextKey{}, trace), trace
+}
+
+// attemptTraceFromContext returns the trace of the request, nil when none is recorded
+func attemptTraceFromContext(ctx context.Context) *AttemptTrace {
+	trace, _ := ctx.Value(attemptTraceContextKey{}).(*AttemptTrace)
+	return trace
+}
+
+// beginAttempt starts a new attempt of the request
+func (t *AttemptTrace) beginAttempt() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.current = Attempt{}
+	t.started = time.Now()
+	t.mu.Unlock()
+}
+
+// noteResponse records the account and the upstream status of the attempt in progress
+func (t *AttemptTrace) noteResponse(account *upstreamAccount, status int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if account != nil {
+		t.current.Account = account.Name
+	}
+	t.current.Status = status
+	t.mu.Unlock()
+}
+
+// finishAttempt completes the attempt in progress with its model and outcome
+func (t *AttemptTrace) finishAttempt(model, outcome string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	attempt := t.current
+	attempt.Model = model
+	attempt.Outcome = outcome
+	if !t.started.IsZero() {
+		attempt.Duration = time.Since(t.started)
+	}
+	t.attempts = append(t.attempts, attempt)
+	t.current, t.started = Attempt{}, time.Time{}
+}
+
+// traceAttempt completes the attempt in progress; attempts ended by the client count
+// as cancelled
+func traceAttempt(ctx context.Context, model, outcome string) {
+	if ctx.Err() != nil && outcome == "error" {
+		outcome = types.FinishReasonCancelled
+	}
+	attemptTraceFromContext(ctx).finishAttempt(model, outcome)
+}
+
+// Attempts returns the finished attempts
+func (t *AttemptTrace) Attempts() []Attempt {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Attempt(nil), t.attempts...)
+}
+
+// String summarizes the trace for the X-Attempts header:
+//
+//	attempts=2; retries=1; models=a/b,c/d; trace=1:a/b:acct-1:503:error:120ms,2:c/d:acct-2:200:stop:2300ms
+//
+// models lists the distinct models in order of use, so more than one means a
+// fallback model served part of the request; "-" stands for no account or status
+func (t *AttemptTrace) String() string {
+	attempts := t.Attempts()
+	var models []string
+	entries := make([]string, 0, len(attempts))
+	for i, a := range attempts {
+		if !slices.Contains(models, a.Model) {
+			models = append(models, a.Model)
+		}
+		status := "-"
+		if a.Status > 0 {
+			status = strconv.Itoa(a.Status)
+		}
+		entries = append(entries, fmt.Sprintf("%d:%s:%s:%s:%s:%dms",
+			i+1, a.Model, cmp.Or(a.Account, "-"), status, a.Outcome, a.Duration.Milliseconds()))
+	}
+	return fmt.Sprintf("attempts=%d; retries=%d; models=%s; trace=%s",
+		len(attempts), max(len(attempts)-1, 0), cmp.Or(strings.Join(models, ","), "-"), cmp.Or(strings.Join(entries, ","), "-"))
+}
+
+// CircuitState summarizes the account pool breakers as healthy/total accounts,
+// "none" without a pool
+func (cs *CursorService) CircuitState() string {
+	if cs.accounts == nil {
+		return "none"
+	}
+	now := time.Now()
+	healthy := 0
+	for _, account := range cs.accounts.accounts {
+		if account.healthy(now) {
+			healthy++
+		}
+	}
+	return fmt.Sprintf("%d/%d", healthy, len(cs.accounts.accounts))
+}
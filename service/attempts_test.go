@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"cursor2api/types"
+)
+
+func TestAttemptTrace_String(t *testing.T) {
+	ctx, trace := WithAttemptTrace(context.Background())
+
+	attemptTraceFromContext(ctx).beginAttempt()
+	attemptTraceFromContext(ctx).noteResponse(&upstreamAccount{Name: "acct-1"}, 503)
+	traceAttempt(ctx, "anthropic/claude-opus-4.1", "error")
+
+	attemptTraceFromContext(ctx).beginAttempt()
+	attemptTraceFromContext(ctx).noteResponse(nil, 200)
+	traceAttempt(ctx, "openai/gpt-5", types.FinishReasonStop)
+
+	want := `^attempts=2; retries=1; models=anthropic/claude-opus-4\.1,openai/gpt-5; ` +
+		`trace=1:anthropic/claude-opus-4\.1:acct-1:503:error:\d+ms,2:openai/gpt-5:-:200:stop:\d+ms$`
+	if got := trace.String(); !regexp.MustCompile(want).MatchString(got) {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestAttemptTrace_CancelledAndUntraced(t *testing.T) {
+	ctx, trace := WithAttemptTrace(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	traceAttempt(ctx, "m", "error")
+	if attempts := trace.Attempts(); len(attempts) != 1 || attempts[0].Outcome != types.FinishReasonCancelled {
+		t.Errorf("attempts = %+v, want one cancelled attempt", attempts)
+	}
+
+	// Requests without a trace record nothing
+	traceAttempt(context.Background(), "m", "stop")
+	var none *AttemptTrace
+	if got := none.String(); got != "attempts=0; retries=0; models=-; trace=-" {
+		t.Errorf("nil trace = %q", got)
+	}
+}
@@ -0,0 +1,290 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cursor2api/config"
+	"cursor2api/types"
+)
+
+// captureRedaction replaces configured patterns in captured streams
+const captureRedaction = "[REDACTED]"
+
+// UpstreamCapture 在管理员开启捕获模式期间记录上游原始 SSE 字节流,
+// 仅将失败请求 (上游异常终止、读取错误、无法解析的事件) 写入磁盘。
+// 文本增量与工具输入默认替换为等长占位内容,可用 `cursor2api replay` 离线重放
+type UpstreamCapture struct {
+	dir         string
+	maxBytes    int
+	keepContent bool
+	patterns    []*regexp.Regexp
+
+	mu        sync.Mutex
+	until     time.Time
+	remaining int // 本次开启期间还可写入的文件数
+}
+
+// CaptureStatus 捕获模式状态
+type CaptureStatus struct {
+	Enabled   bool          `json:"enabled"`
+	Until     *time.Time    `json:"until,omitempty"`
+	Remaining int           `json:"remaining"`
+	Dir       string        `json:"dir"`
+	Files     []CaptureFile `json:"files"`
+}
+
+// CaptureFile 一个已写入的捕获文件
+type CaptureFile struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// newUpstreamCapture 创建捕获器,默认关闭,通过管理接口开启
+func newUpstreamCapture(cfg config.ObservabilityConfig) *UpstreamCapture {
+	c := &UpstreamCapture{
+		dir:         cfg.UpstreamCaptureDir,
+		maxBytes:    cfg.UpstreamCaptureMaxBytes,
+		keepContent: cfg.UpstreamCaptureKeepContent,
+	}
+	for _, pattern := range cfg.UpstreamCaptureRedact {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Printf("⚠️  Warning: 忽略无效的捕获脱敏规则 %q: %v", pattern, err)
+			continue
+		}
+		c.patterns = append(c.patterns, re)
+	}
+	return c
+}
+
+// Enable 开启捕获模式 duration 时长,最多写入 maxFiles 个文件
+func (c *UpstreamCapture) Enable(duration time.Duration, maxFiles int) (CaptureStatus, error) {
+	if c.dir == "" {
+		return c.Status(), fmt.Errorf("UPSTREAM_CAPTURE_DIR is not set")
+	}
+	if err := os.MkdirAll(c.dir, 0o700); err != nil {
+		return c.Status(), err
+	}
+	c.mu.Lock()
+	c.until = time.Now().Add(duration)
+	c.remaining = maxFiles
+	c.mu.Unlock()
+	log.Printf("🎥 上游流捕获已开启 - 时长: %v, 最多 %d 个文件, 目录: %s", duration, maxFiles, c.dir)
+	return c.Status(), nil
+}
+
+// Disable 关闭捕获模式
+func (c *UpstreamCapture) Disable() CaptureStatus {
+	c.mu.Lock()
+	c.until = time.Time{}
+	c.remaining = 0
+	c.mu.Unlock()
+	log.Printf("🎥 上游流捕获已关闭")
+	return c.Status()
+}
+
+// Status 返回捕获模式状态和已写入的文件
+func (c *UpstreamCapture) Status() CaptureStatus {
+	c.mu.Lock()
+	status := CaptureStatus{Dir: c.dir, Remaining: c.remaining}
+	if c.activeLocked() {
+		status.Enabled = true
+		until := c.until
+		status.Until = &until
+	}
+	c.mu.Unlock()
+
+	status.Files = make([]CaptureFile, 0)
+	entries, _ := os.ReadDir(c.dir)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sse") {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			status.Files = append(status.Files, CaptureFile{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+		}
+	}
+	sort.Slice(status.Files, func(i, j int) bool { return status.Files[i].ModTime.After(status.Files[j].ModTime) })
+	return status
+}
+
+// activeLocked 报告捕获模式是否开启,调用方持有锁
+func (c *UpstreamCapture) activeLocked() bool {
+	return c.remaining > 0 && time.Now().Before(c.until)
+}
+
+// start 在捕获模式开启时为一次上游请求创建记录器,否则返回 nil
+func (c *UpstreamCapture) start(ctx context.Context, mode, model string, tools []types.Tool) *captureRecorder {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	active := c.activeLocked()
+	c.mu.Unlock()
+	if !active {
+		return nil
+	}
+
+	names := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		names = append(names, tool.Function.Name)
+	}
+	return &captureRecorder{capture: c, ctx: ctx, mode: mode, model: model, tools: names, started: time.Now()}
+}
+
+// claim 占用一个文件配额,配额用尽或模式已关闭时返回 false
+func (c *UpstreamCapture) claim() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.activeLocked() {
+		return false
+	}
+	c.remaining--
+	return true
+}
+
+// redact 脱敏一行 SSE 数据
+func (c *UpstreamCapture) redact(line string) string {
+	if !c.keepContent {
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			var event map[string]interface{}
+			if json.Unmarshal([]byte(data), &event) == nil {
+				for _, field := range []string{"delta", "input"} {
+					if value, ok := event[field]; ok {
+						event[field] = maskValue(value)
+					}
+				}
+				if masked, err := json.Marshal(event); err == nil {
+					line = "data: " + string(masked)
+				}
+			}
+		}
+	}
+	for _, re := range c.patterns {
+		line = re.ReplaceAllString(line, captureRedaction)
+	}
+	return line
+}
+
+// maskValue 将字符串替换为等长的 x,保留 JSON 结构以便重放
+func maskValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return strings.Repeat("x", len([]rune(v)))
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = maskValue(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = maskValue(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// captureRecorder 记录一次上游请求的原始字节流,nil 时所有方法为空操作
+type captureRecorder struct {
+	capture   *UpstreamCapture
+	ctx       context.Context
+	mode      string
+	model     string
+	tools     []string
+	started   time.Time
+	buf       bytes.Buffer
+	truncated bool
+	malformed bool
+}
+
+// wrap 返回同时写入记录缓冲区的 Reader
+func (r *captureRecorder) wrap(reader io.Reader) io.Reader {
+	if r == nil {
+		return reader
+	}
+	return &captureReader{recorder: r, reader: reader}
+}
+
+// markMalformed 标记出现了无法解析的事件,请求结束时无论结果都写入磁盘
+func (r *captureRecorder) markMalformed() {
+	if r != nil {
+		r.malformed = true
+	}
+}
+
+// finish 请求结束时调用,失败的请求写入捕获文件
+func (r *captureRecorder) finish(outcome string) {
+	if r == nil || r.ctx.Err() != nil {
+		return
+	}
+	failed := outcome == "error" || outcome == types.FinishReasonUpstreamAbort
+	if !failed && !r.malformed {
+		return
+	}
+	if !r.capture.claim() {
+		return
+	}
+
+	name := fmt.Sprintf("capture-%s-%s.sse", r.started.UTC().Format("20060102-150405.000"), r.mode)
+	path := filepath.Join(r.capture.dir, name)
+	if err := r.write(path, outcome); err != nil {
+		log.Printf("❌ 写入上游流捕获文件失败: %v", err)
+		return
+	}
+	log.Printf("🎥 已捕获失败的上游流: %s (outcome: %s, %d bytes)", path, outcome, r.buf.Len())
+}
+
+// write 写出捕获文件: SSE 注释行记录元数据,之后是脱敏后的原始流
+func (r *captureRecorder) write(path, outcome string) error {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, ": cursor2api upstream capture\n")
+	fmt.Fprintf(&out, ": time=%s mode=%s model=%s outcome=%s malformed=%v truncated=%v\n",
+		r.started.UTC().Format(time.RFC3339), r.mode, r.model, outcome, r.malformed, r.truncated)
+	if len(r.tools) > 0 {
+		fmt.Fprintf(&out, ": tools=%s\n", strings.Join(r.tools, ","))
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(r.buf.Bytes()))
+	scanner.Buffer(make([]byte, 64*1024), max(r.buf.Len(), 64*1024)+1)
+	for scanner.Scan() {
+		out.WriteString(r.capture.redact(scanner.Text()))
+		out.WriteByte('\n')
+	}
+	return os.WriteFile(path, out.Bytes(), 0o600)
+}
+
+// captureReader 在读取时复制上游字节,超过上限的部分不再记录
+type captureReader struct {
+	recorder *captureRecorder
+	reader   io.Reader
+}
+
+func (cr *captureReader) Read(p []byte) (int, error) {
+	n, err := cr.reader.Read(p)
+	if n > 0 {
+		r := cr.recorder
+		keep := n
+		if room := r.capture.maxBytes - r.buf.Len(); room < n {
+			r.truncated = true
+			keep = max(room, 0)
+		}
+		r.buf.Write(p[:keep])
+	}
+	return n, err
+}
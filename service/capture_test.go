@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cursor2api/config"
+	"cursor2api/types"
+)
+
+func newTestCapture(t *testing.T, maxBytes int, patterns ...string) *UpstreamCapture {
+	t.Helper()
+	c := newUpstreamCapture(config.ObservabilityConfig{
+		UpstreamCaptureDir:      t.TempDir(),
+		UpstreamCaptureMaxBytes: maxBytes,
+		UpstreamCaptureRedact:   patterns,
+	})
+	if _, err := c.Enable(time.Minute, 2); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestUpstreamCapture_Redact(t *testing.T) {
+	c := newTestCapture(t, 1024, `sk-[a-z0-9]+`)
+
+	got := c.redact(`data: {"delta":"héllo","type":"text-delta"}`)
+	if got != `data: {"delta":"xxxxx","type":"text-delta"}` {
+		t.Errorf("text delta not masked: %s", got)
+	}
+	got = c.redact(`data: {"input":{"path":"/etc/passwd","n":3},"toolName":"read_file","type":"tool-input-available"}`)
+	if strings.Contains(got, "passwd") || !strings.Contains(got, `"n":3`) || !strings.Contains(got, "read_file") {
+		t.Errorf("tool input not masked structurally: %s", got)
+	}
+	got = c.redact(`: note key=sk-abc123 leaked`)
+	if got != ": note key=[REDACTED] leaked" {
+		t.Errorf("pattern not redacted: %s", got)
+	}
+
+	c.keepContent = true
+	if got := c.redact(`data: {"delta":"hello"}`); got != `data: {"delta":"hello"}` {
+		t.Errorf("content should be kept: %s", got)
+	}
+}
+
+func TestUpstreamCapture_WritesFailuresOnly(t *testing.T) {
+	c := newTestCapture(t, 1024)
+	stream := "data: {\"type\":\"text-delta\",\"delta\":\"secret\"}\n\n"
+
+	ok := c.start(context.Background(), "stream", "m1", nil)
+	io.Copy(io.Discard, ok.wrap(strings.NewReader(stream)))
+	ok.finish(types.FinishReasonStop)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	aborted := c.start(ctx, "stream", "m1", nil)
+	io.Copy(io.Discard, aborted.wrap(strings.NewReader(stream)))
+	aborted.finish("error")
+
+	if files := c.Status().Files; len(files) != 0 {
+		t.Fatalf("successful and client-cancelled requests must not be captured: %v", files)
+	}
+
+	failed := c.start(context.Background(), "stream", "m1", []types.Tool{{Function: types.FunctionDef{Name: "read_file"}}})
+	io.Copy(io.Discard, failed.wrap(strings.NewReader(stream)))
+	failed.finish(types.FinishReasonUpstreamAbort)
+
+	status := c.Status()
+	if len(status.Files) != 1 || status.Remaining != 1 {
+		t.Fatalf("files = %v, remaining = %d", status.Files, status.Remaining)
+	}
+	data, err := os.ReadFile(filepath.Join(status.Dir, status.Files[0].Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(data)
+	for _, want := range []string{"mode=stream", "model=m1", "outcome=upstream_abort", ": tools=read_file", `"delta":"xxxxxx"`} {
+		if !strings.Contains(content, want) {
+			t.Errorf("capture missing %q:\n%s", want, content)
+		}
+	}
+	if strings.Contains(content, "secret") {
+		t.Error("capture leaked the text delta")
+	}
+}
+
+func TestUpstreamCapture_MaxBytesAndQuota(t *testing.T) {
+	c := newTestCapture(t, 8)
+
+	r := c.start(context.Background(), "non_stream", "m1", nil)
+	data, _ := io.ReadAll(r.wrap(strings.NewReader("0123456789abcdef")))
+	if string(data) != "0123456789abcdef" {
+		t.Errorf("capture must not alter the stream, got %q", data)
+	}
+	if r.buf.String() != "01234567" || !r.truncated {
+		t.Errorf("recorded %q (truncated %v), want the first 8 bytes", r.buf.String(), r.truncated)
+	}
+
+	r.markMalformed()
+	r.finish(types.FinishReasonStop)
+	c.start(context.Background(), "stream", "m1", nil).finish("error")
+	if c.start(context.Background(), "stream", "m1", nil) != nil {
+		t.Error("capture should switch off once the file quota is used")
+	}
+	if status := c.Status(); status.Enabled || len(status.Files) != 2 {
+		t.Errorf("status = %+v", status)
+	}
+
+	var nilRecorder *captureRecorder
+	nilRecorder.markMalformed()
+	nilRecorder.finish("error")
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"cursor2api/clock"
+	"cursor2api/config"
+)
+
+// errChaosTruncated 模拟上游连接在流中途被重置
+var errChaosTruncated = fmt.Errorf("chaos: upstream stream truncated: %w", io.ErrUnexpectedEOF)
+
+// faultInjector 在服务层注入上游故障,仅用于弹性测试 (CHAOS_ENABLED=true)
+type faultInjector struct {
+	cfg   config.ChaosConfig
+	clock clock.Clock // 抽取延迟抖动
+}
+
+// newFaultInjector 创建故障注入器,未启用时返回 nil
+func newFaultInjector(cfg config.ChaosConfig) *faultInjector {
+	if !cfg.Enabled {
+		return nil
+	}
+	log.Printf("⚠️  [Chaos] 故障注入已启用,仅可用于测试环境!")
+	log.Printf("  └─ Latency: %v (+jitter %v)", cfg.Latency, cfg.LatencyJitter)
+	log.Printf("  └─ Error Rate: %.2f, Truncate Rate: %.2f, Malformed Rate: %.2f",
+		cfg.ErrorRate, cfg.TruncateRate, cfg.MalformedRate)
+	return &faultInjector{cfg: cfg, clock: clock.System}
+}
+
+// beforeRequest 注入上游延迟和随机 5xx,返回非 nil 错误表示本次请求应失败
+func (f *faultInjector) beforeRequest(ctx context.Context) error {
+	if f == nil {
+		return nil
+	}
+
+	if delay := f.cfg.Latency + f.clock.Jitter(f.cfg.LatencyJitter); delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	if f.cfg.ErrorRate > 0 && rand.Float64() < f.cfg.ErrorRate {
+		status := 500 + rand.IntN(4) // 500-503
+		log.Printf("💥 [Chaos] 注入上游错误: HTTP %d", status)
+		return fmt.Errorf("HTTP错误: %d (chaos)", status)
+	}
+	return nil
+}
+
+// wrapBody 包装上游 SSE 响应体,按配置注入畸形事件和流截断
+func (f *faultInjector) wrapBody(r io.Reader) io.Reader {
+	if f == nil || (f.cfg.TruncateRate <= 0 && f.cfg.MalformedRate <= 0) {
+		return r
+	}
+
+	cr := &chaosReader{
+		src:           bufio.NewReader(r),
+		malformedRate: f.cfg.MalformedRate,
+		truncateAfter: -1,
+	}
+	if f.cfg.TruncateRate > 0 && rand.Float64() < f.cfg.TruncateRate {
+		cr.truncateAfter = 1 + rand.IntN(20)
+		log.Printf("💥 [Chaos] 本次响应将在第 %d 行后截断", cr.truncateAfter)
+	}
+	return cr
+}
+
+// chaosReader 逐行转发上游数据,并在行间插入故障
+type chaosReader struct {
+	src           *bufio.Reader
+	pending       []byte
+	malformedRate float64
+	truncateAfter int // 剩余可转发行数,-1 表示不截断
+}
+
+// Read 实现 io.Reader 接口
+func (cr *chaosReader) Read(p []byte) (int, error) {
+	for len(cr.pending) == 0 {
+		if cr.truncateAfter == 0 {
+			return 0, errChaosTruncated
+		}
+
+		line, err := cr.src.ReadBytes('\n')
+		if len(line) > 0 {
+			if cr.malformedRate > 0 && rand.Float64() < cr.malformedRate {
+				cr.pending = append(cr.pending, "data: {\"type\":\"text-delta\",\"delta\":\n\n"...)
+			}
+			cr.pending = append(cr.pending, line...)
+			if cr.truncateAfter > 0 {
+				cr.truncateAfter--
+			}
+		}
+		if err != nil {
+			if len(cr.pending) == 0 {
+				return 0, err
+			}
+			break
+		}
+	}
+
+	n := copy(p, cr.pending)
+	cr.pending = cr.pending[n:]
+	return n, nil
+}
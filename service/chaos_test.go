@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"cursor2api/clock"
+	"cursor2api/config"
+)
+
+const chaosMalformedEvent = "data: {\"type\":\"text-delta\",\"delta\":\n\n"
+
+// chaosUpstream returns an SSE body of n text-delta events
+func chaosUpstream(n int) string {
+	var body strings.Builder
+	for i := range n {
+		fmt.Fprintf(&body, "data: {\"type\":\"text-delta\",\"delta\":\"%d\"}\n", i)
+	}
+	return body.String()
+}
+
+func newTestFaultInjector(cfg config.ChaosConfig) *faultInjector {
+	cfg.Enabled = true
+	f := newFaultInjector(cfg)
+	f.clock = clock.NewFake(time.Unix(0, 0))
+	return f
+}
+
+func TestFaultInjector_BeforeRequest(t *testing.T) {
+	// The fake clock draws no jitter, so the hour of jitter adds no delay
+	f := newTestFaultInjector(config.ChaosConfig{LatencyJitter: time.Hour})
+	if err := f.beforeRequest(context.Background()); err != nil {
+		t.Errorf("error rate 0: %v", err)
+	}
+
+	f.cfg.ErrorRate = 1
+	if err := f.beforeRequest(context.Background()); err == nil || !strings.Contains(err.Error(), "(chaos)") {
+		t.Errorf("error rate 1: %v, want an injected upstream error", err)
+	}
+
+	// With the full jitter drawn the delay outlasts the request
+	f.clock.(*clock.Fake).SetJitter(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := f.beforeRequest(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("cancelled during the delay: %v", err)
+	}
+}
+
+func TestChaosReader_Truncate(t *testing.T) {
+	upstream := chaosUpstream(30)
+
+	f := newTestFaultInjector(config.ChaosConfig{TruncateRate: 0, MalformedRate: 1})
+	body, err := io.ReadAll(f.wrapBody(strings.NewReader(upstream)))
+	if err != nil {
+		t.Fatalf("truncate rate 0: %v", err)
+	}
+	if got := strings.ReplaceAll(string(body), chaosMalformedEvent, ""); got != upstream {
+		t.Errorf("truncate rate 0 forwarded %q", got)
+	}
+
+	f = newTestFaultInjector(config.ChaosConfig{TruncateRate: 1})
+	body, err = io.ReadAll(f.wrapBody(strings.NewReader(upstream)))
+	if !errors.Is(err, errChaosTruncated) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("truncate rate 1: err = %v", err)
+	}
+	lines := strings.Count(string(body), "\n")
+	if lines < 1 || lines > 20 || !strings.HasPrefix(upstream, string(body)) {
+		t.Errorf("truncate rate 1 forwarded %d lines: %q", lines, body)
+	}
+}
+
+func TestChaosReader_Malformed(t *testing.T) {
+	upstream := chaosUpstream(5)
+
+	// The reader is still installed for the truncation, but injects nothing
+	f := newTestFaultInjector(config.ChaosConfig{MalformedRate: 0, TruncateRate: 1})
+	body, _ := io.ReadAll(f.wrapBody(strings.NewReader(upstream)))
+	if strings.Contains(string(body), chaosMalformedEvent) {
+		t.Errorf("malformed rate 0 = %q", body)
+	}
+
+	f = newTestFaultInjector(config.ChaosConfig{MalformedRate: 1})
+	body, err := io.ReadAll(f.wrapBody(strings.NewReader(upstream)))
+	if err != nil {
+		t.Fatalf("malformed rate 1: %v", err)
+	}
+	var want strings.Builder
+	for line := range strings.Lines(upstream) {
+		want.WriteString(chaosMalformedEvent + line)
+	}
+	if string(body) != want.String() {
+		t.Errorf("malformed rate 1 = %q, want a malformed event before every line", body)
+	}
+}
+
+func TestFaultInjector_Disabled(t *testing.T) {
+	if newFaultInjector(config.ChaosConfig{}) != nil {
+		t.Fatal("a disabled injector must be nil")
+	}
+	var f *faultInjector
+	upstream := strings.NewReader(chaosUpstream(3))
+	if f.wrapBody(upstream) != io.Reader(upstream) || f.beforeRequest(context.Background()) != nil {
+		t.Error("a nil injector must pass requests and bodies through")
+	}
+
+	f = newTestFaultInjector(config.ChaosConfig{})
+	if f.wrapBody(upstream) != io.Reader(upstream) {
+		t.Error("rates of 0 must leave the body unwrapped")
+	}
+}
@@ -1,19 +1,25 @@
 package service
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/imroc/req/v3"
-	utls "github.com/refraction-networking/utls"
 
+	"cursor2api/canary"
+	"cursor2api/config"
+	"cursor2api/features"
 	"cursor2api/models"
+	"cursor2api/tracing"
 	"cursor2api/types"
+	"cursor2api/upstream"
 	"cursor2api/utils"
 )
 
@@ -22,74 +28,297 @@ type CursorService struct {
 	manager   *models.AntiBotManager
 	converter *utils.MessageConverter
 	client    *req.Client
+	upstream  config.CursorConfig
+	canary    *upstreamProfile
+	chaos     *faultInjector
+	mock      *mockUpstream
+	sandbox   *mockUpstream // 沙箱请求 (WithSandbox) 使用的模拟上游
+	accounts  *accountPool
+	capture   *UpstreamCapture
+	stream    config.StreamConfig
+
+	upstreamErrors errorWindow     // 最近一分钟的上游错误率,供自适应限流使用
+	schema         *schemaRegistry // 上游 SSE 协议检测与解析策略选择
 }
 
 // NewCursorService 创建 Cursor 服务
-func NewCursorService(manager *models.AntiBotManager, systemPrompt string) *CursorService {
+func NewCursorService(manager *models.AntiBotManager, cfg *config.Config) *CursorService {
 	return &CursorService{
 		manager:   manager,
-		converter: utils.NewMessageConverter(systemPrompt),
-		client: req.C().
-			ImpersonateChrome().
-			SetTLSFingerprint(utls.HelloChrome_131).
-			EnableInsecureSkipVerify(),
+		converter: utils.NewMessageConverter(cfg.Cursor.SystemPrompt),
+		client:    upstream.NewClient(cfg.Upstream),
+		upstream:  cfg.Cursor,
+		chaos:     newFaultInjector(cfg.Chaos),
+		mock:      newMockUpstream(cfg.Mock),
+		sandbox:   &mockUpstream{cfg: cfg.Mock},
+		accounts:  newAccountPool(cfg.Pool),
+		capture:   newUpstreamCapture(cfg.Observability),
+		stream:    cfg.Stream,
+		canary:    newCanaryProfile(cfg),
+		schema:    newSchemaRegistry(cfg.Stream),
 	}
 }
 
-// Chat 非流式聊天 - Returns either text content or tool call
-func (cs *CursorService) Chat(ctx context.Context, messages []types.ChatMessage, model string, conversationID string, tools []types.Tool) (interface{}, error) {
+// upstreamProfile 一组请求转换与上游配置,canary 变体使用独立的 profile
+type upstreamProfile struct {
+	converter    *utils.MessageConverter
+	upstream     config.CursorConfig
+	systemPrompt string // 变体系统提示词,作为第一条 system 消息发送;为空时不修改消息
+}
+
+// newCanaryProfile 基于常规配置叠加 canary 覆盖项,未启用 canary 时返回 nil
+func newCanaryProfile(cfg *config.Config) *upstreamProfile {
+	if cfg.Canary.Percent <= 0 {
+		return nil
+	}
+	upstream := cfg.Cursor
+	if cfg.Canary.ChatURL != "" {
+		upstream.ChatURL = cfg.Canary.ChatURL
+	}
+	if len(cfg.Canary.ExtraHeaders) > 0 {
+		upstream.ExtraHeaders = make(map[string]string, len(cfg.Cursor.ExtraHeaders)+len(cfg.Canary.ExtraHeaders))
+		for k, v := range cfg.Cursor.ExtraHeaders {
+			upstream.ExtraHeaders[k] = v
+		}
+		for k, v := range cfg.Canary.ExtraHeaders {
+			upstream.ExtraHeaders[k] = v
+		}
+	}
+	return &upstreamProfile{
+		converter:    utils.NewMessageConverter(cfg.Cursor.SystemPrompt),
+		upstream:     upstream,
+		systemPrompt: cfg.Canary.SystemPrompt,
+	}
+}
+
+// withSystemPrompt 返回在最前面加入变体系统提示词的消息副本,不修改调用方的消息
+func (p upstreamProfile) withSystemPrompt(messages []types.ChatMessage) []types.ChatMessage {
+	if p.systemPrompt == "" {
+		return messages
+	}
+	result := make([]types.ChatMessage, 0, len(messages)+1)
+	result = append(result, types.ChatMessage{Role: "system", Content: p.systemPrompt})
+	return append(result, messages...)
+}
+
+// profile 返回请求所属配置变体的转换器与上游配置
+func (cs *CursorService) profile(ctx context.Context) upstreamProfile {
+	if cs.canary != nil && canary.IsCanary(ctx) {
+		return *cs.canary
+	}
+	return upstreamProfile{converter: cs.converter, upstream: cs.upstream}
+}
+
+// UseConverterHooks 为常规与 canary 请求启用消息预处理与输出后处理钩子,需在处理请求前调用
+func (cs *CursorService) UseConverterHooks(hooks *utils.ConverterHooks) {
+	cs.converter.UseHooks(hooks)
+	if cs.canary != nil {
+		cs.canary.converter.UseHooks(hooks)
+	}
+}
+
+// Accounts 返回上游账号池状态,未启用账号池时返回 nil
+func (cs *CursorService) Accounts() []types.UpstreamAccountStatus {
+	if cs.accounts == nil {
+		return nil
+	}
+	return cs.accounts.snapshot()
+}
+
+// Capture 返回上游流捕获器,由管理接口开启和关闭
+func (cs *CursorService) Capture() *UpstreamCapture {
+	return cs.capture
+}
+
+// buildHeaders 构建上游请求头,额外头部与账号头部不会覆盖 x-is-human
+// 标记头部 (tags) 位于额外头部之后、账号头部之前,不能覆盖账号身份
+func (cs *CursorService) buildHeaders(upstream config.CursorConfig, xIsHuman string, account *upstreamAccount, tags map[string]string) map[string]string {
+	headers := make(map[string]string, len(upstream.ExtraHeaders)+len(tags)+4)
+	for k, v := range upstream.ExtraHeaders {
+		headers[k] = v
+	}
+	for k, v := range tags {
+		headers[k] = v
+	}
+	if account != nil {
+		for k, v := range account.Headers {
+			headers[k] = v
+		}
+	}
+	headers["referer"] = upstream.Referer
+	headers["x-method"] = upstream.XMethod
+	headers["x-path"] = upstream.XPath
+	headers["x-is-human"] = xIsHuman
+	return headers
+}
+
+// openUpstream 发起上游请求并返回 SSE 响应体,调用方负责关闭
+// 启用账号池时,同一 conversationID 的请求固定使用同一账号
+func (cs *CursorService) openUpstream(ctx context.Context, upstream config.CursorConfig, requestBody, conversationID string) (io.ReadCloser, error) {
+	trace := attemptTraceFromContext(ctx)
+	trace.beginAttempt()
+
+	if err := cs.chaos.beforeRequest(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, upstreamUnavailable(err)
+	}
+
+	if cs.mock != nil {
+		return cs.mock.open(ctx, requestBody), nil
+	}
+	if sandboxFromContext(ctx) {
+		return cs.sandbox.open(ctx, requestBody), nil
+	}
+
+	_, tokenSpan := tracing.Start(ctx, "antibot.get_x_is_human", tracing.KindInternal)
 	xIsHuman, err := cs.manager.GetXIsHuman()
+	tokenSpan.Fail(err)
+	tokenSpan.End()
 	if err != nil {
 		log.Printf("❌ 获取认证参数失败: %v", err)
-		return nil, fmt.Errorf("获取认证参数失败: %w", err)
+		return nil, upstreamUnavailable(fmt.Errorf("获取认证参数失败: %w", err))
 	}
 
-	requestBody := cs.converter.BuildCursorRequest(messages, model, conversationID, tools)
+	var account *upstreamAccount
+	if cs.accounts != nil {
+		account = cs.accounts.pick(conversationID)
+		cs.accounts.begin(account)
+		log.Printf("👤 使用上游账号: %s", account.Name)
+	}
 
-	// Log request metadata only (no sensitive content)
-	log.Printf("🔵 [Non-Stream] Requesting Cursor API")
-	log.Printf("  └─ Model: %s", model)
-	log.Printf("  └─ ConversationID: %s", conversationID)
-	log.Printf("  └─ Messages Count: %d", len(messages))
-	log.Printf("  └─ Estimated Tokens: %d", cs.converter.EstimateMessagesTokens(messages))
+	// 上游调用 span 覆盖到收到响应头为止,读取 SSE 流另有 span
+	_, span := tracing.Start(ctx, "cursor.chat", tracing.KindClient)
+	defer span.End()
+	span.SetAttribute("http.request.method", http.MethodPost)
+	if u, err := url.Parse(upstream.ChatURL); err == nil {
+		span.SetAttribute("server.address", u.Host)
+	}
+	if account != nil {
+		span.SetAttribute("cursor2api.account", account.Name)
+	}
 
 	resp, err := cs.client.R().
 		SetContext(ctx).
-		SetHeaders(map[string]string{
-			"referer":    "https://cursor.com/cn/learn/context",
-			"x-is-human": xIsHuman,
-			"x-method":   "POST",
-			"x-path":     "/api/chat",
-		}).
+		SetHeaders(cs.buildHeaders(upstream, xIsHuman, account, upstreamTagsFromContext(ctx))).
 		SetBodyString(requestBody).
-		Post("https://cursor.com/api/chat")
+		DisableAutoReadResponse().
+		Post(upstream.ChatURL)
 
 	if err != nil {
 		if ctx.Err() != nil {
 			log.Printf("⚠️  请求被取消: %v", ctx.Err())
+			cs.finishAccount(account, false)
 			return nil, ctx.Err()
 		}
 		log.Printf("❌ 请求失败: %v", err)
-		return nil, fmt.Errorf("请求失败: %w", err)
+		span.Fail(err)
+		trace.noteResponse(account, 0)
+		cs.finishAccount(account, true)
+		return nil, upstreamUnavailable(fmt.Errorf("请求失败: %w", err))
 	}
 
 	log.Printf("✅ 收到响应: HTTP %d", resp.StatusCode)
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+	captureResponseHeaders(ctx, resp.Header)
+	trace.noteResponse(account, resp.StatusCode)
 
 	if !resp.IsSuccessState() {
-		responseBody := resp.String()
+		responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		_ = resp.Body.Close()
 		log.Printf("❌ HTTP 错误: %d", resp.StatusCode)
 		log.Printf("  └─ Response: %s", responseBody)
-		return nil, fmt.Errorf("HTTP错误: %d", resp.StatusCode)
+		// 仅鉴权、限流和服务端错误计入账号健康度,请求本身错误(如 400)不计入
+		serverSide := resp.StatusCode == 401 || resp.StatusCode == 403 || resp.StatusCode == 429 || resp.StatusCode >= 500
+		cs.finishAccount(account, serverSide)
+		err := fmt.Errorf("HTTP错误: %d", resp.StatusCode)
+		span.Fail(err)
+		if serverSide {
+			return nil, upstreamUnavailable(err)
+		}
+		return nil, err
+	}
+
+	cs.finishAccount(account, false)
+	return resp.Body, nil
+}
+
+// finishAccount 记录账号的请求结果,未启用账号池时为空操作
+func (cs *CursorService) finishAccount(account *upstreamAccount, failed bool) {
+	if account != nil {
+		cs.accounts.finish(account, failed)
 	}
+}
 
-	// Parse SSE response to check for tool calls (matching Python implementation)
-	responseBody := resp.String()
-	log.Printf("📥 [Non-Stream] Response received, length: %d bytes", len(responseBody))
+// Chat 非流式聊天 - Returns either text content (types.CursorTextResult) or tool calls (types.CursorToolCallResult)
+func (cs *CursorService) Chat(ctx context.Context, messages []types.ChatMessage, model string, conversationID string, tools []types.Tool) (interface{}, error) {
+	profile := cs.profile(ctx)
+	requestBody := profile.converter.BuildCursorRequest(profile.withSystemPrompt(messages), model, conversationID, tools)
 
-	// Process SSE events to extract content or tool calls
+	// Log request metadata only (no sensitive content)
+	log.Printf("🔵 [Non-Stream] Requesting Cursor API")
+	log.Printf("  └─ Model: %s", model)
+	log.Printf("  └─ ConversationID: %s", conversationID)
+	log.Printf("  └─ Messages Count: %d", len(messages))
+	log.Printf("  └─ Estimated Tokens: %d", cs.converter.EstimateMessagesTokens(messages))
+
+	for attempt := 1; ; attempt++ {
+		result, err := cs.chatAttempt(ctx, profile, requestBody, messages, model, conversationID, tools)
+		text, ok := result.(types.CursorTextResult)
+		if err != nil || !ok || text.Content != "" || text.FinishReason != types.FinishReasonStop {
+			return result, err
+		}
+		switch cs.emptyCompletionAction(model, attempt) {
+		case emptyCompletionRetry:
+			continue
+		case config.EmptyCompletionError:
+			return nil, ErrEmptyCompletion
+		case config.EmptyCompletionPlaceholder:
+			text.Content = cs.stream.EmptyPlaceholder
+		}
+		return text, nil
+	}
+}
+
+// chatAttempt 发起一次非流式上游请求,返回文本结果或本轮的全部工具调用
+// (parallel_tool_calls=false 时只返回第一个)
+func (cs *CursorService) chatAttempt(ctx context.Context, profile upstreamProfile, requestBody string, messages []types.ChatMessage, model string, conversationID string, tools []types.Tool) (interface{}, error) {
+	body, err := cs.openUpstream(ctx, profile.upstream, requestBody, conversationID)
+	if err != nil {
+		if ctx.Err() == nil {
+			cs.recordUpstream(ctx, "error")
+		}
+		traceAttempt(ctx, model, "error")
+		return nil, err
+	}
+	watchdog := watchUpstream(ctx, body, "non_stream")
+	recorder := cs.capture.start(ctx, "non_stream", model, tools)
+	_, span := tracing.Start(ctx, "cursor.sse", tracing.KindInternal)
+	outcome := "error"
+	defer func() {
+		outcome := watchdog.stop(outcome)
+		cs.recordUpstream(ctx, outcome)
+		traceAttempt(ctx, model, outcome)
+		recorder.finish(outcome)
+		endSSESpan(span, "non_stream", outcome)
+		_ = body.Close()
+	}()
+
+	// Parse the SSE body as it arrives instead of buffering the raw response;
+	// only the extracted text is kept (matching Python implementation)
 	var fullContent strings.Builder
-	scanner := bufio.NewScanner(strings.NewReader(responseBody))
+	var termination streamTermination
+	toolIDs := newToolCallIDs(messages)
+	var toolCalls types.CursorToolCalls
+	single := singleToolCallFromContext(ctx)
+	limiter := utils.NewTokenLimiter(maxTokensFromContext(ctx))
+	rawBody := &countingReader{reader: recorder.wrap(cs.chaos.wrapBody(watchdog))}
+	scanner, releaseScanner := newScanner(rawBody, cs.stream.ScannerBuffer)
+	defer releaseScanner()
+	schema := cs.schema.detector()
 	
+scan:
 	for scanner.Scan() {
 		line := scanner.Text()
 		
@@ -101,17 +330,29 @@ func (cs *CursorService) Chat(ctx context.Context, messages []types.ChatMessage,
 			data := after
 			
 			if data == "[DONE]" {
+				termination.done()
 				break
 			}
 			
-			var event types.SSEEventData
-			if err := json.Unmarshal([]byte(data), &event); err != nil {
+			event, err := schema.decode([]byte(data))
+			if err != nil {
 				log.Printf("⚠️  解析 SSE 事件失败: %v, data: %s", err, data)
+				recorder.markMalformed()
 				continue
 			}
 			
+			if termination.observe(event) {
+				break scan
+			}
+			
+			// A step that produced tool calls ends the turn: the client runs the tools
+			// and sends their results in the next request
+			if event.Type == types.SSEEventFinishStep && len(toolCalls) > 0 {
+				break scan
+			}
+			
 			// Check for tool call event - highest priority (matching Python logic)
-			if event.Type == "tool-input-error" && len(tools) > 0 {
+			if event.Type == types.SSEEventToolInputError && len(tools) > 0 {
 				log.Printf("🔧 [Non-Stream] Tool call event detected!")
 				log.Printf("  └─ Tool Call ID: %s", event.ToolCallID)
 				log.Printf("  └─ Original Tool Name: %s", event.ToolName)
@@ -151,52 +392,75 @@ func (cs *CursorService) Chat(ctx context.Context, messages []types.ChatMessage,
 				}
 				
 				toolCall := types.CursorToolCall{
-					ToolID:    event.ToolCallID,
+					ToolID:    toolIDs.assign(event.ToolCallID),
 					ToolName:  correctedToolName,
 					ToolInput: inputJSON,
 				}
 				
 				log.Printf("🔧 [Tool Call] Detected in non-stream mode - ID: %s, Name: %s", toolCall.ToolID, toolCall.ToolName)
 				
-				// Return tool call immediately (matching Python's immediate return)
-				return toolCall, nil
+				// Collect the tool calls of the turn; parallel_tool_calls=false returns the first one only
+				toolCalls = append(toolCalls, toolCall)
+				if single {
+					outcome = types.FinishReasonToolCalls
+					return types.CursorToolCallResult{Calls: toolCalls, Usage: termination.usage}, nil
+				}
+				continue
 			}
 			
 			// Accumulate text content
-			if event.Type == "text-delta" && event.Delta != "" {
-				fullContent.WriteString(event.Delta)
+			if event.Type == types.SSEEventTextDelta && event.Delta != "" {
+				fullContent.WriteString(limiter.Write(event.Delta))
+				if limiter.Reached() {
+					// max_tokens reached: stop reading, closing the body cancels the upstream generation
+					break scan
+				}
 			}
 		}
 	}
 	
-	if err := scanner.Err(); err != nil {
-		log.Printf("❌ Failed to parse response: %v", err)
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+	readErr := scanner.Err()
+	if readErr != nil && ctx.Err() != nil {
+		log.Printf("⚠️  请求被取消: %v", ctx.Err())
+		return nil, ctx.Err()
+	}
+	if len(toolCalls) > 0 {
+		// Each tool call event is complete on its own, so an abort after them loses nothing
+		log.Printf("🔧 [Tool Call] Returning %d tool call(s) in non-stream mode", len(toolCalls))
+		outcome = types.FinishReasonToolCalls
+		return types.CursorToolCallResult{Calls: toolCalls, Usage: termination.usage}, nil
+	}
+	finish := termination.result(readErr)
+	if limiter.Reached() {
+		finish = types.StreamFinish{Reason: types.FinishReasonLength, Usage: finish.Usage}
+	}
+	if finish.Err != nil {
+		log.Printf("❌ [Non-Stream] 上游流异常终止: %v", finish.Err)
+		log.Printf("  └─ Partial content discarded: %d characters", fullContent.Len())
+		return nil, finish.Err
 	}
 	
-	content := fullContent.String()
-	log.Printf("📥 [Non-Stream] Text content extracted, length: %d characters", len(content))
+	content := profile.converter.PostProcess(fullContent.String())
+	log.Printf("📥 [Non-Stream] Response received, length: %d bytes", rawBody.n)
+	span.SetAttribute("cursor2api.response_bytes", rawBody.n)
+	log.Printf("📥 [Non-Stream] Text content extracted, length: %d characters, finish reason: %s", len(content), finish.Reason)
 	
-	return content, nil
+	outcome = finish.Reason
+	return types.CursorTextResult{Content: content, FinishReason: finish.Reason, Usage: finish.Usage}, nil
 }
 
 // StreamChat 流式聊天
 func (cs *CursorService) StreamChat(ctx context.Context, messages []types.ChatMessage, model string, conversationID string, tools []types.Tool) (<-chan interface{}, <-chan error) {
-	dataChan := make(chan interface{}, 10)
+	dataChan := make(chan interface{}, cs.stream.ChannelBuffer)
 	errorChan := make(chan error, 1)
+	sender := &chunkSender{ctx: ctx, ch: dataChan, cfg: cs.stream}
 
 	go func() {
 		defer close(dataChan)
 		defer close(errorChan)
 
-		xIsHuman, err := cs.manager.GetXIsHuman()
-		if err != nil {
-			log.Printf("❌ 获取认证参数失败: %v", err)
-			errorChan <- fmt.Errorf("获取认证参数失败: %w", err)
-			return
-		}
-
-		requestBody := cs.converter.BuildCursorRequest(messages, model, conversationID, tools)
+		profile := cs.profile(ctx)
+		requestBody := profile.converter.BuildCursorRequest(profile.withSystemPrompt(messages), model, conversationID, tools)
 
 		// Log request metadata only (no sensitive content)
 		log.Printf("🟢 [Stream] Requesting Cursor API")
@@ -205,165 +469,240 @@ func (cs *CursorService) StreamChat(ctx context.Context, messages []types.ChatMe
 		log.Printf("  └─ Messages Count: %d", len(messages))
 		log.Printf("  └─ Estimated Tokens: %d", cs.converter.EstimateMessagesTokens(messages))
 
-		resp, err := cs.client.R().
-			SetContext(ctx).
-			SetHeaders(map[string]string{
-				"referer":    "https://cursor.com/cn/learn/context",
-				"x-is-human": xIsHuman,
-				"x-method":   "POST",
-				"x-path":     "/api/chat",
-			}).
-			SetBodyString(requestBody).
-			DisableAutoReadResponse().
-			Post("https://cursor.com/api/chat")
-
-		if err != nil {
-			if ctx.Err() != nil {
-				log.Printf("⚠️  请求被取消: %v", ctx.Err())
-				errorChan <- ctx.Err()
+		for attempt := 1; ; attempt++ {
+			if !cs.streamAttempt(ctx, profile, requestBody, messages, model, conversationID, tools, sender, errorChan, attempt) {
 				return
 			}
-			log.Printf("❌ 请求失败: %v", err)
-			errorChan <- fmt.Errorf("请求失败: %w", err)
-			return
 		}
+	}()
 
-		log.Printf("✅ Response received: HTTP %d", resp.StatusCode)
+	return dataChan, errorChan
+}
 
-		if !resp.IsSuccessState() {
-			log.Printf("❌ HTTP error: %d", resp.StatusCode)
-			errorChan <- fmt.Errorf("HTTP error: %d", resp.StatusCode)
-			return
+// streamAttempt 发起一次上游流式请求并转发其输出,返回 true 表示上游回复为空且应重试
+func (cs *CursorService) streamAttempt(ctx context.Context, profile upstreamProfile, requestBody string, messages []types.ChatMessage, model string, conversationID string, tools []types.Tool, sender *chunkSender, errorChan chan<- error, attempt int) bool {
+	body, err := cs.openUpstream(ctx, profile.upstream, requestBody, conversationID)
+	if err != nil {
+		if ctx.Err() == nil {
+			cs.recordUpstream(ctx, "error")
 		}
+		traceAttempt(ctx, model, "error")
+		errorChan <- err
+		return false
+	}
 
-		log.Printf("📥 [Stream] Starting to receive SSE stream...")
-		chunkCount := 0
-		totalBytes := 0
+	log.Printf("📥 [Stream] Starting to receive SSE stream...")
+	chunkCount := 0
+	totalBytes := 0
+
+	// 创建可中断的 Reader;watchdog 在客户端取消时立即关闭响应体,
+	// 不必等到上游下一次发送数据
+	watchdog := watchUpstream(ctx, body, "stream")
+	recorder := cs.capture.start(ctx, "stream", model, tools)
+	_, span := tracing.Start(ctx, "cursor.sse", tracing.KindInternal)
+	outcome := "error"
+	bodyReader := &contextReader{
+		ctx:    ctx,
+		reader: recorder.wrap(cs.chaos.wrapBody(watchdog)),
+	}
+	defer func() {
+		outcome := watchdog.stop(outcome)
+		cs.recordUpstream(ctx, outcome)
+		traceAttempt(ctx, model, outcome)
+		recorder.finish(outcome)
+		span.SetAttribute("cursor2api.chunks", chunkCount)
+		span.SetAttribute("cursor2api.text_bytes", totalBytes)
+		endSSESpan(span, "stream", outcome)
+		_ = body.Close()
+	}()
+
+	var termination streamTermination
+	toolIDs := newToolCallIDs(messages)
+	interleaved := interleavedToolCallsFromContext(ctx)
+	single := singleToolCallFromContext(ctx)
+	reasoning := features.Enabled(ctx, features.ReasoningContent)
+	toolCallsSent := 0
+	scanner, releaseScanner := newScanner(bodyReader, cs.stream.ScannerBuffer)
+	defer releaseScanner()
+	schema := cs.schema.detector()
+scan:
+	for scanner.Scan() {
+		line := scanner.Text()
 
-		// 创建可中断的 Reader
-		bodyReader := &contextReader{
-			ctx:    ctx,
-			reader: resp.Body,
+		if strings.TrimSpace(line) == "" {
+			continue
 		}
-		defer func() {
-			_ = resp.Body.Close()
-		}()
 
-		scanner := bufio.NewScanner(bodyReader)
-		for scanner.Scan() {
-			line := scanner.Text()
+		if after, ok := strings.CutPrefix(line, "data: "); ok {
+			data := after
+
+			if data == "[DONE]" {
+				log.Printf("✅ [流式] 接收完成,共 %d 个 chunk", chunkCount)
+				termination.done()
+				break
+			}
 
-			if strings.TrimSpace(line) == "" {
+			event, err := schema.decode([]byte(data))
+			if err != nil {
+				log.Printf("⚠️  解析 SSE 事件失败: %v, data: %s", err, data)
+				recorder.markMalformed()
 				continue
 			}
 
-			if after, ok := strings.CutPrefix(line, "data: "); ok {
-				data := after
+			if termination.observe(event) {
+				break scan
+			}
 
-				if data == "[DONE]" {
-					log.Printf("✅ [流式] 接收完成,共 %d 个 chunk", chunkCount)
-					break
-				}
+			// A step that produced tool calls ends the turn unless text and tool calls interleave
+			if event.Type == types.SSEEventFinishStep && toolCallsSent > 0 && !interleaved {
+				break scan
+			}
 
-				var event types.SSEEventData
-				if err := json.Unmarshal([]byte(data), &event); err != nil {
-					log.Printf("⚠️  解析 SSE 事件失败: %v, data: %s", err, data)
-					continue
+			// Handle tool call event - match Python reference implementation
+			if event.Type == types.SSEEventToolInputError && len(tools) > 0 {
+				log.Printf("🔧 [Stream] Tool call event detected!")
+				log.Printf("  └─ Tool Call ID: %s", event.ToolCallID)
+				log.Printf("  └─ Original Tool Name: %s", event.ToolName)
+				log.Printf("  └─ Input Type: %T", event.Input)
+				
+				// Enhanced nil check for Input field
+				if event.Input == nil {
+					log.Printf("⚠️  Tool input is nil, using empty JSON object")
+					event.Input = "{}"
 				}
-
-				// Handle tool call event - match Python reference implementation
-				if event.Type == "tool-input-error" && len(tools) > 0 {
-					log.Printf("🔧 [Stream] Tool call event detected!")
-					log.Printf("  └─ Tool Call ID: %s", event.ToolCallID)
-					log.Printf("  └─ Original Tool Name: %s", event.ToolName)
-					log.Printf("  └─ Input Type: %T", event.Input)
-					
-					// Enhanced nil check for Input field
-					if event.Input == nil {
-						log.Printf("⚠️  Tool input is nil, using empty JSON object")
-						event.Input = "{}"
-					}
-					
-					// First check if Input is already a string (like Python implementation)
-					var inputJSON string
-					if strInput, ok := event.Input.(string); ok {
-						inputJSON = strInput
-						log.Printf("  └─ Input already string, length: %d", len(inputJSON))
-					} else {
-						// Marshal to JSON if it's not a string
-						inputBytes, err := json.Marshal(event.Input)
-						if err != nil {
-							log.Printf("❌ Failed to marshal tool input: %v", err)
-							errorChan <- fmt.Errorf("failed to marshal tool input: %w", err)
-							return
-						}
-						inputJSON = string(inputBytes)
-						log.Printf("  └─ Marshaled input to JSON, length: %d", len(inputJSON))
+				
+				// First check if Input is already a string (like Python implementation)
+				var inputJSON string
+				if strInput, ok := event.Input.(string); ok {
+					inputJSON = strInput
+					log.Printf("  └─ Input already string, length: %d", len(inputJSON))
+				} else {
+					// Marshal to JSON if it's not a string
+					inputBytes, err := json.Marshal(event.Input)
+					if err != nil {
+						log.Printf("❌ Failed to marshal tool input: %v", err)
+						errorChan <- fmt.Errorf("failed to marshal tool input: %w", err)
+						return false
 					}
+					inputJSON = string(inputBytes)
+					log.Printf("  └─ Marshaled input to JSON, length: %d", len(inputJSON))
+				}
 
-					// Match tool name using fuzzy matching (like Python's match_tool_name)
-					correctedToolName := event.ToolName
-					if matchedTool := utils.FindToolByName(event.ToolName, tools); matchedTool != nil {
-						correctedToolName = matchedTool.Function.Name
-						if correctedToolName != event.ToolName {
-							log.Printf("  └─ ✅ Tool name corrected: '%s' → '%s'", event.ToolName, correctedToolName)
-						}
-					} else {
-						log.Printf("  └─ ⚠️  No matching tool found for '%s', using original name", event.ToolName)
+				// Match tool name using fuzzy matching (like Python's match_tool_name)
+				correctedToolName := event.ToolName
+				if matchedTool := utils.FindToolByName(event.ToolName, tools); matchedTool != nil {
+					correctedToolName = matchedTool.Function.Name
+					if correctedToolName != event.ToolName {
+						log.Printf("  └─ ✅ Tool name corrected: '%s' → '%s'", event.ToolName, correctedToolName)
 					}
+				} else {
+					log.Printf("  └─ ⚠️  No matching tool found for '%s', using original name", event.ToolName)
+				}
 
-					toolCall := types.CursorToolCall{
-						ToolID:    event.ToolCallID,
-						ToolName:  correctedToolName,
-						ToolInput: inputJSON,
-					}
+				toolCall := types.CursorToolCall{
+					ToolID:    toolIDs.assign(event.ToolCallID),
+					ToolName:  correctedToolName,
+					ToolInput: inputJSON,
+				}
 
-					log.Printf("🔧 [Tool Call] Detected - ID: %s, Name: %s", toolCall.ToolID, toolCall.ToolName)
-
-					// Send tool call and immediately close stream (critical: like Python's return)
-					select {
-					case <-ctx.Done():
-						log.Printf("⚠️  Context cancelled while sending tool call")
-						return
-					case dataChan <- toolCall:
-						log.Printf("✅ [Tool Call] Sent successfully, closing stream immediately")
-						// Critical: Return immediately after sending tool call, don't continue processing
-						return
-					}
+				log.Printf("🔧 [Tool Call] Detected - ID: %s, Name: %s", toolCall.ToolID, toolCall.ToolName)
+
+				// Send each tool call as it arrives; the handler numbers them in order
+				if err := sender.send(toolCall); err != nil {
+					outcome = cs.sendFailed(err, errorChan)
+					return false
 				}
+				toolCallsSent++
+				if single {
+					// parallel_tool_calls=false: the first tool call ends the response
+					log.Printf("✅ [Tool Call] Sent successfully, closing stream immediately")
+					break scan
+				}
+				continue
+			}
 
-				if event.Type == "text-delta" && event.Delta != "" {
-					chunkCount++
-					totalBytes += len(event.Delta)
-
-					// Send chunk without logging sensitive content
-					select {
-					case <-ctx.Done():
-						log.Printf("⚠️  发送 chunk 时检测到客户端取消")
-						return
-					case dataChan <- event.Delta:
-						// 发送成功
-					}
+			// X-C2A-Features: reasoning_content forwards the upstream's reasoning
+			if event.Type == types.SSEEventReasoningDelta && event.Delta != "" && reasoning {
+				if err := sender.send(types.CursorReasoning(event.Delta)); err != nil {
+					outcome = cs.sendFailed(err, errorChan)
+					return false
 				}
+				continue
 			}
-		}
 
-		// Check scanner errors
-		if err := scanner.Err(); err != nil {
-			// If error is due to context cancellation, return directly
-			if ctx.Err() != nil {
-				log.Printf("⚠️  Client cancelled during stream reading: %v", ctx.Err())
-				return
+			if event.Type == types.SSEEventTextDelta && event.Delta != "" {
+				if toolCallsSent > 0 && !interleaved {
+					// Text after the tool calls of a turn is not part of the OpenAI response
+					continue
+				}
+				delta := profile.converter.PostProcess(event.Delta)
+				if delta == "" {
+					continue
+				}
+				chunkCount++
+				totalBytes += len(delta)
+
+				// Send chunk without logging sensitive content
+				if err := sender.send(delta); err != nil {
+					outcome = cs.sendFailed(err, errorChan)
+					return false
+				}
 			}
-			log.Printf("❌ Failed to read response stream: %v", err)
-			errorChan <- fmt.Errorf("failed to read response stream: %w", err)
-			return
 		}
+	}
 
-		log.Printf("✅ [Stream] Completed - Chunks: %d, Total bytes: %d", chunkCount, totalBytes)
-	}()
+	// Check scanner errors
+	readErr := scanner.Err()
+	if readErr != nil && ctx.Err() != nil {
+		// If error is due to context cancellation, return directly
+		log.Printf("⚠️  Client cancelled during stream reading: %v", ctx.Err())
+		return false
+	}
 
-	return dataChan, errorChan
+	// Report how the upstream stream ended instead of just closing the channel,
+	// so an abort mid-generation is not mistaken for a normal stop
+	finish := termination.result(readErr)
+	if toolCallsSent > 0 && (finish.Reason == types.FinishReasonStop || !interleaved || single) {
+		// 已发送过工具调用,按 OpenAI 语义以 tool_calls 结束;工具调用事件各自完整,
+		// 回合结束后的上游中断不影响响应
+		finish = types.StreamFinish{Reason: types.FinishReasonToolCalls, Usage: finish.Usage}
+	}
+	if finish.Err != nil {
+		log.Printf("❌ [Stream] Upstream aborted - Chunks: %d, Total bytes: %d, Error: %v", chunkCount, totalBytes, finish.Err)
+	} else {
+		log.Printf("✅ [Stream] Completed - Chunks: %d, Total bytes: %d, Finish reason: %s", chunkCount, totalBytes, finish.Reason)
+	}
+	outcome = finish.Reason
+	if chunkCount == 0 && toolCallsSent == 0 && finish.Reason == types.FinishReasonStop {
+		switch cs.emptyCompletionAction(model, attempt) {
+		case emptyCompletionRetry:
+			return true
+		case config.EmptyCompletionError:
+			errorChan <- ErrEmptyCompletion
+			return false
+		case config.EmptyCompletionPlaceholder:
+			if err := sender.send(cs.stream.EmptyPlaceholder); err != nil {
+				outcome = cs.sendFailed(err, errorChan)
+				return false
+			}
+		}
+	}
+	if err := sender.send(finish); err != nil {
+		outcome = cs.sendFailed(err, errorChan)
+	}
+	return false
+}
+
+// sendFailed 处理向 handler 发送失败的情况并返回用于统计的结果
+// 慢消费者会收到错误事件,已取消的客户端则无需通知
+func (cs *CursorService) sendFailed(err error, errorChan chan<- error) string {
+	if errors.Is(err, ErrSlowConsumer) {
+		log.Printf("🐢 [Stream] 客户端消费过慢,终止流式响应 (policy: %s, buffer: %d)", cs.stream.SlowConsumerPolicy, cs.stream.ChannelBuffer)
+		errorChan <- err
+		return "slow_consumer"
+	}
+	log.Printf("⚠️  发送 chunk 时检测到客户端取消")
+	return types.FinishReasonCancelled
 }
 
 // contextReader 包装 io.Reader,使其能响应 context 取消
@@ -386,3 +725,24 @@ func (cr *contextReader) Read(p []byte) (n int, err error) {
 	return cr.reader.Read(p)
 }
 
+// endSSESpan 结束读取上游 SSE 流的 span;上游错误与中断标记为失败,客户端取消不算
+func endSSESpan(span *tracing.Span, mode, outcome string) {
+	span.SetAttribute("cursor2api.mode", mode)
+	span.SetAttribute("cursor2api.outcome", outcome)
+	if outcome == "error" || outcome == types.FinishReasonUpstreamAbort {
+		span.Fail(fmt.Errorf("upstream stream ended with %s", outcome))
+	}
+	span.End()
+}
+
+// countingReader 统计读取的字节数
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.reader.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
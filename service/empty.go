@@ -0,0 +1,31 @@
+package service
+
+import (
+	"errors"
+	"log"
+
+	"cursor2api/metrics"
+)
+
+// ErrEmptyCompletion 上游正常结束但没有返回任何文本或工具调用 (EMPTY_COMPLETION_ACTION=error)
+var ErrEmptyCompletion = errors.New("upstream returned an empty completion")
+
+// emptyCompletionRetry 空回复的处理方式: 重新请求上游 (仅限第一次)
+const emptyCompletionRetry = "retry"
+
+var emptyCompletions = metrics.NewCounter(
+	"cursor2api_empty_completions_total",
+	"Upstream completions without any text or tool call, by model and the action taken (retry, passthrough, placeholder or error).",
+	"model", "action")
+
+// emptyCompletionAction 决定第 attempt 次请求得到空回复后的处理方式并计数
+// 第一次空回复在启用重试时重新请求,之后按 EMPTY_COMPLETION_ACTION 处理
+func (cs *CursorService) emptyCompletionAction(model string, attempt int) string {
+	action := cs.stream.EmptyAction
+	if attempt == 1 && cs.stream.EmptyRetry {
+		action = emptyCompletionRetry
+	}
+	emptyCompletions.Inc(model, action)
+	log.Printf("⚠️  上游返回空回复 (model: %s, 第 %d 次请求), 处理方式: %s", model, attempt, action)
+	return action
+}
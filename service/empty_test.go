@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cursor2api/config"
+	"cursor2api/types"
+)
+
+// newEmptyService returns a service whose mock upstream completes without any text
+func newEmptyService(t *testing.T, action string) *CursorService {
+	t.Helper()
+	previous := config.GlobalConfig
+	t.Cleanup(func() { config.GlobalConfig = previous })
+
+	cfg := &config.Config{}
+	cfg.Mock = config.MockConfig{Enabled: true}
+	cfg.Stream = config.StreamConfig{
+		ChannelBuffer:      4,
+		ScannerBuffer:      64 * 1024,
+		SlowConsumerPolicy: config.SlowConsumerBlock,
+		EmptyRetry:         true,
+		EmptyAction:        action,
+		EmptyPlaceholder:   "(empty)",
+	}
+	config.GlobalConfig = cfg
+	return NewCursorService(nil, cfg)
+}
+
+var emptyMessages = []types.ChatMessage{{Role: "user", Content: "hi"}}
+
+func TestChat_EmptyCompletion(t *testing.T) {
+	tests := []struct {
+		action  string
+		content string
+		err     error
+	}{
+		{config.EmptyCompletionPassthrough, "", nil},
+		{config.EmptyCompletionPlaceholder, "(empty)", nil},
+		{config.EmptyCompletionError, "", ErrEmptyCompletion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			cs := newEmptyService(t, tt.action)
+			result, err := cs.Chat(context.Background(), emptyMessages, "test-model", "", nil)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if text, ok := result.(types.CursorTextResult); !ok || text.Content != tt.content {
+				t.Errorf("result = %#v, want content %q", result, tt.content)
+			}
+		})
+	}
+}
+
+func TestStreamChat_EmptyCompletion(t *testing.T) {
+	tests := []struct {
+		action string
+		chunks []string
+		err    error
+	}{
+		{config.EmptyCompletionPassthrough, nil, nil},
+		{config.EmptyCompletionPlaceholder, []string{"(empty)"}, nil},
+		{config.EmptyCompletionError, nil, ErrEmptyCompletion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			cs := newEmptyService(t, tt.action)
+			dataChan, errorChan := cs.StreamChat(context.Background(), emptyMessages, "test-model", "", nil)
+
+			var (
+				chunks   []string
+				finished bool
+			)
+			for data := range dataChan {
+				switch v := data.(type) {
+				case string:
+					chunks = append(chunks, v)
+				case types.StreamFinish:
+					finished = v.Reason == types.FinishReasonStop
+				}
+			}
+			err := <-errorChan
+
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if tt.err == nil && !finished {
+				t.Error("stream did not finish with stop")
+			}
+			if len(chunks) != len(tt.chunks) || (len(chunks) > 0 && chunks[0] != tt.chunks[0]) {
+				t.Errorf("chunks = %q, want %q", chunks, tt.chunks)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"cursor2api/types"
+)
+
+// errorWindowSeconds 上游错误率的统计窗口 (秒)
+const errorWindowSeconds = 60
+
+// errorWindow 按秒分桶统计最近一分钟上游请求的成功与失败次数
+type errorWindow struct {
+	mu      sync.Mutex
+	buckets [errorWindowSeconds]errorBucket
+}
+
+// errorBucket 一秒内的请求统计
+type errorBucket struct {
+	second int64
+	total  int
+	failed int
+}
+
+// record 记录一次上游请求的结果
+func (ew *errorWindow) record(failed bool, now time.Time) {
+	second := now.Unix()
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	bucket := &ew.buckets[second%errorWindowSeconds]
+	if bucket.second != second {
+		*bucket = errorBucket{second: second}
+	}
+	bucket.total++
+	if failed {
+		bucket.failed++
+	}
+}
+
+// rate 返回窗口内的错误率和请求数
+func (ew *errorWindow) rate(now time.Time) (float64, int) {
+	oldest := now.Unix() - errorWindowSeconds
+	total, failed := 0, 0
+	ew.mu.Lock()
+	for _, bucket := range ew.buckets {
+		if bucket.second > oldest {
+			total += bucket.total
+			failed += bucket.failed
+		}
+	}
+	ew.mu.Unlock()
+	if total == 0 {
+		return 0, 0
+	}
+	return float64(failed) / float64(total), total
+}
+
+// recordUpstream 将一次上游请求的最终结果计入错误率窗口,客户端取消的请求与沙箱请求不计入
+func (cs *CursorService) recordUpstream(ctx context.Context, outcome string) {
+	if outcome == types.FinishReasonCancelled || sandboxFromContext(ctx) {
+		return
+	}
+	cs.upstreamErrors.record(outcome == "error" || outcome == types.FinishReasonUpstreamAbort, time.Now())
+}
+
+// UpstreamErrorRate 返回最近一分钟上游请求的错误率和请求数 (客户端取消的请求不计入)
+func (cs *CursorService) UpstreamErrorRate() (float64, int) {
+	return cs.upstreamErrors.rate(time.Now())
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestErrorWindow(t *testing.T) {
+	var ew errorWindow
+	start := time.Unix(1_700_000_000, 0)
+
+	if rate, total := ew.rate(start); rate != 0 || total != 0 {
+		t.Fatalf("empty window = %v, %d", rate, total)
+	}
+
+	for i := 0; i < 10; i++ {
+		ew.record(i < 3, start.Add(time.Duration(i)*time.Second))
+	}
+	if rate, total := ew.rate(start.Add(10 * time.Second)); total != 10 || rate != 0.3 {
+		t.Errorf("rate = %v over %d, want 0.3 over 10", rate, total)
+	}
+
+	// The three failures at the start fall out of the window first
+	if rate, total := ew.rate(start.Add(62 * time.Second)); total != 7 || rate != 0 {
+		t.Errorf("rate after window moved = %v over %d, want 0 over 7", rate, total)
+	}
+
+	// Reused buckets are reset rather than accumulated
+	ew.record(true, start.Add(60*time.Second))
+	if rate, total := ew.rate(start.Add(60 * time.Second)); total != 10 || rate != 0.3 {
+		t.Errorf("rate after bucket reuse = %v over %d, want 0.3 over 10", rate, total)
+	}
+}
@@ -0,0 +1,21 @@
+package service
+
+import "context"
+
+// maxTokensContextKey is the private context key of the max_tokens of a request
+type maxTokensContextKey struct{}
+
+// WithMaxTokens returns a context whose non-streaming completions stop reading the
+// upstream once maxTokens are generated and finish with "length" (0 = unlimited)
+func WithMaxTokens(ctx context.Context, maxTokens int) context.Context {
+	if maxTokens <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, maxTokensContextKey{}, maxTokens)
+}
+
+// maxTokensFromContext returns the max_tokens of the request, 0 when unlimited
+func maxTokensFromContext(ctx context.Context) int {
+	maxTokens, _ := ctx.Value(maxTokensContextKey{}).(int)
+	return maxTokens
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"cursor2api/types"
+)
+
+const textStream = `data: {"type":"text-delta","delta":"Hello there, "}
+
+data: {"type":"text-delta","delta":"this answer keeps going."}
+
+data: {"type":"finish"}
+
+data: [DONE]
+
+`
+
+func TestChat_MaxTokens(t *testing.T) {
+	tests := []struct {
+		maxTokens int
+		content   string
+		reason    string
+	}{
+		{0, "Hello there, this answer keeps going.", types.FinishReasonStop},
+		{4, "Hello there,", types.FinishReasonLength},
+		{100, "Hello there, this answer keeps going.", types.FinishReasonStop},
+	}
+	for _, tt := range tests {
+		cs := newReplayService(t, textStream)
+		result, err := cs.Chat(WithMaxTokens(context.Background(), tt.maxTokens), emptyMessages, "test-model", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		text, ok := result.(types.CursorTextResult)
+		if !ok || text.Content != tt.content || text.FinishReason != tt.reason {
+			t.Errorf("max_tokens=%d: result = %#v, want %q with %s", tt.maxTokens, result, tt.content, tt.reason)
+		}
+	}
+}
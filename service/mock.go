@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"cursor2api/config"
+	"cursor2api/types"
+)
+
+// mockUpstream 在不访问 Cursor 的情况下生成合成 SSE 流 (MOCK_MODE=true)
+// 合成数据仍经过完整的解析/转换管线,适合压测和客户端联调
+type mockUpstream struct {
+	cfg config.MockConfig
+}
+
+// newMockUpstream 创建模拟上游,未启用时返回 nil
+func newMockUpstream(cfg config.MockConfig) *mockUpstream {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.ReplayFile != "" {
+		log.Printf("🧪 [Mock] 模拟上游已启用 - 重放捕获文件: %s", cfg.ReplayFile)
+	} else {
+		log.Printf("🧪 [Mock] 模拟上游已启用 - chunks: %d, chunk delay: %v", cfg.ChunkCount, cfg.ChunkDelay)
+	}
+	return &mockUpstream{cfg: cfg}
+}
+
+// open 返回逐块写入的合成 SSE 响应体; 配置了 MOCK_REPLAY_FILE 时返回捕获的上游流
+func (m *mockUpstream) open(ctx context.Context, requestBody string) io.ReadCloser {
+	if m.cfg.ReplayFile != "" {
+		file, err := os.Open(m.cfg.ReplayFile)
+		if err != nil {
+			return io.NopCloser(errorReader{fmt.Errorf("open replay file: %w", err)})
+		}
+		return file
+	}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		writeEvent := func(event map[string]interface{}) error {
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(pw, "data: %s\n\n", data)
+			return err
+		}
+
+		for i := 0; i < m.cfg.ChunkCount; i++ {
+			if m.cfg.ChunkDelay > 0 {
+				timer := time.NewTimer(m.cfg.ChunkDelay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					pw.CloseWithError(ctx.Err())
+					return
+				case <-timer.C:
+				}
+			}
+			if err := writeEvent(map[string]interface{}{
+				"type":  types.SSEEventTextDelta,
+				"delta": fmt.Sprintf("mock-%d ", i),
+			}); err != nil {
+				return // 读取端已关闭
+			}
+		}
+
+		outputTokens := m.cfg.ChunkCount * 2
+		inputTokens := len(requestBody) / 4
+		_ = writeEvent(map[string]interface{}{
+			"type": types.SSEEventFinish,
+			"messageMetadata": map[string]interface{}{
+				"usage": map[string]int{
+					"inputTokens":  inputTokens,
+					"outputTokens": outputTokens,
+					"totalTokens":  inputTokens + outputTokens,
+				},
+			},
+		})
+		_, _ = io.WriteString(pw, "data: [DONE]\n\n")
+		_ = pw.Close()
+	}()
+
+	return pr
+}
+
+// errorReader 每次读取都返回同一个错误
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"cursor2api/config"
+	"cursor2api/metrics"
+	"cursor2api/types"
+)
+
+// schemaMinSample 判断未知事件比例前至少需要观察的事件数
+const schemaMinSample = 5
+
+// maxUnknownEventTypes 按类型统计的未知事件类型上限,之后的新类型计入 "other",避免标签无限增长
+const maxUnknownEventTypes = 20
+
+var (
+	upstreamUnknownEvents = metrics.NewCounter(
+		"cursor2api_upstream_unknown_events_total",
+		"Upstream SSE events whose type the active parser does not know, by parser.",
+		"parser")
+
+	upstreamUnknownEventTypes = metrics.NewCounter(
+		"cursor2api_upstream_unknown_event_types_total",
+		"Upstream SSE events whose type the active parser does not know, by parser and event type.",
+		"parser", "type")
+
+	upstreamSchemaSwitches = metrics.NewCounter(
+		"cursor2api_upstream_schema_switches_total",
+		"Switches between upstream SSE parser strategies after detecting another event schema.",
+		"from", "to")
+)
+
+// eventParser 一种上游 SSE 事件协议的解析策略,将事件统一转换为当前的 SSEEventData 格式
+type eventParser struct {
+	name   string
+	known  map[string]bool
+	decode func(data []byte) (event types.SSEEventData, rawType string, err error)
+}
+
+// eventParsers 已注册的解析策略,第一个为默认策略
+var eventParsers []*eventParser
+
+// registerEventParser 注册一种解析策略
+func registerEventParser(name string, eventTypes []string, decode func([]byte) (types.SSEEventData, string, error)) {
+	known := make(map[string]bool, len(eventTypes))
+	for _, t := range eventTypes {
+		known[t] = true
+	}
+	eventParsers = append(eventParsers, &eventParser{name: name, known: known, decode: decode})
+}
+
+func init() {
+	// 当前协议: AI SDK v5 UI message stream
+	var current []string
+	for _, t := range types.SSEEventTypes() {
+		current = append(current, string(t))
+	}
+	registerEventParser("ui-message-v5", current, func(data []byte) (types.SSEEventData, string, error) {
+		event, err := types.DecodeSSEEvent(data)
+		return event, string(event.Type), err
+	})
+
+	// 旧协议: AI SDK v4 stream parts (textDelta、tool-call、step-* 事件)
+	registerEventParser("stream-part-v4", []string{
+		"step-start", "step-finish", "finish", "error", "text-delta", "reasoning", "reasoning-signature",
+		"redacted-reasoning", "tool-call", "tool-call-streaming-start", "tool-call-delta", "tool-result", "source", "file",
+	}, decodeStreamPartV4)
+}
+
+// streamPartV4 AI SDK v4 的流事件
+type streamPartV4 struct {
+	Type         string      `json:"type"`
+	TextDelta    string      `json:"textDelta"`
+	ToolCallID   string      `json:"toolCallId"`
+	ToolName     string      `json:"toolName"`
+	Args         interface{} `json:"args"`
+	FinishReason string      `json:"finishReason"`
+	Error        interface{} `json:"error"`
+	Usage        *struct {
+		PromptTokens     int `json:"promptTokens"`
+		CompletionTokens int `json:"completionTokens"`
+	} `json:"usage"`
+}
+
+// decodeStreamPartV4 将 v4 事件转换为当前格式; tool-call 对应当前协议中携带工具输入的 tool-input-error
+func decodeStreamPartV4(data []byte) (types.SSEEventData, string, error) {
+	var part streamPartV4
+	if err := json.Unmarshal(data, &part); err != nil {
+		return types.SSEEventData{}, "", err
+	}
+	event := types.SSEEventData{Type: types.SSEEventType(part.Type), FinishReason: part.FinishReason}
+	switch part.Type {
+	case "text-delta":
+		event.Delta = part.TextDelta
+	case "tool-call":
+		event.Type = types.SSEEventToolInputError
+		event.ToolCallID = part.ToolCallID
+		event.ToolName = part.ToolName
+		event.Input = part.Args
+	case "error":
+		event.ErrorText = fmt.Sprint(part.Error)
+	}
+	if part.Usage != nil {
+		event.MessageMetadata = &types.MessageMetadata{Usage: types.Usage{
+			InputTokens:  part.Usage.PromptTokens,
+			OutputTokens: part.Usage.CompletionTokens,
+			TotalTokens:  part.Usage.PromptTokens + part.Usage.CompletionTokens,
+		}}
+	}
+	return event, part.Type, nil
+}
+
+// unknownEventLabels 已作为指标标签出现的未知事件类型
+var unknownEventLabels = struct {
+	sync.Mutex
+	seen map[string]bool
+}{seen: make(map[string]bool)}
+
+// unknownEventLabel 返回未知事件类型的指标标签,超过 maxUnknownEventTypes 种之后统一为 "other"
+func unknownEventLabel(rawType string) string {
+	unknownEventLabels.Lock()
+	defer unknownEventLabels.Unlock()
+	if unknownEventLabels.seen[rawType] {
+		return rawType
+	}
+	if len(unknownEventLabels.seen) >= maxUnknownEventTypes {
+		return "other"
+	}
+	unknownEventLabels.seen[rawType] = true
+	return rawType
+}
+
+// schemaRegistry 记住最近检测到的协议,新的流直接从该策略开始
+type schemaRegistry struct {
+	preferred atomic.Int32 // eventParsers 下标
+	threshold float64
+	window    int
+}
+
+// newSchemaRegistry 创建协议检测配置
+func newSchemaRegistry(cfg config.StreamConfig) *schemaRegistry {
+	return &schemaRegistry{threshold: cfg.UnknownEventThreshold, window: cfg.SchemaDetectEvents}
+}
+
+// detector 为一个上游流创建协议检测器
+func (sr *schemaRegistry) detector() *schemaDetector {
+	return &schemaDetector{
+		registry: sr,
+		active:   int(sr.preferred.Load()),
+		unknown:  make([]int, len(eventParsers)),
+	}
+}
+
+// schemaDetector 检查流的前若干个事件: 当前策略的未知事件类型比例超过阈值时,
+// 切换到未知事件最少的策略; 没有更合适的策略时明确警告上游协议可能已变更
+type schemaDetector struct {
+	registry     *schemaRegistry
+	active       int
+	seen         int
+	unknown      []int    // 检测窗口内各策略不认识的事件数
+	unknownTypes []string // 用于告警的未知事件类型样本
+	warned       bool
+}
+
+// decode 用当前策略解析一个事件,并在检测窗口内更新协议判断
+func (d *schemaDetector) decode(data []byte) (types.SSEEventData, error) {
+	event, rawType, err := eventParsers[d.active].decode(data)
+	if err != nil {
+		return event, err
+	}
+	if !eventParsers[d.active].known[rawType] {
+		upstreamUnknownEvents.Inc(eventParsers[d.active].name)
+		upstreamUnknownEventTypes.Inc(eventParsers[d.active].name, unknownEventLabel(rawType))
+	}
+	if d.seen >= d.registry.window {
+		return event, nil
+	}
+
+	d.seen++
+	for i, p := range eventParsers {
+		if !p.known[rawType] {
+			d.unknown[i]++
+		}
+	}
+	if !eventParsers[d.active].known[rawType] && len(d.unknownTypes) < 5 && !slices.Contains(d.unknownTypes, rawType) {
+		d.unknownTypes = append(d.unknownTypes, rawType)
+	}
+	if d.seen < schemaMinSample || float64(d.unknown[d.active]) <= d.registry.threshold*float64(d.seen) {
+		return event, nil
+	}
+
+	best := d.active
+	for i := range eventParsers {
+		if d.unknown[i] < d.unknown[best] {
+			best = i
+		}
+	}
+	if best != d.active {
+		from, to := eventParsers[d.active].name, eventParsers[best].name
+		log.Printf("⚠️  检测到上游 SSE 协议变化,切换解析策略: %s → %s", from, to)
+		log.Printf("  └─ Unknown Events: %d/%d (types: %s)", d.unknown[d.active], d.seen, strings.Join(d.unknownTypes, ", "))
+		upstreamSchemaSwitches.Inc(from, to)
+		d.registry.preferred.Store(int32(best))
+		d.active = best
+		d.unknownTypes = nil
+		// 用新策略重新解析当前事件
+		event, _, err = eventParsers[best].decode(data)
+		return event, err
+	}
+	if !d.warned {
+		d.warned = true
+		log.Printf("⚠️  上游 SSE 未知事件类型比例超过阈值,Cursor 可能更改了事件协议")
+		log.Printf("  └─ Parser: %s", eventParsers[d.active].name)
+		log.Printf("  └─ Unknown Events: %d/%d (threshold: %.0f%%)", d.unknown[d.active], d.seen, d.registry.threshold*100)
+		log.Printf("  └─ Unknown Types: %s", strings.Join(d.unknownTypes, ", "))
+	}
+	return event, nil
+}
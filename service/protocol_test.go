@@ -0,0 +1,101 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	"cursor2api/config"
+)
+
+func TestSchemaDetector_CurrentSchema(t *testing.T) {
+	registry := newSchemaRegistry(config.StreamConfig{UnknownEventThreshold: 0.3, SchemaDetectEvents: 20})
+	d := registry.detector()
+	for _, data := range []string{
+		`{"type":"start"}`, `{"type":"text-start","id":"0"}`, `{"type":"text-delta","id":"0","delta":"Hi"}`,
+		`{"type":"some-new-event"}`, `{"type":"text-end","id":"0"}`, `{"type":"finish"}`,
+	} {
+		if _, err := d.decode([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d.active != 0 || d.warned {
+		t.Errorf("one unknown event in six must not switch or warn: active=%d warned=%v", d.active, d.warned)
+	}
+}
+
+func TestSchemaDetector_SwitchesParser(t *testing.T) {
+	registry := newSchemaRegistry(config.StreamConfig{UnknownEventThreshold: 0.3, SchemaDetectEvents: 20})
+	d := registry.detector()
+
+	events := []string{
+		`{"type":"step-start","messageId":"m1"}`,
+		`{"type":"text-delta","textDelta":"Hel"}`,
+		`{"type":"step-finish","finishReason":"stop"}`,
+		`{"type":"step-start","messageId":"m2"}`,
+		`{"type":"reasoning","textDelta":"hmm"}`,
+		`{"type":"text-delta","textDelta":"lo"}`,
+		`{"type":"tool-call","toolCallId":"c1","toolName":"read","args":{"path":"a"}}`,
+		`{"type":"finish","finishReason":"stop","usage":{"promptTokens":3,"completionTokens":2}}`,
+	}
+	var text string
+	for _, data := range events {
+		event, err := d.decode([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch event.Type {
+		case "text-delta":
+			text += event.Delta
+		case "tool-input-error":
+			if event.ToolName != "read" || event.ToolCallID != "c1" || event.Input == nil {
+				t.Errorf("tool call = %+v", event)
+			}
+		case "finish":
+			if event.MessageMetadata == nil || event.MessageMetadata.Usage.TotalTokens != 5 {
+				t.Errorf("finish usage = %+v", event.MessageMetadata)
+			}
+		}
+	}
+	if eventParsers[d.active].name != "stream-part-v4" {
+		t.Fatalf("active parser = %s, want stream-part-v4", eventParsers[d.active].name)
+	}
+	// The first delta was decoded before the switch and lost its text
+	if text != "lo" {
+		t.Errorf("text = %q, want %q", text, "lo")
+	}
+	if next := registry.detector(); next.active != d.active {
+		t.Error("new streams must start with the detected parser")
+	}
+}
+
+func TestSchemaDetector_WarnsWithoutBetterParser(t *testing.T) {
+	registry := newSchemaRegistry(config.StreamConfig{UnknownEventThreshold: 0.3, SchemaDetectEvents: 20})
+	d := registry.detector()
+	for i := 0; i < 6; i++ {
+		if _, err := d.decode([]byte(`{"type":"content-chunk","value":"x"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d.active != 0 || !d.warned || len(d.unknownTypes) != 1 {
+		t.Errorf("active=%d warned=%v unknownTypes=%v", d.active, d.warned, d.unknownTypes)
+	}
+}
+
+func TestUnknownEventLabel_Bounded(t *testing.T) {
+	previous := unknownEventLabels.seen
+	unknownEventLabels.seen = make(map[string]bool)
+	defer func() { unknownEventLabels.seen = previous }()
+
+	for i := 0; i < maxUnknownEventTypes; i++ {
+		name := fmt.Sprintf("new-event-%d", i)
+		if got := unknownEventLabel(name); got != name {
+			t.Fatalf("label = %q, want %q", got, name)
+		}
+	}
+	if got := unknownEventLabel("one-too-many"); got != "other" {
+		t.Errorf("label past the limit = %q, want other", got)
+	}
+	if got := unknownEventLabel("new-event-0"); got != "new-event-0" {
+		t.Errorf("label of a seen type = %q, want it kept", got)
+	}
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"cursor2api/features"
+	"cursor2api/types"
+)
+
+// reasoningStream is an upstream turn that reasons before answering
+const reasoningStream = `data: {"type":"reasoning-start","id":"r1"}
+
+data: {"type":"reasoning-delta","id":"r1","delta":"Think "}
+
+data: {"type":"reasoning-delta","id":"r1","delta":"first."}
+
+data: {"type":"reasoning-end","id":"r1"}
+
+data: {"type":"text-delta","delta":"Answer."}
+
+data: {"type":"finish"}
+
+data: [DONE]
+
+`
+
+func TestStreamChat_ReasoningContent(t *testing.T) {
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		reasoning string
+	}{
+		{"disabled", context.Background(), ""},
+		{"enabled", features.WithSet(context.Background(), features.Set{features.ReasoningContent: true}), "Think first."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := newReplayService(t, reasoningStream)
+			dataChan, errorChan := cs.StreamChat(tt.ctx, emptyMessages, "test-model", "", nil)
+
+			var text, reasoning string
+			var finish types.StreamFinish
+			for data := range dataChan {
+				switch v := data.(type) {
+				case string:
+					text += v
+				case types.CursorReasoning:
+					if text != "" {
+						t.Errorf("reasoning %q after the text", v)
+					}
+					reasoning += string(v)
+				case types.StreamFinish:
+					finish = v
+				}
+			}
+			if err := <-errorChan; err != nil {
+				t.Fatal(err)
+			}
+			if text != "Answer." || reasoning != tt.reasoning {
+				t.Errorf("text = %q, reasoning = %q, want %q", text, reasoning, tt.reasoning)
+			}
+			if finish.Reason != types.FinishReasonStop {
+				t.Errorf("finish = %+v, want stop", finish)
+			}
+		})
+	}
+}
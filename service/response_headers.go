@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// UpstreamHeaderPrefix prefixes upstream response headers surfaced to clients
+const UpstreamHeaderPrefix = "X-Upstream-"
+
+// responseHeadersContextKey is the private context key for the response header collector
+type responseHeadersContextKey struct{}
+
+// ResponseHeaders collects selected upstream response headers of a request so the
+// handler can surface them to the client, e.g. upstream request IDs for debugging.
+// A nil *ResponseHeaders collects nothing.
+type ResponseHeaders struct {
+	mu     sync.Mutex
+	names  []string
+	header http.Header
+}
+
+// WithResponseHeaders returns a context whose upstream responses have the named
+// headers collected into the returned ResponseHeaders; no names disables collection
+func WithResponseHeaders(ctx context.Context, names []string) (context.Context, *ResponseHeaders) {
+	if len(names) == 0 {
+		return ctx, nil
+	}
+	collector := &ResponseHeaders{names: names}
+	return context.WithValue(ctx, responseHeadersContextKey{}, collector), collector
+}
+
+// captureResponseHeaders records the configured headers of an upstream response;
+// a later attempt (e.g. a JSON mode retry) replaces the earlier values
+func captureResponseHeaders(ctx context.Context, src http.Header) {
+	collector, _ := ctx.Value(responseHeadersContextKey{}).(*ResponseHeaders)
+	if collector == nil {
+		return
+	}
+	header := make(http.Header, len(collector.names))
+	for _, name := range collector.names {
+		// Never hand out the upstream account's session cookies
+		if strings.EqualFold(name, "Set-Cookie") {
+			continue
+		}
+		if values := src.Values(name); len(values) > 0 {
+			header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	collector.mu.Lock()
+	collector.header = header
+	collector.mu.Unlock()
+}
+
+// CopyTo adds the collected headers to dst as X-Upstream-<name>, dropping a leading
+// "X-" of the upstream name (x-request-id becomes X-Upstream-Request-Id)
+func (h *ResponseHeaders) CopyTo(dst http.Header) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for name, values := range h.header {
+		key := UpstreamHeaderPrefix + strings.TrimPrefix(name, "X-")
+		dst.Del(key)
+		for _, v := range values {
+			dst.Add(key, v)
+		}
+	}
+}
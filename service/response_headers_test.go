@@ -0,0 +1,43 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestResponseHeaders_CopyTo(t *testing.T) {
+	ctx, collector := WithResponseHeaders(context.Background(), []string{"x-request-id", "X-RateLimit-Remaining", "Set-Cookie", "X-Missing"})
+
+	upstream := http.Header{}
+	upstream.Set("X-Request-Id", "req_123")
+	upstream.Set("X-Ratelimit-Remaining", "42")
+	upstream.Set("Set-Cookie", "session=secret")
+	upstream.Set("Server", "cloudflare")
+	captureResponseHeaders(ctx, upstream)
+
+	dst := http.Header{}
+	collector.CopyTo(dst)
+	if got := dst.Get("X-Upstream-Request-Id"); got != "req_123" {
+		t.Fatalf("X-Upstream-Request-Id = %q", got)
+	}
+	if got := dst.Get("X-Upstream-Ratelimit-Remaining"); got != "42" {
+		t.Fatalf("X-Upstream-Ratelimit-Remaining = %q", got)
+	}
+	if len(dst) != 2 {
+		t.Fatalf("headers = %v, want only the two configured and present ones", dst)
+	}
+}
+
+func TestResponseHeaders_Disabled(t *testing.T) {
+	ctx, collector := WithResponseHeaders(context.Background(), nil)
+	if collector != nil {
+		t.Fatal("no configured names should disable collection")
+	}
+	captureResponseHeaders(ctx, http.Header{"X-Request-Id": {"req_1"}})
+	dst := http.Header{}
+	collector.CopyTo(dst)
+	if len(dst) != 0 {
+		t.Fatalf("headers = %v, want none", dst)
+	}
+}
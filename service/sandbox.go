@@ -0,0 +1,18 @@
+package service
+
+import "context"
+
+// sandboxContextKey is the private context key marking sandbox requests
+type sandboxContextKey struct{}
+
+// WithSandbox returns a context whose upstream requests are answered by the mock
+// upstream instead of Cursor, e.g. for sandbox API keys used during client integration
+func WithSandbox(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sandboxContextKey{}, true)
+}
+
+// sandboxFromContext reports whether a request must not reach the real upstream
+func sandboxFromContext(ctx context.Context) bool {
+	sandbox, _ := ctx.Value(sandboxContextKey{}).(bool)
+	return sandbox
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"cursor2api/config"
+	"cursor2api/metrics"
+)
+
+// ErrSlowConsumer 客户端消费过慢,缓冲区已满且超过了慢消费者策略允许的等待
+var ErrSlowConsumer = errors.New("client is consuming the stream too slowly")
+
+var (
+	backpressureEvents = metrics.NewCounter(
+		"cursor2api_stream_backpressure_total",
+		"Stream sends that found the client buffer full, by what happened next: blocked (then delivered), timeout or dropped.",
+		"event")
+
+	backpressureWait = metrics.NewHistogram(
+		"cursor2api_stream_backpressure_wait_seconds",
+		"Time the upstream reader spent blocked on a full client buffer.",
+		[]float64{0.001, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60})
+)
+
+// chunkSender 按慢消费者策略向 handler 发送数据,避免慢客户端无限期拖住上游读取
+type chunkSender struct {
+	ctx context.Context
+	ch  chan<- interface{}
+	cfg config.StreamConfig
+}
+
+// send 发送一个值;返回 ctx.Err() 表示客户端已取消,ErrSlowConsumer 表示按策略放弃
+func (s *chunkSender) send(v interface{}) error {
+	// 快速路径: 缓冲区未满
+	select {
+	case s.ch <- v:
+		return nil
+	default:
+	}
+
+	if s.cfg.SlowConsumerPolicy == config.SlowConsumerDrop {
+		backpressureEvents.Inc("dropped")
+		return ErrSlowConsumer
+	}
+
+	var deadline <-chan time.Time
+	if s.cfg.SendTimeout > 0 {
+		timer := time.NewTimer(s.cfg.SendTimeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	start := time.Now()
+	select {
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	case s.ch <- v:
+		backpressureEvents.Inc("blocked")
+		backpressureWait.Observe(time.Since(start).Seconds())
+		return nil
+	case <-deadline:
+		backpressureEvents.Inc("timeout")
+		backpressureWait.Observe(time.Since(start).Seconds())
+		return ErrSlowConsumer
+	}
+}
+
+// scanBufferSize 扫描器初始缓冲区大小,单行超过时 bufio 自行扩容至 maxLine
+const scanBufferSize = 64 * 1024
+
+// scanBuffers 复用上游流的扫描器初始缓冲区,流结束后归还,降低流式负载下的分配与 GC 压力
+var scanBuffers = sync.Pool{New: func() any {
+	buf := make([]byte, scanBufferSize)
+	return &buf
+}}
+
+// newScanner 创建按配置限制单行大小的 SSE 扫描器
+// 返回的 release 在扫描结束后调用,归还池化的缓冲区,之后不能再使用该扫描器
+func newScanner(r io.Reader, maxLine int) (*bufio.Scanner, func()) {
+	scanner := bufio.NewScanner(r)
+	if maxLine <= 0 {
+		return scanner, func() {}
+	}
+	if maxLine < scanBufferSize {
+		scanner.Buffer(make([]byte, 0, maxLine), maxLine)
+		return scanner, func() {}
+	}
+	buf := scanBuffers.Get().(*[]byte)
+	scanner.Buffer((*buf)[:0], maxLine)
+	return scanner, func() { scanBuffers.Put(buf) }
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"cursor2api/config"
+)
+
+func TestChunkSender_DropPolicy(t *testing.T) {
+	ch := make(chan interface{}, 1)
+	sender := &chunkSender{ctx: context.Background(), ch: ch, cfg: config.StreamConfig{SlowConsumerPolicy: config.SlowConsumerDrop}}
+
+	if err := sender.send("a"); err != nil {
+		t.Fatalf("send with free buffer: %v", err)
+	}
+	if err := sender.send("b"); !errors.Is(err, ErrSlowConsumer) {
+		t.Fatalf("send on full buffer = %v, want ErrSlowConsumer", err)
+	}
+}
+
+func TestChunkSender_BlockPolicy(t *testing.T) {
+	ch := make(chan interface{}, 1)
+	sender := &chunkSender{ctx: context.Background(), ch: ch, cfg: config.StreamConfig{
+		SlowConsumerPolicy: config.SlowConsumerBlock,
+		SendTimeout:        50 * time.Millisecond,
+	}}
+
+	_ = sender.send("a")
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-ch
+	}()
+	if err := sender.send("b"); err != nil {
+		t.Fatalf("send should wait for the consumer: %v", err)
+	}
+
+	start := time.Now()
+	if err := sender.send("c"); !errors.Is(err, ErrSlowConsumer) {
+		t.Fatalf("send past deadline = %v, want ErrSlowConsumer", err)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Fatalf("gave up after %s, before the send timeout", waited)
+	}
+}
+
+func TestChunkSender_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sender := &chunkSender{ctx: ctx, ch: make(chan interface{}), cfg: config.StreamConfig{SlowConsumerPolicy: config.SlowConsumerBlock}}
+
+	if err := sender.send("a"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("send after cancel = %v, want context.Canceled", err)
+	}
+}
+
+func TestNewScanner_PooledBufferGrowsToMaxLine(t *testing.T) {
+	long := strings.Repeat("x", scanBufferSize*2)
+	scanner, release := newScanner(strings.NewReader("data: "+long+"\ndata: [DONE]\n"), scanBufferSize*4)
+
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	release()
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if len(lines) != 2 || len(lines[0]) != len(long)+len("data: ") {
+		t.Fatalf("lines = %d, first length %d", len(lines), len(lines[0]))
+	}
+
+	// Lines above maxLine still fail after the buffer came from the pool
+	scanner, release = newScanner(strings.NewReader(long+"\n"), scanBufferSize)
+	defer release()
+	for scanner.Scan() {
+	}
+	if scanner.Err() == nil {
+		t.Fatal("line longer than maxLine should fail")
+	}
+}
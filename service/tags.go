@@ -0,0 +1,22 @@
+package service
+
+import "context"
+
+// upstreamTagsContextKey is the private context key for per-request tagging headers
+type upstreamTagsContextKey struct{}
+
+// WithUpstreamTags returns a context whose upstream requests carry the given tagging
+// headers (validated with upstream.ValidateTagHeaders), e.g. to attribute traffic to
+// a tenant or API key
+func WithUpstreamTags(ctx context.Context, tags map[string]string) context.Context {
+	if len(tags) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, upstreamTagsContextKey{}, tags)
+}
+
+// upstreamTagsFromContext returns the tagging headers of a request
+func upstreamTagsFromContext(ctx context.Context) map[string]string {
+	tags, _ := ctx.Value(upstreamTagsContextKey{}).(map[string]string)
+	return tags
+}
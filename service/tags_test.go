@@ -0,0 +1,30 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"cursor2api/config"
+)
+
+func TestBuildHeaders_UpstreamTags(t *testing.T) {
+	cs := &CursorService{}
+	upstream := config.CursorConfig{ExtraHeaders: map[string]string{"X-Profile": "global"}, XPath: "/api/chat"}
+	account := &upstreamAccount{Name: "a", Headers: map[string]string{"X-Account": "account"}}
+	ctx := WithUpstreamTags(context.Background(), map[string]string{"X-Profile": "tenant", "X-Account": "tagged"})
+
+	headers := cs.buildHeaders(upstream, "token", account, upstreamTagsFromContext(ctx))
+
+	if headers["X-Profile"] != "tenant" {
+		t.Fatalf("tag must override extra headers, got %q", headers["X-Profile"])
+	}
+	if headers["X-Account"] != "account" {
+		t.Fatalf("account headers must win over tags, got %q", headers["X-Account"])
+	}
+	if headers["x-is-human"] != "token" || headers["x-path"] != "/api/chat" {
+		t.Fatalf("proxy headers changed: %v", headers)
+	}
+	if tags := upstreamTagsFromContext(context.Background()); tags != nil {
+		t.Fatalf("context without tags returned %v", tags)
+	}
+}
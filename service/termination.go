@@ -0,0 +1,98 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"cursor2api/types"
+)
+
+// ErrUpstreamAborted 上游在正常结束 (finish 事件或 [DONE]) 之前终止了流
+var ErrUpstreamAborted = errors.New("upstream aborted the stream")
+
+// ErrUpstreamUnavailable 上游在返回任何数据之前因服务端原因失败 (连接失败、认证参数不可用、5xx/429 等)
+// 错误信息保持原样,调用方用 errors.Is 识别,例如退还客户端被消耗的限流令牌
+var ErrUpstreamUnavailable = errors.New("upstream unavailable")
+
+// unavailableError 为错误附加 ErrUpstreamUnavailable 标记,不改变错误信息
+type unavailableError struct{ err error }
+
+func (e unavailableError) Error() string   { return e.err.Error() }
+func (e unavailableError) Unwrap() []error { return []error{e.err, ErrUpstreamUnavailable} }
+
+// upstreamUnavailable 将错误标记为上游不可用
+func upstreamUnavailable(err error) error {
+	return unavailableError{err: err}
+}
+
+// streamTermination 跟踪上游 SSE 流如何结束,用于区分正常完成、长度截断与上游中断
+type streamTermination struct {
+	finished bool
+	reason   string
+	err      error
+	usage    *types.Usage // 最近一次 messageMetadata 报告的用量
+}
+
+// observe 根据 SSE 事件更新终止状态,返回 true 表示应立即停止读取
+func (t *streamTermination) observe(event types.SSEEventData) bool {
+	if event.MessageMetadata != nil {
+		usage := event.MessageMetadata.Usage
+		t.usage = &usage
+	}
+	switch event.Type {
+	case types.SSEEventFinish:
+		t.finished = true
+		t.reason = upstreamFinishReason(event.FinishReason)
+		if t.reason == types.FinishReasonUpstreamAbort {
+			t.err = fmt.Errorf("%w: finish reason %q", ErrUpstreamAborted, event.FinishReason)
+			return true
+		}
+	case types.SSEEventError:
+		t.abort(fmt.Errorf("%w: %s", ErrUpstreamAborted, event.ErrorText))
+		return true
+	case types.SSEEventAbort:
+		t.abort(fmt.Errorf("%w: abort event", ErrUpstreamAborted))
+		return true
+	}
+	return false
+}
+
+// done 处理 [DONE] 标记,未收到 finish 事件时视为正常完成
+func (t *streamTermination) done() {
+	if !t.finished {
+		t.finished = true
+		t.reason = types.FinishReasonStop
+	}
+}
+
+func (t *streamTermination) abort(err error) {
+	t.finished = true
+	t.reason = types.FinishReasonUpstreamAbort
+	t.err = err
+}
+
+// result 结合读取错误返回最终的终止状态
+// finish 事件之后的读取错误不影响结果,内容已完整送达; 已报告的用量在每种结果中都保留
+func (t *streamTermination) result(readErr error) types.StreamFinish {
+	if t.finished {
+		return types.StreamFinish{Reason: t.reason, Err: t.err, Usage: t.usage}
+	}
+	if readErr != nil {
+		return types.StreamFinish{Reason: types.FinishReasonUpstreamAbort, Err: fmt.Errorf("%w: %v", ErrUpstreamAborted, readErr), Usage: t.usage}
+	}
+	return types.StreamFinish{Reason: types.FinishReasonUpstreamAbort, Err: fmt.Errorf("%w: stream closed before completion", ErrUpstreamAborted), Usage: t.usage}
+}
+
+// upstreamFinishReason 将上游 (AI SDK 风格) 的 finishReason 映射为 OpenAI 取值
+func upstreamFinishReason(reason string) string {
+	switch reason {
+	case "length":
+		return types.FinishReasonLength
+	case "content-filter":
+		return types.FinishReasonContentFilter
+	case "error":
+		return types.FinishReasonUpstreamAbort
+	default:
+		return types.FinishReasonStop
+	}
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"cursor2api/types"
+)
+
+func TestStreamTermination(t *testing.T) {
+	tests := []struct {
+		name    string
+		events  []types.SSEEventData
+		done    bool
+		readErr error
+		reason  string
+		aborted bool
+	}{
+		{name: "done marker", done: true, reason: types.FinishReasonStop},
+		{name: "finish event", events: []types.SSEEventData{{Type: "finish"}}, reason: types.FinishReasonStop},
+		{name: "finish then reset", events: []types.SSEEventData{{Type: "finish"}}, readErr: io.ErrUnexpectedEOF, reason: types.FinishReasonStop},
+		{name: "length cutoff", events: []types.SSEEventData{{Type: "finish", FinishReason: "length"}}, done: true, reason: types.FinishReasonLength},
+		{name: "content filter", events: []types.SSEEventData{{Type: "finish", FinishReason: "content-filter"}}, reason: types.FinishReasonContentFilter},
+		{name: "eof without finish", events: []types.SSEEventData{{Type: "text-delta", Delta: "hi"}}, reason: types.FinishReasonUpstreamAbort, aborted: true},
+		{name: "connection reset", readErr: io.ErrUnexpectedEOF, reason: types.FinishReasonUpstreamAbort, aborted: true},
+		{name: "error event", events: []types.SSEEventData{{Type: "error", ErrorText: "overloaded"}}, reason: types.FinishReasonUpstreamAbort, aborted: true},
+		{name: "abort event", events: []types.SSEEventData{{Type: "abort"}}, done: true, reason: types.FinishReasonUpstreamAbort, aborted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var term streamTermination
+			for _, event := range tt.events {
+				if term.observe(event) {
+					break
+				}
+			}
+			if tt.done {
+				term.done()
+			}
+
+			finish := term.result(tt.readErr)
+			if finish.Reason != tt.reason {
+				t.Fatalf("reason = %q, want %q", finish.Reason, tt.reason)
+			}
+			if got := errors.Is(finish.Err, ErrUpstreamAborted); got != tt.aborted {
+				t.Fatalf("aborted = %v (err %v), want %v", got, finish.Err, tt.aborted)
+			}
+		})
+	}
+}
+
+func TestUpstreamUnavailable_KeepsMessage(t *testing.T) {
+	cause := fmt.Errorf("HTTP错误: %d", 503)
+	err := upstreamUnavailable(cause)
+	if err.Error() != cause.Error() {
+		t.Fatalf("message = %q, want %q", err.Error(), cause.Error())
+	}
+	if !errors.Is(err, ErrUpstreamUnavailable) || !errors.Is(err, cause) {
+		t.Fatalf("errors.Is should match both the marker and the cause")
+	}
+}
+
+// usageStream is an upstream completion whose finish event reports the token usage
+const usageStream = `data: {"type":"text-delta","delta":"Hello"}
+
+data: {"type":"finish","finishReason":"stop","messageMetadata":{"usage":{"inputTokens":120,"outputTokens":4,"totalTokens":124,"cachedInputTokens":100}}}
+
+data: [DONE]
+
+`
+
+func TestChat_UpstreamUsage(t *testing.T) {
+	want := types.Usage{InputTokens: 120, OutputTokens: 4, TotalTokens: 124, CachedInputTokens: 100}
+
+	cs := newReplayService(t, usageStream)
+	result, err := cs.Chat(t.Context(), emptyMessages, "test-model", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, ok := result.(types.CursorTextResult); !ok || text.Usage == nil || *text.Usage != want {
+		t.Errorf("result = %#v, want usage %+v", result, want)
+	}
+
+	dataChan, errorChan := cs.StreamChat(t.Context(), emptyMessages, "test-model", "", nil)
+	var finish types.StreamFinish
+	for data := range dataChan {
+		if v, ok := data.(types.StreamFinish); ok {
+			finish = v
+		}
+	}
+	if err := <-errorChan; err != nil {
+		t.Fatal(err)
+	}
+	if finish.Usage == nil || *finish.Usage != want {
+		t.Errorf("finish = %+v, want usage %+v", finish, want)
+	}
+
+	// Without messageMetadata the usage is left to local estimation
+	cs = newReplayService(t, "data: {\"type\":\"text-delta\",\"delta\":\"Hello\"}\n\ndata: [DONE]\n\n")
+	result, err = cs.Chat(t.Context(), emptyMessages, "test-model", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := result.(types.CursorTextResult); text.Usage != nil {
+		t.Errorf("usage = %+v, want nil", text.Usage)
+	}
+}
+
+// toolCallUsageStream is an upstream turn whose tool call step reports the usage
+// before the turn ends
+const toolCallUsageStream = `data: {"type":"tool-input-error","toolCallId":"c1","toolName":"read","input":{"path":"a"}}
+
+data: {"type":"finish-step","messageMetadata":{"usage":{"inputTokens":80,"outputTokens":12,"totalTokens":92}}}
+
+data: {"type":"start-step"}
+
+`
+
+func TestChat_ToolCallUsage(t *testing.T) {
+	want := types.Usage{InputTokens: 80, OutputTokens: 12, TotalTokens: 92}
+
+	cs := newReplayService(t, toolCallUsageStream)
+	result, err := cs.Chat(t.Context(), emptyMessages, "test-model", "", readTool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls, ok := result.(types.CursorToolCallResult); !ok || len(calls.Calls) != 1 || calls.Usage == nil || *calls.Usage != want {
+		t.Errorf("result = %#v, want one tool call with usage %+v", result, want)
+	}
+
+	dataChan, errorChan := cs.StreamChat(t.Context(), emptyMessages, "test-model", "", readTool)
+	var finish types.StreamFinish
+	for data := range dataChan {
+		if v, ok := data.(types.StreamFinish); ok {
+			finish = v
+		}
+	}
+	if err := <-errorChan; err != nil {
+		t.Fatal(err)
+	}
+	if finish.Reason != types.FinishReasonToolCalls || finish.Usage == nil || *finish.Usage != want {
+		t.Errorf("finish = %+v, want tool_calls with usage %+v", finish, want)
+	}
+}
+
+func TestStreamTermination_KeepsUsage(t *testing.T) {
+	usage := types.Usage{InputTokens: 10, OutputTokens: 3, TotalTokens: 13}
+	for _, readErr := range []error{nil, io.ErrUnexpectedEOF} {
+		var term streamTermination
+		term.observe(types.SSEEventData{Type: "finish-step", MessageMetadata: &types.MessageMetadata{Usage: usage}})
+		if finish := term.result(readErr); finish.Usage == nil || *finish.Usage != usage {
+			t.Errorf("read error %v: finish = %+v, want usage %+v", readErr, finish, usage)
+		}
+	}
+}
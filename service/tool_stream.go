@@ -0,0 +1,36 @@
+package service
+
+import "context"
+
+// interleavedToolCallsContextKey is the private context key of requests that keep
+// streaming after a tool call
+type interleavedToolCallsContextKey struct{}
+
+// WithInterleavedToolCalls returns a context whose streamed responses stay open
+// after a tool call: text and tool calls are sent as they arrive until the upstream
+// finishes, instead of closing the stream at the first tool call
+func WithInterleavedToolCalls(ctx context.Context) context.Context {
+	return context.WithValue(ctx, interleavedToolCallsContextKey{}, true)
+}
+
+// interleavedToolCallsFromContext reports whether a stream continues after tool calls
+func interleavedToolCallsFromContext(ctx context.Context) bool {
+	interleaved, _ := ctx.Value(interleavedToolCallsContextKey{}).(bool)
+	return interleaved
+}
+
+// singleToolCallContextKey is the private context key of requests that accept at
+// most one tool call per response
+type singleToolCallContextKey struct{}
+
+// WithSingleToolCall returns a context whose responses end at the first tool call
+// (parallel_tool_calls=false); by default all tool calls of the turn are returned
+func WithSingleToolCall(ctx context.Context) context.Context {
+	return context.WithValue(ctx, singleToolCallContextKey{}, true)
+}
+
+// singleToolCallFromContext reports whether a response ends at its first tool call
+func singleToolCallFromContext(ctx context.Context) bool {
+	single, _ := ctx.Value(singleToolCallContextKey{}).(bool)
+	return single
+}
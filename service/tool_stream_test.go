@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cursor2api/config"
+	"cursor2api/types"
+)
+
+// parallelToolCallsStream is an upstream turn with two tool calls followed by a
+// second step whose text is not part of the response
+const parallelToolCallsStream = `data: {"type":"start-step"}
+
+data: {"type":"text-delta","delta":"Checking."}
+
+data: {"type":"tool-input-error","toolCallId":"c1","toolName":"read","input":{"path":"a"}}
+
+data: {"type":"tool-input-error","toolCallId":"c2","toolName":"read","input":{"path":"b"}}
+
+data: {"type":"finish-step"}
+
+data: {"type":"start-step"}
+
+data: {"type":"text-delta","delta":"ignored"}
+
+data: {"type":"finish"}
+
+data: [DONE]
+
+`
+
+var readTool = []types.Tool{{Type: "function", Function: types.FunctionDef{Name: "read"}}}
+
+// newReplayService returns a service whose mock upstream replays stream
+func newReplayService(t *testing.T, stream string) *CursorService {
+	t.Helper()
+	previous := config.GlobalConfig
+	t.Cleanup(func() { config.GlobalConfig = previous })
+
+	path := filepath.Join(t.TempDir(), "stream.sse")
+	if err := os.WriteFile(path, []byte(stream), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{}
+	cfg.Mock = config.MockConfig{Enabled: true, ReplayFile: path}
+	cfg.Stream = config.StreamConfig{
+		ChannelBuffer:      8,
+		ScannerBuffer:      64 * 1024,
+		SlowConsumerPolicy: config.SlowConsumerBlock,
+	}
+	config.GlobalConfig = cfg
+	return NewCursorService(nil, cfg)
+}
+
+func TestChat_ParallelToolCalls(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want []string
+	}{
+		{"parallel", context.Background(), []string{`{"path":"a"}`, `{"path":"b"}`}},
+		{"single", WithSingleToolCall(context.Background()), []string{`{"path":"a"}`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := newReplayService(t, parallelToolCallsStream)
+			result, err := cs.Chat(tt.ctx, emptyMessages, "test-model", "", readTool)
+			if err != nil {
+				t.Fatal(err)
+			}
+			toolResult, ok := result.(types.CursorToolCallResult)
+			calls := toolResult.Calls
+			if !ok || len(calls) != len(tt.want) {
+				t.Fatalf("result = %#v, want %d tool calls", result, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if calls[i].ToolName != "read" || calls[i].ToolInput != want {
+					t.Errorf("call %d = %+v, want input %s", i, calls[i], want)
+				}
+			}
+			if len(calls) == 2 && calls[0].ToolID == calls[1].ToolID {
+				t.Errorf("tool calls share the ID %q", calls[0].ToolID)
+			}
+		})
+	}
+}
+
+func TestStreamChat_ParallelToolCalls(t *testing.T) {
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		calls int
+	}{
+		{"parallel", context.Background(), 2},
+		{"single", WithSingleToolCall(context.Background()), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := newReplayService(t, parallelToolCallsStream)
+			dataChan, errorChan := cs.StreamChat(tt.ctx, emptyMessages, "test-model", "", readTool)
+
+			var (
+				text   string
+				calls  int
+				finish types.StreamFinish
+			)
+			for data := range dataChan {
+				switch v := data.(type) {
+				case string:
+					text += v
+				case types.CursorToolCall:
+					calls++
+				case types.StreamFinish:
+					finish = v
+				}
+			}
+			if err := <-errorChan; err != nil {
+				t.Fatal(err)
+			}
+			if calls != tt.calls || text != "Checking." {
+				t.Errorf("calls = %d, text = %q, want %d calls and the text before them", calls, text, tt.calls)
+			}
+			if finish.Reason != types.FinishReasonToolCalls || finish.Err != nil {
+				t.Errorf("finish = %+v, want tool_calls", finish)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"crypto/rand"
+	"log"
+
+	"cursor2api/metrics"
+	"cursor2api/types"
+)
+
+var toolCallIDsRewritten = metrics.NewCounter(
+	"cursor2api_tool_call_ids_rewritten_total",
+	"Upstream tool call IDs replaced with generated ones, by reason (missing, duplicate).",
+	"reason")
+
+// toolCallIDs 为一次响应分配工具调用 ID:上游缺失或与会话中已有 ID 重复时生成
+// 稳定唯一的 call_<random>,并记录生成 ID 与上游 ID 的对应关系
+type toolCallIDs struct {
+	used     map[string]bool   // 会话历史与本次响应中已使用的 ID
+	upstream map[string]string // 分配的 ID -> 上游原始 ID (仅记录被替换的)
+}
+
+// newToolCallIDs 收集请求历史中 assistant tool_calls 与 tool 结果已使用的 ID
+func newToolCallIDs(messages []types.ChatMessage) *toolCallIDs {
+	ids := &toolCallIDs{used: make(map[string]bool), upstream: make(map[string]string)}
+	for _, msg := range messages {
+		for _, call := range msg.ToolCalls {
+			ids.used[call.ID] = true
+		}
+		if msg.ToolCallID != "" {
+			ids.used[msg.ToolCallID] = true
+		}
+	}
+	return ids
+}
+
+// assign 返回工具调用在客户端可见的 ID
+func (ids *toolCallIDs) assign(upstreamID string) string {
+	reason := ""
+	switch {
+	case upstreamID == "":
+		reason = "missing"
+	case ids.used[upstreamID]:
+		reason = "duplicate"
+	default:
+		ids.used[upstreamID] = true
+		return upstreamID
+	}
+
+	id := newToolCallID()
+	for ids.used[id] {
+		id = newToolCallID()
+	}
+	ids.used[id] = true
+	ids.upstream[id] = upstreamID
+	toolCallIDsRewritten.Inc(reason)
+	log.Printf("  └─ 🔁 Tool call ID normalized (%s): '%s' → '%s'", reason, upstreamID, id)
+	return id
+}
+
+// newToolCallID 生成 OpenAI 风格的工具调用 ID
+func newToolCallID() string {
+	return "call_" + rand.Text()[:24]
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"cursor2api/types"
+)
+
+func TestToolCallIDs_Assign(t *testing.T) {
+	history := []types.ChatMessage{
+		{Role: "assistant", ToolCalls: []types.ToolCall{{ID: "toolu_1", Type: "function"}}},
+		{Role: "tool", ToolCallID: "toolu_1", Content: "sunny"},
+	}
+	ids := newToolCallIDs(history)
+
+	if got := ids.assign("toolu_2"); got != "toolu_2" {
+		t.Fatalf("fresh upstream ID must be kept, got %q", got)
+	}
+
+	reused := ids.assign("toolu_1")
+	if reused == "toolu_1" || !strings.HasPrefix(reused, "call_") {
+		t.Fatalf("ID already in the conversation must be replaced, got %q", reused)
+	}
+	if ids.upstream[reused] != "toolu_1" {
+		t.Fatalf("mapping to the upstream ID missing: %v", ids.upstream)
+	}
+
+	repeated := ids.assign("toolu_2")
+	missing := ids.assign("")
+	if repeated == "toolu_2" || missing == "" || repeated == missing {
+		t.Fatalf("repeated and missing IDs must get distinct generated IDs: %q, %q", repeated, missing)
+	}
+	if len(missing) != len("call_")+24 {
+		t.Fatalf("generated ID %q has unexpected length", missing)
+	}
+}
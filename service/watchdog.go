@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"cursor2api/metrics"
+	"cursor2api/types"
+)
+
+var (
+	upstreamActive = metrics.NewGauge(
+		"cursor2api_upstream_active_requests",
+		"Upstream response bodies currently being read.",
+		"mode")
+
+	upstreamStreams = metrics.NewCounter(
+		"cursor2api_upstream_requests_finished_total",
+		"Upstream requests by how they ended; outcome=cancelled means the client went away first.",
+		"mode", "outcome")
+
+	upstreamTeardown = metrics.NewHistogram(
+		"cursor2api_upstream_teardown_seconds",
+		"Time from client cancellation until the upstream read loop exited.",
+		[]float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}, "mode")
+
+	upstreamBytesAfterCancel = metrics.NewCounter(
+		"cursor2api_upstream_bytes_after_cancel_total",
+		"Upstream bytes read after the client had already cancelled.",
+		"mode")
+)
+
+// upstreamWatchdog 在客户端取消时立即关闭上游响应体
+// contextReader 只在每次 Read 前检查 ctx,阻塞中的 Read 要等上游下一次发送数据才会返回,
+// 这期间上游仍在生成(并计费);主动关闭响应体可以让 Read 立即返回并断开连接
+type upstreamWatchdog struct {
+	ctx         context.Context
+	body        io.ReadCloser
+	mode        string
+	done        chan struct{}
+	exited      chan struct{}
+	cancelledAt atomic.Int64 // UnixNano,0 表示未取消
+	bytesAfter  atomic.Int64
+}
+
+// watchUpstream 开始监视 ctx,调用方必须在读取结束后调用 stop
+func watchUpstream(ctx context.Context, body io.ReadCloser, mode string) *upstreamWatchdog {
+	w := &upstreamWatchdog{
+		ctx:    ctx,
+		body:   body,
+		mode:   mode,
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	upstreamActive.Inc(mode)
+
+	go func() {
+		defer close(w.exited)
+		select {
+		case <-ctx.Done():
+			w.cancelledAt.Store(time.Now().UnixNano())
+			_ = body.Close()
+		case <-w.done:
+		}
+	}()
+	return w
+}
+
+// Read 实现 io.Reader 接口,统计取消之后仍然读到的字节数
+func (w *upstreamWatchdog) Read(p []byte) (int, error) {
+	n, err := w.body.Read(p)
+	if n > 0 && w.cancelledAt.Load() != 0 {
+		w.bytesAfter.Add(int64(n))
+	}
+	return n, err
+}
+
+// stop 停止监视并记录结果,返回最终结果;客户端先取消时 outcome 记为 cancelled
+func (w *upstreamWatchdog) stop(outcome string) string {
+	close(w.done)
+	<-w.exited
+	upstreamActive.Dec(w.mode)
+
+	if cancelledAt := w.cancelledAt.Load(); cancelledAt != 0 {
+		outcome = types.FinishReasonCancelled
+		upstreamTeardown.Observe(time.Since(time.Unix(0, cancelledAt)).Seconds(), w.mode)
+		if n := w.bytesAfter.Load(); n > 0 {
+			upstreamBytesAfterCancel.Add(float64(n), w.mode)
+		}
+	} else if w.ctx.Err() != nil {
+		// 读取循环先于监视协程观察到取消
+		outcome = types.FinishReasonCancelled
+	}
+	upstreamStreams.Inc(w.mode, outcome)
+	return outcome
+}
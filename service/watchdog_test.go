@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestUpstreamWatchdog_ClosesBodyOnCancel(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watchdog := watchUpstream(ctx, pr, "test")
+
+	readErr := make(chan error, 1)
+	go func() {
+		// Blocks until the upstream sends data or the body is closed
+		_, err := watchdog.Read(make([]byte, 16))
+		readErr <- err
+	}()
+
+	cancel()
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Fatal("expected read to fail after cancellation")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked read was not interrupted by cancellation")
+	}
+	watchdog.stop("stop")
+
+	if watchdog.cancelledAt.Load() == 0 {
+		t.Fatal("cancellation was not recorded")
+	}
+}
+
+func TestUpstreamWatchdog_StopWithoutCancel(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	watchdog := watchUpstream(context.Background(), pr, "test")
+	watchdog.stop("stop")
+
+	// The body must stay open for the caller to close
+	go func() { _, _ = pw.Write([]byte("x")) }()
+	if _, err := watchdog.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("body closed without cancellation: %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+// Package shadow mirrors a sample of chat requests to a secondary backend for
+// comparison or migration testing. Shadow requests are fire-and-forget: they run
+// in the background, their responses are discarded, and failures never reach
+// the client.
+package shadow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"cursor2api/config"
+	"cursor2api/logger"
+	"cursor2api/metrics"
+	"cursor2api/types"
+)
+
+// redactedText replaces content matched by a redaction pattern
+const redactedText = "[REDACTED]"
+
+var (
+	shadowRequests = metrics.NewCounter(
+		"cursor2api_shadow_requests_total",
+		"Requests mirrored to the shadow backend, by result: ok, http_error, error or dropped (concurrency limit).",
+		"result")
+
+	shadowDuration = metrics.NewHistogram(
+		"cursor2api_shadow_duration_seconds",
+		"Latency of shadow backend requests.",
+		[]float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60})
+)
+
+// Mirror sends sampled requests to the shadow backend
+type Mirror struct {
+	cfg      config.ShadowConfig
+	client   *http.Client
+	patterns []*regexp.Regexp
+	slots    chan struct{}
+	wg       sync.WaitGroup
+}
+
+// New creates a mirror; returns nil when no URL or sample rate is configured
+func New(cfg config.ShadowConfig) *Mirror {
+	if cfg.URL == "" || cfg.SampleRate <= 0 {
+		return nil
+	}
+
+	m := &Mirror{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		slots:  make(chan struct{}, max(cfg.MaxConcurrency, 1)),
+	}
+	for _, pattern := range cfg.RedactPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			logger.Warn("Ignoring invalid shadow redaction pattern | pattern=%s error=%v", pattern, err)
+			continue
+		}
+		m.patterns = append(m.patterns, re)
+	}
+
+	logger.Info("Shadow traffic enabled | url=%s sample_rate=%.2f max_concurrency=%d redact_fields=%v patterns=%d",
+		cfg.URL, cfg.SampleRate, cap(m.slots), cfg.RedactFields, len(m.patterns))
+	return m
+}
+
+// Mirror samples the request and, if selected, sends a redacted copy in the background.
+// traceID is forwarded so shadow results can be joined with the primary response.
+func (m *Mirror) Mirror(req types.ChatCompletionRequest, traceHeader, traceID string) {
+	if m == nil || rand.Float64() >= m.cfg.SampleRate {
+		return
+	}
+
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		shadowRequests.Inc("dropped")
+		logger.Debug("Shadow request dropped, concurrency limit reached | trace_id=%s", traceID)
+		return
+	}
+
+	body, err := json.Marshal(m.redact(req))
+	if err != nil {
+		<-m.slots
+		shadowRequests.Inc("error")
+		logger.Warn("Failed to encode shadow request | trace_id=%s error=%v", traceID, err)
+		return
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer func() { <-m.slots }()
+		m.send(body, traceHeader, traceID)
+	}()
+}
+
+// Stop waits for in-flight shadow requests to finish
+func (m *Mirror) Stop() {
+	if m == nil {
+		return
+	}
+	m.wg.Wait()
+}
+
+// redact returns a copy of the request prepared for the shadow backend; the
+// caller's request and messages are never modified
+func (m *Mirror) redact(req types.ChatCompletionRequest) types.ChatCompletionRequest {
+	// The shadow response is read in one piece and discarded
+	req.Stream = false
+	if m.cfg.Model != "" {
+		req.Model = m.cfg.Model
+	}
+
+	for _, field := range m.cfg.RedactFields {
+		switch strings.ToLower(strings.TrimSpace(field)) {
+		case "user":
+			req.User = ""
+		case "metadata":
+			req.Metadata = nil
+		case "conversation_id":
+			req.ConversationID = ""
+		}
+	}
+
+	if len(m.patterns) > 0 {
+		messages := make([]types.ChatMessage, len(req.Messages))
+		for i, msg := range req.Messages {
+			for _, re := range m.patterns {
+				msg.Content = re.ReplaceAllString(msg.Content, redactedText)
+			}
+			messages[i] = msg
+		}
+		req.Messages = messages
+	}
+	return req
+}
+
+// send posts the request to the shadow backend and records the outcome
+func (m *Mirror) send(body []byte, traceHeader, traceID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.cfg.Timeout)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, m.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		shadowRequests.Inc("error")
+		logger.Warn("Failed to build shadow request | trace_id=%s error=%v", traceID, err)
+		return
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range m.cfg.Headers {
+		httpReq.Header.Set(k, v)
+	}
+	if traceHeader != "" && traceID != "" {
+		httpReq.Header.Set(traceHeader, traceID)
+	}
+
+	start := time.Now()
+	resp, err := m.client.Do(httpReq)
+	if err != nil {
+		shadowDuration.Observe(time.Since(start).Seconds())
+		shadowRequests.Inc("error")
+		logger.Warn("Shadow request failed | trace_id=%s error=%v", traceID, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var result types.ChatCompletionResponse
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&result)
+	elapsed := time.Since(start)
+	shadowDuration.Observe(elapsed.Seconds())
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		shadowRequests.Inc("http_error")
+		logger.Warn("Shadow backend returned an error | trace_id=%s status=%d latency=%v", traceID, resp.StatusCode, elapsed)
+		return
+	}
+	if decodeErr != nil {
+		shadowRequests.Inc("error")
+		logger.Warn("Failed to decode shadow response | trace_id=%s error=%v", traceID, decodeErr)
+		return
+	}
+
+	shadowRequests.Inc("ok")
+	finishReason, contentLength := "", 0
+	if len(result.Choices) > 0 && result.Choices[0].Message != nil {
+		finishReason = result.Choices[0].FinishReason
+		contentLength = len(result.Choices[0].Message.Content)
+	}
+	logger.Info("Shadow request completed | trace_id=%s status=%d latency=%v finish_reason=%s content_length=%d completion_tokens=%d",
+		traceID, resp.StatusCode, elapsed, finishReason, contentLength, result.Usage.CompletionTokens)
+}
@@ -0,0 +1,61 @@
+package shadow
+
+import (
+	"testing"
+
+	"cursor2api/config"
+	"cursor2api/types"
+)
+
+func TestMirror_RedactDoesNotModifyRequest(t *testing.T) {
+	m := New(config.ShadowConfig{
+		URL:            "http://shadow.invalid/v1/chat/completions",
+		SampleRate:     1,
+		Model:          "gpt-4o-mini",
+		RedactFields:   []string{"user", "metadata"},
+		RedactPatterns: []string{`[\w.]+@[\w.]+`, `(`},
+	})
+
+	req := types.ChatCompletionRequest{
+		Model:          "claude-sonnet-4.5",
+		Stream:         true,
+		User:           "user-42",
+		ConversationID: "conv-1",
+		Metadata:       map[string]string{"team": "a"},
+		Messages:       []types.ChatMessage{{Role: "user", Content: "mail alice@example.com please"}},
+	}
+
+	got := m.redact(req)
+
+	if got.Stream || got.Model != "gpt-4o-mini" {
+		t.Fatalf("stream=%v model=%s, want non-stream request for the shadow model", got.Stream, got.Model)
+	}
+	if got.User != "" || got.Metadata != nil {
+		t.Fatalf("user=%q metadata=%v, want both removed", got.User, got.Metadata)
+	}
+	if got.ConversationID != "conv-1" {
+		t.Fatalf("conversation_id = %q, should be kept when not listed", got.ConversationID)
+	}
+	if want := "mail [REDACTED] please"; got.Messages[0].Content != want {
+		t.Fatalf("content = %q, want %q", got.Messages[0].Content, want)
+	}
+
+	// The primary request must be untouched
+	if req.Messages[0].Content != "mail alice@example.com please" || req.User != "user-42" || !req.Stream {
+		t.Fatalf("primary request was modified: %+v", req)
+	}
+}
+
+func TestNew_Disabled(t *testing.T) {
+	if m := New(config.ShadowConfig{SampleRate: 1}); m != nil {
+		t.Fatal("mirror without URL should be nil")
+	}
+	if m := New(config.ShadowConfig{URL: "http://shadow.invalid"}); m != nil {
+		t.Fatal("mirror with zero sample rate should be nil")
+	}
+
+	// A nil mirror is a no-op
+	var m *Mirror
+	m.Mirror(types.ChatCompletionRequest{}, "X-Trace-Id", "t")
+	m.Stop()
+}
@@ -0,0 +1,80 @@
+package tee
+
+import (
+	"context"
+	"unicode/utf8"
+
+	"golang.org/x/time/rate"
+)
+
+// pacedBytesPerToken matches the proxy's token estimate of about 3 bytes per token
+const pacedBytesPerToken = 3
+
+// maxPacedWritesPerSecond bounds the frames a paced sink writes per second; at higher
+// rates each write carries several tokens
+const maxPacedWritesPerSecond = 20
+
+// Paced is a sink that forwards text to another sink at no more than a given number
+// of tokens per second. Bursts from the upstream are cut into small pieces at rune
+// boundaries and spread out evenly, which gives UIs a steady typewriter effect and
+// caps the bandwidth of a single stream. WriteChunk blocks while pacing and fails
+// once ctx is done.
+type Paced struct {
+	ctx     context.Context
+	sink    Sink
+	limiter *rate.Limiter
+	piece   int // Bytes per forwarded write
+}
+
+// NewPaced wraps sink with output pacing at tokensPerSecond; it returns sink itself
+// when tokensPerSecond is not positive
+func NewPaced(ctx context.Context, sink Sink, tokensPerSecond int) Sink {
+	if tokensPerSecond <= 0 || sink == nil {
+		return sink
+	}
+	bytesPerSecond := tokensPerSecond * pacedBytesPerToken
+	piece := max(bytesPerSecond/maxPacedWritesPerSecond, pacedBytesPerToken)
+	// The burst must fit the largest write: a piece, or a single rune longer than it
+	return &Paced{
+		ctx:     ctx,
+		sink:    sink,
+		limiter: rate.NewLimiter(rate.Limit(bytesPerSecond), max(piece, utf8.UTFMax)),
+		piece:   piece,
+	}
+}
+
+// WriteChunk forwards chunk piece by piece, waiting for the rate limit before each
+func (p *Paced) WriteChunk(chunk string) error {
+	for chunk != "" {
+		n := runePrefix(chunk, p.piece)
+		if err := p.limiter.WaitN(p.ctx, n); err != nil {
+			return err
+		}
+		if err := p.sink.WriteChunk(chunk[:n]); err != nil {
+			return err
+		}
+		chunk = chunk[n:]
+	}
+	return nil
+}
+
+// Close closes the wrapped sink
+func (p *Paced) Close(outcome Outcome) error {
+	return p.sink.Close(outcome)
+}
+
+// runePrefix returns the length of the longest prefix of s of at most n bytes that
+// ends at a rune boundary, and at least the length of the first rune
+func runePrefix(s string, n int) int {
+	if len(s) <= n {
+		return len(s)
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	if n == 0 {
+		_, size := utf8.DecodeRuneInString(s)
+		return size
+	}
+	return n
+}
@@ -0,0 +1,69 @@
+package tee
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPaced_SplitsAndSpreadsChunks(t *testing.T) {
+	sink := &recordingSink{}
+	// 100 tokens/s = 300 bytes/s in pieces of 15 bytes
+	paced := NewPaced(context.Background(), sink, 100)
+
+	text := strings.Repeat("héllo wörld ", 6)
+	start := time.Now()
+	if err := paced.WriteChunk(text); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("wrote %d bytes in %v, want pacing at 300 bytes/s", len(text), elapsed)
+	}
+	if got := strings.Join(sink.chunks, ""); got != text {
+		t.Errorf("forwarded %q, want %q", got, text)
+	}
+	for _, chunk := range sink.chunks {
+		if len(chunk) > 15 {
+			t.Errorf("piece %q exceeds 15 bytes", chunk)
+		}
+	}
+	if len(sink.chunks) < len(text)/15 {
+		t.Errorf("got %d pieces, want at least %d", len(sink.chunks), len(text)/15)
+	}
+}
+
+func TestPaced_StopsWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sink := &recordingSink{}
+	if err := NewPaced(ctx, sink, 1).WriteChunk("some text that needs pacing"); err == nil {
+		t.Error("expected an error after the context is done")
+	}
+}
+
+func TestNewPaced_DisabledReturnsSink(t *testing.T) {
+	sink := &recordingSink{}
+	if NewPaced(context.Background(), sink, 0) != Sink(sink) {
+		t.Error("pacing at 0 tokens/s must return the sink unchanged")
+	}
+}
+
+func TestRunePrefix(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want int
+	}{
+		{"abc", 5, 3},
+		{"abcdef", 4, 4},
+		{"aé", 2, 1}, // é is two bytes and does not fit
+		{"世界", 2, 3}, // a rune longer than n is kept whole
+		{"ab世界", 4, 2},
+	}
+	for _, tt := range tests {
+		if got := runePrefix(tt.s, tt.n); got != tt.want {
+			t.Errorf("runePrefix(%q, %d) = %d, want %d", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
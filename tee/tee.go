@@ -0,0 +1,109 @@
+// Package tee fans a streamed completion out to several consumers (client, usage counter,
+// transcript store) chunk by chunk, so no consumer needs the full content buffered.
+package tee
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"cursor2api/logger"
+)
+
+// Outcome describes how a stream ended
+type Outcome struct {
+	FinishReason string // stop, tool_calls, error, cancelled
+	Err          error
+}
+
+// Sink consumes stream chunks in order
+// Sinks must not retain chunk data beyond what they need.
+type Sink interface {
+	WriteChunk(chunk string) error
+	Close(outcome Outcome) error
+}
+
+// Tee forwards each chunk to all sinks; a sink that fails is dropped without affecting the others
+type Tee struct {
+	sinks  []Sink
+	closed bool
+}
+
+// New creates a tee over the given sinks, skipping nil entries
+func New(sinks ...Sink) *Tee {
+	t := &Tee{sinks: make([]Sink, 0, len(sinks))}
+	for _, sink := range sinks {
+		if sink != nil {
+			t.sinks = append(t.sinks, sink)
+		}
+	}
+	return t
+}
+
+// WriteChunk forwards chunk to every active sink
+func (t *Tee) WriteChunk(chunk string) {
+	for i, sink := range t.sinks {
+		if sink == nil {
+			continue
+		}
+		if err := sink.WriteChunk(chunk); err != nil {
+			logger.Warn("Stream sink failed, detaching it | sink=%T error=%v", sink, err)
+			_ = sink.Close(Outcome{FinishReason: "error", Err: err})
+			t.sinks[i] = nil
+		}
+	}
+}
+
+// Close closes every active sink once with the stream outcome
+func (t *Tee) Close(outcome Outcome) {
+	if t.closed {
+		return
+	}
+	t.closed = true
+	for _, sink := range t.sinks {
+		if sink == nil {
+			continue
+		}
+		if err := sink.Close(outcome); err != nil {
+			logger.Warn("Failed to close stream sink | sink=%T error=%v", sink, err)
+		}
+	}
+}
+
+// Capture is a sink that keeps the streamed content, for consumers that need the text itself
+// Content beyond MaxBytes is dropped (at a UTF-8 boundary) and reported via Truncated.
+type Capture struct {
+	MaxBytes  int // 0 = unlimited
+	b         strings.Builder
+	truncated bool
+}
+
+// WriteChunk appends chunk to the captured content up to MaxBytes
+func (c *Capture) WriteChunk(chunk string) error {
+	if c.truncated {
+		return nil
+	}
+	if c.MaxBytes > 0 && c.b.Len()+len(chunk) > c.MaxBytes {
+		room := c.MaxBytes - c.b.Len()
+		for room > 0 && !utf8.RuneStart(chunk[room]) {
+			room--
+		}
+		c.b.WriteString(chunk[:room])
+		c.truncated = true
+		return nil
+	}
+	c.b.WriteString(chunk)
+	return nil
+}
+
+// Close implements Sink
+func (c *Capture) Close(Outcome) error { return nil }
+
+// String returns the captured content
+func (c *Capture) String() string {
+	return c.b.String()
+}
+
+// Truncated reports whether content was dropped because of MaxBytes
+func (c *Capture) Truncated() bool {
+	return c.truncated
+}
@@ -0,0 +1,62 @@
+package tee
+
+import (
+	"errors"
+	"testing"
+)
+
+type recordingSink struct {
+	chunks  []string
+	outcome *Outcome
+	failOn  int // Fail on the n-th chunk (1-based), 0 = never
+}
+
+func (s *recordingSink) WriteChunk(chunk string) error {
+	if s.failOn > 0 && len(s.chunks)+1 == s.failOn {
+		return errors.New("sink failed")
+	}
+	s.chunks = append(s.chunks, chunk)
+	return nil
+}
+
+func (s *recordingSink) Close(outcome Outcome) error {
+	s.outcome = &outcome
+	return nil
+}
+
+func TestTee_FansOutAndDetachesFailingSink(t *testing.T) {
+	healthy := &recordingSink{}
+	failing := &recordingSink{failOn: 2}
+	capture := &Capture{}
+
+	stream := New(healthy, nil, failing, capture)
+	for _, chunk := range []string{"a", "b", "c"} {
+		stream.WriteChunk(chunk)
+	}
+	stream.Close(Outcome{FinishReason: "stop"})
+	stream.Close(Outcome{FinishReason: "error"}) // Second close is ignored
+
+	if len(healthy.chunks) != 3 || healthy.outcome == nil || healthy.outcome.FinishReason != "stop" {
+		t.Errorf("healthy sink got chunks=%v outcome=%+v", healthy.chunks, healthy.outcome)
+	}
+	if len(failing.chunks) != 1 || failing.outcome == nil || failing.outcome.FinishReason != "error" {
+		t.Errorf("failing sink should be closed with error after its failure, got chunks=%v outcome=%+v", failing.chunks, failing.outcome)
+	}
+	if capture.String() != "abc" {
+		t.Errorf("capture = %q, want %q", capture.String(), "abc")
+	}
+}
+
+func TestCapture_MaxBytesKeepsRuneBoundary(t *testing.T) {
+	capture := &Capture{MaxBytes: 5}
+	_ = capture.WriteChunk("ab")
+	_ = capture.WriteChunk("中文") // 6 bytes; only one 3-byte rune fits
+	_ = capture.WriteChunk("c")
+
+	if got := capture.String(); got != "ab中" {
+		t.Errorf("capture = %q, want %q", got, "ab中")
+	}
+	if !capture.Truncated() {
+		t.Error("capture should report truncation")
+	}
+}
@@ -0,0 +1,261 @@
+// Package tenant implements multi-tenant namespaces. Each tenant has its own API
+// keys, rate limit, model allowlist, system prompt and admin tokens; requests are
+// assigned to a tenant by their API key (exact key or key prefix) or, for keys not
+// bound to a tenant, by a request header.
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"cursor2api/config"
+	"cursor2api/logger"
+	"cursor2api/types"
+	"cursor2api/upstream"
+	"golang.org/x/time/rate"
+)
+
+var (
+	// ErrUnknownTenant is returned when the tenant header names no configured tenant
+	ErrUnknownTenant = errors.New("unknown tenant")
+	// ErrTenantMismatch is returned when a tenant key asks for another tenant in the header
+	ErrTenantMismatch = errors.New("API key does not belong to the requested tenant")
+)
+
+// Tenant is one namespace and its settings
+type Tenant struct {
+	Name            string            `json:"name"`
+	APIKeys         []string          `json:"api_keys,omitempty"`         // Keys accepted in addition to API_KEYS and bound to this tenant
+	KeyPrefix       string            `json:"key_prefix,omitempty"`       // Any valid key with this prefix belongs to this tenant
+	RequestsPerSec  float64           `json:"requests_per_sec,omitempty"` // Tenant-wide rate limit (0 = unlimited)
+	Burst           int               `json:"burst,omitempty"`
+	Models          []string          `json:"models,omitempty"` // Model allowlist; the first entry is the default model (empty = all models)
+	SystemPrompt    string            `json:"system_prompt,omitempty"`
+	AdminTokens     map[string]string `json:"admin_tokens,omitempty"`     // token=role pairs scoped to this tenant
+	UpstreamHeaders map[string]string `json:"upstream_headers,omitempty"` // Tagging headers added to the tenant's upstream requests
+
+	limiter *rate.Limiter
+}
+
+// Allow consumes one request from the tenant's rate limit
+func (t *Tenant) Allow() bool {
+	return t == nil || t.limiter == nil || t.limiter.Allow()
+}
+
+// AllowsModel reports whether the tenant may use a model
+func (t *Tenant) AllowsModel(model string) bool {
+	return t == nil || len(t.Models) == 0 || slices.Contains(t.Models, model)
+}
+
+// DefaultModel returns the model used when a request names none, or "" for the global default
+func (t *Tenant) DefaultModel() string {
+	if t == nil || len(t.Models) == 0 {
+		return ""
+	}
+	return t.Models[0]
+}
+
+// WithSystemPrompt returns the messages with the tenant system prompt as a leading system message
+func (t *Tenant) WithSystemPrompt(messages []types.ChatMessage) []types.ChatMessage {
+	if t == nil || t.SystemPrompt == "" {
+		return messages
+	}
+	out := make([]types.ChatMessage, 0, len(messages)+1)
+	out = append(out, types.ChatMessage{Role: "system", Content: t.SystemPrompt})
+	return append(out, messages...)
+}
+
+// Info is the admin view of a tenant; keys and tokens are never returned
+type Info struct {
+	Name            string   `json:"name"`
+	APIKeys         int      `json:"api_keys"`
+	KeyPrefix       string   `json:"key_prefix,omitempty"`
+	RequestsPerSec  float64  `json:"requests_per_sec,omitempty"`
+	Burst           int      `json:"burst,omitempty"`
+	Models          []string `json:"models,omitempty"`
+	SystemPrompt    bool     `json:"system_prompt"`
+	AdminTokens     int      `json:"admin_tokens"`
+	UpstreamHeaders []string `json:"upstream_headers,omitempty"` // Header names only
+}
+
+// Info describes the tenant for admin endpoints
+func (t *Tenant) Info() Info {
+	return Info{
+		Name:            t.Name,
+		APIKeys:         len(t.APIKeys),
+		KeyPrefix:       t.KeyPrefix,
+		RequestsPerSec:  t.RequestsPerSec,
+		Burst:           t.Burst,
+		Models:          t.Models,
+		SystemPrompt:    t.SystemPrompt != "",
+		AdminTokens:     len(t.AdminTokens),
+		UpstreamHeaders: slices.Sorted(maps.Keys(t.UpstreamHeaders)),
+	}
+}
+
+// Registry holds the configured tenants
+type Registry struct {
+	header   string
+	tenants  map[string]*Tenant
+	byKey    map[string]*Tenant
+	prefixes []*Tenant // longest prefix first
+}
+
+// New loads the tenants file; returns nil when multi-tenancy is not configured
+func New(cfg config.TenantConfig) (*Registry, error) {
+	if cfg.File == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(cfg.File)
+	if err != nil {
+		return nil, err
+	}
+	var tenants map[string]*Tenant
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("failed to parse tenants file: %w", err)
+	}
+
+	r := &Registry{
+		header:  cfg.Header,
+		tenants: make(map[string]*Tenant, len(tenants)),
+		byKey:   make(map[string]*Tenant),
+	}
+	for name, t := range tenants {
+		if name == "" || t == nil {
+			return nil, fmt.Errorf("tenant entries need a name and settings")
+		}
+		t.Name = name
+		if err := upstream.ValidateTagHeaders(t.UpstreamHeaders); err != nil {
+			return nil, fmt.Errorf("tenant %q upstream_headers: %w", name, err)
+		}
+		if t.RequestsPerSec > 0 {
+			t.limiter = rate.NewLimiter(rate.Limit(t.RequestsPerSec), max(t.Burst, 1))
+		}
+		for _, key := range t.APIKeys {
+			if other, ok := r.byKey[key]; ok {
+				return nil, fmt.Errorf("API key is listed in both tenant %q and %q", other.Name, name)
+			}
+			r.byKey[key] = t
+		}
+		if t.KeyPrefix != "" {
+			r.prefixes = append(r.prefixes, t)
+		}
+		r.tenants[name] = t
+	}
+	sort.Slice(r.prefixes, func(i, j int) bool { return len(r.prefixes[i].KeyPrefix) > len(r.prefixes[j].KeyPrefix) })
+
+	logger.Info("Tenants loaded | file=%s tenants=%d header=%s", cfg.File, len(r.tenants), r.header)
+	return r, nil
+}
+
+// Header returns the name of the header selecting a tenant
+func (r *Registry) Header() string {
+	if r == nil {
+		return ""
+	}
+	return r.header
+}
+
+// APIKeys returns the keys of all tenants, which are valid API keys
+func (r *Registry) APIKeys() []string {
+	if r == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(r.byKey))
+	for key := range r.byKey {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Get returns a tenant by name
+func (r *Registry) Get(name string) (*Tenant, bool) {
+	if r == nil {
+		return nil, false
+	}
+	t, ok := r.tenants[name]
+	return t, ok
+}
+
+// List returns all tenants sorted by name
+func (r *Registry) List() []*Tenant {
+	if r == nil {
+		return nil
+	}
+	list := make([]*Tenant, 0, len(r.tenants))
+	for _, t := range r.tenants {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Resolve selects the tenant of a request. A key bound to a tenant (listed or by
+// prefix) always selects it; other keys, or no key when authentication is disabled,
+// select the tenant named in the header. Returns nil when the request has no tenant.
+func (r *Registry) Resolve(apiKey, header string) (*Tenant, error) {
+	if r == nil {
+		return nil, nil
+	}
+	header = strings.TrimSpace(header)
+
+	if bound := r.keyTenant(apiKey); bound != nil {
+		if header != "" && header != bound.Name {
+			return nil, ErrTenantMismatch
+		}
+		return bound, nil
+	}
+	if header == "" {
+		return nil, nil
+	}
+	t, ok := r.tenants[header]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTenant, header)
+	}
+	return t, nil
+}
+
+// keyTenant returns the tenant an API key is bound to
+func (r *Registry) keyTenant(apiKey string) *Tenant {
+	if apiKey == "" {
+		return nil
+	}
+	if t, ok := r.byKey[apiKey]; ok {
+		return t
+	}
+	for _, t := range r.prefixes {
+		if strings.HasPrefix(apiKey, t.KeyPrefix) {
+			return t
+		}
+	}
+	return nil
+}
+
+// tenantContextKey is the private context key for the request tenant
+type tenantContextKey struct{}
+
+// WithTenant returns a context carrying the request tenant
+func WithTenant(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, t)
+}
+
+// FromContext returns the request tenant, or nil outside a tenant
+func FromContext(ctx context.Context) *Tenant {
+	t, _ := ctx.Value(tenantContextKey{}).(*Tenant)
+	return t
+}
+
+// NameFromContext returns the name of the request tenant, or "" outside a tenant
+func NameFromContext(ctx context.Context) string {
+	if t := FromContext(ctx); t != nil {
+		return t.Name
+	}
+	return ""
+}
@@ -0,0 +1,98 @@
+package tenant
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cursor2api/config"
+)
+
+func newTestRegistry(t *testing.T) *Registry {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "tenants.json")
+	data := `{
+		"acme": {"api_keys": ["sk-acme-listed"], "models": ["openai/gpt-5"], "requests_per_sec": 1, "burst": 2},
+		"beta": {"key_prefix": "sk-beta-", "system_prompt": "Answer briefly."},
+		"beta-eu": {"key_prefix": "sk-beta-eu-"}
+	}`
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(config.TenantConfig{File: file, Header: "X-Tenant-Id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRegistry_Resolve(t *testing.T) {
+	r := newTestRegistry(t)
+
+	tests := []struct {
+		name   string
+		key    string
+		header string
+		want   string
+		err    error
+	}{
+		{name: "listed key", key: "sk-acme-listed", want: "acme"},
+		{name: "key prefix", key: "sk-beta-123", want: "beta"},
+		{name: "longest prefix wins", key: "sk-beta-eu-123", want: "beta-eu"},
+		{name: "bound key with matching header", key: "sk-beta-1", header: "beta", want: "beta"},
+		{name: "bound key with other header", key: "sk-beta-1", header: "acme", err: ErrTenantMismatch},
+		{name: "unbound key selects by header", key: "sk-global", header: "acme", want: "acme"},
+		{name: "unknown header", key: "sk-global", header: "nope", err: ErrUnknownTenant},
+		{name: "no tenant", key: "sk-global"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.Resolve(tt.key, tt.header)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if name := got.nameOrEmpty(); name != tt.want {
+				t.Fatalf("tenant = %q, want %q", name, tt.want)
+			}
+		})
+	}
+}
+
+func TestTenant_Settings(t *testing.T) {
+	r := newTestRegistry(t)
+	acme, _ := r.Get("acme")
+	beta, _ := r.Get("beta")
+
+	if !acme.AllowsModel("openai/gpt-5") || acme.AllowsModel("xai/grok-4") || acme.DefaultModel() != "openai/gpt-5" {
+		t.Fatal("acme model allowlist not applied")
+	}
+	if !beta.AllowsModel("xai/grok-4") || beta.DefaultModel() != "" {
+		t.Fatal("tenant without allowlist must allow every model")
+	}
+	if !acme.Allow() || !acme.Allow() || acme.Allow() {
+		t.Fatal("acme burst of 2 not enforced")
+	}
+	if msgs := beta.WithSystemPrompt(nil); len(msgs) != 1 || msgs[0].Role != "system" {
+		t.Fatalf("system prompt not prepended: %+v", msgs)
+	}
+}
+
+func TestNew_DuplicateKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tenants.json")
+	data := `{"a": {"api_keys": ["sk-1"]}, "b": {"api_keys": ["sk-1"]}}`
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(config.TenantConfig{File: file}); err == nil {
+		t.Fatal("a key shared by two tenants must be rejected")
+	}
+}
+
+// nameOrEmpty returns the tenant name, or "" for nil
+func (t *Tenant) nameOrEmpty() string {
+	if t == nil {
+		return ""
+	}
+	return t.Name
+}
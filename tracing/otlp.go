@@ -0,0 +1,203 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cursor2api/logger"
+	"cursor2api/metrics"
+)
+
+// OTLP status codes
+const (
+	statusUnset = 0
+	statusError = 2
+)
+
+var tracingSpans = metrics.NewCounter(
+	"cursor2api_trace_spans_total",
+	"Spans handed to the OTLP exporter, by result (exported, failed, dropped when the export queue is full).",
+	"result")
+
+// loop batches ended spans and exports them periodically
+func (t *Tracer) loop() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.send(batch); err != nil {
+			tracingSpans.Add(float64(len(batch)), "failed")
+			logger.Warn("Failed to export spans | count=%d error=%v", len(batch), err)
+		} else {
+			tracingSpans.Add(float64(len(batch)), "exported")
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.done:
+			for {
+				select {
+				case span := <-t.queue:
+					batch = append(batch, span)
+					if len(batch) >= batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// send posts one batch as an OTLP/JSON ExportTraceServiceRequest
+func (t *Tracer) send(batch []*Span) error {
+	spans := make([]otlpSpan, len(batch))
+	for i, span := range batch {
+		spans[i] = span.otlp()
+	}
+	payload, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttribute{otlpAttr("service.name", t.service)}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "cursor2api"}, Spans: spans}},
+	}}})
+	if err != nil {
+		return fmt.Errorf("failed to marshal spans: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned HTTP %d", resp.StatusCode)
+	}
+	logger.Debug("Exported spans | count=%d", len(batch))
+	return nil
+}
+
+// otlp converts an ended span to its OTLP/JSON form
+func (s *Span) otlp() otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := otlpSpan{
+		TraceID:           hex.EncodeToString(s.traceID[:]),
+		SpanID:            hex.EncodeToString(s.spanID[:]),
+		Name:              s.name,
+		Kind:              int(s.kind),
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Attributes:        make([]otlpAttribute, len(s.attrs)),
+		Status:            otlpStatus{Code: statusUnset},
+	}
+	if s.parentID != [8]byte{} {
+		out.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	for i, attr := range s.attrs {
+		out.Attributes[i] = otlpAttr(attr.key, attr.value)
+	}
+	if s.failed {
+		out.Status = otlpStatus{Code: statusError, Message: s.status}
+	}
+	return out
+}
+
+// otlpAttr encodes an attribute value as an OTLP AnyValue
+func otlpAttr(key string, value interface{}) otlpAttribute {
+	var v otlpValue
+	switch value := value.(type) {
+	case string:
+		v.StringValue = &value
+	case bool:
+		v.BoolValue = &value
+	case int:
+		s := strconv.Itoa(value)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(value, 10)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &value
+	default:
+		s := fmt.Sprint(value)
+		v.StringValue = &s
+	}
+	return otlpAttribute{Key: key, Value: v}
+}
+
+// OTLP/JSON messages (opentelemetry/proto/collector/trace/v1); 64-bit integers
+// are strings and IDs are hex as the JSON encoding requires
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
@@ -0,0 +1,232 @@
+// Package tracing records OpenTelemetry spans: a server span per API request
+// (started by middleware.Tracing) and child spans for AntiBot token acquisition,
+// the upstream Cursor call and reading its SSE stream. Spans are exported in
+// batches to an OTLP/HTTP collector (OTEL_EXPORTER_OTLP_ENDPOINT) using the JSON
+// encoding; W3C traceparent headers of incoming requests are continued.
+//
+// A nil *Tracer and a nil *Span are valid and record nothing, so callers never
+// check whether tracing is enabled.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	mathrand "math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"cursor2api/config"
+	"cursor2api/logger"
+)
+
+// SpanKind is the OTLP span kind
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1 // Work inside the service, e.g. reading the upstream stream
+	KindServer   SpanKind = 2 // An incoming request
+	KindClient   SpanKind = 3 // A call to another service
+)
+
+// Exporter batching
+const (
+	queueSize     = 4096
+	batchSize     = 512
+	flushInterval = 5 * time.Second
+)
+
+// Tracer samples and exports spans
+type Tracer struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	ratio    float64
+	client   *http.Client
+
+	queue chan *Span
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// Span is one timed operation of a trace
+type Span struct {
+	tracer   *Tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte // Zero for the root span
+	kind     SpanKind
+	start    time.Time
+
+	mu     sync.Mutex
+	name   string
+	end    time.Time
+	attrs  []attribute
+	failed bool
+	status string // Error message of a failed span
+	ended  bool
+}
+
+// attribute is one key/value of a span
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+// spanContextKey is the private context key of the current span
+type spanContextKey struct{}
+
+// New creates the tracer and starts its exporter; returns nil when no OTLP
+// endpoint is configured
+func New(cfg config.ObservabilityConfig) *Tracer {
+	if cfg.OTLPEndpoint == "" {
+		return nil
+	}
+	t := &Tracer{
+		endpoint: strings.TrimRight(cfg.OTLPEndpoint, "/") + "/v1/traces",
+		headers:  cfg.OTLPHeaders,
+		service:  cfg.ServiceName,
+		ratio:    cfg.TraceSampleRatio,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan *Span, queueSize),
+		done:     make(chan struct{}),
+	}
+	t.wg.Add(1)
+	go t.loop()
+
+	logger.Info("Tracing enabled | endpoint=%s service=%s sample_ratio=%g", t.endpoint, t.service, t.ratio)
+	return t
+}
+
+// Stop exports the pending spans and stops the exporter; a nil tracer is a no-op
+func (t *Tracer) Stop() {
+	if t == nil {
+		return
+	}
+	close(t.done)
+	t.wg.Wait()
+}
+
+// StartRequest starts the server span of an incoming request. A valid traceparent
+// continues the caller's trace and sampling decision; otherwise a new trace is
+// sampled at the configured ratio. Returns a nil span when the request is not
+// sampled.
+func (t *Tracer) StartRequest(ctx context.Context, name, traceparent string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{tracer: t, kind: KindServer, name: name, start: time.Now()}
+	if traceID, parentID, sampled, ok := parseTraceParent(traceparent); ok {
+		if !sampled {
+			return ctx, nil
+		}
+		span.traceID, span.parentID = traceID, parentID
+	} else {
+		if t.ratio < 1 && mathrand.Float64() >= t.ratio {
+			return ctx, nil
+		}
+		_, _ = rand.Read(span.traceID[:])
+	}
+	_, _ = rand.Read(span.spanID[:])
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// Start starts a child of the span in ctx; without one (tracing disabled or the
+// request not sampled) nothing is recorded and the returned span is nil
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	span := &Span{tracer: parent.tracer, traceID: parent.traceID, parentID: parent.spanID, kind: kind, name: name, start: time.Now()}
+	_, _ = rand.Read(span.spanID[:])
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// SpanFromContext returns the current span, nil when there is none
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// SetName renames the span, e.g. once the route of a request is known
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+}
+
+// SetAttribute sets a string, bool, integer or float attribute, replacing an
+// earlier value of key
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.attrs {
+		if s.attrs[i].key == key {
+			s.attrs[i].value = value
+			return
+		}
+	}
+	s.attrs = append(s.attrs, attribute{key: key, value: value})
+}
+
+// Fail marks the span as failed with err as its status message; cancellations
+// by the client are not failures of the service and are ignored
+func (s *Span) Fail(err error) {
+	if s == nil || err == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	s.mu.Lock()
+	s.failed = true
+	s.status = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export; later calls are no-ops
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	select {
+	case s.tracer.queue <- s:
+	default:
+		tracingSpans.Inc("dropped")
+	}
+}
+
+// parseTraceParent parses a W3C traceparent header (version 00)
+func parseTraceParent(header string) (traceID [16]byte, parentID [8]byte, sampled bool, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || parts[0] == "ff" || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return traceID, parentID, false, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil || traceID == [16]byte{} {
+		return traceID, parentID, false, false
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil || parentID == [8]byte{} {
+		return traceID, parentID, false, false
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return traceID, parentID, false, false
+	}
+	return traceID, parentID, flags[0]&1 == 1, true
+}
@@ -0,0 +1,106 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cursor2api/config"
+)
+
+func TestTracer_ExportsSpanTree(t *testing.T) {
+	requests := make(chan otlpRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Authorization") != "Bearer otlp-token" {
+			t.Errorf("export to %s with Authorization %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode export: %v", err)
+		}
+		requests <- req
+	}))
+	defer collector.Close()
+
+	tracer := New(config.ObservabilityConfig{
+		OTLPEndpoint:     collector.URL + "/",
+		OTLPHeaders:      map[string]string{"Authorization": "Bearer otlp-token"},
+		ServiceName:      "cursor2api-test",
+		TraceSampleRatio: 0, // The traceparent decides
+	})
+
+	ctx, root := tracer.StartRequest(context.Background(), "POST", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	root.SetName("POST /v1/chat/completions")
+	root.SetAttribute("http.response.status_code", 200)
+	_, child := Start(ctx, "cursor.chat", KindClient)
+	child.SetAttribute("server.address", "cursor.com")
+	child.Fail(errors.New("HTTP错误: 502"))
+	child.End()
+	root.End()
+	root.End() // Ending twice exports once
+	tracer.Stop()
+
+	req := <-requests
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("export = %+v", req)
+	}
+	if name := *req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue; name != "cursor2api-test" {
+		t.Errorf("service.name = %q", name)
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("spans = %+v", spans)
+	}
+	exportedChild, exportedRoot := spans[0], spans[1]
+	if exportedRoot.TraceID != "0af7651916cd43dd8448eb211c80319c" || exportedRoot.ParentSpanID != "b7ad6b7169203331" ||
+		exportedRoot.Name != "POST /v1/chat/completions" || exportedRoot.Kind != int(KindServer) {
+		t.Errorf("root = %+v", exportedRoot)
+	}
+	if v := exportedRoot.Attributes[0].Value.IntValue; v == nil || *v != "200" {
+		t.Errorf("root attributes = %+v", exportedRoot.Attributes)
+	}
+	if exportedChild.TraceID != exportedRoot.TraceID || exportedChild.ParentSpanID != exportedRoot.SpanID ||
+		exportedChild.Status.Code != statusError || exportedChild.Status.Message != "HTTP错误: 502" {
+		t.Errorf("child = %+v", exportedChild)
+	}
+}
+
+func TestTracer_Sampling(t *testing.T) {
+	tracer := &Tracer{ratio: 0, queue: make(chan *Span, 1)}
+
+	// An unsampled caller and the ratio both drop the trace, and children with it
+	for _, traceparent := range []string{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00", "", "garbage"} {
+		ctx, span := tracer.StartRequest(context.Background(), "GET", traceparent)
+		if span != nil {
+			t.Errorf("traceparent %q was sampled", traceparent)
+		}
+		if _, child := Start(ctx, "cursor.sse", KindInternal); child != nil {
+			t.Errorf("child of an unsampled request was recorded")
+		}
+	}
+
+	// A nil tracer records nothing
+	var disabled *Tracer
+	if _, span := disabled.StartRequest(context.Background(), "GET", ""); span != nil {
+		t.Error("disabled tracer started a span")
+	}
+	disabled.Stop()
+}
+
+func TestParseTraceParent(t *testing.T) {
+	for header, valid := range map[string]bool{
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01": true,
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01": false, // Zero trace ID
+		"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01": false, // Zero parent ID
+		"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01": false, // Invalid version
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b71692033-01":   false,
+		"00-zzf7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01": false,
+	} {
+		if _, _, _, ok := parseTraceParent(header); ok != valid {
+			t.Errorf("parseTraceParent(%s) ok = %v, want %v", header, ok, valid)
+		}
+	}
+}
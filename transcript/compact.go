@@ -0,0 +1,195 @@
+package transcript
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"cursor2api/codec"
+	"cursor2api/logger"
+)
+
+const (
+	// compactedMarker records in a day directory the codec its files were compacted with
+	compactedMarker = ".compacted"
+	// compactMinAge keeps the compactor away from files a long stream may still be writing
+	compactMinAge = time.Hour
+)
+
+// fileExts are the transcript file extensions of every codec
+var fileExts = []string{"", codec.Ext(codec.Zstd), codec.Ext(codec.Gzip)}
+
+// Start launches the background compactor; no-op when compaction is disabled
+func (s *Store) Start() {
+	if s == nil || s.compactInterval <= 0 {
+		return
+	}
+	s.wg.Add(1)
+	go s.compactLoop()
+	logger.Info("Transcript compaction started | interval=%s codec=%s", s.compactInterval, s.codec)
+}
+
+// Stop stops the compactor and waits for a running pass to finish
+func (s *Store) Stop() {
+	if s == nil {
+		return
+	}
+	s.stopOnce.Do(func() { close(s.stopChan) })
+	s.wg.Wait()
+}
+
+func (s *Store) compactLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.compactInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+			s.Compact(time.Now())
+		}
+	}
+}
+
+// Compact recompresses the transcripts of finished UTC days with the configured
+// codec at its best level. A day is compacted again only when the codec changes,
+// so switching STORAGE_COMPRESSION eventually converts every stored transcript.
+func (s *Store) Compact(now time.Time) {
+	if s == nil {
+		return
+	}
+	days, err := s.days()
+	if err != nil {
+		logger.Warn("Transcript compaction failed | error=%v", err)
+		return
+	}
+	today := now.UTC().Format("2006-01-02")
+	for _, day := range days {
+		if day >= today {
+			continue
+		}
+		select {
+		case <-s.stopChan:
+			return
+		default:
+		}
+		s.compactDay(filepath.Join(s.dir, day), now)
+	}
+}
+
+// compactDay recompresses one day directory and marks it done when no file was skipped
+func (s *Store) compactDay(dir string, now time.Time) {
+	if marker, err := os.ReadFile(filepath.Join(dir, compactedMarker)); err == nil && string(marker) == s.codec {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logger.Warn("Transcript compaction failed | dir=%s error=%v", dir, err)
+		return
+	}
+
+	var files, before, after int64
+	complete := true
+	for _, e := range entries {
+		id, ok := transcriptID(e.Name())
+		if !ok || e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			complete = false
+			continue
+		}
+		if now.Sub(info.ModTime()) < compactMinAge {
+			complete = false
+			continue
+		}
+		size, err := s.compactFile(dir, e.Name(), id)
+		if err != nil {
+			logger.Warn("Transcript compaction failed | file=%s error=%v", filepath.Join(dir, e.Name()), err)
+			complete = false
+			continue
+		}
+		files++
+		before += info.Size()
+		after += size
+	}
+
+	if complete {
+		if err := os.WriteFile(filepath.Join(dir, compactedMarker), []byte(s.codec), 0o640); err != nil {
+			logger.Warn("Transcript compaction marker failed | dir=%s error=%v", dir, err)
+		}
+	}
+	if files > 0 {
+		logger.Info("Transcripts compacted | dir=%s files=%d bytes_before=%d bytes_after=%d", dir, files, before, after)
+	}
+}
+
+// compactFile rewrites one transcript with the configured codec and returns its new size
+func (s *Store) compactFile(dir, name, id string) (int64, error) {
+	data, err := codec.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return 0, err
+	}
+	encoded, err := codec.Encode(data, s.codec, codec.Best)
+	if err != nil {
+		return 0, err
+	}
+
+	target := id + ".jsonl" + codec.Ext(s.codec)
+	tmp, err := os.CreateTemp(dir, target+".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tmp.Write(encoded); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, target)); err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	if name != target {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(encoded)), nil
+}
+
+// days lists the day directories in ascending order
+func (s *Store) days() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var days []string
+	for _, e := range entries {
+		if _, err := time.Parse("2006-01-02", e.Name()); err == nil && e.IsDir() {
+			days = append(days, e.Name())
+		}
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+// transcriptID returns the request ID of a transcript file name
+func transcriptID(name string) (string, bool) {
+	for _, ext := range fileExts {
+		if id, ok := strings.CutSuffix(name, ".jsonl"+ext); ok && id != "" {
+			return id, true
+		}
+	}
+	return "", false
+}
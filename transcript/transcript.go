@@ -0,0 +1,163 @@
+// Package transcript persists streamed completions as JSON Lines files, one per
+// request, optionally compressed (see STORAGE_COMPRESSION).
+package transcript
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"cursor2api/codec"
+	"cursor2api/config"
+	"cursor2api/logger"
+	"cursor2api/tee"
+)
+
+// Meta identifies the request a transcript belongs to
+type Meta struct {
+	ID        string            `json:"id"`
+	TraceID   string            `json:"trace_id,omitempty"`
+	Model     string            `json:"model"`
+	MaskedKey string            `json:"key,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// entry is one line of a transcript file
+type entry struct {
+	Type         string    `json:"type"` // request, delta, end
+	Time         time.Time `json:"time"`
+	Request      *Meta     `json:"request,omitempty"`
+	Content      string    `json:"content,omitempty"`
+	FinishReason string    `json:"finish_reason,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// ErrNotFound is returned by Read for an unknown transcript ID
+var ErrNotFound = errors.New("transcript not found")
+
+// Store writes transcripts below a directory, partitioned by UTC day
+type Store struct {
+	dir             string
+	codec           string
+	compactInterval time.Duration
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewStore creates a transcript store; returns nil when dir is empty
+func NewStore(dir string, cfg config.StorageConfig) *Store {
+	if dir == "" {
+		return nil
+	}
+	logger.Info("Transcript store enabled | dir=%s compression=%s", dir, cfg.Compression)
+	return &Store{
+		dir:             dir,
+		codec:           cfg.Compression,
+		compactInterval: cfg.CompactInterval,
+		stopChan:        make(chan struct{}),
+	}
+}
+
+// Open creates the transcript file for a request and writes its header line
+// A nil store returns a nil sink, which tee.New skips.
+func (s *Store) Open(meta Meta) (tee.Sink, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	now := time.Now().UTC()
+	dayDir := filepath.Join(s.dir, now.Format("2006-01-02"))
+	if err := os.MkdirAll(dayDir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create transcript dir: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dayDir, meta.ID+".jsonl"+codec.Ext(s.codec)), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transcript file: %w", err)
+	}
+	// Written at the fastest level while the request streams; the compactor
+	// recompresses finished days at a better ratio
+	comp, err := codec.NewWriter(file, s.codec, codec.Fast)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	w := &Writer{file: file, comp: comp, buf: bufio.NewWriter(comp)}
+	w.enc = json.NewEncoder(w.buf)
+	if err := w.enc.Encode(entry{Type: "request", Time: now, Request: &meta}); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Read returns the decompressed JSON Lines of a transcript, searching the most
+// recent days first
+func (s *Store) Read(id string) ([]byte, error) {
+	if s == nil {
+		return nil, ErrNotFound
+	}
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return nil, ErrNotFound
+	}
+	days, err := s.days()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(days) - 1; i >= 0; i-- {
+		for _, ext := range fileExts {
+			data, err := codec.ReadFile(filepath.Join(s.dir, days[i], id+".jsonl"+ext))
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return data, err
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Writer is a tee.Sink appending deltas to one transcript file
+type Writer struct {
+	file *os.File
+	comp io.WriteCloser
+	buf  *bufio.Writer
+	enc  *json.Encoder
+}
+
+// WriteChunk appends a delta line
+func (w *Writer) WriteChunk(chunk string) error {
+	return w.enc.Encode(entry{Type: "delta", Time: time.Now().UTC(), Content: chunk})
+}
+
+// Close appends the end line and closes the file
+func (w *Writer) Close(outcome tee.Outcome) error {
+	end := entry{Type: "end", Time: time.Now().UTC(), FinishReason: outcome.FinishReason}
+	if outcome.Err != nil {
+		end.Error = outcome.Err.Error()
+	}
+	encErr := w.enc.Encode(end)
+	flushErr := w.buf.Flush()
+	compErr := w.comp.Close()
+	closeErr := w.file.Close()
+
+	switch {
+	case encErr != nil:
+		return encErr
+	case flushErr != nil:
+		return flushErr
+	case compErr != nil:
+		return compErr
+	default:
+		return closeErr
+	}
+}
@@ -0,0 +1,103 @@
+package transcript
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cursor2api/config"
+	"cursor2api/tee"
+)
+
+func writeTranscript(t *testing.T, s *Store, id string) {
+	t.Helper()
+	sink, err := s.Open(Meta{ID: id, Model: "openai/gpt-5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		if err := sink.WriteChunk("hello "); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(tee.Outcome{FinishReason: "stop"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStore_CompressedRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(dir, config.StorageConfig{Compression: "zstd"})
+	writeTranscript(t, s, "chatcmpl-1")
+
+	day := time.Now().UTC().Format("2006-01-02")
+	if _, err := os.Stat(filepath.Join(dir, day, "chatcmpl-1.jsonl.zst")); err != nil {
+		t.Fatalf("compressed file missing: %v", err)
+	}
+	data, err := s.Read("chatcmpl-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 52 || !strings.Contains(lines[0], `"type":"request"`) || !strings.Contains(lines[51], `"finish_reason":"stop"`) {
+		t.Errorf("transcript has %d lines: %s ... %s", len(lines), lines[0], lines[len(lines)-1])
+	}
+
+	for _, id := range []string{"missing", "../" + day + "/chatcmpl-1", ""} {
+		if _, err := s.Read(id); err != ErrNotFound {
+			t.Errorf("Read(%q) err = %v", id, err)
+		}
+	}
+}
+
+func TestStore_Compact(t *testing.T) {
+	dir := t.TempDir()
+	writeTranscript(t, NewStore(dir, config.StorageConfig{Compression: "none"}), "chatcmpl-old")
+	writeTranscript(t, NewStore(dir, config.StorageConfig{Compression: "zstd"}), "chatcmpl-new")
+	want, _ := NewStore(dir, config.StorageConfig{}).Read("chatcmpl-old")
+
+	// Move today's files into a finished day
+	today := time.Now().UTC().Format("2006-01-02")
+	oldDay := "2020-01-01"
+	if err := os.Rename(filepath.Join(dir, today), filepath.Join(dir, oldDay)); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewStore(dir, config.StorageConfig{Compression: "gzip"})
+	s.Compact(time.Now())
+	if _, err := os.Stat(filepath.Join(dir, oldDay, compactedMarker)); err == nil {
+		t.Fatal("files younger than compactMinAge must not be compacted")
+	}
+
+	s.Compact(time.Now().Add(2 * compactMinAge))
+	entries, _ := os.ReadDir(filepath.Join(dir, oldDay))
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if strings.Join(names, ",") != ".compacted,chatcmpl-new.jsonl.gz,chatcmpl-old.jsonl.gz" {
+		t.Errorf("files after compaction = %v", names)
+	}
+	got, err := s.Read("chatcmpl-old")
+	if err != nil || string(got) != string(want) {
+		t.Errorf("compacted transcript changed: %v", err)
+	}
+}
+
+func TestStore_Nil(t *testing.T) {
+	var s *Store
+	if s = NewStore("", config.StorageConfig{}); s != nil {
+		t.Fatal("empty dir must disable the store")
+	}
+	if sink, err := s.Open(Meta{ID: "x"}); sink != nil || err != nil {
+		t.Errorf("Open = %v, %v", sink, err)
+	}
+	if _, err := s.Read("x"); err != ErrNotFound {
+		t.Errorf("Read err = %v", err)
+	}
+	s.Start()
+	s.Compact(time.Now())
+	s.Stop()
+}
@@ -0,0 +1,148 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// AnthropicRequest Anthropic Messages API 请求 (POST /v1/messages)
+type AnthropicRequest struct {
+	Model         string               `json:"model"`
+	MaxTokens     int                  `json:"max_tokens,omitempty"`
+	System        AnthropicContent     `json:"system,omitempty"` // 字符串或文本块数组
+	Messages      []AnthropicMessage   `json:"messages"`
+	Stream        bool                 `json:"stream,omitempty"`
+	Temperature   float64              `json:"temperature,omitempty"`
+	TopP          float64              `json:"top_p,omitempty"`
+	StopSequences []string             `json:"stop_sequences,omitempty"`
+	Tools         []AnthropicTool      `json:"tools,omitempty"`
+	ToolChoice    *AnthropicToolChoice `json:"tool_choice,omitempty"`
+	Metadata      *AnthropicMetadata   `json:"metadata,omitempty"`
+}
+
+// AnthropicMessage Messages API 消息,content 为字符串或内容块数组
+type AnthropicMessage struct {
+	Role    string           `json:"role"` // user, assistant
+	Content AnthropicContent `json:"content"`
+}
+
+// AnthropicContent 内容块列表; 反序列化时字符串视为单个文本块
+type AnthropicContent []AnthropicContentBlock
+
+// UnmarshalJSON 接受字符串或内容块数组
+func (c *AnthropicContent) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = AnthropicContent{{Type: "text", Text: text}}
+		return nil
+	}
+	var blocks []AnthropicContentBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return errors.New("content must be a string or an array of content blocks")
+	}
+	*c = blocks
+	return nil
+}
+
+// AnthropicContentBlock 内容块: text、tool_use、tool_result (以及不支持的 image 等)
+type AnthropicContentBlock struct {
+	Type      string           `json:"type"`
+	Text      string           `json:"text,omitempty"`        // text
+	ID        string           `json:"id,omitempty"`          // tool_use
+	Name      string           `json:"name,omitempty"`        // tool_use
+	Input     json.RawMessage  `json:"input,omitempty"`       // tool_use 参数对象
+	ToolUseID string           `json:"tool_use_id,omitempty"` // tool_result
+	Content   AnthropicContent `json:"content,omitempty"`     // tool_result
+	IsError   bool             `json:"is_error,omitempty"`    // tool_result
+}
+
+// MarshalJSON 只写出块类型对应的字段,text 块即使为空也带 text,tool_use 块总是带 input
+func (b AnthropicContentBlock) MarshalJSON() ([]byte, error) {
+	switch b.Type {
+	case "text":
+		return json.Marshal(struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		}{b.Type, b.Text})
+	case "tool_use":
+		input := b.Input
+		if len(input) == 0 {
+			input = json.RawMessage("{}")
+		}
+		return json.Marshal(struct {
+			Type  string          `json:"type"`
+			ID    string          `json:"id"`
+			Name  string          `json:"name"`
+			Input json.RawMessage `json:"input"`
+		}{b.Type, b.ID, b.Name, input})
+	}
+	type plain AnthropicContentBlock
+	return json.Marshal(plain(b))
+}
+
+// AnthropicTool Messages API 工具定义
+type AnthropicTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema,omitempty"`
+}
+
+// AnthropicToolChoice 工具选择策略: auto、any、tool (指定 name) 或 none
+type AnthropicToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+// AnthropicMetadata 请求元数据
+type AnthropicMetadata struct {
+	UserID string `json:"user_id,omitempty"`
+}
+
+// AnthropicUsage Token 使用统计
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// AnthropicResponse Messages API 响应,也是流式 message_start 事件中的 message
+type AnthropicResponse struct {
+	ID           string           `json:"id"`
+	Type         string           `json:"type"` // message
+	Role         string           `json:"role"` // assistant
+	Model        string           `json:"model"`
+	Content      AnthropicContent `json:"content"`
+	StopReason   *string          `json:"stop_reason"`   // end_turn, max_tokens, tool_use; 流开始时为 null
+	StopSequence *string          `json:"stop_sequence"` // 总是 null: 上游不报告命中的停止序列
+	Usage        AnthropicUsage   `json:"usage"`
+}
+
+// AnthropicDelta content_block_delta 与 message_delta 事件的 delta
+type AnthropicDelta struct {
+	Type        string  `json:"type,omitempty"`         // text_delta, input_json_delta
+	Text        string  `json:"text,omitempty"`         // text_delta
+	PartialJSON string  `json:"partial_json,omitempty"` // input_json_delta
+	StopReason  *string `json:"stop_reason,omitempty"`  // message_delta
+}
+
+// AnthropicEvent Messages API 流式事件,以 "event: <type>" 和 JSON data 发送
+type AnthropicEvent struct {
+	Type         string                 `json:"type"`
+	Message      *AnthropicResponse     `json:"message,omitempty"`       // message_start
+	Index        *int                   `json:"index,omitempty"`         // content_block_*
+	ContentBlock *AnthropicContentBlock `json:"content_block,omitempty"` // content_block_start
+	Delta        *AnthropicDelta        `json:"delta,omitempty"`         // content_block_delta, message_delta
+	Usage        *AnthropicUsage        `json:"usage,omitempty"`         // message_delta
+	Error        *AnthropicError        `json:"error,omitempty"`         // error
+}
+
+// AnthropicError Messages API 错误详情
+type AnthropicError struct {
+	Type    string `json:"type"` // invalid_request_error, authentication_error, rate_limit_error, api_error, ...
+	Message string `json:"message"`
+}
+
+// AnthropicErrorResponse Messages API 错误响应
+type AnthropicErrorResponse struct {
+	Type  string         `json:"type"` // error
+	Error AnthropicError `json:"error"`
+}
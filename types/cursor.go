@@ -1,14 +1,77 @@
 package types
 
+import (
+	"encoding/json"
+	"slices"
+)
+
+// SSEEventType 上游 SSE 事件类型 (AI SDK v5 UI message stream)
+type SSEEventType string
+
+// 上游 SSE 事件类型; 工具调用以 tool-input-error 事件下发
+const (
+	SSEEventStart               SSEEventType = "start"
+	SSEEventStartStep           SSEEventType = "start-step"
+	SSEEventFinishStep          SSEEventType = "finish-step"
+	SSEEventFinish              SSEEventType = "finish"
+	SSEEventError               SSEEventType = "error"
+	SSEEventAbort               SSEEventType = "abort"
+	SSEEventMessageMetadata     SSEEventType = "message-metadata"
+	SSEEventTextStart           SSEEventType = "text-start"
+	SSEEventTextDelta           SSEEventType = "text-delta"
+	SSEEventTextEnd             SSEEventType = "text-end"
+	SSEEventReasoningStart      SSEEventType = "reasoning-start"
+	SSEEventReasoningDelta      SSEEventType = "reasoning-delta"
+	SSEEventReasoningEnd        SSEEventType = "reasoning-end"
+	SSEEventToolInputStart      SSEEventType = "tool-input-start"
+	SSEEventToolInputDelta      SSEEventType = "tool-input-delta"
+	SSEEventToolInputAvailable  SSEEventType = "tool-input-available"
+	SSEEventToolInputError      SSEEventType = "tool-input-error"
+	SSEEventToolOutputAvailable SSEEventType = "tool-output-available"
+	SSEEventToolOutputError     SSEEventType = "tool-output-error"
+	SSEEventSourceURL           SSEEventType = "source-url"
+	SSEEventSourceDocument      SSEEventType = "source-document"
+	SSEEventFile                SSEEventType = "file"
+)
+
+// sseEventTypes 所有已知的事件类型,新增类型时同时加入此列表
+var sseEventTypes = []SSEEventType{
+	SSEEventStart, SSEEventStartStep, SSEEventFinishStep, SSEEventFinish, SSEEventError, SSEEventAbort,
+	SSEEventMessageMetadata, SSEEventTextStart, SSEEventTextDelta, SSEEventTextEnd,
+	SSEEventReasoningStart, SSEEventReasoningDelta, SSEEventReasoningEnd,
+	SSEEventToolInputStart, SSEEventToolInputDelta, SSEEventToolInputAvailable, SSEEventToolInputError,
+	SSEEventToolOutputAvailable, SSEEventToolOutputError, SSEEventSourceURL, SSEEventSourceDocument, SSEEventFile,
+}
+
+// SSEEventTypes 返回所有已知的事件类型
+func SSEEventTypes() []SSEEventType {
+	return slices.Clone(sseEventTypes)
+}
+
+// Known 判断事件类型是否为已知类型
+func (t SSEEventType) Known() bool {
+	return slices.Contains(sseEventTypes, t)
+}
+
+// DecodeSSEEvent 解析一个 SSE data 负载
+// 未知的事件类型不是错误: 事件照常返回,由调用方通过 Type.Known 判断并统计
+func DecodeSSEEvent(data []byte) (SSEEventData, error) {
+	var event SSEEventData
+	err := json.Unmarshal(data, &event)
+	return event, err
+}
+
 // SSEEventData SSE事件数据结构
 type SSEEventData struct {
-	Type            string                 `json:"type"`
+	Type            SSEEventType           `json:"type"`
 	ID              string                 `json:"id,omitempty"`
 	Delta           string                 `json:"delta,omitempty"`
 	MessageMetadata *MessageMetadata       `json:"messageMetadata,omitempty"`
 	ToolCallID      string                 `json:"toolCallId,omitempty"`
 	ToolName        string                 `json:"toolName,omitempty"`
 	Input           interface{} `json:"input,omitempty"`
+	FinishReason    string                 `json:"finishReason,omitempty"`
+	ErrorText       string                 `json:"errorText,omitempty"`
 }
 
 // MessageMetadata 消息元数据
@@ -64,3 +127,45 @@ type CursorToolCall struct {
 	ToolName  string `json:"tool_name"`
 	ToolInput string `json:"tool_input"`
 }
+
+// CursorToolCalls are the tool calls of a turn that ended with them,
+// in the order the upstream emitted them
+type CursorToolCalls []CursorToolCall
+
+// CursorReasoning is a fragment of the upstream's reasoning in a stream, only sent
+// when the request enabled the reasoning_content feature
+type CursorReasoning string
+
+// 流终止原因 - stop/length/tool_calls/content_filter 原样返回给客户端,
+// upstream_abort 与 invalid_json 对外表现为 "error",cancelled 仅用于日志和统计(客户端已断开)
+const (
+	FinishReasonStop          = "stop"
+	FinishReasonLength        = "length"
+	FinishReasonToolCalls     = "tool_calls"
+	FinishReasonContentFilter = "content_filter"
+	FinishReasonUpstreamAbort = "upstream_abort"
+	FinishReasonCancelled     = "cancelled"
+	FinishReasonInvalidJSON   = "invalid_json" // JSON 模式下输出无法修正为 JSON 对象
+)
+
+// StreamFinish 上游流结束信号,在 StreamChat 的数据通道关闭前发送
+// Err 仅在 Reason 为 upstream_abort 或 invalid_json 时非空
+// Usage 为上游 messageMetadata 报告的用量,上游未报告时为 nil
+type StreamFinish struct {
+	Reason string
+	Err    error
+	Usage  *Usage
+}
+
+// CursorTextResult 非流式文本结果
+type CursorTextResult struct {
+	Content      string
+	FinishReason string
+	Usage        *Usage // 上游报告的用量,未报告时为 nil
+}
+
+// CursorToolCallResult 非流式工具调用结果
+type CursorToolCallResult struct {
+	Calls CursorToolCalls
+	Usage *Usage // 上游报告的用量,未报告时为 nil
+}
@@ -0,0 +1,83 @@
+package types
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDecodeSSEEvent_KnownTypes(t *testing.T) {
+	// Wire names of the AI SDK v5 UI message stream; every registered type must be listed here
+	wire := []string{
+		"start", "start-step", "finish-step", "finish", "error", "abort", "message-metadata",
+		"text-start", "text-delta", "text-end", "reasoning-start", "reasoning-delta", "reasoning-end",
+		"tool-input-start", "tool-input-delta", "tool-input-available", "tool-input-error",
+		"tool-output-available", "tool-output-error", "source-url", "source-document", "file",
+	}
+	if got := SSEEventTypes(); len(got) != len(wire) {
+		t.Fatalf("registered types = %d, want %d", len(got), len(wire))
+	}
+	seen := make(map[SSEEventType]bool)
+	for _, name := range wire {
+		t.Run(name, func(t *testing.T) {
+			event, err := DecodeSSEEvent(fmt.Appendf(nil, `{"type":%q}`, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(event.Type) != name || !event.Type.Known() {
+				t.Errorf("type = %q, known = %v", event.Type, event.Type.Known())
+			}
+			if seen[event.Type] {
+				t.Errorf("type %q registered twice", name)
+			}
+			seen[event.Type] = true
+		})
+	}
+}
+
+func TestDecodeSSEEvent_Fields(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		check func(SSEEventData) bool
+	}{
+		{"text delta", `{"type":"text-delta","id":"0","delta":"Hi"}`, func(e SSEEventData) bool {
+			return e.Type == SSEEventTextDelta && e.ID == "0" && e.Delta == "Hi"
+		}},
+		{"tool call", `{"type":"tool-input-error","toolCallId":"c1","toolName":"read","input":{"path":"a"}}`, func(e SSEEventData) bool {
+			input, ok := e.Input.(map[string]interface{})
+			return e.Type == SSEEventToolInputError && e.ToolCallID == "c1" && e.ToolName == "read" && ok && input["path"] == "a"
+		}},
+		{"finish with usage", `{"type":"finish","finishReason":"stop","messageMetadata":{"usage":{"inputTokens":3,"outputTokens":2,"totalTokens":5}}}`, func(e SSEEventData) bool {
+			return e.Type == SSEEventFinish && e.FinishReason == "stop" && e.MessageMetadata != nil && e.MessageMetadata.Usage.TotalTokens == 5
+		}},
+		{"error", `{"type":"error","errorText":"overloaded"}`, func(e SSEEventData) bool {
+			return e.Type == SSEEventError && e.ErrorText == "overloaded"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := DecodeSSEEvent([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(event) {
+				t.Errorf("event = %+v", event)
+			}
+		})
+	}
+}
+
+func TestDecodeSSEEvent_UnknownAndMalformed(t *testing.T) {
+	for _, data := range []string{`{"type":"content-chunk","value":"x"}`, `{"delta":"no type"}`} {
+		event, err := DecodeSSEEvent([]byte(data))
+		if err != nil {
+			t.Fatalf("%s: unknown events must decode, got %v", data, err)
+		}
+		if event.Type.Known() {
+			t.Errorf("%s: type %q reported as known", data, event.Type)
+		}
+	}
+	if _, err := DecodeSSEEvent([]byte(`{"type":`)); err == nil {
+		t.Error("malformed JSON must fail")
+	}
+}
@@ -11,6 +11,8 @@ type ErrorDetail struct {
 	Type    string `json:"type"`
 	Param   string `json:"param,omitempty"`
 	Code    string `json:"code,omitempty"`
+	Hint    string `json:"hint,omitempty"`    // 可操作的修复建议 (ERROR_HINTS_ENABLED)
+	DocURL  string `json:"doc_url,omitempty"` // 错误码文档链接 (ERROR_DOCS_URL)
 }
 
 // ErrorResponse OpenAI 错误响应
@@ -23,6 +25,8 @@ type OpenAIError struct {
 	Message string `json:"message"`
 	Type    string `json:"type"`
 	Code    string `json:"code"`
+	Hint    string `json:"hint,omitempty"`
+	DocURL  string `json:"doc_url,omitempty"`
 }
 
 // OpenAIErrorResponse wraps OpenAI error for HTTP responses
@@ -0,0 +1,110 @@
+package types
+
+import "encoding/json"
+
+// GeminiRequest Gemini generateContent 请求 (POST /v1beta/models/{model}:generateContent)
+type GeminiRequest struct {
+	Contents          []GeminiContent         `json:"contents"`
+	SystemInstruction *GeminiContent          `json:"systemInstruction,omitempty"`
+	Tools             []GeminiTool            `json:"tools,omitempty"`
+	ToolConfig        *GeminiToolConfig       `json:"toolConfig,omitempty"`
+	GenerationConfig  *GeminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+// GeminiContent 一轮对话内容,role 为 user 或 model
+type GeminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+// GeminiPart 内容片段,每个片段只设置一种数据
+type GeminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"` // 模型思考摘要
+	FunctionCall     *GeminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *GeminiFunctionResponse `json:"functionResponse,omitempty"`
+	InlineData       json.RawMessage         `json:"inlineData,omitempty"` // 不支持
+	FileData         json.RawMessage         `json:"fileData,omitempty"`   // 不支持
+}
+
+// GeminiFunctionCall 模型发起的函数调用
+type GeminiFunctionCall struct {
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// GeminiFunctionResponse 客户端返回的函数执行结果
+type GeminiFunctionResponse struct {
+	ID       string          `json:"id,omitempty"`
+	Name     string          `json:"name"`
+	Response json.RawMessage `json:"response"`
+}
+
+// GeminiTool 工具集合,只支持 functionDeclarations
+type GeminiTool struct {
+	FunctionDeclarations []GeminiFunctionDeclaration `json:"functionDeclarations,omitempty"`
+}
+
+// GeminiFunctionDeclaration 函数声明
+type GeminiFunctionDeclaration struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// GeminiToolConfig 工具调用配置
+type GeminiToolConfig struct {
+	FunctionCallingConfig *GeminiFunctionCallingConfig `json:"functionCallingConfig,omitempty"`
+}
+
+// GeminiFunctionCallingConfig 函数调用模式: AUTO, ANY, NONE
+type GeminiFunctionCallingConfig struct {
+	Mode                 string   `json:"mode,omitempty"`
+	AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+}
+
+// GeminiGenerationConfig 生成参数
+type GeminiGenerationConfig struct {
+	Temperature      float64  `json:"temperature,omitempty"`
+	TopP             float64  `json:"topP,omitempty"`
+	MaxOutputTokens  int      `json:"maxOutputTokens,omitempty"`
+	StopSequences    []string `json:"stopSequences,omitempty"`
+	CandidateCount   int      `json:"candidateCount,omitempty"`
+	ResponseMimeType string   `json:"responseMimeType,omitempty"` // application/json 时要求输出 JSON
+}
+
+// GeminiResponse generateContent 响应,流式响应的每个事件也是该结构
+type GeminiResponse struct {
+	Candidates    []GeminiCandidate    `json:"candidates,omitempty"`
+	UsageMetadata *GeminiUsageMetadata `json:"usageMetadata,omitempty"`
+	ModelVersion  string               `json:"modelVersion,omitempty"`
+	ResponseID    string               `json:"responseId,omitempty"`
+	Error         *GeminiError         `json:"error,omitempty"` // 流式响应中途失败时的错误事件
+}
+
+// GeminiCandidate 候选结果
+type GeminiCandidate struct {
+	Content      GeminiContent `json:"content"`
+	FinishReason string        `json:"finishReason,omitempty"` // STOP, MAX_TOKENS, SAFETY, OTHER
+	Index        int           `json:"index"`
+}
+
+// GeminiUsageMetadata token 用量
+type GeminiUsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+}
+
+// GeminiErrorResponse Gemini 格式的错误响应
+type GeminiErrorResponse struct {
+	Error GeminiError `json:"error"`
+}
+
+// GeminiError 错误详情,status 为 google.rpc.Code 名称
+type GeminiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
@@ -7,9 +7,75 @@ type HealthResponse struct {
 	Status           string    `json:"status"`
 	Timestamp        time.Time `json:"timestamp"`
 	ManagerHealthy   bool      `json:"manager_healthy"`
+	Ready            bool      `json:"ready"`
 	ParameterAge     string    `json:"parameter_age,omitempty"`
 	TotalRequests    int64     `json:"total_requests"`
 	SuccessRequests  int64     `json:"success_requests"`
 	FailedRequests   int64     `json:"failed_requests"`
 	CacheHits        int64     `json:"cache_hits"`
+	RefreshPaused    bool      `json:"refresh_paused"`
+
+	LastError    *ManagerError    `json:"last_error,omitempty"`
+	RecentErrors []ManagerError   `json:"recent_errors,omitempty"`
+	ErrorCounts  map[string]int64 `json:"error_counts,omitempty"`
+
+	Heartbeat     *HeartbeatStatus     `json:"heartbeat,omitempty"`      // 未配置推送时省略
+	ModelGuidance *ModelGuidanceStatus `json:"model_guidance,omitempty"` // 未配置模型指南时省略
+	Availability  *AvailabilityStatus  `json:"availability,omitempty"`
+}
+
+// HeartbeatStatus 心跳推送的投递状态
+type HeartbeatStatus struct {
+	Delivered           int64      `json:"delivered"`
+	Failed              int64      `json:"failed"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastAttempt         *time.Time `json:"last_attempt,omitempty"`
+	LastDelivery        *time.Time `json:"last_delivery,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	NextAttempt         time.Time  `json:"next_attempt"`
+}
+
+// AvailabilityStatus 降级阶梯的当前阶段及其原因
+type AvailabilityStatus struct {
+	Stage          string    `json:"stage"` // normal, quotas_disabled, cached_token, chat_unavailable
+	Since          time.Time `json:"since"`
+	ChatAvailable  bool      `json:"chat_available"`
+	QuotasEnforced bool      `json:"quotas_enforced"`
+	TokenAge       string    `json:"token_age,omitempty"`
+	SolverError    string    `json:"solver_error,omitempty"`
+	StorageError   string    `json:"storage_error,omitempty"`
+}
+
+// ModelGuidanceStatus 上游模型指南的拉取状态
+type ModelGuidanceStatus struct {
+	Models      int        `json:"models"` // 当前生效的模型指南数量
+	Fetched     int64      `json:"fetched"`
+	Failed      int64      `json:"failed"`
+	LastAttempt *time.Time `json:"last_attempt,omitempty"`
+	LastUpdate  *time.Time `json:"last_update,omitempty"` // 最近一次拿到新内容的时间
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// ManagerError 参数管理器的一条错误记录
+type ManagerError struct {
+	Time     time.Time `json:"time"`
+	Category string    `json:"category"` // js_download, process, not_initialized, unknown
+	Source   string    `json:"source"`   // startup, loop, on_demand
+	Message  string    `json:"message"`
+}
+
+// ReadyResponse 就绪检查响应
+type ReadyResponse struct {
+	Ready  bool   `json:"ready"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// UpstreamAccountStatus 上游账号池中单个账号的状态
+type UpstreamAccountStatus struct {
+	Name                string     `json:"name"`
+	Healthy             bool       `json:"healthy"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	UnhealthyUntil      *time.Time `json:"unhealthy_until,omitempty"`
+	InFlight            int64      `json:"in_flight"`
+	Conversations       int        `json:"conversations"` // Conversations currently pinned to this account
 }
@@ -6,6 +6,16 @@ type Model struct {
 	Object  string `json:"object"`
 	Created int64  `json:"created"`
 	OwnedBy string `json:"owned_by"`
+
+	Description   string `json:"description,omitempty"`    // 上游模型指南中的说明,未启用时省略
+	ContextWindow int    `json:"context_window,omitempty"` // 上下文长度(模型目录配置,否则取上游模型指南),未知时省略
+	SupportsTools bool   `json:"supports_tools,omitempty"` // 模型目录中标记支持工具调用
+	Vision        bool   `json:"vision,omitempty"`         // 模型目录中标记支持图片输入
+
+	AliasFor string `json:"alias_for,omitempty"` // 别名条目指向的模型,请求时按该模型处理
+
+	Deprecated bool   `json:"deprecated,omitempty"`  // 已弃用的模型仍可使用,响应带 Warning 头
+	ReplacedBy string `json:"replaced_by,omitempty"` // 建议迁移到的模型
 }
 
 // ModelList 模型列表
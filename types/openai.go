@@ -1,12 +1,18 @@
 package types
 
+import (
+	"cmp"
+	"encoding/json"
+)
+
 // ChatMessage OpenAI 消息格式
 type ChatMessage struct {
-	Role       string     `json:"role,omitempty"`         // system, user, assistant, tool
-	Content    string     `json:"content,omitempty"`      // 消息内容
-	Name       string     `json:"name,omitempty"`         // 函数/工具名称 (function/tool role)
-	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`   // 工具调用列表 (assistant role)
-	ToolCallID string     `json:"tool_call_id,omitempty"` // 工具调用ID (tool role)
+	Role             string     `json:"role,omitempty"`              // system, user, assistant, tool
+	Content          string     `json:"content,omitempty"`           // 消息内容
+	Name             string     `json:"name,omitempty"`              // 函数/工具名称 (function/tool role)
+	ToolCalls        []ToolCall `json:"tool_calls,omitempty"`        // 工具调用列表 (assistant role)
+	ToolCallID       string     `json:"tool_call_id,omitempty"`      // 工具调用ID (tool role)
+	ReasoningContent string     `json:"reasoning_content,omitempty"` // 上游推理内容 (流式 delta,需开启 reasoning_content 特性)
 }
 
 // ChatCompletionRequest OpenAI 聊天完成请求
@@ -14,6 +20,7 @@ type ChatCompletionRequest struct {
 	Model            string                 `json:"model"`
 	Messages         []ChatMessage          `json:"messages"`
 	Stream           bool                   `json:"stream,omitempty"`
+	StreamOptions    *StreamOptions         `json:"stream_options,omitempty"` // 仅在 stream 为 true 时允许
 	Temperature      float64                `json:"temperature,omitempty"`
 	TopP             float64                `json:"top_p,omitempty"`
 	N                int                    `json:"n,omitempty"`
@@ -25,9 +32,80 @@ type ChatCompletionRequest struct {
 	Tools            []Tool                 `json:"tools,omitempty"`           // 可用工具列表
 	ToolChoice       interface{}            `json:"tool_choice,omitempty"`     // 工具选择策略
 	ConversationID   string                 `json:"conversation_id,omitempty"`
+	Metadata         map[string]string      `json:"metadata,omitempty"` // 调用方标签,原样回显并用于用量归因
+	PromptID         string                 `json:"prompt_id,omitempty"` // 服务端提示词模板 ID,展开后置于 messages 之前
+	Variables        map[string]string      `json:"variables,omitempty"` // 模板变量,替换模板中的 {{name}}
+	ResponseFormat   *ResponseFormat        `json:"response_format,omitempty"` // 输出格式,type 为 json_object 时要求输出 JSON 对象
+	StreamProgress   bool                   `json:"stream_progress,omitempty"` // 流式响应中周期性发送 x-progress 注释 (扩展)
+	StopOnToolCall   *bool                  `json:"stop_on_tool_call,omitempty"` // false 时流式响应在工具调用后继续,文本与工具调用交错发送直到上游结束 (扩展)
+	SelfVerify       *bool                  `json:"self_verify,omitempty"` // 非流式响应违反格式约束时由模型修复后返回,覆盖 SELF_VERIFY_ENABLED (扩展)
+	ParallelToolCalls *bool                 `json:"parallel_tool_calls,omitempty"` // false 时每个响应最多返回一个工具调用
+	RequestedModel   string                 `json:"-"` // RESPONSE_MODEL=requested 时在响应中回显的模型名 (别名解析之前)
 	Extra            map[string]interface{} `json:"-"`
 }
 
+// ResponseModel 返回响应 model 字段的取值: 设置了 RequestedModel 时回显客户端请求的模型名,否则为实际使用的模型
+func (r *ChatCompletionRequest) ResponseModel() string {
+	return cmp.Or(r.RequestedModel, r.Model)
+}
+
+// StreamOptions 流式响应选项
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage,omitempty"` // 在 [DONE] 之前发送 choices 为空、只含 usage 的最终 chunk
+}
+
+// IncludeUsage 报告流式响应是否以单独的 usage chunk 结束 (stream_options.include_usage)
+func (r *ChatCompletionRequest) IncludeUsage() bool {
+	return r.Stream && r.StreamOptions != nil && r.StreamOptions.IncludeUsage
+}
+
+// ResponseFormat 输出格式约束
+type ResponseFormat struct {
+	Type           string            `json:"type"`                      // text, json_object, json_schema
+	RequiredFields []string          `json:"required_fields,omitempty"` // json_object 必须包含的顶层字段 (扩展)
+	JSONSchema     *JSONSchemaFormat `json:"json_schema,omitempty"`     // type 为 json_schema 时必填
+}
+
+// JSONSchemaFormat response_format.json_schema: 输出必须符合的 JSON Schema
+type JSONSchemaFormat struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema,omitempty"`
+	Strict      *bool           `json:"strict,omitempty"`
+}
+
+// JSONMode 报告请求是否要求模型只输出一个 JSON 对象 (json_object 或 json_schema)
+func (r *ChatCompletionRequest) JSONMode() bool {
+	return r.ResponseFormat != nil && (r.ResponseFormat.Type == "json_object" || r.ResponseFormat.Type == "json_schema")
+}
+
+// JSONSchema 返回 json_schema 模式下输出必须符合的 schema,其他模式返回 nil
+func (r *ChatCompletionRequest) JSONSchema() json.RawMessage {
+	if r.ResponseFormat == nil || r.ResponseFormat.Type != "json_schema" || r.ResponseFormat.JSONSchema == nil {
+		return nil
+	}
+	return r.ResponseFormat.JSONSchema.Schema
+}
+
+// RequiredFields 返回 json_object 模式下输出对象必须包含的顶层字段
+// (json_schema 模式的必需字段由 schema 描述)
+func (r *ChatCompletionRequest) RequiredFields() []string {
+	if r.ResponseFormat == nil || r.ResponseFormat.Type != "json_object" {
+		return nil
+	}
+	return r.ResponseFormat.RequiredFields
+}
+
+// InterleavedToolCalls 报告流式响应是否在工具调用后保持打开 (stop_on_tool_call=false)
+func (r *ChatCompletionRequest) InterleavedToolCalls() bool {
+	return r.Stream && r.StopOnToolCall != nil && !*r.StopOnToolCall
+}
+
+// SingleToolCall 报告响应是否只返回第一个工具调用 (parallel_tool_calls=false)
+func (r *ChatCompletionRequest) SingleToolCall() bool {
+	return r.ParallelToolCalls != nil && !*r.ParallelToolCalls
+}
+
 // ChatCompletionChoice 响应选项
 type ChatCompletionChoice struct {
 	Index        int          `json:"index"`
@@ -38,9 +116,15 @@ type ChatCompletionChoice struct {
 
 // ChatCompletionUsage Token 使用统计
 type ChatCompletionUsage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens        int                  `json:"prompt_tokens"`
+	CompletionTokens    int                  `json:"completion_tokens"`
+	TotalTokens         int                  `json:"total_tokens"`
+	PromptTokensDetails *PromptTokensDetails `json:"prompt_tokens_details,omitempty"` // 仅在上游报告了缓存命中时返回
+}
+
+// PromptTokensDetails prompt token 明细
+type PromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
 }
 
 // ChatCompletionResponse OpenAI 聊天完成响应（非流式）
@@ -49,8 +133,9 @@ type ChatCompletionResponse struct {
 	Object  string                   `json:"object"`
 	Created int64                    `json:"created"`
 	Model   string                   `json:"model"`
-	Choices []ChatCompletionChoice   `json:"choices"`
-	Usage   ChatCompletionUsage      `json:"usage"`
+	Choices  []ChatCompletionChoice `json:"choices"`
+	Usage    ChatCompletionUsage    `json:"usage"`
+	Metadata map[string]string      `json:"metadata,omitempty"`
 }
 
 // ChatCompletionStreamResponse OpenAI 流式响应
@@ -61,4 +146,5 @@ type ChatCompletionStreamResponse struct {
 	Model   string                  `json:"model"`
 	Choices []ChatCompletionChoice  `json:"choices"`
 	Usage   *ChatCompletionUsage    `json:"usage,omitempty"`
+	Error   *ErrorDetail            `json:"error,omitempty"` // 仅在上游中途终止时出现在最终 chunk
 }
@@ -15,14 +15,22 @@ type FunctionDef struct {
 
 // ToolCall represents a function call made by the model
 type ToolCall struct {
-	Index    int                 `json:"index,omitempty"`    // Index for streaming tool calls
-	ID       string              `json:"id"`
-	Type     string              `json:"type"`
+	Index    *int                `json:"index,omitempty"`    // Set in every streamed tool call delta (including 0), nil in messages
+	ID       string              `json:"id,omitempty"`   // Only in the first chunk of a streamed tool call
+	Type     string              `json:"type,omitempty"` // Only in the first chunk of a streamed tool call
 	Function ToolCallFunction    `json:"function"`
 }
 
+// StreamIndex returns the index of a streamed tool call delta (0 when absent)
+func (c ToolCall) StreamIndex() int {
+	if c.Index == nil {
+		return 0
+	}
+	return *c.Index
+}
+
 // ToolCallFunction represents the function details in a tool call
 type ToolCallFunction struct {
-	Name      string `json:"name"`
+	Name      string `json:"name,omitempty"` // Only in the first chunk of a streamed tool call
 	Arguments string `json:"arguments"`
 }
\ No newline at end of file
@@ -0,0 +1,189 @@
+package upstream
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/imroc/req/v3"
+	utls "github.com/refraction-networking/utls"
+
+	"cursor2api/config"
+	"cursor2api/logger"
+)
+
+// CheckTLS validates the upstream TLS settings at startup, so a CA bundle that
+// cannot be loaded or a malformed pin fails loudly instead of leaving NewClient
+// on the system roots or without pinning
+func CheckTLS(cfg config.UpstreamConfig) error {
+	if _, err := rootCAs(cfg.TLSCAFiles); err != nil {
+		return fmt.Errorf("UPSTREAM_TLS_CA_FILES: %w", err)
+	}
+	if _, err := parsePins(cfg.TLSPinnedSHA256); err != nil {
+		return fmt.Errorf("UPSTREAM_TLS_PINNED_SHA256: %w", err)
+	}
+	return nil
+}
+
+// NewClient creates a Chrome-fingerprinted req client tuned with the upstream pool settings
+// The same builder is shared by CursorService and AntiBotManager so both reuse TLS sessions
+func NewClient(cfg config.UpstreamConfig) *req.Client {
+	client := req.C().ImpersonateChrome()
+
+	var sessionCache utls.ClientSessionCache
+	if cfg.TLSSessionCacheSize > 0 {
+		sessionCache = utls.NewLRUClientSessionCache(cfg.TLSSessionCacheSize)
+	}
+	pins, err := parsePins(cfg.TLSPinnedSHA256)
+	if err != nil {
+		logger.Error("Invalid upstream TLS pin, pinning only the valid ones | error=%v", err)
+	}
+	setTLSFingerprint(client, utls.HelloChrome_131, sessionCache, pins)
+
+	if len(cfg.TLSCAFiles) > 0 {
+		if roots, err := rootCAs(cfg.TLSCAFiles); err != nil {
+			logger.Error("Failed to load upstream CA files, using the system roots | error=%v", err)
+		} else {
+			client.GetTLSClientConfig().RootCAs = roots
+		}
+	}
+	if cfg.TLSInsecure {
+		logger.Warn("Upstream TLS certificate verification is DISABLED (UPSTREAM_TLS_INSECURE=true)")
+		client.EnableInsecureSkipVerify()
+	}
+
+	t := client.GetTransport()
+	t.SetMaxIdleConns(cfg.MaxIdleConns).
+		SetMaxConnsPerHost(cfg.MaxConnsPerHost).
+		SetIdleConnTimeout(cfg.IdleConnTimeout).
+		SetTLSHandshakeTimeout(cfg.TLSHandshakeTimeout)
+	t.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	t.DisableKeepAlives = cfg.DisableKeepAlives
+
+	if resolver := NewResolver(cfg); resolver != nil {
+		client.SetDial(resolver.DialContext)
+	}
+
+	return client
+}
+
+// rootCAs returns the system roots with the PEM bundles of files added
+func rootCAs(files []string) (*x509.CertPool, error) {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no PEM certificate found in %s", file)
+		}
+	}
+	return roots, nil
+}
+
+// setTLSFingerprint mirrors req's SetTLSFingerprint but keeps a client session cache,
+// so reconnects resume TLS sessions instead of performing a full uTLS handshake.
+// When pins is non-empty the leaf chain must contain a certificate whose SPKI hash is pinned.
+func setTLSFingerprint(c *req.Client, clientHelloID utls.ClientHelloID, sessionCache utls.ClientSessionCache, pins map[string]struct{}) {
+	c.SetTLSHandshake(func(ctx context.Context, addr string, plainConn net.Conn) (net.Conn, *tls.ConnectionState, error) {
+		hostname := addr
+		if colonPos := strings.LastIndex(addr, ":"); colonPos != -1 {
+			hostname = addr[:colonPos]
+		}
+
+		tlsConfig := c.GetTLSClientConfig()
+		utlsConfig := &utls.Config{
+			ServerName:             hostname,
+			RootCAs:                tlsConfig.RootCAs,
+			NextProtos:             tlsConfig.NextProtos,
+			InsecureSkipVerify:     tlsConfig.InsecureSkipVerify,
+			SessionTicketsDisabled: sessionCache == nil,
+			ClientSessionCache:     sessionCache,
+			MinVersion:             tlsConfig.MinVersion,
+			MaxVersion:             tlsConfig.MaxVersion,
+			KeyLogWriter:           tlsConfig.KeyLogWriter,
+		}
+
+		uconn := &uTLSConn{utls.UClient(plainConn, utlsConfig, clientHelloID)}
+		if err := uconn.HandshakeContext(ctx); err != nil {
+			return nil, nil, err
+		}
+
+		state := uconn.ConnectionState()
+		if len(pins) > 0 {
+			if err := verifyPins(state, pins); err != nil {
+				_ = uconn.Close()
+				return nil, nil, err
+			}
+		}
+		return uconn, &state, nil
+	})
+}
+
+// parsePins decodes SPKI SHA-256 pins given as base64 (HPKP style) or hex strings.
+// The error names the first value that is neither; the valid pins are still returned.
+func parsePins(values []string) (map[string]struct{}, error) {
+	pins := make(map[string]struct{}, len(values))
+	var invalid error
+	for _, value := range values {
+		value = strings.TrimPrefix(strings.TrimSpace(value), "sha256/")
+		if raw, err := base64.StdEncoding.DecodeString(value); err == nil && len(raw) == sha256.Size {
+			pins[string(raw)] = struct{}{}
+			continue
+		}
+		if raw, err := hex.DecodeString(strings.ReplaceAll(value, ":", "")); err == nil && len(raw) == sha256.Size {
+			pins[string(raw)] = struct{}{}
+			continue
+		}
+		if invalid == nil {
+			invalid = fmt.Errorf("invalid pin %q: want a base64 or hex SHA-256 hash", value)
+		}
+	}
+	return pins, invalid
+}
+
+// verifyPins checks that at least one presented certificate matches a pinned SPKI hash
+func verifyPins(state tls.ConnectionState, pins map[string]struct{}) error {
+	for _, cert := range state.PeerCertificates {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		if _, ok := pins[string(sum[:])]; ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("tls: no certificate for %s matched the pinned public keys", state.ServerName)
+}
+
+// uTLSConn adapts utls.UConn to expose a crypto/tls ConnectionState
+type uTLSConn struct {
+	*utls.UConn
+}
+
+// ConnectionState converts the utls connection state to the crypto/tls representation
+func (conn *uTLSConn) ConnectionState() tls.ConnectionState {
+	cs := conn.Conn.ConnectionState()
+	return tls.ConnectionState{
+		Version:                     cs.Version,
+		HandshakeComplete:           cs.HandshakeComplete,
+		DidResume:                   cs.DidResume,
+		CipherSuite:                 cs.CipherSuite,
+		NegotiatedProtocol:          cs.NegotiatedProtocol,
+		NegotiatedProtocolIsMutual:  cs.NegotiatedProtocolIsMutual,
+		ServerName:                  cs.ServerName,
+		PeerCertificates:            cs.PeerCertificates,
+		VerifiedChains:              cs.VerifiedChains,
+		SignedCertificateTimestamps: cs.SignedCertificateTimestamps,
+		OCSPResponse:                cs.OCSPResponse,
+		TLSUnique:                   cs.TLSUnique,
+	}
+}
@@ -0,0 +1,122 @@
+package upstream
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCertificate creates a self-signed CA certificate
+func testCertificate(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "cursor2api test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestRootCAs(t *testing.T) {
+	cert := testCertificate(t)
+	dir := t.TempDir()
+	bundle := filepath.Join(dir, "corp-ca.pem")
+	if err := os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	garbage := filepath.Join(dir, "garbage.pem")
+	if err := os.WriteFile(garbage, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	roots, err := rootCAs([]string{bundle})
+	if err != nil {
+		t.Fatalf("rootCAs: %v", err)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots}); err != nil {
+		t.Errorf("extra CA not trusted: %v", err)
+	}
+	if system, err := x509.SystemCertPool(); err == nil {
+		// The bundle is added to the system roots, not used instead of them
+		system.AddCert(cert)
+		if !roots.Equal(system) {
+			t.Error("system roots were replaced by the CA bundle")
+		}
+	}
+
+	for _, files := range [][]string{{filepath.Join(dir, "missing.pem")}, {bundle, garbage}} {
+		if _, err := rootCAs(files); err == nil {
+			t.Errorf("rootCAs(%v) succeeded, want an error", files)
+		}
+	}
+}
+
+func TestParsePins(t *testing.T) {
+	sum := sha256.Sum256([]byte("spki"))
+	b64, hexPin := base64.StdEncoding.EncodeToString(sum[:]), hex.EncodeToString(sum[:])
+	colons := hexPin[:2]
+	for i := 2; i < len(hexPin); i += 2 {
+		colons += ":" + hexPin[i:i+2]
+	}
+
+	for _, value := range []string{b64, "sha256/" + b64, " " + hexPin + " ", colons} {
+		pins, err := parsePins([]string{value})
+		if _, ok := pins[string(sum[:])]; err != nil || !ok || len(pins) != 1 {
+			t.Errorf("parsePins(%q) = %d pins, %v", value, len(pins), err)
+		}
+	}
+
+	short := base64.StdEncoding.EncodeToString(sum[:16])
+	for _, value := range []string{"not-a-pin", short, hexPin[:40]} {
+		pins, err := parsePins([]string{b64, value})
+		if err == nil {
+			t.Errorf("parsePins(%q) succeeded, want an error", value)
+		}
+		if len(pins) != 1 {
+			t.Errorf("parsePins(%q) kept %d valid pins, want 1", value, len(pins))
+		}
+	}
+}
+
+func TestVerifyPins(t *testing.T) {
+	cert, other := testCertificate(t), testCertificate(t)
+	state := tls.ConnectionState{ServerName: "cursor.test", PeerCertificates: []*x509.Certificate{other, cert}}
+
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	if err := verifyPins(state, map[string]struct{}{string(sum[:]): {}}); err != nil {
+		t.Errorf("a pinned certificate in the chain was rejected: %v", err)
+	}
+
+	unknown := sha256.Sum256([]byte("other key"))
+	if err := verifyPins(state, map[string]struct{}{string(unknown[:]): {}}); err == nil {
+		t.Error("a chain without a pinned key was accepted")
+	}
+}
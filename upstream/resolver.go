@@ -0,0 +1,251 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"cursor2api/config"
+	"cursor2api/logger"
+)
+
+// minDoHCacheTTL bounds how often the same host is re-queried over DoH
+const minDoHCacheTTL = 30 * time.Second
+
+// Resolver resolves upstream hostnames using static pins, DNS-over-HTTPS or custom DNS servers
+// Lookup order: static host pinning -> DoH -> custom DNS servers -> system resolver
+// The DoH server's own hostname is resolved without DoH: static pins -> custom DNS servers -> system resolver
+type Resolver struct {
+	staticHosts map[string]string
+	dohURL      string
+	dohClient   *http.Client
+	dns         *net.Resolver
+
+	mu    sync.RWMutex
+	cache map[string]dohCacheEntry
+}
+
+// dohCacheEntry caches a DoH answer until its TTL expires
+type dohCacheEntry struct {
+	ips     []string
+	expires time.Time
+}
+
+// dohResponse is the JSON DoH answer format (application/dns-json)
+type dohResponse struct {
+	Status int `json:"Status"`
+	Answer []struct {
+		Type int    `json:"type"`
+		TTL  int    `json:"TTL"`
+		Data string `json:"data"`
+	} `json:"Answer"`
+}
+
+// NewResolver creates a resolver from the upstream configuration
+// Returns nil when no custom resolution is configured, so callers keep the default dialer
+func NewResolver(cfg config.UpstreamConfig) *Resolver {
+	if len(cfg.StaticHosts) == 0 && cfg.DoHURL == "" && len(cfg.DNSServers) == 0 {
+		return nil
+	}
+
+	r := &Resolver{
+		staticHosts: make(map[string]string, len(cfg.StaticHosts)),
+		dohURL:      cfg.DoHURL,
+		cache:       make(map[string]dohCacheEntry),
+	}
+	// The DoH server is dialed through the resolver too, so a poisoned system
+	// resolver cannot redirect the lookups meant to bypass it
+	r.dohClient = &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         r.dialDoH,
+			ForceAttemptHTTP2:   true,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+	}
+	for host, ip := range cfg.StaticHosts {
+		r.staticHosts[strings.ToLower(host)] = ip
+	}
+
+	if len(cfg.DNSServers) > 0 {
+		servers := make([]string, 0, len(cfg.DNSServers))
+		for _, server := range cfg.DNSServers {
+			if _, _, err := net.SplitHostPort(server); err != nil {
+				server = net.JoinHostPort(server, "53")
+			}
+			servers = append(servers, server)
+		}
+		var next int
+		var nextMu sync.Mutex
+		r.dns = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				// Rotate through the configured servers on each dial
+				nextMu.Lock()
+				server := servers[next%len(servers)]
+				next++
+				nextMu.Unlock()
+				d := net.Dialer{Timeout: 5 * time.Second}
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	logger.Info("Upstream resolver initialized | static_hosts=%d doh=%v dns_servers=%d",
+		len(r.staticHosts), r.dohURL != "", len(cfg.DNSServers))
+
+	return r
+}
+
+// LookupHost resolves host to a list of IP addresses
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if ips, ok := r.lookupStatic(host); ok {
+		return ips, nil
+	}
+
+	if r.dohURL != "" {
+		ips, err := r.lookupDoH(ctx, host)
+		if err == nil && len(ips) > 0 {
+			return ips, nil
+		}
+		logger.Warn("DoH lookup failed, falling back to DNS | host=%s error=%v", host, err)
+	}
+	return r.lookupDNS(ctx, host)
+}
+
+// lookupDirect resolves host without DoH; used for the DoH server itself
+func (r *Resolver) lookupDirect(ctx context.Context, host string) ([]string, error) {
+	if ips, ok := r.lookupStatic(host); ok {
+		return ips, nil
+	}
+	return r.lookupDNS(ctx, host)
+}
+
+// lookupStatic answers IP literals and pinned hosts
+func (r *Resolver) lookupStatic(host string) ([]string, bool) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{host}, true
+	}
+	if ip, ok := r.staticHosts[strings.ToLower(host)]; ok {
+		return []string{ip}, true
+	}
+	return nil, false
+}
+
+// lookupDNS queries the custom DNS servers, or the system resolver when none are set
+func (r *Resolver) lookupDNS(ctx context.Context, host string) ([]string, error) {
+	if r.dns != nil {
+		return r.dns.LookupHost(ctx, host)
+	}
+	return net.DefaultResolver.LookupHost(ctx, host)
+}
+
+// lookupDoH queries the configured DNS-over-HTTPS endpoint for A records
+func (r *Resolver) lookupDoH(ctx context.Context, host string) ([]string, error) {
+	r.mu.RLock()
+	entry, ok := r.cache[host]
+	r.mu.RUnlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.ips, nil
+	}
+
+	u, err := url.Parse(r.dohURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DoH URL: %w", err)
+	}
+	q := u.Query()
+	q.Set("name", host)
+	q.Set("type", "A")
+	u.RawQuery = q.Encode()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build DoH request: %w", err)
+	}
+	httpReq.Header.Set("Accept", "application/dns-json")
+
+	resp, err := r.dohClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("DoH request failed: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH returned HTTP %d", resp.StatusCode)
+	}
+
+	var answer dohResponse
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return nil, fmt.Errorf("failed to decode DoH response: %w", err)
+	}
+	if answer.Status != 0 {
+		return nil, fmt.Errorf("DoH returned DNS status %d", answer.Status)
+	}
+
+	ttl := time.Duration(0)
+	ips := make([]string, 0, len(answer.Answer))
+	for _, rr := range answer.Answer {
+		// Type 1 = A record, CNAME entries are skipped
+		if rr.Type == 1 && net.ParseIP(rr.Data) != nil {
+			ips = append(ips, rr.Data)
+			if t := time.Duration(rr.TTL) * time.Second; ttl == 0 || t < ttl {
+				ttl = t
+			}
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("DoH returned no A records for %s", host)
+	}
+
+	if ttl < minDoHCacheTTL {
+		ttl = minDoHCacheTTL
+	}
+	r.mu.Lock()
+	r.cache[host] = dohCacheEntry{ips: ips, expires: time.Now().Add(ttl)}
+	r.mu.Unlock()
+
+	return ips, nil
+}
+
+// DialContext resolves addr with the resolver and dials the first reachable IP
+func (r *Resolver) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return r.dial(ctx, network, addr, r.LookupHost)
+}
+
+// dialDoH dials the DoH server, resolving its hostname without DoH
+func (r *Resolver) dialDoH(ctx context.Context, network, addr string) (net.Conn, error) {
+	return r.dial(ctx, network, addr, r.lookupDirect)
+}
+
+// dial resolves addr with lookup and dials the first reachable IP
+func (r *Resolver) dial(ctx context.Context, network, addr string, lookup func(context.Context, string) ([]string, error)) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dial address %s: %w", addr, err)
+	}
+
+	ips, err := lookup(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+
+	dialer := net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("failed to dial %s: %w", host, lastErr)
+}
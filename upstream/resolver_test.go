@@ -0,0 +1,126 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"cursor2api/config"
+)
+
+// dohServer answers A queries for cursor.test with two addresses behind a CNAME
+func dohServer(t *testing.T, queries *atomic.Int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries.Add(1)
+		if r.URL.Query().Get("name") != "cursor.test" || r.URL.Query().Get("type") != "A" ||
+			r.Header.Get("Accept") != "application/dns-json" {
+			t.Errorf("DoH query %s with Accept %q", r.URL.RawQuery, r.Header.Get("Accept"))
+		}
+		fmt.Fprint(w, `{"Status":0,"Answer":[`+
+			`{"type":5,"TTL":300,"data":"edge.cursor.test."},`+
+			`{"type":1,"TTL":60,"data":"192.0.2.10"},`+
+			`{"type":1,"TTL":300,"data":"192.0.2.11"}]}`)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNewResolver_Disabled(t *testing.T) {
+	if NewResolver(config.UpstreamConfig{}) != nil {
+		t.Error("a resolver without static hosts, DoH or DNS servers must be nil")
+	}
+}
+
+func TestResolver_StaticHosts(t *testing.T) {
+	var queries atomic.Int32
+	doh := dohServer(t, &queries)
+	r := NewResolver(config.UpstreamConfig{
+		StaticHosts: map[string]string{"Cursor.test": "192.0.2.1"},
+		DoHURL:      doh.URL,
+	})
+
+	for host, want := range map[string]string{"cursor.TEST": "192.0.2.1", "203.0.113.5": "203.0.113.5"} {
+		ips, err := r.LookupHost(context.Background(), host)
+		if err != nil || !slices.Equal(ips, []string{want}) {
+			t.Errorf("LookupHost(%s) = %v, %v, want %s", host, ips, err, want)
+		}
+	}
+	if queries.Load() != 0 {
+		t.Errorf("pinned hosts were queried over DoH %d times", queries.Load())
+	}
+}
+
+func TestResolver_DoH(t *testing.T) {
+	var queries atomic.Int32
+	doh := dohServer(t, &queries)
+
+	// The DoH server is named by a hostname the system resolver cannot resolve:
+	// the lookup only works when it is dialed through the static hosts
+	u, err := url.Parse(doh.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(u.Host)
+	r := NewResolver(config.UpstreamConfig{
+		StaticHosts: map[string]string{"doh.invalid": "127.0.0.1"},
+		DoHURL:      "http://" + net.JoinHostPort("doh.invalid", port) + "/dns-query",
+	})
+
+	for range 2 {
+		ips, err := r.LookupHost(context.Background(), "cursor.test")
+		if err != nil {
+			t.Fatalf("LookupHost: %v", err)
+		}
+		if !slices.Equal(ips, []string{"192.0.2.10", "192.0.2.11"}) {
+			t.Errorf("LookupHost = %v, want the A records", ips)
+		}
+	}
+	if queries.Load() != 1 {
+		t.Errorf("DoH queries = %d, want 1 (the answer is cached)", queries.Load())
+	}
+}
+
+func TestResolver_DoHFailureFallsBack(t *testing.T) {
+	doh := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer doh.Close()
+
+	r := NewResolver(config.UpstreamConfig{DoHURL: doh.URL})
+	ips, err := r.LookupHost(context.Background(), "localhost")
+	if err != nil || len(ips) == 0 {
+		t.Errorf("LookupHost(localhost) = %v, %v, want the system resolver's answer", ips, err)
+	}
+}
+
+func TestResolver_DialContext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	r := NewResolver(config.UpstreamConfig{StaticHosts: map[string]string{"cursor.invalid": "127.0.0.1"}})
+	conn, err := r.DialContext(context.Background(), "tcp", net.JoinHostPort("cursor.invalid", port))
+	if err != nil {
+		t.Fatalf("DialContext: %v", err)
+	}
+	conn.Close()
+
+	if _, err := r.DialContext(context.Background(), "tcp", "cursor.invalid"); err == nil {
+		t.Error("an address without a port must be rejected")
+	}
+}
@@ -0,0 +1,102 @@
+package upstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Bounds of the traffic tagging headers configured per tenant or API key
+const (
+	MaxTagHeaders     = 8
+	MaxTagValueLength = 256
+)
+
+// protectedHeaders are set by the proxy itself (identity, AntiBot parameters and
+// transport) and can never be overridden by tagging headers
+var protectedHeaders = map[string]bool{
+	"authorization":     true,
+	"cookie":            true,
+	"host":              true,
+	"content-length":    true,
+	"content-type":      true,
+	"transfer-encoding": true,
+	"connection":        true,
+	"user-agent":        true,
+	"referer":           true,
+	"origin":            true,
+	"x-is-human":        true,
+	"x-method":          true,
+	"x-path":            true,
+	"x-forwarded-for":   true,
+	"x-real-ip":         true,
+}
+
+// ValidateTagHeaders checks that traffic tagging headers stay within safe bounds:
+// at most MaxTagHeaders "X-" headers with short printable values, none of which
+// replaces a header the proxy sets itself
+func ValidateTagHeaders(headers map[string]string) error {
+	if len(headers) > MaxTagHeaders {
+		return fmt.Errorf("at most %d tagging headers are allowed, got %d", MaxTagHeaders, len(headers))
+	}
+	for name, value := range headers {
+		canonical := http.CanonicalHeaderKey(name)
+		switch {
+		case !validTagName(name):
+			return fmt.Errorf("invalid header name %q", name)
+		case !strings.HasPrefix(canonical, "X-"):
+			return fmt.Errorf("tagging header %q must start with X-", name)
+		case protectedHeaders[strings.ToLower(name)]:
+			return fmt.Errorf("header %q is set by the proxy and cannot be tagged", name)
+		case len(value) > MaxTagValueLength:
+			return fmt.Errorf("value of header %q exceeds %d bytes", name, MaxTagValueLength)
+		case strings.ContainsFunc(value, func(r rune) bool { return r < ' ' || r == 0x7f }):
+			return fmt.Errorf("invalid value for header %q", name)
+		}
+	}
+	return nil
+}
+
+// LoadKeyTagHeaders reads a JSON object of api_key -> tagging headers and validates every entry
+func LoadKeyTagHeaders(path string) (map[string]map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys map[string]map[string]string
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse key upstream headers: %w", err)
+	}
+	for key, headers := range keys {
+		if err := ValidateTagHeaders(headers); err != nil {
+			return nil, fmt.Errorf("key %s: %w", maskKey(key), err)
+		}
+	}
+	return keys, nil
+}
+
+// maskKey shows only the first 8 characters of an API key in errors
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return "****"
+	}
+	return key[:8] + "****"
+}
+
+// validTagName reports whether name only uses letters, digits and dashes
+func validTagName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
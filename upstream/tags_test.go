@@ -0,0 +1,36 @@
+package upstream
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestValidateTagHeaders(t *testing.T) {
+	tooMany := make(map[string]string)
+	for i := 0; i <= MaxTagHeaders; i++ {
+		tooMany[fmt.Sprintf("X-Tag-%d", i)] = "v"
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		ok      bool
+	}{
+		{name: "valid", headers: map[string]string{"X-Profile": "browser-2", "x-tenant-tag": "acme"}, ok: true},
+		{name: "empty", headers: nil, ok: true},
+		{name: "not an X- header", headers: map[string]string{"Accept-Language": "en"}},
+		{name: "protected header", headers: map[string]string{"X-Is-Human": "forged"}},
+		{name: "header injection", headers: map[string]string{"X-Profile": "a\r\nCookie: b"}},
+		{name: "invalid name", headers: map[string]string{"X-Pro file": "a"}},
+		{name: "value too long", headers: map[string]string{"X-Profile": strings.Repeat("a", MaxTagValueLength+1)}},
+		{name: "too many headers", headers: tooMany},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateTagHeaders(tt.headers); (err == nil) != tt.ok {
+				t.Fatalf("err = %v, want ok = %v", err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package usage
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"cursor2api/clock"
+	"cursor2api/config"
+	"cursor2api/logger"
+	"cursor2api/metrics"
+)
+
+// dayFormat is the layout of budget periods (UTC calendar days)
+const dayFormat = "2006-01-02"
+
+// BudgetScopeGlobal is the scope of the budget covering all models
+const BudgetScopeGlobal = "global"
+
+// budgetThresholds are the percentages of a daily budget that raise an alert
+var budgetThresholds = []int{50, 80, 100}
+
+var budgetAlerts = metrics.NewCounter(
+	"cursor2api_budget_alerts_total",
+	"Daily budget alerts raised, by scope (global or model) and threshold percentage.",
+	"scope", "threshold")
+
+// BudgetAlert is logged and posted to the budget alert webhook when the daily spend
+// of a scope crosses a threshold
+type BudgetAlert struct {
+	Timestamp time.Time `json:"timestamp"`
+	Day       string    `json:"day"`
+	Scope     string    `json:"scope"` // "global" or the model ID
+	Threshold int       `json:"threshold_percent"`
+	SpentUSD  float64   `json:"spent_usd"`
+	BudgetUSD float64   `json:"budget_usd"`
+}
+
+// BudgetStatus is today's spend against one daily budget
+type BudgetStatus struct {
+	Scope     string  `json:"scope"`
+	Day       string  `json:"day"`
+	SpentUSD  float64 `json:"spent_usd"`
+	BudgetUSD float64 `json:"budget_usd"`
+	Percent   float64 `json:"percent"`
+	Alerted   int     `json:"alerted_percent,omitempty"` // Highest threshold alerted today
+}
+
+// budgetMonitor tracks daily spend against the global and per-model budgets and raises
+// each threshold alert once per day. Budgets only alert, they never reject requests;
+// daily spend is kept in memory and starts over after a restart.
+type budgetMonitor struct {
+	budgets    map[string]float64 // scope -> daily USD budget
+	webhookURL string
+	secret     string
+	client     *http.Client
+	clock      clock.Clock // Selects the current day of snapshots
+
+	mu      sync.Mutex
+	day     string
+	spent   map[string]float64 // scope -> spend of day
+	alerted map[string]int     // scope -> highest threshold alerted on day
+}
+
+// newBudgetMonitor creates the monitor; returns nil when no daily budget is configured
+func newBudgetMonitor(cfg config.UsageConfig) *budgetMonitor {
+	budgets := make(map[string]float64, len(cfg.DailyModelBudgets)+1)
+	if cfg.DailyBudget > 0 {
+		budgets[BudgetScopeGlobal] = cfg.DailyBudget
+	}
+	for model, value := range cfg.DailyModelBudgets {
+		budget, err := strconv.ParseFloat(value, 64)
+		if err != nil || budget <= 0 {
+			logger.Warn("Invalid daily model budget, ignoring | model=%s value=%s", model, value)
+			continue
+		}
+		budgets[model] = budget
+	}
+	if len(budgets) == 0 {
+		return nil
+	}
+
+	logger.Info("Daily budgets enabled | global_usd=%g budgets=%d webhook=%v", cfg.DailyBudget, len(budgets), cfg.BudgetAlertWebhookURL != "")
+	return &budgetMonitor{
+		budgets:    budgets,
+		webhookURL: cfg.BudgetAlertWebhookURL,
+		secret:     cfg.BillingWebhookSecret,
+		client:     &http.Client{Timeout: 30 * time.Second},
+		spent:      make(map[string]float64),
+		alerted:    make(map[string]int),
+		clock:      clock.System,
+	}
+}
+
+// add accounts the cost of a request and delivers the alerts it triggers
+func (m *budgetMonitor) add(at time.Time, model string, cost float64) {
+	if m == nil || cost <= 0 {
+		return
+	}
+	for _, alert := range m.record(at, model, cost) {
+		m.deliver(alert)
+	}
+}
+
+// record accounts the cost and returns the thresholds crossed by it
+func (m *budgetMonitor) record(at time.Time, model string, cost float64) []BudgetAlert {
+	day := at.UTC().Format(dayFormat)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case day > m.day:
+		m.day = day
+		clear(m.spent)
+		clear(m.alerted)
+	case day < m.day:
+		return nil // Late record from a closed day
+	}
+
+	var alerts []BudgetAlert
+	for _, scope := range []string{BudgetScopeGlobal, model} {
+		budget, ok := m.budgets[scope]
+		if !ok {
+			continue
+		}
+		m.spent[scope] += cost
+		spent := m.spent[scope]
+
+		crossed := 0
+		for _, threshold := range budgetThresholds {
+			if spent >= budget*float64(threshold)/100 {
+				crossed = threshold
+			}
+		}
+		if crossed > m.alerted[scope] {
+			m.alerted[scope] = crossed
+			alerts = append(alerts, BudgetAlert{
+				Timestamp: at.UTC(),
+				Day:       day,
+				Scope:     scope,
+				Threshold: crossed,
+				SpentUSD:  spent,
+				BudgetUSD: budget,
+			})
+		}
+	}
+	return alerts
+}
+
+// deliver logs an alert and posts it to the webhook in the background
+func (m *budgetMonitor) deliver(alert BudgetAlert) {
+	budgetAlerts.Inc(alert.Scope, strconv.Itoa(alert.Threshold))
+	logger.Warn("Daily budget threshold reached | scope=%s threshold=%d%% spent_usd=%.4f budget_usd=%g day=%s",
+		alert.Scope, alert.Threshold, alert.SpentUSD, alert.BudgetUSD, alert.Day)
+
+	if m.webhookURL == "" {
+		return
+	}
+	go func() {
+		if err := postJSON(m.client, m.webhookURL, m.secret, alert); err != nil {
+			logger.Error("Failed to post budget alert webhook | scope=%s threshold=%d error=%v", alert.Scope, alert.Threshold, err)
+		}
+	}()
+}
+
+// snapshot returns today's spend against every configured budget, global first
+func (m *budgetMonitor) snapshot() []BudgetStatus {
+	if m == nil {
+		return nil
+	}
+	day := m.clock.Now().UTC().Format(dayFormat)
+
+	m.mu.Lock()
+	list := make([]BudgetStatus, 0, len(m.budgets))
+	for scope, budget := range m.budgets {
+		status := BudgetStatus{Scope: scope, Day: day, BudgetUSD: budget}
+		if m.day == day {
+			status.SpentUSD = m.spent[scope]
+			status.Alerted = m.alerted[scope]
+		}
+		status.Percent = status.SpentUSD / budget * 100
+		list = append(list, status)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if (list[i].Scope == BudgetScopeGlobal) != (list[j].Scope == BudgetScopeGlobal) {
+			return list[i].Scope == BudgetScopeGlobal
+		}
+		return list[i].Scope < list[j].Scope
+	})
+	return list
+}
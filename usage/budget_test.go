@@ -0,0 +1,85 @@
+package usage
+
+import (
+	"testing"
+	"time"
+
+	"cursor2api/clock"
+	"cursor2api/config"
+)
+
+func TestBudgetMonitor_Thresholds(t *testing.T) {
+	m := newBudgetMonitor(config.UsageConfig{
+		DailyBudget:       10,
+		DailyModelBudgets: map[string]string{"openai/gpt-5": "2", "bad": "x"},
+	})
+	if m == nil || len(m.budgets) != 2 {
+		t.Fatalf("budgets = %v, want global and openai/gpt-5", m)
+	}
+	day := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	alerts := m.record(day, "openai/gpt-5", 1)
+	if len(alerts) != 1 || alerts[0].Scope != "openai/gpt-5" || alerts[0].Threshold != 50 {
+		t.Fatalf("first alerts = %+v", alerts)
+	}
+	if alerts := m.record(day, "openai/gpt-5", 0.1); len(alerts) != 0 {
+		t.Errorf("no new threshold crossed, got %+v", alerts)
+	}
+
+	// One request crossing several thresholds raises only the highest
+	alerts = m.record(day, "openai/gpt-5", 4)
+	if len(alerts) != 2 || alerts[0].Scope != BudgetScopeGlobal || alerts[0].Threshold != 50 || alerts[1].Threshold != 100 {
+		t.Fatalf("alerts = %+v", alerts)
+	}
+	if alerts := m.record(day, "openai/gpt-5", 5); len(alerts) != 1 || alerts[0].Threshold != 100 || alerts[0].Scope != BudgetScopeGlobal {
+		t.Errorf("alerts past 100%% = %+v", alerts)
+	}
+	if alerts := m.record(day, "openai/gpt-5", 5); len(alerts) != 0 {
+		t.Errorf("thresholds must alert once per day, got %+v", alerts)
+	}
+
+	// Late records from a closed day are ignored and a new day starts over
+	next := day.Add(24 * time.Hour)
+	if alerts := m.record(next, "anthropic/claude-4.5-sonnet", 5); len(alerts) != 1 || alerts[0].Threshold != 50 || alerts[0].Day != "2025-03-02" {
+		t.Errorf("new day alerts = %+v", alerts)
+	}
+	if alerts := m.record(day, "openai/gpt-5", 100); alerts != nil {
+		t.Errorf("late record alerted: %+v", alerts)
+	}
+}
+
+func TestBudgetMonitor_Disabled(t *testing.T) {
+	m := newBudgetMonitor(config.UsageConfig{DailyModelBudgets: map[string]string{"openai/gpt-5": "0"}})
+	if m != nil {
+		t.Fatal("monitor should be nil without a valid budget")
+	}
+	m.add(time.Now(), "openai/gpt-5", 1)
+	if m.snapshot() != nil {
+		t.Error("nil monitor snapshot should be nil")
+	}
+}
+
+func TestRecorder_BudgetSnapshot(t *testing.T) {
+	r := NewRecorder(config.UsageConfig{DailyModelBudgets: map[string]string{"openai/gpt-5": "1"}}, config.StorageConfig{})
+	r.Record(Record{Model: "openai/gpt-5", PromptTokens: 400_000})
+
+	budgets := r.BudgetSnapshot()
+	if len(budgets) != 1 || budgets[0].SpentUSD != 0.5 || budgets[0].Percent != 50 || budgets[0].Alerted != 50 {
+		t.Errorf("budgets = %+v", budgets)
+	}
+}
+
+func TestRecorder_SpendCapResetsNextMonth(t *testing.T) {
+	r := NewRecorder(config.UsageConfig{MonthlySpendLimit: 0.5}, config.StorageConfig{})
+	fake := clock.NewFake(time.Date(2025, 3, 31, 23, 0, 0, 0, time.UTC))
+	r.clock = fake
+	r.Record(Record{APIKey: "sk-a", Model: "openai/gpt-5", PromptTokens: 400_000})
+	if spent, _, ok := r.CheckSpend("sk-a"); ok || spent != 0.5 {
+		t.Fatalf("spent = %v, ok = %v, want the cap reached", spent, ok)
+	}
+
+	fake.Advance(2 * time.Hour)
+	if spent, _, ok := r.CheckSpend("sk-a"); !ok || spent != 0 {
+		t.Errorf("spent = %v, ok = %v, want a fresh month", spent, ok)
+	}
+}
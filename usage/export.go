@@ -0,0 +1,179 @@
+package usage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"cursor2api/config"
+	"cursor2api/logger"
+)
+
+// Report is the payload posted to the billing webhook
+type Report struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Usage       []Summary `json:"usage"`
+	Spend       []Spend   `json:"spend"`
+}
+
+// Exporter periodically persists spend state and exports usage/spend summaries
+// to a billing webhook and/or a CSV file.
+type Exporter struct {
+	recorder   *Recorder
+	interval   time.Duration
+	webhookURL string
+	secret     string
+	csvPath    string
+	client     *http.Client
+	stopChan   chan struct{}
+	stopOnce   sync.Once
+	wg         sync.WaitGroup
+}
+
+// NewExporter creates and starts the export job; returns nil when there is nothing to export
+func NewExporter(cfg config.UsageConfig, recorder *Recorder) *Exporter {
+	if cfg.BillingWebhookURL == "" && cfg.ExportCSVPath == "" && cfg.StateFile == "" {
+		return nil
+	}
+
+	e := &Exporter{
+		recorder:   recorder,
+		interval:   cfg.ExportInterval,
+		webhookURL: cfg.BillingWebhookURL,
+		secret:     cfg.BillingWebhookSecret,
+		csvPath:    cfg.ExportCSVPath,
+		client:     &http.Client{Timeout: 30 * time.Second},
+		stopChan:   make(chan struct{}),
+	}
+	e.wg.Add(1)
+	go e.loop()
+
+	logger.Info("Usage export started | interval=%v webhook=%v csv=%s state_file=%s",
+		e.interval, e.webhookURL != "", e.csvPath, cfg.StateFile)
+	return e
+}
+
+// Stop runs a final export and stops the background job
+func (e *Exporter) Stop() {
+	if e == nil {
+		return
+	}
+	e.stopOnce.Do(func() {
+		close(e.stopChan)
+		e.wg.Wait()
+	})
+}
+
+// loop exports on every tick and once more on shutdown
+func (e *Exporter) loop() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.Export()
+		case <-e.stopChan:
+			e.Export()
+			return
+		}
+	}
+}
+
+// Export persists spend state and delivers the current report to every configured sink
+func (e *Exporter) Export() {
+	if err := e.recorder.Save(); err != nil {
+		logger.Error("Failed to save usage state | error=%v", err)
+	}
+
+	report := Report{
+		GeneratedAt: e.recorder.clock.Now().UTC(),
+		Usage:       e.recorder.Snapshot(),
+		Spend:       e.recorder.SpendSnapshot(),
+	}
+
+	if e.webhookURL != "" {
+		if err := e.postWebhook(report); err != nil {
+			logger.Error("Failed to post billing webhook | error=%v", err)
+		} else {
+			logger.Debug("Billing webhook delivered | usage_rows=%d spend_rows=%d", len(report.Usage), len(report.Spend))
+		}
+	}
+
+	if e.csvPath != "" {
+		if err := writeCSV(e.csvPath, report.Usage); err != nil {
+			logger.Error("Failed to write usage CSV | path=%s error=%v", e.csvPath, err)
+		}
+	}
+}
+
+// postWebhook sends the report to the billing webhook
+func (e *Exporter) postWebhook(report Report) error {
+	return postJSON(e.client, e.webhookURL, e.secret, report)
+}
+
+// postJSON posts v as JSON, signed with HMAC-SHA256 when a secret is configured
+func postJSON(client *http.Client, url, secret string, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(payload)
+		req.Header.Set("X-Signature-SHA256", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// writeCSV writes the usage summaries to path, replacing the previous export
+func writeCSV(path string, summaries []Summary) error {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"month", "key", "model", "dimensions", "requests", "prompt_tokens", "completion_tokens", "cost_usd", "tenant", "client"})
+	for _, s := range summaries {
+		_ = w.Write([]string{
+			s.Month,
+			s.MaskedKey,
+			s.Model,
+			FormatDimensions(s.Dimensions),
+			strconv.FormatInt(s.Requests, 10),
+			strconv.FormatInt(s.PromptTokens, 10),
+			strconv.FormatInt(s.CompletionTokens, 10),
+			strconv.FormatFloat(s.CostUSD, 'f', 6, 64),
+			s.Tenant,
+			s.Client,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
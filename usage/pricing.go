@@ -0,0 +1,56 @@
+package usage
+
+import (
+	"strconv"
+	"strings"
+
+	"cursor2api/logger"
+)
+
+// Price is the cost of a model in USD per million tokens
+type Price struct {
+	InputPerMTok  float64 `json:"input_per_mtok"`
+	OutputPerMTok float64 `json:"output_per_mtok"`
+}
+
+// Pricing maps model IDs to prices
+type Pricing map[string]Price
+
+// defaultPricing is the built-in list price table for the advertised models
+var defaultPricing = Pricing{
+	"anthropic/claude-4.5-sonnet": {InputPerMTok: 3, OutputPerMTok: 15},
+	"anthropic/claude-4-sonnet":   {InputPerMTok: 3, OutputPerMTok: 15},
+	"anthropic/claude-opus-4.1":   {InputPerMTok: 15, OutputPerMTok: 75},
+	"openai/gpt-5":                {InputPerMTok: 1.25, OutputPerMTok: 10},
+	"google/gemini-2.5-pro":       {InputPerMTok: 1.25, OutputPerMTok: 10},
+	"xai/grok-4":                  {InputPerMTok: 3, OutputPerMTok: 15},
+}
+
+// NewPricing returns the built-in table with overrides applied
+// Overrides map a model ID to "input:output" USD per million tokens; invalid entries are skipped.
+func NewPricing(overrides map[string]string) Pricing {
+	pricing := make(Pricing, len(defaultPricing)+len(overrides))
+	for model, price := range defaultPricing {
+		pricing[model] = price
+	}
+	for model, value := range overrides {
+		input, output, ok := strings.Cut(value, ":")
+		inputPrice, inErr := strconv.ParseFloat(strings.TrimSpace(input), 64)
+		outputPrice, outErr := strconv.ParseFloat(strings.TrimSpace(output), 64)
+		if !ok || inErr != nil || outErr != nil {
+			logger.Warn("Invalid model price, expected input:output | model=%s value=%s", model, value)
+			continue
+		}
+		pricing[model] = Price{InputPerMTok: inputPrice, OutputPerMTok: outputPrice}
+	}
+	return pricing
+}
+
+// Cost returns the USD cost of a request; unknown models cost nothing
+func (p Pricing) Cost(model string, promptTokens, completionTokens int) float64 {
+	price, ok := p[model]
+	if !ok {
+		return 0
+	}
+	return (float64(promptTokens)*price.InputPerMTok + float64(completionTokens)*price.OutputPerMTok) / 1e6
+}
@@ -0,0 +1,177 @@
+package usage
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"cursor2api/config"
+	"cursor2api/logger"
+	"cursor2api/metrics"
+)
+
+// Size metrics checked for anomalies
+const (
+	SizeRequestBytes     = "request_bytes"
+	SizePromptTokens     = "prompt_tokens"
+	SizeCompletionTokens = "completion_tokens"
+)
+
+var sizeMetrics = [...]string{SizeRequestBytes, SizePromptTokens, SizeCompletionTokens}
+
+// sizeBaselineWeight is the weight of a new request in the moving average baseline
+const sizeBaselineWeight = 0.1
+
+var (
+	requestBytes = metrics.NewHistogram(
+		"cursor2api_request_body_bytes",
+		"Size of chat completion request bodies, by masked API key and model.",
+		[]float64{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20},
+		"key", "model")
+
+	promptTokens = metrics.NewHistogram(
+		"cursor2api_request_prompt_tokens",
+		"Prompt tokens per request, by masked API key and model.",
+		[]float64{100, 500, 1000, 5000, 10000, 50000, 100000, 200000, 500000},
+		"key", "model")
+
+	completionTokens = metrics.NewHistogram(
+		"cursor2api_request_completion_tokens",
+		"Completion tokens per request, by masked API key and model.",
+		[]float64{10, 50, 100, 500, 1000, 2000, 4000, 8000, 16000, 32000},
+		"key", "model")
+
+	sizeAnomalies = metrics.NewCounter(
+		"cursor2api_size_anomalies_total",
+		"Requests far larger than the moving average of their key and model, by metric.",
+		"metric")
+)
+
+// SizeAnomaly is logged and posted to the size alert webhook when a request is
+// Factor times larger than the moving average of its key and model, which often
+// means an agent is accumulating context without bound
+type SizeAnomaly struct {
+	Timestamp time.Time `json:"timestamp"`
+	Key       string    `json:"key"` // Masked API key
+	Tenant    string    `json:"tenant,omitempty"`
+	Model     string    `json:"model"`
+	Metric    string    `json:"metric"` // request_bytes, prompt_tokens or completion_tokens
+	Value     float64   `json:"value"`
+	Baseline  float64   `json:"baseline"` // Moving average before this request
+	Factor    float64   `json:"factor"`   // Value / Baseline
+}
+
+// sizeMonitor keeps a moving average of every size metric per key and model and
+// raises an alert when a request exceeds it by the configured factor. Baselines
+// are kept in memory and learnt again after a restart.
+type sizeMonitor struct {
+	factor     float64
+	minSamples int
+	cooldown   time.Duration
+	webhookURL string
+	secret     string
+	client     *http.Client
+
+	mu        sync.Mutex
+	baselines map[string]*sizeBaseline // masked key|model -> baseline
+}
+
+type sizeBaseline struct {
+	samples   [len(sizeMetrics)]int
+	mean      [len(sizeMetrics)]float64
+	lastAlert [len(sizeMetrics)]time.Time
+}
+
+// newSizeMonitor creates the monitor; returns nil when anomaly alerts are disabled
+func newSizeMonitor(cfg config.UsageConfig) *sizeMonitor {
+	if cfg.SizeAnomalyFactor <= 0 {
+		return nil
+	}
+	logger.Info("Size anomaly alerts enabled | factor=%g min_samples=%d cooldown=%v webhook=%v",
+		cfg.SizeAnomalyFactor, cfg.SizeAnomalyMinSamples, cfg.SizeAnomalyCooldown, cfg.SizeAlertWebhookURL != "")
+	return &sizeMonitor{
+		factor:     cfg.SizeAnomalyFactor,
+		minSamples: cfg.SizeAnomalyMinSamples,
+		cooldown:   cfg.SizeAnomalyCooldown,
+		webhookURL: cfg.SizeAlertWebhookURL,
+		secret:     cfg.BillingWebhookSecret,
+		client:     &http.Client{Timeout: 30 * time.Second},
+		baselines:  make(map[string]*sizeBaseline),
+	}
+}
+
+// observe records the size histograms of a request and checks it for anomalies
+func (m *sizeMonitor) observe(rec Record) {
+	values := [len(sizeMetrics)]float64{float64(rec.RequestBytes), float64(rec.PromptTokens), float64(rec.CompletionTokens)}
+	if rec.RequestBytes > 0 {
+		requestBytes.Observe(values[0], rec.MaskedKey, rec.Model)
+	}
+	promptTokens.Observe(values[1], rec.MaskedKey, rec.Model)
+	completionTokens.Observe(values[2], rec.MaskedKey, rec.Model)
+
+	if m == nil {
+		return
+	}
+	for _, anomaly := range m.record(rec, values) {
+		m.deliver(anomaly)
+	}
+}
+
+// record updates the baselines and returns the anomalies of the request
+func (m *sizeMonitor) record(rec Record, values [len(sizeMetrics)]float64) []SizeAnomaly {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := rec.MaskedKey + "|" + rec.Model
+	baseline, ok := m.baselines[key]
+	if !ok {
+		baseline = &sizeBaseline{}
+		m.baselines[key] = baseline
+	}
+
+	var anomalies []SizeAnomaly
+	for i, value := range values {
+		if value <= 0 {
+			continue // Not measured (e.g. no Content-Length)
+		}
+		mean := baseline.mean[i]
+		if baseline.samples[i] >= m.minSamples && mean > 0 && value >= mean*m.factor &&
+			rec.Timestamp.Sub(baseline.lastAlert[i]) >= m.cooldown {
+			baseline.lastAlert[i] = rec.Timestamp
+			anomalies = append(anomalies, SizeAnomaly{
+				Timestamp: rec.Timestamp.UTC(),
+				Key:       rec.MaskedKey,
+				Tenant:    rec.Tenant,
+				Model:     rec.Model,
+				Metric:    sizeMetrics[i],
+				Value:     value,
+				Baseline:  mean,
+				Factor:    value / mean,
+			})
+		}
+
+		// The first sample seeds the baseline, later ones move it
+		if baseline.samples[i] == 0 {
+			baseline.mean[i] = value
+		} else {
+			baseline.mean[i] += sizeBaselineWeight * (value - mean)
+		}
+		baseline.samples[i]++
+	}
+	return anomalies
+}
+
+// deliver logs an anomaly and posts it to the webhook in the background
+func (m *sizeMonitor) deliver(anomaly SizeAnomaly) {
+	sizeAnomalies.Inc(anomaly.Metric)
+	logger.Warn("Request size anomaly | key=%s tenant=%s model=%s metric=%s value=%.0f baseline=%.0f factor=%.1f",
+		anomaly.Key, anomaly.Tenant, anomaly.Model, anomaly.Metric, anomaly.Value, anomaly.Baseline, anomaly.Factor)
+
+	if m.webhookURL == "" {
+		return
+	}
+	go func() {
+		if err := postJSON(m.client, m.webhookURL, m.secret, anomaly); err != nil {
+			logger.Error("Failed to post size anomaly webhook | key=%s metric=%s error=%v", anomaly.Key, anomaly.Metric, err)
+		}
+	}()
+}
@@ -0,0 +1,56 @@
+package usage
+
+import (
+	"testing"
+	"time"
+
+	"cursor2api/config"
+)
+
+func TestSizeMonitor_Anomalies(t *testing.T) {
+	m := newSizeMonitor(config.UsageConfig{SizeAnomalyFactor: 10, SizeAnomalyMinSamples: 3, SizeAnomalyCooldown: time.Hour})
+	at := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	request := func(prompt int) []SizeAnomaly {
+		at = at.Add(time.Minute)
+		rec := Record{Timestamp: at, MaskedKey: "sk-a...1234", Model: "openai/gpt-5", PromptTokens: prompt, CompletionTokens: 100}
+		return m.record(rec, [len(sizeMetrics)]float64{0, float64(prompt), 100})
+	}
+
+	// A large request before the baseline is trusted does not alert
+	for _, prompt := range []int{1000, 1000, 20000} {
+		if alerts := request(prompt); len(alerts) != 0 {
+			t.Fatalf("alert during warm-up: %+v", alerts)
+		}
+	}
+	baseline := m.baselines["sk-a...1234|openai/gpt-5"].mean[1]
+	if alerts := request(1000); len(alerts) != 0 {
+		t.Fatalf("normal request alerted: %+v", alerts)
+	}
+
+	alerts := request(int(baseline * 12))
+	if len(alerts) != 1 || alerts[0].Metric != SizePromptTokens || alerts[0].Factor < 10 {
+		t.Fatalf("alerts = %+v", alerts)
+	}
+	// Alerts of a key, model and metric are rate limited by the cooldown
+	if alerts := request(int(baseline * 100)); len(alerts) != 0 {
+		t.Errorf("alert within cooldown: %+v", alerts)
+	}
+	at = at.Add(time.Hour)
+	baseline = m.baselines["sk-a...1234|openai/gpt-5"].mean[1] // Moved up by the large requests
+	if alerts := request(int(baseline * 11)); len(alerts) != 1 {
+		t.Errorf("alert after cooldown = %+v", alerts)
+	}
+
+	// Unmeasured values (no Content-Length) never count
+	if b := m.baselines["sk-a...1234|openai/gpt-5"]; b.samples[0] != 0 {
+		t.Errorf("request_bytes samples = %d, want 0", b.samples[0])
+	}
+}
+
+func TestSizeMonitor_Disabled(t *testing.T) {
+	m := newSizeMonitor(config.UsageConfig{})
+	if m != nil {
+		t.Fatal("monitor should be nil with a zero factor")
+	}
+	m.observe(Record{Model: "openai/gpt-5", PromptTokens: 10})
+}
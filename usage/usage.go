@@ -0,0 +1,348 @@
+// Package usage aggregates per-request token usage for accounting and attribution.
+package usage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cursor2api/clock"
+	"cursor2api/codec"
+	"cursor2api/config"
+	"cursor2api/logger"
+)
+
+// monthFormat is the layout of billing periods (UTC calendar months)
+const monthFormat = "2006-01"
+
+// Record is the usage of a single completed request
+type Record struct {
+	Timestamp        time.Time
+	APIKey           string // Raw key, never logged; use MaskedKey for output
+	MaskedKey        string
+	Tenant           string
+	Client           string // Client SDK detected from the User-Agent (openai-python, curl, ...)
+	Model            string
+	Stream           bool
+	RequestBytes     int // Size of the request body (0 = unknown)
+	PromptTokens     int
+	CompletionTokens int
+	Dimensions       map[string]string // Selected request metadata (e.g. team, feature)
+}
+
+// Summary is the aggregated usage for one month/key/model/dimension group
+type Summary struct {
+	Month            string            `json:"month"`
+	MaskedKey        string            `json:"key"`
+	Tenant           string            `json:"tenant,omitempty"`
+	Client           string            `json:"client,omitempty"`
+	Model            string            `json:"model"`
+	Dimensions       map[string]string `json:"dimensions,omitempty"`
+	Requests         int64             `json:"requests"`
+	PromptTokens     int64             `json:"prompt_tokens"`
+	CompletionTokens int64             `json:"completion_tokens"`
+	CostUSD          float64           `json:"cost_usd"`
+	LastUsed         time.Time         `json:"last_used"`
+}
+
+// Spend is the accumulated cost of one key in one month
+type Spend struct {
+	KeyID     string  `json:"key_id"` // Truncated SHA-256 of the raw key, safe to persist
+	MaskedKey string  `json:"key"`
+	Tenant    string  `json:"tenant,omitempty"`
+	Month     string  `json:"month"`
+	CostUSD   float64 `json:"cost_usd"`
+	LimitUSD  float64 `json:"limit_usd,omitempty"`
+}
+
+// Recorder accumulates usage records in memory and enforces monthly spend caps
+type Recorder struct {
+	mu            sync.RWMutex
+	dimensionKeys []string
+	pricing       Pricing
+	defaultLimit  float64
+	limits        map[string]float64 // key ID -> monthly USD cap
+	stateFile     string
+	compression   string // Codec of the state file; reads detect any codec
+	groups        map[string]*Summary
+	spend         map[string]*Spend // month|key ID -> spend
+	budget        *budgetMonitor    // nil when no daily budget is configured
+	sizes         *sizeMonitor      // nil when size anomaly alerts are disabled
+	clock         clock.Clock       // Timestamps records and selects the current spend month
+
+	storageMu  sync.Mutex
+	storageErr error // Last failure to load or save the state file, cleared by a successful save
+}
+
+// NewRecorder creates a usage recorder from configuration and restores persisted spend
+func NewRecorder(cfg config.UsageConfig, storage config.StorageConfig) *Recorder {
+	r := &Recorder{
+		dimensionKeys: cfg.DimensionKeys,
+		pricing:       NewPricing(cfg.Pricing),
+		defaultLimit:  cfg.MonthlySpendLimit,
+		limits:        make(map[string]float64, len(cfg.SpendLimits)),
+		stateFile:     cfg.StateFile,
+		compression:   storage.Compression,
+		groups:        make(map[string]*Summary),
+		spend:         make(map[string]*Spend),
+		budget:        newBudgetMonitor(cfg),
+		sizes:         newSizeMonitor(cfg),
+		clock:         clock.System,
+	}
+
+	for key, value := range cfg.SpendLimits {
+		limit, err := strconv.ParseFloat(value, 64)
+		if err != nil || limit < 0 {
+			logger.Warn("Invalid spend limit, ignoring | key_id=%s value=%s", keyID(key), value)
+			continue
+		}
+		r.limits[keyID(key)] = limit
+	}
+
+	if r.stateFile != "" {
+		if err := r.load(); err != nil {
+			logger.Warn("Failed to restore usage state | file=%s error=%v", r.stateFile, err)
+			r.setStorageErr(err)
+		}
+	}
+	return r
+}
+
+// Dimensions selects the configured dimension keys from request metadata
+func (r *Recorder) Dimensions(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 || len(r.dimensionKeys) == 0 {
+		return nil
+	}
+	dims := make(map[string]string, len(r.dimensionKeys))
+	for _, key := range r.dimensionKeys {
+		if value, ok := metadata[key]; ok && value != "" {
+			dims[key] = value
+		}
+	}
+	if len(dims) == 0 {
+		return nil
+	}
+	return dims
+}
+
+// Record adds a usage record to the aggregates
+func (r *Recorder) Record(rec Record) {
+	if rec.Timestamp.IsZero() {
+		rec.Timestamp = r.clock.Now()
+	}
+	month := rec.Timestamp.UTC().Format(monthFormat)
+	groupKey := month + "|" + rec.Tenant + "|" + rec.APIKey + "|" + rec.Client + "|" + rec.Model + "|" + FormatDimensions(rec.Dimensions)
+	cost := r.pricing.Cost(rec.Model, rec.PromptTokens, rec.CompletionTokens)
+
+	r.mu.Lock()
+	summary, ok := r.groups[groupKey]
+	if !ok {
+		summary = &Summary{
+			Month:      month,
+			MaskedKey:  rec.MaskedKey,
+			Tenant:     rec.Tenant,
+			Client:     rec.Client,
+			Model:      rec.Model,
+			Dimensions: rec.Dimensions,
+		}
+		r.groups[groupKey] = summary
+	}
+	summary.Requests++
+	summary.PromptTokens += int64(rec.PromptTokens)
+	summary.CompletionTokens += int64(rec.CompletionTokens)
+	summary.CostUSD += cost
+	summary.LastUsed = rec.Timestamp
+
+	if rec.APIKey != "" {
+		id := keyID(rec.APIKey)
+		spend, ok := r.spend[month+"|"+id]
+		if !ok {
+			spend = &Spend{KeyID: id, MaskedKey: rec.MaskedKey, Tenant: rec.Tenant, Month: month}
+			r.spend[month+"|"+id] = spend
+		}
+		spend.CostUSD += cost
+	}
+	r.mu.Unlock()
+
+	r.budget.add(rec.Timestamp, rec.Model, cost)
+	r.sizes.observe(rec)
+
+	logger.Debug("Usage recorded | key=%s tenant=%s client=%s model=%s stream=%v prompt_tokens=%d completion_tokens=%d cost_usd=%.6f dimensions=%s",
+		rec.MaskedKey, rec.Tenant, rec.Client, rec.Model, rec.Stream, rec.PromptTokens, rec.CompletionTokens, cost, FormatDimensions(rec.Dimensions))
+}
+
+// CheckSpend reports the current month's spend and cap for a key; ok is false once the cap is reached
+func (r *Recorder) CheckSpend(apiKey string) (spent, limit float64, ok bool) {
+	if apiKey == "" {
+		return 0, 0, true
+	}
+	id := keyID(apiKey)
+	limit = r.limitFor(id)
+
+	r.mu.RLock()
+	if spend, exists := r.spend[r.clock.Now().UTC().Format(monthFormat)+"|"+id]; exists {
+		spent = spend.CostUSD
+	}
+	r.mu.RUnlock()
+
+	return spent, limit, limit <= 0 || spent < limit
+}
+
+// SpendSnapshot returns a copy of all per-key monthly spend sorted by month and key
+func (r *Recorder) SpendSnapshot() []Spend {
+	r.mu.RLock()
+	list := make([]Spend, 0, len(r.spend))
+	for _, spend := range r.spend {
+		list = append(list, *spend)
+	}
+	r.mu.RUnlock()
+
+	for i := range list {
+		list[i].LimitUSD = r.limitFor(list[i].KeyID)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Month != list[j].Month {
+			return list[i].Month < list[j].Month
+		}
+		return list[i].MaskedKey < list[j].MaskedKey
+	})
+	return list
+}
+
+// BudgetSnapshot returns today's spend against the configured daily budgets
+func (r *Recorder) BudgetSnapshot() []BudgetStatus {
+	return r.budget.snapshot()
+}
+
+// Save persists per-key monthly spend to the state file so caps survive restarts
+func (r *Recorder) Save() (err error) {
+	if r.stateFile == "" {
+		return nil
+	}
+	defer func() { r.setStorageErr(err) }()
+
+	data, err := json.MarshalIndent(r.SpendSnapshot(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage state: %w", err)
+	}
+	if data, err = codec.Encode(data, r.compression, codec.Best); err != nil {
+		return fmt.Errorf("failed to compress usage state: %w", err)
+	}
+	return writeFileAtomic(r.stateFile, data)
+}
+
+// StorageError returns the last failure to load or save the state file (nil when healthy)
+func (r *Recorder) StorageError() error {
+	r.storageMu.Lock()
+	defer r.storageMu.Unlock()
+	return r.storageErr
+}
+
+func (r *Recorder) setStorageErr(err error) {
+	r.storageMu.Lock()
+	defer r.storageMu.Unlock()
+	r.storageErr = err
+}
+
+// load restores per-key monthly spend from the state file
+func (r *Recorder) load() error {
+	data, err := codec.ReadFile(r.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var list []Spend
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("failed to parse usage state: %w", err)
+	}
+	for i := range list {
+		spend := list[i]
+		spend.LimitUSD = 0
+		r.spend[spend.Month+"|"+spend.KeyID] = &spend
+	}
+	logger.Info("Usage state restored | file=%s entries=%d", r.stateFile, len(list))
+	return nil
+}
+
+// limitFor returns the monthly cap for a key ID (0 = unlimited)
+func (r *Recorder) limitFor(id string) float64 {
+	if limit, ok := r.limits[id]; ok {
+		return limit
+	}
+	return r.defaultLimit
+}
+
+// Snapshot returns a copy of all aggregates sorted by month, key, model and dimensions
+func (r *Recorder) Snapshot() []Summary {
+	r.mu.RLock()
+	list := make([]Summary, 0, len(r.groups))
+	for _, summary := range r.groups {
+		list = append(list, *summary)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Month != list[j].Month {
+			return list[i].Month < list[j].Month
+		}
+		if list[i].MaskedKey != list[j].MaskedKey {
+			return list[i].MaskedKey < list[j].MaskedKey
+		}
+		if list[i].Model != list[j].Model {
+			return list[i].Model < list[j].Model
+		}
+		if list[i].Client != list[j].Client {
+			return list[i].Client < list[j].Client
+		}
+		return FormatDimensions(list[i].Dimensions) < FormatDimensions(list[j].Dimensions)
+	})
+	return list
+}
+
+// FormatDimensions renders dimensions as sorted "k=v,k=v" for logs and grouping
+func FormatDimensions(dims map[string]string) string {
+	if len(dims) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(dims))
+	for k, v := range dims {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// keyID derives a stable, non-reversible identifier for an API key
+func keyID(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
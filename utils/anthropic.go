@@ -0,0 +1,356 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cursor2api/types"
+)
+
+// AnthropicToOpenAI converts a Messages API request into the chat completion
+// request served by the OpenAI-compatible pipeline. Tool results become tool
+// messages and tool_use blocks become assistant tool calls; thinking blocks of
+// earlier turns are dropped. Image and document blocks are rejected because the
+// upstream only accepts text.
+func AnthropicToOpenAI(req types.AnthropicRequest) (types.ChatCompletionRequest, error) {
+	out := types.ChatCompletionRequest{
+		Model:       req.Model,
+		Stream:      req.Stream,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		MaxTokens:   req.MaxTokens,
+		Stop:        req.StopSequences,
+	}
+	if req.Metadata != nil {
+		out.User = req.Metadata.UserID
+	}
+
+	if system := anthropicText(req.System); system != "" {
+		out.Messages = append(out.Messages, types.ChatMessage{Role: "system", Content: system})
+	}
+	for i, msg := range req.Messages {
+		messages, err := anthropicMessage(msg)
+		if err != nil {
+			return types.ChatCompletionRequest{}, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		out.Messages = append(out.Messages, messages...)
+	}
+
+	for _, tool := range req.Tools {
+		out.Tools = append(out.Tools, types.Tool{
+			Type: "function",
+			Function: types.FunctionDef{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+		})
+	}
+	if choice := req.ToolChoice; choice != nil {
+		switch choice.Type {
+		case "auto", "none":
+			out.ToolChoice = choice.Type
+		case "any":
+			out.ToolChoice = "required"
+		case "tool":
+			out.ToolChoice = map[string]interface{}{
+				"type":     "function",
+				"function": map[string]string{"name": choice.Name},
+			}
+		default:
+			return types.ChatCompletionRequest{}, fmt.Errorf("unknown tool_choice type %q", choice.Type)
+		}
+	}
+	return out, nil
+}
+
+// anthropicMessage converts one message; a user message carrying tool results
+// becomes one tool message per result followed by the remaining text
+func anthropicMessage(msg types.AnthropicMessage) ([]types.ChatMessage, error) {
+	if msg.Role != "user" && msg.Role != "assistant" {
+		return nil, fmt.Errorf("unknown role %q", msg.Role)
+	}
+
+	var (
+		out       []types.ChatMessage
+		text      []string
+		toolCalls []types.ToolCall
+	)
+	for _, block := range msg.Content {
+		switch block.Type {
+		case "text":
+			text = append(text, block.Text)
+		case "tool_use":
+			input := string(block.Input)
+			if input == "" {
+				input = "{}"
+			}
+			toolCalls = append(toolCalls, types.ToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: types.ToolCallFunction{Name: block.Name, Arguments: input},
+			})
+		case "tool_result":
+			content := anthropicText(block.Content)
+			if block.IsError {
+				content = "Error: " + content
+			}
+			out = append(out, types.ChatMessage{Role: "tool", ToolCallID: block.ToolUseID, Content: content})
+		case "thinking", "redacted_thinking":
+		default:
+			return nil, fmt.Errorf("content block type %q is not supported", block.Type)
+		}
+	}
+
+	if len(text) > 0 || len(toolCalls) > 0 {
+		out = append(out, types.ChatMessage{Role: msg.Role, Content: strings.Join(text, "\n\n"), ToolCalls: toolCalls})
+	}
+	return out, nil
+}
+
+// anthropicText joins the text blocks of a content list
+func anthropicText(content types.AnthropicContent) string {
+	var parts []string
+	for _, block := range content {
+		if block.Type == "text" && block.Text != "" {
+			parts = append(parts, block.Text)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// AnthropicMessageID derives the Messages API ID from a completion ID
+func AnthropicMessageID(completionID string) string {
+	return "msg_" + strings.TrimPrefix(completionID, "chatcmpl-")
+}
+
+// AnthropicStopReason maps an OpenAI finish_reason to a Messages API stop_reason
+func AnthropicStopReason(finishReason string) string {
+	switch finishReason {
+	case "tool_calls":
+		return "tool_use"
+	case "length":
+		return "max_tokens"
+	case "content_filter":
+		return "refusal"
+	}
+	return "end_turn"
+}
+
+// AnthropicErrorType maps an HTTP status to a Messages API error type
+func AnthropicErrorType(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case http.StatusServiceUnavailable, 529:
+		return "overloaded_error"
+	}
+	return "api_error"
+}
+
+// OpenAIToAnthropic converts a non-streaming chat completion into a Messages API response
+func OpenAIToAnthropic(resp types.ChatCompletionResponse) types.AnthropicResponse {
+	out := types.AnthropicResponse{
+		ID:      AnthropicMessageID(resp.ID),
+		Type:    "message",
+		Role:    "assistant",
+		Model:   resp.Model,
+		Content: types.AnthropicContent{},
+		Usage: types.AnthropicUsage{
+			InputTokens:  resp.Usage.PromptTokens,
+			OutputTokens: resp.Usage.CompletionTokens,
+		},
+	}
+	finishReason := ""
+	if len(resp.Choices) > 0 {
+		choice := resp.Choices[0]
+		finishReason = choice.FinishReason
+		if msg := choice.Message; msg != nil {
+			if msg.Content != "" {
+				out.Content = append(out.Content, types.AnthropicContentBlock{Type: "text", Text: msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				out.Content = append(out.Content, types.AnthropicContentBlock{
+					Type:  "tool_use",
+					ID:    call.ID,
+					Name:  call.Function.Name,
+					Input: toolInput(call.Function.Arguments),
+				})
+			}
+		}
+	}
+	stopReason := AnthropicStopReason(finishReason)
+	out.StopReason = &stopReason
+	return out
+}
+
+// toolInput returns the tool call arguments as the input object; arguments that
+// are not a JSON object are passed as {"arguments": "<raw>"}
+func toolInput(arguments string) json.RawMessage {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(arguments), &object); err == nil && object != nil {
+		return json.RawMessage(arguments)
+	}
+	if strings.TrimSpace(arguments) == "" {
+		return json.RawMessage("{}")
+	}
+	wrapped, _ := json.Marshal(map[string]string{"arguments": arguments})
+	return wrapped
+}
+
+// AnthropicStream translates the chunks of an OpenAI chat completion stream into
+// Messages API events: message_start, one content block per text run or tool
+// call, message_delta with the stop reason and usage, then message_stop.
+type AnthropicStream struct {
+	inputTokens int // Estimate sent in message_start, before the upstream reports usage
+
+	started    bool
+	done       bool
+	blocks     int         // Content blocks started so far
+	open       int         // Index of the open block (-1 = none)
+	openText   bool        // Whether the open block is a text block
+	toolBlocks map[int]int // OpenAI tool call index -> block index
+	stop       string
+	usage      *types.ChatCompletionUsage
+}
+
+// NewAnthropicStream creates the translator; inputTokens is reported in message_start
+func NewAnthropicStream(inputTokens int) *AnthropicStream {
+	return &AnthropicStream{inputTokens: inputTokens, open: -1, toolBlocks: make(map[int]int)}
+}
+
+// Done reports whether the stream has ended with message_stop or an error event
+func (s *AnthropicStream) Done() bool {
+	return s.done
+}
+
+// Chunk translates one stream chunk. An error chunk ends the stream with an error event.
+func (s *AnthropicStream) Chunk(chunk types.ChatCompletionStreamResponse) []types.AnthropicEvent {
+	if s.done {
+		return nil
+	}
+	var events []types.AnthropicEvent
+	if !s.started {
+		events = append(events, s.start(chunk.ID, chunk.Model))
+	}
+	if chunk.Usage != nil {
+		s.usage = chunk.Usage
+	}
+
+	for _, choice := range chunk.Choices {
+		if delta := choice.Delta; delta != nil {
+			if delta.Content != "" {
+				if s.open < 0 || !s.openText {
+					events = append(events, s.closeBlock()...)
+					events = append(events, s.startBlock(types.AnthropicContentBlock{Type: "text"}, true))
+				}
+				events = append(events, s.delta(s.open, types.AnthropicDelta{Type: "text_delta", Text: delta.Content}))
+			}
+			for _, call := range delta.ToolCalls {
+				index, ok := s.toolBlocks[call.StreamIndex()]
+				if !ok || call.ID != "" {
+					events = append(events, s.closeBlock()...)
+					events = append(events, s.startBlock(types.AnthropicContentBlock{
+						Type:  "tool_use",
+						ID:    call.ID,
+						Name:  call.Function.Name,
+						Input: json.RawMessage("{}"),
+					}, false))
+					index = s.open
+					s.toolBlocks[call.StreamIndex()] = index
+				}
+				if call.Function.Arguments != "" {
+					events = append(events, s.delta(index, types.AnthropicDelta{Type: "input_json_delta", PartialJSON: call.Function.Arguments}))
+				}
+			}
+		}
+		if choice.FinishReason != "" {
+			s.stop = choice.FinishReason
+		}
+	}
+
+	if chunk.Error != nil {
+		events = append(events, s.closeBlock()...)
+		events = append(events, s.Error(http.StatusInternalServerError, chunk.Error.Message)...)
+	}
+	return events
+}
+
+// Finish closes the open block and ends the stream with message_delta and message_stop
+func (s *AnthropicStream) Finish() []types.AnthropicEvent {
+	if s.done {
+		return nil
+	}
+	var events []types.AnthropicEvent
+	if !s.started {
+		events = append(events, s.start("", ""))
+	}
+	events = append(events, s.closeBlock()...)
+
+	stopReason := AnthropicStopReason(s.stop)
+	usage := types.AnthropicUsage{InputTokens: s.inputTokens}
+	if s.usage != nil {
+		usage = types.AnthropicUsage{InputTokens: s.usage.PromptTokens, OutputTokens: s.usage.CompletionTokens}
+	}
+	events = append(events,
+		types.AnthropicEvent{Type: "message_delta", Delta: &types.AnthropicDelta{StopReason: &stopReason}, Usage: &usage},
+		types.AnthropicEvent{Type: "message_stop"})
+	s.done = true
+	return events
+}
+
+// Error ends the stream with an error event typed after the HTTP status
+func (s *AnthropicStream) Error(status int, message string) []types.AnthropicEvent {
+	if s.done {
+		return nil
+	}
+	s.done = true
+	return []types.AnthropicEvent{{
+		Type:  "error",
+		Error: &types.AnthropicError{Type: AnthropicErrorType(status), Message: message},
+	}}
+}
+
+func (s *AnthropicStream) start(id, model string) types.AnthropicEvent {
+	s.started = true
+	return types.AnthropicEvent{Type: "message_start", Message: &types.AnthropicResponse{
+		ID:      AnthropicMessageID(id),
+		Type:    "message",
+		Role:    "assistant",
+		Model:   model,
+		Content: types.AnthropicContent{},
+		Usage:   types.AnthropicUsage{InputTokens: s.inputTokens},
+	}}
+}
+
+func (s *AnthropicStream) startBlock(block types.AnthropicContentBlock, text bool) types.AnthropicEvent {
+	index := s.blocks
+	s.blocks++
+	s.open, s.openText = index, text
+	return types.AnthropicEvent{Type: "content_block_start", Index: &index, ContentBlock: &block}
+}
+
+func (s *AnthropicStream) closeBlock() []types.AnthropicEvent {
+	if s.open < 0 {
+		return nil
+	}
+	index := s.open
+	s.open = -1
+	return []types.AnthropicEvent{{Type: "content_block_stop", Index: &index}}
+}
+
+func (s *AnthropicStream) delta(index int, delta types.AnthropicDelta) types.AnthropicEvent {
+	return types.AnthropicEvent{Type: "content_block_delta", Index: &index, Delta: &delta}
+}
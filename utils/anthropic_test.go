@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cursor2api/types"
+)
+
+func TestAnthropicToOpenAI(t *testing.T) {
+	var req types.AnthropicRequest
+	body := `{
+		"model": "anthropic/claude-4.5-sonnet",
+		"max_tokens": 1024,
+		"system": [{"type": "text", "text": "Be brief."}],
+		"stop_sequences": ["END"],
+		"metadata": {"user_id": "u1"},
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+		"tool_choice": {"type": "tool", "name": "get_weather"},
+		"messages": [
+			{"role": "user", "content": "Weather in Paris?"},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "..."},
+				{"type": "text", "text": "Checking."},
+				{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "18C"}]},
+				{"type": "text", "text": "Thanks"}
+			]}
+		]
+	}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := AnthropicToOpenAI(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []types.ChatMessage{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "Weather in Paris?"},
+		{Role: "assistant", Content: "Checking.", ToolCalls: []types.ToolCall{
+			{ID: "toolu_1", Type: "function", Function: types.ToolCallFunction{Name: "get_weather", Arguments: `{"city": "Paris"}`}},
+		}},
+		{Role: "tool", ToolCallID: "toolu_1", Content: "18C"},
+		{Role: "user", Content: "Thanks"},
+	}
+	if !reflect.DeepEqual(got.Messages, want) {
+		t.Errorf("messages = %+v\nwant %+v", got.Messages, want)
+	}
+	if got.MaxTokens != 1024 || got.User != "u1" || !reflect.DeepEqual(got.Stop, []string{"END"}) {
+		t.Errorf("parameters = %+v", got)
+	}
+	if len(got.Tools) != 1 || got.Tools[0].Function.Name != "get_weather" || got.Tools[0].Type != "function" {
+		t.Errorf("tools = %+v", got.Tools)
+	}
+	choice, _ := json.Marshal(got.ToolChoice)
+	if string(choice) != `{"function":{"name":"get_weather"},"type":"function"}` {
+		t.Errorf("tool_choice = %s", choice)
+	}
+
+	req.Messages = append(req.Messages, types.AnthropicMessage{Role: "user", Content: types.AnthropicContent{{Type: "image"}}})
+	if _, err := AnthropicToOpenAI(req); err == nil || !strings.Contains(err.Error(), "image") {
+		t.Errorf("image block: err = %v", err)
+	}
+}
+
+func TestOpenAIToAnthropic(t *testing.T) {
+	resp := types.ChatCompletionResponse{
+		ID:    "chatcmpl-ABC",
+		Model: "openai/gpt-5",
+		Choices: []types.ChatCompletionChoice{{
+			Message: &types.ChatMessage{Role: "assistant", Content: "Sure.", ToolCalls: []types.ToolCall{
+				{ID: "t1", Function: types.ToolCallFunction{Name: "run", Arguments: `{"cmd":"ls"}`}},
+				{ID: "t2", Function: types.ToolCallFunction{Name: "raw", Arguments: "not json"}},
+			}},
+			FinishReason: "tool_calls",
+		}},
+		Usage: types.ChatCompletionUsage{PromptTokens: 10, CompletionTokens: 5},
+	}
+	data, err := json.Marshal(OpenAIToAnthropic(resp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":"msg_ABC","type":"message","role":"assistant","model":"openai/gpt-5","content":[` +
+		`{"type":"text","text":"Sure."},` +
+		`{"type":"tool_use","id":"t1","name":"run","input":{"cmd":"ls"}},` +
+		`{"type":"tool_use","id":"t2","name":"raw","input":{"arguments":"not json"}}],` +
+		`"stop_reason":"tool_use","stop_sequence":null,"usage":{"input_tokens":10,"output_tokens":5}}`
+	if string(data) != want {
+		t.Errorf("response =\n%s\nwant\n%s", data, want)
+	}
+}
+
+func TestAnthropicStream(t *testing.T) {
+	s := NewAnthropicStream(7)
+	chunk := func(delta types.ChatMessage, finish string) types.ChatCompletionStreamResponse {
+		return types.ChatCompletionStreamResponse{
+			ID:      "chatcmpl-X",
+			Model:   "m",
+			Choices: []types.ChatCompletionChoice{{Delta: &delta, FinishReason: finish}},
+		}
+	}
+	call := func(index int, id, name, args string) types.ChatMessage {
+		return types.ChatMessage{ToolCalls: []types.ToolCall{{Index: &index, ID: id, Function: types.ToolCallFunction{Name: name, Arguments: args}}}}
+	}
+
+	var events []types.AnthropicEvent
+	events = append(events, s.Chunk(chunk(types.ChatMessage{Role: "assistant", Content: "Hi"}, ""))...)
+	events = append(events, s.Chunk(chunk(types.ChatMessage{Content: " there"}, ""))...)
+	events = append(events, s.Chunk(chunk(call(0, "t1", "run", `{"cmd":`), ""))...)
+	events = append(events, s.Chunk(chunk(call(0, "", "", `"ls"}`), ""))...)
+	final := chunk(types.ChatMessage{}, "tool_calls")
+	final.Usage = &types.ChatCompletionUsage{PromptTokens: 9, CompletionTokens: 4}
+	events = append(events, s.Chunk(final)...)
+	events = append(events, s.Finish()...)
+
+	var eventTypes []string
+	for _, e := range events {
+		eventTypes = append(eventTypes, e.Type)
+	}
+	want := []string{
+		"message_start",
+		"content_block_start", "content_block_delta", "content_block_delta", "content_block_stop",
+		"content_block_start", "content_block_delta", "content_block_delta", "content_block_stop",
+		"message_delta", "message_stop",
+	}
+	if !reflect.DeepEqual(eventTypes, want) {
+		t.Fatalf("events = %v\nwant %v", eventTypes, want)
+	}
+	if m := events[0].Message; m.ID != "msg_X" || m.Usage.InputTokens != 7 {
+		t.Errorf("message_start = %+v", m)
+	}
+	if b := events[5]; *b.Index != 1 || b.ContentBlock.Type != "tool_use" || b.ContentBlock.ID != "t1" {
+		t.Errorf("tool block start = %+v", b.ContentBlock)
+	}
+	if d := events[7].Delta; *events[7].Index != 1 || d.PartialJSON != `"ls"}` {
+		t.Errorf("continued arguments = %+v", d)
+	}
+	if d := events[9]; *d.Delta.StopReason != "tool_use" || d.Usage.OutputTokens != 4 || d.Usage.InputTokens != 9 {
+		t.Errorf("message_delta = %+v %+v", d.Delta, d.Usage)
+	}
+	if s.Finish() != nil || !s.Done() {
+		t.Error("the stream must end once")
+	}
+}
+
+func TestAnthropicStream_Error(t *testing.T) {
+	s := NewAnthropicStream(0)
+	s.Chunk(types.ChatCompletionStreamResponse{Choices: []types.ChatCompletionChoice{{Delta: &types.ChatMessage{Content: "partial"}}}})
+	events := s.Chunk(types.ChatCompletionStreamResponse{Error: &types.ErrorDetail{Message: "upstream failed"}})
+	if len(events) != 2 || events[0].Type != "content_block_stop" || events[1].Type != "error" || events[1].Error.Message != "upstream failed" {
+		t.Fatalf("events = %+v", events)
+	}
+	if s.Finish() != nil {
+		t.Error("no message_stop after an error")
+	}
+}
@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"cursor2api/types"
+)
+
+// AutoModel is the pseudo-model resolved to a real model by the auto router
+const AutoModel = "auto"
+
+// AutoRule routes a request to Model when all of its set conditions hold
+type AutoRule struct {
+	Name      string `json:"name,omitempty"` // Reported in logs and metrics; defaults to the model
+	Model     string `json:"model"`
+	MinTokens int    `json:"min_tokens,omitempty"` // Estimated prompt tokens at least
+	MaxTokens int    `json:"max_tokens,omitempty"` // Estimated prompt tokens at most (0 = no limit)
+	Code      *bool  `json:"code,omitempty"`       // Whether the prompt contains code
+	Tools     *bool  `json:"tools,omitempty"`      // Whether the request offers tools
+}
+
+// AutoRouter resolves the auto model with the first matching rule, else the default
+type AutoRouter struct {
+	Rules   []AutoRule `json:"rules"`
+	Default string     `json:"default"`
+}
+
+// DefaultAutoRouter returns the built-in rules: tool use and code go to a strong coding
+// model, very long prompts to a long-context model and everything else to a cheaper one
+func DefaultAutoRouter() *AutoRouter {
+	yes := true
+	return &AutoRouter{
+		Rules: []AutoRule{
+			{Name: "tools", Model: "anthropic/claude-4.5-sonnet", Tools: &yes},
+			{Name: "long_prompt", Model: "google/gemini-2.5-pro", MinTokens: 32000},
+			{Name: "code", Model: "anthropic/claude-4.5-sonnet", Code: &yes},
+		},
+		Default: "openai/gpt-5",
+	}
+}
+
+// LoadAutoRouter reads the routing rules file; an empty path selects the built-in rules
+func LoadAutoRouter(path string) (*AutoRouter, error) {
+	if path == "" {
+		return DefaultAutoRouter(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var router AutoRouter
+	if err := json.Unmarshal(data, &router); err != nil {
+		return nil, fmt.Errorf("failed to parse auto model rules: %w", err)
+	}
+	if router.Default == "" {
+		return nil, errors.New("auto model rules need a default model")
+	}
+	for i, rule := range router.Rules {
+		if rule.Model == "" || rule.Model == AutoModel {
+			return nil, fmt.Errorf("auto model rule %d needs a real model", i+1)
+		}
+	}
+	return &router, nil
+}
+
+// Route returns the model for a request to the auto model and the name of the rule
+// that selected it ("default" when none matched); promptTokens is the prompt estimate
+func (ar *AutoRouter) Route(req *types.ChatCompletionRequest, promptTokens int) (model, rule string) {
+	hasCode := -1 // Computed lazily, only rules with a code condition need it
+	for _, r := range ar.Rules {
+		if promptTokens < r.MinTokens || (r.MaxTokens > 0 && promptTokens > r.MaxTokens) {
+			continue
+		}
+		if r.Tools != nil && *r.Tools != (len(req.Tools) > 0) {
+			continue
+		}
+		if r.Code != nil {
+			if hasCode < 0 {
+				hasCode = 0
+				if ContainsCode(req.Messages) {
+					hasCode = 1
+				}
+			}
+			if *r.Code != (hasCode == 1) {
+				continue
+			}
+		}
+		return r.Model, cmp.Or(r.Name, r.Model)
+	}
+	return ar.Default, "default"
+}
+
+// codeLinePrefixes are line starts that mark source code outside of fences
+var codeLinePrefixes = []string{"func ", "def ", "class ", "import ", "package ", "#include", "public ", "const ", "let ", "fn "}
+
+// ContainsCode reports whether the user or tool messages contain source code: a fenced
+// block, or several lines that start like declarations or end like statements or blocks
+func ContainsCode(messages []types.ChatMessage) bool {
+	for _, m := range messages {
+		if m.Role != "user" && m.Role != "tool" {
+			continue
+		}
+		if strings.Contains(m.Content, "```") {
+			return true
+		}
+		codeLines := 0
+		for _, line := range strings.Split(m.Content, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			if strings.HasSuffix(line, ";") || strings.HasSuffix(line, "{") || strings.HasSuffix(line, "}") || hasAnyPrefix(line, codeLinePrefixes) {
+				codeLines++
+			}
+			if codeLines >= 3 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasAnyPrefix reports whether s starts with one of prefixes
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cursor2api/types"
+)
+
+func TestAutoRouter_DefaultRules(t *testing.T) {
+	router := DefaultAutoRouter()
+	user := func(content string) []types.ChatMessage {
+		return []types.ChatMessage{{Role: "user", Content: content}}
+	}
+
+	tests := []struct {
+		name   string
+		req    types.ChatCompletionRequest
+		tokens int
+		model  string
+		rule   string
+	}{
+		{"simple question", types.ChatCompletionRequest{Messages: user("What is the capital of France?")}, 10, "openai/gpt-5", "default"},
+		{"fenced code", types.ChatCompletionRequest{Messages: user("Fix this:\n```go\nx := 1\n```")}, 20, "anthropic/claude-4.5-sonnet", "code"},
+		{"tools", types.ChatCompletionRequest{Messages: user("hi"), Tools: []types.Tool{{Type: "function"}}}, 5, "anthropic/claude-4.5-sonnet", "tools"},
+		{"long prompt", types.ChatCompletionRequest{Messages: user("summarize")}, 50000, "google/gemini-2.5-pro", "long_prompt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, rule := router.Route(&tt.req, tt.tokens)
+			if model != tt.model || rule != tt.rule {
+				t.Errorf("Route() = %s (%s), want %s (%s)", model, rule, tt.model, tt.rule)
+			}
+		})
+	}
+}
+
+func TestContainsCode(t *testing.T) {
+	unfenced := "why does this fail?\nfunc main() {\n\tfmt.Println(x);\n}"
+	if !ContainsCode([]types.ChatMessage{{Role: "user", Content: unfenced}}) {
+		t.Error("unfenced Go code not detected")
+	}
+	prose := "Tell me a story.\nIt should be short.\nAnd funny."
+	if ContainsCode([]types.ChatMessage{{Role: "user", Content: prose}}) {
+		t.Error("prose detected as code")
+	}
+	if ContainsCode([]types.ChatMessage{{Role: "system", Content: "```"}}) {
+		t.Error("system prompts must not count as code")
+	}
+}
+
+func TestLoadAutoRouter(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	router, err := LoadAutoRouter(write("ok.json", `{"rules": [{"model": "xai/grok-4", "max_tokens": 100, "code": false}], "default": "openai/gpt-5"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := types.ChatCompletionRequest{Messages: []types.ChatMessage{{Role: "user", Content: "hi"}}}
+	if model, rule := router.Route(&req, 10); model != "xai/grok-4" || rule != "xai/grok-4" {
+		t.Errorf("Route() = %s (%s), want the unnamed rule's model", model, rule)
+	}
+	if model, _ := router.Route(&req, 500); model != "openai/gpt-5" {
+		t.Errorf("Route() over max_tokens = %s, want the default", model)
+	}
+
+	if _, err := LoadAutoRouter(write("nodefault.json", `{"rules": []}`)); err == nil {
+		t.Error("rules without a default should be rejected")
+	}
+	if _, err := LoadAutoRouter(write("loop.json", `{"rules": [{"model": "auto"}], "default": "openai/gpt-5"}`)); err == nil {
+		t.Error("a rule routing to auto should be rejected")
+	}
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"cursor2api/config"
+	"cursor2api/types"
+)
+
+// ConversionCase is an OpenAI → Cursor conversion test vector. The same format is
+// used by the golden files in utils/testdata/convert and the debug/convert admin call.
+type ConversionCase struct {
+	Name string `json:"name,omitempty"`
+	// FunctionCalling overrides ENABLE_FUNCTION_CALLING for this case when set
+	FunctionCalling *bool                       `json:"function_calling,omitempty"`
+	Request         types.ChatCompletionRequest `json:"request"`
+	// Expected is the Cursor request body; formatting is ignored, the compacted
+	// form must match the converter output byte for byte
+	Expected json.RawMessage `json:"expected,omitempty"`
+}
+
+// ConversionResult is the outcome of running a ConversionCase
+type ConversionResult struct {
+	Name            string          `json:"name,omitempty"`
+	FunctionCalling bool            `json:"function_calling"`
+	Output          json.RawMessage `json:"output"`
+	Match           *bool           `json:"match,omitempty"` // nil when the case has no expected output
+}
+
+// RunConversionCase converts the case's request exactly as BuildCursorRequest does
+// and compares the result with the expected body when one is given
+func RunConversionCase(c ConversionCase) (ConversionResult, error) {
+	functionCalling := config.GlobalConfig != nil && config.GlobalConfig.Cursor.EnableFunctionCalling
+	if c.FunctionCalling != nil {
+		functionCalling = *c.FunctionCalling
+	}
+
+	// The converter injects tool prompts into the messages in place; keep the case intact
+	messages := append([]types.ChatMessage(nil), c.Request.Messages...)
+	output, err := buildCursorRequestBody(messages, c.Request.Model, c.Request.Tools, functionCalling)
+	if err != nil {
+		return ConversionResult{}, err
+	}
+
+	result := ConversionResult{Name: c.Name, FunctionCalling: functionCalling, Output: output}
+	if len(c.Expected) > 0 {
+		var expected bytes.Buffer
+		if err := json.Compact(&expected, c.Expected); err != nil {
+			return ConversionResult{}, fmt.Errorf("invalid expected output: %w", err)
+		}
+		match := bytes.Equal(expected.Bytes(), output)
+		result.Match = &match
+	}
+	return result, nil
+}
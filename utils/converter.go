@@ -11,6 +11,7 @@ import (
 // MessageConverter handles OpenAI to Cursor message conversion
 type MessageConverter struct {
 	systemPrompt string
+	hooks        *ConverterHooks
 }
 
 // NewMessageConverter creates a new message converter
@@ -20,25 +21,44 @@ func NewMessageConverter(systemPrompt string) *MessageConverter {
 	}
 }
 
+// UseHooks sets the pre/post processing hooks applied around conversion; nil disables them
+func (mc *MessageConverter) UseHooks(hooks *ConverterHooks) {
+	mc.hooks = hooks
+}
+
+// PostProcess applies the output hooks to generated text (a whole response or a stream chunk)
+func (mc *MessageConverter) PostProcess(text string) string {
+	return mc.hooks.ApplyOutput(text)
+}
+
 // BuildCursorRequest builds a Cursor API request body
 func (mc *MessageConverter) BuildCursorRequest(messages []types.ChatMessage, model string, conversationID string, tools []types.Tool) string {
-	cursorReq, err := ConvertOpenAIToCursorRequest(&types.ChatCompletionRequest{
+	requestBody, err := buildCursorRequestBody(mc.hooks.ApplyMessages(messages), model, tools, config.GlobalConfig.Cursor.EnableFunctionCalling)
+	if err != nil {
+		logger.Error("Failed to build request: %v", err)
+		return ""
+	}
+
+	return string(requestBody)
+}
+
+// buildCursorRequestBody converts and serializes a request exactly as it is sent upstream
+func buildCursorRequestBody(messages []types.ChatMessage, model string, tools []types.Tool, functionCalling bool) ([]byte, error) {
+	cursorReq, err := convertRequest(&types.ChatCompletionRequest{
 		Messages: messages,
 		Model:    model,
 		Tools:    tools,
-	})
+	}, functionCalling)
 	if err != nil {
-		logger.Error("Failed to convert request: %v", err)
-		return ""
+		return nil, fmt.Errorf("failed to convert request: %w", err)
 	}
 
 	requestBody, err := json.Marshal(cursorReq)
 	if err != nil {
-		logger.Error("Failed to marshal request: %v", err)
-		return ""
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	return string(requestBody)
+	return requestBody, nil
 }
 
 // EstimateMessagesTokens estimates the token count for messages
@@ -48,19 +68,22 @@ func (mc *MessageConverter) EstimateMessagesTokens(messages []types.ChatMessage)
 		content := extractTextFromContent(msg.Content)
 		totalChars += len(content)
 	}
-	// Rough estimation: 1 token ≈ 4 characters for English, 1 token ≈ 2 characters for Chinese
-	return totalChars / 3
+	return estimateTokens(totalChars)
 }
 
 // EstimateTokens estimates the token count for a single text string
 func (mc *MessageConverter) EstimateTokens(text string) int {
-	// Rough estimation: 1 token ≈ 4 characters for English, 1 token ≈ 2 characters for Chinese
-	return len(text) / 3
+	return estimateTokens(len(text))
 }
 
 // ConvertOpenAIToCursorRequest converts OpenAI format request to Cursor format
 func ConvertOpenAIToCursorRequest(req *types.ChatCompletionRequest) (*types.CursorChatRequest, error) {
-	messages, err := convertMessages(req.Messages, req.Tools)
+	return convertRequest(req, config.GlobalConfig.Cursor.EnableFunctionCalling)
+}
+
+// convertRequest converts a request with function calling explicitly enabled or disabled
+func convertRequest(req *types.ChatCompletionRequest, functionCalling bool) (*types.CursorChatRequest, error) {
+	messages, err := convertMessages(req.Messages, req.Tools, functionCalling)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert messages: %w", err)
 	}
@@ -74,9 +97,9 @@ func ConvertOpenAIToCursorRequest(req *types.ChatCompletionRequest) (*types.Curs
 }
 
 // convertMessages converts OpenAI messages to Cursor format with tool injection
-func convertMessages(messages []types.ChatMessage, tools []types.Tool) ([]types.CursorMessage, error) {
+func convertMessages(messages []types.ChatMessage, tools []types.Tool, functionCalling bool) ([]types.CursorMessage, error) {
 	// CRITICAL: Inject tools into system prompt if function calling is enabled
-	if config.GlobalConfig.Cursor.EnableFunctionCalling && len(tools) > 0 {
+	if functionCalling && len(tools) > 0 {
 		injectToolsIntoSystemPrompt(messages, tools)
 	}
 
@@ -84,7 +107,7 @@ func convertMessages(messages []types.ChatMessage, tools []types.Tool) ([]types.
 
 	for _, msg := range messages {
 		// Handle tool_calls in assistant messages
-		if config.GlobalConfig.Cursor.EnableFunctionCalling && msg.Role == "assistant" && len(msg.ToolCalls) > 0 {
+		if functionCalling && msg.Role == "assistant" && len(msg.ToolCalls) > 0 {
 			toolCallsJSON, err := json.Marshal(msg.ToolCalls)
 			if err != nil {
 				logger.Error("Failed to marshal tool_calls: %v", err)
@@ -105,7 +128,7 @@ func convertMessages(messages []types.ChatMessage, tools []types.Tool) ([]types.
 		}
 
 		// Handle tool response messages
-		if config.GlobalConfig.Cursor.EnableFunctionCalling && msg.Role == "tool" && msg.ToolCallID != "" {
+		if functionCalling && msg.Role == "tool" && msg.ToolCallID != "" {
 			cursorMsg := types.CursorMessage{
 				Role: "user",
 				Parts: []types.CursorMessagePart{
@@ -193,13 +216,16 @@ func injectToolsIntoSystemPrompt(messages []types.ChatMessage, tools []types.Too
 		return
 	}
 
-	// CRITICAL: Inject TWO separate prompts exactly as Python does
+	// CRITICAL: Inject TWO separate prompts exactly as Python does,
+	// in the language of the conversation (Chinese matches Python verbatim)
+	prompt := toolPromptFor(messages)
+
 	// First injection: tool definitions
-	firstPrompt := fmt.Sprintf("你可用的工具: %s", string(toolsArrayJSON))
+	firstPrompt := fmt.Sprintf(prompt.tools, string(toolsArrayJSON))
 	injectSinglePrompt(messages, firstPrompt)
 
 	// Second injection: usage instruction
-	secondPrompt := "不允许使用tool_calls: xxxx调用工具，请使用原生的工具调用方法"
+	secondPrompt := prompt.instruction
 	injectSinglePrompt(messages, secondPrompt)
 
 	logger.Debug("Tools injected into system prompt, tool_count: %d, first_prompt_preview: %s",
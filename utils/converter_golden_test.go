@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cursor2api/types"
+)
+
+// Regenerate the expected outputs with: go test ./utils -run TestConverterGolden -update
+var update = flag.Bool("update", false, "rewrite the expected output of the conversion golden files")
+
+func TestConverterGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "convert", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no conversion golden files found")
+	}
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var c ConversionCase
+			if err := json.Unmarshal(data, &c); err != nil {
+				t.Fatalf("invalid golden file: %v", err)
+			}
+			if c.FunctionCalling == nil {
+				t.Fatal("golden files must set function_calling explicitly")
+			}
+
+			result, err := RunConversionCase(c)
+			if err != nil {
+				t.Fatalf("conversion failed: %v", err)
+			}
+
+			if *update {
+				c.Expected = result.Output
+				updated, err := json.MarshalIndent(c, "", "  ")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(file, append(updated, '\n'), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			if result.Match == nil {
+				t.Fatal("golden file has no expected output, run with -update")
+			}
+			if !*result.Match {
+				var want bytes.Buffer
+				_ = json.Compact(&want, c.Expected)
+				t.Fatalf("conversion output differs from golden file\n got: %s\nwant: %s", result.Output, want.Bytes())
+			}
+		})
+	}
+}
+
+func TestRunConversionCase_DoesNotModifyCase(t *testing.T) {
+	enabled := true
+	c := ConversionCase{
+		FunctionCalling: &enabled,
+		Request: types.ChatCompletionRequest{
+			Model:    "claude-sonnet-4.5",
+			Messages: []types.ChatMessage{{Role: "system", Content: "sys"}, {Role: "user", Content: "hi"}},
+			Tools:    []types.Tool{{Type: "function", Function: types.FunctionDef{Name: "noop"}}},
+		},
+	}
+	before := c.Request.Messages[0].Content
+
+	if _, err := RunConversionCase(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Request.Messages[0].Content != before {
+		t.Fatalf("system message was modified: %q", c.Request.Messages[0].Content)
+	}
+}
@@ -0,0 +1,45 @@
+package utils
+
+import "unicode/utf8"
+
+// minJSONPiece is the largest encoded size of a single rune; smaller limits are
+// raised to it so every piece holds at least one rune
+const minJSONPiece = 6
+
+// SplitJSONString splits s into pieces whose JSON-encoded form (without the
+// surrounding quotes) is at most limit bytes, cutting only between runes. The
+// encoded size is estimated conservatively, counting HTML-escaped characters as
+// \u escapes. Returns s as the only piece when limit is not positive.
+func SplitJSONString(s string, limit int) []string {
+	if limit <= 0 || len(s) <= limit/minJSONPiece {
+		return []string{s}
+	}
+	limit = max(limit, minJSONPiece)
+
+	var pieces []string
+	start, size := 0, 0
+	for i, r := range s {
+		n := escapedLen(r, s[i:])
+		if size+n > limit {
+			pieces = append(pieces, s[start:i])
+			start, size = i, 0
+		}
+		size += n
+	}
+	return append(pieces, s[start:])
+}
+
+// escapedLen returns the JSON-encoded size of the rune r starting rest
+func escapedLen(r rune, rest string) int {
+	switch {
+	case r == '"' || r == '\\' || r == '\n' || r == '\r' || r == '\t':
+		return 2
+	case r < 0x20 || r == '<' || r == '>' || r == '&' || r == '\u2028' || r == '\u2029':
+		return 6
+	case r == utf8.RuneError:
+		if _, n := utf8.DecodeRuneInString(rest); n == 1 {
+			return 6 // invalid byte, encoded as \ufffd
+		}
+	}
+	return utf8.RuneLen(r)
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSplitJSONString(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		limit int
+	}{
+		{"ascii", strings.Repeat(`{"path": "a/b"}`, 200), 100},
+		{"escapes", strings.Repeat("\"<tag>\"\n\\", 300), 64},
+		{"multibyte", strings.Repeat("读取文件 ✂️ ", 300), 50},
+		{"tiny limit", `{"a": "<>"}`, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pieces := SplitJSONString(tt.s, tt.limit)
+			if len(pieces) < 2 {
+				t.Fatalf("pieces = %d, want a split", len(pieces))
+			}
+			if got := strings.Join(pieces, ""); got != tt.s {
+				t.Fatal("pieces do not add up to the input")
+			}
+			limit := max(tt.limit, minJSONPiece)
+			for i, piece := range pieces {
+				encoded, _ := json.Marshal(piece)
+				if piece == "" || len(encoded)-2 > limit {
+					t.Errorf("piece %d encodes to %d bytes, limit %d", i, len(encoded)-2, limit)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitJSONString_NoSplit(t *testing.T) {
+	for _, limit := range []int{0, -1, 1 << 20} {
+		if pieces := SplitJSONString(`{"a": 1}`, limit); len(pieces) != 1 || pieces[0] != `{"a": 1}` {
+			t.Errorf("limit %d: pieces = %q", limit, pieces)
+		}
+	}
+}
@@ -0,0 +1,423 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"cursor2api/types"
+)
+
+// GeminiToOpenAI converts a generateContent request into the chat completion
+// request served by the OpenAI-compatible pipeline. Function calls of model turns
+// become assistant tool calls and function responses become tool messages; calls
+// without an ID are matched to responses by name, in order. Inline and file data
+// are rejected because the upstream only accepts text.
+func GeminiToOpenAI(model string, req types.GeminiRequest, stream bool) (types.ChatCompletionRequest, error) {
+	out := types.ChatCompletionRequest{Model: model, Stream: stream}
+	if gen := req.GenerationConfig; gen != nil {
+		if gen.CandidateCount > 1 {
+			return types.ChatCompletionRequest{}, fmt.Errorf("generationConfig.candidateCount > 1 is not supported")
+		}
+		out.Temperature = gen.Temperature
+		out.TopP = gen.TopP
+		out.MaxTokens = gen.MaxOutputTokens
+		out.Stop = gen.StopSequences
+		switch gen.ResponseMimeType {
+		case "", "text/plain":
+		case "application/json":
+			out.ResponseFormat = &types.ResponseFormat{Type: "json_object"}
+		default:
+			return types.ChatCompletionRequest{}, fmt.Errorf("generationConfig.responseMimeType %q is not supported", gen.ResponseMimeType)
+		}
+	}
+
+	if req.SystemInstruction != nil {
+		system, err := geminiText(req.SystemInstruction.Parts)
+		if err != nil {
+			return types.ChatCompletionRequest{}, fmt.Errorf("systemInstruction: %w", err)
+		}
+		if system != "" {
+			out.Messages = append(out.Messages, types.ChatMessage{Role: "system", Content: system})
+		}
+	}
+
+	calls := newGeminiCallIDs()
+	for i, content := range req.Contents {
+		messages, err := geminiContent(content, i, calls)
+		if err != nil {
+			return types.ChatCompletionRequest{}, fmt.Errorf("contents[%d]: %w", i, err)
+		}
+		out.Messages = append(out.Messages, messages...)
+	}
+
+	for _, tool := range req.Tools {
+		for _, fn := range tool.FunctionDeclarations {
+			out.Tools = append(out.Tools, types.Tool{
+				Type: "function",
+				Function: types.FunctionDef{
+					Name:        fn.Name,
+					Description: fn.Description,
+					Parameters:  geminiSchema(fn.Parameters),
+				},
+			})
+		}
+	}
+	if req.ToolConfig != nil && req.ToolConfig.FunctionCallingConfig != nil {
+		config := req.ToolConfig.FunctionCallingConfig
+		switch strings.ToUpper(config.Mode) {
+		case "", "AUTO", "MODE_UNSPECIFIED":
+			out.ToolChoice = "auto"
+		case "NONE":
+			out.ToolChoice = "none"
+		case "ANY":
+			out.ToolChoice = "required"
+			if len(config.AllowedFunctionNames) == 1 {
+				out.ToolChoice = map[string]interface{}{
+					"type":     "function",
+					"function": map[string]string{"name": config.AllowedFunctionNames[0]},
+				}
+			}
+		default:
+			return types.ChatCompletionRequest{}, fmt.Errorf("unknown functionCallingConfig.mode %q", config.Mode)
+		}
+	}
+	return out, nil
+}
+
+// geminiCallIDs assigns IDs to function calls and matches responses to them
+type geminiCallIDs struct {
+	pending map[string][]string // function name -> IDs of calls awaiting a response
+}
+
+func newGeminiCallIDs() *geminiCallIDs {
+	return &geminiCallIDs{pending: make(map[string][]string)}
+}
+
+// call returns the ID of a function call, generating one when the client sent none
+func (c *geminiCallIDs) call(fc *types.GeminiFunctionCall, content, part int) string {
+	id := fc.ID
+	if id == "" {
+		id = fmt.Sprintf("call_%d_%d", content, part)
+	}
+	c.pending[fc.Name] = append(c.pending[fc.Name], id)
+	return id
+}
+
+// response returns the ID of the call a function response answers
+func (c *geminiCallIDs) response(fr *types.GeminiFunctionResponse) string {
+	queue := c.pending[fr.Name]
+	if fr.ID != "" {
+		for i, id := range queue {
+			if id == fr.ID {
+				c.pending[fr.Name] = append(queue[:i:i], queue[i+1:]...)
+				break
+			}
+		}
+		return fr.ID
+	}
+	if len(queue) == 0 {
+		return "call_" + fr.Name
+	}
+	c.pending[fr.Name] = queue[1:]
+	return queue[0]
+}
+
+// geminiContent converts one turn; function responses become one tool message each
+// followed by the remaining text
+func geminiContent(content types.GeminiContent, index int, calls *geminiCallIDs) ([]types.ChatMessage, error) {
+	role := "user"
+	switch content.Role {
+	case "", "user", "function", "tool":
+	case "model":
+		role = "assistant"
+	default:
+		return nil, fmt.Errorf("unknown role %q", content.Role)
+	}
+
+	var (
+		out       []types.ChatMessage
+		text      []string
+		toolCalls []types.ToolCall
+	)
+	for i, part := range content.Parts {
+		switch {
+		case part.Thought:
+		case part.FunctionCall != nil:
+			args := string(part.FunctionCall.Args)
+			if args == "" || args == "null" {
+				args = "{}"
+			}
+			toolCalls = append(toolCalls, types.ToolCall{
+				ID:       calls.call(part.FunctionCall, index, i),
+				Type:     "function",
+				Function: types.ToolCallFunction{Name: part.FunctionCall.Name, Arguments: args},
+			})
+		case part.FunctionResponse != nil:
+			out = append(out, types.ChatMessage{
+				Role:       "tool",
+				ToolCallID: calls.response(part.FunctionResponse),
+				Content:    string(part.FunctionResponse.Response),
+			})
+		case part.InlineData != nil || part.FileData != nil:
+			return nil, fmt.Errorf("parts[%d]: inline and file data are not supported", i)
+		default:
+			text = append(text, part.Text)
+		}
+	}
+
+	if len(toolCalls) > 0 && role != "assistant" {
+		return nil, fmt.Errorf("functionCall parts are only allowed in model turns")
+	}
+	if joined := strings.Join(text, ""); joined != "" || len(toolCalls) > 0 {
+		out = append(out, types.ChatMessage{Role: role, Content: joined, ToolCalls: toolCalls})
+	}
+	return out, nil
+}
+
+// geminiText joins the text parts of a system instruction
+func geminiText(parts []types.GeminiPart) (string, error) {
+	var text []string
+	for i, part := range parts {
+		if part.FunctionCall != nil || part.FunctionResponse != nil || part.InlineData != nil || part.FileData != nil {
+			return "", fmt.Errorf("parts[%d]: only text is supported", i)
+		}
+		text = append(text, part.Text)
+	}
+	return strings.Join(text, ""), nil
+}
+
+// geminiSchema lowercases the OpenAPI type names ("OBJECT", "STRING") sent by
+// Gemini SDKs into the JSON Schema names used by OpenAI tools
+func geminiSchema(schema map[string]interface{}) map[string]interface{} {
+	if schema == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(schema))
+	for key, value := range schema {
+		switch v := value.(type) {
+		case string:
+			if key == "type" {
+				v = strings.ToLower(v)
+			}
+			out[key] = v
+		case map[string]interface{}:
+			out[key] = geminiSchema(v)
+		case []interface{}:
+			items := make([]interface{}, len(v))
+			for i, item := range v {
+				if m, ok := item.(map[string]interface{}); ok {
+					items[i] = geminiSchema(m)
+				} else {
+					items[i] = item
+				}
+			}
+			out[key] = items
+		default:
+			out[key] = value
+		}
+	}
+	return out
+}
+
+// GeminiFinishReason maps an OpenAI finish_reason to a Gemini finishReason
+func GeminiFinishReason(finishReason string) string {
+	switch finishReason {
+	case "", "stop", "tool_calls":
+		return "STOP"
+	case "length":
+		return "MAX_TOKENS"
+	case "content_filter":
+		return "SAFETY"
+	}
+	return "OTHER"
+}
+
+// GeminiErrorStatus maps an HTTP status to a google.rpc.Code name
+func GeminiErrorStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusRequestEntityTooLarge:
+		return "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		return "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		return "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusTooManyRequests:
+		return "RESOURCE_EXHAUSTED"
+	case 499:
+		return "CANCELLED"
+	case http.StatusServiceUnavailable:
+		return "UNAVAILABLE"
+	case http.StatusGatewayTimeout:
+		return "DEADLINE_EXCEEDED"
+	}
+	return "INTERNAL"
+}
+
+// GeminiResponseID derives the Gemini response ID from a completion ID
+func GeminiResponseID(completionID string) string {
+	return strings.TrimPrefix(completionID, "chatcmpl-")
+}
+
+// OpenAIToGemini converts a non-streaming chat completion into a generateContent response
+func OpenAIToGemini(resp types.ChatCompletionResponse) types.GeminiResponse {
+	candidate := types.GeminiCandidate{Content: types.GeminiContent{Role: "model", Parts: []types.GeminiPart{}}}
+	finishReason := ""
+	if len(resp.Choices) > 0 {
+		choice := resp.Choices[0]
+		finishReason = choice.FinishReason
+		if msg := choice.Message; msg != nil {
+			candidate.Content.Parts = geminiParts(msg.Content, msg.ToolCalls)
+		}
+	}
+	candidate.FinishReason = GeminiFinishReason(finishReason)
+	return types.GeminiResponse{
+		Candidates:    []types.GeminiCandidate{candidate},
+		UsageMetadata: geminiUsage(resp.Usage.PromptTokens, resp.Usage.CompletionTokens),
+		ModelVersion:  resp.Model,
+		ResponseID:    GeminiResponseID(resp.ID),
+	}
+}
+
+// geminiParts builds the parts of a model turn from text and tool calls
+func geminiParts(text string, toolCalls []types.ToolCall) []types.GeminiPart {
+	parts := []types.GeminiPart{}
+	if text != "" {
+		parts = append(parts, types.GeminiPart{Text: text})
+	}
+	for _, call := range toolCalls {
+		parts = append(parts, types.GeminiPart{FunctionCall: &types.GeminiFunctionCall{
+			ID:   call.ID,
+			Name: call.Function.Name,
+			Args: toolInput(call.Function.Arguments),
+		}})
+	}
+	return parts
+}
+
+func geminiUsage(prompt, completion int) *types.GeminiUsageMetadata {
+	return &types.GeminiUsageMetadata{
+		PromptTokenCount:     prompt,
+		CandidatesTokenCount: completion,
+		TotalTokenCount:      prompt + completion,
+	}
+}
+
+// GeminiStream translates the chunks of an OpenAI chat completion stream into
+// streamGenerateContent events. Text is forwarded as it arrives; function calls,
+// whose arguments are streamed in fragments, are sent complete in the final event
+// together with the finish reason and usage.
+type GeminiStream struct {
+	promptTokens int // Estimate reported when the upstream does not report usage
+
+	id        string
+	model     string
+	done      bool
+	toolCalls map[int]*types.ToolCall // OpenAI tool call index -> accumulated call
+	stop      string
+	usage     *types.ChatCompletionUsage
+}
+
+// NewGeminiStream creates the translator; promptTokens is reported when the upstream reports no usage
+func NewGeminiStream(promptTokens int) *GeminiStream {
+	return &GeminiStream{promptTokens: promptTokens, toolCalls: make(map[int]*types.ToolCall)}
+}
+
+// Done reports whether the stream has ended with the final event or an error
+func (s *GeminiStream) Done() bool {
+	return s.done
+}
+
+// Chunk translates one stream chunk. An error chunk ends the stream with an error event.
+func (s *GeminiStream) Chunk(chunk types.ChatCompletionStreamResponse) []types.GeminiResponse {
+	if s.done {
+		return nil
+	}
+	if s.id == "" {
+		s.id, s.model = chunk.ID, chunk.Model
+	}
+	if chunk.Usage != nil {
+		s.usage = chunk.Usage
+	}
+
+	var events []types.GeminiResponse
+	for _, choice := range chunk.Choices {
+		if delta := choice.Delta; delta != nil {
+			if delta.Content != "" {
+				events = append(events, s.event(types.GeminiCandidate{
+					Content: types.GeminiContent{Role: "model", Parts: []types.GeminiPart{{Text: delta.Content}}},
+				}))
+			}
+			for _, call := range delta.ToolCalls {
+				acc, ok := s.toolCalls[call.StreamIndex()]
+				if !ok {
+					acc = &types.ToolCall{Index: call.Index}
+					s.toolCalls[call.StreamIndex()] = acc
+				}
+				if call.ID != "" {
+					acc.ID = call.ID
+				}
+				acc.Function.Name += call.Function.Name
+				acc.Function.Arguments += call.Function.Arguments
+			}
+		}
+		if choice.FinishReason != "" {
+			s.stop = choice.FinishReason
+		}
+	}
+
+	if chunk.Error != nil {
+		events = append(events, s.Error(http.StatusInternalServerError, chunk.Error.Message)...)
+	}
+	return events
+}
+
+// Finish ends the stream with an event carrying the function calls, finish reason and usage
+func (s *GeminiStream) Finish() []types.GeminiResponse {
+	if s.done {
+		return nil
+	}
+	s.done = true
+
+	indexes := make([]int, 0, len(s.toolCalls))
+	for index := range s.toolCalls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	calls := make([]types.ToolCall, 0, len(indexes))
+	for _, index := range indexes {
+		calls = append(calls, *s.toolCalls[index])
+	}
+
+	event := s.event(types.GeminiCandidate{
+		Content:      types.GeminiContent{Role: "model", Parts: geminiParts("", calls)},
+		FinishReason: GeminiFinishReason(s.stop),
+	})
+	event.UsageMetadata = geminiUsage(s.promptTokens, 0)
+	if s.usage != nil {
+		event.UsageMetadata = geminiUsage(s.usage.PromptTokens, s.usage.CompletionTokens)
+	}
+	return []types.GeminiResponse{event}
+}
+
+// Error ends the stream with an error event typed after the HTTP status
+func (s *GeminiStream) Error(status int, message string) []types.GeminiResponse {
+	if s.done {
+		return nil
+	}
+	s.done = true
+	return []types.GeminiResponse{{
+		Error: &types.GeminiError{Code: status, Message: message, Status: GeminiErrorStatus(status)},
+	}}
+}
+
+func (s *GeminiStream) event(candidate types.GeminiCandidate) types.GeminiResponse {
+	return types.GeminiResponse{
+		Candidates:   []types.GeminiCandidate{candidate},
+		ModelVersion: s.model,
+		ResponseID:   GeminiResponseID(s.id),
+	}
+}
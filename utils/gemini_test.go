@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cursor2api/types"
+)
+
+func TestGeminiToOpenAI(t *testing.T) {
+	var req types.GeminiRequest
+	body := `{
+		"systemInstruction": {"parts": [{"text": "Be brief."}]},
+		"generationConfig": {"maxOutputTokens": 512, "stopSequences": ["END"], "responseMimeType": "application/json"},
+		"tools": [{"functionDeclarations": [{"name": "get_weather", "parameters": {"type": "OBJECT", "properties": {"city": {"type": "STRING"}}}}]}],
+		"toolConfig": {"functionCallingConfig": {"mode": "ANY", "allowedFunctionNames": ["get_weather"]}},
+		"contents": [
+			{"role": "user", "parts": [{"text": "Weather in Paris"}, {"text": " and Rome?"}]},
+			{"role": "model", "parts": [
+				{"text": "...", "thought": true},
+				{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}},
+				{"functionCall": {"name": "get_weather", "args": {"city": "Rome"}}}
+			]},
+			{"role": "user", "parts": [
+				{"functionResponse": {"name": "get_weather", "response": {"temp": "18C"}}},
+				{"functionResponse": {"name": "get_weather", "response": {"temp": "24C"}}}
+			]}
+		]
+	}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := GeminiToOpenAI("openai/gpt-5", req, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []types.ChatMessage{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "Weather in Paris and Rome?"},
+		{Role: "assistant", ToolCalls: []types.ToolCall{
+			{ID: "call_1_1", Type: "function", Function: types.ToolCallFunction{Name: "get_weather", Arguments: `{"city": "Paris"}`}},
+			{ID: "call_1_2", Type: "function", Function: types.ToolCallFunction{Name: "get_weather", Arguments: `{"city": "Rome"}`}},
+		}},
+		{Role: "tool", ToolCallID: "call_1_1", Content: `{"temp": "18C"}`},
+		{Role: "tool", ToolCallID: "call_1_2", Content: `{"temp": "24C"}`},
+	}
+	if !reflect.DeepEqual(got.Messages, want) {
+		t.Errorf("messages = %+v\nwant %+v", got.Messages, want)
+	}
+	if got.Model != "openai/gpt-5" || !got.Stream || got.MaxTokens != 512 || !got.JSONMode() || !reflect.DeepEqual(got.Stop, []string{"END"}) {
+		t.Errorf("parameters = %+v", got)
+	}
+	schema, _ := json.Marshal(got.Tools[0].Function.Parameters)
+	if string(schema) != `{"properties":{"city":{"type":"string"}},"type":"object"}` {
+		t.Errorf("parameters schema = %s", schema)
+	}
+	choice, _ := json.Marshal(got.ToolChoice)
+	if string(choice) != `{"function":{"name":"get_weather"},"type":"function"}` {
+		t.Errorf("tool_choice = %s", choice)
+	}
+
+	req.Contents = append(req.Contents, types.GeminiContent{Role: "user", Parts: []types.GeminiPart{{InlineData: json.RawMessage(`{}`)}}})
+	if _, err := GeminiToOpenAI("m", req, false); err == nil || !strings.Contains(err.Error(), "inline") {
+		t.Errorf("inline data: err = %v", err)
+	}
+}
+
+func TestOpenAIToGemini(t *testing.T) {
+	resp := types.ChatCompletionResponse{
+		ID:    "chatcmpl-ABC",
+		Model: "openai/gpt-5",
+		Choices: []types.ChatCompletionChoice{{
+			Message: &types.ChatMessage{Role: "assistant", Content: "Sure.", ToolCalls: []types.ToolCall{
+				{ID: "t1", Function: types.ToolCallFunction{Name: "run", Arguments: `{"cmd":"ls"}`}},
+			}},
+			FinishReason: "tool_calls",
+		}},
+		Usage: types.ChatCompletionUsage{PromptTokens: 10, CompletionTokens: 5},
+	}
+	data, err := json.Marshal(OpenAIToGemini(resp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"candidates":[{"content":{"role":"model","parts":[{"text":"Sure."},{"functionCall":{"id":"t1","name":"run","args":{"cmd":"ls"}}}]},"finishReason":"STOP","index":0}],` +
+		`"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":5,"totalTokenCount":15},"modelVersion":"openai/gpt-5","responseId":"ABC"}`
+	if string(data) != want {
+		t.Errorf("response =\n%s\nwant\n%s", data, want)
+	}
+}
+
+func TestGeminiStream(t *testing.T) {
+	s := NewGeminiStream(7)
+	chunk := func(delta types.ChatMessage, finish string) types.ChatCompletionStreamResponse {
+		return types.ChatCompletionStreamResponse{
+			ID:      "chatcmpl-X",
+			Model:   "m",
+			Choices: []types.ChatCompletionChoice{{Delta: &delta, FinishReason: finish}},
+		}
+	}
+	call := func(id, name, args string) types.ChatMessage {
+		return types.ChatMessage{ToolCalls: []types.ToolCall{{ID: id, Function: types.ToolCallFunction{Name: name, Arguments: args}}}}
+	}
+
+	var events []types.GeminiResponse
+	events = append(events, s.Chunk(chunk(types.ChatMessage{Role: "assistant", Content: "Hi"}, ""))...)
+	events = append(events, s.Chunk(chunk(call("t1", "run", `{"cmd":`), ""))...)
+	events = append(events, s.Chunk(chunk(call("", "", `"ls"}`), "tool_calls"))...)
+	events = append(events, s.Finish()...)
+
+	if len(events) != 2 {
+		t.Fatalf("events = %+v", events)
+	}
+	if text := events[0].Candidates[0].Content.Parts[0].Text; text != "Hi" || events[0].ResponseID != "X" {
+		t.Errorf("text event = %+v", events[0])
+	}
+	last := events[1]
+	fc := last.Candidates[0].Content.Parts[0].FunctionCall
+	if fc == nil || fc.Name != "run" || string(fc.Args) != `{"cmd":"ls"}` || last.Candidates[0].FinishReason != "STOP" {
+		t.Errorf("final event = %+v", last.Candidates[0])
+	}
+	if last.UsageMetadata.PromptTokenCount != 7 {
+		t.Errorf("usage = %+v", last.UsageMetadata)
+	}
+	if s.Finish() != nil || !s.Done() {
+		t.Error("the stream must end once")
+	}
+
+	s = NewGeminiStream(0)
+	events = s.Chunk(types.ChatCompletionStreamResponse{Error: &types.ErrorDetail{Message: "upstream failed"}})
+	if len(events) != 1 || events[0].Error == nil || events[0].Error.Status != "INTERNAL" || s.Finish() != nil {
+		t.Errorf("error events = %+v", events)
+	}
+}
@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"cursor2api/types"
+)
+
+// MessageHook rewrites the OpenAI messages of a request before they are converted
+// to the upstream format. It receives a copy of the message slice and may replace
+// or drop entries freely.
+type MessageHook func(messages []types.ChatMessage) []types.ChatMessage
+
+// OutputHook rewrites generated text before it is returned to the client.
+// Streaming responses call it once per text chunk, so it must not rely on seeing
+// a whole word or line at once.
+type OutputHook func(text string) string
+
+var (
+	messageHooks = map[string]MessageHook{}
+	outputHooks  = map[string]OutputHook{}
+)
+
+// RegisterMessageHook makes a message hook available under name. Deployments with
+// custom hooks register them from an init function; CONVERTER_MESSAGE_HOOKS
+// selects which registered hooks run and in which order.
+func RegisterMessageHook(name string, hook MessageHook) {
+	messageHooks[name] = hook
+}
+
+// RegisterOutputHook makes an output hook available under name, selected with
+// CONVERTER_OUTPUT_HOOKS
+func RegisterOutputHook(name string, hook OutputHook) {
+	outputHooks[name] = hook
+}
+
+func init() {
+	RegisterMessageHook("strip_markdown_images", stripMarkdownImages)
+	RegisterOutputHook("strip_zero_width", stripZeroWidth)
+}
+
+// ConverterHooks is the ordered chain of hooks enabled for a deployment.
+// A nil *ConverterHooks runs no hooks.
+type ConverterHooks struct {
+	messageNames []string
+	messages     []MessageHook
+	outputNames  []string
+	output       []OutputHook
+}
+
+// NewConverterHooks resolves the named hooks in the given order; returns nil when
+// no hooks are enabled and an error for names that were never registered
+func NewConverterHooks(messageNames, outputNames []string) (*ConverterHooks, error) {
+	if len(messageNames) == 0 && len(outputNames) == 0 {
+		return nil, nil
+	}
+
+	h := &ConverterHooks{messageNames: messageNames, outputNames: outputNames}
+	for _, name := range messageNames {
+		hook, ok := messageHooks[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown message hook %q", name)
+		}
+		h.messages = append(h.messages, hook)
+	}
+	for _, name := range outputNames {
+		hook, ok := outputHooks[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown output hook %q", name)
+		}
+		h.output = append(h.output, hook)
+	}
+	return h, nil
+}
+
+// String lists the enabled hooks for logs
+func (h *ConverterHooks) String() string {
+	if h == nil {
+		return "none"
+	}
+	return fmt.Sprintf("message=%v output=%v", h.messageNames, h.outputNames)
+}
+
+// ApplyMessages runs the message hooks in order; the caller's slice is never modified
+func (h *ConverterHooks) ApplyMessages(messages []types.ChatMessage) []types.ChatMessage {
+	if h == nil || len(h.messages) == 0 {
+		return messages
+	}
+	result := slices.Clone(messages)
+	for _, hook := range h.messages {
+		result = hook(result)
+	}
+	return result
+}
+
+// ApplyOutput runs the output hooks in order
+func (h *ConverterHooks) ApplyOutput(text string) string {
+	if h == nil {
+		return text
+	}
+	for _, hook := range h.output {
+		text = hook(text)
+	}
+	return text
+}
+
+// markdownImage matches ![alt](url) and ![alt](url "title")
+var markdownImage = regexp.MustCompile(`!\[([^\]]*)\]\([^)\s]*(?:\s+"[^"]*")?\)`)
+
+// stripMarkdownImages replaces markdown images with their alt text, since the
+// upstream cannot fetch them and long data URLs waste the context window
+func stripMarkdownImages(messages []types.ChatMessage) []types.ChatMessage {
+	for i := range messages {
+		if !strings.Contains(messages[i].Content, "![") {
+			continue
+		}
+		messages[i].Content = markdownImage.ReplaceAllStringFunc(messages[i].Content, func(image string) string {
+			alt := markdownImage.FindStringSubmatch(image)[1]
+			if alt == "" {
+				return "[image]"
+			}
+			return "[image: " + alt + "]"
+		})
+	}
+	return messages
+}
+
+// zeroWidth removes zero-width spaces, joiners and byte order marks
+var zeroWidth = strings.NewReplacer("\u200b", "", "\u200c", "", "\u200d", "", "\ufeff", "")
+
+// stripZeroWidth removes invisible characters that break exact-match parsing on the client
+func stripZeroWidth(text string) string {
+	return zeroWidth.Replace(text)
+}
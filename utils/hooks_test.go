@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"cursor2api/config"
+	"cursor2api/types"
+)
+
+func TestNewConverterHooks_Disabled(t *testing.T) {
+	hooks, err := NewConverterHooks(nil, nil)
+	if err != nil || hooks != nil {
+		t.Fatalf("NewConverterHooks(nil, nil) = %v, %v; want nil, nil", hooks, err)
+	}
+
+	messages := []types.ChatMessage{{Role: "user", Content: "![x](y)"}}
+	if got := hooks.ApplyMessages(messages); got[0].Content != "![x](y)" {
+		t.Errorf("nil hooks changed messages: %q", got[0].Content)
+	}
+	if got := hooks.ApplyOutput("a\u200bb"); got != "a\u200bb" {
+		t.Errorf("nil hooks changed output: %q", got)
+	}
+}
+
+func TestNewConverterHooks_UnknownName(t *testing.T) {
+	if _, err := NewConverterHooks([]string{"missing"}, nil); err == nil {
+		t.Error("expected error for unknown message hook")
+	}
+	if _, err := NewConverterHooks(nil, []string{"missing"}); err == nil {
+		t.Error("expected error for unknown output hook")
+	}
+}
+
+func TestConverterHooks_Order(t *testing.T) {
+	RegisterOutputHook("test_suffix_a", func(s string) string { return s + "a" })
+	RegisterOutputHook("test_suffix_b", func(s string) string { return s + "b" })
+	defer delete(outputHooks, "test_suffix_a")
+	defer delete(outputHooks, "test_suffix_b")
+
+	hooks, err := NewConverterHooks(nil, []string{"test_suffix_b", "test_suffix_a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hooks.ApplyOutput("x"); got != "xba" {
+		t.Errorf("ApplyOutput = %q, want hooks in configured order (xba)", got)
+	}
+}
+
+func TestConverterHooks_MessagesDoNotModifyCaller(t *testing.T) {
+	hooks, err := NewConverterHooks([]string{"strip_markdown_images"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := []types.ChatMessage{
+		{Role: "system", Content: "be brief"},
+		{Role: "user", Content: `see ![chart](data:image/png;base64,AAAA) and ![](https://x/y.png "t")`},
+	}
+	got := hooks.ApplyMessages(messages)
+
+	if want := "see [image: chart] and [image]"; got[1].Content != want {
+		t.Errorf("content = %q, want %q", got[1].Content, want)
+	}
+	if !strings.Contains(messages[1].Content, "![chart]") {
+		t.Errorf("caller's messages were modified: %q", messages[1].Content)
+	}
+}
+
+func TestMessageConverter_Hooks(t *testing.T) {
+	hooks, err := NewConverterHooks([]string{"strip_markdown_images"}, []string{"strip_zero_width"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(previous *config.Config) { config.GlobalConfig = previous }(config.GlobalConfig)
+	config.GlobalConfig = &config.Config{}
+
+	mc := NewMessageConverter("")
+	mc.UseHooks(hooks)
+
+	body := mc.BuildCursorRequest([]types.ChatMessage{{Role: "user", Content: "![logo](https://x/logo.png)"}}, "gpt-4o", "", nil)
+	if strings.Contains(body, "logo.png") || !strings.Contains(body, "[image: logo]") {
+		t.Errorf("request body not preprocessed: %s", body)
+	}
+	if got := mc.PostProcess("\ufeffhello\u200b"); got != "hello" {
+		t.Errorf("PostProcess = %q, want %q", got, "hello")
+	}
+}
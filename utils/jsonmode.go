@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"cursor2api/types"
+)
+
+// ErrNotJSON is returned when the output of a JSON mode request stops being a JSON object
+var ErrNotJSON = errors.New("model output is not a valid JSON object")
+
+// JSON mode instructions sent to the model
+const (
+	jsonModeInstruction       = "Respond only with a single valid JSON object. Do not add any text before or after it."
+	jsonModeStrictInstruction = "Your previous answer was not valid JSON. Reply with exactly one valid JSON object and nothing else: " +
+		"no explanations, no Markdown code fences, no text before the opening { or after the closing }."
+)
+
+// jsonSchemaInstruction introduces the schema of a json_schema request
+const jsonSchemaInstruction = "The object must match this JSON Schema:\n"
+
+// WithJSONModeInstruction returns the messages with the JSON mode instruction as a
+// leading system message, followed by the schema when one is given; strict adds the
+// retry instruction as the last user message
+func WithJSONModeInstruction(messages []types.ChatMessage, schema json.RawMessage, strict bool) []types.ChatMessage {
+	instruction := jsonModeInstruction
+	if len(schema) > 0 {
+		instruction += "\n" + jsonSchemaInstruction + string(schema)
+	}
+	out := make([]types.ChatMessage, 0, len(messages)+2)
+	out = append(out, types.ChatMessage{Role: "system", Content: instruction})
+	out = append(out, messages...)
+	if strict {
+		out = append(out, types.ChatMessage{Role: "user", Content: jsonModeStrictInstruction})
+	}
+	return out
+}
+
+// JSONStreamValidator checks streamed output incrementally against JSON mode. Leading
+// whitespace and a Markdown code fence are dropped; everything after the closing brace
+// of the object is discarded. Inside the object, bracket nesting and bare words are
+// checked as they arrive, and the complete object is validated once it closes.
+type JSONStreamValidator struct {
+	preamble string // output before the opening brace
+	started  bool
+	complete bool
+	stack    []byte
+	inString bool
+	escaped  bool
+	object   strings.Builder
+}
+
+// Started reports whether the opening brace was seen, i.e. output was passed on
+func (v *JSONStreamValidator) Started() bool {
+	return v.started
+}
+
+// Complete reports whether the object was closed
+func (v *JSONStreamValidator) Complete() bool {
+	return v.complete
+}
+
+// Write validates a chunk and returns the part of it that belongs to the JSON object.
+// It returns ErrNotJSON as soon as the output can no longer be a JSON object.
+func (v *JSONStreamValidator) Write(chunk string) (string, error) {
+	if v.complete {
+		return "", nil
+	}
+	if !v.started {
+		v.preamble += chunk
+		rest, ok, err := v.skipPreamble()
+		if err != nil || !ok {
+			return "", err
+		}
+		v.started = true
+		v.preamble = ""
+		chunk = rest
+	}
+	return v.scan(chunk)
+}
+
+// skipPreamble drops leading whitespace and a ```json fence; ok is false while more output is needed
+func (v *JSONStreamValidator) skipPreamble() (string, bool, error) {
+	s := strings.TrimLeft(v.preamble, " \t\r\n")
+	if fence, found := strings.CutPrefix(s, "```"); found {
+		newline := strings.IndexByte(fence, '\n')
+		if newline < 0 {
+			if len(fence) > len("json ") {
+				return "", false, ErrNotJSON
+			}
+			return "", false, nil
+		}
+		if lang := strings.ToLower(strings.TrimSpace(fence[:newline])); lang != "" && lang != "json" {
+			return "", false, ErrNotJSON
+		}
+		s = strings.TrimLeft(fence[newline+1:], " \t\r\n")
+	} else if strings.HasPrefix("```", s) {
+		return "", false, nil
+	}
+
+	if s == "" {
+		return "", false, nil
+	}
+	if s[0] != '{' {
+		return "", false, ErrNotJSON
+	}
+	return s, true, nil
+}
+
+// scan tracks strings and nesting; returns the object part of the chunk
+func (v *JSONStreamValidator) scan(chunk string) (string, error) {
+	for i := 0; i < len(chunk); i++ {
+		c := chunk[i]
+		if v.inString {
+			switch {
+			case v.escaped:
+				v.escaped = false
+			case c == '\\':
+				v.escaped = true
+			case c == '"':
+				v.inString = false
+			case c < ' ':
+				return "", ErrNotJSON
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			v.inString = true
+		case '{', '[':
+			v.stack = append(v.stack, c)
+		case '}', ']':
+			open := byte('{')
+			if c == ']' {
+				open = '['
+			}
+			if len(v.stack) == 0 || v.stack[len(v.stack)-1] != open {
+				return "", ErrNotJSON
+			}
+			v.stack = v.stack[:len(v.stack)-1]
+			if len(v.stack) == 0 {
+				v.complete = true
+				out := chunk[:i+1]
+				v.object.WriteString(out)
+				if !json.Valid([]byte(v.object.String())) {
+					return "", ErrNotJSON
+				}
+				return out, nil
+			}
+		default:
+			// Outside strings only literals, numbers and punctuation may appear
+			if (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') && !strings.ContainsRune("truefalsnE", rune(c)) {
+				return "", ErrNotJSON
+			}
+		}
+	}
+	v.object.WriteString(chunk)
+	return chunk, nil
+}
+
+// ExtractJSONObject returns the JSON object in a complete model answer, tolerating
+// surrounding whitespace and a Markdown code fence
+func ExtractJSONObject(text string) (string, bool) {
+	var v JSONStreamValidator
+	out, err := v.Write(text)
+	if err != nil || !v.Complete() {
+		return "", false
+	}
+	return out, true
+}
+
+// CheckJSONObject checks a complete answer against JSON mode, the required top-level
+// fields and the schema (nil = none). It returns the extracted object and the problems
+// found, which are phrased to be shown to the model when asking it to repair the answer.
+func CheckJSONObject(text string, required []string, schema *JSONSchema) (string, []string) {
+	object, ok := ExtractJSONObject(text)
+	if !ok {
+		return "", []string{"The answer is not a single valid JSON object."}
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(object), &fields); err != nil {
+		return "", []string{"The answer is not a single valid JSON object."}
+	}
+	var problems []string
+	for _, name := range required {
+		if _, ok := fields[name]; !ok {
+			problems = append(problems, fmt.Sprintf("The object is missing the required field %q.", name))
+		}
+	}
+	if schema != nil {
+		var value interface{}
+		json.Unmarshal([]byte(object), &value)
+		problems = append(problems, schema.Validate(value)...)
+	}
+	return object, problems
+}
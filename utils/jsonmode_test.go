@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// feed writes chunks in order and returns the passed-on output and the first error
+func feed(v *JSONStreamValidator, chunks ...string) (string, error) {
+	var out strings.Builder
+	for _, chunk := range chunks {
+		part, err := v.Write(chunk)
+		out.WriteString(part)
+		if err != nil {
+			return out.String(), err
+		}
+	}
+	return out.String(), nil
+}
+
+func TestJSONStreamValidator_ValidObject(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{"plain", []string{`{"a": [1, `, `true, null], "b": "x}"}`}, `{"a": [1, true, null], "b": "x}"}`},
+		{"leading whitespace", []string{"\n  ", `{"n": -1.5E3}`}, `{"n": -1.5E3}`},
+		{"code fence", []string{"``", "`json\n{\"ok\"", ": true}\n```"}, `{"ok": true}`},
+		{"trailing prose dropped", []string{`{"a": "b"} Hope this helps!`}, `{"a": "b"}`},
+		{"escaped quote", []string{`{"q": "say \"hi\" {"}`}, `{"q": "say \"hi\" {"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v JSONStreamValidator
+			got, err := feed(&v, tt.chunks...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !v.Complete() || got != tt.want {
+				t.Fatalf("got %q (complete=%v), want %q", got, v.Complete(), tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONStreamValidator_Drift(t *testing.T) {
+	tests := []struct {
+		name    string
+		chunks  []string
+		started bool
+	}{
+		{"prose before object", []string{"Sure! ", `{"a": 1}`}, false},
+		{"other code fence", []string{"```python\n{}"}, false},
+		{"prose inside object", []string{`{"a": 1, `, "here is the rest"}, true},
+		{"mismatched bracket", []string{`{"a": [1}`}, true},
+		{"invalid object", []string{`{"a" 1}`}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v JSONStreamValidator
+			if _, err := feed(&v, tt.chunks...); !errors.Is(err, ErrNotJSON) {
+				t.Fatalf("error = %v, want ErrNotJSON", err)
+			}
+			if v.Started() != tt.started {
+				t.Errorf("Started() = %v, want %v", v.Started(), tt.started)
+			}
+		})
+	}
+}
+
+func TestJSONStreamValidator_WaitsForMoreOutput(t *testing.T) {
+	var v JSONStreamValidator
+	got, err := feed(&v, "  ", "`", "``js")
+	if err != nil || got != "" || v.Started() {
+		t.Fatalf("got %q, err %v, started %v; want to wait for more output", got, err, v.Started())
+	}
+}
+
+func TestExtractJSONObject(t *testing.T) {
+	if got, ok := ExtractJSONObject("```json\n{\"a\": 1}\n```"); !ok || got != `{"a": 1}` {
+		t.Errorf("fenced object: got %q, %v", got, ok)
+	}
+	if _, ok := ExtractJSONObject(`{"a": `); ok {
+		t.Error("unclosed object should not be extracted")
+	}
+	if _, ok := ExtractJSONObject("I cannot answer in JSON."); ok {
+		t.Error("prose should not be extracted")
+	}
+}
+
+func TestCheckJSONObject(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		required []string
+		object   string
+		problems int
+	}{
+		{"valid", "```json\n{\"a\": 1, \"b\": null}\n```", []string{"a", "b"}, `{"a": 1, "b": null}`, 0},
+		{"missing field", `{"a": 1}`, []string{"a", "b", "c"}, `{"a": 1}`, 2},
+		{"prose", "Sure! Here it is.", []string{"a"}, "", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			object, problems := CheckJSONObject(tt.text, tt.required, nil)
+			if object != tt.object || len(problems) != tt.problems {
+				t.Errorf("got %q, %q; want %q and %d problems", object, problems, tt.object, tt.problems)
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrSchemaMismatch is returned when the output of a json_schema request is a JSON
+// object that does not match the schema
+var ErrSchemaMismatch = errors.New("model output does not match the JSON schema")
+
+// maxSchemaProblems caps the problems reported for one value; the first ones are
+// enough for a client error or a repair prompt
+const maxSchemaProblems = 10
+
+// maxSchemaDepth bounds $ref expansion of recursive schemas
+const maxSchemaDepth = 64
+
+// JSONSchema is a compiled JSON Schema. It supports the subset used by OpenAI
+// structured outputs: type, properties, required, additionalProperties, items,
+// enum, const, anyOf, allOf, oneOf, not, local $ref into $defs/definitions, string
+// length and pattern, numeric bounds and array length. Other keywords are ignored.
+type JSONSchema struct {
+	root     map[string]interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// CompileJSONSchema parses a schema and checks its types, patterns and references
+func CompileJSONSchema(raw json.RawMessage) (*JSONSchema, error) {
+	var root interface{}
+	if err := json.Unmarshal(raw, &root); err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+	}
+	obj, ok := root.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("schema must be a JSON object")
+	}
+	s := &JSONSchema{root: obj, patterns: make(map[string]*regexp.Regexp)}
+	if err := s.compile(obj, "#"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// compile walks a subschema, compiling its patterns and resolving its references
+func (s *JSONSchema) compile(node interface{}, at string) error {
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		if _, ok := node.(bool); ok {
+			return nil
+		}
+		return fmt.Errorf("%s: a schema must be an object or a boolean", at)
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		if _, err := s.resolve(ref); err != nil {
+			return fmt.Errorf("%s: %w", at, err)
+		}
+	}
+	for _, t := range schemaTypes(schema) {
+		switch t {
+		case "object", "array", "string", "number", "integer", "boolean", "null":
+		default:
+			return fmt.Errorf("%s: unknown type %q", at, t)
+		}
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid pattern: %w", at, err)
+		}
+		s.patterns[pattern] = re
+	}
+
+	for _, key := range []string{"properties", "$defs", "definitions"} {
+		children, _ := schema[key].(map[string]interface{})
+		for name, child := range children {
+			if err := s.compile(child, at+"/"+key+"/"+name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range []string{"items", "additionalProperties", "not"} {
+		if child, ok := schema[key]; ok {
+			if err := s.compile(child, at+"/"+key); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range []string{"anyOf", "allOf", "oneOf"} {
+		children, _ := schema[key].([]interface{})
+		for i, child := range children {
+			if err := s.compile(child, fmt.Sprintf("%s/%s/%d", at, key, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve looks up a local reference such as #/$defs/name
+func (s *JSONSchema) resolve(ref string) (interface{}, error) {
+	path, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, fmt.Errorf("only local references are supported, got %q", ref)
+	}
+	var node interface{} = s.root
+	for _, part := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		part = strings.NewReplacer("~1", "/", "~0", "~").Replace(part)
+		obj, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unresolvable reference %q", ref)
+		}
+		if node, ok = obj[part]; !ok {
+			return nil, fmt.Errorf("unresolvable reference %q", ref)
+		}
+	}
+	return node, nil
+}
+
+// Validate checks a decoded JSON value and returns the problems found, phrased to
+// be shown to the model when asking it to repair the answer
+func (s *JSONSchema) Validate(value interface{}) []string {
+	var problems []string
+	s.validate(s.root, value, "$", 0, &problems)
+	return problems
+}
+
+// validate appends the problems of value against a subschema
+func (s *JSONSchema) validate(node interface{}, value interface{}, path string, depth int, problems *[]string) {
+	if len(*problems) >= maxSchemaProblems {
+		return
+	}
+	report := func(format string, args ...interface{}) {
+		if len(*problems) < maxSchemaProblems {
+			*problems = append(*problems, fmt.Sprintf("At %s: %s.", path, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	if b, ok := node.(bool); ok {
+		if !b {
+			report("no value is allowed here")
+		}
+		return
+	}
+	schema, _ := node.(map[string]interface{})
+	if ref, ok := schema["$ref"].(string); ok {
+		if depth >= maxSchemaDepth {
+			report("the value is nested too deeply")
+			return
+		}
+		target, _ := s.resolve(ref)
+		s.validate(target, value, path, depth+1, problems)
+	}
+
+	if types := schemaTypes(schema); len(types) > 0 && !slices.ContainsFunc(types, func(t string) bool { return hasJSONType(value, t) }) {
+		report("expected %s, got %s", strings.Join(types, " or "), jsonTypeName(value))
+		return
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && !slices.ContainsFunc(enum, func(e interface{}) bool { return reflect.DeepEqual(e, value) }) {
+		report("the value must be one of %s", compactJSON(enum))
+	}
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, value) {
+		report("the value must be %s", compactJSON(c))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		s.validateObject(schema, v, path, depth, problems, report)
+	case []interface{}:
+		if n, ok := schemaNumber(schema, "minItems"); ok && float64(len(v)) < n {
+			report("expected at least %g items, got %d", n, len(v))
+		}
+		if n, ok := schemaNumber(schema, "maxItems"); ok && float64(len(v)) > n {
+			report("expected at most %g items, got %d", n, len(v))
+		}
+		if items, ok := schema["items"]; ok {
+			for i, item := range v {
+				s.validate(items, item, fmt.Sprintf("%s[%d]", path, i), depth, problems)
+			}
+		}
+	case string:
+		length := float64(len([]rune(v)))
+		if n, ok := schemaNumber(schema, "minLength"); ok && length < n {
+			report("expected at least %g characters, got %g", n, length)
+		}
+		if n, ok := schemaNumber(schema, "maxLength"); ok && length > n {
+			report("expected at most %g characters, got %g", n, length)
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !s.patterns[pattern].MatchString(v) {
+			report("the string must match the pattern %q", pattern)
+		}
+	case float64:
+		if n, ok := schemaNumber(schema, "minimum"); ok && v < n {
+			report("the number must be >= %g", n)
+		}
+		if n, ok := schemaNumber(schema, "maximum"); ok && v > n {
+			report("the number must be <= %g", n)
+		}
+		if n, ok := schemaNumber(schema, "exclusiveMinimum"); ok && v <= n {
+			report("the number must be > %g", n)
+		}
+		if n, ok := schemaNumber(schema, "exclusiveMaximum"); ok && v >= n {
+			report("the number must be < %g", n)
+		}
+	}
+
+	s.validateCombinators(schema, value, path, depth, problems, report)
+}
+
+// validateObject checks required fields, declared properties and additionalProperties
+func (s *JSONSchema) validateObject(schema, v map[string]interface{}, path string, depth int, problems *[]string, report func(string, ...interface{})) {
+	required, _ := schema["required"].([]interface{})
+	for _, name := range required {
+		if name, ok := name.(string); ok {
+			if _, ok := v[name]; !ok {
+				report("the object is missing the required field %q", name)
+			}
+		}
+	}
+	properties, _ := schema["properties"].(map[string]interface{})
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		child := path + "." + name
+		if prop, ok := properties[name]; ok {
+			s.validate(prop, v[name], child, depth, problems)
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				report("the field %q is not allowed", name)
+			}
+		case map[string]interface{}:
+			s.validate(extra, v[name], child, depth, problems)
+		}
+	}
+}
+
+// validateCombinators checks anyOf, allOf, oneOf and not
+func (s *JSONSchema) validateCombinators(schema map[string]interface{}, value interface{}, path string, depth int, problems *[]string, report func(string, ...interface{})) {
+	matches := func(node interface{}) bool {
+		var sub []string
+		s.validate(node, value, path, depth, &sub)
+		return len(sub) == 0
+	}
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, node := range all {
+			s.validate(node, value, path, depth, problems)
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok && !slices.ContainsFunc(anyOf, matches) {
+		report("the value matches none of the allowed schemas (anyOf)")
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		n := 0
+		for _, node := range oneOf {
+			if matches(node) {
+				n++
+			}
+		}
+		if n != 1 {
+			report("the value must match exactly one schema of oneOf, matched %d", n)
+		}
+	}
+	if not, ok := schema["not"]; ok && matches(not) {
+		report("the value matches a schema it must not match (not)")
+	}
+}
+
+// schemaTypes returns the type keyword as a list
+func schemaTypes(schema map[string]interface{}) []string {
+	switch t := schema["type"].(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		types := make([]string, 0, len(t))
+		for _, item := range t {
+			if name, ok := item.(string); ok {
+				types = append(types, name)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// schemaNumber returns a numeric keyword
+func schemaNumber(schema map[string]interface{}, key string) (float64, bool) {
+	n, ok := schema[key].(float64)
+	return n, ok
+}
+
+// hasJSONType reports whether a decoded value is of a JSON Schema type
+func hasJSONType(value interface{}, t string) bool {
+	switch v := value.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case string:
+		return t == "string"
+	case float64:
+		return t == "number" || t == "integer" && v == math.Trunc(v)
+	case []interface{}:
+		return t == "array"
+	case map[string]interface{}:
+		return t == "object"
+	}
+	return false
+}
+
+// jsonTypeName names the JSON type of a decoded value
+func jsonTypeName(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string " + strconv.Quote(truncateRunes(v, 40))
+	case float64:
+		return "number " + strconv.FormatFloat(v, 'g', -1, 64)
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// compactJSON formats a schema value for a problem message
+func compactJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return truncateRunes(string(data), 200)
+}
+
+// truncateRunes shortens s to at most n runes
+func truncateRunes(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"cursor2api/types"
+)
+
+const personSchema = `{
+	"type": "object",
+	"properties": {
+		"name": {"type": "string", "minLength": 1},
+		"age": {"type": "integer", "minimum": 0},
+		"role": {"enum": ["admin", "user"]},
+		"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2},
+		"address": {"$ref": "#/$defs/address"}
+	},
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"$defs": {
+		"address": {
+			"type": "object",
+			"properties": {"zip": {"type": "string", "pattern": "^[0-9]{5}$"}},
+			"required": ["zip"]
+		}
+	}
+}`
+
+func TestJSONSchema_Validate(t *testing.T) {
+	schema, err := CompileJSONSchema(json.RawMessage(personSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		want  []string // substrings of the expected problems, in order
+	}{
+		{"valid", `{"name": "Ada", "age": 36, "role": "admin", "tags": ["x"], "address": {"zip": "12345"}}`, nil},
+		{"missing required", `{"name": "Ada"}`, []string{`missing the required field "age"`}},
+		{"wrong type", `{"name": "Ada", "age": 36.5}`, []string{"At $.age: expected integer, got number 36.5"}},
+		{"extra field", `{"name": "Ada", "age": 1, "email": "a@b"}`, []string{`the field "email" is not allowed`}},
+		{"enum", `{"name": "Ada", "age": 1, "role": "root"}`, []string{`At $.role: the value must be one of ["admin","user"]`}},
+		{"array", `{"name": "Ada", "age": 1, "tags": ["a", 2, "c"]}`, []string{"expected at most 2 items", "At $.tags[1]: expected string"}},
+		{"ref and pattern", `{"name": "Ada", "age": 1, "address": {"zip": "abc"}}`, []string{"At $.address.zip: the string must match"}},
+		{"bounds", `{"name": "", "age": -1}`, []string{"At $.age: the number must be >= 0", "At $.name: expected at least 1 characters"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value interface{}
+			if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatal(err)
+			}
+			problems := schema.Validate(value)
+			if len(problems) != len(tt.want) {
+				t.Fatalf("problems = %q, want %d", problems, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(problems[i], want) {
+					t.Errorf("problem %d = %q, want it to contain %q", i, problems[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestJSONSchema_Combinators(t *testing.T) {
+	schema, err := CompileJSONSchema(json.RawMessage(`{
+		"anyOf": [{"type": "string"}, {"type": "null"}],
+		"not": {"const": "forbidden"}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for value, valid := range map[string]bool{`"ok"`: true, `null`: true, `1`: false, `"forbidden"`: false} {
+		var v interface{}
+		json.Unmarshal([]byte(value), &v)
+		if problems := schema.Validate(v); (len(problems) == 0) != valid {
+			t.Errorf("Validate(%s) = %q, want valid=%v", value, problems, valid)
+		}
+	}
+}
+
+func TestCompileJSONSchema_RejectsInvalid(t *testing.T) {
+	for name, raw := range map[string]string{
+		"not json":       `{"type": `,
+		"not an object":  `["object"]`,
+		"unknown type":   `{"type": "map"}`,
+		"dangling ref":   `{"properties": {"a": {"$ref": "#/$defs/missing"}}}`,
+		"remote ref":     `{"$ref": "https://example.com/schema.json"}`,
+		"invalid regexp": `{"type": "string", "pattern": "("}`,
+	} {
+		if _, err := CompileJSONSchema(json.RawMessage(raw)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestJSONSchema_RecursiveRef(t *testing.T) {
+	schema, err := CompileJSONSchema(json.RawMessage(`{
+		"type": "object",
+		"properties": {"children": {"type": "array", "items": {"$ref": "#"}}},
+		"required": ["children"]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v interface{}
+	json.Unmarshal([]byte(`{"children": [{"children": []}, {}]}`), &v)
+	problems := schema.Validate(v)
+	if len(problems) != 1 || !strings.Contains(problems[0], `At $.children[1]: the object is missing the required field "children"`) {
+		t.Errorf("problems = %q", problems)
+	}
+}
+
+func TestCheckJSONObject_Schema(t *testing.T) {
+	schema, err := CompileJSONSchema(json.RawMessage(personSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object, problems := CheckJSONObject("```json\n{\"name\": \"Ada\", \"age\": 3}\n```", nil, schema); len(problems) != 0 || object != `{"name": "Ada", "age": 3}` {
+		t.Errorf("valid answer: object=%q problems=%q", object, problems)
+	}
+	if object, problems := CheckJSONObject(`{"name": "Ada"}`, nil, schema); object == "" || len(problems) != 1 {
+		t.Errorf("mismatching answer: object=%q problems=%q", object, problems)
+	}
+}
+
+func TestWithJSONModeInstruction_Schema(t *testing.T) {
+	messages := []types.ChatMessage{{Role: "user", Content: "hi"}}
+	out := WithJSONModeInstruction(messages, json.RawMessage(`{"type":"object"}`), true)
+	if len(out) != 3 || out[0].Role != "system" || !strings.Contains(out[0].Content, `{"type":"object"}`) || out[2].Role != "user" {
+		t.Errorf("messages = %+v", out)
+	}
+	if out := WithJSONModeInstruction(messages, nil, false); len(out) != 2 || out[0].Content != jsonModeInstruction {
+		t.Errorf("without schema: %+v", out)
+	}
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"unicode"
+
+	"cursor2api/config"
+	"cursor2api/types"
+)
+
+// toolPrompt holds the two instructions injected into the system prompt when
+// tools are present: the tool definitions and the native tool-calling reminder
+type toolPrompt struct {
+	tools       string // Format string receiving the JSON array of tool definitions
+	instruction string
+}
+
+var toolPrompts = map[string]toolPrompt{
+	config.ToolPromptChinese: {
+		tools:       "你可用的工具: %s",
+		instruction: "不允许使用tool_calls: xxxx调用工具，请使用原生的工具调用方法",
+	},
+	config.ToolPromptEnglish: {
+		tools:       "Available tools: %s",
+		instruction: "Do not call tools by writing tool_calls: xxxx as text, use the native tool calling method",
+	},
+}
+
+// hanPerLatinLetters weighs a Han character against Latin letters: one Chinese
+// character carries about as much as a short English word
+const hanPerLatinLetters = 4
+
+// toolPromptFor picks the tool instructions for TOOL_PROMPT_LANGUAGE, detecting
+// the language of the conversation in auto mode
+func toolPromptFor(messages []types.ChatMessage) toolPrompt {
+	language := config.ToolPromptAuto
+	if config.GlobalConfig != nil {
+		language = config.GlobalConfig.Cursor.ToolPromptLanguage
+	}
+	if language != config.ToolPromptChinese && language != config.ToolPromptEnglish {
+		language = DetectLanguage(messages)
+	}
+	return toolPrompts[language]
+}
+
+// DetectLanguage returns the dominant language of the user messages, zh or en.
+// System prompts are only counted when there is no user text, since clients often
+// ship an English system prompt regardless of the language their users write in.
+// Conversations without any letters default to Chinese, the original instructions.
+func DetectLanguage(messages []types.ChatMessage) string {
+	han, latin := countScripts(messages, "user")
+	if han == 0 && latin == 0 {
+		han, latin = countScripts(messages, "")
+	}
+	if latin > 0 && han*hanPerLatinLetters < latin {
+		return config.ToolPromptEnglish
+	}
+	return config.ToolPromptChinese
+}
+
+// countScripts counts Han characters and Latin letters in messages of role ("" = all roles)
+func countScripts(messages []types.ChatMessage, role string) (han, latin int) {
+	for _, msg := range messages {
+		if role != "" && msg.Role != role {
+			continue
+		}
+		for _, r := range msg.Content {
+			switch {
+			case unicode.Is(unicode.Han, r):
+				han++
+			case r < unicode.MaxASCII && unicode.IsLetter(r):
+				latin++
+			}
+		}
+	}
+	return han, latin
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"testing"
+
+	"cursor2api/types"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []types.ChatMessage
+		want     string
+	}{
+		{"english", []types.ChatMessage{{Role: "user", Content: "List the files in the repo"}}, "en"},
+		{"chinese", []types.ChatMessage{{Role: "user", Content: "列出仓库里的文件"}}, "zh"},
+		{"chinese with code identifiers", []types.ChatMessage{{Role: "user", Content: "帮我修复 handleStreamingResponse 里的错误"}}, "zh"},
+		{"english system prompt ignored", []types.ChatMessage{
+			{Role: "system", Content: "You are a helpful coding agent working in a large repository."},
+			{Role: "user", Content: "今天天气怎么样"},
+		}, "zh"},
+		{"system prompt used without user text", []types.ChatMessage{{Role: "system", Content: "You are a helpful agent."}}, "en"},
+		{"no letters", []types.ChatMessage{{Role: "user", Content: "1 + 1 = ?"}}, "zh"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectLanguage(tt.messages); got != tt.want {
+				t.Errorf("DetectLanguage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
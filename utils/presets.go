@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"cursor2api/types"
+)
+
+// Preset holds generation defaults; zero fields are not applied
+type Preset struct {
+	Temperature  float64 `json:"temperature,omitempty"`
+	TopP         float64 `json:"top_p,omitempty"`
+	MaxTokens    int     `json:"max_tokens,omitempty"`
+	SystemPrompt string  `json:"system_prompt,omitempty"` // Used when the request has no system message
+}
+
+// PresetSet holds the named presets and their assignment to models and API keys
+type PresetSet struct {
+	Presets map[string]Preset `json:"presets"`
+	Models  map[string]string `json:"models,omitempty"` // model -> preset name
+	Keys    map[string]string `json:"keys,omitempty"`   // api_key -> preset name, takes precedence over the model
+}
+
+// LoadPresets reads the presets file; returns nil when path is empty
+func LoadPresets(path string) (*PresetSet, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var set PresetSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse presets: %w", err)
+	}
+	for model, name := range set.Models {
+		if _, ok := set.Presets[name]; !ok {
+			return nil, fmt.Errorf("model %s uses unknown preset %q", model, name)
+		}
+	}
+	for _, name := range set.Keys {
+		if _, ok := set.Presets[name]; !ok {
+			return nil, fmt.Errorf("an API key uses unknown preset %q", name)
+		}
+	}
+	return &set, nil
+}
+
+// Len returns the number of presets
+func (s *PresetSet) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.Presets)
+}
+
+// Apply fills the fields the request leaves unset from the preset of the API key or,
+// failing that, of the model. Returns the name of the applied preset, or "".
+func (s *PresetSet) Apply(req *types.ChatCompletionRequest, apiKey string) string {
+	if s == nil {
+		return ""
+	}
+	name, ok := s.Keys[apiKey]
+	if !ok {
+		name, ok = s.Models[req.Model]
+	}
+	if !ok {
+		return ""
+	}
+
+	p := s.Presets[name]
+	if req.Temperature == 0 {
+		req.Temperature = p.Temperature
+	}
+	if req.TopP == 0 {
+		req.TopP = p.TopP
+	}
+	if req.MaxTokens == 0 {
+		req.MaxTokens = p.MaxTokens
+	}
+	if p.SystemPrompt != "" && !hasSystemMessage(req.Messages) {
+		messages := make([]types.ChatMessage, 0, len(req.Messages)+1)
+		messages = append(messages, types.ChatMessage{Role: "system", Content: p.SystemPrompt})
+		req.Messages = append(messages, req.Messages...)
+	}
+	return name
+}
+
+// hasSystemMessage reports whether the client sent its own system prompt
+func hasSystemMessage(messages []types.ChatMessage) bool {
+	for _, m := range messages {
+		if m.Role == "system" {
+			return true
+		}
+	}
+	return false
+}
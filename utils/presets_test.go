@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cursor2api/types"
+)
+
+func loadTestPresets(t *testing.T, data string) (*PresetSet, error) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "presets.json")
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return LoadPresets(file)
+}
+
+func TestPresetSet_Apply(t *testing.T) {
+	set, err := loadTestPresets(t, `{
+		"presets": {
+			"precise": {"temperature": 0.2, "top_p": 0.9, "max_tokens": 1024, "system_prompt": "Be precise."},
+			"creative": {"temperature": 1.1}
+		},
+		"models": {"gpt-4o": "precise"},
+		"keys": {"sk-creative": "creative"}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := types.ChatCompletionRequest{
+		Model:     "gpt-4o",
+		MaxTokens: 256,
+		Messages:  []types.ChatMessage{{Role: "user", Content: "hi"}},
+	}
+	if name := set.Apply(&req, "sk-other"); name != "precise" {
+		t.Fatalf("applied preset %q, want precise", name)
+	}
+	if req.Temperature != 0.2 || req.TopP != 0.9 {
+		t.Errorf("temperature/top_p = %v/%v, want preset values", req.Temperature, req.TopP)
+	}
+	if req.MaxTokens != 256 {
+		t.Errorf("max_tokens = %d, the request's own value must win", req.MaxTokens)
+	}
+	if len(req.Messages) != 2 || req.Messages[0].Content != "Be precise." {
+		t.Errorf("preset system prompt not prepended: %+v", req.Messages)
+	}
+
+	// The key's preset takes precedence over the model's
+	req = types.ChatCompletionRequest{Model: "gpt-4o", Messages: []types.ChatMessage{{Role: "user", Content: "hi"}}}
+	if name := set.Apply(&req, "sk-creative"); name != "creative" || req.Temperature != 1.1 || req.MaxTokens != 0 {
+		t.Errorf("key preset: name %q, temperature %v, max_tokens %d", name, req.Temperature, req.MaxTokens)
+	}
+
+	// A client system message suppresses the preset system prompt
+	req = types.ChatCompletionRequest{Model: "gpt-4o", Messages: []types.ChatMessage{{Role: "system", Content: "Mine."}, {Role: "user", Content: "hi"}}}
+	set.Apply(&req, "")
+	if len(req.Messages) != 2 || req.Messages[0].Content != "Mine." {
+		t.Errorf("client system prompt must be kept: %+v", req.Messages)
+	}
+
+	// Unassigned models are unchanged
+	req = types.ChatCompletionRequest{Model: "other"}
+	if name := set.Apply(&req, ""); name != "" || req.Temperature != 0 {
+		t.Errorf("unassigned model got preset %q", name)
+	}
+}
+
+func TestLoadPresets_UnknownPreset(t *testing.T) {
+	_, err := loadTestPresets(t, `{"presets": {}, "models": {"gpt-4o": "missing"}}`)
+	if err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("error = %v, want unknown preset error", err)
+	}
+}
+
+func TestPresetSet_NilIsNoop(t *testing.T) {
+	var set *PresetSet
+	req := types.ChatCompletionRequest{Model: "gpt-4o"}
+	if name := set.Apply(&req, "key"); name != "" || set.Len() != 0 {
+		t.Error("nil preset set must not apply anything")
+	}
+}
@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+
+	"cursor2api/types"
+)
+
+var (
+	// ErrPromptNotFound is returned when a request names an unknown prompt_id
+	ErrPromptNotFound = errors.New("prompt template not found")
+	// ErrPromptVariables is returned when a template variable has no value
+	ErrPromptVariables = errors.New("missing prompt template variables")
+)
+
+// promptVariable matches {{name}} placeholders
+var promptVariable = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// PromptTemplate is a named server-side prompt. Its messages are placed before the
+// client's messages; {{name}} placeholders are replaced with the request variables.
+type PromptTemplate struct {
+	Description string              `json:"description,omitempty"`
+	Messages    []types.ChatMessage `json:"messages"`
+	Defaults    map[string]string   `json:"defaults,omitempty"` // Values of variables the client may omit
+}
+
+// variables lists the placeholders used by the template
+func (t PromptTemplate) variables() []string {
+	var names []string
+	for _, m := range t.Messages {
+		for _, match := range promptVariable.FindAllStringSubmatch(m.Content, -1) {
+			if !slices.Contains(names, match[1]) {
+				names = append(names, match[1])
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PromptInfo describes a template for the admin API
+type PromptInfo struct {
+	ID          string            `json:"id"`
+	Description string            `json:"description,omitempty"`
+	Messages    int               `json:"messages"`
+	Variables   []string          `json:"variables"`
+	Defaults    map[string]string `json:"defaults,omitempty"`
+}
+
+// PromptLibrary holds the named prompt templates
+type PromptLibrary struct {
+	templates map[string]PromptTemplate
+}
+
+// LoadPromptLibrary reads a JSON object of prompt_id -> template; returns nil when path is empty
+func LoadPromptLibrary(path string) (*PromptLibrary, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var templates map[string]PromptTemplate
+	if err := json.Unmarshal(data, &templates); err != nil {
+		return nil, fmt.Errorf("failed to parse prompt templates: %w", err)
+	}
+	for id, t := range templates {
+		if len(t.Messages) == 0 {
+			return nil, fmt.Errorf("prompt template %q has no messages", id)
+		}
+	}
+	return &PromptLibrary{templates: templates}, nil
+}
+
+// Len returns the number of templates
+func (l *PromptLibrary) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.templates)
+}
+
+// List describes all templates sorted by ID
+func (l *PromptLibrary) List() []PromptInfo {
+	list := make([]PromptInfo, 0, l.Len())
+	if l == nil {
+		return list
+	}
+	for id, t := range l.templates {
+		list = append(list, PromptInfo{
+			ID:          id,
+			Description: t.Description,
+			Messages:    len(t.Messages),
+			Variables:   t.variables(),
+			Defaults:    t.Defaults,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Expand replaces the request's prompt_id with the template messages, placed before
+// the client's own messages. prompt_id and variables are cleared so the request can
+// be forwarded as a plain chat completion. Requests without prompt_id are unchanged.
+func (l *PromptLibrary) Expand(req *types.ChatCompletionRequest) error {
+	if req.PromptID == "" {
+		return nil
+	}
+	var (
+		t  PromptTemplate
+		ok bool
+	)
+	if l != nil {
+		t, ok = l.templates[req.PromptID]
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrPromptNotFound, req.PromptID)
+	}
+
+	var missing []string
+	for _, name := range t.variables() {
+		if _, ok := req.Variables[name]; ok {
+			continue
+		}
+		if _, ok := t.Defaults[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %v", ErrPromptVariables, missing)
+	}
+
+	messages := make([]types.ChatMessage, 0, len(t.Messages)+len(req.Messages))
+	for _, m := range t.Messages {
+		m.Content = promptVariable.ReplaceAllStringFunc(m.Content, func(placeholder string) string {
+			name := promptVariable.FindStringSubmatch(placeholder)[1]
+			if value, ok := req.Variables[name]; ok {
+				return value
+			}
+			return t.Defaults[name]
+		})
+		messages = append(messages, m)
+	}
+	req.Messages = append(messages, req.Messages...)
+	req.PromptID = ""
+	req.Variables = nil
+	return nil
+}
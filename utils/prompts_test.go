@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cursor2api/types"
+)
+
+func loadTestLibrary(t *testing.T) *PromptLibrary {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "prompts.json")
+	data := `{
+		"summarize": {
+			"description": "Summarize a document",
+			"messages": [
+				{"role": "system", "content": "Summarize in {{ language }}, at most {{max_words}} words."},
+				{"role": "user", "content": "{{document}}"}
+			],
+			"defaults": {"language": "English"}
+		}
+	}`
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	lib, err := LoadPromptLibrary(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lib
+}
+
+func TestPromptLibrary_Expand(t *testing.T) {
+	lib := loadTestLibrary(t)
+	req := types.ChatCompletionRequest{
+		PromptID:  "summarize",
+		Variables: map[string]string{"max_words": "50", "document": "Go is a language."},
+		Messages:  []types.ChatMessage{{Role: "user", Content: "Focus on the syntax."}},
+	}
+
+	if err := lib.Expand(&req); err != nil {
+		t.Fatal(err)
+	}
+	want := []types.ChatMessage{
+		{Role: "system", Content: "Summarize in English, at most 50 words."},
+		{Role: "user", Content: "Go is a language."},
+		{Role: "user", Content: "Focus on the syntax."},
+	}
+	if len(req.Messages) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(req.Messages), len(want))
+	}
+	for i := range want {
+		if req.Messages[i].Role != want[i].Role || req.Messages[i].Content != want[i].Content {
+			t.Fatalf("message %d = %+v, want %+v", i, req.Messages[i], want[i])
+		}
+	}
+	if req.PromptID != "" || req.Variables != nil {
+		t.Fatal("prompt_id and variables must be cleared after expansion")
+	}
+
+	// The template itself must not be modified
+	if got := lib.templates["summarize"].Messages[0].Content; got != "Summarize in {{ language }}, at most {{max_words}} words." {
+		t.Fatalf("template modified: %q", got)
+	}
+}
+
+func TestPromptLibrary_ExpandErrors(t *testing.T) {
+	lib := loadTestLibrary(t)
+
+	req := types.ChatCompletionRequest{PromptID: "summarize", Variables: map[string]string{"document": "x"}}
+	if err := lib.Expand(&req); !errors.Is(err, ErrPromptVariables) {
+		t.Fatalf("missing variable: err = %v", err)
+	}
+
+	req = types.ChatCompletionRequest{PromptID: "unknown"}
+	if err := lib.Expand(&req); !errors.Is(err, ErrPromptNotFound) {
+		t.Fatalf("unknown prompt: err = %v", err)
+	}
+
+	var none *PromptLibrary
+	if err := none.Expand(&types.ChatCompletionRequest{PromptID: "summarize"}); !errors.Is(err, ErrPromptNotFound) {
+		t.Fatalf("no library: err = %v", err)
+	}
+}
+
+func TestPromptLibrary_List(t *testing.T) {
+	list := loadTestLibrary(t).List()
+	if len(list) != 1 || list[0].ID != "summarize" {
+		t.Fatalf("list = %+v", list)
+	}
+	if got := list[0].Variables; len(got) != 3 || got[0] != "document" || got[1] != "language" || got[2] != "max_words" {
+		t.Fatalf("variables = %v", got)
+	}
+}
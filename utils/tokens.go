@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"unicode/utf8"
+
+	"cursor2api/tee"
+)
+
+// bytesPerToken is the ratio behind estimateTokens
+const bytesPerToken = 3
+
+// estimateTokens is the shared heuristic behind EstimateTokens and TokenCounter
+// Rough estimation: 1 token ≈ 4 characters for English, 1 token ≈ 2 characters for Chinese
+func estimateTokens(byteLen int) int {
+	return byteLen / bytesPerToken
+}
+
+// TokenCounter incrementally estimates the tokens of streamed text without retaining it
+// It implements tee.Sink and yields the same result as EstimateTokens on the concatenated text.
+type TokenCounter struct {
+	bytes int
+}
+
+// WriteChunk counts chunk
+func (c *TokenCounter) WriteChunk(chunk string) error {
+	c.bytes += len(chunk)
+	return nil
+}
+
+// Close implements tee.Sink
+func (c *TokenCounter) Close(tee.Outcome) error { return nil }
+
+// Bytes returns the number of bytes counted so far
+func (c *TokenCounter) Bytes() int {
+	return c.bytes
+}
+
+// Tokens returns the estimated token count so far
+func (c *TokenCounter) Tokens() int {
+	return estimateTokens(c.bytes)
+}
+
+// TokenLimiter cuts generated text off once it reaches max_tokens, measured with the
+// same estimate as TokenCounter so the reported completion tokens never exceed the
+// limit. A nil *TokenLimiter passes text through.
+type TokenLimiter struct {
+	remaining int // Bytes that may still be let through
+	reached   bool
+}
+
+// NewTokenLimiter returns a limiter for maxTokens, nil when maxTokens is not positive
+func NewTokenLimiter(maxTokens int) *TokenLimiter {
+	if maxTokens <= 0 {
+		return nil
+	}
+	return &TokenLimiter{remaining: maxTokens * bytesPerToken}
+}
+
+// Write returns the part of chunk within the limit, cut at a rune boundary; text
+// after the limit was reached is discarded
+func (l *TokenLimiter) Write(chunk string) string {
+	if l == nil {
+		return chunk
+	}
+	if l.reached {
+		return ""
+	}
+	if len(chunk) <= l.remaining {
+		l.remaining -= len(chunk)
+		return chunk
+	}
+	cut := l.remaining
+	for cut > 0 && !utf8.RuneStart(chunk[cut]) {
+		cut--
+	}
+	l.remaining, l.reached = 0, true
+	return chunk[:cut]
+}
+
+// Reached reports whether text was cut off; the completion ends with finish_reason "length"
+func (l *TokenLimiter) Reached() bool {
+	return l != nil && l.reached
+}
+
+// LimitTokens cuts a complete text off at maxTokens (0 = unlimited) and reports whether it was cut
+func LimitTokens(text string, maxTokens int) (string, bool) {
+	l := NewTokenLimiter(maxTokens)
+	text = l.Write(text)
+	return text, l.Reached()
+}
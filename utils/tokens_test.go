@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenLimiter(t *testing.T) {
+	l := NewTokenLimiter(3) // 9 bytes
+	if got := l.Write("abcd"); got != "abcd" || l.Reached() {
+		t.Fatalf("first chunk = %q, reached = %v", got, l.Reached())
+	}
+	// "xéé" is 5 bytes and exactly fills the limit
+	if got := l.Write("xéé"); got != "xéé" {
+		t.Fatalf("second chunk = %q", got)
+	}
+	if got := l.Write("世界"); got != "" || !l.Reached() {
+		t.Errorf("chunk over the limit = %q, reached = %v", got, l.Reached())
+	}
+	if got := l.Write("more"); got != "" {
+		t.Errorf("text after the limit = %q, want it discarded", got)
+	}
+}
+
+func TestTokenLimiter_CutsAtRuneBoundary(t *testing.T) {
+	l := NewTokenLimiter(2) // 6 bytes
+	got := l.Write("ab世界") // 2 + 3 + 3 bytes
+	if got != "ab世" || !l.Reached() {
+		t.Errorf("got %q, reached = %v", got, l.Reached())
+	}
+	var counter TokenCounter
+	counter.WriteChunk(got)
+	if counter.Tokens() > 2 {
+		t.Errorf("counted %d tokens, want at most the limit", counter.Tokens())
+	}
+}
+
+func TestLimitTokens(t *testing.T) {
+	text := strings.Repeat("word ", 10)
+	if out, cut := LimitTokens(text, 0); out != text || cut {
+		t.Errorf("unlimited: %q, %v", out, cut)
+	}
+	if out, cut := LimitTokens(text, 4); len(out) != 12 || !cut {
+		t.Errorf("limited: %q, %v", out, cut)
+	}
+	var nilLimiter *TokenLimiter
+	if nilLimiter.Write("x") != "x" || nilLimiter.Reached() {
+		t.Error("a nil limiter must pass text through")
+	}
+}
@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"cursor2api/config"
+	"cursor2api/types"
+)
+
+// ErrToolsTooLarge is returned when tool definitions exceed TOOLS_MAX_JSON_BYTES and the
+// oversize strategy rejects them
+var ErrToolsTooLarge = errors.New("tool definitions are too large")
+
+// ToolFit reports how tool definitions were fitted into the size limit
+type ToolFit struct {
+	OriginalBytes int      // Injected JSON size of the definitions as sent by the client
+	Bytes         int      // Injected JSON size after the applied steps
+	Applied       []string // Strategy steps that were applied, in order
+	Oversized     bool     // Whether the definitions exceeded the limit
+}
+
+// Fits reports whether the definitions are within the limit after the applied steps
+func (f ToolFit) Fits(limit int) bool {
+	return limit <= 0 || f.Bytes <= limit
+}
+
+// toolsPromptJSON serializes tools exactly as they are injected into the system prompt:
+// a JSON array of per-tool JSON strings (matching Python's model_dump_json list)
+func toolsPromptJSON(tools []types.Tool) ([]byte, error) {
+	toolJSONStrings := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		toolJSON, err := json.Marshal(tool)
+		if err != nil {
+			return nil, fmt.Errorf("marshal tool %s: %w", tool.Function.Name, err)
+		}
+		toolJSONStrings = append(toolJSONStrings, string(toolJSON))
+	}
+	return json.Marshal(toolJSONStrings)
+}
+
+// ToolsJSONSize returns the size in bytes of tools as injected into the system prompt
+func ToolsJSONSize(tools []types.Tool) int {
+	data, err := toolsPromptJSON(tools)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// FitTools applies the oversize strategy steps in order until the injected tool JSON is
+// at most limit bytes. The client's definitions are never modified; shrunk copies are
+// returned. When a reject step is reached while still too large, ErrToolsTooLarge is
+// returned; when the steps run out, the shrunk definitions are returned over the limit.
+func FitTools(tools []types.Tool, limit int, strategy []string, descriptionMax int) ([]types.Tool, ToolFit, error) {
+	size := ToolsJSONSize(tools)
+	fit := ToolFit{OriginalBytes: size, Bytes: size}
+	if limit <= 0 || size <= limit {
+		return tools, fit, nil
+	}
+	fit.Oversized = true
+
+	fitted := cloneTools(tools)
+	for _, step := range strategy {
+		if fit.Fits(limit) {
+			break
+		}
+		switch step {
+		case config.ToolsDropExamples:
+			for i := range fitted {
+				walkSchema(fitted[i].Function.Parameters, func(schema map[string]interface{}) {
+					delete(schema, "example")
+					delete(schema, "examples")
+				})
+			}
+		case config.ToolsTruncateDescriptions:
+			for i := range fitted {
+				fitted[i].Function.Description = truncateRunes(fitted[i].Function.Description, descriptionMax)
+				walkSchema(fitted[i].Function.Parameters, func(schema map[string]interface{}) {
+					if description, ok := schema["description"].(string); ok {
+						schema["description"] = truncateRunes(description, descriptionMax)
+					}
+				})
+			}
+		case config.ToolsReject:
+			return nil, fit, fmt.Errorf("%w: %d bytes of tool definitions exceed the limit of %d bytes", ErrToolsTooLarge, fit.Bytes, limit)
+		default:
+			continue
+		}
+		fit.Applied = append(fit.Applied, step)
+		fit.Bytes = ToolsJSONSize(fitted)
+	}
+	return fitted, fit, nil
+}
+
+// cloneTools deep-copies tool definitions so their parameter schemas can be edited
+func cloneTools(tools []types.Tool) []types.Tool {
+	cloned := make([]types.Tool, len(tools))
+	for i, tool := range tools {
+		cloned[i] = tool
+		if tool.Function.Parameters == nil {
+			continue
+		}
+		data, err := json.Marshal(tool.Function.Parameters)
+		if err != nil {
+			continue
+		}
+		var params map[string]interface{}
+		if json.Unmarshal(data, &params) == nil {
+			cloned[i].Function.Parameters = params
+		}
+	}
+	return cloned
+}
+
+// walkSchema calls fn on a JSON Schema and each of its subschemas. Only keyword
+// positions are visited, so a property named "examples" or "description" is kept.
+func walkSchema(node interface{}, fn func(map[string]interface{})) {
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		return
+	}
+	fn(schema)
+	for _, key := range []string{"properties", "$defs", "definitions", "patternProperties"} {
+		children, _ := schema[key].(map[string]interface{})
+		for _, child := range children {
+			walkSchema(child, fn)
+		}
+	}
+	for _, key := range []string{"items", "additionalProperties", "not"} {
+		walkSchema(schema[key], fn)
+	}
+	for _, key := range []string{"anyOf", "allOf", "oneOf", "prefixItems"} {
+		children, _ := schema[key].([]interface{})
+		for _, child := range children {
+			walkSchema(child, fn)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"cursor2api/config"
+	"cursor2api/types"
+)
+
+// bigTool returns a tool with long descriptions and examples in its schema
+func bigTool() types.Tool {
+	long := strings.Repeat("very detailed explanation ", 40)
+	return types.Tool{Type: "function", Function: types.FunctionDef{
+		Name:        "search",
+		Description: long,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query": map[string]interface{}{
+					"type":        "string",
+					"description": long,
+					"examples":    []interface{}{long, long},
+				},
+				// A property named like a keyword must survive
+				"examples": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string", "example": long}},
+			},
+		},
+	}}
+}
+
+var allSteps = []string{config.ToolsDropExamples, config.ToolsTruncateDescriptions, config.ToolsReject}
+
+func TestFitTools_WithinLimit(t *testing.T) {
+	tools := []types.Tool{bigTool()}
+	fitted, fit, err := FitTools(tools, 1<<20, allSteps, 50)
+	if err != nil || fit.Oversized || len(fit.Applied) != 0 || &fitted[0] != &tools[0] {
+		t.Errorf("fit = %+v, err = %v, want the tools unchanged", fit, err)
+	}
+}
+
+func TestFitTools_DropsExamplesFirst(t *testing.T) {
+	tools := []types.Tool{bigTool()}
+	size := ToolsJSONSize(tools)
+	// Dropping the examples (three long strings) is enough for this limit
+	fitted, fit, err := FitTools(tools, size/2, allSteps, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fit.Applied) != 1 || fit.Applied[0] != config.ToolsDropExamples || !fit.Fits(size/2) {
+		t.Fatalf("fit = %+v", fit)
+	}
+	props := fitted[0].Function.Parameters["properties"].(map[string]interface{})
+	if _, ok := props["query"].(map[string]interface{})["examples"]; ok {
+		t.Error("examples keyword was kept")
+	}
+	if _, ok := props["examples"]; !ok {
+		t.Error("the property named examples was dropped")
+	}
+	// The client's definitions are not modified
+	original := tools[0].Function.Parameters["properties"].(map[string]interface{})
+	if _, ok := original["query"].(map[string]interface{})["examples"]; !ok {
+		t.Error("FitTools modified the original tools")
+	}
+}
+
+func TestFitTools_TruncatesDescriptions(t *testing.T) {
+	tools := []types.Tool{bigTool()}
+	limit := 600
+	fitted, fit, err := FitTools(tools, limit, allSteps[:2], 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fit.Applied) != 2 || !fit.Fits(limit) {
+		t.Fatalf("fit = %+v, want both steps and a fitting size", fit)
+	}
+	if n := len([]rune(fitted[0].Function.Description)); n > 51 {
+		t.Errorf("description has %d runes, want at most 50 plus the ellipsis", n)
+	}
+}
+
+func TestFitTools_Rejects(t *testing.T) {
+	tools := []types.Tool{bigTool()}
+	_, fit, err := FitTools(tools, 100, allSteps, 50)
+	if !errors.Is(err, ErrToolsTooLarge) {
+		t.Fatalf("err = %v, want ErrToolsTooLarge", err)
+	}
+	if len(fit.Applied) != 2 {
+		t.Errorf("applied = %v, want both shrinking steps before rejecting", fit.Applied)
+	}
+
+	// Without a reject step the shrunk tools are returned over the limit
+	fitted, fit, err := FitTools(tools, 100, allSteps[:2], 50)
+	if err != nil || fit.Fits(100) || len(fitted) != 1 {
+		t.Errorf("fit = %+v, err = %v, want the shrunk tools over the limit", fit, err)
+	}
+}